@@ -12,6 +12,11 @@ type apiResponseDto struct {
 	CreatedAt        datatype.DateTime          `json:"createdAt"`
 	Permissions      []apiPermissionResponseDto `json:"permissions"`
 	AllowCIMDClients bool                       `json:"allowCimdClients"`
+
+	AccessTokenSigningAlg    string                                  `json:"accessTokenSigningAlg"`
+	IntrospectionJWTRequired bool                                    `json:"introspectionJwtRequired"`
+	ResourceServerClientID   *string                                 `json:"resourceServerClientId"`
+	AuthorizationDetailTypes []apiAuthorizationDetailTypeResponseDto `json:"authorizationDetailTypes"`
 }
 
 type apiPermissionResponseDto struct {
@@ -22,17 +27,30 @@ type apiPermissionResponseDto struct {
 	AllowedForCIMDClients bool    `json:"allowedForCimdClients"`
 }
 
+type apiAuthorizationDetailTypeResponseDto struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+}
+
 // apiCreateDto is the payload for creating an API
 // The resource identifier is only accepted here because changing it later would invalidate every token already minted for the API
 type apiCreateDto struct {
-	Name     string `json:"name" binding:"required,min=1,max=50" unorm:"nfc"`
-	Resource string `json:"resource" binding:"required,resource_uri,max=350" unorm:"nfc"`
+	Name                     string  `json:"name" binding:"required,min=1,max=50" unorm:"nfc"`
+	Resource                 string  `json:"resource" binding:"required,resource_uri,max=350" unorm:"nfc"`
+	AccessTokenSigningAlg    string  `json:"accessTokenSigningAlg" binding:"omitempty,oneof=RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512 EdDSA"`
+	IntrospectionJWTRequired bool    `json:"introspectionJwtRequired"`
+	ResourceServerClientID   *string `json:"resourceServerClientId"`
 }
 
 // apiUpdateDto is the payload for updating an API
 // The resource identifier is intentionally not updatable
 type apiUpdateDto struct {
-	Name string `json:"name" binding:"required,min=1,max=50" unorm:"nfc"`
+	Name                     string  `json:"name" binding:"required,min=1,max=50" unorm:"nfc"`
+	AccessTokenSigningAlg    string  `json:"accessTokenSigningAlg" binding:"omitempty,oneof=RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512 EdDSA"`
+	IntrospectionJWTRequired bool    `json:"introspectionJwtRequired"`
+	ResourceServerClientID   *string `json:"resourceServerClientId"`
 }
 
 type apiPermissionInputDto struct {
@@ -46,6 +64,17 @@ type apiPermissionsUpdateDto struct {
 	Permissions []apiPermissionInputDto `json:"permissions" binding:"omitempty,dive"`
 }
 
+type apiAuthorizationDetailTypeInputDto struct {
+	Type        string  `json:"type" binding:"required,min=1,max=128" unorm:"nfc"`
+	Name        string  `json:"name" binding:"required,min=1,max=50" unorm:"nfc"`
+	Description *string `json:"description" binding:"omitempty,max=200"`
+}
+
+// apiAuthorizationDetailTypesUpdateDto replaces the full set of RFC 9396 authorization details types an API accepts
+type apiAuthorizationDetailTypesUpdateDto struct {
+	Types []apiAuthorizationDetailTypeInputDto `json:"types" binding:"omitempty,dive"`
+}
+
 // apiCimdAccessUpdateDto replaces which permissions of an API every CIMD client may request
 // The permission IDs are kept while Enabled is false, so switching access off and on again preserves the selection
 type apiCimdAccessUpdateDto struct {
@@ -57,15 +86,19 @@ type apiCimdAccessUpdateDto struct {
 type apiClientGrantDto struct {
 	UserDelegatedAccess        bool     `json:"userDelegatedAccess"`
 	ClientAccess               bool     `json:"clientAccess"`
+	TokenExchangeAccess        bool     `json:"tokenExchangeAccess"`
 	UserDelegatedPermissionIDs []string `json:"userDelegatedPermissionIds"`
 	ClientPermissionIDs        []string `json:"clientPermissionIds"`
+	TokenExchangePermissionIDs []string `json:"tokenExchangePermissionIds"`
 }
 
 type apiClientGrantUpdateDto struct {
 	UserDelegatedAccess        bool     `json:"userDelegatedAccess"`
 	ClientAccess               bool     `json:"clientAccess"`
+	TokenExchangeAccess        bool     `json:"tokenExchangeAccess"`
 	UserDelegatedPermissionIDs []string `json:"userDelegatedPermissionIds" binding:"omitempty,dive,required"`
 	ClientPermissionIDs        []string `json:"clientPermissionIds" binding:"omitempty,dive,required"`
+	TokenExchangePermissionIDs []string `json:"tokenExchangePermissionIds" binding:"omitempty,dive,required"`
 }
 
 // apiClientAccessDto is one client's grants on a single API, as listed on the API's detail page
@@ -94,3 +127,13 @@ type apiClientDto struct {
 	HasLogo     bool   `json:"hasLogo"`
 	HasDarkLogo bool   `json:"hasDarkLogo"`
 }
+
+// protectedResourceMetadataDto is the OAuth 2.0 Protected Resource Metadata of an API, see RFC 9728 section 2
+type protectedResourceMetadataDto struct {
+	Resource                           string   `json:"resource"`
+	ResourceName                       string   `json:"resource_name"`
+	AuthorizationServers               []string `json:"authorization_servers"`
+	ScopesSupported                    []string `json:"scopes_supported"`
+	BearerMethodsSupported             []string `json:"bearer_methods_supported"`
+	AuthorizationDetailsTypesSupported []string `json:"authorization_details_types_supported,omitempty"`
+}
@@ -74,6 +74,24 @@ func (h *handler) get(c *gin.Context) error {
 	return nil
 }
 
+// getProtectedResourceMetadata godoc
+// @Summary Get the protected resource metadata of an API
+// @Description Get the OAuth 2.0 Protected Resource Metadata (RFC 9728) of an API, which tells clients the authorization server and scopes to use for it
+// @Tags APIs
+// @Produce json
+// @Param id path string true "API ID"
+// @Success 200 {object} protectedResourceMetadataDto
+// @Router /.well-known/oauth-protected-resource/apis/{id} [get]
+func (h *handler) getProtectedResourceMetadata(c *gin.Context) error {
+	metadata, err := h.service.ProtectedResourceMetadata(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, metadata)
+	return nil
+}
+
 // create godoc
 // @Summary Create API
 // @Description Create a new API resource server
@@ -179,6 +197,36 @@ func (h *handler) updatePermissions(c *gin.Context) error {
 	return nil
 }
 
+// updateAuthorizationDetailTypes godoc
+// @Summary Update API authorization details types
+// @Description Replace the full set of RFC 9396 authorization details types an API accepts
+// @Tags APIs
+// @Accept json
+// @Produce json
+// @Param id path string true "API ID"
+// @Param types body apiAuthorizationDetailTypesUpdateDto true "Authorization details types to set"
+// @Success 200 {object} apiResponseDto "Updated API"
+// @Router /api/apis/{id}/authorization-detail-types [put]
+func (h *handler) updateAuthorizationDetailTypes(c *gin.Context) error {
+	var input apiAuthorizationDetailTypesUpdateDto
+	if err := httpserver.BindJSON(c, &input); err != nil {
+		return err
+	}
+
+	api, err := h.service.UpdateAuthorizationDetailTypes(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	var responseDto apiResponseDto
+	if err := dto.MapStruct(api, &responseDto); err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, responseDto)
+	return nil
+}
+
 // updateCimdAccess godoc
 // @Summary Update metadata document client access
 // @Description Replace which permissions of an API every client registered through a Client ID Metadata Document may request
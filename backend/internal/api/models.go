@@ -13,8 +13,15 @@ type API struct {
 	Audience         string `sortable:"true"`
 	UpdatedAt        *datatype.DateTime
 	AllowCIMDClients bool `gorm:"column:allow_cimd_clients"`
+	// AccessTokenSigningAlg is the algorithm the API's access tokens are signed with, empty for the algorithm of the default signing key
+	AccessTokenSigningAlg string
+	// IntrospectionJWTRequired makes the introspection responses for the API's tokens always signed JWTs, see RFC 9701
+	IntrospectionJWTRequired bool `gorm:"column:introspection_jwt_required"`
+	// ResourceServerClientID is the client the API authenticates with to introspect the access tokens audienced to it
+	ResourceServerClientID *string
 
-	Permissions []Permission `gorm:"foreignKey:APIID;references:ID;constraint:OnDelete:CASCADE"`
+	Permissions              []Permission              `gorm:"foreignKey:APIID;references:ID;constraint:OnDelete:CASCADE"`
+	AuthorizationDetailTypes []AuthorizationDetailType `gorm:"foreignKey:APIID;references:ID;constraint:OnDelete:CASCADE"`
 }
 
 type Permission struct {
@@ -29,6 +36,18 @@ type Permission struct {
 
 func (Permission) TableName() string { return "api_permissions" }
 
+// AuthorizationDetailType is a type of RFC 9396 authorization details the API accepts, such as a payment initiation
+type AuthorizationDetailType struct {
+	model.Base
+
+	APIID       string `gorm:"column:api_id"`
+	Type        string `sortable:"true"`
+	Name        string
+	Description *string
+}
+
+func (AuthorizationDetailType) TableName() string { return "api_authorization_detail_types" }
+
 type OidcClientAllowedAPI struct {
 	OidcClientID string
 	APIID        string `gorm:"column:api_id"`
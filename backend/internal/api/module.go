@@ -15,6 +15,8 @@ type Dependencies struct {
 	DB *gorm.DB
 	// Issuer is the OpenID Provider issuer URL, reserved so a custom API cannot claim it as its audience
 	Issuer string
+	// Signer holds the signing keys, which the access token signing algorithm of an API must be usable with
+	Signer oidc.TokenSigner
 }
 
 type Module struct {
@@ -23,7 +25,7 @@ type Module struct {
 }
 
 func New(deps Dependencies) *Module {
-	service := newService(deps.DB, deps.Issuer)
+	service := newService(deps.DB, deps.Issuer, deps.Signer)
 	return &Module{
 		service: service,
 		handler: newHandler(service),
@@ -59,9 +61,46 @@ func (m *Module) DescribePermissions(ctx context.Context, audience string, keys
 	return infos, nil
 }
 
-// RegisterRoutes mounts the admin CRUD endpoints
+// AuthorizationDetailTypes implements the OIDC module's APIAccessProvider interface
+func (m *Module) AuthorizationDetailTypes(ctx context.Context, tx *gorm.DB, audience string) ([]dto.AuthorizationDetailTypeDto, error) {
+	detailTypes, err := m.service.AuthorizationDetailTypes(ctx, tx, audience)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]dto.AuthorizationDetailTypeDto, len(detailTypes))
+	for i, detailType := range detailTypes {
+		description := ""
+		if detailType.Description != nil {
+			description = *detailType.Description
+		}
+		infos[i] = dto.AuthorizationDetailTypeDto{Type: detailType.Type, Name: detailType.Name, Description: description}
+	}
+
+	return infos, nil
+}
+
+// AccessTokenSigningAlg implements the OIDC module's APIAccessProvider interface
+func (m *Module) AccessTokenSigningAlg(ctx context.Context, tx *gorm.DB, audience string) (string, error) {
+	return m.service.AccessTokenSigningAlg(ctx, tx, audience)
+}
+
+// IntrospectionJWTRequired implements the OIDC module's APIAccessProvider interface
+func (m *Module) IntrospectionJWTRequired(ctx context.Context, tx *gorm.DB, audience string) (bool, error) {
+	return m.service.IntrospectionJWTRequired(ctx, tx, audience)
+}
+
+// ResourceServerAudiences implements the OIDC module's APIAccessProvider interface
+func (m *Module) ResourceServerAudiences(ctx context.Context, tx *gorm.DB, clientID string) ([]string, error) {
+	return m.service.ResourceServerAudiences(ctx, tx, clientID)
+}
+
+// RegisterRoutes mounts the admin CRUD endpoints, and the public metadata documents of the APIs
 // adminAuth is passed in as a gin handler so the module does not import internal/middleware
-func (m *Module) RegisterRoutes(apiGroup *gin.RouterGroup, adminAuth gin.HandlerFunc) {
+func (m *Module) RegisterRoutes(rootGroup *gin.RouterGroup, apiGroup *gin.RouterGroup, adminAuth gin.HandlerFunc) {
+	// Pocket ID can't serve the metadata at the well-known location of the API's own host, so resource servers point clients to it with the resource_metadata parameter, see RFC 9728 section 5
+	rootGroup.GET("/.well-known/oauth-protected-resource/apis/:id", httpserver.Handle(m.handler.getProtectedResourceMetadata))
+
 	apis := apiGroup.Group("/apis")
 	apis.Use(adminAuth)
 	apis.GET("", httpserver.Handle(m.handler.list))
@@ -70,6 +109,7 @@ func (m *Module) RegisterRoutes(apiGroup *gin.RouterGroup, adminAuth gin.Handler
 	apis.PUT("/:id", httpserver.Handle(m.handler.update))
 	apis.DELETE("/:id", httpserver.Handle(m.handler.delete))
 	apis.PUT("/:id/permissions", httpserver.Handle(m.handler.updatePermissions))
+	apis.PUT("/:id/authorization-detail-types", httpserver.Handle(m.handler.updateAuthorizationDetailTypes))
 	apis.PUT("/:id/cimd-access", httpserver.Handle(m.handler.updateCimdAccess))
 
 	// The same client grants are editable from either side of the relation, so the API can list and manage its clients too
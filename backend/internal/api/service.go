@@ -40,10 +40,11 @@ func isValidPermissionKey(key string) bool {
 type Service struct {
 	db     *gorm.DB
 	issuer string
+	signer oidc.TokenSigner
 }
 
-func newService(db *gorm.DB, issuer string) *Service {
-	return &Service{db: db, issuer: issuer}
+func newService(db *gorm.DB, issuer string, signer oidc.TokenSigner) *Service {
+	return &Service{db: db, issuer: issuer, signer: signer}
 }
 
 // isIssuerAudience reports whether the audience refers to Pocket ID itself (the issuer)
@@ -52,10 +53,37 @@ func isIssuerAudience(audience, issuer string) bool {
 	return issuer != "" && strings.ToLower(strings.TrimRight(audience, "/")) == issuer
 }
 
+// validateAccessTokenSigningAlg checks that one of the active signing keys can sign the access tokens of an API with the algorithm
+func (s *Service) validateAccessTokenSigningAlg(alg string) error {
+	if alg == "" {
+		return nil
+	}
+	if s.signer == nil || !slices.Contains(oidc.AccessTokenSigningAlgValuesSupported(s.signer), alg) {
+		return apperror.InvalidField("accessTokenSigningAlg", "unsupported", "can't be used with any of the signing keys")
+	}
+	return nil
+}
+
+// validateResourceServerClient checks that the client registered as the API's resource server exists, and returns nil for an empty client ID
+func (s *Service) validateResourceServerClient(ctx context.Context, clientID *string) (*string, error) {
+	if clientID == nil || *clientID == "" {
+		return nil, nil
+	}
+
+	err := ensureOIDCClientExists(ctx, s.db, *clientID)
+	if apperror.IsCode(err, apperror.CodeNotFound) {
+		return nil, apperror.InvalidField("resourceServerClientId", "not_found", "doesn't match any OIDC client")
+	} else if err != nil {
+		return nil, err
+	}
+	return clientID, nil
+}
+
 func (s *Service) List(ctx context.Context, search string, listRequestOptions utils.ListRequestOptions) (apis []API, response utils.PaginationResponse, err error) {
 	query := s.db.
 		WithContext(ctx).
 		Preload("Permissions").
+		Preload("AuthorizationDetailTypes").
 		Model(&API{})
 
 	if listRequestOptions.Sort.Column == "resource" {
@@ -71,7 +99,7 @@ func (s *Service) List(ctx context.Context, search string, listRequestOptions ut
 	return apis, response, err
 }
 
-// Get loads an API and its permissions
+// Get loads an API with its permissions and authorization details types
 func (s *Service) Get(ctx context.Context, tx *gorm.DB, id string) (api API, err error) {
 	query := s.db.WithContext(ctx)
 	if tx != nil {
@@ -80,6 +108,7 @@ func (s *Service) Get(ctx context.Context, tx *gorm.DB, id string) (api API, err
 
 	err = query.
 		Preload("Permissions").
+		Preload("AuthorizationDetailTypes").
 		Where("id = ?", id).
 		First(&api).
 		Error
@@ -89,6 +118,37 @@ func (s *Service) Get(ctx context.Context, tx *gorm.DB, id string) (api API, err
 	return api, err
 }
 
+// ProtectedResourceMetadata returns the metadata that lets clients discover how to get an access token for the API, see RFC 9728
+func (s *Service) ProtectedResourceMetadata(ctx context.Context, id string) (protectedResourceMetadataDto, error) {
+	api, err := s.Get(ctx, nil, id)
+	if err != nil {
+		return protectedResourceMetadataDto{}, err
+	}
+
+	scopes := make([]string, len(api.Permissions))
+	for i, permission := range api.Permissions {
+		scopes[i] = permission.Key
+	}
+	slices.Sort(scopes)
+
+	detailTypes := make([]string, len(api.AuthorizationDetailTypes))
+	for i, detailType := range api.AuthorizationDetailTypes {
+		detailTypes[i] = detailType.Type
+	}
+	slices.Sort(detailTypes)
+
+	return protectedResourceMetadataDto{
+		Resource:             api.Audience,
+		ResourceName:         api.Name,
+		AuthorizationServers: []string{s.issuer},
+		ScopesSupported:      scopes,
+		// Pocket ID can't tell how the API reads and validates the access token, so only the method every resource server supports is advertised
+		// Whether the API accepts sender-constrained tokens is left out for the same reason
+		BearerMethodsSupported:             []string{"header"},
+		AuthorizationDetailsTypesSupported: detailTypes,
+	}, nil
+}
+
 func (s *Service) Create(ctx context.Context, input apiCreateDto) (api API, err error) {
 	// Store one canonical resource identifier so trailing-slash variants cannot create separate audiences
 	input.Resource = strings.TrimRight(input.Resource, "/")
@@ -97,10 +157,22 @@ func (s *Service) Create(ctx context.Context, input apiCreateDto) (api API, err
 	if isIssuerAudience(input.Resource, s.issuer) {
 		return API{}, apperror.InvalidField("resource", "reserved", "is reserved by Pocket ID and cannot be used for a custom API")
 	}
+	err = s.validateAccessTokenSigningAlg(input.AccessTokenSigningAlg)
+	if err != nil {
+		return API{}, err
+	}
+
+	input.ResourceServerClientID, err = s.validateResourceServerClient(ctx, input.ResourceServerClientID)
+	if err != nil {
+		return API{}, err
+	}
 
 	api = API{
-		Name:     input.Name,
-		Audience: input.Resource,
+		Name:                     input.Name,
+		Audience:                 input.Resource,
+		AccessTokenSigningAlg:    input.AccessTokenSigningAlg,
+		IntrospectionJWTRequired: input.IntrospectionJWTRequired,
+		ResourceServerClientID:   input.ResourceServerClientID,
 	}
 
 	err = s.db.WithContext(ctx).Create(&api).Error
@@ -115,6 +187,15 @@ func (s *Service) Create(ctx context.Context, input apiCreateDto) (api API, err
 }
 
 func (s *Service) Update(ctx context.Context, id string, input apiUpdateDto) (api API, err error) {
+	err = s.validateAccessTokenSigningAlg(input.AccessTokenSigningAlg)
+	if err != nil {
+		return API{}, err
+	}
+	input.ResourceServerClientID, err = s.validateResourceServerClient(ctx, input.ResourceServerClientID)
+	if err != nil {
+		return API{}, err
+	}
+
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
@@ -126,6 +207,9 @@ func (s *Service) Update(ctx context.Context, id string, input apiUpdateDto) (ap
 	}
 
 	api.Name = input.Name
+	api.AccessTokenSigningAlg = input.AccessTokenSigningAlg
+	api.IntrospectionJWTRequired = input.IntrospectionJWTRequired
+	api.ResourceServerClientID = input.ResourceServerClientID
 	api.UpdatedAt = new(datatype.DateTime(time.Now()))
 
 	err = tx.WithContext(ctx).Save(&api).Error
@@ -260,6 +344,74 @@ func (s *Service) UpdatePermissions(ctx context.Context, id string, input apiPer
 	return api, nil
 }
 
+// UpdateAuthorizationDetailTypes replaces the full set of RFC 9396 authorization details types the API accepts
+// No grant references a type, so the set is simply rewritten
+func (s *Service) UpdateAuthorizationDetailTypes(ctx context.Context, id string, input apiAuthorizationDetailTypesUpdateDto) (api API, err error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	api, err = s.Get(ctx, tx, id)
+	if err != nil {
+		return API{}, err
+	}
+
+	// The type is sent back in the token and introspection responses, so it's held to the same characters as a permission key
+	seen := make(map[string]struct{}, len(input.Types))
+	for index, detailType := range input.Types {
+		field := fmt.Sprintf("types[%d].type", index)
+		if !isValidPermissionKey(detailType.Type) {
+			return API{}, apperror.InvalidField(field, "invalid_format", "contains characters that are not valid in an authorization details type")
+		}
+		_, ok := seen[detailType.Type]
+		if ok {
+			return API{}, apperror.InvalidField(field, "duplicate", "is listed more than once")
+		}
+		seen[detailType.Type] = struct{}{}
+	}
+
+	err = tx.WithContext(ctx).
+		Where("api_id = ?", api.ID).
+		Delete(&AuthorizationDetailType{}).
+		Error
+	if err != nil {
+		return API{}, err
+	}
+
+	for _, in := range input.Types {
+		detailType := AuthorizationDetailType{
+			APIID:       api.ID,
+			Type:        in.Type,
+			Name:        in.Name,
+			Description: in.Description,
+		}
+		if err = tx.WithContext(ctx).Create(&detailType).Error; err != nil {
+			return API{}, err
+		}
+	}
+
+	err = tx.WithContext(ctx).
+		Model(&API{}).
+		Where("id = ?", api.ID).
+		Update("updated_at", new(datatype.DateTime(time.Now()))).
+		Error
+	if err != nil {
+		return API{}, err
+	}
+
+	api, err = s.Get(ctx, tx, id)
+	if err != nil {
+		return API{}, err
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return API{}, err
+	}
+
+	return api, nil
+}
+
 // SetCIMDAccess toggles whether an API is open to CIMD clients and stores which permissions they may request
 // The selection is kept while access is off, so re-enabling restores the previous choice
 func (s *Service) SetCIMDAccess(ctx context.Context, id string, input apiCimdAccessUpdateDto) (api API, err error) {
@@ -327,8 +479,10 @@ func (s *Service) SetCIMDAccess(ctx context.Context, id string, input apiCimdAcc
 type ClientAPIAccess struct {
 	UserDelegatedAPIIDs        []string
 	ClientAPIIDs               []string
+	TokenExchangeAPIIDs        []string
 	UserDelegatedPermissionIDs []string
 	ClientPermissionIDs        []string
+	TokenExchangePermissionIDs []string
 }
 
 // ClientAPIGrant is one API a client may reach, together with the grants that apply to it
@@ -377,6 +531,8 @@ func (s *Service) ListClientAPIs(ctx context.Context, clientID string) ([]Client
 			grantFor(row.APIID).ClientAccess = true
 		case oidc.SubjectTypeUser:
 			grantFor(row.APIID).UserDelegatedAccess = true
+		case oidc.SubjectTypeTokenExchange:
+			grantFor(row.APIID).TokenExchangeAccess = true
 		}
 	}
 
@@ -406,6 +562,8 @@ func (s *Service) ListClientAPIs(ctx context.Context, clientID string) ([]Client
 			entry.ClientPermissionIDs = append(entry.ClientPermissionIDs, row.APIPermissionID)
 		case oidc.SubjectTypeUser:
 			entry.UserDelegatedPermissionIDs = append(entry.UserDelegatedPermissionIDs, row.APIPermissionID)
+		case oidc.SubjectTypeTokenExchange:
+			entry.TokenExchangePermissionIDs = append(entry.TokenExchangePermissionIDs, row.APIPermissionID)
 		}
 	}
 
@@ -447,6 +605,7 @@ func (s *Service) ListClientAPIs(ctx context.Context, clientID string) ([]Client
 		entry.API = api
 		entry.UserDelegatedPermissionIDs = orEmptyIDs(entry.UserDelegatedPermissionIDs)
 		entry.ClientPermissionIDs = orEmptyIDs(entry.ClientPermissionIDs)
+		entry.TokenExchangePermissionIDs = orEmptyIDs(entry.TokenExchangePermissionIDs)
 		entry.CIMDGrantedPermissionIDs = orEmptyIDs(entry.CIMDGrantedPermissionIDs)
 		result = append(result, entry)
 	}
@@ -456,26 +615,32 @@ func (s *Service) ListClientAPIs(ctx context.Context, clientID string) ([]Client
 
 // insertClientGrants writes the API and permission grants of one client for both subject types
 func insertClientGrants(ctx context.Context, tx *gorm.DB, clientID string, access ClientAPIAccess) error {
-	apiRows := make([]OidcClientAllowedAPI, 0, len(access.UserDelegatedAPIIDs)+len(access.ClientAPIIDs))
+	apiRows := make([]OidcClientAllowedAPI, 0, len(access.UserDelegatedAPIIDs)+len(access.ClientAPIIDs)+len(access.TokenExchangeAPIIDs))
 	for _, apiID := range access.UserDelegatedAPIIDs {
 		apiRows = append(apiRows, OidcClientAllowedAPI{OidcClientID: clientID, APIID: apiID, SubjectType: oidc.SubjectTypeUser})
 	}
 	for _, apiID := range access.ClientAPIIDs {
 		apiRows = append(apiRows, OidcClientAllowedAPI{OidcClientID: clientID, APIID: apiID, SubjectType: oidc.SubjectTypeClient})
 	}
+	for _, apiID := range access.TokenExchangeAPIIDs {
+		apiRows = append(apiRows, OidcClientAllowedAPI{OidcClientID: clientID, APIID: apiID, SubjectType: oidc.SubjectTypeTokenExchange})
+	}
 	if len(apiRows) > 0 {
 		if err := tx.WithContext(ctx).Create(&apiRows).Error; err != nil {
 			return err
 		}
 	}
 
-	permissionRows := make([]OidcClientAllowedAPIPermission, 0, len(access.UserDelegatedPermissionIDs)+len(access.ClientPermissionIDs))
+	permissionRows := make([]OidcClientAllowedAPIPermission, 0, len(access.UserDelegatedPermissionIDs)+len(access.ClientPermissionIDs)+len(access.TokenExchangePermissionIDs))
 	for _, permissionID := range access.UserDelegatedPermissionIDs {
 		permissionRows = append(permissionRows, OidcClientAllowedAPIPermission{OidcClientID: clientID, APIPermissionID: permissionID, SubjectType: oidc.SubjectTypeUser})
 	}
 	for _, permissionID := range access.ClientPermissionIDs {
 		permissionRows = append(permissionRows, OidcClientAllowedAPIPermission{OidcClientID: clientID, APIPermissionID: permissionID, SubjectType: oidc.SubjectTypeClient})
 	}
+	for _, permissionID := range access.TokenExchangePermissionIDs {
+		permissionRows = append(permissionRows, OidcClientAllowedAPIPermission{OidcClientID: clientID, APIPermissionID: permissionID, SubjectType: oidc.SubjectTypeTokenExchange})
+	}
 	if len(permissionRows) > 0 {
 		if err := tx.WithContext(ctx).Create(&permissionRows).Error; err != nil {
 			return err
@@ -490,8 +655,10 @@ func insertClientGrants(ctx context.Context, tx *gorm.DB, clientID string, acces
 type APIClientGrant struct {
 	UserDelegatedAccess        bool
 	ClientAccess               bool
+	TokenExchangeAccess        bool
 	UserDelegatedPermissionIDs []string
 	ClientPermissionIDs        []string
+	TokenExchangePermissionIDs []string
 }
 
 // APIClientAccess is a single client's grants on one API, as shown on the API's detail page
@@ -536,6 +703,8 @@ func (s *Service) ListAPIClients(ctx context.Context, apiID, search string, list
 			grantFor(row.OidcClientID).ClientAccess = true
 		case oidc.SubjectTypeUser:
 			grantFor(row.OidcClientID).UserDelegatedAccess = true
+		case oidc.SubjectTypeTokenExchange:
+			grantFor(row.OidcClientID).TokenExchangeAccess = true
 		}
 	}
 
@@ -561,6 +730,8 @@ func (s *Service) ListAPIClients(ctx context.Context, apiID, search string, list
 			entry.ClientPermissionIDs = append(entry.ClientPermissionIDs, row.APIPermissionID)
 		case oidc.SubjectTypeUser:
 			entry.UserDelegatedPermissionIDs = append(entry.UserDelegatedPermissionIDs, row.APIPermissionID)
+		case oidc.SubjectTypeTokenExchange:
+			entry.TokenExchangePermissionIDs = append(entry.TokenExchangePermissionIDs, row.APIPermissionID)
 		}
 	}
 
@@ -615,6 +786,7 @@ func (s *Service) ListAPIClients(ctx context.Context, apiID, search string, list
 		}
 		grant.UserDelegatedPermissionIDs = orEmptyIDs(grant.UserDelegatedPermissionIDs)
 		grant.ClientPermissionIDs = orEmptyIDs(grant.ClientPermissionIDs)
+		grant.TokenExchangePermissionIDs = orEmptyIDs(grant.TokenExchangePermissionIDs)
 
 		entry := APIClientAccess{Client: client, APIClientGrant: grant, CIMDGrantedPermissionIDs: []string{}}
 		if api.AllowCIMDClients && client.ClientType == model.OidcClientTypeCIMD {
@@ -716,13 +888,17 @@ func (s *Service) SetAPIClientAccess(ctx context.Context, apiID, clientID string
 	permissionIDs := collectIDs(api.Permissions)
 	applied.UserDelegatedPermissionIDs = intersectIDs(permissionIDs, grant.UserDelegatedPermissionIDs)
 	applied.ClientPermissionIDs = intersectIDs(permissionIDs, grant.ClientPermissionIDs)
-	// A public client can't use the client credentials grant, so drop any client-subject grant that could never produce a token
+	applied.TokenExchangePermissionIDs = intersectIDs(permissionIDs, grant.TokenExchangePermissionIDs)
+	// A public client can't use the client credentials or token exchange grants, so drop any grant for them that could never produce a token
 	if client.IsPublic {
 		grant.ClientAccess = false
+		grant.TokenExchangeAccess = false
 		applied.ClientPermissionIDs = nil
+		applied.TokenExchangePermissionIDs = nil
 	}
 	applied.UserDelegatedAccess = grant.UserDelegatedAccess || len(applied.UserDelegatedPermissionIDs) > 0
 	applied.ClientAccess = grant.ClientAccess || len(applied.ClientPermissionIDs) > 0
+	applied.TokenExchangeAccess = grant.TokenExchangeAccess || len(applied.TokenExchangePermissionIDs) > 0
 
 	if err = deleteAPIClientGrants(ctx, tx, api.ID, permissionIDs, clientID); err != nil {
 		return APIClientGrant{}, err
@@ -731,6 +907,7 @@ func (s *Service) SetAPIClientAccess(ctx context.Context, apiID, clientID string
 	access := ClientAPIAccess{
 		UserDelegatedPermissionIDs: applied.UserDelegatedPermissionIDs,
 		ClientPermissionIDs:        applied.ClientPermissionIDs,
+		TokenExchangePermissionIDs: applied.TokenExchangePermissionIDs,
 	}
 	if applied.UserDelegatedAccess {
 		access.UserDelegatedAPIIDs = []string{api.ID}
@@ -738,6 +915,9 @@ func (s *Service) SetAPIClientAccess(ctx context.Context, apiID, clientID string
 	if applied.ClientAccess {
 		access.ClientAPIIDs = []string{api.ID}
 	}
+	if applied.TokenExchangeAccess {
+		access.TokenExchangeAPIIDs = []string{api.ID}
+	}
 	if err = insertClientGrants(ctx, tx, clientID, access); err != nil {
 		return APIClientGrant{}, err
 	}
@@ -839,11 +1019,14 @@ func (s *Service) ClientAPIScopesAndAudiences(ctx context.Context, tx *gorm.DB,
 		tx = s.db
 	}
 
+	// Token exchange grants are resolved by the token exchange grant itself, so they must not widen what the client may request in the other flows
+	requestableSubjectTypes := []oidc.SubjectType{oidc.SubjectTypeUser, oidc.SubjectTypeClient}
+
 	var audienceRows []string
 	err = tx.WithContext(ctx).
 		Table("oidc_clients_allowed_apis AS g").
 		Joins("JOIN apis ON apis.id = g.api_id").
-		Where("g.oidc_client_id = ?", clientID).
+		Where("g.oidc_client_id = ? AND g.subject_type IN ?", clientID, requestableSubjectTypes).
 		Pluck("apis.audience", &audienceRows).
 		Error
 	if err != nil {
@@ -854,7 +1037,7 @@ func (s *Service) ClientAPIScopesAndAudiences(ctx context.Context, tx *gorm.DB,
 	err = tx.WithContext(ctx).
 		Table("oidc_clients_allowed_api_permissions AS g").
 		Joins("JOIN api_permissions ON api_permissions.id = g.api_permission_id").
-		Where("g.oidc_client_id = ?", clientID).
+		Where("g.oidc_client_id = ? AND g.subject_type IN ?", clientID, requestableSubjectTypes).
 		Pluck("api_permissions.key", &scopeRows).
 		Error
 	if err != nil {
@@ -1046,6 +1229,100 @@ func (s *Service) DescribePermissions(ctx context.Context, audience string, keys
 	return permissions, nil
 }
 
+// AuthorizationDetailTypes returns the RFC 9396 authorization details types accepted by the API identified by the given audience
+// The OIDC module validates requested authorization details against these and shows their names on the consent screen
+func (s *Service) AuthorizationDetailTypes(ctx context.Context, tx *gorm.DB, audience string) ([]AuthorizationDetailType, error) {
+	if tx == nil {
+		tx = s.db
+	}
+
+	// Match against the same canonical audience used during resource resolution
+	audience = strings.TrimRight(audience, "/")
+
+	var detailTypes []AuthorizationDetailType
+	err := tx.WithContext(ctx).
+		Model(&AuthorizationDetailType{}).
+		Joins("JOIN apis ON apis.id = api_authorization_detail_types.api_id").
+		Where("apis.audience = ?", audience).
+		Order("api_authorization_detail_types.type").
+		Find(&detailTypes).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return detailTypes, nil
+}
+
+// AccessTokenSigningAlg returns the algorithm the access tokens for the API identified by the given audience are signed with
+// It's empty if the API uses the default signing key, or if no API has the audience
+func (s *Service) AccessTokenSigningAlg(ctx context.Context, tx *gorm.DB, audience string) (string, error) {
+	if tx == nil {
+		tx = s.db
+	}
+
+	// Match against the same canonical audience used during resource resolution
+	audience = strings.TrimRight(audience, "/")
+
+	var algs []string
+	err := tx.WithContext(ctx).
+		Model(&API{}).
+		Where("audience = ?", audience).
+		Limit(1).
+		Pluck("access_token_signing_alg", &algs).
+		Error
+	if err != nil {
+		return "", err
+	}
+	if len(algs) == 0 {
+		return "", nil
+	}
+
+	return algs[0], nil
+}
+
+// IntrospectionJWTRequired reports whether the introspection responses for the tokens of the API identified by the given audience must be signed JWTs
+// It's false if no API has the audience
+func (s *Service) IntrospectionJWTRequired(ctx context.Context, tx *gorm.DB, audience string) (bool, error) {
+	if tx == nil {
+		tx = s.db
+	}
+
+	// Match against the same canonical audience used during resource resolution
+	audience = strings.TrimRight(audience, "/")
+
+	var count int64
+	err := tx.WithContext(ctx).
+		Model(&API{}).
+		Where("audience = ? AND introspection_jwt_required = ?", audience, true).
+		Count(&count).
+		Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// ResourceServerAudiences returns the audiences of the APIs the client is registered as the resource server of
+func (s *Service) ResourceServerAudiences(ctx context.Context, tx *gorm.DB, clientID string) ([]string, error) {
+	if tx == nil {
+		tx = s.db
+	}
+
+	var audiences []string
+	err := tx.WithContext(ctx).
+		Model(&API{}).
+		Where("resource_server_client_id = ?", clientID).
+		Pluck("audience", &audiences).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return audiences, nil
+}
+
 // deletePermissions removes permissions by ID; deleting them cascades to any client permission grants that reference them (oidc_clients_allowed_api_permissions.api_permission_id ON DELETE CASCADE)
 // A client's access to an API is dropped together with the last permission it held there, so removing a permission never leaves a client with lingering scopeless access it never asked for; access granted without any permission is untouched because such a client holds none of the deleted permissions
 func (s *Service) deletePermissions(ctx context.Context, tx *gorm.DB, permissionIDs []string) error {
@@ -1,8 +1,13 @@
 package api
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"testing"
 
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -229,6 +234,65 @@ func TestAllowedScopesForAudienceFiltersBySubjectType(t *testing.T) {
 	assert.ElementsMatch(t, []string{"https://api.orders.example.com"}, audiences)
 }
 
+// TestTokenExchangeGrants guards that the APIs a client may exchange tokens into are kept apart from its other grants,
+// so allowing an exchange neither lets the client request the API at the authorization endpoint nor the other way around.
+func TestTokenExchangeGrants(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	svc := New(Dependencies{DB: db}).service
+
+	const resource = "https://api.orders.example.com"
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "client-1"}, Name: "Client 1"}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "public-1"}, Name: "Public", IsPublic: true}).Error)
+
+	orders, err := svc.Create(t.Context(), apiCreateDto{Name: "Orders", Resource: resource})
+	require.NoError(t, err)
+	orders, err = svc.UpdatePermissions(t.Context(), orders.ID, apiPermissionsUpdateDto{Permissions: []apiPermissionInputDto{
+		{Key: "read:orders", Name: "Read"},
+		{Key: "write:orders", Name: "Write"},
+	}})
+	require.NoError(t, err)
+	readID := findPermission(orders, "read:orders").ID
+
+	applied, err := svc.SetAPIClientAccess(t.Context(), orders.ID, "client-1", APIClientGrant{
+		TokenExchangePermissionIDs: []string{readID, "does-not-exist"},
+	})
+	require.NoError(t, err)
+	assert.True(t, applied.TokenExchangeAccess)
+	assert.ElementsMatch(t, []string{readID}, applied.TokenExchangePermissionIDs)
+	assert.False(t, applied.UserDelegatedAccess)
+
+	got := clientGrantFor(t, svc, "client-1", orders.ID)
+	assert.True(t, got.TokenExchangeAccess)
+	assert.ElementsMatch(t, []string{readID}, got.TokenExchangePermissionIDs)
+	assert.Empty(t, got.UserDelegatedPermissionIDs)
+
+	scopes, exists, hasAccess, err := svc.AllowedScopesForAudience(t.Context(), nil, "client-1", resource, oidc.SubjectTypeTokenExchange)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.True(t, hasAccess)
+	assert.ElementsMatch(t, []string{"read:orders"}, scopes)
+
+	_, _, hasAccess, err = svc.AllowedScopesForAudience(t.Context(), nil, "client-1", resource, oidc.SubjectTypeUser)
+	require.NoError(t, err)
+	assert.False(t, hasAccess)
+
+	// The exchange grants are not part of what the client may request in the other flows
+	scopes, audiences, err := svc.ClientAPIScopesAndAudiences(t.Context(), nil, "client-1", false)
+	require.NoError(t, err)
+	assert.Empty(t, scopes)
+	assert.Empty(t, audiences)
+
+	// A public client can't authenticate for the token exchange grant
+	applied, err = svc.SetAPIClientAccess(t.Context(), orders.ID, "public-1", APIClientGrant{
+		UserDelegatedAccess:        true,
+		TokenExchangeAccess:        true,
+		TokenExchangePermissionIDs: []string{readID},
+	})
+	require.NoError(t, err)
+	assert.False(t, applied.TokenExchangeAccess)
+	assert.Empty(t, applied.TokenExchangePermissionIDs)
+}
+
 // TestAccessWithoutPermissions covers granting an API to a client without any permission, which is what
 // an MCP client needs: the resource is reachable and the token simply carries no custom scope.
 func TestAccessWithoutPermissions(t *testing.T) {
@@ -367,6 +431,56 @@ func TestCreateTrimsResourceTrailingSlashes(t *testing.T) {
 	require.True(t, apperror.IsCode(err, apperror.CodeAlreadyInUse))
 }
 
+// testSigner has a single P-256 signing key, which can only sign with ES256
+type testSigner struct {
+	key jwk.Key
+}
+
+func newTestSigner(t *testing.T) testSigner {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key, err := jwk.Import(privateKey)
+	require.NoError(t, err)
+	return testSigner{key: key}
+}
+
+func (s testSigner) GetPrivateKey() any {
+	return nil
+}
+
+func (s testSigner) GetKeyAlg() (jwa.KeyAlgorithm, error) {
+	return jwa.ES256(), nil
+}
+
+func (s testSigner) GetKeyID() (string, bool) {
+	return "", false
+}
+
+func (s testSigner) GetSigningKeys() []jwk.Key {
+	return []jwk.Key{s.key}
+}
+
+func (s testSigner) GetVerificationKeys() []jwk.Key {
+	return []jwk.Key{s.key}
+}
+
+func TestAccessTokenSigningAlgMustMatchSigningKeys(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	svc := New(Dependencies{DB: db, Signer: newTestSigner(t)}).service
+
+	_, err := svc.Create(t.Context(), apiCreateDto{Name: "Orders", Resource: "https://api.orders.example.com", AccessTokenSigningAlg: "RS256"})
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+
+	orders, err := svc.Create(t.Context(), apiCreateDto{Name: "Orders", Resource: "https://api.orders.example.com", AccessTokenSigningAlg: "ES256"})
+	require.NoError(t, err)
+	assert.Equal(t, "ES256", orders.AccessTokenSigningAlg)
+
+	_, err = svc.Update(t.Context(), orders.ID, apiUpdateDto{Name: "Orders", AccessTokenSigningAlg: "EdDSA"})
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+}
+
 func TestDescribePermissions(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 	svc := New(Dependencies{DB: db}).service
@@ -389,6 +503,124 @@ func TestDescribePermissions(t *testing.T) {
 	assert.Equal(t, "Read orders", *infos[0].Description)
 }
 
+func TestIntrospectionJWTRequired(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	svc := New(Dependencies{DB: db}).service
+
+	orders, err := svc.Create(t.Context(), apiCreateDto{Name: "Orders", Resource: "https://api.orders.example.com", IntrospectionJWTRequired: true})
+	require.NoError(t, err)
+	_, err = svc.Create(t.Context(), apiCreateDto{Name: "Billing", Resource: "https://api.billing.example.com"})
+	require.NoError(t, err)
+
+	required, err := svc.IntrospectionJWTRequired(t.Context(), nil, "https://api.orders.example.com/")
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	required, err = svc.IntrospectionJWTRequired(t.Context(), nil, "https://api.billing.example.com")
+	require.NoError(t, err)
+	assert.False(t, required)
+
+	required, err = svc.IntrospectionJWTRequired(t.Context(), nil, "https://unknown.example.com")
+	require.NoError(t, err)
+	assert.False(t, required)
+
+	_, err = svc.Update(t.Context(), orders.ID, apiUpdateDto{Name: "Orders"})
+	require.NoError(t, err)
+	required, err = svc.IntrospectionJWTRequired(t.Context(), nil, "https://api.orders.example.com")
+	require.NoError(t, err)
+	assert.False(t, required)
+}
+
+func TestProtectedResourceMetadata(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	svc := New(Dependencies{DB: db, Issuer: "https://id.example.com"}).service
+
+	orders, err := svc.Create(t.Context(), apiCreateDto{Name: "Orders", Resource: "https://api.orders.example.com"})
+	require.NoError(t, err)
+	_, err = svc.UpdatePermissions(t.Context(), orders.ID, apiPermissionsUpdateDto{Permissions: []apiPermissionInputDto{
+		{Key: "write:orders", Name: "Write orders"},
+		{Key: "read:orders", Name: "Read orders"},
+	}})
+	require.NoError(t, err)
+
+	metadata, err := svc.ProtectedResourceMetadata(t.Context(), orders.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.orders.example.com", metadata.Resource)
+	assert.Equal(t, "Orders", metadata.ResourceName)
+	assert.Equal(t, []string{"https://id.example.com"}, metadata.AuthorizationServers)
+	assert.Equal(t, []string{"read:orders", "write:orders"}, metadata.ScopesSupported)
+	assert.Equal(t, []string{"header"}, metadata.BearerMethodsSupported)
+	assert.Empty(t, metadata.AuthorizationDetailsTypesSupported)
+
+	_, err = svc.ProtectedResourceMetadata(t.Context(), "unknown")
+	require.True(t, apperror.IsCode(err, apperror.CodeNotFound))
+}
+
+func TestResourceServerAudiences(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	svc := New(Dependencies{DB: db}).service
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "gateway"}, Name: "Gateway"}).Error)
+
+	_, err := svc.Create(t.Context(), apiCreateDto{Name: "Orders", Resource: "https://api.orders.example.com", ResourceServerClientID: new("unknown")})
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+
+	orders, err := svc.Create(t.Context(), apiCreateDto{Name: "Orders", Resource: "https://api.orders.example.com", ResourceServerClientID: new("gateway")})
+	require.NoError(t, err)
+	_, err = svc.Create(t.Context(), apiCreateDto{Name: "Billing", Resource: "https://api.billing.example.com", ResourceServerClientID: new("")})
+	require.NoError(t, err)
+
+	audiences, err := svc.ResourceServerAudiences(t.Context(), nil, "gateway")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://api.orders.example.com"}, audiences)
+
+	_, err = svc.Update(t.Context(), orders.ID, apiUpdateDto{Name: "Orders"})
+	require.NoError(t, err)
+	audiences, err = svc.ResourceServerAudiences(t.Context(), nil, "gateway")
+	require.NoError(t, err)
+	assert.Empty(t, audiences)
+}
+
+func TestUpdateAuthorizationDetailTypes(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	svc := New(Dependencies{DB: db}).service
+
+	payments, err := svc.Create(t.Context(), apiCreateDto{Name: "Payments", Resource: "https://api.payments.example.com"})
+	require.NoError(t, err)
+
+	desc := "Initiate a payment"
+	updated, err := svc.UpdateAuthorizationDetailTypes(t.Context(), payments.ID, apiAuthorizationDetailTypesUpdateDto{Types: []apiAuthorizationDetailTypeInputDto{
+		{Type: "payment_initiation", Name: "Payment", Description: &desc},
+		{Type: "account_information", Name: "Account information"},
+	}})
+	require.NoError(t, err)
+	assert.Len(t, updated.AuthorizationDetailTypes, 2)
+
+	// The set is replaced as a whole
+	updated, err = svc.UpdateAuthorizationDetailTypes(t.Context(), payments.ID, apiAuthorizationDetailTypesUpdateDto{Types: []apiAuthorizationDetailTypeInputDto{
+		{Type: "payment_initiation", Name: "Payment", Description: &desc},
+	}})
+	require.NoError(t, err)
+	require.Len(t, updated.AuthorizationDetailTypes, 1)
+
+	detailTypes, err := svc.AuthorizationDetailTypes(t.Context(), nil, "https://api.payments.example.com/")
+	require.NoError(t, err)
+	require.Len(t, detailTypes, 1)
+	assert.Equal(t, "payment_initiation", detailTypes[0].Type)
+	require.NotNil(t, detailTypes[0].Description)
+	assert.Equal(t, desc, *detailTypes[0].Description)
+
+	_, err = svc.UpdateAuthorizationDetailTypes(t.Context(), payments.ID, apiAuthorizationDetailTypesUpdateDto{Types: []apiAuthorizationDetailTypeInputDto{
+		{Type: "payment_initiation", Name: "Payment"},
+		{Type: "payment_initiation", Name: "Payment again"},
+	}})
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+
+	_, err = svc.UpdateAuthorizationDetailTypes(t.Context(), payments.ID, apiAuthorizationDetailTypesUpdateDto{Types: []apiAuthorizationDetailTypeInputDto{
+		{Type: "payment initiation", Name: "Payment"},
+	}})
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+}
+
 // TestCimdClientAccess covers the API-wide opt-in that lets every metadata document client reach an API without an individual grant.
 func TestCimdClientAccess(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
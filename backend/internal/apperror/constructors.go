@@ -126,6 +126,10 @@ func SyncedPasskeyNotAllowed() *Error {
 	return New(CodeSyncedPasskeyNotAllowed, http.StatusBadRequest, "Synced passkeys are not allowed")
 }
 
+func InsufficientUserAuthentication() *Error {
+	return New(CodeInsufficientUserAuthentication, http.StatusBadRequest, "This application requires a stronger passkey")
+}
+
 func ReservedClaim(key string) *Error {
 	return New(CodeReservedClaim, http.StatusBadRequest, fmt.Sprintf("Claim %s is reserved and can't be used", key)).
 		WithDetail("key", key).
@@ -288,6 +292,10 @@ func OidcPARRequired() *Error {
 	return New(CodeOidcPARRequired, http.StatusBadRequest, "This client requires pushed authorization requests")
 }
 
+func SigningKeyRolloverInProgress() *Error {
+	return New(CodeSigningKeyRolloverInProgress, http.StatusConflict, "A signing key rollover is already in progress")
+}
+
 func InvalidEmailVerificationToken() *Error {
 	return New(CodeEmailVerificationTokenInvalid, http.StatusBadRequest, "Email verification token is invalid")
 }
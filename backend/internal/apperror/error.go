@@ -27,6 +27,7 @@ const (
 	CodeWebAuthnAuthenticationFailed    Code = "webauthn_authentication_failed"
 	CodePasskeyUserVerificationRequired Code = "passkey_user_verification_required"
 	CodeSyncedPasskeyNotAllowed         Code = "synced_passkey_not_allowed"
+	CodeInsufficientUserAuthentication  Code = "insufficient_user_authentication"
 	CodeInvalidWebAuthnSession          Code = "invalid_webauthn_session"
 	CodeUserNotFound                    Code = "user_not_found"
 	CodeUserDisabled                    Code = "user_disabled"
@@ -68,6 +69,7 @@ const (
 	CodeLogoTypeNotSupported            Code = "logo_type_not_supported"
 	CodeLogoTooLarge                    Code = "logo_too_large"
 	CodeOidcPARRequired                 Code = "oidc_par_required"
+	CodeSigningKeyRolloverInProgress    Code = "signing_key_rollover_in_progress"
 )
 
 // FieldError describes one safe, client-actionable validation failure
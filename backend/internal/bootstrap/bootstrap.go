@@ -103,6 +103,9 @@ func Bootstrap(ctx context.Context) error {
 	// Migrate the pre-actor signup tokens into their actors, once the actor host is ready
 	services = append(services, actorsReady.Await(svc.userSignUpModule.RunSignupTokenMigration))
 
+	// Reload the signing keys periodically, so this replica picks up a rollover without a restart
+	services = append(services, svc.keyRolloverModule.Run)
+
 	// These services are only registered in non-test mode
 	if common.EnvConfig.AppEnv != "test" {
 		// Refresh the GeoLite database (this is cached per each replica)
@@ -10,11 +10,13 @@ import (
 	"github.com/pocket-id/pocket-id/backend/internal/apikey"
 	"github.com/pocket-id/pocket-id/backend/internal/appconfig"
 	"github.com/pocket-id/pocket-id/backend/internal/auditlogs"
+	"github.com/pocket-id/pocket-id/backend/internal/clientregistration"
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/devicelogin"
 	"github.com/pocket-id/pocket-id/backend/internal/email"
 	"github.com/pocket-id/pocket-id/backend/internal/emailverification"
 	"github.com/pocket-id/pocket-id/backend/internal/geolite"
+	"github.com/pocket-id/pocket-id/backend/internal/keyrollover"
 	"github.com/pocket-id/pocket-id/backend/internal/ldapsync"
 	"github.com/pocket-id/pocket-id/backend/internal/oidc"
 	"github.com/pocket-id/pocket-id/backend/internal/onetimeaccess"
@@ -22,6 +24,7 @@ import (
 	"github.com/pocket-id/pocket-id/backend/internal/service"
 	"github.com/pocket-id/pocket-id/backend/internal/storage"
 	"github.com/pocket-id/pocket-id/backend/internal/usersignup"
+	jwkutils "github.com/pocket-id/pocket-id/backend/internal/utils/jwk"
 	"github.com/pocket-id/pocket-id/backend/internal/webauthn"
 	"gorm.io/gorm"
 )
@@ -40,18 +43,20 @@ type services struct {
 	versionService     *service.VersionService
 	fileStorage        storage.FileStorage
 
-	apiKeyModule            *apikey.Module
-	auditLogsModule         *auditlogs.Module
-	deviceLoginModule       *devicelogin.Module
-	ldapSyncModule          *ldapsync.Module
-	scimSyncModule          *scimsync.Module
-	oidcModule              *oidc.Module
-	webauthnModule          *webauthn.Module
-	userSignUpModule        *usersignup.Module
-	oneTimeAccessModule     *onetimeaccess.Module
-	emailVerificationModule *emailverification.Module
-	apiModule               *api.Module
-	actors                  *local.Host
+	apiKeyModule             *apikey.Module
+	auditLogsModule          *auditlogs.Module
+	clientRegistrationModule *clientregistration.Module
+	deviceLoginModule        *devicelogin.Module
+	keyRolloverModule        *keyrollover.Module
+	ldapSyncModule           *ldapsync.Module
+	scimSyncModule           *scimsync.Module
+	oidcModule               *oidc.Module
+	webauthnModule           *webauthn.Module
+	userSignUpModule         *usersignup.Module
+	oneTimeAccessModule      *onetimeaccess.Module
+	emailVerificationModule  *emailverification.Module
+	apiModule                *api.Module
+	actors                   *local.Host
 }
 
 // Initializes all services
@@ -109,6 +114,23 @@ func initServices(
 		return nil, fmt.Errorf("failed to create JWT service: %w", err)
 	}
 
+	keyProvider, err := jwkutils.GetKeyProvider(db, &common.EnvConfig, instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key provider: %w", err)
+	}
+	svc.keyRolloverModule, err = keyrollover.New(keyrollover.Dependencies{
+		DB:          db,
+		Actors:      actors,
+		KeyProvider: keyProvider,
+		Keys:        svc.jwtService,
+		AppConfig:   svc.appConfigService,
+		// Disable in test environment
+		ScheduleDisabled: common.EnvConfig.AppEnv.IsTest(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key rollover module: %w", err)
+	}
+
 	svc.customClaimService = service.NewCustomClaimService(db)
 	svc.webauthnModule, err = webauthn.New(webauthn.Dependencies{
 		DB:        db,
@@ -148,7 +170,7 @@ func initServices(
 		return nil, fmt.Errorf("failed to create SCIM sync module: %w", err)
 	}
 
-	svc.apiModule = api.New(api.Dependencies{DB: db, Issuer: common.EnvConfig.AppURL})
+	svc.apiModule = api.New(api.Dependencies{DB: db, Issuer: common.EnvConfig.AppURL, Signer: svc.jwtService})
 
 	svc.oidcModule, err = oidc.New(ctx, oidc.Dependencies{
 		DB:                  db,
@@ -160,6 +182,8 @@ func initServices(
 			TokenBaseURL:              common.EnvConfig.AppURL,
 			Secret:                    common.EnvConfig.EncryptionKey,
 			AllowInsecureCallbackURLs: common.EnvConfig.AllowInsecureCallbackURLs,
+			ClientCertificateHeader:   common.EnvConfig.TLSClientCertHeader,
+			ClientCertificateProxies:  common.EnvConfig.TLSClientCertProxies,
 		},
 		Signer:       svc.jwtService,
 		CustomClaims: svc.customClaimService,
@@ -173,11 +197,18 @@ func initServices(
 		return nil, fmt.Errorf("failed to create OIDC module: %w", err)
 	}
 
-	svc.oidcService, err = service.NewOidcService(db, svc.jwtService, svc.oidcModule.Preview, svc.oidcModule, svc.scimSyncModule, httpClient, fileStorage)
+	svc.oidcService, err = service.NewOidcService(db, svc.jwtService, svc.oidcModule.Preview, svc.oidcModule, svc.oidcModule, svc.oidcModule, svc.scimSyncModule, httpClient, fileStorage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OIDC service: %w", err)
 	}
 
+	svc.clientRegistrationModule = clientregistration.New(clientregistration.Dependencies{
+		DB:      db,
+		Clients: svc.oidcService,
+		Signer:  svc.jwtService,
+		BaseURL: common.EnvConfig.InternalAppURL,
+	})
+
 	svc.userGroupService = service.NewUserGroupService(db, svc.scimSyncModule)
 	svc.userService = service.NewUserService(db, svc.jwtService, svc.auditLogService, svc.customClaimService, svc.appImagesService, svc.scimSyncModule, fileStorage)
 
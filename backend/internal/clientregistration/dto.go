@@ -0,0 +1,76 @@
+package clientregistration
+
+import (
+	"encoding/json"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+type initialAccessTokenCreateDto struct {
+	Name         string            `json:"name" binding:"required,min=3,max=50" unorm:"nfc"`
+	Description  *string           `json:"description" unorm:"nfc"`
+	ExpiresAt    datatype.DateTime `json:"expiresAt" binding:"required"`
+	UsageLimit   int               `json:"usageLimit" binding:"min=0,max=1000"`
+	UserGroupIDs []string          `json:"userGroupIds"`
+}
+
+type initialAccessTokenDto struct {
+	ID          string                    `json:"id"`
+	Name        string                    `json:"name"`
+	Description *string                   `json:"description"`
+	ExpiresAt   datatype.DateTime         `json:"expiresAt"`
+	LastUsedAt  *datatype.DateTime        `json:"lastUsedAt"`
+	UsageLimit  int                       `json:"usageLimit"`
+	UsageCount  int                       `json:"usageCount"`
+	UserGroups  []dto.UserGroupMinimalDto `json:"userGroups"`
+	CreatedAt   datatype.DateTime         `json:"createdAt"`
+}
+
+type initialAccessTokenResponseDto struct {
+	InitialAccessToken initialAccessTokenDto `json:"initialAccessToken"`
+	Token              string                `json:"token"`
+}
+
+// clientMetadataDto is the client metadata a client registers with, as defined by RFC 7591 section 2
+// Members Pocket ID doesn't support, like software_statement, are ignored
+type clientMetadataDto struct {
+	RedirectURIs                       []string        `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod            string          `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes                         []string        `json:"grant_types,omitempty"`
+	ResponseTypes                      []string        `json:"response_types,omitempty"`
+	ClientName                         string          `json:"client_name,omitempty"`
+	ClientURI                          string          `json:"client_uri,omitempty"`
+	LogoURI                            string          `json:"logo_uri,omitempty"`
+	JWKSURI                            string          `json:"jwks_uri,omitempty"`
+	JWKS                               json.RawMessage `json:"jwks,omitempty"`
+	PostLogoutRedirectURIs             []string        `json:"post_logout_redirect_uris,omitempty"`
+	BackchannelLogoutURI               string          `json:"backchannel_logout_uri,omitempty"`
+	BackchannelLogoutSessionRequired   bool            `json:"backchannel_logout_session_required,omitempty"`
+	FrontchannelLogoutURI              string          `json:"frontchannel_logout_uri,omitempty"`
+	FrontchannelLogoutSessionRequired  bool            `json:"frontchannel_logout_session_required,omitempty"`
+	SubjectType                        string          `json:"subject_type,omitempty"`
+	SectorIdentifierURI                string          `json:"sector_identifier_uri,omitempty"`
+	AuthorizationSignedResponseAlg     string          `json:"authorization_signed_response_alg,omitempty"`
+	UserinfoSignedResponseAlg          string          `json:"userinfo_signed_response_alg,omitempty"`
+	IDTokenSignedResponseAlg           string          `json:"id_token_signed_response_alg,omitempty"`
+	IDTokenEncryptedResponseAlg        string          `json:"id_token_encrypted_response_alg,omitempty"`
+	IDTokenEncryptedResponseEnc        string          `json:"id_token_encrypted_response_enc,omitempty"`
+	UserinfoEncryptedResponseAlg       string          `json:"userinfo_encrypted_response_alg,omitempty"`
+	UserinfoEncryptedResponseEnc       string          `json:"userinfo_encrypted_response_enc,omitempty"`
+	RequirePushedAuthorizationRequests bool            `json:"require_pushed_authorization_requests,omitempty"`
+	DPoPBoundAccessTokens              bool            `json:"dpop_bound_access_tokens,omitempty"`
+	RequireSignedRequestObject         bool            `json:"require_signed_request_object,omitempty"`
+}
+
+// clientInformationDto is the response of the registration endpoints, as defined by RFC 7591 section 3.2.1 and RFC 7592 section 3
+// The registration access token is only included when a new one is issued, as reading the registration keeps the current one
+type clientInformationDto struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   *int64 `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri"`
+	clientMetadataDto
+}
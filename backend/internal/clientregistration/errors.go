@@ -0,0 +1,28 @@
+package clientregistration
+
+import (
+	"net/http"
+)
+
+// registrationError is an error returned by the registration endpoints, as defined by RFC 7591 section 3.2.2 and RFC 6750 section 3.1
+type registrationError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	status      int
+}
+
+func (e *registrationError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func errInvalidClientMetadata(description string) *registrationError {
+	return &registrationError{Code: "invalid_client_metadata", Description: description, status: http.StatusBadRequest}
+}
+
+func errInvalidRedirectURI(description string) *registrationError {
+	return &registrationError{Code: "invalid_redirect_uri", Description: description, status: http.StatusBadRequest}
+}
+
+func errInvalidToken(description string) *registrationError {
+	return &registrationError{Code: "invalid_token", Description: description, status: http.StatusUnauthorized}
+}
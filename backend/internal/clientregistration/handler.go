@@ -0,0 +1,224 @@
+package clientregistration
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/httpserver"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+type handler struct {
+	service *Service
+}
+
+func newHandler(service *Service) *handler {
+	return &handler{service: service}
+}
+
+// listInitialAccessTokens godoc
+// @Summary List initial access tokens
+// @Description Get a paginated list of the initial access tokens for dynamic client registration
+// @Tags OIDC
+// @Param pagination[page] query int false "Page number for pagination" default(1)
+// @Param pagination[limit] query int false "Number of items per page" default(20)
+// @Param sort[column] query string false "Column to sort by"
+// @Param sort[direction] query string false "Sort direction (asc or desc)" default("asc")
+// @Success 200 {object} dto.Paginated[initialAccessTokenDto]
+// @Router /api/oidc/initial-access-tokens [get]
+func (h *handler) listInitialAccessTokens(c *gin.Context) error {
+	listRequestOptions := utils.ParseListRequestOptions(c)
+
+	tokens, pagination, err := h.service.ListInitialAccessTokens(c.Request.Context(), listRequestOptions)
+	if err != nil {
+		return err
+	}
+
+	var tokensDto []initialAccessTokenDto
+	err = dto.MapStructList(tokens, &tokensDto)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, dto.Paginated[initialAccessTokenDto]{
+		Data:       tokensDto,
+		Pagination: pagination,
+	})
+	return nil
+}
+
+// createInitialAccessToken godoc
+// @Summary Create initial access token
+// @Description Create a token that authorizes the dynamic registration of OIDC clients
+// @Tags OIDC
+// @Param token body initialAccessTokenCreateDto true "Initial access token information"
+// @Success 201 {object} initialAccessTokenResponseDto "Created initial access token with its value"
+// @Router /api/oidc/initial-access-tokens [post]
+func (h *handler) createInitialAccessToken(c *gin.Context) error {
+	var input initialAccessTokenCreateDto
+	err := httpserver.BindJSON(c, &input)
+	if err != nil {
+		return err
+	}
+
+	token, value, err := h.service.CreateInitialAccessToken(c.Request.Context(), c.GetString("userID"), input)
+	if err != nil {
+		return err
+	}
+
+	var tokenDto initialAccessTokenDto
+	err = dto.MapStruct(token, &tokenDto)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusCreated, initialAccessTokenResponseDto{
+		InitialAccessToken: tokenDto,
+		Token:              value,
+	})
+	return nil
+}
+
+// deleteInitialAccessToken godoc
+// @Summary Delete initial access token
+// @Description Delete an initial access token by ID; the clients registered with it are kept
+// @Tags OIDC
+// @Param id path string true "Initial access token ID"
+// @Success 204 "No Content"
+// @Router /api/oidc/initial-access-tokens/{id} [delete]
+func (h *handler) deleteInitialAccessToken(c *gin.Context) error {
+	err := h.service.DeleteInitialAccessToken(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	c.Status(http.StatusNoContent)
+	return nil
+}
+
+// registerClient godoc
+// @Summary Register a client
+// @Description Dynamically register an OIDC client, as defined by RFC 7591. The request must be authorized with an initial access token as bearer token.
+// @Tags OIDC
+// @Accept json
+// @Produce json
+// @Param metadata body clientMetadataDto true "Client metadata"
+// @Success 201 {object} clientInformationDto "Registered client"
+// @Router /api/oidc/register [post]
+func (h *handler) registerClient(c *gin.Context) {
+	var input clientMetadataDto
+	err := c.ShouldBindJSON(&input)
+	if err != nil {
+		writeRegistrationError(c, errInvalidClientMetadata("The request body is not valid client metadata"))
+		return
+	}
+
+	info, err := h.service.RegisterClient(c.Request.Context(), bearerToken(c), input)
+	if err != nil {
+		writeRegistrationError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, info)
+}
+
+// getRegisteredClient godoc
+// @Summary Read a client registration
+// @Description Read the registration of a dynamically registered client, as defined by RFC 7592. The request must be authorized with the registration access token as bearer token, which is replaced by the one in the response.
+// @Tags OIDC
+// @Produce json
+// @Param id path string true "Client ID"
+// @Success 200 {object} clientInformationDto "Registered client"
+// @Router /api/oidc/register/{id} [get]
+func (h *handler) getRegisteredClient(c *gin.Context) {
+	info, err := h.service.GetRegisteredClient(c.Request.Context(), c.Param("id"), bearerToken(c))
+	if err != nil {
+		writeRegistrationError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// updateRegisteredClient godoc
+// @Summary Update a client registration
+// @Description Replace the metadata of a dynamically registered client, as defined by RFC 7592. The request must be authorized with the registration access token as bearer token, which is replaced by the one in the response.
+// @Tags OIDC
+// @Accept json
+// @Produce json
+// @Param id path string true "Client ID"
+// @Param metadata body clientMetadataDto true "Client metadata"
+// @Success 200 {object} clientInformationDto "Registered client"
+// @Router /api/oidc/register/{id} [put]
+func (h *handler) updateRegisteredClient(c *gin.Context) {
+	var input struct {
+		clientMetadataDto
+		ClientID string `json:"client_id"`
+	}
+	err := c.ShouldBindJSON(&input)
+	if err != nil {
+		writeRegistrationError(c, errInvalidClientMetadata("The request body is not valid client metadata"))
+		return
+	}
+	// The client ID in the body must match the one of the registration, as defined by RFC 7592 section 2.2
+	if input.ClientID != c.Param("id") {
+		writeRegistrationError(c, errInvalidClientMetadata("The client_id doesn't match the registration"))
+		return
+	}
+
+	info, err := h.service.UpdateRegisteredClient(c.Request.Context(), c.Param("id"), bearerToken(c), input.clientMetadataDto)
+	if err != nil {
+		writeRegistrationError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// deleteRegisteredClient godoc
+// @Summary Delete a client registration
+// @Description Delete a dynamically registered client, as defined by RFC 7592. The request must be authorized with the registration access token as bearer token.
+// @Tags OIDC
+// @Param id path string true "Client ID"
+// @Success 204 "No Content"
+// @Router /api/oidc/register/{id} [delete]
+func (h *handler) deleteRegisteredClient(c *gin.Context) {
+	err := h.service.DeleteRegisteredClient(c.Request.Context(), c.Param("id"), bearerToken(c))
+	if err != nil {
+		writeRegistrationError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// bearerToken returns the token of a bearer Authorization header, as defined by RFC 6750 section 2.1
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return token
+}
+
+func writeRegistrationError(c *gin.Context, err error) {
+	regErr, ok := errors.AsType[*registrationError](err)
+	if !ok {
+		// Other errors are handled by the error middleware
+		_ = c.Error(err)
+		return
+	}
+
+	if regErr.status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", error_description="%s"`, regErr.Code, regErr.Description))
+	}
+	c.JSON(regErr.status, regErr)
+}
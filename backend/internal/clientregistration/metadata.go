@@ -0,0 +1,282 @@
+package clientregistration
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/oidc"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+const (
+	authMethodNone              = "none"
+	authMethodClientSecretBasic = "client_secret_basic"
+	authMethodClientSecretPost  = "client_secret_post"
+
+	defaultClientName = "Dynamically registered client"
+)
+
+// metadataFields maps the fields of the client DTO to the client metadata they're set from, to report validation errors with the registered names
+var metadataFields = map[string]string{
+	"name":                           "client_name",
+	"callbackURLs":                   "redirect_uris",
+	"logoutCallbackURLs":             "post_logout_redirect_uris",
+	"launchURL":                      "client_uri",
+	"jwks":                           "jwks",
+	"jwksUri":                        "jwks_uri",
+	"backchannelLogoutURI":           "backchannel_logout_uri",
+	"frontchannelLogoutURI":          "frontchannel_logout_uri",
+	"authorizationSignedResponseAlg": "authorization_signed_response_alg",
+	"userinfoSignedResponseAlg":      "userinfo_signed_response_alg",
+	"idTokenSignedResponseAlg":       "id_token_signed_response_alg",
+	"subjectType":                    "subject_type",
+	"sectorIdentifierUri":            "sector_identifier_uri",
+	"idTokenEncryptedResponseAlg":    "id_token_encrypted_response_alg",
+	"idTokenEncryptedResponseEnc":    "id_token_encrypted_response_enc",
+	"userinfoEncryptedResponseAlg":   "userinfo_encrypted_response_alg",
+	"userinfoEncryptedResponseEnc":   "userinfo_encrypted_response_enc",
+}
+
+// clientCreateDtoFromMetadata converts the metadata a client registered with to a client, with the same validation as the clients created by an admin
+// Dynamically registered clients get stricter defaults: PKCE is always enabled, and the user is always asked for consent
+func clientCreateDtoFromMetadata(metadata clientMetadataDto, signer oidc.TokenSigner) (dto.OidcClientCreateDto, []string, error) {
+	var isPublic bool
+	switch metadata.TokenEndpointAuthMethod {
+	case authMethodNone:
+		isPublic = true
+	case "", authMethodClientSecretBasic, authMethodClientSecretPost:
+		isPublic = false
+	default:
+		return dto.OidcClientCreateDto{}, nil, errInvalidClientMetadata(fmt.Sprintf("The token_endpoint_auth_method %q is not supported", metadata.TokenEndpointAuthMethod))
+	}
+
+	grantTypes := metadata.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{service.GrantTypeAuthorizationCode}
+	}
+	for _, grantType := range grantTypes {
+		switch grantType {
+		case service.GrantTypeAuthorizationCode, service.GrantTypeRefreshToken, service.GrantTypeDeviceCode:
+		case service.GrantTypeClientCredentials:
+			if isPublic {
+				return dto.OidcClientCreateDto{}, nil, errInvalidClientMetadata("The client_credentials grant type requires a confidential client")
+			}
+		default:
+			return dto.OidcClientCreateDto{}, nil, errInvalidClientMetadata(fmt.Sprintf("The grant type %q is not supported", grantType))
+		}
+	}
+
+	// The authorization code flow is the only one with a response type, so the response types must be consistent with it, as defined by RFC 7591 section 2.1
+	usesAuthorizationCode := slices.Contains(grantTypes, service.GrantTypeAuthorizationCode)
+	for _, responseType := range metadata.ResponseTypes {
+		if responseType != "code" {
+			return dto.OidcClientCreateDto{}, nil, errInvalidClientMetadata(fmt.Sprintf("The response type %q is not supported", responseType))
+		}
+		if !usesAuthorizationCode {
+			return dto.OidcClientCreateDto{}, nil, errInvalidClientMetadata("The code response type requires the authorization_code grant type")
+		}
+	}
+
+	if usesAuthorizationCode && len(metadata.RedirectURIs) == 0 {
+		return dto.OidcClientCreateDto{}, nil, errInvalidRedirectURI("At least one redirect URI is required for the authorization_code grant type")
+	}
+	// Wildcards are only supported in the callback URLs configured by an admin
+	for _, redirectURI := range metadata.RedirectURIs {
+		if strings.Contains(redirectURI, "*") {
+			return dto.OidcClientCreateDto{}, nil, errInvalidRedirectURI("Redirect URIs must not contain wildcards")
+		}
+	}
+	for _, redirectURI := range metadata.PostLogoutRedirectURIs {
+		if strings.Contains(redirectURI, "*") {
+			return dto.OidcClientCreateDto{}, nil, errInvalidClientMetadata("Post logout redirect URIs must not contain wildcards")
+		}
+	}
+
+	input := dto.OidcClientCreateDto{
+		OidcClientUpdateDto: dto.OidcClientUpdateDto{
+			Name:                                cmp.Or(metadata.ClientName, defaultClientName),
+			CallbackURLs:                        metadata.RedirectURIs,
+			LogoutCallbackURLs:                  metadata.PostLogoutRedirectURIs,
+			IsPublic:                            isPublic,
+			PkceEnabled:                         true,
+			RequiresPushedAuthorizationRequests: metadata.RequirePushedAuthorizationRequests,
+			RequiresDpop:                        metadata.DPoPBoundAccessTokens,
+			RequiresSignedRequestObject:         metadata.RequireSignedRequestObject,
+			Credentials: dto.OidcClientCredentialsDto{
+				JWKS:    string(metadata.JWKS),
+				JWKSURI: metadata.JWKSURI,
+			},
+			LaunchURL:                         optionalString(metadata.ClientURI),
+			LogoURL:                           optionalString(metadata.LogoURI),
+			BackchannelLogoutURI:              optionalString(metadata.BackchannelLogoutURI),
+			BackchannelLogoutSessionRequired:  metadata.BackchannelLogoutSessionRequired,
+			FrontchannelLogoutURI:             optionalString(metadata.FrontchannelLogoutURI),
+			FrontchannelLogoutSessionRequired: metadata.FrontchannelLogoutSessionRequired,
+			AuthorizationSignedResponseAlg:    metadata.AuthorizationSignedResponseAlg,
+			UserinfoSignedResponseAlg:         metadata.UserinfoSignedResponseAlg,
+			IDTokenSignedResponseAlg:          metadata.IDTokenSignedResponseAlg,
+			SubjectType:                       metadata.SubjectType,
+			SectorIdentifierURI:               optionalString(metadata.SectorIdentifierURI),
+			IDTokenEncryptedResponseAlg:       metadata.IDTokenEncryptedResponseAlg,
+			IDTokenEncryptedResponseEnc:       metadata.IDTokenEncryptedResponseEnc,
+			UserinfoEncryptedResponseAlg:      metadata.UserinfoEncryptedResponseAlg,
+			UserinfoEncryptedResponseEnc:      metadata.UserinfoEncryptedResponseEnc,
+		},
+	}
+
+	err := input.Validate()
+	if err != nil {
+		return dto.OidcClientCreateDto{}, nil, metadataValidationError(err)
+	}
+	err = validateSigningAlgs(metadata, signer)
+	if err != nil {
+		return dto.OidcClientCreateDto{}, nil, err
+	}
+
+	return input, grantTypes, nil
+}
+
+// validateSigningAlgs rejects the signing algorithms none of Pocket ID's active signing keys can be used with
+func validateSigningAlgs(metadata clientMetadataDto, signer oidc.TokenSigner) error {
+	algs := []struct {
+		name      string
+		value     string
+		supported func(oidc.TokenSigner) []string
+	}{
+		{name: "authorization_signed_response_alg", value: metadata.AuthorizationSignedResponseAlg, supported: oidc.AuthorizationSigningAlgValuesSupported},
+		{name: "userinfo_signed_response_alg", value: metadata.UserinfoSignedResponseAlg, supported: oidc.UserinfoSigningAlgValuesSupported},
+		{name: "id_token_signed_response_alg", value: metadata.IDTokenSignedResponseAlg, supported: oidc.IDTokenSigningAlgValuesSupported},
+	}
+	for _, alg := range algs {
+		if alg.value == "" {
+			continue
+		}
+		if signer == nil || !slices.Contains(alg.supported(signer), alg.value) {
+			return errInvalidClientMetadata(fmt.Sprintf("The %s %q is not supported", alg.name, alg.value))
+		}
+	}
+	return nil
+}
+
+// validateMetadataURLs rejects the URLs Pocket ID sends requests to that resolve to private IP addresses, as anyone with an initial access token could otherwise make it reach internal services
+// The requests themselves check every address again, and the logo and sector identifier URIs are checked when they're downloaded
+func validateMetadataURLs(ctx context.Context, metadata clientMetadataDto) error {
+	urls := []struct {
+		name  string
+		value string
+	}{
+		{name: "jwks_uri", value: metadata.JWKSURI},
+		{name: "backchannel_logout_uri", value: metadata.BackchannelLogoutURI},
+	}
+	for _, u := range urls {
+		if u.value == "" {
+			continue
+		}
+		parsed, err := url.Parse(u.value)
+		if err != nil {
+			return errInvalidClientMetadata(fmt.Sprintf("The value of %s is invalid", u.name))
+		}
+		private, err := utils.IsURLPrivate(ctx, parsed)
+		if err != nil {
+			return errInvalidClientMetadata(fmt.Sprintf("The host of %s could not be resolved", u.name))
+		} else if private {
+			return errInvalidClientMetadata(fmt.Sprintf("The %s must not point to a private IP address", u.name))
+		}
+	}
+	return nil
+}
+
+func metadataValidationError(err error) error {
+	validationErrors, ok := errors.AsType[validator.ValidationErrors](err)
+	if !ok || len(validationErrors) == 0 {
+		return errInvalidClientMetadata("The client metadata is invalid")
+	}
+
+	// Only the first error is reported, as the registration error has a single description
+	// Nested fields are reported as e.g. "callbackURLs[0]" or "credentials.jwks", so only the last segment without the index is used
+	field := validationErrors[0].Namespace()
+	field = field[strings.LastIndex(field, ".")+1:]
+	field, _, _ = strings.Cut(field, "[")
+	name, ok := metadataFields[field]
+	if !ok {
+		return errInvalidClientMetadata("The client metadata is invalid")
+	}
+
+	if name == "redirect_uris" {
+		return errInvalidRedirectURI("The value of redirect_uris is invalid")
+	}
+	return errInvalidClientMetadata(fmt.Sprintf("The value of %s is invalid", name))
+}
+
+// metadataFromClient returns the metadata of a registered client, as returned by the registration endpoints
+func metadataFromClient(client model.OidcClient) clientMetadataDto {
+	authMethod := authMethodClientSecretBasic
+	if client.IsPublic {
+		authMethod = authMethodNone
+	}
+
+	grantTypes := []string(client.MetadataGrantTypes)
+	if len(grantTypes) == 0 {
+		grantTypes = []string{service.GrantTypeAuthorizationCode}
+	}
+	var responseTypes []string
+	if slices.Contains(grantTypes, service.GrantTypeAuthorizationCode) {
+		responseTypes = []string{"code"}
+	}
+
+	metadata := clientMetadataDto{
+		RedirectURIs:                       client.CallbackURLs,
+		TokenEndpointAuthMethod:            authMethod,
+		GrantTypes:                         grantTypes,
+		ResponseTypes:                      responseTypes,
+		ClientName:                         client.Name,
+		JWKSURI:                            client.Credentials.JWKSURI,
+		PostLogoutRedirectURIs:             client.LogoutCallbackURLs,
+		BackchannelLogoutSessionRequired:   client.BackchannelLogoutSessionRequired,
+		FrontchannelLogoutSessionRequired:  client.FrontchannelLogoutSessionRequired,
+		SubjectType:                        string(client.SubjectType),
+		AuthorizationSignedResponseAlg:     client.AuthorizationSignedResponseAlg,
+		UserinfoSignedResponseAlg:          client.UserinfoSignedResponseAlg,
+		IDTokenSignedResponseAlg:           client.IDTokenSignedResponseAlg,
+		IDTokenEncryptedResponseAlg:        client.IDTokenEncryptedResponseAlg,
+		IDTokenEncryptedResponseEnc:        client.IDTokenEncryptedResponseEnc,
+		UserinfoEncryptedResponseAlg:       client.UserinfoEncryptedResponseAlg,
+		UserinfoEncryptedResponseEnc:       client.UserinfoEncryptedResponseEnc,
+		RequirePushedAuthorizationRequests: client.RequiresPushedAuthorizationRequests,
+		DPoPBoundAccessTokens:              client.RequiresDpop,
+		RequireSignedRequestObject:         client.RequiresSignedRequestObject,
+	}
+	if client.Credentials.JWKS != "" {
+		metadata.JWKS = []byte(client.Credentials.JWKS)
+	}
+	if client.LaunchURL != nil {
+		metadata.ClientURI = *client.LaunchURL
+	}
+	if client.BackchannelLogoutURI != nil {
+		metadata.BackchannelLogoutURI = *client.BackchannelLogoutURI
+	}
+	if client.FrontchannelLogoutURI != nil {
+		metadata.FrontchannelLogoutURI = *client.FrontchannelLogoutURI
+	}
+	if client.SectorIdentifierURI != nil {
+		metadata.SectorIdentifierURI = *client.SectorIdentifierURI
+	}
+	return metadata
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,39 @@
+package clientregistration
+
+import (
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// InitialAccessToken is issued by an admin and authorizes the dynamic registration of OIDC clients, as defined by RFC 7591
+type InitialAccessToken struct {
+	model.Base
+
+	Name        string `sortable:"true"`
+	Token       string
+	Description *string
+	ExpiresAt   datatype.DateTime  `sortable:"true"`
+	LastUsedAt  *datatype.DateTime `sortable:"true"`
+	UsageLimit  int                `sortable:"true"`
+	UsageCount  int                `sortable:"true"`
+	CreatedByID *string
+
+	// UserGroups are the groups whose members may use the clients registered with the token
+	UserGroups []model.UserGroup `gorm:"many2many:oidc_initial_access_tokens_user_groups;"`
+}
+
+func (InitialAccessToken) TableName() string {
+	return "oidc_initial_access_tokens"
+}
+
+// ClientRegistration holds the registration access token a dynamically registered client manages its registration with, as defined by RFC 7592
+type ClientRegistration struct {
+	ClientID                string `gorm:"primaryKey"`
+	CreatedAt               datatype.DateTime
+	RegistrationAccessToken string
+	InitialAccessTokenID    *string
+}
+
+func (ClientRegistration) TableName() string {
+	return "oidc_client_registrations"
+}
@@ -0,0 +1,59 @@
+package clientregistration
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/httpserver"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/oidc"
+)
+
+// ClientManager creates and manages the registered clients
+// It is implemented by the OIDC service, so the registered clients get the same validation as the clients created by an admin
+type ClientManager interface {
+	GetClient(ctx context.Context, clientID string) (model.OidcClient, error)
+	CreateDynamicClient(ctx context.Context, input dto.OidcClientCreateDto, grantTypes []string, userGroupIDs []string, createdByID *string) (model.OidcClient, error)
+	UpdateDynamicClient(ctx context.Context, clientID string, input dto.OidcClientUpdateDto, grantTypes []string) (model.OidcClient, error)
+	DeleteClient(ctx context.Context, clientID string) error
+	CreateClientSecret(ctx context.Context, clientID string, input dto.OidcClientSecretCreateDto) (model.OidcClientSecret, string, error)
+}
+
+type Dependencies struct {
+	DB      *gorm.DB
+	Clients ClientManager
+	// Signer holds the signing keys, which the signing algorithms a client registers must be usable with
+	Signer oidc.TokenSigner
+	// BaseURL is the URL the registration client URIs are built from
+	BaseURL string
+}
+
+type Module struct {
+	service *Service
+	handler *handler
+}
+
+func New(deps Dependencies) *Module {
+	service := newService(deps.DB, deps.Clients, deps.Signer, deps.BaseURL)
+	return &Module{
+		service: service,
+		handler: newHandler(service),
+	}
+}
+
+// RegisterRoutes mounts the dynamic client registration endpoints and the management of the initial access tokens
+// adminAuth guards the token-management routes; the registration endpoints authenticate with their own bearer tokens and are throttled by registrationRateLimit
+func (m *Module) RegisterRoutes(apiGroup *gin.RouterGroup, adminAuth, registrationRateLimit gin.HandlerFunc) {
+	apiGroup.GET("/oidc/initial-access-tokens", adminAuth, httpserver.Handle(m.handler.listInitialAccessTokens))
+	apiGroup.POST("/oidc/initial-access-tokens", adminAuth, httpserver.Handle(m.handler.createInitialAccessToken))
+	apiGroup.DELETE("/oidc/initial-access-tokens/:id", adminAuth, httpserver.Handle(m.handler.deleteInitialAccessToken))
+
+	group := apiGroup.Group("/oidc/register", registrationRateLimit)
+	group.POST("", m.handler.registerClient)
+	group.GET("/:id", m.handler.getRegisteredClient)
+	group.PUT("/:id", m.handler.updateRegisteredClient)
+	group.DELETE("/:id", m.handler.deleteRegisteredClient)
+}
@@ -0,0 +1,385 @@
+package clientregistration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/oidc"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// Service holds the business logic for the dynamic registration of OIDC clients
+type Service struct {
+	db      *gorm.DB
+	clients ClientManager
+	signer  oidc.TokenSigner
+	baseURL string
+}
+
+func newService(db *gorm.DB, clients ClientManager, signer oidc.TokenSigner, baseURL string) *Service {
+	return &Service{
+		db:      db,
+		clients: clients,
+		signer:  signer,
+		baseURL: baseURL,
+	}
+}
+
+func (s *Service) ListInitialAccessTokens(ctx context.Context, listRequestOptions utils.ListRequestOptions) ([]InitialAccessToken, utils.PaginationResponse, error) {
+	query := s.db.
+		WithContext(ctx).
+		Preload("UserGroups").
+		Model(&InitialAccessToken{})
+
+	var tokens []InitialAccessToken
+	pagination, err := utils.PaginateFilterAndSort(listRequestOptions, query, &tokens)
+	if err != nil {
+		return nil, utils.PaginationResponse{}, fmt.Errorf("error listing initial access tokens: %w", err)
+	}
+
+	return tokens, pagination, nil
+}
+
+func (s *Service) CreateInitialAccessToken(ctx context.Context, userID string, input initialAccessTokenCreateDto) (InitialAccessToken, string, error) {
+	// Check if expiration is in the future
+	if !input.ExpiresAt.ToTime().After(time.Now()) {
+		return InitialAccessToken{}, "", apperror.ValidationMessage("The expiration date of an initial access token must be in the future")
+	}
+
+	var userGroups []model.UserGroup
+	if len(input.UserGroupIDs) > 0 {
+		err := s.db.
+			WithContext(ctx).
+			Where("id IN ?", input.UserGroupIDs).
+			Find(&userGroups).
+			Error
+		if err != nil {
+			return InitialAccessToken{}, "", fmt.Errorf("error loading user groups: %w", err)
+		}
+	}
+
+	token, err := utils.GenerateRandomAlphanumericString(32)
+	if err != nil {
+		return InitialAccessToken{}, "", fmt.Errorf("error generating initial access token: %w", err)
+	}
+
+	initialAccessToken := InitialAccessToken{
+		Name:        input.Name,
+		Token:       utils.CreateSha256Hash(token), // Hash the token for storage
+		Description: input.Description,
+		ExpiresAt:   input.ExpiresAt,
+		UsageLimit:  input.UsageLimit,
+		CreatedByID: new(userID),
+		UserGroups:  userGroups,
+	}
+
+	err = s.db.
+		WithContext(ctx).
+		Create(&initialAccessToken).
+		Error
+	if err != nil {
+		return InitialAccessToken{}, "", fmt.Errorf("error creating initial access token: %w", err)
+	}
+
+	// Return the raw token only once - it cannot be retrieved later
+	return initialAccessToken, token, nil
+}
+
+func (s *Service) DeleteInitialAccessToken(ctx context.Context, tokenID string) error {
+	result := s.db.
+		WithContext(ctx).
+		Where("id = ?", tokenID).
+		Delete(&InitialAccessToken{})
+	if result.Error != nil {
+		return fmt.Errorf("error deleting initial access token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.NotFound("initial access token")
+	}
+
+	return nil
+}
+
+// RegisterClient creates a client from the metadata it registered with, as defined by RFC 7591 section 3
+// The registration must be authorized by an initial access token, whose user groups are the only ones allowed to use the client
+func (s *Service) RegisterClient(ctx context.Context, initialAccessToken string, metadata clientMetadataDto) (clientInformationDto, error) {
+	now := time.Now()
+	const usableCondition = "expires_at > ? AND (usage_limit = 0 OR usage_count < usage_limit)"
+
+	var token InitialAccessToken
+	err := s.db.
+		WithContext(ctx).
+		Preload("UserGroups").
+		Where("token = ? AND "+usableCondition, utils.CreateSha256Hash(initialAccessToken), datatype.DateTime(now)).
+		First(&token).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return clientInformationDto{}, errInvalidToken("The initial access token is invalid or expired")
+	} else if err != nil {
+		return clientInformationDto{}, fmt.Errorf("error loading initial access token: %w", err)
+	}
+
+	input, grantTypes, err := clientCreateDtoFromMetadata(metadata, s.signer)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+	err = validateMetadataURLs(ctx, metadata)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+	input.IsGroupRestricted = true
+
+	// The conditions are checked again, so concurrent registrations can't exceed the usage limit of the token
+	result := s.db.
+		WithContext(ctx).
+		Model(&InitialAccessToken{}).
+		Where("id = ? AND "+usableCondition, token.ID, datatype.DateTime(now)).
+		Updates(map[string]any{
+			"usage_count":  gorm.Expr("usage_count + 1"),
+			"last_used_at": datatype.DateTime(now),
+		})
+	if result.Error != nil {
+		return clientInformationDto{}, fmt.Errorf("error updating initial access token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return clientInformationDto{}, errInvalidToken("The initial access token is invalid or expired")
+	}
+
+	userGroupIDs := make([]string, len(token.UserGroups))
+	for i, group := range token.UserGroups {
+		userGroupIDs[i] = group.ID
+	}
+
+	client, err := s.clients.CreateDynamicClient(ctx, input, grantTypes, userGroupIDs, token.CreatedByID)
+	if err != nil {
+		// The client is already stored if only downloading its logo failed
+		s.deleteFailedRegistration(ctx, client.ID, token.ID)
+		return clientInformationDto{}, registrationErrorFromAppError(err)
+	}
+
+	var clientSecret string
+	if !client.IsPublic {
+		_, clientSecret, err = s.clients.CreateClientSecret(ctx, client.ID, dto.OidcClientSecretCreateDto{})
+		if err != nil {
+			s.deleteFailedRegistration(ctx, client.ID, token.ID)
+			return clientInformationDto{}, fmt.Errorf("error creating client secret: %w", err)
+		}
+	}
+
+	registrationAccessToken, err := utils.GenerateRandomAlphanumericString(32)
+	if err != nil {
+		s.deleteFailedRegistration(ctx, client.ID, token.ID)
+		return clientInformationDto{}, fmt.Errorf("error generating registration access token: %w", err)
+	}
+
+	registration := ClientRegistration{
+		ClientID:                client.ID,
+		CreatedAt:               datatype.DateTime(now),
+		RegistrationAccessToken: utils.CreateSha256Hash(registrationAccessToken),
+		InitialAccessTokenID:    &token.ID,
+	}
+	err = s.db.
+		WithContext(ctx).
+		Create(&registration).
+		Error
+	if err != nil {
+		s.deleteFailedRegistration(ctx, client.ID, token.ID)
+		return clientInformationDto{}, fmt.Errorf("error creating client registration: %w", err)
+	}
+
+	info := s.clientInformation(client, registration, registrationAccessToken)
+	if clientSecret != "" {
+		info.ClientSecret = clientSecret
+		// Client secrets issued at registration don't expire
+		info.ClientSecretExpiresAt = new(int64(0))
+	}
+	return info, nil
+}
+
+// GetRegisteredClient returns the current registration of a client, as defined by RFC 7592 section 2.1
+// The registration access token is kept, so a client that doesn't receive the response can still read its registration again
+func (s *Service) GetRegisteredClient(ctx context.Context, clientID string, registrationAccessToken string) (clientInformationDto, error) {
+	registration, err := s.authenticateRegistration(ctx, clientID, registrationAccessToken)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+
+	client, err := s.clients.GetClient(ctx, clientID)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+
+	return s.clientInformation(client, registration, ""), nil
+}
+
+// UpdateRegisteredClient replaces the metadata of a registered client, as defined by RFC 7592 section 2.2
+// Settings that are managed by the admins, like the allowed user groups or the token lifetimes, are kept
+func (s *Service) UpdateRegisteredClient(ctx context.Context, clientID string, registrationAccessToken string, metadata clientMetadataDto) (clientInformationDto, error) {
+	registration, err := s.authenticateRegistration(ctx, clientID, registrationAccessToken)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+
+	existing, err := s.clients.GetClient(ctx, clientID)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+
+	input, grantTypes, err := clientCreateDtoFromMetadata(metadata, s.signer)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+	err = validateMetadataURLs(ctx, metadata)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+	// Changing between a public and a confidential client would require issuing or revoking its secrets
+	if input.IsPublic != existing.IsPublic {
+		return clientInformationDto{}, errInvalidClientMetadata("The token_endpoint_auth_method of a registered client can't be changed")
+	}
+
+	update := input.OidcClientUpdateDto
+	jwks, jwksURI := update.Credentials.JWKS, update.Credentials.JWKSURI
+	err = dto.MapStruct(existing.Credentials, &update.Credentials)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+	update.Credentials.JWKS = jwks
+	update.Credentials.JWKSURI = jwksURI
+	err = dto.MapStruct(existing.ClaimPolicy, &update.ClaimPolicy)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+	update.Description = existing.Description
+	update.PkceEnabled = existing.PkceEnabled
+	update.SkipConsent = existing.SkipConsent
+	update.RequiresReauthentication = existing.RequiresReauthentication
+	update.MinimumACR = existing.MinimumACR
+	update.IsGroupRestricted = existing.IsGroupRestricted
+	update.AccessTokenDurationMinutes = existing.AccessTokenDurationMinutes
+	update.RefreshTokenDurationMinutes = existing.RefreshTokenDurationMinutes
+	update.RefreshTokenRequiresOfflineAccess = existing.RefreshTokenRequiresOfflineAccess
+	update.RefreshTokenMaxLifetimeMinutes = existing.RefreshTokenMaxLifetimeMinutes
+	update.RefreshTokenReuseGraceSeconds = existing.RefreshTokenReuseGraceSeconds
+	update.ConsentLifetimeMinutes = existing.ConsentLifetimeMinutes
+	update.BackchannelTokenDeliveryMode = string(existing.BackchannelTokenDeliveryMode)
+	update.BackchannelClientNotificationEndpoint = existing.BackchannelClientNotificationEndpoint
+
+	client, err := s.clients.UpdateDynamicClient(ctx, clientID, update, grantTypes)
+	if err != nil {
+		return clientInformationDto{}, registrationErrorFromAppError(err)
+	}
+
+	newToken, err := s.rotateRegistrationAccessToken(ctx, registration)
+	if err != nil {
+		return clientInformationDto{}, err
+	}
+
+	return s.clientInformation(client, registration, newToken), nil
+}
+
+// DeleteRegisteredClient deletes a registered client, as defined by RFC 7592 section 2.3
+func (s *Service) DeleteRegisteredClient(ctx context.Context, clientID string, registrationAccessToken string) error {
+	_, err := s.authenticateRegistration(ctx, clientID, registrationAccessToken)
+	if err != nil {
+		return err
+	}
+
+	// The registration is deleted together with the client
+	return s.clients.DeleteClient(ctx, clientID)
+}
+
+func (s *Service) authenticateRegistration(ctx context.Context, clientID string, registrationAccessToken string) (ClientRegistration, error) {
+	if registrationAccessToken == "" {
+		return ClientRegistration{}, errInvalidToken("The registration access token is missing")
+	}
+
+	var registration ClientRegistration
+	err := s.db.
+		WithContext(ctx).
+		Where("client_id = ? AND registration_access_token = ?", clientID, utils.CreateSha256Hash(registrationAccessToken)).
+		First(&registration).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Unknown clients are reported the same way as invalid tokens, so the endpoint can't be used to discover client IDs
+		return ClientRegistration{}, errInvalidToken("The registration access token is invalid")
+	} else if err != nil {
+		return ClientRegistration{}, fmt.Errorf("error loading client registration: %w", err)
+	}
+
+	return registration, nil
+}
+
+// rotateRegistrationAccessToken replaces the registration access token after it was used to update the registration, as allowed by RFC 7592 section 3
+func (s *Service) rotateRegistrationAccessToken(ctx context.Context, registration ClientRegistration) (string, error) {
+	token, err := utils.GenerateRandomAlphanumericString(32)
+	if err != nil {
+		return "", fmt.Errorf("error generating registration access token: %w", err)
+	}
+
+	// The previous token is matched, so only one of concurrent requests with the same token gets the new one
+	result := s.db.
+		WithContext(ctx).
+		Model(&ClientRegistration{}).
+		Where("client_id = ? AND registration_access_token = ?", registration.ClientID, registration.RegistrationAccessToken).
+		Update("registration_access_token", utils.CreateSha256Hash(token))
+	if result.Error != nil {
+		return "", fmt.Errorf("error updating registration access token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return "", errInvalidToken("The registration access token is invalid")
+	}
+
+	return token, nil
+}
+
+// deleteFailedRegistration deletes the client of a failed registration, if it was stored already, and gives back the use of the initial access token it counted against
+func (s *Service) deleteFailedRegistration(ctx context.Context, clientID string, initialAccessTokenID string) {
+	ctx = context.WithoutCancel(ctx)
+
+	if clientID != "" {
+		err := s.clients.DeleteClient(ctx, clientID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to delete the client of a failed registration", slog.String("client_id", clientID), slog.Any("error", err))
+		}
+	}
+
+	err := s.db.
+		WithContext(ctx).
+		Model(&InitialAccessToken{}).
+		Where("id = ? AND usage_count > 0", initialAccessTokenID).
+		Update("usage_count", gorm.Expr("usage_count - 1")).
+		Error
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to give back the use of the initial access token of a failed registration", slog.String("initial_access_token_id", initialAccessTokenID), slog.Any("error", err))
+	}
+}
+
+func (s *Service) clientInformation(client model.OidcClient, registration ClientRegistration, registrationAccessToken string) clientInformationDto {
+	return clientInformationDto{
+		ClientID:                client.ID,
+		ClientIDIssuedAt:        registration.CreatedAt.ToTime().Unix(),
+		RegistrationAccessToken: registrationAccessToken,
+		RegistrationClientURI:   s.baseURL + "/api/oidc/register/" + client.ID,
+		clientMetadataDto:       metadataFromClient(client),
+	}
+}
+
+// registrationErrorFromAppError reports the validation errors of the OIDC service as invalid client metadata
+func registrationErrorFromAppError(err error) error {
+	appErr, ok := errors.AsType[*apperror.Error](err)
+	if !ok || appErr.HTTPStatus() != http.StatusBadRequest {
+		return err
+	}
+	return errInvalidClientMetadata(appErr.ClientMessage())
+}
@@ -0,0 +1,267 @@
+package clientregistration
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func newTestService(t *testing.T) (*Service, string) {
+	t.Helper()
+
+	db := testutils.NewDatabaseForTest(t)
+	oidcService, err := service.NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	admin := model.User{Base: model.Base{ID: "admin-id"}, Username: "admin", IsAdmin: true}
+	require.NoError(t, db.Create(&admin).Error)
+	group := model.UserGroup{Base: model.Base{ID: "group-id"}, Name: "developers", FriendlyName: "Developers"}
+	require.NoError(t, db.Create(&group).Error)
+
+	return newService(db, oidcService, nil, "https://pocket-id.example.com"), admin.ID
+}
+
+func createInitialAccessToken(t *testing.T, s *Service, userID string, usageLimit int) string {
+	t.Helper()
+
+	_, token, err := s.CreateInitialAccessToken(t.Context(), userID, initialAccessTokenCreateDto{
+		Name:         "Test token",
+		ExpiresAt:    datatype.DateTime(time.Now().Add(time.Hour)),
+		UsageLimit:   usageLimit,
+		UserGroupIDs: []string{"group-id"},
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func requireRegistrationError(t *testing.T, err error, code string) {
+	t.Helper()
+
+	regErr, ok := errors.AsType[*registrationError](err)
+	require.Truef(t, ok, "expected a registration error, got %v", err)
+	assert.Equal(t, code, regErr.Code)
+}
+
+func TestRegisterClient(t *testing.T) {
+	s, adminID := newTestService(t)
+	token := createInitialAccessToken(t, s, adminID, 1)
+
+	info, err := s.RegisterClient(t.Context(), token, clientMetadataDto{
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		ClientName:   "My app",
+		GrantTypes:   []string{service.GrantTypeAuthorizationCode, service.GrantTypeRefreshToken},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, info.ClientSecret)
+	assert.NotEmpty(t, info.RegistrationAccessToken)
+	assert.Equal(t, "https://pocket-id.example.com/api/oidc/register/"+info.ClientID, info.RegistrationClientURI)
+	assert.Equal(t, authMethodClientSecretBasic, info.TokenEndpointAuthMethod)
+	assert.Equal(t, []string{"code"}, info.ResponseTypes)
+
+	var client model.OidcClient
+	require.NoError(t, s.db.Preload("AllowedUserGroups").First(&client, "id = ?", info.ClientID).Error)
+	assert.Equal(t, model.OidcClientTypeDynamic, client.ClientType)
+	assert.Equal(t, "My app", client.Name)
+	assert.True(t, client.PkceEnabled)
+	assert.False(t, client.SkipConsent)
+	assert.True(t, client.IsGroupRestricted)
+	require.Len(t, client.AllowedUserGroups, 1)
+	assert.Equal(t, "group-id", client.AllowedUserGroups[0].ID)
+	assert.Equal(t, adminID, *client.CreatedByID)
+	assert.Len(t, client.Credentials.Secrets, 1)
+
+	t.Run("usage limit of the initial access token is enforced", func(t *testing.T) {
+		_, err := s.RegisterClient(t.Context(), token, clientMetadataDto{
+			RedirectURIs: []string{"https://app.example.com/callback"},
+		})
+		requireRegistrationError(t, err, "invalid_token")
+	})
+}
+
+func TestRegisterClient_invalidRequests(t *testing.T) {
+	s, adminID := newTestService(t)
+	token := createInitialAccessToken(t, s, adminID, 0)
+
+	tests := []struct {
+		name     string
+		token    string
+		metadata clientMetadataDto
+		code     string
+	}{
+		{
+			name:     "unknown initial access token",
+			token:    "unknown",
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}},
+			code:     "invalid_token",
+		},
+		{
+			name:     "missing redirect URIs",
+			token:    token,
+			metadata: clientMetadataDto{},
+			code:     "invalid_redirect_uri",
+		},
+		{
+			name:     "wildcard redirect URI",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://*.example.com/callback"}},
+			code:     "invalid_redirect_uri",
+		},
+		{
+			name:     "unsupported grant type",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, GrantTypes: []string{"implicit"}},
+			code:     "invalid_client_metadata",
+		},
+		{
+			name:  "client credentials for a public client",
+			token: token,
+			metadata: clientMetadataDto{
+				TokenEndpointAuthMethod: authMethodNone,
+				GrantTypes:              []string{service.GrantTypeClientCredentials},
+			},
+			code: "invalid_client_metadata",
+		},
+		{
+			name:     "unsupported authentication method",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, TokenEndpointAuthMethod: "private_key_jwt"},
+			code:     "invalid_client_metadata",
+		},
+		{
+			name:     "invalid client URI",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, ClientURI: "not a URL"},
+			code:     "invalid_client_metadata",
+		},
+		{
+			name:     "unsupported ID token encryption algorithm",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, IDTokenEncryptedResponseAlg: "RSA1_5"},
+			code:     "invalid_client_metadata",
+		},
+		{
+			name:     "ID token signing algorithm without a signing key",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, IDTokenSignedResponseAlg: "ES256"},
+			code:     "invalid_client_metadata",
+		},
+		{
+			name:     "JWKS URI on a private IP address",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, JWKSURI: "https://10.0.0.1/jwks.json"},
+			code:     "invalid_client_metadata",
+		},
+		{
+			name:     "backchannel logout URI on a private IP address",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, BackchannelLogoutURI: "https://127.0.0.1/logout"},
+			code:     "invalid_client_metadata",
+		},
+		{
+			name:     "userinfo encryption without keys",
+			token:    token,
+			metadata: clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}, UserinfoEncryptedResponseAlg: "RSA-OAEP-256"},
+			code:     "invalid_client_metadata",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.RegisterClient(t.Context(), test.token, test.metadata)
+			requireRegistrationError(t, err, test.code)
+		})
+	}
+
+	t.Run("client is deleted when its logo can't be downloaded", func(t *testing.T) {
+		limitedToken := createInitialAccessToken(t, s, adminID, 1)
+		_, err := s.RegisterClient(t.Context(), limitedToken, clientMetadataDto{
+			RedirectURIs: []string{"https://app.example.com/callback"},
+			LogoURI:      "https://127.0.0.1/logo.png",
+		})
+		requireRegistrationError(t, err, "invalid_client_metadata")
+
+		var count int64
+		require.NoError(t, s.db.Model(&model.OidcClient{}).Where("client_type = ?", model.OidcClientTypeDynamic).Count(&count).Error)
+		assert.Zero(t, count)
+
+		// The failed registration doesn't use up the initial access token
+		_, err = s.RegisterClient(t.Context(), limitedToken, clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}})
+		require.NoError(t, err)
+	})
+
+	t.Run("expired initial access token", func(t *testing.T) {
+		require.NoError(t, s.db.Model(&InitialAccessToken{}).Where("1 = 1").Update("expires_at", datatype.DateTime(time.Now().Add(-time.Minute))).Error)
+		_, err := s.RegisterClient(t.Context(), token, clientMetadataDto{RedirectURIs: []string{"https://app.example.com/callback"}})
+		requireRegistrationError(t, err, "invalid_token")
+	})
+}
+
+func TestClientRegistrationManagement(t *testing.T) {
+	s, adminID := newTestService(t)
+	ctx := t.Context()
+
+	info, err := s.RegisterClient(ctx, createInitialAccessToken(t, s, adminID, 0), clientMetadataDto{
+		RedirectURIs:            []string{"https://app.example.com/callback"},
+		TokenEndpointAuthMethod: authMethodNone,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, info.ClientSecret)
+
+	t.Run("read keeps the registration access token", func(t *testing.T) {
+		read, err := s.GetRegisteredClient(ctx, info.ClientID, info.RegistrationAccessToken)
+		require.NoError(t, err)
+		assert.Empty(t, read.RegistrationAccessToken)
+		assert.Equal(t, []string{"https://app.example.com/callback"}, read.RedirectURIs)
+
+		_, err = s.GetRegisteredClient(ctx, info.ClientID, info.RegistrationAccessToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("update keeps the settings managed by admins", func(t *testing.T) {
+		require.NoError(t, s.db.Model(&model.OidcClient{}).Where("id = ?", info.ClientID).Updates(map[string]any{"access_token_duration_minutes": 5, "minimum_acr": common.ACRDeviceBoundPasskey}).Error)
+
+		updated, err := s.UpdateRegisteredClient(ctx, info.ClientID, info.RegistrationAccessToken, clientMetadataDto{
+			RedirectURIs:            []string{"https://app.example.com/new-callback"},
+			TokenEndpointAuthMethod: authMethodNone,
+			ClientName:              "Renamed app",
+		})
+		require.NoError(t, err)
+		assert.NotEqual(t, info.RegistrationAccessToken, updated.RegistrationAccessToken, "update rotates the registration access token")
+		info.RegistrationAccessToken = updated.RegistrationAccessToken
+
+		var client model.OidcClient
+		require.NoError(t, s.db.Preload("AllowedUserGroups").First(&client, "id = ?", info.ClientID).Error)
+		assert.Equal(t, "Renamed app", client.Name)
+		assert.Equal(t, []string{"https://app.example.com/new-callback"}, []string(client.CallbackURLs))
+		assert.EqualValues(t, 5, client.AccessTokenDurationMinutes)
+		assert.Equal(t, common.ACRDeviceBoundPasskey, client.MinimumACR)
+		assert.True(t, client.IsGroupRestricted)
+		assert.Len(t, client.AllowedUserGroups, 1)
+	})
+
+	t.Run("update can't change the authentication method", func(t *testing.T) {
+		_, err := s.UpdateRegisteredClient(ctx, info.ClientID, info.RegistrationAccessToken, clientMetadataDto{
+			RedirectURIs: []string{"https://app.example.com/callback"},
+		})
+		requireRegistrationError(t, err, "invalid_client_metadata")
+	})
+
+	t.Run("delete removes the client", func(t *testing.T) {
+		require.NoError(t, s.DeleteRegisteredClient(ctx, info.ClientID, info.RegistrationAccessToken))
+
+		var count int64
+		require.NoError(t, s.db.Model(&model.OidcClient{}).Where("id = ?", info.ClientID).Count(&count).Error)
+		assert.Zero(t, count)
+
+		_, err := s.GetRegisteredClient(ctx, info.ClientID, info.RegistrationAccessToken)
+		requireRegistrationError(t, err, "invalid_token")
+	})
+}
@@ -132,6 +132,22 @@ func rotateSigningKeyEncryption(ctx context.Context, db *gorm.DB, oldKek []byte,
 	if key == nil {
 		return nil
 	}
+	additionalKeys, err := oldProvider.LoadAdditionalKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load additional signing keys using old encryption key: %w", err)
+	}
+	nextKey, err := oldProvider.LoadNextKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load next signing key using old encryption key: %w", err)
+	}
+	nextAdditionalKeys, err := oldProvider.LoadNextAdditionalKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load next additional signing keys using old encryption key: %w", err)
+	}
+	retiredKeys, err := oldProvider.LoadRetiredKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load retired signing keys using old encryption key: %w", err)
+	}
 
 	newProvider := &jwkutils.KeyProviderDatabase{}
 	err = newProvider.Init(jwkutils.KeyProviderOpts{
@@ -145,6 +161,26 @@ func rotateSigningKeyEncryption(ctx context.Context, db *gorm.DB, oldKek []byte,
 	if err := newProvider.SaveKey(ctx, key); err != nil {
 		return fmt.Errorf("failed to store signing key with new encryption key: %w", err)
 	}
+	if len(additionalKeys) > 0 {
+		if err := newProvider.SaveAdditionalKeys(ctx, additionalKeys); err != nil {
+			return fmt.Errorf("failed to store additional signing keys with new encryption key: %w", err)
+		}
+	}
+	if nextKey != nil {
+		if err := newProvider.SaveNextKey(ctx, nextKey); err != nil {
+			return fmt.Errorf("failed to store next signing key with new encryption key: %w", err)
+		}
+	}
+	if len(nextAdditionalKeys) > 0 {
+		if err := newProvider.SaveNextAdditionalKeys(ctx, nextAdditionalKeys); err != nil {
+			return fmt.Errorf("failed to store next additional signing keys with new encryption key: %w", err)
+		}
+	}
+	if len(retiredKeys) > 0 {
+		if err := newProvider.SaveRetiredKeys(ctx, retiredKeys); err != nil {
+			return fmt.Errorf("failed to store retired signing keys with new encryption key: %w", err)
+		}
+	}
 
 	return nil
 }
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/spf13/cobra"
 	"gorm.io/gorm"
 
@@ -22,6 +24,10 @@ type keyRotateFlags struct {
 	Alg string
 	Crv string
 	Yes bool
+	// Additional rotates the key of the algorithm that is active alongside the current key, instead of replacing the current key
+	Additional bool
+	// Remove removes the additional key of the algorithm
+	Remove bool
 }
 
 func init() {
@@ -48,6 +54,8 @@ func init() {
 	keyRotateCmd.Flags().StringVarP(&flags.Alg, "alg", "a", "RS256", "Key algorithm. Supported values: RS256, RS384, RS512, ES256, ES384, ES512, EdDSA")
 	keyRotateCmd.Flags().StringVarP(&flags.Crv, "crv", "c", "", "Curve name when using EdDSA keys. Supported values: Ed25519")
 	keyRotateCmd.Flags().BoolVarP(&flags.Yes, "yes", "y", false, "Do not prompt for confirmation")
+	keyRotateCmd.Flags().BoolVar(&flags.Additional, "additional", false, "Generate an additional key for the algorithm, which is active alongside the current key for the clients and APIs that need its algorithm")
+	keyRotateCmd.Flags().BoolVar(&flags.Remove, "remove", false, "Remove the additional key for the algorithm instead of generating one; requires --additional")
 
 	rootCmd.AddCommand(keyRotateCmd)
 }
@@ -76,8 +84,20 @@ func keyRotate(ctx context.Context, flags keyRotateFlags, db *gorm.DB, instanceI
 		return errors.New("unsupported key algorithm; supported values: RS256, RS384, RS512, ES256, ES384, ES512, EdDSA")
 	}
 
+	if flags.Remove && !flags.Additional {
+		return errors.New("removing a key requires --additional, as the current key can only be replaced")
+	}
+
 	if !flags.Yes {
-		fmt.Println("WARNING: Rotating the private key will invalidate all existing tokens. Both pocket-id and all client applications will likely need to be restarted.")
+		if flags.Additional {
+			fmt.Printf("WARNING: Rotating the additional %s key will invalidate all existing tokens signed with it. Clients and APIs that use this algorithm will likely need to be restarted.\n", flags.Alg)
+			if !flags.Remove {
+				fmt.Println("To replace the key without invalidating tokens, start a signing key rollover with the admin API instead, which replaces the additional keys along with the current key.")
+			}
+		} else {
+			fmt.Println("WARNING: Rotating the private key will invalidate all existing tokens. Both pocket-id and all client applications will likely need to be restarted.")
+			fmt.Println("To replace the key without invalidating tokens, start a signing key rollover with the admin API instead.")
+		}
 		ok, err := utils.PromptForConfirmation("Confirm")
 		if err != nil {
 			return err
@@ -94,6 +114,10 @@ func keyRotate(ctx context.Context, flags keyRotateFlags, db *gorm.DB, instanceI
 		return fmt.Errorf("failed to get key provider: %w", err)
 	}
 
+	if flags.Additional {
+		return rotateAdditionalKey(ctx, flags, keyProvider)
+	}
+
 	// Generate a new key
 	key, err := jwkutils.GenerateKey(flags.Alg, flags.Crv)
 	if err != nil {
@@ -111,3 +135,59 @@ func keyRotate(ctx context.Context, flags keyRotateFlags, db *gorm.DB, instanceI
 
 	return nil
 }
+
+// rotateAdditionalKey replaces or removes the additional key of the algorithm, keeping the current key and the additional keys of other algorithms
+func rotateAdditionalKey(ctx context.Context, flags keyRotateFlags, keyProvider jwkutils.KeyProvider) error {
+	currentKey, err := keyProvider.LoadKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load current key: %w", err)
+	}
+	if currentKey != nil {
+		currentAlg, ok := currentKey.Algorithm()
+		if ok && currentAlg.String() == flags.Alg {
+			return fmt.Errorf("the current key already uses algorithm %s", flags.Alg)
+		}
+	}
+
+	keys, err := keyProvider.LoadAdditionalKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load additional keys: %w", err)
+	}
+
+	// There's one additional key per algorithm, so the key of the algorithm is replaced
+	var found bool
+	keys = slices.DeleteFunc(keys, func(key jwk.Key) bool {
+		alg, ok := key.Algorithm()
+		if ok && alg.String() == flags.Alg {
+			found = true
+			return true
+		}
+		return false
+	})
+
+	if flags.Remove {
+		if !found {
+			return fmt.Errorf("there is no additional key for algorithm %s", flags.Alg)
+		}
+	} else {
+		key, err := jwkutils.GenerateKey(flags.Alg, flags.Crv)
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	err = keyProvider.SaveAdditionalKeys(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to store additional keys: %w", err)
+	}
+
+	if flags.Remove {
+		fmt.Println("Additional key removed successfully")
+	} else {
+		fmt.Println("Additional key rotated successfully")
+	}
+	fmt.Println("Note: if pocket-id is running, you will need to restart it for the keys to be loaded")
+
+	return nil
+}
@@ -147,3 +147,74 @@ func TestKeyRotateMultipleAlgorithms(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyRotateAdditional(t *testing.T) {
+	envConfig := &common.EnvConfigSchema{
+		EncryptionKey: []byte("test-encryption-key-characters-long"),
+	}
+	db := testingutils.NewDatabaseForTest(t)
+	instanceID, err := instanceid.Load(t.Context(), db)
+	require.NoError(t, err)
+	keyProvider, err := jwkutils.GetKeyProvider(db, envConfig, instanceID)
+	require.NoError(t, err)
+
+	require.NoError(t, keyRotate(t.Context(), keyRotateFlags{Alg: "RS256", Yes: true}, db, instanceID, envConfig))
+	currentKey, err := keyProvider.LoadKey(t.Context())
+	require.NoError(t, err)
+
+	additionalAlgs := func(t *testing.T) []string {
+		t.Helper()
+		keys, err := keyProvider.LoadAdditionalKeys(t.Context())
+		require.NoError(t, err)
+		algs := make([]string, len(keys))
+		for i, key := range keys {
+			alg, _ := key.Algorithm()
+			algs[i] = alg.String()
+		}
+		return algs
+	}
+
+	t.Run("adds keys of other algorithms alongside the current key", func(t *testing.T) {
+		require.NoError(t, keyRotate(t.Context(), keyRotateFlags{Alg: "ES256", Additional: true, Yes: true}, db, instanceID, envConfig))
+		require.NoError(t, keyRotate(t.Context(), keyRotateFlags{Alg: "EdDSA", Crv: "Ed25519", Additional: true, Yes: true}, db, instanceID, envConfig))
+		assert.Equal(t, []string{"ES256", "EdDSA"}, additionalAlgs(t))
+
+		key, err := keyProvider.LoadKey(t.Context())
+		require.NoError(t, err)
+		currentKID, _ := currentKey.KeyID()
+		kid, _ := key.KeyID()
+		assert.Equal(t, currentKID, kid)
+	})
+
+	t.Run("replaces the key of the same algorithm", func(t *testing.T) {
+		keys, err := keyProvider.LoadAdditionalKeys(t.Context())
+		require.NoError(t, err)
+		oldKID, _ := keys[0].KeyID()
+
+		require.NoError(t, keyRotate(t.Context(), keyRotateFlags{Alg: "ES256", Additional: true, Yes: true}, db, instanceID, envConfig))
+		assert.Equal(t, []string{"EdDSA", "ES256"}, additionalAlgs(t))
+
+		keys, err = keyProvider.LoadAdditionalKeys(t.Context())
+		require.NoError(t, err)
+		newKID, _ := keys[1].KeyID()
+		assert.NotEqual(t, oldKID, newKID)
+	})
+
+	t.Run("removes the key of the algorithm", func(t *testing.T) {
+		require.NoError(t, keyRotate(t.Context(), keyRotateFlags{Alg: "EdDSA", Crv: "Ed25519", Additional: true, Remove: true, Yes: true}, db, instanceID, envConfig))
+		assert.Equal(t, []string{"ES256"}, additionalAlgs(t))
+
+		err := keyRotate(t.Context(), keyRotateFlags{Alg: "EdDSA", Crv: "Ed25519", Additional: true, Remove: true, Yes: true}, db, instanceID, envConfig)
+		require.ErrorContains(t, err, "there is no additional key for algorithm EdDSA")
+	})
+
+	t.Run("rejects the algorithm of the current key", func(t *testing.T) {
+		err := keyRotate(t.Context(), keyRotateFlags{Alg: "RS256", Additional: true, Yes: true}, db, instanceID, envConfig)
+		require.ErrorContains(t, err, "the current key already uses algorithm RS256")
+	})
+
+	t.Run("remove requires additional", func(t *testing.T) {
+		err := keyRotate(t.Context(), keyRotateFlags{Alg: "ES256", Remove: true, Yes: true}, db, instanceID, envConfig)
+		require.ErrorContains(t, err, "removing a key requires --additional")
+	})
+}
@@ -0,0 +1,46 @@
+package common
+
+import "slices"
+
+// AuthenticationContextClassClaim is the JWT claim ("acr") used to identify the authentication
+// context class the user authenticated with. Like "amr", it is shared between the session JWTs
+// and the OIDC tokens.
+const AuthenticationContextClassClaim = "acr"
+
+// Authentication context classes, from the weakest to the strongest
+const (
+	// ACROneTimeCode is a sign in with a one-time code or login link
+	ACROneTimeCode = "urn:pocket-id:acr:one-time-code"
+	// ACRPasskey is a sign in with a passkey that didn't verify the user
+	ACRPasskey = "urn:pocket-id:acr:passkey"
+	// ACRUserVerifiedPasskey is a sign in with a passkey that verified the user, for example with a PIN or biometrics
+	ACRUserVerifiedPasskey = "urn:pocket-id:acr:passkey:user-verified"
+	// ACRDeviceBoundPasskey is a sign in with a user-verified passkey that can't be synced to other devices, such as a security key
+	ACRDeviceBoundPasskey = "urn:pocket-id:acr:passkey:device-bound"
+)
+
+var authenticationContextClasses = []string{
+	ACROneTimeCode,
+	ACRPasskey,
+	ACRUserVerifiedPasskey,
+	ACRDeviceBoundPasskey,
+}
+
+// AuthenticationContextClasses returns the supported authentication context classes, from the weakest to the strongest
+func AuthenticationContextClasses() []string {
+	return slices.Clone(authenticationContextClasses)
+}
+
+// AuthenticationContextLevel returns the strength of an authentication context class, or 0 if the class is unknown
+func AuthenticationContextLevel(acr string) int {
+	return slices.Index(authenticationContextClasses, acr) + 1
+}
+
+// SatisfiesAuthenticationContext reports whether a user who authenticated with the achieved class meets the required class
+// Classes are ordered, so a stronger authentication satisfies the requirement for a weaker one
+func SatisfiesAuthenticationContext(achieved, required string) bool {
+	if required == "" {
+		return true
+	}
+	return AuthenticationContextLevel(achieved) >= AuthenticationContextLevel(required)
+}
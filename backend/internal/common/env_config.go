@@ -19,6 +19,7 @@ import (
 type AppEnv string
 type DbProvider string
 type TrustProxyConfig []string
+type ProxyListConfig []string
 
 const (
 	// TracerName should be passed to otel.Tracer, trace.SpanFromContext when creating custom spans.
@@ -81,6 +82,12 @@ type EnvConfigSchema struct {
 	TLSCertFile string `env:"TLS_CERT_FILE"`
 	TLSKeyFile  string `env:"TLS_KEY_FILE"`
 
+	// TLSClientCertHeader is the header a TLS-terminating reverse proxy forwards the client certificate for mutual-TLS client authentication in
+	// The proxy must overwrite this header on every request, otherwise clients could send their own certificate in it
+	TLSClientCertHeader string `env:"TLS_CLIENT_CERT_HEADER"`
+	// TLSClientCertProxies are the reverse proxies allowed to forward the client certificate, which must be listed explicitly instead of trusting every address
+	TLSClientCertProxies ProxyListConfig `env:"TLS_CLIENT_CERT_PROXIES"`
+
 	MaxMindLicenseKey string `env:"MAXMIND_LICENSE_KEY" options:"file"`
 	GeoLiteDBPath     string `env:"GEOLITE_DB_PATH"`
 	GeoLiteDBUrl      string `env:"GEOLITE_DB_URL"`
@@ -174,6 +181,9 @@ func ValidateEnvConfig(config *EnvConfigSchema) error {
 	if len(config.ProxyProtocol) > 0 && config.UnixSocket != "" {
 		return errors.New("PROXY_PROTOCOL and UNIX_SOCKET are mutually exclusive")
 	}
+	if (config.TLSClientCertHeader == "") != (len(config.TLSClientCertProxies) == 0) {
+		return errors.New("TLS_CLIENT_CERT_HEADER and TLS_CLIENT_CERT_PROXIES must be set together")
+	}
 
 	if config.AuditLogRetentionDays <= 0 {
 		return errors.New("AUDIT_LOG_RETENTION_DAYS must be greater than 0")
@@ -437,18 +447,50 @@ func (config *TrustProxyConfig) UnmarshalText(text []byte) error {
 		return nil
 	}
 
-	// Normalize and validate each explicit proxy before the server starts
+	proxies, err := parseProxyList(value)
+	if err != nil {
+		return err
+	}
+
+	*config = proxies
+	return nil
+}
+
+// UnmarshalText parses a list of explicit proxies, which unlike TrustProxyConfig can't be set to true to trust every address
+func (config *ProxyListConfig) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if value == "" {
+		*config = nil
+		return nil
+	}
+
+	proxies, err := parseProxyList(value)
+	if err != nil {
+		return err
+	}
+	for _, proxy := range proxies {
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			if ones, _ := ipNet.Mask.Size(); ones == 0 {
+				return fmt.Errorf("proxy CIDR %q would trust every address", proxy)
+			}
+		}
+	}
+
+	*config = proxies
+	return nil
+}
+
+// parseProxyList normalizes and validates each explicit proxy before the server starts
+func parseProxyList(value string) ([]string, error) {
 	proxies := strings.Split(value, ",")
 	for i, proxy := range proxies {
 		proxy = strings.TrimSpace(proxy)
 		if net.ParseIP(proxy) == nil {
 			if _, _, err := net.ParseCIDR(proxy); err != nil {
-				return fmt.Errorf("invalid proxy IP address or CIDR %q", proxy)
+				return nil, fmt.Errorf("invalid proxy IP address or CIDR %q", proxy)
 			}
 		}
 		proxies[i] = proxy
 	}
-
-	*config = proxies
-	return nil
+	return proxies, nil
 }
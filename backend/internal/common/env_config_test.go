@@ -177,6 +177,37 @@ func TestParseEnvConfig(t *testing.T) {
 		assert.ErrorContains(t, err, "PROXY_PROTOCOL and UNIX_SOCKET are mutually exclusive")
 	})
 
+	t.Run("should parse the proxies allowed to forward TLS client certificates", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("TLS_CLIENT_CERT_HEADER", "X-SSL-Client-Cert")
+		t.Setenv("TLS_CLIENT_CERT_PROXIES", "10.0.0.0/8, 192.168.1.10")
+
+		err := parseAndValidateEnvConfig(t)
+		require.NoError(t, err)
+		assert.Equal(t, "X-SSL-Client-Cert", EnvConfig.TLSClientCertHeader)
+		assert.Equal(t, ProxyListConfig{"10.0.0.0/8", "192.168.1.10"}, EnvConfig.TLSClientCertProxies)
+	})
+
+	t.Run("should reject trusting every address to forward TLS client certificates", func(t *testing.T) {
+		for _, proxies := range []string{"true", "0.0.0.0/0", "::/0"} {
+			EnvConfig = defaultConfig()
+			t.Setenv("TLS_CLIENT_CERT_HEADER", "X-SSL-Client-Cert")
+			t.Setenv("TLS_CLIENT_CERT_PROXIES", proxies)
+
+			err := parseAndValidateEnvConfig(t)
+			require.Error(t, err, proxies)
+		}
+	})
+
+	t.Run("should require the TLS client certificate header and proxies together", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("TLS_CLIENT_CERT_HEADER", "X-SSL-Client-Cert")
+
+		err := parseAndValidateEnvConfig(t)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "TLS_CLIENT_CERT_HEADER and TLS_CLIENT_CERT_PROXIES must be set together")
+	})
+
 	t.Run("should allow insecure callback URLs by default", func(t *testing.T) {
 		assert.True(t, defaultConfig().AllowInsecureCallbackURLs)
 	})
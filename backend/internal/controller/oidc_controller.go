@@ -48,6 +48,8 @@ func NewOidcController(group *gin.RouterGroup, authMiddleware *middleware.AuthMi
 	group.GET("/oidc/users/:id/authorized-clients", authMiddleware.Add(), httpserver.Handle(oc.listAuthorizedClientsHandler))
 
 	group.DELETE("/oidc/users/me/authorized-clients/:clientId", authMiddleware.WithAdminNotRequired().Add(), httpserver.Handle(oc.revokeOwnClientAuthorizationHandler))
+	group.GET("/oidc/users/me/authorized-clients/:clientId/scopes", authMiddleware.WithAdminNotRequired().Add(), httpserver.Handle(oc.listOwnAuthorizedClientScopesHandler))
+	group.DELETE("/oidc/users/me/authorized-clients/:clientId/scopes", authMiddleware.WithAdminNotRequired().Add(), httpserver.Handle(oc.revokeOwnAuthorizedClientScopeHandler))
 
 	group.GET("/oidc/users/me/clients", authMiddleware.WithAdminNotRequired().Add(), httpserver.Handle(oc.listOwnAccessibleClientsHandler))
 
@@ -533,6 +535,51 @@ func (oc *OidcController) revokeOwnClientAuthorizationHandler(c *gin.Context) er
 	return nil
 }
 
+// listOwnAuthorizedClientScopesHandler godoc
+// @Summary List the scopes of an authorized OIDC client
+// @Description Get the current user's decisions on every scope, API and claim they were asked to consent to for an OIDC client
+// @Tags OIDC
+// @Param clientId path string true "Client ID"
+// @Success 200 {array} dto.AuthorizedOidcClientScopeDto
+// @Failure default {object} dto.ErrorDto "Error"
+// @Router /api/oidc/users/me/authorized-clients/{clientId}/scopes [get]
+func (oc *OidcController) listOwnAuthorizedClientScopesHandler(c *gin.Context) error {
+	clientID := c.Param("clientId")
+
+	userID := c.GetString("userID")
+
+	scopes, err := oc.oidcService.ListAuthorizedClientScopes(c.Request.Context(), userID, clientID)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, scopes)
+	return nil
+}
+
+// revokeOwnAuthorizedClientScopeHandler godoc
+// @Summary Revoke a single scope of an authorized OIDC client
+// @Description Revoke the current user's consent to a single optional scope of an OIDC client, without revoking the whole authorization
+// @Tags OIDC
+// @Param clientId path string true "Client ID"
+// @Param scope query string true "Key of the scope to revoke"
+// @Success 204 "No Content"
+// @Failure default {object} dto.ErrorDto "Error"
+// @Router /api/oidc/users/me/authorized-clients/{clientId}/scopes [delete]
+func (oc *OidcController) revokeOwnAuthorizedClientScopeHandler(c *gin.Context) error {
+	clientID := c.Param("clientId")
+
+	userID := c.GetString("userID")
+
+	err := oc.oidcService.RevokeAuthorizedClientScope(c.Request.Context(), userID, clientID, c.Query("scope"))
+	if err != nil {
+		return err
+	}
+
+	c.Status(http.StatusNoContent)
+	return nil
+}
+
 // listOwnAccessibleClientsHandler godoc
 // @Summary List accessible OIDC clients for current user
 // @Description Get a list of OIDC clients that the current user can access
@@ -2,7 +2,6 @@ package controller
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +9,7 @@ import (
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	_ "github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/httpserver"
+	"github.com/pocket-id/pocket-id/backend/internal/oidc"
 	"github.com/pocket-id/pocket-id/backend/internal/service"
 )
 
@@ -87,39 +87,56 @@ func (wkc *WellKnownController) computeServerMetadata() ([]byte, error) {
 
 	internalAppUrl := common.EnvConfig.InternalAppURL
 
-	alg, err := wkc.jwtService.GetKeyAlg()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get key algorithm: %w", err)
-	}
 	cimdSupported := false
 	if wkc.getCIMDURLAllowlist != nil {
 		cimdSupported = len(wkc.getCIMDURLAllowlist()) > 0
 	}
 
 	config := map[string]any{
-		"issuer":                 appUrl,
-		"authorization_endpoint": appUrl + "/authorize",
-		"token_endpoint":         internalAppUrl + "/api/oidc/token",
-		"userinfo_endpoint":      internalAppUrl + "/api/oidc/userinfo",
-		"end_session_endpoint":   appUrl + "/api/oidc/end-session",
-		"introspection_endpoint": internalAppUrl + "/api/oidc/introspect",
-		"introspection_endpoint_auth_methods_supported":  []string{"client_secret_basic", "Bearer"},
-		"device_authorization_endpoint":                  appUrl + "/api/oidc/device/authorize",
+		"issuer":                                        appUrl,
+		"authorization_endpoint":                        appUrl + "/authorize",
+		"token_endpoint":                                internalAppUrl + "/api/oidc/token",
+		"userinfo_endpoint":                             internalAppUrl + "/api/oidc/userinfo",
+		"end_session_endpoint":                          appUrl + "/api/oidc/end-session",
+		"backchannel_logout_supported":                  true,
+		"backchannel_logout_session_supported":          true,
+		"frontchannel_logout_supported":                 true,
+		"frontchannel_logout_session_supported":         true,
+		"introspection_endpoint":                        internalAppUrl + "/api/oidc/introspect",
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "Bearer"},
+		"introspection_signing_alg_values_supported":    oidc.IntrospectionSigningAlgValuesSupported(wkc.jwtService),
+		"revocation_endpoint":                           internalAppUrl + "/api/oidc/revoke",
+		"revocation_endpoint_auth_methods_supported":    append([]string{"client_secret_basic", "client_secret_post", "none"}, oidc.TLSClientAuthMethodsSupported()...),
+		"registration_endpoint":                         internalAppUrl + "/api/oidc/register",
+		"device_authorization_endpoint":                 appUrl + "/api/oidc/device/authorize",
+		"backchannel_authentication_endpoint":           internalAppUrl + "/api/oidc/bc-authorize",
+		"backchannel_token_delivery_modes_supported":    oidc.BackchannelTokenDeliveryModesSupported(),
+		"backchannel_user_code_parameter_supported":     false,
 		"jwks_uri":                                       internalAppUrl + "/.well-known/jwks.json",
-		"grant_types_supported":                          []string{service.GrantTypeAuthorizationCode, service.GrantTypeRefreshToken, service.GrantTypeDeviceCode, service.GrantTypeClientCredentials},
+		"grant_types_supported":                          []string{service.GrantTypeAuthorizationCode, service.GrantTypeRefreshToken, service.GrantTypeDeviceCode, service.GrantTypeClientCredentials, service.GrantTypeTokenExchange, service.GrantTypeJWTBearer, service.GrantTypeCIBA},
 		"scopes_supported":                               []string{"openid", "profile", "email", "groups", "offline_access"},
-		"claims_supported":                               []string{"sub", "given_name", "family_name", "name", "display_name", "email", "email_verified", "preferred_username", "picture", "groups", "auth_time", "amr"},
+		"claims_supported":                               []string{"sub", "given_name", "family_name", "name", "display_name", "email", "email_verified", "preferred_username", "picture", "groups", "auth_time", "amr", "acr"},
+		"claims_parameter_supported":                     true,
 		"response_types_supported":                       []string{"code"},
-		"response_modes_supported":                       []string{"query", "fragment", "form_post"},
-		"subject_types_supported":                        []string{"public"},
-		"id_token_signing_alg_values_supported":          []string{alg.String()},
+		"response_modes_supported":                       append([]string{"query", "fragment", "form_post"}, oidc.JARMResponseModesSupported()...),
+		"authorization_signing_alg_values_supported":     oidc.AuthorizationSigningAlgValuesSupported(wkc.jwtService),
+		"subject_types_supported":                        oidc.SubjectTypesSupported(),
+		"acr_values_supported":                           oidc.ACRValuesSupported(),
+		"id_token_signing_alg_values_supported":          oidc.IDTokenSigningAlgValuesSupported(wkc.jwtService),
+		"id_token_encryption_alg_values_supported":       oidc.EncryptionAlgValuesSupported(),
+		"id_token_encryption_enc_values_supported":       oidc.EncryptionEncValuesSupported(),
+		"userinfo_signing_alg_values_supported":          oidc.UserinfoSigningAlgValuesSupported(wkc.jwtService),
+		"userinfo_encryption_alg_values_supported":       oidc.EncryptionAlgValuesSupported(),
+		"userinfo_encryption_enc_values_supported":       oidc.EncryptionEncValuesSupported(),
 		"authorization_response_iss_parameter_supported": true,
 		"code_challenge_methods_supported":               []string{"plain", "S256"},
 		"request_parameter_supported":                    true,
 		"request_uri_parameter_supported":                false,
-		"request_object_signing_alg_values_supported":    []string{"none"},
+		"request_object_signing_alg_values_supported":    oidc.RequestObjectSigningAlgValuesSupported(),
 		"prompt_values_supported":                        []string{"none", "login", "consent", "select_account"},
-		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"token_endpoint_auth_methods_supported":          append([]string{"client_secret_basic", "client_secret_post", "none"}, oidc.TLSClientAuthMethodsSupported()...),
+		"tls_client_certificate_bound_access_tokens":     true,
+		"dpop_signing_alg_values_supported":              oidc.DPoPSigningAlgValuesSupported(),
 		"pushed_authorization_request_endpoint":          internalAppUrl + "/api/oidc/par",
 		"require_pushed_authorization_requests":          false,
 		"client_id_metadata_document_supported":          cimdSupported,
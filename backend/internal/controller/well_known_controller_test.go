@@ -96,11 +96,28 @@ func TestOAuthAuthorizationServerMetadata(t *testing.T) {
 	assert.NotEmpty(t, doc["jwks_uri"])
 	assert.Contains(t, doc["scopes_supported"], "openid")
 	assert.Contains(t, doc["grant_types_supported"], "authorization_code")
+	assert.Contains(t, doc["grant_types_supported"], "urn:ietf:params:oauth:grant-type:token-exchange")
+	assert.Contains(t, doc["grant_types_supported"], "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	assert.Contains(t, doc["grant_types_supported"], "urn:openid:params:grant-type:ciba")
+	assert.Equal(t, []any{common.ACROneTimeCode, common.ACRPasskey, common.ACRUserVerifiedPasskey, common.ACRDeviceBoundPasskey}, doc["acr_values_supported"])
+	assert.Contains(t, doc["claims_supported"], "acr")
+	assert.Equal(t, true, doc["claims_parameter_supported"])
+	assert.Equal(t, common.EnvConfig.InternalAppURL+"/api/oidc/bc-authorize", doc["backchannel_authentication_endpoint"])
+	assert.ElementsMatch(t, []any{"poll", "ping"}, doc["backchannel_token_delivery_modes_supported"])
 	assert.Contains(t, doc["code_challenge_methods_supported"], "S256")
 	assert.Equal(t, "https://pocket-id.org/docs", doc["service_documentation"])
-	assert.ElementsMatch(t, []any{"query", "fragment", "form_post"}, doc["response_modes_supported"])
-	assert.NotContains(t, doc, "revocation_endpoint")
-	assert.NotContains(t, doc, "registration_endpoint")
+	assert.ElementsMatch(t, []any{"query", "fragment", "form_post", "jwt", "query.jwt", "fragment.jwt", "form_post.jwt"}, doc["response_modes_supported"])
+	assert.NotEmpty(t, doc["authorization_signing_alg_values_supported"])
+	assert.Equal(t, doc["authorization_signing_alg_values_supported"], doc["userinfo_signing_alg_values_supported"])
+	assert.Len(t, doc["introspection_signing_alg_values_supported"], 1)
+	assert.Equal(t, common.EnvConfig.InternalAppURL+"/api/oidc/revoke", doc["revocation_endpoint"])
+	assert.Contains(t, doc["dpop_signing_alg_values_supported"], "ES256")
+	assert.Contains(t, doc["token_endpoint_auth_methods_supported"], "self_signed_tls_client_auth")
+	assert.Equal(t, true, doc["tls_client_certificate_bound_access_tokens"])
+	assert.Subset(t, doc["request_object_signing_alg_values_supported"], []any{"ES256", "RS256", "none"})
+	assert.Equal(t, common.EnvConfig.InternalAppURL+"/api/oidc/register", doc["registration_endpoint"])
+	assert.Contains(t, doc["id_token_encryption_alg_values_supported"], "RSA-OAEP-256")
+	assert.Contains(t, doc["userinfo_encryption_enc_values_supported"], "A128CBC-HS256")
 
 	for name, value := range doc {
 		if arr, ok := value.([]any); ok {
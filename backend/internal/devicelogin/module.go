@@ -19,7 +19,7 @@ type TokenService interface {
 }
 
 type ReauthenticationTokenConsumer interface {
-	ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, error)
+	ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, string, error)
 }
 
 type AuditLogger interface {
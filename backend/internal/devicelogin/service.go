@@ -239,7 +239,7 @@ func (s *Service) consumeReauthenticationProof(ctx context.Context, token, userI
 	}
 	defer tx.Rollback()
 
-	reauthenticatedAt, err := s.reauth.ConsumeReauthenticationToken(ctx, tx, token, userID)
+	reauthenticatedAt, _, err := s.reauth.ConsumeReauthenticationToken(ctx, tx, token, userID)
 	if err != nil {
 		if apperror.IsCode(err, apperror.CodeReauthenticationRequired) {
 			return apperror.ReauthenticationRequired()
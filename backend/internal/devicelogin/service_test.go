@@ -31,14 +31,14 @@ type fakeReauthenticationTokenConsumer struct {
 	createdAt     time.Time
 }
 
-func (f *fakeReauthenticationTokenConsumer) ConsumeReauthenticationToken(_ context.Context, _ *gorm.DB, token string, _ string) (time.Time, error) {
+func (f *fakeReauthenticationTokenConsumer) ConsumeReauthenticationToken(_ context.Context, _ *gorm.DB, token string, _ string) (time.Time, string, error) {
 	if token != f.expectedValue {
-		return time.Time{}, apperror.ReauthenticationRequired()
+		return time.Time{}, "", apperror.ReauthenticationRequired()
 	}
 	if !f.createdAt.IsZero() {
-		return f.createdAt, nil
+		return f.createdAt, "", nil
 	}
-	return time.Now(), nil
+	return time.Now(), "", nil
 }
 
 type fakeTokenService struct {
@@ -1,6 +1,10 @@
 package dto
 
-import datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+import (
+	"github.com/gin-gonic/gin/binding"
+
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
 
 type OidcClientMetaDataDto struct {
 	ID                       string  `json:"id"`
@@ -15,17 +19,40 @@ type OidcClientMetaDataDto struct {
 
 type OidcClientDto struct {
 	OidcClientMetaDataDto
-	CallbackURLs                        []string                 `json:"callbackURLs"`
-	LogoutCallbackURLs                  []string                 `json:"logoutCallbackURLs"`
-	IsPublic                            bool                     `json:"isPublic"`
-	PkceEnabled                         bool                     `json:"pkceEnabled"`
-	RequiresPushedAuthorizationRequests bool                     `json:"requiresPushedAuthorizationRequests"`
-	SkipConsent                         bool                     `json:"skipConsent"`
-	Credentials                         OidcClientCredentialsDto `json:"credentials"`
-	IsGroupRestricted                   bool                     `json:"isGroupRestricted"`
-	PkceSupported                       bool                     `json:"pkceSupported,omitempty"`
-	AccessTokenDurationMinutes          int64                    `json:"accessTokenDurationMinutes"`
-	RefreshTokenDurationMinutes         int64                    `json:"refreshTokenDurationMinutes"`
+	CallbackURLs                          []string                 `json:"callbackURLs"`
+	LogoutCallbackURLs                    []string                 `json:"logoutCallbackURLs"`
+	IsPublic                              bool                     `json:"isPublic"`
+	PkceEnabled                           bool                     `json:"pkceEnabled"`
+	RequiresPushedAuthorizationRequests   bool                     `json:"requiresPushedAuthorizationRequests"`
+	RequiresDpop                          bool                     `json:"requiresDpop"`
+	RequiresSignedRequestObject           bool                     `json:"requiresSignedRequestObject"`
+	SkipConsent                           bool                     `json:"skipConsent"`
+	Credentials                           OidcClientCredentialsDto `json:"credentials"`
+	IsGroupRestricted                     bool                     `json:"isGroupRestricted"`
+	PkceSupported                         bool                     `json:"pkceSupported,omitempty"`
+	AccessTokenDurationMinutes            int64                    `json:"accessTokenDurationMinutes"`
+	RefreshTokenDurationMinutes           int64                    `json:"refreshTokenDurationMinutes"`
+	RefreshTokenRequiresOfflineAccess     bool                     `json:"refreshTokenRequiresOfflineAccess"`
+	RefreshTokenMaxLifetimeMinutes        int64                    `json:"refreshTokenMaxLifetimeMinutes"`
+	RefreshTokenReuseGraceSeconds         int64                    `json:"refreshTokenReuseGraceSeconds"`
+	ConsentLifetimeMinutes                int64                    `json:"consentLifetimeMinutes"`
+	ClaimPolicy                           OidcClientClaimPolicyDto `json:"claimPolicy"`
+	BackchannelLogoutURI                  *string                  `json:"backchannelLogoutURI"`
+	BackchannelLogoutSessionRequired      bool                     `json:"backchannelLogoutSessionRequired"`
+	FrontchannelLogoutURI                 *string                  `json:"frontchannelLogoutURI"`
+	FrontchannelLogoutSessionRequired     bool                     `json:"frontchannelLogoutSessionRequired"`
+	AuthorizationSignedResponseAlg        string                   `json:"authorizationSignedResponseAlg"`
+	UserinfoSignedResponseAlg             string                   `json:"userinfoSignedResponseAlg"`
+	IDTokenSignedResponseAlg              string                   `json:"idTokenSignedResponseAlg"`
+	BackchannelTokenDeliveryMode          string                   `json:"backchannelTokenDeliveryMode"`
+	BackchannelClientNotificationEndpoint *string                  `json:"backchannelClientNotificationEndpoint"`
+	SubjectType                           string                   `json:"subjectType"`
+	SectorIdentifierURI                   *string                  `json:"sectorIdentifierUri"`
+	IDTokenEncryptedResponseAlg           string                   `json:"idTokenEncryptedResponseAlg"`
+	IDTokenEncryptedResponseEnc           string                   `json:"idTokenEncryptedResponseEnc"`
+	UserinfoEncryptedResponseAlg          string                   `json:"userinfoEncryptedResponseAlg"`
+	UserinfoEncryptedResponseEnc          string                   `json:"userinfoEncryptedResponseEnc"`
+	MinimumACR                            string                   `json:"minimumAcr"`
 }
 
 type OidcClientWithAllowedUserGroupsDto struct {
@@ -39,24 +66,47 @@ type OidcClientWithAllowedGroupsCountDto struct {
 }
 
 type OidcClientUpdateDto struct {
-	Name                                string                   `json:"name" binding:"required,max=50" unorm:"nfc"`
-	Description                         string                   `json:"description" binding:"omitempty,max=150" unorm:"nfc"`
-	CallbackURLs                        []string                 `json:"callbackURLs" binding:"omitempty,dive,callback_url_pattern"`
-	LogoutCallbackURLs                  []string                 `json:"logoutCallbackURLs" binding:"omitempty,dive,callback_url_pattern"`
-	IsPublic                            bool                     `json:"isPublic"`
-	PkceEnabled                         bool                     `json:"pkceEnabled"`
-	RequiresReauthentication            bool                     `json:"requiresReauthentication"`
-	RequiresPushedAuthorizationRequests bool                     `json:"requiresPushedAuthorizationRequests"`
-	SkipConsent                         bool                     `json:"skipConsent"`
-	Credentials                         OidcClientCredentialsDto `json:"credentials"`
-	LaunchURL                           *string                  `json:"launchURL" binding:"omitempty,url"`
-	HasLogo                             bool                     `json:"hasLogo"`
-	HasDarkLogo                         bool                     `json:"hasDarkLogo"`
-	LogoURL                             *string                  `json:"logoUrl"`
-	DarkLogoURL                         *string                  `json:"darkLogoUrl"`
-	IsGroupRestricted                   bool                     `json:"isGroupRestricted"`
-	AccessTokenDurationMinutes          int64                    `json:"accessTokenDurationMinutes" binding:"omitempty,token_duration"`
-	RefreshTokenDurationMinutes         int64                    `json:"refreshTokenDurationMinutes" binding:"omitempty,token_duration"`
+	Name                                  string                   `json:"name" binding:"required,max=50" unorm:"nfc"`
+	Description                           string                   `json:"description" binding:"omitempty,max=150" unorm:"nfc"`
+	CallbackURLs                          []string                 `json:"callbackURLs" binding:"omitempty,dive,callback_url_pattern"`
+	LogoutCallbackURLs                    []string                 `json:"logoutCallbackURLs" binding:"omitempty,dive,callback_url_pattern"`
+	IsPublic                              bool                     `json:"isPublic"`
+	PkceEnabled                           bool                     `json:"pkceEnabled"`
+	RequiresReauthentication              bool                     `json:"requiresReauthentication"`
+	RequiresPushedAuthorizationRequests   bool                     `json:"requiresPushedAuthorizationRequests"`
+	RequiresDpop                          bool                     `json:"requiresDpop"`
+	RequiresSignedRequestObject           bool                     `json:"requiresSignedRequestObject"`
+	SkipConsent                           bool                     `json:"skipConsent"`
+	Credentials                           OidcClientCredentialsDto `json:"credentials"`
+	LaunchURL                             *string                  `json:"launchURL" binding:"omitempty,url"`
+	HasLogo                               bool                     `json:"hasLogo"`
+	HasDarkLogo                           bool                     `json:"hasDarkLogo"`
+	LogoURL                               *string                  `json:"logoUrl"`
+	DarkLogoURL                           *string                  `json:"darkLogoUrl"`
+	IsGroupRestricted                     bool                     `json:"isGroupRestricted"`
+	AccessTokenDurationMinutes            int64                    `json:"accessTokenDurationMinutes" binding:"omitempty,token_duration"`
+	RefreshTokenDurationMinutes           int64                    `json:"refreshTokenDurationMinutes" binding:"omitempty,token_duration"`
+	RefreshTokenRequiresOfflineAccess     bool                     `json:"refreshTokenRequiresOfflineAccess"`
+	RefreshTokenMaxLifetimeMinutes        int64                    `json:"refreshTokenMaxLifetimeMinutes" binding:"omitempty,token_duration"`
+	RefreshTokenReuseGraceSeconds         int64                    `json:"refreshTokenReuseGraceSeconds" binding:"min=0,max=300"`
+	ConsentLifetimeMinutes                int64                    `json:"consentLifetimeMinutes" binding:"omitempty,token_duration"`
+	ClaimPolicy                           OidcClientClaimPolicyDto `json:"claimPolicy"`
+	BackchannelLogoutURI                  *string                  `json:"backchannelLogoutURI" binding:"omitempty,url"`
+	BackchannelLogoutSessionRequired      bool                     `json:"backchannelLogoutSessionRequired"`
+	FrontchannelLogoutURI                 *string                  `json:"frontchannelLogoutURI" binding:"omitempty,url"`
+	FrontchannelLogoutSessionRequired     bool                     `json:"frontchannelLogoutSessionRequired"`
+	AuthorizationSignedResponseAlg        string                   `json:"authorizationSignedResponseAlg" binding:"omitempty,oneof=RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512 EdDSA"`
+	UserinfoSignedResponseAlg             string                   `json:"userinfoSignedResponseAlg" binding:"omitempty,oneof=RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512 EdDSA"`
+	IDTokenSignedResponseAlg              string                   `json:"idTokenSignedResponseAlg" binding:"omitempty,oneof=RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512 EdDSA"`
+	BackchannelTokenDeliveryMode          string                   `json:"backchannelTokenDeliveryMode" binding:"omitempty,oneof=poll ping"`
+	BackchannelClientNotificationEndpoint *string                  `json:"backchannelClientNotificationEndpoint" binding:"required_if=BackchannelTokenDeliveryMode ping,omitempty,url"`
+	SubjectType                           string                   `json:"subjectType" binding:"omitempty,oneof=public pairwise"`
+	SectorIdentifierURI                   *string                  `json:"sectorIdentifierUri" binding:"omitempty,url"`
+	IDTokenEncryptedResponseAlg           string                   `json:"idTokenEncryptedResponseAlg" binding:"omitempty,oneof=RSA-OAEP RSA-OAEP-256 ECDH-ES ECDH-ES+A128KW ECDH-ES+A192KW ECDH-ES+A256KW"`
+	IDTokenEncryptedResponseEnc           string                   `json:"idTokenEncryptedResponseEnc" binding:"omitempty,oneof=A128CBC-HS256 A192CBC-HS384 A256CBC-HS512 A128GCM A192GCM A256GCM"`
+	UserinfoEncryptedResponseAlg          string                   `json:"userinfoEncryptedResponseAlg" binding:"omitempty,oneof=RSA-OAEP RSA-OAEP-256 ECDH-ES ECDH-ES+A128KW ECDH-ES+A192KW ECDH-ES+A256KW"`
+	UserinfoEncryptedResponseEnc          string                   `json:"userinfoEncryptedResponseEnc" binding:"omitempty,oneof=A128CBC-HS256 A192CBC-HS384 A256CBC-HS512 A128GCM A192GCM A256GCM"`
+	MinimumACR                            string                   `json:"minimumAcr" binding:"omitempty,oneof=urn:pocket-id:acr:one-time-code urn:pocket-id:acr:passkey urn:pocket-id:acr:passkey:user-verified urn:pocket-id:acr:passkey:device-bound"`
 }
 
 type OidcClientCreateDto struct {
@@ -64,6 +114,11 @@ type OidcClientCreateDto struct {
 	ID string `json:"id" binding:"omitempty,client_id,min=2,max=128"`
 }
 
+// Validate applies the same validation as when the client is bound from a request, for clients created from other input
+func (c OidcClientCreateDto) Validate() error {
+	return binding.Validator.ValidateStruct(c)
+}
+
 // OidcClientSecretDto describes a client secret without disclosing its value, which is only ever returned right after the secret is created
 type OidcClientSecretDto struct {
 	ID string `json:"id"`
@@ -91,7 +146,12 @@ type OidcClientSecretCreatedDto struct {
 type OidcClientCredentialsDto struct {
 	FederatedIdentities []OidcClientFederatedIdentityDto `json:"federatedIdentities,omitempty"`
 	// Secrets is read-only: secrets are managed through the dedicated client secret endpoints and any value sent by a client is ignored
-	Secrets []OidcClientSecretDto `json:"secrets"`
+	Secrets       []OidcClientSecretDto       `json:"secrets"`
+	TLSClientAuth *OidcClientTLSClientAuthDto `json:"tlsClientAuth,omitempty"`
+	JWKS          string                      `json:"jwks,omitempty" binding:"omitempty,public_jwks"`
+	JWKSURI       string                      `json:"jwksUri,omitempty" binding:"omitempty,url"`
+	// JWTBearerGrants map the JWTs of trusted external issuers to the subject of the tokens issued with the JWT bearer grant
+	JWTBearerGrants []OidcClientJWTBearerGrantDto `json:"jwtBearerGrants,omitempty" binding:"omitempty,dive"`
 }
 
 type OidcClientFederatedIdentityDto struct {
@@ -102,6 +162,55 @@ type OidcClientFederatedIdentityDto struct {
 	ReplayProtection bool   `json:"replayProtection"`
 }
 
+type OidcClientJWTBearerGrantDto struct {
+	Issuer   string `json:"issuer" binding:"required,url"`
+	Subject  string `json:"subject" binding:"required,max=1024,jwt_bearer_subject"`
+	Audience string `json:"audience,omitempty" binding:"omitempty,max=1024"`
+	JWKS     string `json:"jwks,omitempty" binding:"omitempty,url"`
+	// UserID is the user the issued tokens act for, the client itself when empty
+	UserID           string `json:"userId,omitempty" binding:"omitempty,max=36"`
+	ReplayProtection bool   `json:"replayProtection"`
+}
+
+type OidcClientTLSClientAuthDto struct {
+	Method         string `json:"method" binding:"required,oneof=tls_client_auth self_signed_tls_client_auth"`
+	CACertificates string `json:"caCertificates" binding:"required_if=Method tls_client_auth,omitempty,pem_certificates"`
+	SubjectDN      string `json:"subjectDn" binding:"omitempty,max=1024"`
+	SANDNS         string `json:"sanDns" binding:"omitempty,hostname_rfc1123"`
+	SANURI         string `json:"sanUri" binding:"omitempty,uri"`
+	SANIP          string `json:"sanIp" binding:"omitempty,ip"`
+	SANEmail       string `json:"sanEmail" binding:"omitempty,email"`
+	Certificates   string `json:"certificates" binding:"required_if=Method self_signed_tls_client_auth,omitempty,pem_certificates"`
+}
+
+// OidcClientClaimPolicyDto limits, renames and routes the user claims released to a client, and adds static claims
+type OidcClientClaimPolicyDto struct {
+	// CustomClaimsFilter is whether the custom claim keys are the only custom claims released or are withheld, empty to release all custom claims
+	CustomClaimsFilter string                      `json:"customClaimsFilter" binding:"omitempty,oneof=allow deny"`
+	CustomClaimKeys    []string                    `json:"customClaimKeys,omitempty" binding:"omitempty,dive,required,max=255"`
+	Mappings           []OidcClientClaimMappingDto `json:"mappings,omitempty" binding:"omitempty,dive"`
+	StaticClaims       []OidcClientStaticClaimDto  `json:"staticClaims,omitempty" binding:"omitempty,dive"`
+}
+
+type OidcClientClaimMappingDto struct {
+	Claim string `json:"claim" binding:"required,max=255"`
+	// Name is what the client gets the claim as, empty to keep its name
+	Name         string                         `json:"name" binding:"omitempty,max=255"`
+	Destinations OidcClientClaimDestinationsDto `json:"destinations"`
+}
+
+type OidcClientStaticClaimDto struct {
+	Key          string                         `json:"key" binding:"required,max=255" unorm:"nfc"`
+	Value        string                         `json:"value" binding:"required" unorm:"nfc"`
+	Destinations OidcClientClaimDestinationsDto `json:"destinations"`
+}
+
+type OidcClientClaimDestinationsDto struct {
+	IDToken     bool `json:"idToken"`
+	UserInfo    bool `json:"userInfo"`
+	AccessToken bool `json:"accessToken"`
+}
+
 type OidcUpdateAllowedUserGroupsDto struct {
 	UserGroupIDs []string `json:"userGroupIds" binding:"required"`
 }
@@ -122,26 +231,72 @@ type OidcDeviceAuthorizationResponseDto struct {
 	Interval                int    `json:"interval"`
 }
 
+type OidcBackchannelAuthenticationResponseDto struct {
+	AuthReqID string `json:"auth_req_id"`
+	ExpiresIn int    `json:"expires_in"`
+	Interval  int    `json:"interval,omitempty"`
+}
+
+// BackchannelAuthenticationRequestDto is a pending backchannel authentication request, shown to the user it was started for
+type BackchannelAuthenticationRequestDto struct {
+	ID             string                `json:"id"`
+	Client         OidcClientMetaDataDto `json:"client"`
+	Scope          []string              `json:"scope"`
+	ScopeInfo      []ScopeInfoDto        `json:"scopeInfo"`
+	BindingMessage *string               `json:"bindingMessage"`
+	CreatedAt      datatype.DateTime     `json:"createdAt"`
+	ExpiresAt      datatype.DateTime     `json:"expiresAt"`
+}
+
 type ScopeInfoDto struct {
 	Key         string `json:"key"`
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
 }
 
+// AuthorizationDetailTypeDto describes a type of RFC 9396 authorization details an API accepts
+type AuthorizationDetailTypeDto struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// AuthorizationDetailInfoDto is a requested RFC 9396 authorization detail, as shown on the consent screen
+type AuthorizationDetailInfoDto struct {
+	AuthorizationDetailTypeDto
+	Detail map[string]any `json:"detail"`
+}
+
 type DeviceCodeInfoDto struct {
 	Scope                    []string              `json:"scope"`
 	ScopeInfo                []ScopeInfoDto        `json:"scopeInfo"`
+	Claims                   []string              `json:"claims"`
 	AuthorizationRequired    bool                  `json:"authorizationRequired"`
 	ReauthenticationRequired bool                  `json:"reauthenticationRequired"`
 	Client                   OidcClientMetaDataDto `json:"client"`
 }
 
 type AuthorizedOidcClientDto struct {
-	Scope      string                `json:"scope"`
 	Client     OidcClientMetaDataDto `json:"client"`
 	LastUsedAt datatype.DateTime     `json:"lastUsedAt"`
 }
 
+// AuthorizedOidcClientScopeDto is the user's decision on a scope, API or claim they were asked to consent to for a client
+type AuthorizedOidcClientScopeDto struct {
+	Key string `json:"key"`
+	// Type is scope, permission, api or claim
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Audience is the API the permission is for
+	Audience string `json:"audience,omitempty"`
+	Granted  bool   `json:"granted"`
+	// Revocable is whether the user can revoke it without revoking the whole authorization
+	Revocable   bool               `json:"revocable"`
+	ConsentedAt datatype.DateTime  `json:"consentedAt"`
+	ExpiresAt   *datatype.DateTime `json:"expiresAt"`
+}
+
 type OidcClientPreviewDto struct {
 	IdToken     map[string]any `json:"idToken"`
 	AccessToken map[string]any `json:"accessToken"`
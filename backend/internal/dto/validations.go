@@ -13,9 +13,11 @@ import (
 	"github.com/ory/fosite"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/crypto"
 
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
+	"github.com/lestrrat-go/jwx/v3/jwk"
 )
 
 // [a-zA-Z0-9]      : The username must start with an alphanumeric character
@@ -83,6 +85,16 @@ func init() {
 		"integer_string": func(fl validator.FieldLevel) bool {
 			return validateIntegerString(fl.Field().String())
 		},
+		"pem_certificates": func(fl validator.FieldLevel) bool {
+			_, err := crypto.ParseCertificatesPEM(fl.Field().String())
+			return err == nil
+		},
+		"public_jwks": func(fl validator.FieldLevel) bool {
+			return validatePublicJWKS(fl.Field().String())
+		},
+		"jwt_bearer_subject": func(fl validator.FieldLevel) bool {
+			return validateJWTBearerSubject(fl.Field().String())
+		},
 	}
 	for k, v := range validators {
 		err := engine.RegisterValidation(k, v)
@@ -92,6 +104,29 @@ func init() {
 	}
 }
 
+// validatePublicJWKS accepts a non-empty JSON Web Key Set that doesn't contain private keys, which must never be handed to Pocket ID
+func validatePublicJWKS(value string) bool {
+	set, err := jwk.ParseString(value)
+	if err != nil || set.Len() == 0 {
+		return false
+	}
+
+	for i := range set.Len() {
+		key, _ := set.Key(i)
+		isPrivate, err := jwk.IsPrivateKey(key)
+		if err != nil || isPrivate {
+			return false
+		}
+	}
+	return true
+}
+
+// validateJWTBearerSubject requires a wildcard subject to keep a prefix, as a bare "*" would map every subject of the issuer
+func validateJWTBearerSubject(value string) bool {
+	prefix, _ := strings.CutSuffix(value, "*")
+	return prefix != ""
+}
+
 // validateJSONStringArray requires an array so downstream consumers never receive another valid JSON type
 func validateJSONStringArray(value string) bool {
 	var items []string
@@ -171,6 +206,12 @@ func ValidationErrorDetails(validationError validator.FieldError) (string, strin
 		return "invalid_format", "must be either true or false"
 	case "integer_string":
 		return "invalid_format", "must be an integer"
+	case "pem_certificates":
+		return "invalid_format", "must contain one or more PEM-encoded certificates"
+	case "public_jwks":
+		return "invalid_format", "must be a JSON Web Key Set with one or more public keys"
+	case "jwt_bearer_subject":
+		return "invalid_format", "must be a subject or a subject prefix followed by \"*\""
 	default:
 		return validationError.Tag(), "is invalid"
 	}
@@ -230,3 +230,22 @@ func TestValidateCallbackURLPattern(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateJWTBearerSubject(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{"valid exact subject", "system:serviceaccount:ci:deployer", true},
+		{"valid wildcard with prefix", "repo:pocket-id/*", true},
+		{"invalid bare wildcard", "*", false},
+		{"invalid empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, validateJWTBearerSubject(tt.input))
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package keyrollover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/italypaleale/francis/actor"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// The SigningKeyRollover singleton actor replaces the token signing key and the additional keys of other algorithms without invalidating the tokens signed with them
+// The next keys are published in the JWKS ahead of use and then promoted, while the replaced keys stay published until every token they signed has expired
+
+// ActorType is the actor type for the signing key rollover actor
+const ActorType = "SigningKeyRollover"
+
+const (
+	// alarmRollover is the name of the repeating alarm that moves the rollover forward
+	alarmRollover = "rollover"
+
+	// rolloverCheckInterval is how often the rollover moves forward, as the ISO8601 duration the alarm repetition expects
+	rolloverCheckInterval = "PT1H"
+
+	// initialCheckDelay gives the application a moment to finish starting before the first check
+	initialCheckDelay = time.Minute
+
+	// methodStatus returns the state of the rollover
+	methodStatus = "status"
+	// methodStart publishes the next key right away, regardless of the schedule
+	methodStart = "start"
+	// methodUpdateSchedule changes how often the key is rolled over
+	methodUpdateSchedule = "update-schedule"
+
+	// publishLeadTime is how long the next key is published before it's promoted, so relying parties that cache the JWKS know it by then
+	publishLeadTime = 24 * time.Hour
+
+	// actorTimeout bounds the state and alarm operations performed by the actor
+	actorTimeout = 10 * time.Second
+)
+
+// rolloverState is the state of the rollover actor
+// The keys themselves are stored encrypted by the key provider, and the state refers to them by key ID
+type rolloverState struct {
+	// IntervalDays is how often the key is rolled over automatically, 0 if only manual rollovers are performed
+	IntervalDays int
+	// LastRolloverAt is when the signing key was last promoted
+	LastRolloverAt time.Time
+
+	// NextKeyID is the key published ahead of replacing the signing key, empty when no rollover is in progress
+	// The keys that replace the additional keys are promoted along with it
+	NextKeyID          string
+	NextKeyPublishedAt time.Time
+	PromoteAt          time.Time
+
+	RetiredKeys []retiredKey
+}
+
+type retiredKey struct {
+	KeyID string
+	// RetireAt is when every token signed with the key has expired, and it stops being published
+	RetireAt time.Time
+}
+
+// nextRolloverAt returns when the schedule promotes the next key, or the zero time if there's no schedule
+func (s rolloverState) nextRolloverAt() time.Time {
+	if s.IntervalDays <= 0 {
+		return time.Time{}
+	}
+	return s.LastRolloverAt.Add(time.Duration(s.IntervalDays) * 24 * time.Hour)
+}
+
+type scheduleRequest struct {
+	IntervalDays int
+}
+
+type startResponse struct {
+	// Started is false when a rollover was already in progress
+	Started bool
+}
+
+// rolloverActor is the cluster-wide singleton that rolls the signing key over
+type rolloverActor struct {
+	log     *slog.Logger
+	service *Service
+	// scheduleDisabled removes the alarm instead of arming it, for environments that don't roll keys over on their own
+	scheduleDisabled bool
+	client           actor.Client[rolloverState]
+}
+
+// NewActor returns the factory that allocates the signing key rollover actor
+func NewActor(service *Service, scheduleDisabled bool) actor.Factory {
+	return func(actorID string, actorService *actor.Service) actor.Actor {
+		return &rolloverActor{
+			log: slog.With(
+				slog.String("scope", "actor"),
+				slog.String("actorType", ActorType),
+			),
+			service:          service,
+			scheduleDisabled: scheduleDisabled,
+			client:           actor.NewActorClient[rolloverState](ActorType, actorID, actorService),
+		}
+	}
+}
+
+// Bootstrap implements actor.ActorBootstrapper
+// The host drives it on every startup, routed to the single owning host, so it must stay idempotent
+func (a *rolloverActor) Bootstrap(parentCtx context.Context, _ actor.Envelope) error {
+	ctx, cancel := context.WithTimeout(parentCtx, actorTimeout)
+	defer cancel()
+
+	// The key in use when the actor first runs counts as rolled over then, so enabling a schedule doesn't replace it right away
+	_, err := a.client.GetState(ctx)
+	if errors.Is(err, actor.ErrStateNotFound) {
+		err = a.client.SetState(ctx, rolloverState{LastRolloverAt: time.Now()}, nil)
+		if err != nil {
+			return fmt.Errorf("error saving actor state: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error retrieving actor state: %w", err)
+	}
+
+	// The schedule may have been enabled in a previous run, so make sure a leftover alarm doesn't keep firing
+	if a.scheduleDisabled {
+		err = a.client.DeleteAlarm(ctx, alarmRollover)
+		if err != nil && !errors.Is(err, actor.ErrAlarmNotFound) {
+			return fmt.Errorf("error deleting the signing key rollover alarm: %w", err)
+		}
+
+		return nil
+	}
+
+	// Setting the alarm replaces whatever is registered, which both restores an alarm that was lost and picks up a change to the interval
+	err = a.client.SetAlarm(ctx, alarmRollover, actor.AlarmProperties{
+		DueTime:  time.Now().Add(initialCheckDelay),
+		Interval: rolloverCheckInterval,
+	})
+	if err != nil {
+		return fmt.Errorf("error setting the signing key rollover alarm: %w", err)
+	}
+
+	a.log.DebugContext(parentCtx, "Registered the signing key rollover alarm", slog.String("interval", rolloverCheckInterval))
+
+	return nil
+}
+
+// Peek returns the state of the rollover, which is read-only
+func (a *rolloverActor) Peek(ctx context.Context, method string, _ actor.Envelope) (any, error) {
+	if method != methodStatus {
+		return nil, common.ErrUnsupportedActorMethod{Method: method}
+	}
+
+	return a.loadState(ctx)
+}
+
+// Invoke implements actor.ActorInvoke
+func (a *rolloverActor) Invoke(ctx context.Context, method string, data actor.Envelope) (any, error) {
+	switch method {
+	case methodStart:
+		return a.start(ctx)
+	case methodUpdateSchedule:
+		if data == nil {
+			return nil, fmt.Errorf("request body is empty for method '%s'", method)
+		}
+		var req scheduleRequest
+		err := data.Decode(&req)
+		if err != nil {
+			return nil, fmt.Errorf("request body is not valid for method '%s': %w", method, err)
+		}
+		return nil, a.updateSchedule(ctx, req)
+	default:
+		return nil, common.ErrUnsupportedActorMethod{Method: method}
+	}
+}
+
+// Alarm implements actor.ActorAlarm
+func (a *rolloverActor) Alarm(ctx context.Context, name string, _ actor.Envelope) error {
+	if name != alarmRollover {
+		return fmt.Errorf("unsupported alarm '%s' for the %s actor", name, ActorType)
+	}
+
+	err := a.advance(ctx, time.Now())
+	if err != nil {
+		// A failure never surfaces as an error: the framework would retry the occurrence and then delete the alarm once the attempts run out, which would stop the rollover altogether
+		// Every step can be repeated, so the next occurrence picks up where this one failed
+		a.log.ErrorContext(ctx, "Signing key rollover failed, will try again on the next run", slog.Any("error", err))
+	}
+
+	return nil
+}
+
+// advance moves the rollover forward
+// It promotes the next key once it's due, stops publishing the retired keys whose tokens have expired, and publishes the next key when the schedule calls for it
+func (a *rolloverActor) advance(ctx context.Context, now time.Time) error {
+	state, err := a.loadState(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	if state.NextKeyID != "" && !now.Before(state.PromoteAt) {
+		retiredKeyIDs, err := a.service.promoteNextKey(ctx, state.NextKeyID)
+		if err != nil {
+			return fmt.Errorf("failed to promote the next key: %w", err)
+		}
+		a.log.InfoContext(ctx, "Promoted the next signing keys", slog.String("key_id", state.NextKeyID), slog.Any("retired_key_ids", retiredKeyIDs))
+
+		state.LastRolloverAt = now
+		state.NextKeyID = ""
+		state.NextKeyPublishedAt = time.Time{}
+		state.PromoteAt = time.Time{}
+		changed = true
+
+		// The state is saved right away, as the key has been replaced regardless of what happens next
+		err = a.saveState(ctx, state)
+		if err != nil {
+			return err
+		}
+	}
+
+	retiredChanged, err := a.retireKeys(ctx, &state, now)
+	if err != nil {
+		return err
+	}
+	changed = changed || retiredChanged
+
+	if next := state.nextRolloverAt(); state.NextKeyID == "" && !next.IsZero() && !now.Before(next.Add(-publishLeadTime)) {
+		err = a.publishNextKey(ctx, &state, now)
+		if err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	err = a.saveState(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	// The other replicas pick up the keys on their next reload
+	return a.service.reloadKeys(ctx)
+}
+
+// retireKeys gives every retired key a time at which it stops being published, and removes the keys whose time has come
+func (a *rolloverActor) retireKeys(ctx context.Context, state *rolloverState, now time.Time) (bool, error) {
+	stored, err := a.service.retiredKeyIDs(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+
+	// The state doesn't know about a key retired by the promotion, or by one that was interrupted before the state was saved
+	var lifetime time.Duration
+	for _, keyID := range stored {
+		if slices.ContainsFunc(state.RetiredKeys, func(k retiredKey) bool { return k.KeyID == keyID }) {
+			continue
+		}
+		if lifetime == 0 {
+			lifetime, err = a.service.maxTokenLifetime(ctx)
+			if err != nil {
+				return false, err
+			}
+		}
+		state.RetiredKeys = append(state.RetiredKeys, retiredKey{KeyID: keyID, RetireAt: now.Add(lifetime)})
+		changed = true
+	}
+
+	var expired []string
+	state.RetiredKeys = slices.DeleteFunc(state.RetiredKeys, func(k retiredKey) bool {
+		switch {
+		case !slices.Contains(stored, k.KeyID):
+			changed = true
+			return true
+		case !now.Before(k.RetireAt):
+			expired = append(expired, k.KeyID)
+			changed = true
+			return true
+		default:
+			return false
+		}
+	})
+
+	if len(expired) > 0 {
+		err = a.service.removeRetiredKeys(ctx, expired)
+		if err != nil {
+			return false, fmt.Errorf("failed to remove the retired keys: %w", err)
+		}
+		a.log.InfoContext(ctx, "Stopped publishing the retired signing keys", slog.Any("key_ids", expired))
+	}
+
+	return changed, nil
+}
+
+// publishNextKey publishes a new key, which is promoted once relying parties have had the time to pick it up
+func (a *rolloverActor) publishNextKey(ctx context.Context, state *rolloverState, now time.Time) error {
+	keyID, err := a.service.publishNextKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to publish the next key: %w", err)
+	}
+
+	state.NextKeyID = keyID
+	state.NextKeyPublishedAt = now
+	state.PromoteAt = now.Add(publishLeadTime)
+
+	a.log.InfoContext(ctx, "Published the next signing key", slog.String("key_id", keyID), slog.Time("promote_at", state.PromoteAt))
+
+	return nil
+}
+
+func (a *rolloverActor) start(ctx context.Context) (startResponse, error) {
+	state, err := a.loadState(ctx)
+	if err != nil {
+		return startResponse{}, err
+	}
+	if state.NextKeyID != "" {
+		return startResponse{Started: false}, nil
+	}
+
+	err = a.publishNextKey(ctx, &state, time.Now())
+	if err != nil {
+		return startResponse{}, err
+	}
+	err = a.saveState(ctx, state)
+	if err != nil {
+		return startResponse{}, err
+	}
+	err = a.service.reloadKeys(ctx)
+	if err != nil {
+		return startResponse{}, err
+	}
+
+	return startResponse{Started: true}, nil
+}
+
+func (a *rolloverActor) updateSchedule(ctx context.Context, req scheduleRequest) error {
+	state, err := a.loadState(ctx)
+	if err != nil {
+		return err
+	}
+
+	state.IntervalDays = req.IntervalDays
+	return a.saveState(ctx, state)
+}
+
+func (a *rolloverActor) loadState(parentCtx context.Context) (rolloverState, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, actorTimeout)
+	defer cancel()
+
+	state, err := a.client.GetState(ctx)
+	if errors.Is(err, actor.ErrStateNotFound) {
+		// Bootstrap initializes the state, but it runs asynchronously after the host becomes ready
+		return rolloverState{LastRolloverAt: time.Now()}, nil
+	} else if err != nil {
+		return rolloverState{}, fmt.Errorf("error retrieving actor state: %w", err)
+	}
+
+	return state, nil
+}
+
+func (a *rolloverActor) saveState(parentCtx context.Context, state rolloverState) error {
+	ctx, cancel := context.WithTimeout(parentCtx, actorTimeout)
+	defer cancel()
+
+	err := a.client.SetState(ctx, state, nil)
+	if err != nil {
+		return fmt.Errorf("error saving actor state: %w", err)
+	}
+
+	return nil
+}
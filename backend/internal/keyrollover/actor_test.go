@@ -0,0 +1,273 @@
+package keyrollover
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/italypaleale/francis/actor"
+	"github.com/italypaleale/francis/host/local"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/appconfig"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	jwkutils "github.com/pocket-id/pocket-id/backend/internal/utils/jwk"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+// fakeAppConfigResolver returns a fixed application configuration
+type fakeAppConfigResolver struct {
+	config *appconfig.AppConfigModel
+}
+
+func (f fakeAppConfigResolver) GetConfig(_ context.Context) (*appconfig.AppConfigModel, error) {
+	return f.config, nil
+}
+
+type fakeKeyReloader struct {
+	calls atomic.Int32
+}
+
+func (r *fakeKeyReloader) ReloadKeys(_ context.Context) error {
+	r.calls.Add(1)
+	return nil
+}
+
+func TestActorBootstrapArmsAlarm(t *testing.T) {
+	host, act, _, _ := newRolloverActorForTest(t, false)
+
+	require.NoError(t, act.Bootstrap(t.Context(), nil))
+	require.NoError(t, act.Bootstrap(t.Context(), nil))
+
+	properties, err := host.GetAlarm(t.Context(), ActorType, actor.SingletonActorID, alarmRollover)
+	require.NoError(t, err)
+	assert.Equal(t, rolloverCheckInterval, properties.Interval)
+	assert.WithinDuration(t, time.Now().Add(initialCheckDelay), properties.DueTime, time.Second)
+
+	state, err := act.loadState(t.Context())
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), state.LastRolloverAt, time.Second)
+	assert.Empty(t, state.NextKeyID)
+}
+
+func TestActorBootstrapRemovesAlarmWhenDisabled(t *testing.T) {
+	host, act, _, _ := newRolloverActorForTest(t, true)
+
+	require.NoError(t, host.SetAlarm(t.Context(), ActorType, actor.SingletonActorID, alarmRollover, actor.AlarmProperties{
+		DueTime: time.Now().Add(time.Hour),
+	}))
+
+	require.NoError(t, act.Bootstrap(t.Context(), nil))
+
+	_, err := host.GetAlarm(t.Context(), ActorType, actor.SingletonActorID, alarmRollover)
+	require.ErrorIs(t, err, actor.ErrAlarmNotFound)
+}
+
+func TestActorRollover(t *testing.T) {
+	_, act, keyProvider, reloader := newRolloverActorForTest(t, true)
+	originalKeyID := saveSigningKey(t, keyProvider)
+
+	res, err := act.start(t.Context())
+	require.NoError(t, err)
+	assert.True(t, res.Started)
+
+	state, err := act.loadState(t.Context())
+	require.NoError(t, err)
+	require.NotEmpty(t, state.NextKeyID)
+	assert.WithinDuration(t, time.Now().Add(publishLeadTime), state.PromoteAt, time.Second)
+	assert.EqualValues(t, 1, reloader.calls.Load())
+
+	next, err := keyProvider.LoadNextKey(t.Context())
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, state.NextKeyID, keyID(next))
+	nextAlg, _ := next.Algorithm()
+	assert.Equal(t, "ES256", nextAlg.String())
+
+	t.Run("a second start is rejected while the rollover is in progress", func(t *testing.T) {
+		res, err := act.start(t.Context())
+		require.NoError(t, err)
+		assert.False(t, res.Started)
+	})
+
+	t.Run("the next key is not promoted before it's due", func(t *testing.T) {
+		require.NoError(t, act.advance(t.Context(), state.PromoteAt.Add(-time.Minute)))
+
+		current, err := keyProvider.LoadKey(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, originalKeyID, keyID(current))
+	})
+
+	promotedAt := state.PromoteAt.Add(time.Minute)
+	t.Run("the next key is promoted and the current key retired", func(t *testing.T) {
+		require.NoError(t, act.advance(t.Context(), promotedAt))
+
+		current, err := keyProvider.LoadKey(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, state.NextKeyID, keyID(current))
+
+		next, err := keyProvider.LoadNextKey(t.Context())
+		require.NoError(t, err)
+		assert.Nil(t, next)
+
+		retired, err := keyProvider.LoadRetiredKeys(t.Context())
+		require.NoError(t, err)
+		require.Len(t, retired, 1)
+		assert.Equal(t, originalKeyID, keyID(retired[0]))
+
+		promoted, err := act.loadState(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, promoted.NextKeyID)
+		assert.WithinDuration(t, promotedAt, promoted.LastRolloverAt, 0)
+		require.Len(t, promoted.RetiredKeys, 1)
+		assert.Equal(t, originalKeyID, promoted.RetiredKeys[0].KeyID)
+		// The default session lasts longer than any token lifetime configured in the test
+		assert.WithinDuration(t, promotedAt.Add(time.Hour+keyReloadInterval+clockSkew), promoted.RetiredKeys[0].RetireAt, 0)
+	})
+
+	t.Run("the retired key stops being published once its tokens have expired", func(t *testing.T) {
+		promoted, err := act.loadState(t.Context())
+		require.NoError(t, err)
+
+		require.NoError(t, act.advance(t.Context(), promoted.RetiredKeys[0].RetireAt.Add(-time.Second)))
+		retired, err := keyProvider.LoadRetiredKeys(t.Context())
+		require.NoError(t, err)
+		assert.Len(t, retired, 1)
+
+		require.NoError(t, act.advance(t.Context(), promoted.RetiredKeys[0].RetireAt))
+		retired, err = keyProvider.LoadRetiredKeys(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, retired)
+
+		final, err := act.loadState(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, final.RetiredKeys)
+	})
+}
+
+func TestActorRolloverReplacesAdditionalKeys(t *testing.T) {
+	_, act, keyProvider, _ := newRolloverActorForTest(t, true)
+	originalKeyID := saveSigningKey(t, keyProvider)
+	additionalKey, err := jwkutils.GenerateKey("RS256", "")
+	require.NoError(t, err)
+	require.NoError(t, keyProvider.SaveAdditionalKeys(t.Context(), []jwk.Key{additionalKey}))
+
+	_, err = act.start(t.Context())
+	require.NoError(t, err)
+
+	nextAdditional, err := keyProvider.LoadNextAdditionalKeys(t.Context())
+	require.NoError(t, err)
+	require.Len(t, nextAdditional, 1)
+	nextAlg, _ := nextAdditional[0].Algorithm()
+	assert.Equal(t, "RS256", nextAlg.String())
+
+	state, err := act.loadState(t.Context())
+	require.NoError(t, err)
+	status, err := act.service.status(t.Context(), state)
+	require.NoError(t, err)
+	require.NotNil(t, status.NextKey)
+	assert.Equal(t, []AdditionalKeyDto{{KeyID: keyID(nextAdditional[0]), Algorithm: "RS256"}}, status.NextKey.AdditionalKeys)
+
+	require.NoError(t, act.advance(t.Context(), state.PromoteAt))
+
+	additional, err := keyProvider.LoadAdditionalKeys(t.Context())
+	require.NoError(t, err)
+	require.Len(t, additional, 1)
+	assert.Equal(t, keyID(nextAdditional[0]), keyID(additional[0]))
+
+	nextAdditional, err = keyProvider.LoadNextAdditionalKeys(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, nextAdditional)
+
+	retired, err := keyProvider.LoadRetiredKeys(t.Context())
+	require.NoError(t, err)
+	require.Len(t, retired, 2)
+	assert.ElementsMatch(t, []string{originalKeyID, keyID(additionalKey)}, []string{keyID(retired[0]), keyID(retired[1])})
+
+	promoted, err := act.loadState(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, promoted.RetiredKeys, 2)
+}
+
+func TestActorScheduledRollover(t *testing.T) {
+	_, act, keyProvider, _ := newRolloverActorForTest(t, true)
+	saveSigningKey(t, keyProvider)
+
+	lastRolloverAt := time.Now().Add(-10 * 24 * time.Hour)
+	require.NoError(t, act.saveState(t.Context(), rolloverState{LastRolloverAt: lastRolloverAt}))
+
+	t.Run("no key is published without a schedule", func(t *testing.T) {
+		require.NoError(t, act.advance(t.Context(), time.Now()))
+
+		state, err := act.loadState(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, state.NextKeyID)
+	})
+
+	require.NoError(t, act.updateSchedule(t.Context(), scheduleRequest{IntervalDays: 30}))
+
+	t.Run("no key is published before the lead time", func(t *testing.T) {
+		require.NoError(t, act.advance(t.Context(), time.Now()))
+
+		state, err := act.loadState(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, state.NextKeyID)
+	})
+
+	t.Run("the next key is published ahead of the scheduled rollover", func(t *testing.T) {
+		now := lastRolloverAt.Add(30*24*time.Hour - publishLeadTime)
+		require.NoError(t, act.advance(t.Context(), now))
+
+		state, err := act.loadState(t.Context())
+		require.NoError(t, err)
+		assert.NotEmpty(t, state.NextKeyID)
+		assert.WithinDuration(t, now.Add(publishLeadTime), state.PromoteAt, 0)
+	})
+}
+
+func TestActorAlarmDoesNotFail(t *testing.T) {
+	_, act, _, _ := newRolloverActorForTest(t, true)
+
+	// There's no signing key, so publishing the next key fails
+	require.NoError(t, act.updateSchedule(t.Context(), scheduleRequest{IntervalDays: 1}))
+	require.Error(t, act.advance(t.Context(), time.Now()))
+
+	require.NoError(t, act.Alarm(t.Context(), alarmRollover, nil))
+}
+
+func newRolloverActorForTest(t *testing.T, scheduleDisabled bool) (*local.Host, *rolloverActor, jwkutils.KeyProvider, *fakeKeyReloader) {
+	t.Helper()
+
+	db := testutils.NewDatabaseForTest(t)
+	keyProvider, err := jwkutils.GetKeyProvider(db, &common.EnvConfigSchema{
+		EncryptionKey: []byte("0123456789abcdef0123456789abcdef"),
+	}, "test-instance")
+	require.NoError(t, err)
+
+	reloader := &fakeKeyReloader{}
+	service := &Service{
+		db:          db,
+		keyProvider: keyProvider,
+		keys:        reloader,
+		appConfig:   fakeAppConfigResolver{config: appconfig.NewTestConfig(nil)},
+	}
+
+	host := testutils.NewActorHostForTest(t, nil)
+	act, ok := NewActor(service, scheduleDisabled)(actor.SingletonActorID, host.Service()).(*rolloverActor)
+	require.True(t, ok)
+
+	return host, act, keyProvider, reloader
+}
+
+func saveSigningKey(t *testing.T, keyProvider jwkutils.KeyProvider) string {
+	t.Helper()
+
+	key, err := jwkutils.GenerateKey("ES256", "")
+	require.NoError(t, err)
+	require.NoError(t, keyProvider.SaveKey(t.Context(), key))
+
+	return keyID(key)
+}
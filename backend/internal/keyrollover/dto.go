@@ -0,0 +1,41 @@
+package keyrollover
+
+import (
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+type RolloverStatusDto struct {
+	CurrentKeyID string `json:"currentKeyId"`
+	Algorithm    string `json:"algorithm"`
+	// AdditionalKeys are the signing keys of other algorithms, which are rolled over along with the current key
+	AdditionalKeys []AdditionalKeyDto `json:"additionalKeys"`
+	// IntervalDays is how often the signing key is rolled over automatically, 0 if only manual rollovers are performed
+	IntervalDays   int                `json:"intervalDays"`
+	LastRolloverAt datatype.DateTime  `json:"lastRolloverAt"`
+	NextRolloverAt *datatype.DateTime `json:"nextRolloverAt"`
+	NextKey        *NextKeyDto        `json:"nextKey"`
+	RetiredKeys    []RetiredKeyDto    `json:"retiredKeys"`
+}
+
+type AdditionalKeyDto struct {
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+}
+
+type NextKeyDto struct {
+	KeyID string `json:"keyId"`
+	// AdditionalKeys replace the additional keys of the same algorithms when the next key is promoted
+	AdditionalKeys []AdditionalKeyDto `json:"additionalKeys"`
+	PublishedAt    datatype.DateTime  `json:"publishedAt"`
+	PromoteAt      datatype.DateTime  `json:"promoteAt"`
+}
+
+type RetiredKeyDto struct {
+	KeyID    string            `json:"keyId"`
+	RetireAt datatype.DateTime `json:"retireAt"`
+}
+
+type RolloverScheduleUpdateDto struct {
+	// The interval must exceed the time the next key is published ahead of its promotion
+	IntervalDays int `json:"intervalDays" binding:"omitempty,min=2,max=365"`
+}
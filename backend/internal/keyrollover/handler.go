@@ -0,0 +1,79 @@
+package keyrollover
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/httpserver"
+)
+
+type handler struct {
+	service *Service
+}
+
+func newHandler(service *Service) *handler {
+	return &handler{service: service}
+}
+
+// getStatus godoc
+// @Summary Get signing key rollover status
+// @Description Get the current signing key, the key published ahead of a rollover, and the retired keys that are still published
+// @Tags Signing Keys
+// @Produce json
+// @Success 200 {object} RolloverStatusDto "Signing key rollover status"
+// @Failure default {object} dto.ErrorDto "Error"
+// @Router /api/signing-keys/rollover [get]
+func (h *handler) getStatus(c *gin.Context) error {
+	status, err := h.service.Status(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, status)
+	return nil
+}
+
+// startRollover godoc
+// @Summary Start signing key rollover
+// @Description Publish a new signing key right away; it replaces the current key once it has been published long enough
+// @Tags Signing Keys
+// @Produce json
+// @Success 202 {object} RolloverStatusDto "Signing key rollover status"
+// @Failure default {object} dto.ErrorDto "Error"
+// @Router /api/signing-keys/rollover [post]
+func (h *handler) startRollover(c *gin.Context) error {
+	status, err := h.service.StartRollover(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusAccepted, status)
+	return nil
+}
+
+// updateSchedule godoc
+// @Summary Update signing key rollover schedule
+// @Description Set how often the signing key is rolled over automatically, or 0 to only roll it over manually
+// @Tags Signing Keys
+// @Accept json
+// @Produce json
+// @Param schedule body RolloverScheduleUpdateDto true "Rollover schedule"
+// @Success 200 {object} RolloverStatusDto "Signing key rollover status"
+// @Failure default {object} dto.ErrorDto "Error"
+// @Router /api/signing-keys/rollover/schedule [put]
+func (h *handler) updateSchedule(c *gin.Context) error {
+	var input RolloverScheduleUpdateDto
+	err := httpserver.BindJSON(c, &input)
+	if err != nil {
+		return err
+	}
+
+	status, err := h.service.UpdateSchedule(c.Request.Context(), input)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, status)
+	return nil
+}
@@ -0,0 +1,85 @@
+package keyrollover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/italypaleale/francis/host/local"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/appconfig"
+	"github.com/pocket-id/pocket-id/backend/internal/httpserver"
+	jwkutils "github.com/pocket-id/pocket-id/backend/internal/utils/jwk"
+)
+
+// keyReloadInterval is how often each replica loads the keys again, picking up the rollover steps performed on any replica
+// The next key is published long before it's promoted, so a replica that hasn't reloaded yet already verifies the tokens it signs
+const keyReloadInterval = time.Minute
+
+// KeyReloader holds the keys in memory and loads them from the key provider again on request
+type KeyReloader interface {
+	ReloadKeys(ctx context.Context) error
+}
+
+type Dependencies struct {
+	DB          *gorm.DB
+	Actors      *local.Host
+	KeyProvider jwkutils.KeyProvider
+	Keys        KeyReloader
+	AppConfig   appconfig.AppConfigResolver
+
+	// ScheduleDisabled keeps the rollover alarm from being armed
+	// It's set in the test environment, where the signing key must not change under the end-to-end tests
+	ScheduleDisabled bool
+}
+
+type Module struct {
+	service *Service
+	handler *handler
+}
+
+func New(deps Dependencies) (*Module, error) {
+	service := newService(deps)
+
+	// Register the actor that drives the rollover
+	// It's a singleton, so every step runs once per cluster rather than once per replica
+	err := deps.Actors.RegisterSingletonActor(ActorType, NewActor(service, deps.ScheduleDisabled))
+	if err != nil {
+		return nil, fmt.Errorf("error registering the %s actor: %w", ActorType, err)
+	}
+
+	return &Module{
+		service: service,
+		handler: newHandler(service),
+	}, nil
+}
+
+// RegisterRoutes mounts the signing key rollover endpoints
+// auth guards them, as they're admin-only operations
+func (m *Module) RegisterRoutes(apiGroup *gin.RouterGroup, auth gin.HandlerFunc) {
+	apiGroup.GET("/signing-keys/rollover", auth, httpserver.Handle(m.handler.getStatus))
+	apiGroup.POST("/signing-keys/rollover", auth, httpserver.Handle(m.handler.startRollover))
+	apiGroup.PUT("/signing-keys/rollover/schedule", auth, httpserver.Handle(m.handler.updateSchedule))
+}
+
+// Run reloads the keys of this replica periodically, so a rollover never requires a restart
+// It blocks until the context is canceled
+func (m *Module) Run(ctx context.Context) error {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := m.service.reloadKeys(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to reload the signing keys, will try again on the next run", slog.Any("error", err))
+			}
+		}
+	}
+}
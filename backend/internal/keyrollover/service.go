@@ -0,0 +1,396 @@
+package keyrollover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/italypaleale/francis/actor"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/appconfig"
+	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	jwkutils "github.com/pocket-id/pocket-id/backend/internal/utils/jwk"
+)
+
+const (
+	// defaultTokenLifetime is the lifetime of the OIDC tokens that don't follow a configurable lifetime, such as ID tokens
+	defaultTokenLifetime = time.Hour
+
+	// clockSkew is the clock skew accepted when verifying tokens, during which an expired token is still valid
+	clockSkew = time.Minute
+)
+
+// Service performs the steps of a signing key rollover on the stored keys
+type Service struct {
+	db          *gorm.DB
+	actors      *actor.Service
+	keyProvider jwkutils.KeyProvider
+	keys        KeyReloader
+	appConfig   appconfig.AppConfigResolver
+}
+
+func newService(deps Dependencies) *Service {
+	return &Service{
+		db:          deps.DB,
+		actors:      deps.Actors.Service(),
+		keyProvider: deps.KeyProvider,
+		keys:        deps.Keys,
+		appConfig:   deps.AppConfig,
+	}
+}
+
+// Status returns the state of the rollover
+func (s *Service) Status(ctx context.Context) (RolloverStatusDto, error) {
+	res, err := s.actors.Peek(ctx, ActorType, actor.SingletonActorID, methodStatus, nil)
+	if err != nil {
+		return RolloverStatusDto{}, fmt.Errorf("error retrieving the rollover state from the actor: %w", err)
+	}
+	if res == nil {
+		return RolloverStatusDto{}, errors.New("rollover actor response was empty")
+	}
+
+	var state rolloverState
+	err = res.Decode(&state)
+	if err != nil {
+		return RolloverStatusDto{}, fmt.Errorf("error decoding rollover actor response: %w", err)
+	}
+
+	return s.status(ctx, state)
+}
+
+// StartRollover publishes the next key right away, which is promoted once it has been published long enough
+func (s *Service) StartRollover(ctx context.Context) (RolloverStatusDto, error) {
+	res, err := s.actors.Invoke(ctx, ActorType, actor.SingletonActorID, methodStart, nil)
+	if err != nil {
+		return RolloverStatusDto{}, fmt.Errorf("error starting the rollover: %w", err)
+	}
+	if res == nil {
+		return RolloverStatusDto{}, errors.New("rollover actor response was empty")
+	}
+
+	var result startResponse
+	err = res.Decode(&result)
+	if err != nil {
+		return RolloverStatusDto{}, fmt.Errorf("error decoding rollover actor response: %w", err)
+	}
+	if !result.Started {
+		return RolloverStatusDto{}, apperror.SigningKeyRolloverInProgress()
+	}
+
+	return s.Status(ctx)
+}
+
+// UpdateSchedule changes how often the key is rolled over automatically, 0 for manual rollovers only
+func (s *Service) UpdateSchedule(ctx context.Context, input RolloverScheduleUpdateDto) (RolloverStatusDto, error) {
+	_, err := s.actors.Invoke(ctx, ActorType, actor.SingletonActorID, methodUpdateSchedule, scheduleRequest{
+		IntervalDays: input.IntervalDays,
+	})
+	if err != nil {
+		return RolloverStatusDto{}, fmt.Errorf("error updating the rollover schedule: %w", err)
+	}
+
+	return s.Status(ctx)
+}
+
+// publishNextKey generates the keys that replace the signing key and each of the additional keys, with the same algorithms, and publishes them
+// It returns the ID of the key that replaces the signing key
+func (s *Service) publishNextKey(ctx context.Context) (string, error) {
+	current, err := s.loadCurrentKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	additional, err := s.keyProvider.LoadAdditionalKeys(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to load additional keys: %w", err)
+	}
+
+	key, err := generateKeyLike(current)
+	if err != nil {
+		return "", err
+	}
+	nextAdditional := make([]jwk.Key, len(additional))
+	for i, additionalKey := range additional {
+		nextAdditional[i], err = generateKeyLike(additionalKey)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The next additional keys are stored first, as the rollover only starts once the next key is stored
+	err = s.keyProvider.SaveNextAdditionalKeys(ctx, nextAdditional)
+	if err != nil {
+		return "", fmt.Errorf("failed to store next additional keys: %w", err)
+	}
+	err = s.keyProvider.SaveNextKey(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to store next key: %w", err)
+	}
+
+	return keyID(key), nil
+}
+
+// promoteNextKey makes the next keys the signing key and the additional keys, and retires the keys they replace
+// It returns the IDs of the retired keys, which is empty if the next key had already been promoted by an interrupted attempt
+func (s *Service) promoteNextKey(ctx context.Context, nextKeyID string) ([]string, error) {
+	current, err := s.loadCurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if keyID(current) == nextKeyID {
+		return nil, s.deleteNextKeys(ctx)
+	}
+
+	next, err := s.keyProvider.LoadNextKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load next key: %w", err)
+	}
+	if next == nil || keyID(next) != nextKeyID {
+		return nil, fmt.Errorf("next key '%s' not found", nextKeyID)
+	}
+	nextAdditional, err := s.keyProvider.LoadNextAdditionalKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load next additional keys: %w", err)
+	}
+	additional, err := s.keyProvider.LoadAdditionalKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load additional keys: %w", err)
+	}
+
+	// Each additional key is replaced by the next key of its algorithm, while a key added after the next keys were published is kept
+	replaced := []jwk.Key{current}
+	promoted := make([]jwk.Key, len(additional))
+	for i, key := range additional {
+		promoted[i] = key
+		replacement := keyWithAlgorithmOf(nextAdditional, key)
+		if replacement != nil && keyID(replacement) != keyID(key) {
+			promoted[i] = replacement
+			replaced = append(replaced, key)
+		}
+	}
+
+	// The replaced keys are retired before they're replaced, so they stay published at every step of the promotion
+	retired, err := s.keyProvider.LoadRetiredKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retired keys: %w", err)
+	}
+	retiredKeyIDs := make([]string, len(replaced))
+	retiredCount := len(retired)
+	for i, key := range replaced {
+		retiredKeyIDs[i] = keyID(key)
+		if !slices.ContainsFunc(retired, func(k jwk.Key) bool { return keyID(k) == retiredKeyIDs[i] }) {
+			retired = append(retired, key)
+		}
+	}
+	if len(retired) != retiredCount {
+		err = s.keyProvider.SaveRetiredKeys(ctx, retired)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store retired keys: %w", err)
+		}
+	}
+
+	err = s.keyProvider.SaveAdditionalKeys(ctx, promoted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store additional keys: %w", err)
+	}
+	err = s.keyProvider.SaveKey(ctx, next)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %w", err)
+	}
+	err = s.deleteNextKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return retiredKeyIDs, nil
+}
+
+// deleteNextKeys stops publishing the next keys once they've been promoted
+func (s *Service) deleteNextKeys(ctx context.Context) error {
+	err := s.keyProvider.SaveNextAdditionalKeys(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete next additional keys: %w", err)
+	}
+	err = s.keyProvider.DeleteNextKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete next key: %w", err)
+	}
+	return nil
+}
+
+// retiredKeyIDs returns the IDs of the stored retired keys
+func (s *Service) retiredKeyIDs(ctx context.Context) ([]string, error) {
+	retired, err := s.keyProvider.LoadRetiredKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retired keys: %w", err)
+	}
+
+	keyIDs := make([]string, len(retired))
+	for i, key := range retired {
+		keyIDs[i] = keyID(key)
+	}
+	return keyIDs, nil
+}
+
+// removeRetiredKeys stops publishing the retired keys with the given IDs
+func (s *Service) removeRetiredKeys(ctx context.Context, keyIDs []string) error {
+	retired, err := s.keyProvider.LoadRetiredKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load retired keys: %w", err)
+	}
+
+	remaining := slices.DeleteFunc(retired, func(key jwk.Key) bool {
+		return slices.Contains(keyIDs, keyID(key))
+	})
+	err = s.keyProvider.SaveRetiredKeys(ctx, remaining)
+	if err != nil {
+		return fmt.Errorf("failed to store retired keys: %w", err)
+	}
+
+	return nil
+}
+
+// maxTokenLifetime returns how long a key must remain published after it's retired, so every token it signed has expired by then
+func (s *Service) maxTokenLifetime(ctx context.Context) (time.Duration, error) {
+	dbConfig, err := s.appConfig.GetConfig(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load app configuration: %w", err)
+	}
+
+	var maxAccessTokenMinutes int64
+	err = s.db.
+		WithContext(ctx).
+		Model(&model.OidcClient{}).
+		Select("COALESCE(MAX(access_token_duration_minutes), 0)").
+		Scan(&maxAccessTokenMinutes).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the access token lifetimes: %w", err)
+	}
+
+	lifetime := max(defaultTokenLifetime, dbConfig.SessionDuration.AsDurationMinutes(), time.Duration(maxAccessTokenMinutes)*time.Minute)
+
+	// Replicas keep signing with the retired key until they reload the keys
+	return lifetime + keyReloadInterval + clockSkew, nil
+}
+
+// reloadKeys loads the keys in this replica right away, while the others pick them up on their next reload
+func (s *Service) reloadKeys(ctx context.Context) error {
+	err := s.keys.ReloadKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reload keys: %w", err)
+	}
+	return nil
+}
+
+// status describes the rollover for the admin API
+func (s *Service) status(ctx context.Context, state rolloverState) (RolloverStatusDto, error) {
+	current, err := s.loadCurrentKey(ctx)
+	if err != nil {
+		return RolloverStatusDto{}, err
+	}
+	alg, _ := current.Algorithm()
+	additional, err := s.keyProvider.LoadAdditionalKeys(ctx)
+	if err != nil {
+		return RolloverStatusDto{}, fmt.Errorf("failed to load additional keys: %w", err)
+	}
+
+	status := RolloverStatusDto{
+		CurrentKeyID:   keyID(current),
+		Algorithm:      alg.String(),
+		AdditionalKeys: additionalKeyDtos(additional),
+		IntervalDays:   state.IntervalDays,
+		LastRolloverAt: datatype.DateTime(state.LastRolloverAt),
+		RetiredKeys:    make([]RetiredKeyDto, len(state.RetiredKeys)),
+	}
+	if state.NextKeyID != "" {
+		nextAdditional, err := s.keyProvider.LoadNextAdditionalKeys(ctx)
+		if err != nil {
+			return RolloverStatusDto{}, fmt.Errorf("failed to load next additional keys: %w", err)
+		}
+		status.NextKey = &NextKeyDto{
+			KeyID:          state.NextKeyID,
+			AdditionalKeys: additionalKeyDtos(nextAdditional),
+			PublishedAt:    datatype.DateTime(state.NextKeyPublishedAt),
+			PromoteAt:      datatype.DateTime(state.PromoteAt),
+		}
+	}
+	if next := state.nextRolloverAt(); !next.IsZero() {
+		status.NextRolloverAt = new(datatype.DateTime(next))
+	}
+	for i, retired := range state.RetiredKeys {
+		status.RetiredKeys[i] = RetiredKeyDto{
+			KeyID:    retired.KeyID,
+			RetireAt: datatype.DateTime(retired.RetireAt),
+		}
+	}
+
+	return status, nil
+}
+
+func additionalKeyDtos(keys []jwk.Key) []AdditionalKeyDto {
+	dtos := make([]AdditionalKeyDto, len(keys))
+	for i, key := range keys {
+		dtos[i].KeyID = keyID(key)
+		if alg, ok := key.Algorithm(); ok && alg != nil {
+			dtos[i].Algorithm = alg.String()
+		}
+	}
+	return dtos
+}
+
+func (s *Service) loadCurrentKey(ctx context.Context) (jwk.Key, error) {
+	key, err := s.keyProvider.LoadKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key: %w", err)
+	}
+	if key == nil {
+		return nil, errors.New("signing key not found")
+	}
+	return key, nil
+}
+
+// generateKeyLike generates a new key with the algorithm of the key
+func generateKeyLike(key jwk.Key) (jwk.Key, error) {
+	alg, ok := key.Algorithm()
+	if !ok || alg == nil {
+		return nil, fmt.Errorf("key '%s' does not contain an algorithm", keyID(key))
+	}
+	var crv string
+	if alg.String() == jwa.EdDSA().String() {
+		// Ed25519 is the only curve supported for EdDSA keys
+		crv = jwa.Ed25519().String()
+	}
+
+	newKey, err := jwkutils.GenerateKey(alg.String(), crv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return newKey, nil
+}
+
+// keyWithAlgorithmOf returns the key among the keys that has the algorithm of the given key, or nil if there's none
+func keyWithAlgorithmOf(keys []jwk.Key, key jwk.Key) jwk.Key {
+	alg, ok := key.Algorithm()
+	if !ok || alg == nil {
+		return nil
+	}
+	for _, k := range keys {
+		kAlg, ok := k.Algorithm()
+		if ok && kAlg != nil && kAlg.String() == alg.String() {
+			return k
+		}
+	}
+	return nil
+}
+
+func keyID(key jwk.Key) string {
+	kid, _ := key.KeyID()
+	return kid
+}
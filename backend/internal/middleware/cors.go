@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -50,6 +51,7 @@ func isCorsPath(path string) bool {
 		"/.well-known/oauth-authorization-server":
 		return true
 	default:
-		return false
+		// Browser-based clients read the metadata of an API before they start authorization
+		return strings.HasPrefix(path, "/.well-known/oauth-protected-resource/")
 	}
 }
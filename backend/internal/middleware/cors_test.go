@@ -14,23 +14,30 @@ func TestCorsMiddlewareAllowsDiscoveryDocuments(t *testing.T) {
 	router := gin.New()
 	router.Use(NewCorsMiddleware().Add())
 
-	paths := []string{"/.well-known/openid-configuration", "/.well-known/oauth-authorization-server"}
-	for _, path := range paths {
-		router.GET(path, func(c *gin.Context) {
+	tests := []struct {
+		route string
+		path  string
+	}{
+		{route: "/.well-known/openid-configuration", path: "/.well-known/openid-configuration"},
+		{route: "/.well-known/oauth-authorization-server", path: "/.well-known/oauth-authorization-server"},
+		{route: "/.well-known/oauth-protected-resource/apis/:id", path: "/.well-known/oauth-protected-resource/apis/api-1"},
+	}
+	for _, tt := range tests {
+		router.GET(tt.route, func(c *gin.Context) {
 			c.Status(http.StatusOK)
 		})
 	}
 
-	for _, path := range paths {
-		t.Run(path, func(t *testing.T) {
-			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, http.NoBody)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tt.path, http.NoBody)
 			w := httptest.NewRecorder()
 			router.ServeHTTP(w, req)
 
 			require.Equal(t, http.StatusOK, w.Code)
 			require.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 
-			req = httptest.NewRequestWithContext(t.Context(), http.MethodOptions, path, http.NoBody)
+			req = httptest.NewRequestWithContext(t.Context(), http.MethodOptions, tt.path, http.NoBody)
 			w = httptest.NewRecorder()
 			router.ServeHTTP(w, req)
 
@@ -21,7 +21,7 @@ func NewJwtAuthMiddleware(jwtService *service.JwtService, userService *service.U
 
 func (m *JwtAuthMiddleware) Add(adminRequired bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID, isAdmin, authenticationMethod, authenticationTime, err := m.Verify(c, adminRequired)
+		userID, isAdmin, authenticationMethod, authenticationContextClass, authenticationTime, sessionID, err := m.Verify(c, adminRequired)
 		if err != nil {
 			c.Abort()
 			_ = c.Error(err)
@@ -31,12 +31,16 @@ func (m *JwtAuthMiddleware) Add(adminRequired bool) gin.HandlerFunc {
 		c.Set("userID", userID)
 		c.Set("userIsAdmin", isAdmin)
 		c.Set("authenticationMethod", authenticationMethod)
+		c.Set("authenticationContextClass", authenticationContextClass)
 		c.Set("authenticationTime", authenticationTime)
+		c.Set("sessionID", sessionID)
 		c.Next()
 	}
 }
 
-func (m *JwtAuthMiddleware) Verify(c *gin.Context, adminRequired bool) (subject string, isAdmin bool, authenticationMethod string, authenticationTime time.Time, err error) {
+// Verify validates the access token of the request
+// The returned session ID is the ID of the access token, which identifies the browser session it was issued for
+func (m *JwtAuthMiddleware) Verify(c *gin.Context, adminRequired bool) (subject string, isAdmin bool, authenticationMethod string, authenticationContextClass string, authenticationTime time.Time, sessionID string, err error) {
 	// Extract the token from the cookie
 	accessToken, err := c.Cookie(cookie.AccessTokenCookieName)
 	if err != nil {
@@ -44,38 +48,43 @@ func (m *JwtAuthMiddleware) Verify(c *gin.Context, adminRequired bool) (subject
 		var ok bool
 		_, accessToken, ok = strings.Cut(c.GetHeader("Authorization"), " ")
 		if !ok || accessToken == "" {
-			return "", false, "", time.Time{}, apperror.NotSignedIn()
+			return "", false, "", "", time.Time{}, "", apperror.NotSignedIn()
 		}
 	}
 
 	token, err := m.jwtService.VerifyAccessToken(accessToken)
 	if err != nil {
-		return "", false, "", time.Time{}, apperror.NotSignedIn()
+		return "", false, "", "", time.Time{}, "", apperror.NotSignedIn()
 	}
 	authenticationMethod, err = m.jwtService.GetAuthenticationMethod(token)
 	if err != nil {
-		return "", false, "", time.Time{}, apperror.NotSignedIn()
+		return "", false, "", "", time.Time{}, "", apperror.NotSignedIn()
+	}
+	authenticationContextClass, err = m.jwtService.GetAuthenticationContextClass(token)
+	if err != nil {
+		return "", false, "", "", time.Time{}, "", apperror.NotSignedIn()
 	}
 	authenticationTime, _ = token.IssuedAt()
+	sessionID, _ = token.JwtID()
 
 	subject, ok := token.Subject()
 	if !ok {
 		_ = c.Error(apperror.TokenInvalid())
-		return "", false, "", time.Time{}, apperror.TokenInvalid()
+		return "", false, "", "", time.Time{}, "", apperror.TokenInvalid()
 	}
 
 	user, err := m.userService.GetUser(c, subject)
 	if err != nil {
-		return "", false, "", time.Time{}, apperror.NotSignedIn()
+		return "", false, "", "", time.Time{}, "", apperror.NotSignedIn()
 	}
 
 	if user.Disabled {
-		return "", false, "", time.Time{}, apperror.UserDisabled()
+		return "", false, "", "", time.Time{}, "", apperror.UserDisabled()
 	}
 
 	if adminRequired && !user.IsAdmin {
-		return "", false, "", time.Time{}, apperror.MissingPermission()
+		return "", false, "", "", time.Time{}, "", apperror.MissingPermission()
 	}
 
-	return subject, user.IsAdmin, authenticationMethod, authenticationTime, nil
+	return subject, user.IsAdmin, authenticationMethod, authenticationContextClass, authenticationTime, sessionID, nil
 }
@@ -27,16 +27,21 @@ type AuditLogData map[string]string //nolint:recvcheck
 type AuditLogEvent string //nolint:recvcheck
 
 const (
-	AuditLogEventSignIn                     AuditLogEvent = "SIGN_IN"
-	AuditLogEventOneTimeAccessTokenSignIn   AuditLogEvent = "TOKEN_SIGN_IN"
-	AuditLogEventRemoteSignIn               AuditLogEvent = "REMOTE_SIGN_IN"
-	AuditLogEventAccountCreated             AuditLogEvent = "ACCOUNT_CREATED"
-	AuditLogEventClientAuthorization        AuditLogEvent = "CLIENT_AUTHORIZATION"
-	AuditLogEventNewClientAuthorization     AuditLogEvent = "NEW_CLIENT_AUTHORIZATION"
-	AuditLogEventDeviceCodeAuthorization    AuditLogEvent = "DEVICE_CODE_AUTHORIZATION"
-	AuditLogEventNewDeviceCodeAuthorization AuditLogEvent = "NEW_DEVICE_CODE_AUTHORIZATION"
-	AuditLogEventPasskeyAdded               AuditLogEvent = "PASSKEY_ADDED"
-	AuditLogEventPasskeyRemoved             AuditLogEvent = "PASSKEY_REMOVED"
+	AuditLogEventSignIn                      AuditLogEvent = "SIGN_IN"
+	AuditLogEventOneTimeAccessTokenSignIn    AuditLogEvent = "TOKEN_SIGN_IN"
+	AuditLogEventRemoteSignIn                AuditLogEvent = "REMOTE_SIGN_IN"
+	AuditLogEventAccountCreated              AuditLogEvent = "ACCOUNT_CREATED"
+	AuditLogEventClientAuthorization         AuditLogEvent = "CLIENT_AUTHORIZATION"
+	AuditLogEventNewClientAuthorization      AuditLogEvent = "NEW_CLIENT_AUTHORIZATION"
+	AuditLogEventDeviceCodeAuthorization     AuditLogEvent = "DEVICE_CODE_AUTHORIZATION"
+	AuditLogEventNewDeviceCodeAuthorization  AuditLogEvent = "NEW_DEVICE_CODE_AUTHORIZATION"
+	AuditLogEventBackchannelAuthorization    AuditLogEvent = "BACKCHANNEL_AUTHORIZATION"
+	AuditLogEventNewBackchannelAuthorization AuditLogEvent = "NEW_BACKCHANNEL_AUTHORIZATION"
+	AuditLogEventPasskeyAdded                AuditLogEvent = "PASSKEY_ADDED"
+	AuditLogEventPasskeyRemoved              AuditLogEvent = "PASSKEY_REMOVED"
+	AuditLogEventTokenRevoked                AuditLogEvent = "TOKEN_REVOKED"
+	AuditLogEventTokenExchanged              AuditLogEvent = "TOKEN_EXCHANGED"
+	AuditLogEventRefreshTokenReused          AuditLogEvent = "REFRESH_TOKEN_REUSED"
 )
 
 // Scan and Value methods for GORM to handle the custom type
@@ -3,7 +3,9 @@ package model
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"net/url"
 	"slices"
+	"strings"
 	"time"
 
 	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
@@ -11,8 +13,9 @@ import (
 )
 
 type UserAuthorizedOidcClient struct {
-	Scope      datatype.StringList
-	LastUsedAt datatype.DateTime `sortable:"true"`
+	// ScopeConsents are the user's decisions on the consent keys the client requested
+	ScopeConsents ScopeConsents
+	LastUsedAt    datatype.DateTime `sortable:"true"`
 
 	UserID string `gorm:"primary_key;"`
 	User   User
@@ -21,12 +24,72 @@ type UserAuthorizedOidcClient struct {
 	Client   OidcClient
 }
 
+// ScopeConsent is the user's decision on one consent key of an authorized client
+type ScopeConsent struct {
+	Scope string `json:"scope"`
+	// Granted is false if the user unticked the optional scope on the consent screen
+	Granted     bool              `json:"granted"`
+	ConsentedAt datatype.DateTime `json:"consentedAt"`
+}
+
+// ExpiresAt returns when the user has to be asked again, nil if the consent doesn't expire
+func (c ScopeConsent) ExpiresAt(lifetimeMinutes int64) *datatype.DateTime {
+	if lifetimeMinutes <= 0 {
+		return nil
+	}
+	expiresAt := datatype.DateTime(c.ConsentedAt.ToTime().Add(time.Duration(lifetimeMinutes) * time.Minute))
+	return &expiresAt
+}
+
+// IsExpiredAt reports whether the consent lifetime of the client has passed at the given time
+func (c ScopeConsent) IsExpiredAt(lifetimeMinutes int64, now time.Time) bool {
+	expiresAt := c.ExpiresAt(lifetimeMinutes)
+	return expiresAt != nil && !now.Before(expiresAt.ToTime())
+}
+
+type ScopeConsents []ScopeConsent //nolint:recvcheck
+
+func (c *ScopeConsents) Scan(value any) error {
+	return utils.UnmarshalJSONFromDatabase(c, value)
+}
+
+func (c ScopeConsents) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Find returns the user's decision on the consent key
+func (c ScopeConsents) Find(scope string) (ScopeConsent, bool) {
+	index := slices.IndexFunc(c, func(consent ScopeConsent) bool { return consent.Scope == scope })
+	if index < 0 {
+		return ScopeConsent{}, false
+	}
+	return c[index], true
+}
+
+// Granted returns the consent keys the user granted
+func (c ScopeConsents) Granted() []string {
+	granted := make([]string, 0, len(c))
+	for _, consent := range c {
+		if consent.Granted {
+			granted = append(granted, consent.Scope)
+		}
+	}
+	return granted
+}
+
+// IsDeclined reports whether the user declined the consent key
+func (c ScopeConsents) IsDeclined(scope string) bool {
+	consent, ok := c.Find(scope)
+	return ok && !consent.Granted
+}
+
 // OidcClientType identifies how an OIDC client was registered.
 type OidcClientType string
 
 const (
 	OidcClientTypeStandard OidcClientType = "standard"
 	OidcClientTypeCIMD     OidcClientType = "cimd"
+	OidcClientTypeDynamic  OidcClientType = "dynamic"
 
 	// DefaultAccessTokenDurationMinutes is the access-token lifetime used for new clients
 	DefaultAccessTokenDurationMinutes int64 = 60
@@ -41,6 +104,9 @@ const (
 	MaxOidcClientSecrets = 20
 	// OidcClientSecretPrefixLength is how many leading characters of a client secret are kept in clear text so admins can tell secrets apart
 	OidcClientSecretPrefixLength = 4
+
+	// DefaultEncryptedResponseEnc is the content encryption of encrypted responses when the client only sets the algorithm, as defined by OpenID Connect Dynamic Client Registration
+	DefaultEncryptedResponseEnc = "A128CBC-HS256"
 )
 
 type OidcClient struct {
@@ -56,6 +122,8 @@ type OidcClient struct {
 	PkceEnabled                         bool `sortable:"true" filterable:"true"`
 	RequiresReauthentication            bool `sortable:"true" filterable:"true"`
 	RequiresPushedAuthorizationRequests bool `sortable:"true" filterable:"true"`
+	RequiresDpop                        bool `sortable:"true" filterable:"true"`
+	RequiresSignedRequestObject         bool `sortable:"true" filterable:"true"`
 	SkipConsent                         bool `sortable:"true" filterable:"true"`
 	Credentials                         OidcClientCredentials
 	LaunchURL                           *string
@@ -66,6 +134,37 @@ type OidcClient struct {
 	MetadataGrantTypes                  datatype.StringList
 	AccessTokenDurationMinutes          int64 `gorm:"default:60"`
 	RefreshTokenDurationMinutes         int64 `gorm:"default:43200"`
+	BackchannelLogoutURI                *string
+	BackchannelLogoutSessionRequired    bool
+	FrontchannelLogoutURI               *string
+	FrontchannelLogoutSessionRequired   bool
+	AuthorizationSignedResponseAlg      string
+	UserinfoSignedResponseAlg           string
+	// BackchannelTokenDeliveryMode is how the client receives the tokens of a backchannel authentication request, empty if the client can't start one
+	BackchannelTokenDeliveryMode          OidcClientBackchannelTokenDeliveryMode
+	BackchannelClientNotificationEndpoint *string
+	// SubjectType is whether the client gets the user's ID as subject, or a pairwise subject of its sector
+	SubjectType         OidcClientSubjectType `gorm:"default:public"`
+	SectorIdentifierURI *string
+	// The encrypted response algorithms are empty if the client gets signed responses only
+	IDTokenEncryptedResponseAlg  string
+	IDTokenEncryptedResponseEnc  string
+	UserinfoEncryptedResponseAlg string
+	UserinfoEncryptedResponseEnc string
+	// MinimumACR is the weakest authentication context class users must have signed in with, empty if any sign in is enough
+	MinimumACR string
+	// RefreshTokenRequiresOfflineAccess limits refresh tokens to authorizations that were granted the offline_access scope
+	RefreshTokenRequiresOfflineAccess bool
+	// RefreshTokenMaxLifetimeMinutes is how long a refresh token family can be refreshed for in total, 0 if only the idle lifetime applies
+	RefreshTokenMaxLifetimeMinutes int64
+	// RefreshTokenReuseGraceSeconds is how long a rotated refresh token is still accepted, so concurrent refreshes of the same token don't count as reuse
+	RefreshTokenReuseGraceSeconds int64
+	// IDTokenSignedResponseAlg is the algorithm the client's ID tokens are signed with, empty for the algorithm of the default signing key
+	IDTokenSignedResponseAlg string
+	// ConsentLifetimeMinutes is how long the user's consent lasts before they are asked again, 0 if it never expires
+	ConsentLifetimeMinutes int64
+	// ClaimPolicy limits, renames and routes the user claims released to the client, and adds static claims
+	ClaimPolicy OidcClientClaimPolicy
 
 	AllowedUserGroups         []UserGroup `gorm:"many2many:oidc_clients_allowed_user_groups;"`
 	CreatedByID               *string
@@ -92,9 +191,47 @@ func (c OidcClient) IsMetadataDocument() bool {
 	return c.ClientType == OidcClientTypeCIMD
 }
 
+// IsDynamicallyRegistered reports whether the client registered itself with
+// OAuth 2.0 Dynamic Client Registration, using an initial access token.
+func (c OidcClient) IsDynamicallyRegistered() bool {
+	return c.ClientType == OidcClientTypeDynamic
+}
+
+// IsSelfRegistered reports whether the client registered itself, through a
+// metadata document or dynamic client registration. The URLs of its metadata
+// are then controlled by a third party rather than an admin.
+func (c OidcClient) IsSelfRegistered() bool {
+	return c.IsMetadataDocument() || c.IsDynamicallyRegistered()
+}
+
+// SectorIdentifier returns the host the pairwise subjects of the client are computed for, as defined by OpenID Connect Core section 8.1
+// Without a sector identifier URI it's the host of the callback URLs, which must then all be on the same host
+func (c OidcClient) SectorIdentifier() string {
+	raw := ""
+	if c.SectorIdentifierURI != nil && *c.SectorIdentifierURI != "" {
+		raw = *c.SectorIdentifierURI
+	} else if len(c.CallbackURLs) > 0 {
+		raw = c.CallbackURLs[0]
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		// A client without callback URLs, such as a device or backchannel client, is a sector of its own
+		return c.ID
+	}
+	return u.Hostname()
+}
+
 type OidcClientCredentials struct { //nolint:recvcheck
 	FederatedIdentities []OidcClientFederatedIdentity `json:"federatedIdentities,omitempty"`
 	Secrets             []OidcClientSecret            `json:"secrets,omitempty"`
+	TLSClientAuth       *OidcClientTLSClientAuth      `json:"tlsClientAuth,omitempty"`
+	// JWKS is the client's public JSON Web Key Set, used to verify the request objects it signs and to encrypt the responses it receives
+	JWKS string `json:"jwks,omitempty"`
+	// JWKSURI is the URL of the client's public JSON Web Key Set, used when JWKS is empty
+	JWKSURI string `json:"jwksUri,omitempty"`
+	// JWTBearerGrants are the external issuers whose JWTs the client may exchange for access tokens with the JWT bearer grant
+	JWTBearerGrants []OidcClientJWTBearerGrant `json:"jwtBearerGrants,omitempty"`
 }
 
 // OidcClientSecretHashAlgorithm identifies how the hash of a client secret was computed
@@ -178,6 +315,198 @@ func (occ OidcClientCredentials) FederatedIdentityForIssuer(issuer string) (Oidc
 	return OidcClientFederatedIdentity{}, false
 }
 
+// OidcClientJWTBearerGrant maps the JWTs of a trusted external issuer, such as a workload identity provider, to the subject of the access tokens issued with the JWT bearer grant (RFC 7523)
+type OidcClientJWTBearerGrant struct {
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience,omitempty"`
+	JWKS     string `json:"jwks,omitempty"` // URL of the JWKS
+	// Subject is the subject the JWT must have, a trailing "*" matches every subject starting with what precedes it
+	Subject string `json:"subject"`
+	// UserID is the user the issued tokens act for, if empty they are issued to the client itself
+	UserID           string `json:"userId,omitempty"`
+	ReplayProtection bool   `json:"replayProtection,omitempty"`
+}
+
+// MatchesSubject reports whether the subject of a JWT is matched by the grant
+func (g OidcClientJWTBearerGrant) MatchesSubject(subject string) bool {
+	if subject == "" {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(g.Subject, "*"); ok {
+		// A wildcard without a prefix would match every subject of the issuer
+		return prefix != "" && strings.HasPrefix(subject, prefix)
+	}
+	return subject == g.Subject
+}
+
+// JWTBearerGrantFor returns the grant that maps the JWT with the given issuer and subject
+// The most specific grant wins: an exact subject before the longest wildcard, so a single workload can be mapped differently than the rest of its namespace
+func (occ OidcClientCredentials) JWTBearerGrantFor(issuer, subject string) (OidcClientJWTBearerGrant, bool) {
+	var wildcard *OidcClientJWTBearerGrant
+	for i, grant := range occ.JWTBearerGrants {
+		if grant.Issuer != issuer || !grant.MatchesSubject(subject) {
+			continue
+		}
+		if grant.Subject == subject {
+			return grant, true
+		}
+		if wildcard == nil || len(grant.Subject) > len(wildcard.Subject) {
+			wildcard = &occ.JWTBearerGrants[i]
+		}
+	}
+
+	if wildcard == nil {
+		return OidcClientJWTBearerGrant{}, false
+	}
+	return *wildcard, true
+}
+
+// OidcClientBackchannelTokenDeliveryMode is one of the token delivery modes of OpenID Connect Client-Initiated Backchannel Authentication
+type OidcClientBackchannelTokenDeliveryMode string
+
+const (
+	// OidcClientBackchannelTokenDeliveryModePoll lets the client poll the token endpoint until the user decided
+	OidcClientBackchannelTokenDeliveryModePoll OidcClientBackchannelTokenDeliveryMode = "poll"
+	// OidcClientBackchannelTokenDeliveryModePing notifies the client at its notification endpoint once the user decided, after which it fetches the tokens
+	OidcClientBackchannelTokenDeliveryModePing OidcClientBackchannelTokenDeliveryMode = "ping"
+)
+
+// OidcClientSubjectType is one of the subject identifier types of OpenID Connect Core section 8
+type OidcClientSubjectType string
+
+const (
+	// OidcClientSubjectTypePublic gives every client the same subject, the ID of the user
+	OidcClientSubjectTypePublic OidcClientSubjectType = "public"
+	// OidcClientSubjectTypePairwise gives the clients of each sector a different subject, so clients of different sectors can't correlate the user
+	OidcClientSubjectTypePairwise OidcClientSubjectType = "pairwise"
+)
+
+// OidcClientTLSClientAuthMethod is one of the mutual-TLS client authentication methods defined by RFC 8705
+type OidcClientTLSClientAuthMethod string
+
+const (
+	// OidcClientTLSClientAuthPKI authenticates the client with a certificate issued by a trusted CA, matched on its subject DN or on a subject alternative name
+	OidcClientTLSClientAuthPKI OidcClientTLSClientAuthMethod = "tls_client_auth"
+	// OidcClientTLSClientAuthSelfSigned authenticates the client with one of the certificates pinned to it
+	OidcClientTLSClientAuthSelfSigned OidcClientTLSClientAuthMethod = "self_signed_tls_client_auth"
+)
+
+// OidcClientTLSClientAuth configures how a client authenticates with a TLS client certificate
+// For the PKI method, exactly one of the subject attributes is expected, like the tls_client_auth_* client metadata of RFC 8705 section 2.1.2
+type OidcClientTLSClientAuth struct {
+	Method OidcClientTLSClientAuthMethod `json:"method"`
+	// CACertificates is the PEM bundle of the CAs the client's certificate must chain up to, for the PKI method
+	CACertificates string `json:"caCertificates,omitempty"`
+	SubjectDN      string `json:"subjectDn,omitempty"`
+	SANDNS         string `json:"sanDns,omitempty"`
+	SANURI         string `json:"sanUri,omitempty"`
+	SANIP          string `json:"sanIp,omitempty"`
+	SANEmail       string `json:"sanEmail,omitempty"`
+	// Certificates is the PEM bundle of the certificates pinned to the client, for the self-signed method
+	Certificates string `json:"certificates,omitempty"`
+}
+
+// OidcClientClaimFilter is how the custom claim keys of a client's claim policy filter the custom claims it gets
+type OidcClientClaimFilter string
+
+const (
+	// OidcClientClaimFilterAllow only releases the custom claims with one of the keys
+	OidcClientClaimFilterAllow OidcClientClaimFilter = "allow"
+	// OidcClientClaimFilterDeny releases all custom claims except the ones with one of the keys
+	OidcClientClaimFilterDeny OidcClientClaimFilter = "deny"
+)
+
+// ClaimDestination is one of the places a claim released to a client can go
+type ClaimDestination int
+
+const (
+	ClaimDestinationIDToken ClaimDestination = iota
+	ClaimDestinationUserInfo
+	ClaimDestinationAccessToken
+)
+
+// ClaimDestinations are the places a claim released to a client goes
+type ClaimDestinations struct {
+	IDToken     bool `json:"idToken"`
+	UserInfo    bool `json:"userInfo"`
+	AccessToken bool `json:"accessToken"`
+}
+
+// Includes reports whether the claim goes to the destination
+func (d ClaimDestinations) Includes(destination ClaimDestination) bool {
+	switch destination {
+	case ClaimDestinationIDToken:
+		return d.IDToken
+	case ClaimDestinationUserInfo:
+		return d.UserInfo
+	case ClaimDestinationAccessToken:
+		return d.AccessToken
+	default:
+		return false
+	}
+}
+
+// DefaultClaimDestinations are where the user claims go unless the claim policy of the client maps them elsewhere
+var DefaultClaimDestinations = ClaimDestinations{IDToken: true, UserInfo: true}
+
+// OidcClientClaimPolicy limits and shapes the user claims released to a client
+// The scopes and the claims parameter still decide which claims are released, the policy only narrows and reshapes them
+type OidcClientClaimPolicy struct { //nolint:recvcheck
+	// CustomClaimsFilter is whether the custom claim keys are the only custom claims released or are withheld, empty to release all custom claims
+	CustomClaimsFilter OidcClientClaimFilter `json:"customClaimsFilter,omitempty"`
+	CustomClaimKeys    []string              `json:"customClaimKeys,omitempty"`
+	// Mappings rename user claims and choose where they go
+	Mappings []OidcClientClaimMapping `json:"mappings,omitempty"`
+	// StaticClaims are released to the client for every user
+	StaticClaims []OidcClientStaticClaim `json:"staticClaims,omitempty"`
+}
+
+// OidcClientClaimMapping renames a user claim for a client and chooses where it goes
+type OidcClientClaimMapping struct {
+	// Claim is the name of the user claim, like "email" or the key of a custom claim
+	Claim string `json:"claim"`
+	// Name is what the client gets the claim as, empty to keep its name
+	Name         string            `json:"name,omitempty"`
+	Destinations ClaimDestinations `json:"destinations"`
+}
+
+// OidcClientStaticClaim is a claim with the same value for every user of a client
+type OidcClientStaticClaim struct {
+	Key string `json:"key"`
+	// Value is a JSON document or a plain string, like the value of a custom claim
+	Value        string            `json:"value"`
+	Destinations ClaimDestinations `json:"destinations"`
+}
+
+// ReleasesCustomClaim reports whether the custom claim with the key may be released to the client
+func (p OidcClientClaimPolicy) ReleasesCustomClaim(key string) bool {
+	switch p.CustomClaimsFilter {
+	case OidcClientClaimFilterAllow:
+		return slices.Contains(p.CustomClaimKeys, key)
+	case OidcClientClaimFilterDeny:
+		return !slices.Contains(p.CustomClaimKeys, key)
+	default:
+		return true
+	}
+}
+
+// Mapping returns how the user claim is renamed and where it goes, which is unchanged to the default destinations without a mapping
+func (p OidcClientClaimPolicy) Mapping(claim string) OidcClientClaimMapping {
+	index := slices.IndexFunc(p.Mappings, func(mapping OidcClientClaimMapping) bool { return mapping.Claim == claim })
+	if index < 0 {
+		return OidcClientClaimMapping{Claim: claim, Destinations: DefaultClaimDestinations}
+	}
+	return p.Mappings[index]
+}
+
+func (p *OidcClientClaimPolicy) Scan(value any) error {
+	return utils.UnmarshalJSONFromDatabase(p, value)
+}
+
+func (p OidcClientClaimPolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
 func (occ *OidcClientCredentials) Scan(value any) error {
 	return utils.UnmarshalJSONFromDatabase(occ, value)
 }
@@ -3,6 +3,7 @@ package oidc
 import (
 	"context"
 	"slices"
+	"strings"
 
 	"github.com/ory/fosite"
 	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
@@ -65,3 +66,64 @@ type identityAudienceAccessTokenStrategy struct {
 func (s identityAudienceAccessTokenStrategy) GenerateAccessToken(ctx context.Context, requester fosite.Requester) (string, string, error) {
 	return s.CoreStrategy.GenerateAccessToken(ctx, withIdentityAudience(requester, s.issuer))
 }
+
+// selectResourceGrant limits the access token of a grant for several resources to the one the client names with the resource parameter, see RFC 8707 section 2.2
+// The refresh token keeps the whole grant, so a later refresh can request an access token for any of the other resources
+func selectResourceGrant(accessRequest fosite.AccessRequester, session *Session) error {
+	if len(session.ResourceGrants) == 0 {
+		return nil
+	}
+
+	resources := requestedResources(accessRequest.GetRequestForm())
+	if len(resources) != 1 {
+		return fosite.ErrInvalidTarget.WithHint("The grant covers several resources, so the 'resource' parameter must name the one the access token is requested for.")
+	}
+	resource := strings.TrimRight(resources[0], "/")
+	index := slices.IndexFunc(session.ResourceGrants, func(grant ResourceGrant) bool {
+		return grant.Audience == resource
+	})
+	if index < 0 {
+		return fosite.ErrInvalidTarget.WithHintf("The requested resource '%s' was not granted.", resources[0])
+	}
+	grant := session.ResourceGrants[index]
+
+	accessReq, ok := accessRequest.(*fosite.AccessRequest)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The access request can't be limited to a single resource.")
+	}
+	accessReq.GrantedScope = slices.DeleteFunc(slices.Clone(accessReq.GrantedScope), func(scope string) bool {
+		return !slices.Contains(grant.Scopes, scope)
+	})
+	accessReq.GrantedAudience = fosite.Arguments{grant.Audience}
+	return nil
+}
+
+// withResourceGrants returns a view of the requester that holds the whole grant of an authorization for several resources
+// It undoes selectResourceGrant for the refresh token, which is stored from the same requester as the access token
+func withResourceGrants(requester fosite.Requester) fosite.Requester {
+	session, ok := requester.GetSession().(*Session)
+	if !ok || len(session.ResourceGrants) == 0 {
+		return requester
+	}
+
+	audience := make(fosite.Arguments, 0, len(session.ResourceGrants))
+	for _, grant := range session.ResourceGrants {
+		audience = append(audience, grant.Audience)
+	}
+	return resourceGrantsRequester{Requester: requester, grantedScopes: resourceGrantScopes(session.ResourceGrants), grantedAudience: audience}
+}
+
+// resourceGrantsRequester overrides the granted scopes and audience of the wrapped requester
+type resourceGrantsRequester struct {
+	fosite.Requester
+	grantedScopes   fosite.Arguments
+	grantedAudience fosite.Arguments
+}
+
+func (r resourceGrantsRequester) GetGrantedScopes() fosite.Arguments {
+	return r.grantedScopes
+}
+
+func (r resourceGrantsRequester) GetGrantedAudience() fosite.Arguments {
+	return r.grantedAudience
+}
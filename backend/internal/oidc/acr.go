@@ -0,0 +1,72 @@
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// errInsufficientUserAuthentication is returned by protected resources when the authentication behind the access token is too weak, as described by RFC 9470 section 3
+var errInsufficientUserAuthentication = &fosite.RFC6749Error{
+	ErrorField:       "insufficient_user_authentication",
+	DescriptionField: "A stronger authentication is required.",
+	CodeField:        http.StatusUnauthorized,
+}
+
+// ACRValuesSupported returns the authentication context classes users can authenticate with, for the acr_values_supported server metadata
+func ACRValuesSupported() []string {
+	return common.AuthenticationContextClasses()
+}
+
+// requiredAuthenticationContextClass returns the weakest authentication context class the user must have authenticated with for the request
+// It's the client's minimum, or the class requested with the acr_values or claims parameters if that's stronger
+// Any of the requested classes is acceptable, so the weakest known one of them is the requirement
+func requiredAuthenticationContextClass(client model.OidcClient, acrValues string, claims string) (string, error) {
+	requested := strings.Fields(acrValues)
+	essential := false
+	claimsRequest, err := parseClaimsRequest(claims)
+	if err != nil {
+		return "", err
+	}
+	if acr := claimsRequest.idTokenClaims()[common.AuthenticationContextClassClaim]; acr != nil {
+		essential = acr.Essential
+		requested = append(requested, acr.acceptedStrings()...)
+	}
+
+	known := make([]string, 0, len(requested))
+	for _, acr := range requested {
+		if common.AuthenticationContextLevel(acr) > 0 {
+			known = append(known, acr)
+		}
+	}
+	if len(known) == 0 {
+		// Requested classes are only a preference, unless the client marked the claim as essential
+		if essential && len(requested) > 0 {
+			return "", fosite.ErrInvalidRequest.WithHint("None of the requested authentication context classes are supported.")
+		}
+		return client.MinimumACR, nil
+	}
+
+	weakest := slices.MinFunc(known, func(a, b string) int {
+		return common.AuthenticationContextLevel(a) - common.AuthenticationContextLevel(b)
+	})
+	if common.SatisfiesAuthenticationContext(client.MinimumACR, weakest) {
+		return client.MinimumACR, nil
+	}
+	return weakest, nil
+}
+
+// writeStepUpChallenge answers a request to a protected resource whose access token doesn't meet the required authentication context class
+// The challenge tells the client which class to request when it sends the user through the authorization endpoint again
+func writeStepUpChallenge(c *gin.Context, requiredContextClass string) {
+	rfcErr := errInsufficientUserAuthentication
+	c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", error_description="%s", acr_values="%s"`, rfcErr.ErrorField, rfcErr.GetDescription(), requiredContextClass))
+	c.JSON(rfcErr.StatusCode(), rfcErr)
+}
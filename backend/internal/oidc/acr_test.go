@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+func TestRequiredAuthenticationContextClass(t *testing.T) {
+	tests := []struct {
+		name          string
+		minimumACR    string
+		acrValues     string
+		claims        string
+		expected      string
+		expectedError bool
+	}{
+		{name: "nothing requested", expected: ""},
+		{name: "client minimum", minimumACR: common.ACRPasskey, expected: common.ACRPasskey},
+		{name: "acr_values", acrValues: common.ACRUserVerifiedPasskey, expected: common.ACRUserVerifiedPasskey},
+		{name: "weakest of several acr_values", acrValues: common.ACRDeviceBoundPasskey + " " + common.ACRUserVerifiedPasskey, expected: common.ACRUserVerifiedPasskey},
+		{name: "unknown acr_values are ignored", acrValues: "urn:example:acr:unknown", minimumACR: common.ACRPasskey, expected: common.ACRPasskey},
+		{name: "client minimum is stronger than the request", minimumACR: common.ACRDeviceBoundPasskey, acrValues: common.ACRPasskey, expected: common.ACRDeviceBoundPasskey},
+		{name: "request is stronger than the client minimum", minimumACR: common.ACROneTimeCode, acrValues: common.ACRUserVerifiedPasskey, expected: common.ACRUserVerifiedPasskey},
+		{name: "claims value", claims: `{"id_token":{"acr":{"value":"` + common.ACRDeviceBoundPasskey + `"}}}`, expected: common.ACRDeviceBoundPasskey},
+		{name: "claims values", claims: `{"id_token":{"acr":{"essential":true,"values":["` + common.ACRDeviceBoundPasskey + `","` + common.ACRPasskey + `"]}}}`, expected: common.ACRPasskey},
+		{name: "unknown voluntary claims value is ignored", claims: `{"id_token":{"acr":{"value":"urn:example:acr:unknown"}}}`, expected: ""},
+		{name: "unknown essential claims value", claims: `{"id_token":{"acr":{"essential":true,"value":"urn:example:acr:unknown"}}}`, expectedError: true},
+		{name: "invalid claims", claims: `{"id_token":`, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			required, err := requiredAuthenticationContextClass(model.OidcClient{MinimumACR: tt.minimumACR}, tt.acrValues, tt.claims)
+			if tt.expectedError {
+				require.ErrorIs(t, err, fosite.ErrInvalidRequest)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, required)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package oidc
 
 import (
 	"context"
+	"net/url"
 	"slices"
 	"strings"
 
@@ -18,6 +19,8 @@ const (
 	SubjectTypeUser SubjectType = "user"
 	// SubjectTypeClient covers client access: the client credentials grant, where the client acts as itself without a user
 	SubjectTypeClient SubjectType = "client"
+	// SubjectTypeTokenExchange covers the token exchange grant, where the client swaps a user's access token for a token of another API that still acts on behalf of that user
+	SubjectTypeTokenExchange SubjectType = "token_exchange"
 )
 
 var standardScopes = fosite.Arguments{"openid", "profile", "email", "groups", "offline_access"}
@@ -37,60 +40,131 @@ type APIAccessProvider interface {
 	// DescribePermissions returns the display information for the given permission keys of the API identified by audience
 	// Unknown keys are omitted
 	DescribePermissions(ctx context.Context, audience string, keys []string) ([]dto.ScopeInfoDto, error)
+	// AuthorizationDetailTypes returns the RFC 9396 authorization details types accepted by the API identified by audience
+	AuthorizationDetailTypes(ctx context.Context, tx *gorm.DB, audience string) ([]dto.AuthorizationDetailTypeDto, error)
+	// AccessTokenSigningAlg returns the algorithm the access tokens for the API identified by audience are signed with, empty for the default signing key
+	AccessTokenSigningAlg(ctx context.Context, tx *gorm.DB, audience string) (string, error)
+	// IntrospectionJWTRequired reports whether the introspection responses for the tokens of the API identified by audience must be signed JWTs, see RFC 9701
+	IntrospectionJWTRequired(ctx context.Context, tx *gorm.DB, audience string) (bool, error)
+	// ResourceServerAudiences returns the audiences of the APIs the client is registered as the resource server of, whose access tokens it may introspect
+	ResourceServerAudiences(ctx context.Context, tx *gorm.DB, clientID string) ([]string, error)
 }
 
 // resolveResource maps an RFC 8707 resource, which may be empty, to the audience to stamp on the issued token and the subset of requestedScopes that may be granted
 // An empty resource is a plain login token bound to the requesting client and yields only identity scopes
-// The subject type selects which of the client's grants apply: user-delegated flows only see user grants, the client credentials grant only sees client grants
+// The subject type selects which of the client's grants apply: user-delegated flows only see user grants, the client credentials grant only sees client grants, and the token exchange grant only sees token exchange grants
 func resolveResource(ctx context.Context, tx *gorm.DB, provider APIAccessProvider, clientID, resource string, requestedScopes []string, subjectType SubjectType) (audience string, grantedScopes []string, err error) {
-	grantable := make(map[string]struct{}, len(standardScopes))
-	for _, scope := range standardScopes {
-		grantable[scope] = struct{}{}
+	audience, allowedScopes, err := resolveResourceAudience(ctx, tx, provider, clientID, resource, subjectType)
+	if err != nil {
+		return "", nil, err
 	}
 
+	// A client credentials or token exchange request without explicit scopes gets everything the client is granted for the API
+	if resource != "" && subjectType != SubjectTypeUser && len(requestedScopes) == 0 {
+		requestedScopes = allowedScopes
+	}
+
+	granted := make(fosite.Arguments, 0, len(requestedScopes))
+	for _, scope := range requestedScopes {
+		if !isStandardScope(scope) && !slices.Contains(allowedScopes, scope) {
+			return "", nil, fosite.ErrInvalidScope.WithHintf("The scope '%s' is not available for the requested resource.", scope)
+		}
+		if !granted.Has(scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	return audience, granted, nil
+}
+
+// resolveResourceAudience maps an RFC 8707 resource, which may be empty, to the audience to stamp on the issued token and the custom-API permission keys the client is allowed for it
+func resolveResourceAudience(ctx context.Context, tx *gorm.DB, provider APIAccessProvider, clientID, resource string, subjectType SubjectType) (audience string, allowedScopes []string, err error) {
 	if resource == "" {
 		// A plain login token is audienced to the requesting client
-		audience = clientID
-	} else {
-		if !fosite.IsValidResourceIndicatorURI(resource) || provider == nil {
-			return "", nil, fosite.ErrInvalidTarget.WithHintf("The requested resource '%s' is invalid, missing, unknown, or malformed.", resource)
-		}
-		// Resolve every trailing-slash variant against the same canonical resource and stamp that value into the token audience
-		resource = strings.TrimRight(resource, "/")
+		return clientID, nil, nil
+	}
+
+	if !fosite.IsValidResourceIndicatorURI(resource) || provider == nil {
+		return "", nil, fosite.ErrInvalidTarget.WithHintf("The requested resource '%s' is invalid, missing, unknown, or malformed.", resource)
+	}
+	// Resolve every trailing-slash variant against the same canonical resource and stamp that value into the token audience
+	resource = strings.TrimRight(resource, "/")
 
-		allowedScopes, apiExists, hasAccess, err := provider.AllowedScopesForAudience(ctx, tx, clientID, resource, subjectType)
+	allowedScopes, apiExists, hasAccess, err := provider.AllowedScopesForAudience(ctx, tx, clientID, resource, subjectType)
+	if err != nil {
+		return "", nil, err
+	}
+	if !apiExists {
+		return "", nil, fosite.ErrInvalidTarget.WithHintf("The requested resource '%s' is invalid, missing, unknown, or malformed.", resource)
+	}
+	// Access is per API: a client allowed to reach one without any permission still gets a token, only without a scope
+	if !hasAccess {
+		return "", nil, fosite.ErrAccessDenied.WithHintf("The OAuth 2.0 Client is not allowed to access resource '%s'.", resource)
+	}
+
+	return resource, allowedScopes, nil
+}
+
+// ResourceGrant is the audience and scopes granted for one of the resources of an authorization that named several, see RFC 8707 section 2
+type ResourceGrant struct {
+	Audience string   `json:"audience"`
+	Scopes   []string `json:"scopes"`
+}
+
+// resolveResources maps the RFC 8707 resources of one user-delegated authorization to a grant per resource, see resolveResource
+// A requested scope is granted for every resource that allows it and must be allowed by at least one of them, while identity scopes are granted for all of them
+// Duplicate resources, including trailing-slash variants, resolve to a single grant
+func resolveResources(ctx context.Context, tx *gorm.DB, provider APIAccessProvider, clientID string, resources []string, requestedScopes []string) ([]ResourceGrant, error) {
+	grants := make([]ResourceGrant, 0, len(resources))
+	for _, resource := range resources {
+		audience, allowedScopes, err := resolveResourceAudience(ctx, tx, provider, clientID, resource, SubjectTypeUser)
 		if err != nil {
-			return "", nil, err
-		}
-		if !apiExists {
-			return "", nil, fosite.ErrInvalidTarget.WithHintf("The requested resource '%s' is invalid, missing, unknown, or malformed.", resource)
+			return nil, err
 		}
-		// Access is per API: a client allowed to reach one without any permission still gets a token, only without a scope
-		if !hasAccess {
-			return "", nil, fosite.ErrAccessDenied.WithHintf("The OAuth 2.0 Client is not allowed to access resource '%s'.", resource)
+		if slices.ContainsFunc(grants, func(grant ResourceGrant) bool { return grant.Audience == audience }) {
+			continue
 		}
 
-		audience = resource
-		for _, scope := range allowedScopes {
-			grantable[scope] = struct{}{}
-		}
-		// A client credentials request without explicit scopes gets everything the client is granted for the API
-		if subjectType == SubjectTypeClient && len(requestedScopes) == 0 {
-			requestedScopes = allowedScopes
+		grant := ResourceGrant{Audience: audience, Scopes: []string{}}
+		for _, scope := range requestedScopes {
+			if (isStandardScope(scope) || slices.Contains(allowedScopes, scope)) && !slices.Contains(grant.Scopes, scope) {
+				grant.Scopes = append(grant.Scopes, scope)
+			}
 		}
+		grants = append(grants, grant)
 	}
 
-	granted := make(fosite.Arguments, 0, len(requestedScopes))
 	for _, scope := range requestedScopes {
-		if _, ok := grantable[scope]; !ok {
-			return "", nil, fosite.ErrInvalidScope.WithHintf("The scope '%s' is not available for the requested resource.", scope)
+		granted := slices.ContainsFunc(grants, func(grant ResourceGrant) bool { return slices.Contains(grant.Scopes, scope) })
+		if !granted {
+			return nil, fosite.ErrInvalidScope.WithHintf("The scope '%s' is not available for any of the requested resources.", scope)
 		}
-		if !granted.Has(scope) {
-			granted = append(granted, scope)
+	}
+
+	return grants, nil
+}
+
+// resourceGrantScopes returns the scopes granted across all resource grants, without duplicates
+func resourceGrantScopes(grants []ResourceGrant) []string {
+	scopes := make([]string, 0)
+	for _, grant := range grants {
+		for _, scope := range grant.Scopes {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
 		}
 	}
+	return scopes
+}
 
-	return audience, granted, nil
+// requestedResources returns the RFC 8707 resources of an authorization request, which may repeat the resource parameter to name several APIs
+// Resource indicators are URIs and can't contain whitespace, so interaction sessions store them space-separated in one parameter, which is split here as well
+func requestedResources(form url.Values) []string {
+	var resources []string
+	for _, value := range form["resource"] {
+		resources = append(resources, strings.Fields(value)...)
+	}
+	return resources
 }
 
 // grantResourceIndicator applies a resolved resource audience and scopes to the request
@@ -103,6 +177,32 @@ func grantResourceIndicator(requester fosite.Requester, audience string, scopes
 	}
 }
 
+// AccessTokenSigningAlgValuesSupported returns the algorithms the access tokens of an API can be signed with
+func AccessTokenSigningAlgValuesSupported(signer TokenSigner) []string {
+	return signingAlgValuesSupported(signer)
+}
+
+// accessTokenSigningAlgorithm returns the algorithm the API an access token is audienced to wants it signed with, empty for the default signing key
+// The client itself may be among the audiences, but a token is only ever audienced to one API
+func accessTokenSigningAlgorithm(ctx context.Context, tx *gorm.DB, provider APIAccessProvider, clientID string, audiences []string) (string, error) {
+	if provider == nil {
+		return "", nil
+	}
+	for _, audience := range audiences {
+		if audience == clientID {
+			continue
+		}
+		alg, err := provider.AccessTokenSigningAlg(ctx, tx, audience)
+		if err != nil {
+			return "", err
+		}
+		if alg != "" {
+			return alg, nil
+		}
+	}
+	return "", nil
+}
+
 // consentScopeKey qualifies a custom-API scope by its audience so the same permission key on two different APIs is consented to separately
 // The unit-separator delimiter is collision-free because audiences are validated as URIs and permission keys are restricted to RFC 6749 scope-token characters, so neither can contain it
 // Standard identity scopes stay bare for backward compatibility with existing consents
@@ -135,3 +235,16 @@ func consentKeysForGrant(audience, resource string, grantedScopes []string) []st
 	}
 	return keys
 }
+
+// consentKeysForResourceGrants is the set of keys an authorization for several resources has to be consented to, see consentKeysForGrant
+func consentKeysForResourceGrants(grants []ResourceGrant) []string {
+	keys := make([]string, 0)
+	for _, grant := range grants {
+		for _, key := range consentKeysForGrant(grant.Audience, grant.Audience, grant.Scopes) {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
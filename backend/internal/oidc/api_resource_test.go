@@ -5,6 +5,7 @@ import (
 	"slices"
 	"testing"
 
+	"github.com/ory/fosite"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
@@ -14,8 +15,14 @@ import (
 
 // fakeAPIAccess implements APIAccessProvider from an audience -> subject type -> allowed-scopes map.
 // An audience present in the map exists as an API; a subject type present under it grants access, together with the scopes that subject may request, which can be none.
+// detailTypes lists the authorization details types each audience accepts.
+// resourceServers lists the audiences each client is registered as the resource server of.
 type fakeAPIAccess struct {
-	allowed map[string]map[SubjectType][]string
+	allowed          map[string]map[SubjectType][]string
+	detailTypes      map[string][]string
+	signingAlgs      map[string]string
+	jwtIntrospection map[string]bool
+	resourceServers  map[string][]string
 }
 
 // userAccess builds a fakeAPIAccess with only user-delegated grants, for tests that don't care about the split.
@@ -70,6 +77,26 @@ func (f fakeAPIAccess) DescribePermissions(_ context.Context, audience string, k
 	return infos, nil
 }
 
+func (f fakeAPIAccess) AuthorizationDetailTypes(_ context.Context, _ *gorm.DB, audience string) ([]dto.AuthorizationDetailTypeDto, error) {
+	var infos []dto.AuthorizationDetailTypeDto
+	for _, detailType := range f.detailTypes[audience] {
+		infos = append(infos, dto.AuthorizationDetailTypeDto{Type: detailType, Name: detailType})
+	}
+	return infos, nil
+}
+
+func (f fakeAPIAccess) AccessTokenSigningAlg(_ context.Context, _ *gorm.DB, audience string) (string, error) {
+	return f.signingAlgs[audience], nil
+}
+
+func (f fakeAPIAccess) IntrospectionJWTRequired(_ context.Context, _ *gorm.DB, audience string) (bool, error) {
+	return f.jwtIntrospection[audience], nil
+}
+
+func (f fakeAPIAccess) ResourceServerAudiences(_ context.Context, _ *gorm.DB, clientID string) ([]string, error) {
+	return f.resourceServers[clientID], nil
+}
+
 func TestResolveResourceDefaultIsLoginToken(t *testing.T) {
 	// With no resource the token is a plain login token audienced to the requesting client
 	audience, granted, err := resolveResource(t.Context(), nil, nil, "client-1", "", []string{"openid", "profile"}, SubjectTypeUser)
@@ -208,3 +235,50 @@ func TestConsentScopeKeyQualifiesCustomScopesOnly(t *testing.T) {
 		consentScopeKey("https://api.billing.example.com", "read"),
 	)
 }
+
+func TestResolveResourcesGrantsEachScopeForItsAPIs(t *testing.T) {
+	provider := userAccess(map[string][]string{
+		"https://api.orders.example.com":  {"read", "write:orders"},
+		"https://api.billing.example.com": {"read"},
+	})
+
+	grants, err := resolveResources(t.Context(), nil, provider, "client-1",
+		[]string{"https://api.orders.example.com", "https://api.billing.example.com/", "https://api.orders.example.com/"},
+		[]string{"openid", "read", "write:orders"},
+	)
+	require.NoError(t, err)
+	// Identity scopes go with every resource, a custom scope with each API that allows it, and the trailing-slash duplicate collapses
+	assert.Equal(t, []ResourceGrant{
+		{Audience: "https://api.orders.example.com", Scopes: []string{"openid", "read", "write:orders"}},
+		{Audience: "https://api.billing.example.com", Scopes: []string{"openid", "read"}},
+	}, grants)
+	assert.Equal(t, []string{"openid", "read", "write:orders"}, resourceGrantScopes(grants))
+	assert.ElementsMatch(t, []string{
+		"openid",
+		"https://api.orders.example.com\x1fread",
+		"https://api.orders.example.com\x1fwrite:orders",
+		"https://api.orders.example.com\x1f",
+		"https://api.billing.example.com\x1fread",
+		"https://api.billing.example.com\x1f",
+	}, consentKeysForResourceGrants(grants))
+}
+
+func TestResolveResourcesRejectsScopeOfNoRequestedAPI(t *testing.T) {
+	provider := userAccess(map[string][]string{
+		"https://api.orders.example.com":  {"read:orders"},
+		"https://api.billing.example.com": {"read:billing"},
+	})
+
+	_, err := resolveResources(t.Context(), nil, provider, "client-1",
+		[]string{"https://api.orders.example.com", "https://api.billing.example.com"},
+		[]string{"read:orders", "write:billing"},
+	)
+	require.ErrorIs(t, err, fosite.ErrInvalidScope)
+
+	// Every resource has to resolve, not just one of them
+	_, err = resolveResources(t.Context(), nil, provider, "client-1",
+		[]string{"https://api.orders.example.com", "https://api.unknown.example.com"},
+		[]string{"read:orders"},
+	)
+	require.ErrorIs(t, err, fosite.ErrInvalidTarget)
+}
@@ -0,0 +1,181 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+)
+
+// errInvalidAuthorizationDetails is returned for authorization details that are malformed or not accepted by the requested API, as described by RFC 9396 section 5
+var errInvalidAuthorizationDetails = &fosite.RFC6749Error{
+	ErrorField:       "invalid_authorization_details",
+	DescriptionField: "The authorization details are invalid, unknown, or malformed.",
+	CodeField:        http.StatusBadRequest,
+}
+
+// authorizationDetailStringArrayFields are the common fields of RFC 9396 section 2.2 that hold an array of strings
+var authorizationDetailStringArrayFields = []string{"locations", "actions", "datatypes", "privileges"}
+
+// AuthorizationDetail is one object of the authorization_details parameter, see RFC 9396 section 2
+// It's kept as the client sent it, because the fields besides the type are defined by the API
+type AuthorizationDetail map[string]any
+
+// Type returns the type of the authorization detail, which selects the API defined fields it may have
+func (d AuthorizationDetail) Type() string {
+	detailType, _ := d["type"].(string)
+	return detailType
+}
+
+// locations returns the resource servers the authorization detail applies to, which is empty if it applies to the requested resource
+func (d AuthorizationDetail) locations() []string {
+	locations, _ := d["locations"].([]any)
+	values := make([]string, 0, len(locations))
+	for _, location := range locations {
+		if location, ok := location.(string); ok {
+			values = append(values, location)
+		}
+	}
+	return values
+}
+
+// parseAuthorizationDetails parses the authorization_details parameter, which is empty if the client didn't send one
+// Only the structure of the common fields is checked here, the types are checked against the requested API by resolveAuthorizationDetails
+func parseAuthorizationDetails(authorizationDetails string) ([]AuthorizationDetail, error) {
+	if authorizationDetails == "" {
+		return nil, nil
+	}
+
+	var details []AuthorizationDetail
+	err := json.Unmarshal([]byte(authorizationDetails), &details)
+	if err != nil {
+		return nil, errInvalidAuthorizationDetails.WithHint("The 'authorization_details' parameter is not a JSON array of objects.").WithWrap(err)
+	}
+
+	for i, detail := range details {
+		if detail.Type() == "" {
+			return nil, errInvalidAuthorizationDetails.WithHintf("The authorization detail at index %d has no type.", i)
+		}
+		for _, field := range authorizationDetailStringArrayFields {
+			value, ok := detail[field]
+			if ok && !isStringArray(value) {
+				return nil, errInvalidAuthorizationDetails.WithHintf("The '%s' field of the authorization detail at index %d must be an array of strings.", field, i)
+			}
+		}
+		if identifier, ok := detail["identifier"]; ok {
+			if _, ok := identifier.(string); !ok {
+				return nil, errInvalidAuthorizationDetails.WithHintf("The 'identifier' field of the authorization detail at index %d must be a string.", i)
+			}
+		}
+	}
+
+	return details, nil
+}
+
+func isStringArray(value any) bool {
+	values, ok := value.([]any)
+	if !ok {
+		return false
+	}
+	for _, value := range values {
+		if _, ok := value.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// authorizationDetailsResource returns the resource the authorization details of a request are validated against
+// A token is only ever audienced to one API, so authorization details can't be combined with several resources
+func authorizationDetailsResource(resources []string, authorizationDetails string) (string, error) {
+	if len(resources) > 1 && authorizationDetails != "" {
+		return "", errInvalidAuthorizationDetails.WithHint("Authorization details can't be requested together with several resources.")
+	}
+	if len(resources) == 0 {
+		return "", nil
+	}
+	return resources[0], nil
+}
+
+// requestedAuthorizationDetails parses the authorization_details parameter and validates it against the API identified by resource
+func requestedAuthorizationDetails(ctx context.Context, tx *gorm.DB, provider APIAccessProvider, clientID, resource string, subjectType SubjectType, authorizationDetails string) ([]AuthorizationDetail, error) {
+	details, err := parseAuthorizationDetails(authorizationDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resolveAuthorizationDetails(ctx, tx, provider, clientID, resource, subjectType, details)
+	if err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// resolveAuthorizationDetails checks that every authorization detail has a type registered for the API identified by resource, and that the client may access that API
+// A token is only ever audienced to the requested resource, so the locations of a detail may only name that resource
+func resolveAuthorizationDetails(ctx context.Context, tx *gorm.DB, provider APIAccessProvider, clientID, resource string, subjectType SubjectType, details []AuthorizationDetail) error {
+	if len(details) == 0 {
+		return nil
+	}
+	if resource == "" || provider == nil {
+		return errInvalidAuthorizationDetails.WithHint("Authorization details require the 'resource' parameter to name the API they apply to.")
+	}
+	resource = strings.TrimRight(resource, "/")
+
+	_, apiExists, hasAccess, err := provider.AllowedScopesForAudience(ctx, tx, clientID, resource, subjectType)
+	if err != nil {
+		return err
+	}
+	if !apiExists || !hasAccess {
+		return errInvalidAuthorizationDetails.WithHint("Authorization details require the 'resource' parameter to name an API the client may access.")
+	}
+
+	detailTypes, err := provider.AuthorizationDetailTypes(ctx, tx, resource)
+	if err != nil {
+		return err
+	}
+
+	for _, detail := range details {
+		supported := slices.ContainsFunc(detailTypes, func(detailType dto.AuthorizationDetailTypeDto) bool {
+			return detailType.Type == detail.Type()
+		})
+		if !supported {
+			return errInvalidAuthorizationDetails.WithHintf("The authorization details type '%s' is not supported by the requested resource.", detail.Type())
+		}
+		for _, location := range detail.locations() {
+			if strings.TrimRight(location, "/") != resource {
+				return errInvalidAuthorizationDetails.WithHintf("The location '%s' of an authorization detail is not the requested resource.", location)
+			}
+		}
+	}
+
+	return nil
+}
+
+// narrowAuthorizationDetails returns the authorization details requested at the token endpoint, which must be a subset of the granted ones, see RFC 9396 section 6.1
+// Without the parameter the token carries every granted authorization detail, which is reported as nil
+func narrowAuthorizationDetails(granted []AuthorizationDetail, authorizationDetails string) ([]AuthorizationDetail, error) {
+	requested, err := parseAuthorizationDetails(authorizationDetails)
+	if err != nil {
+		return nil, err
+	}
+	if requested == nil {
+		return nil, nil
+	}
+
+	for _, detail := range requested {
+		wasGranted := slices.ContainsFunc(granted, func(grantedDetail AuthorizationDetail) bool {
+			return claimValuesEqual(grantedDetail, detail)
+		})
+		if !wasGranted {
+			return nil, errInvalidAuthorizationDetails.WithHint("The requested authorization details exceed the ones that were granted.")
+		}
+	}
+	return requested, nil
+}
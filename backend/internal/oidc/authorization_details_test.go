@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAuthorizationDetails(t *testing.T) {
+	tests := []struct {
+		name                 string
+		authorizationDetails string
+		expected             []AuthorizationDetail
+		wantErr              bool
+	}{
+		{name: "empty", authorizationDetails: "", expected: nil},
+		{
+			name:                 "type specific fields",
+			authorizationDetails: `[{"type":"payment_initiation","actions":["initiate"],"instructedAmount":{"currency":"EUR","amount":"100.00"}}]`,
+			expected: []AuthorizationDetail{{
+				"type":             "payment_initiation",
+				"actions":          []any{"initiate"},
+				"instructedAmount": map[string]any{"currency": "EUR", "amount": "100.00"},
+			}},
+		},
+		{name: "not an array", authorizationDetails: `{"type":"payment_initiation"}`, wantErr: true},
+		{name: "missing type", authorizationDetails: `[{"actions":["read"]}]`, wantErr: true},
+		{name: "null detail", authorizationDetails: `[null]`, wantErr: true},
+		{name: "locations not strings", authorizationDetails: `[{"type":"folder","locations":[1]}]`, wantErr: true},
+		{name: "identifier not a string", authorizationDetails: `[{"type":"folder","identifier":{"id":1}}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := parseAuthorizationDetails(tt.authorizationDetails)
+			if tt.wantErr {
+				require.ErrorIs(t, err, errInvalidAuthorizationDetails)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, details)
+		})
+	}
+}
+
+func TestResolveAuthorizationDetails(t *testing.T) {
+	const (
+		documents = "https://documents.example.com"
+		payments  = "https://payments.example.com"
+	)
+	apiAccess := fakeAPIAccess{
+		allowed: map[string]map[SubjectType][]string{
+			documents: {SubjectTypeUser: {"read"}},
+			payments:  {SubjectTypeClient: {}},
+		},
+		detailTypes: map[string][]string{
+			documents: {"folder"},
+			payments:  {"payment_initiation"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		resource string
+		details  []AuthorizationDetail
+		wantErr  bool
+	}{
+		{name: "no details without resource", resource: "", details: nil},
+		{name: "registered type", resource: documents, details: []AuthorizationDetail{{"type": "folder", "identifier": "reports"}}},
+		{name: "trailing slash resource", resource: documents + "/", details: []AuthorizationDetail{{"type": "folder"}}},
+		{name: "location of the resource", resource: documents, details: []AuthorizationDetail{{"type": "folder", "locations": []any{documents + "/"}}}},
+		{name: "missing resource", resource: "", details: []AuthorizationDetail{{"type": "folder"}}, wantErr: true},
+		{name: "unregistered type", resource: documents, details: []AuthorizationDetail{{"type": "payment_initiation"}}, wantErr: true},
+		{name: "location of another API", resource: documents, details: []AuthorizationDetail{{"type": "folder", "locations": []any{payments}}}, wantErr: true},
+		{name: "API without user access", resource: payments, details: []AuthorizationDetail{{"type": "payment_initiation"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := resolveAuthorizationDetails(t.Context(), nil, apiAccess, "client-1", tt.resource, SubjectTypeUser, tt.details)
+			if tt.wantErr {
+				require.ErrorIs(t, err, errInvalidAuthorizationDetails)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNarrowAuthorizationDetails(t *testing.T) {
+	granted := []AuthorizationDetail{
+		{"type": "folder", "identifier": "reports", "actions": []any{"read"}},
+		{"type": "folder", "identifier": "invoices", "actions": []any{"read"}},
+	}
+
+	details, err := narrowAuthorizationDetails(granted, "")
+	require.NoError(t, err)
+	require.Nil(t, details)
+
+	details, err = narrowAuthorizationDetails(granted, `[{"actions":["read"],"identifier":"invoices","type":"folder"}]`)
+	require.NoError(t, err)
+	require.Equal(t, granted[1:], details)
+
+	_, err = narrowAuthorizationDetails(granted, `[{"type":"folder","identifier":"invoices","actions":["read","write"]}]`)
+	require.ErrorIs(t, err, errInvalidAuthorizationDetails)
+}
@@ -21,15 +21,18 @@ const parRequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
 type authorizationHandler struct {
 	provider             fosite.OAuth2Provider
 	authorizationService *authorizationService
+	requestObjects       *requestObjectVerifier
 }
 
 func newAuthorizationHandler(
 	provider fosite.OAuth2Provider,
 	authorizationService *authorizationService,
+	requestObjects *requestObjectVerifier,
 ) *authorizationHandler {
 	return &authorizationHandler{
 		provider:             provider,
 		authorizationService: authorizationService,
+		requestObjects:       requestObjects,
 	}
 }
 
@@ -37,6 +40,7 @@ func (h *authorizationHandler) authorize(c *gin.Context) {
 	ctx := c.Request.Context()
 	userID := c.GetString("userID")
 	authenticationMethod := c.GetString("authenticationMethod")
+	authenticationContextClass := c.GetString("authenticationContextClass")
 	authenticationTime, _ := c.Get("authenticationTime")
 	typedAuthenticationTime, _ := authenticationTime.(time.Time)
 	reauthenticationToken, _ := c.Cookie(cookie.ReauthenticationTokenCookieName)
@@ -60,6 +64,16 @@ func (h *authorizationHandler) authorize(c *gin.Context) {
 	// sending an arbitrary (non-prefixed) request_uri, which fosite silently ignores.
 	hasPushedAuthorizationRequest := strings.HasPrefix(c.Query("request_uri"), parRequestURIPrefix)
 
+	// Parameters restored from an interaction or a pushed authorization request were already verified when Pocket ID first received them
+	if interactionID == "" && !hasPushedAuthorizationRequest {
+		err := h.requestObjects.resolve(ctx, c.Request)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to verify request object", "error", err.Error())
+			h.writeAuthorizeError(ctx, c, fosite.NewAuthorizeRequest(), err)
+			return
+		}
+	}
+
 	ar, err := h.provider.NewAuthorizeRequest(ctx, c.Request)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to create authorize request", "error", err.Error())
@@ -70,7 +84,9 @@ func (h *authorizationHandler) authorize(c *gin.Context) {
 	authorization, err := h.authorizationService.authorize(ctx, authorizeInput{
 		userID:                        userID,
 		authenticationMethod:          authenticationMethod,
+		authenticationContextClass:    authenticationContextClass,
 		authenticationTime:            typedAuthenticationTime,
+		sessionID:                     c.GetString("sessionID"),
 		requester:                     ar,
 		hasPushedAuthorizationRequest: hasPushedAuthorizationRequest,
 		reauthenticationToken:         reauthenticationToken,
@@ -126,7 +142,7 @@ func (h *authorizationHandler) completeInteraction(c *gin.Context) {
 	}
 
 	reauthenticationToken, _ := c.Cookie(cookie.ReauthenticationTokenCookieName)
-	response, err := h.authorizationService.completeInteractionStep(c.Request.Context(), interactionID, c.GetString("userID"), request.Step, reauthenticationToken, typedAuthenticationTime, requestMetaFromGin(c))
+	response, err := h.authorizationService.completeInteractionStep(c.Request.Context(), interactionID, c.GetString("userID"), request.Step, request.DeclinedScopes, reauthenticationToken, typedAuthenticationTime, requestMetaFromGin(c))
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -200,13 +216,17 @@ func authorizeRequestParams(requester fosite.AuthorizeRequester) map[string]stri
 		}
 		params[key] = values[0]
 	}
+	// An authorization may name several resources, which the interaction session stores space-separated
+	if resources := requestedResources(requester.GetRequestForm()); len(resources) > 0 {
+		params["resource"] = strings.Join(resources, " ")
+	}
 
 	return params
 }
 
-// relaxCSPForFormPost loosens the per-request Content-Security-Policy when the response is delivered via response_mode=form_post
+// relaxCSPForFormPost loosens the per-request Content-Security-Policy when the response is delivered via response_mode=form_post or form_post.jwt
 func (h *authorizationHandler) relaxCSPForFormPost(c *gin.Context, ar fosite.AuthorizeRequester) {
-	if ar.GetResponseMode() != fosite.ResponseModeFormPost || ar.GetRedirectURI() == nil {
+	if !isFormPostResponseMode(ar.GetResponseMode()) || ar.GetRedirectURI() == nil {
 		return
 	}
 	c.Header("Content-Security-Policy", utils.BuildFormPostCSP(utils.GetCSPNonce(c), ar.GetRedirectURI().String(), formPostScriptCSPHash))
@@ -13,6 +13,7 @@ import (
 
 	"github.com/ory/fosite"
 	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
@@ -42,13 +43,38 @@ type authorizationService struct {
 
 // resolveGrant resolves the RFC 8707 resource of a request into the token audience, the scopes that may actually be granted, and the audience-qualified keys used to record and check consent
 // It always resolves against the client's user-delegated grants because every flow that passes through here acts on behalf of a user
-func (s *authorizationService) resolveGrant(ctx context.Context, clientID, resource string, requestedScopes []string) (audience string, grantedScopes []string, consentKeys []string, err error) {
+func (s *authorizationService) resolveGrant(ctx context.Context, clientID, resource string, requestedScopes []string, claimsRequest *ClaimsRequest) (audience string, grantedScopes []string, consentKeys []string, err error) {
 	audience, grantedScopes, err = resolveResource(ctx, dbFromContext(ctx, s.db), s.apiAccess, clientID, resource, requestedScopes, SubjectTypeUser)
 	if err != nil {
 		return "", nil, nil, err
 	}
 
-	return audience, grantedScopes, consentKeysForGrant(audience, resource, grantedScopes), nil
+	// Claims requested individually are consented to on their own, unless a granted scope releases them already
+	consentKeys = append(consentKeysForGrant(audience, resource, grantedScopes), claimConsentKeys(claimsRequest, grantedScopes)...)
+	return audience, grantedScopes, consentKeys, nil
+}
+
+// resolveResourceGrants resolves the RFC 8707 resources of a browser authorization, which may name several APIs at once, into a grant per resource and the consent keys covering all of them
+func (s *authorizationService) resolveResourceGrants(ctx context.Context, clientID string, resources []string, requestedScopes []string, claimsRequest *ClaimsRequest) (grants []ResourceGrant, consentKeys []string, err error) {
+	if len(resources) <= 1 {
+		resource := ""
+		if len(resources) == 1 {
+			resource = resources[0]
+		}
+		audience, grantedScopes, keys, err := s.resolveGrant(ctx, clientID, resource, requestedScopes, claimsRequest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []ResourceGrant{{Audience: audience, Scopes: grantedScopes}}, keys, nil
+	}
+
+	grants, err = resolveResources(ctx, dbFromContext(ctx, s.db), s.apiAccess, clientID, resources, requestedScopes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	consentKeys = append(consentKeysForResourceGrants(grants), claimConsentKeys(claimsRequest, resourceGrantScopes(grants))...)
+	return grants, consentKeys, nil
 }
 
 type requestMeta struct {
@@ -85,7 +111,9 @@ func consentRequired(hasAlreadyAuthorizedClient, clientSkipsConsent bool, prompt
 type authorizeInput struct {
 	userID                        string
 	authenticationMethod          string
+	authenticationContextClass    string
 	authenticationTime            time.Time
+	sessionID                     string
 	requester                     fosite.AuthorizeRequester
 	hasPushedAuthorizationRequest bool
 	reauthenticationToken         string
@@ -98,10 +126,12 @@ type authorizeInput struct {
 type authorizeRequest struct {
 	authorizeInput
 
-	client             Client
-	prompt             promptValues
-	interactionSession *InteractionSession
-	now                time.Time
+	client               Client
+	prompt               promptValues
+	claimsRequest        *ClaimsRequest
+	authorizationDetails []AuthorizationDetail
+	interactionSession   *InteractionSession
+	now                  time.Time
 }
 
 func (s *authorizationService) authorize(ctx context.Context, input authorizeInput) (authorizationResult, error) {
@@ -113,6 +143,16 @@ func (s *authorizationService) authorize(ctx context.Context, input authorizeInp
 		return authorizationResult{}, err
 	}
 
+	// The requested claims and authentication context are validated before the user authenticates
+	claimsRequest, err := parseClaimsRequest(input.requester.GetRequestForm().Get("claims"))
+	if err != nil {
+		return authorizationResult{}, err
+	}
+	_, err = requiredAuthenticationContextClass(client.OidcClient, input.requester.GetRequestForm().Get("acr_values"), input.requester.GetRequestForm().Get("claims"))
+	if err != nil {
+		return authorizationResult{}, err
+	}
+
 	interactionSession, err := s.boundInteractionSession(ctx, input.interactionID, input.userID, client, input.requester)
 	if err != nil {
 		return authorizationResult{}, err
@@ -123,20 +163,17 @@ func (s *authorizationService) authorize(ctx context.Context, input authorizeInp
 		return authorizationResult{}, apperror.OidcPARRequired()
 	}
 
-	resource, err := input.requester.GetResource()
-	if err != nil {
-		return authorizationResult{}, err
-	}
+	resources := requestedResources(input.requester.GetRequestForm())
 
-	// Validate the requested scopes against the targeted API up front, before the user authenticates or reaches the consent screen
+	// Validate the requested scopes against the targeted APIs up front, before the user authenticates or reaches the consent screen
 	// This rejects a custom permission requested without, or with the wrong, resource at the authorize endpoint itself
-	_, _, _, err = s.resolveGrant(ctx, client.GetID(), resource, input.requester.GetRequestedScopes())
+	_, _, err = s.resolveResourceGrants(ctx, client.GetID(), resources, input.requester.GetRequestedScopes(), claimsRequest)
 	if err != nil {
 		// resolveGrant distinguishes an unknown API, an API this client is not granted, and a scope not allowed for the API
 		// This validation runs before authentication, so returning those distinct errors would let anyone holding a public client_id diff the responses to enumerate which API audiences exist and which ones the client may request
 		// Collapse every resource-targeted failure into one generic invalid_request so the pre-auth response reveals no backend state, while keeping the underlying reason in the server log for operators
 		// A request that names no resource cannot leak API topology, so its scope error is returned unchanged to help legitimate integrations
-		if resource != "" {
+		if len(resources) > 0 {
 			slog.DebugContext(ctx, "Rejected authorize request with an invalid or unauthorized resource or scope", "client_id", client.GetID(), "error", err.Error())
 			return authorizationResult{}, fosite.ErrInvalidRequest.WithHint("The 'resource' or 'scope' parameter is invalid.")
 		}
@@ -144,6 +181,15 @@ func (s *authorizationService) authorize(ctx context.Context, input authorizeInp
 		return authorizationResult{}, err
 	}
 
+	detailsResource, err := authorizationDetailsResource(resources, input.requester.GetRequestForm().Get("authorization_details"))
+	if err != nil {
+		return authorizationResult{}, err
+	}
+	authorizationDetails, err := requestedAuthorizationDetails(ctx, dbFromContext(ctx, s.db), s.apiAccess, client.GetID(), detailsResource, SubjectTypeUser, input.requester.GetRequestForm().Get("authorization_details"))
+	if err != nil {
+		return authorizationResult{}, err
+	}
+
 	if input.userID == "" {
 		if prompt.has("none") {
 			return authorizationResult{}, fosite.ErrLoginRequired
@@ -163,11 +209,13 @@ func (s *authorizationService) authorize(ctx context.Context, input authorizeInp
 	}
 
 	req := authorizeRequest{
-		authorizeInput:     input,
-		client:             client,
-		prompt:             prompt,
-		interactionSession: interactionSession,
-		now:                time.Now().UTC(),
+		authorizeInput:       input,
+		client:               client,
+		prompt:               prompt,
+		claimsRequest:        claimsRequest,
+		authorizationDetails: authorizationDetails,
+		interactionSession:   interactionSession,
+		now:                  time.Now().UTC(),
 	}
 
 	codeChallenge := input.requester.GetRequestForm().Get("code_challenge")
@@ -195,7 +243,7 @@ func (s *authorizationService) authorize(ctx context.Context, input authorizeInp
 		return result, nil
 	}
 
-	err = s.claimsService.applyIDTokenClaims(ctx, result.Session, input.requester.GetGrantedScopes())
+	err = s.claimsService.applyIDTokenClaims(ctx, result.Session, client.OidcClient, input.requester.GetGrantedScopes())
 	if err != nil {
 		return authorizationResult{}, err
 	}
@@ -231,7 +279,7 @@ func (s *authorizationService) authorizeAuthenticated(ctx context.Context, req a
 		}
 	}
 
-	requirements, authenticationTime, err := s.resolveRequirements(ctx, req, interactionSession)
+	requirements, authentication, err := s.resolveRequirements(ctx, req, interactionSession)
 	if err != nil {
 		return authorizationResult{}, err
 	}
@@ -259,23 +307,27 @@ func (s *authorizationService) authorizeAuthenticated(ctx context.Context, req a
 		}
 	}
 
-	resource, err := req.requester.GetResource()
-	if err != nil {
-		return authorizationResult{}, err
-	}
-	audience, grantedScopes, consentKeys, err := s.resolveGrant(ctx, req.client.GetID(), resource, req.requester.GetRequestedScopes())
+	grants, consentKeys, err := s.resolveResourceGrants(ctx, req.client.GetID(), requestedResources(req.requester.GetRequestForm()), req.requester.GetRequestedScopes(), req.claimsRequest)
 	if err != nil {
 		return authorizationResult{}, err
 	}
 
-	hasAlreadyAuthorizedClient, err := s.consent(ctx, req.userID, req.client.GetID(), consentKeys)
+	consents, hasAlreadyAuthorizedClient, err := s.saveConsent(ctx, req.userID, req.client.GetID(), consentKeys, nil, true)
 	if err != nil {
 		return authorizationResult{}, err
 	}
+	grants = withoutDeclinedScopes(grants, consents)
 
-	session := s.buildAuthorizedSession(req, interactionSession, authenticationTime)
+	session := s.buildAuthorizedSession(req, interactionSession, authentication)
+	session.ClaimsRequest = withoutDeclinedClaims(req.claimsRequest, req.requester.GetRequestedScopes(), grants)
 
-	grantResourceIndicator(req.requester, audience, grantedScopes)
+	for _, grant := range grants {
+		grantResourceIndicator(req.requester, grant.Audience, grant.Scopes)
+	}
+	// The token endpoint issues each access token for one of several granted resources, so it needs to know which scopes belong to which
+	if len(grants) > 1 {
+		session.ResourceGrants = grants
+	}
 
 	authorizationEvent := model.AuditLogEventClientAuthorization
 	if !hasAlreadyAuthorizedClient {
@@ -302,27 +354,36 @@ func flagPkceSupportedClient(ctx context.Context, clientID string, tx *gorm.DB)
 	return nil
 }
 
+// userAuthentication is when, and with which authentication context class, the user last authenticated.
+type userAuthentication struct {
+	time         time.Time
+	contextClass string
+}
+
 // resolveRequirements determines the interaction steps still required; the requirements
 // of a resumed interaction session win over the ones derived from the request.
-func (s *authorizationService) resolveRequirements(ctx context.Context, req authorizeRequest, interactionSession *InteractionSession) (interactionRequirements, time.Time, error) {
-	authenticationTime := req.authenticationTime
+func (s *authorizationService) resolveRequirements(ctx context.Context, req authorizeRequest, interactionSession *InteractionSession) (interactionRequirements, userAuthentication, error) {
+	authentication := userAuthentication{time: req.authenticationTime, contextClass: req.authenticationContextClass}
+	form := req.requester.GetRequestForm()
 
-	resource, err := req.requester.GetResource()
+	_, consentKeys, err := s.resolveResourceGrants(ctx, req.client.GetID(), requestedResources(form), req.requester.GetRequestedScopes(), req.claimsRequest)
 	if err != nil {
-		return interactionRequirements{}, authenticationTime, err
-	}
-	_, _, consentKeys, err := s.resolveGrant(ctx, req.client.GetID(), resource, req.requester.GetRequestedScopes())
-	if err != nil {
-		return interactionRequirements{}, authenticationTime, err
+		return interactionRequirements{}, authentication, err
 	}
 	hasAlreadyAuthorizedClient, err := s.hasAuthorizedClient(ctx, req.client.GetID(), req.userID, consentKeys)
 	if err != nil {
-		return interactionRequirements{}, authenticationTime, err
+		return interactionRequirements{}, authentication, err
 	}
+	// Authorization details are specific to one request, like a single payment, so a previous consent never covers them
+	hasAlreadyAuthorizedClient = hasAlreadyAuthorizedClient && len(req.authorizationDetails) == 0
 
-	maxAgeReauthenticationRequired, err := requiresReauthenticationForMaxAge(req.requester.GetRequestForm().Get("max_age"), authenticationTime, req.now)
+	maxAgeReauthenticationRequired, err := requiresReauthenticationForMaxAge(form.Get("max_age"), authentication.time, req.now)
+	if err != nil {
+		return interactionRequirements{}, authentication, err
+	}
+	requiredContextClass, err := requiredAuthenticationContextClass(req.client.OidcClient, form.Get("acr_values"), form.Get("claims"))
 	if err != nil {
-		return interactionRequirements{}, authenticationTime, err
+		return interactionRequirements{}, authentication, err
 	}
 
 	requirements := interactionRequirements{
@@ -340,29 +401,44 @@ func (s *authorizationService) resolveRequirements(ctx context.Context, req auth
 			AuthenticationRequired:   interactionSession.AuthenticationRequired,
 		}
 		if interactionSession.ReauthenticatedAt != nil {
-			authenticationTime = interactionSession.ReauthenticatedAt.UTC()
+			authentication = userAuthentication{time: interactionSession.ReauthenticatedAt.UTC(), contextClass: interactionSession.ReauthenticationContextClass}
 		}
 	}
 
+	// A user who authenticated with a weaker class than required steps up by reauthenticating, see RFC 9470
+	if !common.SatisfiesAuthenticationContext(authentication.contextClass, requiredContextClass) {
+		requirements.ReauthenticationRequired = true
+	}
+
 	if req.prompt.has("none") && requirements.ConsentRequired {
-		return interactionRequirements{}, authenticationTime, fosite.ErrConsentRequired
+		return interactionRequirements{}, authentication, fosite.ErrConsentRequired
 	}
 	if req.prompt.has("none") && requirements.ReauthenticationRequired {
-		return interactionRequirements{}, authenticationTime, fosite.ErrLoginRequired
+		return interactionRequirements{}, authentication, fosite.ErrLoginRequired
 	}
 
 	if requirements.ReauthenticationRequired && req.reauthenticationToken != "" && s.reauth != nil {
-		reauthenticatedAt, err := s.reauth.ConsumeReauthenticationToken(ctx, dbFromContext(ctx, s.db), req.reauthenticationToken, req.userID)
+		reauthenticatedAt, contextClass, err := s.reauth.ConsumeReauthenticationToken(ctx, dbFromContext(ctx, s.db), req.reauthenticationToken, req.userID)
 		if err == nil {
-			requirements.ReauthenticationRequired = false
-			authenticationTime = reauthenticatedAt
+			requirements.ReauthenticationRequired = !common.SatisfiesAuthenticationContext(contextClass, requiredContextClass)
+			authentication = userAuthentication{time: reauthenticatedAt, contextClass: contextClass}
+		}
+	}
+
+	// The stored requirements of a resumed interaction don't include a step-up, so the user is sent back to the reauthentication step
+	if requirements.ReauthenticationRequired && interactionSession != nil && !interactionSession.ReauthenticationRequired {
+		interactionSession.ReauthenticationRequired = true
+		err = s.interactionSessionService.update(ctx, *interactionSession)
+		if err != nil {
+			return interactionRequirements{}, authentication, err
 		}
 	}
 
-	return requirements, authenticationTime, nil
+	return requirements, authentication, nil
 }
 
-func (s *authorizationService) buildAuthorizedSession(req authorizeRequest, interactionSession *InteractionSession, authenticationTime time.Time) *Session {
+func (s *authorizationService) buildAuthorizedSession(req authorizeRequest, interactionSession *InteractionSession, authentication userAuthentication) *Session {
+	authenticationTime := authentication.time
 	if authenticationTime.IsZero() {
 		authenticationTime = req.now
 	}
@@ -374,7 +450,11 @@ func (s *authorizationService) buildAuthorizedSession(req authorizeRequest, inte
 		requestedAt = req.now
 	}
 
-	return NewAuthenticatedSession(req.userID, req.authenticationMethod, authenticationTime, requestedAt)
+	session := NewAuthenticatedSession(req.userID, req.authenticationMethod, authenticationTime, requestedAt)
+	session.AuthenticationContextClass = authentication.contextClass
+	session.AuthorizationDetails = req.authorizationDetails
+	session.SessionID = req.sessionID
+	return session
 }
 
 // interactionRequestQuery returns the authorize parameters stored for the interaction
@@ -394,6 +474,10 @@ func (s *authorizationService) interactionRequestQuery(ctx context.Context, inte
 			query.Set(key, value)
 		}
 	}
+	// Several resources are stored space-separated, but the authorize endpoint expects the parameter repeated
+	if resources := strings.Fields(interactionSession.Parameters["resource"]); len(resources) > 0 {
+		query["resource"] = resources
+	}
 	query.Set("interaction", interactionSession.ID)
 
 	return query, nil
@@ -508,6 +592,7 @@ func (s *authorizationService) switchInteractionSessionUser(ctx context.Context,
 	interactionSession.ConsentRequired = requirements.ConsentRequired
 	interactionSession.ReauthenticationRequired = requirements.ReauthenticationRequired
 	interactionSession.ReauthenticatedAt = nil
+	interactionSession.ReauthenticationContextClass = ""
 
 	return nil
 }
@@ -524,7 +609,7 @@ func (s *authorizationService) getInteractionSession(ctx context.Context, intera
 	return s.buildInteractionForUser(ctx, interactionSession)
 }
 
-// buildInteractionForUser builds the consent-screen DTO and enriches it with display information for the requested custom-API permissions
+// buildInteractionForUser builds the consent-screen DTO and enriches it with display information for the requested custom-API permissions and the claims the client gets
 func (s *authorizationService) buildInteractionForUser(ctx context.Context, interactionSession InteractionSession) (interactionSessionForUser, error) {
 	result, err := newInteractionSessionForUser(interactionSession)
 	if err != nil {
@@ -541,13 +626,43 @@ func (s *authorizationService) buildInteractionForUser(ctx context.Context, inte
 	}
 	result.ScopeInfo = scopeInfo
 
+	authorizationDetails, err := s.resolveAuthorizationDetailInfo(ctx, strings.Fields(interactionSession.Parameters["resource"]), interactionSession.Parameters["authorization_details"])
+	if err != nil {
+		return interactionSessionForUser{}, err
+	}
+	if authorizationDetails == nil {
+		authorizationDetails = []dto.AuthorizationDetailInfoDto{}
+	}
+	result.AuthorizationDetails = authorizationDetails
+
+	// The claims can only be resolved once the user is known, which is always the case on the consent screen
+	result.Claims = []string{}
+	if interactionSession.UserID != nil {
+		claimsRequest, err := parseClaimsRequest(interactionSession.Parameters["claims"])
+		if err != nil {
+			return interactionSessionForUser{}, err
+		}
+		result.Claims, err = s.claimsService.releasedClaimNames(ctx, interactionSession.Client, *interactionSession.UserID, interactionSession.Scopes, claimsRequest)
+		if err != nil {
+			return interactionSessionForUser{}, err
+		}
+	}
+
 	return result, nil
 }
 
-// resolveScopeInfo resolves display names and descriptions for the requested non-standard scopes, looked up against the API targeted by the request's RFC 8707 resource
+// resolveScopeInfo resolves display names and descriptions for the requested non-standard scopes, looked up against every API targeted by the request's RFC 8707 resources
 // Standard identity scopes are rendered by the client
 func (s *authorizationService) resolveScopeInfo(ctx context.Context, interactionSession InteractionSession) ([]dto.ScopeInfoDto, error) {
-	return s.resolveScopeInfoForRequest(ctx, interactionSession.Parameters["resource"], interactionSession.Scopes)
+	var infos []dto.ScopeInfoDto
+	for _, resource := range strings.Fields(interactionSession.Parameters["resource"]) {
+		resourceInfos, err := s.resolveScopeInfoForRequest(ctx, resource, interactionSession.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, resourceInfos...)
+	}
+	return infos, nil
 }
 
 // resolveScopeInfoForRequest resolves display names and descriptions for the requested non-standard scopes against the API identified by resource
@@ -579,7 +694,40 @@ func (s *authorizationService) resolveScopeInfoForRequest(ctx context.Context, r
 	return infos, nil
 }
 
-func (s *authorizationService) completeInteractionStep(ctx context.Context, interactionSessionID, userID string, step interactionStep, reauthenticationToken string, authenticationTime time.Time, meta requestMeta) (completeInteractionResponse, error) {
+// resolveAuthorizationDetailInfo pairs the requested authorization details with the name and description of their type, so the consent screen can show what the user grants
+func (s *authorizationService) resolveAuthorizationDetailInfo(ctx context.Context, resources []string, authorizationDetails string) ([]dto.AuthorizationDetailInfoDto, error) {
+	details, err := parseAuthorizationDetails(authorizationDetails)
+	if err != nil || len(details) == 0 || s.apiAccess == nil {
+		return nil, err
+	}
+	resource, err := authorizationDetailsResource(resources, authorizationDetails)
+	if err != nil || resource == "" {
+		return nil, err
+	}
+
+	detailTypes, err := s.apiAccess.AuthorizationDetailTypes(ctx, dbFromContext(ctx, s.db), strings.TrimRight(resource, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]dto.AuthorizationDetailInfoDto, len(details))
+	for i, detail := range details {
+		infos[i] = dto.AuthorizationDetailInfoDto{
+			AuthorizationDetailTypeDto: dto.AuthorizationDetailTypeDto{Type: detail.Type(), Name: detail.Type()},
+			Detail:                     detail,
+		}
+		index := slices.IndexFunc(detailTypes, func(detailType dto.AuthorizationDetailTypeDto) bool {
+			return detailType.Type == detail.Type()
+		})
+		if index >= 0 {
+			infos[i].AuthorizationDetailTypeDto = detailTypes[index]
+		}
+	}
+
+	return infos, nil
+}
+
+func (s *authorizationService) completeInteractionStep(ctx context.Context, interactionSessionID, userID string, step interactionStep, declinedScopes []string, reauthenticationToken string, authenticationTime time.Time, meta requestMeta) (completeInteractionResponse, error) {
 	var interactionSession InteractionSession
 	var response completeInteractionResponse
 	err := withTx(ctx, s.db, func(ctx context.Context) error {
@@ -612,7 +760,7 @@ func (s *authorizationService) completeInteractionStep(ctx context.Context, inte
 			return apperror.ValidationMessage("expected interaction step " + string(requiredSteps[0]) + " but got " + string(step))
 		}
 
-		if err := s.applyInteractionStep(ctx, &interactionSession, userID, step, reauthenticationToken, authenticationTime, meta); err != nil {
+		if err := s.applyInteractionStep(ctx, &interactionSession, userID, step, declinedScopes, reauthenticationToken, authenticationTime, meta); err != nil {
 			return err
 		}
 
@@ -637,7 +785,7 @@ func (s *authorizationService) completeInteractionStep(ctx context.Context, inte
 	return completeInteractionResponse{Interaction: &interaction}, nil
 }
 
-func (s *authorizationService) applyInteractionStep(ctx context.Context, interactionSession *InteractionSession, userID string, step interactionStep, reauthenticationToken string, authenticationTime time.Time, meta requestMeta) error {
+func (s *authorizationService) applyInteractionStep(ctx context.Context, interactionSession *InteractionSession, userID string, step interactionStep, declinedScopes []string, reauthenticationToken string, authenticationTime time.Time, meta requestMeta) error {
 	switch step {
 	case interactionStepAuthenticate:
 		if err := bindInteractionSessionUser(interactionSession, userID); err != nil {
@@ -654,7 +802,7 @@ func (s *authorizationService) applyInteractionStep(ctx context.Context, interac
 	case interactionStepReauthenticate:
 		return s.completeReauthenticationStep(ctx, interactionSession, userID, reauthenticationToken)
 	case interactionStepConsent:
-		return s.completeConsentStep(ctx, interactionSession, userID, meta)
+		return s.completeConsentStep(ctx, interactionSession, userID, declinedScopes, meta)
 	default:
 		return apperror.ValidationMessage("unknown interaction step " + string(step))
 	}
@@ -667,26 +815,43 @@ func (s *authorizationService) completeReauthenticationStep(ctx context.Context,
 	if reauthenticationToken == "" {
 		return apperror.MissingField("reauthenticationToken")
 	}
-	reauthenticatedAt, err := s.reauth.ConsumeReauthenticationToken(ctx, dbFromContext(ctx, s.db), reauthenticationToken, userID)
+	reauthenticatedAt, contextClass, err := s.reauth.ConsumeReauthenticationToken(ctx, dbFromContext(ctx, s.db), reauthenticationToken, userID)
+	if err != nil {
+		return err
+	}
+
+	// The user can try again with a stronger passkey if this one doesn't meet the requirement
+	requiredContextClass, err := requiredAuthenticationContextClass(interactionSession.Client, interactionSession.Parameters["acr_values"], interactionSession.Parameters["claims"])
 	if err != nil {
 		return err
 	}
+	if !common.SatisfiesAuthenticationContext(contextClass, requiredContextClass) {
+		return apperror.InsufficientUserAuthentication()
+	}
 
 	interactionSession.ReauthenticationRequired = false
 	interactionSession.ReauthenticatedAt = new(datatype.DateTime(reauthenticatedAt))
+	interactionSession.ReauthenticationContextClass = contextClass
 	return nil
 }
 
-func (s *authorizationService) completeConsentStep(ctx context.Context, interactionSession *InteractionSession, userID string, meta requestMeta) error {
+func (s *authorizationService) completeConsentStep(ctx context.Context, interactionSession *InteractionSession, userID string, declinedScopes []string, meta requestMeta) error {
 	if err := bindInteractionSessionUser(interactionSession, userID); err != nil {
 		return err
 	}
-	resource := interactionSession.Parameters["resource"]
-	_, _, consentKeys, err := s.resolveGrant(ctx, interactionSession.ClientID, resource, interactionSession.Scopes)
+	claimsRequest, err := parseClaimsRequest(interactionSession.Parameters["claims"])
+	if err != nil {
+		return err
+	}
+	grants, consentKeys, err := s.resolveResourceGrants(ctx, interactionSession.ClientID, strings.Fields(interactionSession.Parameters["resource"]), interactionSession.Scopes, claimsRequest)
 	if err != nil {
 		return err
 	}
-	hasAlreadyAuthorizedClient, err := s.consent(ctx, userID, interactionSession.ClientID, consentKeys)
+	declinedKeys, err := declinedConsentKeys(grants, interactionSession.Scopes, declinedScopes)
+	if err != nil {
+		return err
+	}
+	_, hasAlreadyAuthorizedClient, err := s.saveConsent(ctx, userID, interactionSession.ClientID, consentKeys, declinedKeys, false)
 	if err != nil {
 		return err
 	}
@@ -716,8 +881,11 @@ func (s *authorizationService) populatePostAuthenticationRequirements(ctx contex
 
 func (s *authorizationService) interactionRequirementsForUser(ctx context.Context, userID string, interactionSession *InteractionSession, authenticationTime time.Time) (interactionRequirements, error) {
 	prompt := newPromptValues(interactionSession.Parameters["prompt"])
-	resource := interactionSession.Parameters["resource"]
-	_, _, consentKeys, err := s.resolveGrant(ctx, interactionSession.ClientID, resource, interactionSession.Scopes)
+	claimsRequest, err := parseClaimsRequest(interactionSession.Parameters["claims"])
+	if err != nil {
+		return interactionRequirements{}, err
+	}
+	_, consentKeys, err := s.resolveResourceGrants(ctx, interactionSession.ClientID, strings.Fields(interactionSession.Parameters["resource"]), interactionSession.Scopes, claimsRequest)
 	if err != nil {
 		return interactionRequirements{}, err
 	}
@@ -725,6 +893,7 @@ func (s *authorizationService) interactionRequirementsForUser(ctx context.Contex
 	if err != nil {
 		return interactionRequirements{}, err
 	}
+	hasAlreadyAuthorizedClient = hasAlreadyAuthorizedClient && interactionSession.Parameters["authorization_details"] == ""
 
 	maxAgeReauthenticationRequired, err := requiresReauthenticationForMaxAge(interactionSession.Parameters["max_age"], authenticationTime, time.Now().UTC())
 	if err != nil {
@@ -774,7 +943,16 @@ func requiresReauthenticationForMaxAge(maxAgeRaw string, authenticationTime time
 	return !now.Before(authenticationTime.UTC().Add(time.Duration(maxAge) * time.Second)), nil
 }
 
+// consent records that the user granted the consent keys, which they approved on a screen listing all of them, and updates when the client was last used
 func (s *authorizationService) consent(ctx context.Context, userID string, clientID string, scope []string) (hasAlreadyAuthorizedClient bool, err error) {
+	_, hasAlreadyAuthorizedClient, err = s.saveConsent(ctx, userID, clientID, scope, nil, false)
+	return hasAlreadyAuthorizedClient, err
+}
+
+// saveConsent records the user's decisions on the consent keys of an authorization: the declined keys are declined and all others granted
+// With keepDecisions, only the keys the user hasn't decided on yet are recorded, for authorizations that didn't show the consent screen
+// It returns the decisions on all the consent keys of the client, and whether the user had decided on every key of this authorization already
+func (s *authorizationService) saveConsent(ctx context.Context, userID string, clientID string, scope []string, declined []string, keepDecisions bool) (consents model.ScopeConsents, hasAlreadyAuthorizedClient bool, err error) {
 	db := dbFromContext(ctx, s.db)
 
 	now := datatype.DateTime(time.Now())
@@ -784,51 +962,58 @@ func (s *authorizationService) consent(ctx context.Context, userID string, clien
 		First(&existing, "client_id = ? AND user_id = ?", clientID, userID).
 		Error
 	if err == nil {
-		// If the user has already authorized the client with the same or a superset of the requested scopes, we just update the last_used_at timestamp and return
-		hasAlreadyAuthorizedClient = consentScopesInclude(existing.Scope, scope)
-		if hasAlreadyAuthorizedClient {
+		hasAlreadyAuthorizedClient = !slices.ContainsFunc(scope, func(key string) bool {
+			_, ok := existing.ScopeConsents.Find(key)
+			return !ok
+		})
+		// If the user has already decided on all the consent keys and isn't deciding again, we just update the last_used_at timestamp and return
+		if hasAlreadyAuthorizedClient && keepDecisions {
 			err = db.
 				Model(&model.UserAuthorizedOidcClient{}).
 				Where("user_id = ? AND client_id = ?", userID, clientID).
 				Update("last_used_at", now).
 				Error
 
-			return hasAlreadyAuthorizedClient, err
+			return existing.ScopeConsents, hasAlreadyAuthorizedClient, err
 		}
 
-		// If the user has already authorized the client but with a different set of scopes, we merge the scopes and update the record
+		// Otherwise we merge the decisions, keeping the ones for other resources, and update the record
+		consents = mergeScopeConsents(existing.ScopeConsents, scope, declined, now, keepDecisions)
 		err = db.
 			Model(&model.UserAuthorizedOidcClient{}).
 			Where("user_id = ? AND client_id = ?", userID, clientID).
 			Updates(map[string]any{
-				"scope":        mergeConsentScopes(existing.Scope, scope),
-				"last_used_at": now,
+				"scope_consents": consents,
+				"last_used_at":   now,
 			}).
 			Error
 
-		return hasAlreadyAuthorizedClient, err
+		return consents, hasAlreadyAuthorizedClient, err
 	}
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
-		return false, err
+		return nil, false, err
 	}
 
 	// The user has not authorized the client yet, so we create a new record
+	consents = mergeScopeConsents(nil, scope, declined, now, keepDecisions)
 	err = db.Create(&model.UserAuthorizedOidcClient{
-		UserID:     userID,
-		ClientID:   clientID,
-		Scope:      mergeConsentScopes(nil, scope),
-		LastUsedAt: now,
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: consents,
+		LastUsedAt:    now,
 	}).Error
 	if errors.Is(err, gorm.ErrDuplicatedKey) {
-		return s.consent(ctx, userID, clientID, scope)
+		return s.saveConsent(ctx, userID, clientID, scope, declined, keepDecisions)
 	}
 
-	return false, err
+	return consents, false, err
 }
 
+// hasAuthorizedClient reports whether the user decided on every consent key, by granting or declining it, and the client's consent lifetime hasn't passed since
 func (s *authorizationService) hasAuthorizedClient(ctx context.Context, clientID, userID string, scope []string) (bool, error) {
 	var userAuthorizedOidcClient model.UserAuthorizedOidcClient
 	err := dbFromContext(ctx, s.db).
+		Preload("Client").
 		First(&userAuthorizedOidcClient, "client_id = ? AND user_id = ?", clientID, userID).
 		Error
 	if err != nil {
@@ -838,12 +1023,13 @@ func (s *authorizationService) hasAuthorizedClient(ctx context.Context, clientID
 		return false, err
 	}
 
-	return consentScopesInclude(userAuthorizedOidcClient.Scope, scope), nil
+	return scopeConsentsInclude(userAuthorizedOidcClient.ScopeConsents, scope, userAuthorizedOidcClient.Client.ConsentLifetimeMinutes, time.Now()), nil
 }
 
-func consentScopesInclude(authorizedScopes []string, requestedScopes []string) bool {
+func scopeConsentsInclude(consents model.ScopeConsents, requestedScopes []string, lifetimeMinutes int64, now time.Time) bool {
 	for _, requestedScope := range requestedScopes {
-		if !slices.Contains(authorizedScopes, requestedScope) {
+		consent, ok := consents.Find(requestedScope)
+		if !ok || consent.IsExpiredAt(lifetimeMinutes, now) {
 			return false
 		}
 	}
@@ -851,25 +1037,29 @@ func consentScopesInclude(authorizedScopes []string, requestedScopes []string) b
 	return true
 }
 
-// mergeConsentScopes merges the authorized scopes with the requested scopes, ensuring that there are no duplicates.
+// mergeScopeConsents merges the existing decisions with the ones on the requested consent keys, ensuring that there are no duplicates.
 // Merging is necessary because the user may authorize scopes for multiple resources.
-// If we would just replace the authorized scopes with the requested scopes, we would lose the previously authorized scopes for other resources.
-func mergeConsentScopes(authorizedScopes []string, requestedScopes []string) datatype.StringList {
-	merged := make(datatype.StringList, 0, len(authorizedScopes)+len(requestedScopes))
-	seen := make(map[string]struct{}, len(authorizedScopes)+len(requestedScopes))
-	for _, scope := range authorizedScopes {
-		if _, ok := seen[scope]; ok {
+// If we would just replace the decisions with the requested ones, we would lose the previously authorized scopes for other resources.
+func mergeScopeConsents(existing model.ScopeConsents, requestedScopes []string, declined []string, now datatype.DateTime, keepDecisions bool) model.ScopeConsents {
+	merged := make(model.ScopeConsents, 0, len(existing)+len(requestedScopes))
+	for _, consent := range existing {
+		if _, ok := merged.Find(consent.Scope); ok {
 			continue
 		}
-		seen[scope] = struct{}{}
-		merged = append(merged, scope)
+		merged = append(merged, consent)
 	}
 	for _, scope := range requestedScopes {
-		if _, ok := seen[scope]; ok {
+		index := slices.IndexFunc(merged, func(consent model.ScopeConsent) bool { return consent.Scope == scope })
+		if index >= 0 && keepDecisions {
 			continue
 		}
-		seen[scope] = struct{}{}
-		merged = append(merged, scope)
+
+		consent := model.ScopeConsent{Scope: scope, Granted: !slices.Contains(declined, scope), ConsentedAt: now}
+		if index >= 0 {
+			merged[index] = consent
+		} else {
+			merged = append(merged, consent)
+		}
 	}
 
 	return merged
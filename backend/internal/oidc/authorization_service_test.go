@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
 	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
@@ -27,6 +28,15 @@ func (f *fakeAuditLogger) Create(_ context.Context, event model.AuditLogEvent, _
 	return model.AuditLog{}, true
 }
 
+// grantedScopeConsents returns the consent of a user who granted the scopes just now
+func grantedScopeConsents(scopes ...string) model.ScopeConsents {
+	consents := make(model.ScopeConsents, len(scopes))
+	for i, scope := range scopes {
+		consents[i] = model.ScopeConsent{Scope: scope, Granted: true, ConsentedAt: datatype.DateTime(time.Now())}
+	}
+	return consents
+}
+
 func TestAuthorizationServiceAuthorizeLogsClientAuthorization(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 	auditLogger := &fakeAuditLogger{}
@@ -45,9 +55,9 @@ func TestAuthorizationServiceAuthorizeLogsClientAuthorization(t *testing.T) {
 		Name: "Test Client",
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 
 	authorization, err := service.authorize(t.Context(), authorizeInput{
@@ -163,7 +173,7 @@ func TestAuthorizationServiceConsentStepLogsNewClientAuthorization(t *testing.T)
 		Parameters:      map[string]string{},
 	}).Error)
 
-	response, err := service.completeInteractionStep(t.Context(), interactionID, userID, interactionStepConsent, "", time.Now().UTC(), requestMeta{})
+	response, err := service.completeInteractionStep(t.Context(), interactionID, userID, interactionStepConsent, nil, "", time.Now().UTC(), requestMeta{})
 	require.NoError(t, err)
 	require.NotEmpty(t, response.RedirectURL)
 
@@ -210,7 +220,7 @@ func TestAuthorizationServiceConsentMergesAudienceQualifiedScopeKeys(t *testing.
 		"openid",
 		consentScopeKey(apiA, "read"), consentAudienceKey(apiA),
 		consentScopeKey(apiB, "read"), consentAudienceKey(apiB),
-	}, authorizedClient.Scope)
+	}, authorizedClient.ScopeConsents.Granted())
 
 	hasAuthorizedAPIA, err := service.hasAuthorizedClient(t.Context(), clientID, userID, apiAConsent)
 	require.NoError(t, err)
@@ -307,9 +317,9 @@ func TestAuthorizationServiceAuthorizeConsumesInteractionSession(t *testing.T) {
 		Name: "Test Client",
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 	require.NoError(t, db.Create(&InteractionSession{
 		Base:        model.Base{ID: interactionID},
@@ -380,7 +390,7 @@ func TestInteractionAPIClassifiesMissingSession(t *testing.T) {
 	_, err := service.getInteractionSession(t.Context(), "missing-interaction")
 	require.True(t, apperror.IsCode(err, apperror.CodeNotFound))
 
-	_, err = service.completeInteractionStep(t.Context(), "missing-interaction", "user", interactionStepConsent, "", time.Now().UTC(), requestMeta{})
+	_, err = service.completeInteractionStep(t.Context(), "missing-interaction", "user", interactionStepConsent, nil, "", time.Now().UTC(), requestMeta{})
 	require.True(t, apperror.IsCode(err, apperror.CodeNotFound))
 }
 
@@ -436,7 +446,7 @@ func TestAuthorizationServiceAuthorizeBindsScopesToInteractionSession(t *testing
 	// The user must not have been recorded as having authorized the escalated scopes
 	var authorizedClient model.UserAuthorizedOidcClient
 	require.NoError(t, db.First(&authorizedClient, "user_id = ? AND client_id = ?", userID, clientID).Error)
-	require.Equal(t, datatype.StringList{"openid"}, authorizedClient.Scope)
+	require.Equal(t, []string{"openid"}, authorizedClient.ScopeConsents.Granted())
 }
 
 func TestAuthorizationServiceAuthorizeRejectsInteractionSessionOfOtherClient(t *testing.T) {
@@ -502,9 +512,9 @@ func TestAuthorizationServiceAuthorizeSwitchesUserAndResetsRequirements(t *testi
 		Name: "Test Client",
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 	require.NoError(t, db.Create(&InteractionSession{
 		Base:                     model.Base{ID: interactionID},
@@ -597,7 +607,7 @@ func TestAuthorizationServiceCompleteInteractionBindsUserToSession(t *testing.T)
 		Parameters:      map[string]string{},
 	}).Error)
 
-	response, err := service.completeInteractionStep(t.Context(), interactionID, userID, interactionStepConsent, "", time.Now().UTC(), requestMeta{})
+	response, err := service.completeInteractionStep(t.Context(), interactionID, userID, interactionStepConsent, nil, "", time.Now().UTC(), requestMeta{})
 	require.NoError(t, err)
 	require.NotEmpty(t, response.RedirectURL)
 
@@ -642,7 +652,7 @@ func TestAuthorizationServiceCompleteInteractionSwitchesUserAndResetsRequirement
 		},
 	}).Error)
 
-	response, err := service.completeInteractionStep(t.Context(), interactionID, otherUserID, interactionStepSelectAccount, "", time.Now().UTC(), requestMeta{})
+	response, err := service.completeInteractionStep(t.Context(), interactionID, otherUserID, interactionStepSelectAccount, nil, "", time.Now().UTC(), requestMeta{})
 	require.NoError(t, err)
 	require.Empty(t, response.RedirectURL)
 	require.NotNil(t, response.Interaction)
@@ -676,9 +686,9 @@ func TestAuthorizationServiceSelectAccountRecomputesConsentForSelectedUser(t *te
 
 	// The initiator has previously consented to the client; the switched-to user has not.
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   initiatorID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        initiatorID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 
 	authorization, err := service.authorize(t.Context(), authorizeInput{
@@ -690,7 +700,7 @@ func TestAuthorizationServiceSelectAccountRecomputesConsentForSelectedUser(t *te
 	require.NoError(t, err)
 	require.True(t, authorization.RequiresInteraction)
 
-	response, err := service.completeInteractionStep(t.Context(), authorization.InteractionID, switchedID, interactionStepSelectAccount, "", time.Now().UTC(), requestMeta{})
+	response, err := service.completeInteractionStep(t.Context(), authorization.InteractionID, switchedID, interactionStepSelectAccount, nil, "", time.Now().UTC(), requestMeta{})
 	require.NoError(t, err)
 
 	// The flow must not be granted yet: consent is still pending for the switched-to user.
@@ -732,7 +742,7 @@ func TestAuthorizationServiceAuthorizeEnforcesPerClientPKCE(t *testing.T) {
 	)
 	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}, Username: "test-user"}).Error)
 	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "PKCE Client", PkceEnabled: true}).Error)
-	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: userID, ClientID: clientID, Scope: datatype.StringList{"openid"}}).Error)
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: userID, ClientID: clientID, ScopeConsents: grantedScopeConsents("openid")}).Error)
 
 	pkceClient := Client{OidcClient: model.OidcClient{Base: model.Base{ID: clientID}, Name: "PKCE Client", PkceEnabled: true}}
 
@@ -777,9 +787,9 @@ func TestAuthorizationServiceAuthorizePARRequiredClient(t *testing.T) {
 		RequiresPushedAuthorizationRequests: true,
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 
 	authorize := func(interactionID string, hasPushedAuthorizationRequest bool) (authorizationResult, error) {
@@ -881,9 +891,9 @@ func TestAuthorizationServiceAuthorizeUsesLoginAuthenticationTime(t *testing.T)
 		Name: "Test Client",
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 
 	firstAuthorization, err := service.authorize(t.Context(), authorizeInput{
@@ -923,9 +933,9 @@ func TestAuthorizationServiceAuthorizeRequiresReauthenticationWhenMaxAgeExceeded
 		Name: "Test Client",
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 
 	authorization, err := service.authorize(t.Context(), authorizeInput{
@@ -962,9 +972,9 @@ func TestAuthorizationServiceAuthorizeUsesCompletedReauthenticationTime(t *testi
 		Name: "Test Client",
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 	require.NoError(t, db.Create(&InteractionSession{
 		Base:                     model.Base{ID: interactionID},
@@ -989,6 +999,311 @@ func TestAuthorizationServiceAuthorizeUsesCompletedReauthenticationTime(t *testi
 	require.Equal(t, reauthenticatedAt, authorization.Session.IDTokenClaims().AuthTime)
 }
 
+func TestAuthorizationServiceAuthorizeReleasesRequestedClaimsAfterConsent(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, fakeCustomClaimSource{}, "", nil), nil, nil, nil)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+
+	require.NoError(t, db.Create(&model.User{
+		Base:  model.Base{ID: userID},
+		Email: stringPointer("tim@example.com"),
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base: model.Base{ID: clientID},
+		Name: "Test Client",
+	}).Error)
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
+	}).Error)
+	form := url.Values{"claims": {`{"id_token":{"email":null}}`}}
+
+	// The email claim wasn't consented to with the openid scope
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:        userID,
+		requester:     newTestAuthorizeRequesterWithForm("claims-request", clientID, form),
+		requestParams: map[string]string{"claims": form.Get("claims")},
+	})
+	require.NoError(t, err)
+	require.True(t, authorization.RequiresInteraction)
+
+	interaction, err := service.getInteractionSession(t.Context(), authorization.InteractionID)
+	require.NoError(t, err)
+	require.Equal(t, interactionStepConsent, interaction.CurrentStep)
+	require.Equal(t, []string{"email"}, interaction.Claims)
+
+	require.NoError(t, db.Model(&model.UserAuthorizedOidcClient{}).
+		Where("user_id = ? AND client_id = ?", userID, clientID).
+		Update("scope", datatype.StringList{"openid", consentClaimKey("email")}).Error)
+
+	authorization, err = service.authorize(t.Context(), authorizeInput{
+		userID:    userID,
+		requester: newTestAuthorizeRequesterWithForm("consented-claims-request", clientID, form),
+	})
+	require.NoError(t, err)
+	require.False(t, authorization.RequiresInteraction)
+	require.Equal(t, &ClaimsRequest{IDToken: RequestedClaims{"email": nil}}, authorization.Session.ClaimsRequest)
+	require.Equal(t, "tim@example.com", authorization.Session.IDTokenClaims().Extra["email"])
+}
+
+func TestAuthorizationServiceAuthorizeRejectsInvalidClaimsParameter(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
+
+	_, err := service.authorize(t.Context(), authorizeInput{
+		requester: newTestAuthorizeRequesterWithForm("invalid-claims-request", "test-client", url.Values{"claims": {"email"}}),
+	})
+	require.ErrorIs(t, err, fosite.ErrInvalidRequest)
+}
+
+func TestAuthorizationServiceAuthorizeGrantsAuthorizationDetailsAfterConsent(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+		api      = "https://payments.example.com"
+	)
+
+	apiAccess := userAccess(map[string][]string{api: {}})
+	apiAccess.detailTypes = map[string][]string{api: {"payment_initiation"}}
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, apiAccess)
+
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "Test Client"}).Error)
+
+	// The API was consented to before, which doesn't cover a new payment
+	_, err := service.consent(t.Context(), userID, clientID, consentKeysForGrant(api, api, []string{"openid"}))
+	require.NoError(t, err)
+
+	form := url.Values{
+		"resource":              {api},
+		"authorization_details": {`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"100.00"}}]`},
+	}
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:             userID,
+		authenticationTime: time.Now().UTC(),
+		requester:          newTestAuthorizeRequesterWithForm("payment-request", clientID, form),
+		requestParams:      map[string]string{"resource": api, "authorization_details": form.Get("authorization_details")},
+	})
+	require.NoError(t, err)
+	require.True(t, authorization.RequiresInteraction)
+
+	interaction, err := service.getInteractionSession(t.Context(), authorization.InteractionID)
+	require.NoError(t, err)
+	require.Equal(t, interactionStepConsent, interaction.CurrentStep)
+	require.Len(t, interaction.AuthorizationDetails, 1)
+	require.Equal(t, "payment_initiation", interaction.AuthorizationDetails[0].Type)
+	require.Equal(t, map[string]any{"currency": "EUR", "amount": "100.00"}, interaction.AuthorizationDetails[0].Detail["instructedAmount"])
+
+	_, err = service.completeInteractionStep(t.Context(), authorization.InteractionID, userID, interactionStepConsent, nil, "", time.Now().UTC(), requestMeta{})
+	require.NoError(t, err)
+
+	authorization, err = service.authorize(t.Context(), authorizeInput{
+		userID:             userID,
+		authenticationTime: time.Now().UTC(),
+		requester:          newTestAuthorizeRequesterWithForm("payment-request", clientID, form),
+		interactionID:      authorization.InteractionID,
+	})
+	require.NoError(t, err)
+	require.False(t, authorization.RequiresInteraction)
+	require.Equal(t, []AuthorizationDetail{{
+		"type":             "payment_initiation",
+		"instructedAmount": map[string]any{"currency": "EUR", "amount": "100.00"},
+	}}, authorization.Session.AuthorizationDetails)
+}
+
+func TestAuthorizationServiceAuthorizeRejectsUnknownAuthorizationDetailsType(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	const api = "https://payments.example.com"
+	apiAccess := userAccess(map[string][]string{api: {}})
+	apiAccess.detailTypes = map[string][]string{api: {"payment_initiation"}}
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, apiAccess)
+
+	_, err := service.authorize(t.Context(), authorizeInput{
+		requester: newTestAuthorizeRequesterWithForm("unknown-type-request", "test-client", url.Values{
+			"resource":              {api},
+			"authorization_details": {`[{"type":"account_information"}]`},
+		}),
+	})
+	require.ErrorIs(t, err, errInvalidAuthorizationDetails)
+}
+
+func TestAuthorizationServiceAuthorizeGrantsSeveralResourcesAfterConsent(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+		orders   = "https://api.orders.example.com"
+		billing  = "https://api.billing.example.com"
+	)
+
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, userAccess(map[string][]string{
+		orders:  {"read:orders"},
+		billing: {"read:billing"},
+	}))
+
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "Test Client"}).Error)
+
+	newRequester := func() fosite.AuthorizeRequester {
+		requester := newTestAuthorizeRequesterWithForm("multi-resource-request", clientID, url.Values{"resource": {orders, billing}})
+		requester.(*fosite.AuthorizeRequest).RequestedScope = fosite.Arguments{"openid", "read:orders", "read:billing"}
+		return requester
+	}
+
+	requester := newRequester()
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:             userID,
+		authenticationTime: time.Now().UTC(),
+		requester:          requester,
+		requestParams:      authorizeRequestParams(requester),
+	})
+	require.NoError(t, err)
+	require.True(t, authorization.RequiresInteraction)
+
+	// The consent screen describes the permissions of both APIs, and the resumed request names both resources again
+	interaction, err := service.getInteractionSession(t.Context(), authorization.InteractionID)
+	require.NoError(t, err)
+	require.Len(t, interaction.ScopeInfo, 2)
+	require.ElementsMatch(t, []string{"read:orders", "read:billing"}, []string{interaction.ScopeInfo[0].Key, interaction.ScopeInfo[1].Key})
+	query, err := service.interactionRequestQuery(t.Context(), authorization.InteractionID)
+	require.NoError(t, err)
+	require.Equal(t, []string{orders, billing}, query["resource"])
+
+	_, err = service.completeInteractionStep(t.Context(), authorization.InteractionID, userID, interactionStepConsent, nil, "", time.Now().UTC(), requestMeta{})
+	require.NoError(t, err)
+
+	var authorizedClient model.UserAuthorizedOidcClient
+	require.NoError(t, db.First(&authorizedClient, "user_id = ? AND client_id = ?", userID, clientID).Error)
+	require.ElementsMatch(t, []string{
+		"openid",
+		consentScopeKey(orders, "read:orders"), consentAudienceKey(orders),
+		consentScopeKey(billing, "read:billing"), consentAudienceKey(billing),
+	}, authorizedClient.ScopeConsents.Granted())
+
+	requester = newRequester()
+	authorization, err = service.authorize(t.Context(), authorizeInput{
+		userID:             userID,
+		authenticationTime: time.Now().UTC(),
+		requester:          requester,
+		interactionID:      authorization.InteractionID,
+	})
+	require.NoError(t, err)
+	require.False(t, authorization.RequiresInteraction)
+	require.ElementsMatch(t, []string{orders, billing}, requester.GetGrantedAudience())
+	require.ElementsMatch(t, []string{"openid", "read:orders", "read:billing"}, requester.GetGrantedScopes())
+	require.Equal(t, []ResourceGrant{
+		{Audience: orders, Scopes: []string{"openid", "read:orders"}},
+		{Audience: billing, Scopes: []string{"openid", "read:billing"}},
+	}, authorization.Session.ResourceGrants)
+}
+
+func TestAuthorizationServiceAuthorizeRequiresStepUpForRequestedACR(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+
+	require.NoError(t, db.Create(&model.User{
+		Base: model.Base{ID: userID},
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base: model.Base{ID: clientID},
+		Name: "Test Client",
+	}).Error)
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
+	}).Error)
+	form := url.Values{"acr_values": {common.ACRUserVerifiedPasskey}}
+
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:                     userID,
+		authenticationContextClass: common.ACRPasskey,
+		requester:                  newTestAuthorizeRequesterWithForm("weak-request", clientID, form),
+	})
+	require.NoError(t, err)
+	require.True(t, authorization.RequiresInteraction)
+
+	interaction, err := service.getInteractionSession(t.Context(), authorization.InteractionID)
+	require.NoError(t, err)
+	require.Equal(t, interactionStepReauthenticate, interaction.CurrentStep)
+
+	authorization, err = service.authorize(t.Context(), authorizeInput{
+		userID:                     userID,
+		authenticationContextClass: common.ACRDeviceBoundPasskey,
+		requester:                  newTestAuthorizeRequesterWithForm("strong-request", clientID, form),
+	})
+	require.NoError(t, err)
+	require.False(t, authorization.RequiresInteraction)
+	require.Equal(t, common.ACRDeviceBoundPasskey, authorization.Session.AuthenticationContextClass)
+	require.Equal(t, common.ACRDeviceBoundPasskey, authorization.Session.IDTokenClaims().AuthenticationContextClassReference)
+}
+
+func TestAuthorizationServiceAuthorizeRequiresStepUpAfterWeakReauthentication(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
+
+	const (
+		userID        = "test-user"
+		clientID      = "test-client"
+		interactionID = "test-interaction"
+	)
+
+	require.NoError(t, db.Create(&model.User{
+		Base: model.Base{ID: userID},
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:       model.Base{ID: clientID},
+		Name:       "Test Client",
+		MinimumACR: common.ACRDeviceBoundPasskey,
+	}).Error)
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
+	}).Error)
+	require.NoError(t, db.Create(&InteractionSession{
+		Base:                         model.Base{ID: interactionID},
+		Scopes:                       datatype.StringList{"openid"},
+		ClientID:                     clientID,
+		ReauthenticatedAt:            new(datatype.DateTime(time.Now().UTC())),
+		ReauthenticationContextClass: common.ACRUserVerifiedPasskey,
+		Parameters:                   map[string]string{},
+	}).Error)
+
+	requester := newTestAuthorizeRequester("final-request", clientID, "")
+	requester.(*fosite.AuthorizeRequest).Client = Client{OidcClient: model.OidcClient{
+		Base:       model.Base{ID: clientID},
+		Name:       "Test Client",
+		MinimumACR: common.ACRDeviceBoundPasskey,
+	}}
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:                     userID,
+		authenticationContextClass: common.ACRPasskey,
+		requester:                  requester,
+		interactionID:              interactionID,
+	})
+	require.NoError(t, err)
+	require.True(t, authorization.RequiresInteraction)
+
+	interaction, err := service.getInteractionSession(t.Context(), interactionID)
+	require.NoError(t, err)
+	require.Equal(t, interactionStepReauthenticate, interaction.CurrentStep)
+}
+
 func TestAuthorizationServiceAuthorizeUsesOriginalInteractionRequestTime(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
@@ -1010,9 +1325,9 @@ func TestAuthorizationServiceAuthorizeUsesOriginalInteractionRequestTime(t *test
 		Name: "Test Client",
 	}).Error)
 	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
-		UserID:   userID,
-		ClientID: clientID,
-		Scope:    datatype.StringList{"openid"},
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: grantedScopeConsents("openid"),
 	}).Error)
 	require.NoError(t, db.Create(&InteractionSession{
 		Base:                     model.Base{ID: interactionID},
@@ -1076,6 +1391,7 @@ func TestInteractionSessionServiceSavePersistsParameters(t *testing.T) {
 	reauthenticatedAt := datatype.DateTime(time.Now().UTC().Truncate(time.Second))
 	interactionSession.ReauthenticationRequired = false
 	interactionSession.ReauthenticatedAt = &reauthenticatedAt
+	interactionSession.ReauthenticationContextClass = common.ACRUserVerifiedPasskey
 	require.NoError(t, service.update(t.Context(), interactionSession))
 
 	storedInteractionSession, err := service.get(t.Context(), interactionID)
@@ -1084,6 +1400,7 @@ func TestInteractionSessionServiceSavePersistsParameters(t *testing.T) {
 	require.Equal(t, "1", storedInteractionSession.Parameters["max_age"])
 	require.NotNil(t, storedInteractionSession.ReauthenticatedAt)
 	require.Equal(t, reauthenticatedAt.UTC(), storedInteractionSession.ReauthenticatedAt.UTC())
+	require.Equal(t, common.ACRUserVerifiedPasskey, storedInteractionSession.ReauthenticationContextClass)
 }
 
 func newTestAuthorizeRequester(requestID, clientID, prompt string) fosite.AuthorizeRequester {
@@ -1200,3 +1517,197 @@ func TestAuthorizationServiceSkipConsentHonorsPromptConsent(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, interactionSession.ConsentRequired)
 }
+
+// Scopes the user unticked on the consent screen must not be granted, and must not bring the consent screen back on their own
+func TestAuthorizationServiceConsentStepDeclinesOptionalScopes(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
+
+	const (
+		userID        = "test-user"
+		clientID      = "test-client"
+		interactionID = "test-interaction"
+	)
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "Test Client"}).Error)
+	require.NoError(t, db.Create(&InteractionSession{
+		Base:            model.Base{ID: interactionID},
+		Scopes:          datatype.StringList{"openid", "email", "profile"},
+		ClientID:        clientID,
+		ConsentRequired: true,
+		RequestedAt:     datatype.DateTime(time.Now().UTC()),
+		Parameters:      map[string]string{},
+	}).Error)
+
+	_, err := service.completeInteractionStep(t.Context(), interactionID, userID, interactionStepConsent, []string{"email"}, "", time.Now().UTC(), requestMeta{})
+	require.NoError(t, err)
+
+	var authorizedClient model.UserAuthorizedOidcClient
+	require.NoError(t, db.First(&authorizedClient, "user_id = ? AND client_id = ?", userID, clientID).Error)
+	require.ElementsMatch(t, []string{"openid", "profile"}, authorizedClient.ScopeConsents.Granted())
+	require.True(t, authorizedClient.ScopeConsents.IsDeclined("email"))
+
+	requester := newTestAuthorizeRequester("declined-scope-request", clientID, "")
+	requester.(*fosite.AuthorizeRequest).RequestedScope = fosite.Arguments{"openid", "email", "profile"}
+
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:             userID,
+		authenticationTime: time.Now().UTC(),
+		requester:          requester,
+		meta:               requestMeta{},
+	})
+	require.NoError(t, err)
+	require.False(t, authorization.RequiresInteraction)
+	require.ElementsMatch(t, fosite.Arguments{"openid", "profile"}, requester.GetGrantedScopes())
+}
+
+func TestAuthorizationServiceDeclinedScopeWithholdsRequestedClaims(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, fakeCustomClaimSource{}, "", nil), nil, nil, nil)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+	require.NoError(t, db.Create(&model.User{
+		Base:  model.Base{ID: userID},
+		Email: stringPointer("tim@example.com"),
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "Test Client"}).Error)
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
+		UserID:        userID,
+		ClientID:      clientID,
+		ScopeConsents: append(grantedScopeConsents("openid"), model.ScopeConsent{Scope: "email", ConsentedAt: datatype.DateTime(time.Now())}),
+	}).Error)
+
+	// The client asks for the declined scope's claim individually as well
+	form := url.Values{"claims": {`{"id_token":{"email":null,"auth_time":null},"userinfo":{"email_verified":null}}`}}
+	requester := newTestAuthorizeRequesterWithForm("declined-claims-request", clientID, form)
+	requester.(*fosite.AuthorizeRequest).RequestedScope = fosite.Arguments{"openid", "email"}
+
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:             userID,
+		authenticationTime: time.Now().UTC(),
+		requester:          requester,
+	})
+	require.NoError(t, err)
+	require.False(t, authorization.RequiresInteraction)
+	require.Equal(t, fosite.Arguments{"openid"}, requester.GetGrantedScopes())
+	require.Equal(t, &ClaimsRequest{
+		IDToken:  RequestedClaims{"auth_time": nil},
+		UserInfo: RequestedClaims{},
+	}, authorization.Session.ClaimsRequest)
+	require.NotContains(t, authorization.Session.IDTokenClaims().Extra, "email")
+}
+
+func TestAuthorizationServiceConsentStepRejectsDecliningRequiredScopes(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "Test Client"}).Error)
+
+	for _, declined := range []string{"openid", "groups"} {
+		interactionID := "test-interaction-" + declined
+		require.NoError(t, db.Create(&InteractionSession{
+			Base:            model.Base{ID: interactionID},
+			Scopes:          datatype.StringList{"openid", "email"},
+			ClientID:        clientID,
+			ConsentRequired: true,
+			RequestedAt:     datatype.DateTime(time.Now().UTC()),
+			Parameters:      map[string]string{},
+		}).Error)
+
+		_, err := service.completeInteractionStep(t.Context(), interactionID, userID, interactionStepConsent, []string{declined}, "", time.Now().UTC(), requestMeta{})
+		require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed), declined)
+	}
+
+	var count int64
+	require.NoError(t, db.Model(&model.UserAuthorizedOidcClient{}).Where("user_id = ? AND client_id = ?", userID, clientID).Count(&count).Error)
+	require.Zero(t, count)
+}
+
+// Once the client's consent lifetime has passed since the user's decision, the user has to consent again
+func TestAuthorizationServiceAuthorizeRequiresConsentAfterConsentLifetime(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "Test Client", ConsentLifetimeMinutes: 60}).Error)
+
+	consents := grantedScopeConsents("openid")
+	consents[0].ConsentedAt = datatype.DateTime(time.Now().Add(-2 * time.Hour))
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: userID, ClientID: clientID, ScopeConsents: consents}).Error)
+
+	authorization, err := service.authorize(t.Context(), authorizeInput{
+		userID:             userID,
+		authenticationTime: time.Now().UTC(),
+		requester:          newTestAuthorizeRequester("expired-consent-request", clientID, ""),
+		meta:               requestMeta{},
+	})
+	require.NoError(t, err)
+	require.True(t, authorization.RequiresInteraction)
+
+	interactionSession, err := newInteractionSessionService(db).get(t.Context(), authorization.InteractionID)
+	require.NoError(t, err)
+	require.True(t, interactionSession.ConsentRequired)
+
+	// Consenting again starts a new lifetime
+	_, err = service.completeInteractionStep(t.Context(), authorization.InteractionID, userID, interactionStepConsent, nil, "", time.Now().UTC(), requestMeta{})
+	require.NoError(t, err)
+
+	hasAuthorized, err := service.hasAuthorizedClient(t.Context(), clientID, userID, []string{"openid"})
+	require.NoError(t, err)
+	require.True(t, hasAuthorized)
+}
+
+func TestAuthorizationServiceRevokeAuthorizedScope(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := newAuthorizationService(db, newInteractionSessionService(db), newClaimsService(db, nil, "", nil), nil, nil, nil)
+
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: "Test Client"}).Error)
+	consents := grantedScopeConsents("openid", "email", "profile")
+	consents[2].Granted = false
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: userID, ClientID: clientID, ScopeConsents: consents}).Error)
+
+	scopes, err := service.authorizedScopes(t.Context(), userID, clientID)
+	require.NoError(t, err)
+	require.Len(t, scopes, 3)
+	require.Equal(t, consentKeyTypeScope, scopes[0].Type)
+	require.False(t, scopes[0].Revocable)
+	require.True(t, scopes[1].Revocable)
+	require.False(t, scopes[2].Granted)
+	require.False(t, scopes[2].Revocable)
+	require.Nil(t, scopes[1].ExpiresAt)
+
+	err = service.revokeAuthorizedScope(t.Context(), userID, clientID, "openid")
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+	err = service.revokeAuthorizedScope(t.Context(), userID, clientID, "profile")
+	require.True(t, apperror.IsCode(err, apperror.CodeNotFound))
+
+	require.NoError(t, service.revokeAuthorizedScope(t.Context(), userID, clientID, "email"))
+
+	var authorizedClient model.UserAuthorizedOidcClient
+	require.NoError(t, db.First(&authorizedClient, "user_id = ? AND client_id = ?", userID, clientID).Error)
+	require.Equal(t, []string{"openid"}, authorizedClient.ScopeConsents.Granted())
+	_, ok := authorizedClient.ScopeConsents.Find("email")
+	require.False(t, ok)
+
+	// The client has to ask for the revoked scope again
+	hasAuthorized, err := service.hasAuthorizedClient(t.Context(), clientID, userID, []string{"openid", "email"})
+	require.NoError(t, err)
+	require.False(t, hasAuthorized)
+}
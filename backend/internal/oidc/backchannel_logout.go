@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/italypaleale/francis/actor"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+type backchannelLogoutService struct {
+	db       *gorm.DB
+	actors   *actor.Service
+	subjects *pairwiseSubjects
+}
+
+func newBackchannelLogoutService(db *gorm.DB, actors *actor.Service, subjects *pairwiseSubjects) *backchannelLogoutService {
+	return &backchannelLogoutService{
+		db:       db,
+		actors:   actors,
+		subjects: subjects,
+	}
+}
+
+// notifyUserLogout tells every client the user has authorized, and that registered a backchannel logout URI, that the user signed out of the given browser session
+func (s *backchannelLogoutService) notifyUserLogout(ctx context.Context, userID string, sessionID string) error {
+	return s.notify(ctx, userID, "", sessionID)
+}
+
+// notifyClientLogout tells a single client that the user's sessions with it have ended
+// The client is sent a logout token for each browser session the user's tokens with it were issued in, or a single one without a session ID if none of them was
+func (s *backchannelLogoutService) notifyClientLogout(ctx context.Context, userID string, clientID string) error {
+	if s == nil || s.actors == nil || userID == "" || clientID == "" {
+		return nil
+	}
+
+	sessionIDs, err := NewStore(s.db, nil).findSessionIDsForUserClient(ctx, userID, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to load sessions for backchannel logout: %w", err)
+	}
+	if len(sessionIDs) == 0 {
+		return s.notify(ctx, userID, clientID, "")
+	}
+
+	for _, sessionID := range sessionIDs {
+		err = s.notify(ctx, userID, clientID, sessionID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *backchannelLogoutService) notify(ctx context.Context, userID string, clientID string, sessionID string) error {
+	if s == nil || s.actors == nil || userID == "" {
+		return nil
+	}
+
+	query := s.db.
+		WithContext(ctx).
+		Model(&model.OidcClient{}).
+		Where("oidc_clients.backchannel_logout_uri IS NOT NULL AND oidc_clients.backchannel_logout_uri <> ''")
+	if clientID != "" {
+		// The authorization may already be gone, for example when it was just revoked, so the client is notified regardless
+		query = query.Where("oidc_clients.id = ?", clientID)
+	} else {
+		query = query.
+			Joins("JOIN user_authorized_oidc_clients uac ON uac.client_id = oidc_clients.id").
+			Where("uac.user_id = ?", userID)
+	}
+
+	var clients []model.OidcClient
+	err := query.Find(&clients).Error
+	if err != nil {
+		return fmt.Errorf("failed to load clients for backchannel logout: %w", err)
+	}
+
+	for _, client := range clients {
+		err = s.schedule(ctx, backchannelLogoutActorState{
+			ClientID:   client.ID,
+			LogoutURI:  *client.BackchannelLogoutURI,
+			Subject:    s.subjects.subjectFor(client, userID),
+			SessionID:  sessionID,
+			SigningAlg: client.IDTokenSignedResponseAlg,
+			PublicOnly: client.IsSelfRegistered(),
+		})
+		if err != nil {
+			// A failure to schedule one client must not prevent the others from being notified
+			slog.ErrorContext(ctx, "Failed to schedule backchannel logout", slog.String("client_id", client.ID), slog.Any("error", err))
+		}
+	}
+
+	return nil
+}
+
+func (s *backchannelLogoutService) schedule(ctx context.Context, state backchannelLogoutActorState) error {
+	_, err := s.actors.Invoke(ctx, backchannelLogoutActorType, uuid.New().String(), backchannelLogoutActorMethodSchedule, state)
+	return err
+}
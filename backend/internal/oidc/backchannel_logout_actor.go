@@ -0,0 +1,236 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/italypaleale/francis/actor"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// A backchannel logout actor delivers a single logout token to a single client, retrying with a backoff until the client accepts it
+// Each delivery is its own actor, so a slow or unreachable client never holds up the logout of the user or the delivery to other clients
+
+const (
+	backchannelLogoutActorType = "oidc-backchannel-logout"
+
+	backchannelLogoutActorMethodSchedule = "schedule"
+	backchannelLogoutAlarmDeliver        = "deliver"
+
+	// backchannelLogoutEvent is the event identifier the logout token must carry, as defined by OpenID Connect Back-Channel Logout 1.0 section 2.4
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// backchannelLogoutTokenType is the explicit JWT type of logout tokens, recommended by section 2.4 so they can't be confused with ID tokens
+	backchannelLogoutTokenType = "logout+jwt"
+	// backchannelLogoutTokenLifetime is how long a logout token is valid, counted from each delivery attempt as the token is signed again for every attempt
+	backchannelLogoutTokenLifetime = 2 * time.Minute
+	// backchannelLogoutRequestTimeout bounds every delivery attempt
+	backchannelLogoutRequestTimeout = 10 * time.Second
+	// backchannelLogoutStateTTL is how long an undelivered logout is kept, which is longer than the whole retry schedule
+	backchannelLogoutStateTTL = 24 * time.Hour
+)
+
+// backchannelLogoutRetryDelays is the wait before each retry; once exhausted, the delivery is abandoned
+var backchannelLogoutRetryDelays = []time.Duration{
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+}
+
+type backchannelLogoutActorState struct {
+	ClientID  string
+	LogoutURI string
+	Subject   string
+	SessionID string
+	// SigningAlg is the algorithm the client registered for its ID tokens, which logout tokens are signed with too
+	SigningAlg string
+	// PublicOnly restricts the delivery to public IP addresses, for logout URIs a self-registered client chose
+	PublicOnly bool
+	Attempts   int
+}
+
+type backchannelLogoutDelivery struct {
+	signer     TokenSigner
+	issuer     string
+	httpClient *http.Client
+}
+
+type backchannelLogoutActor struct {
+	log      *slog.Logger
+	delivery *backchannelLogoutDelivery
+	client   actor.Client[backchannelLogoutActorState]
+}
+
+func newBackchannelLogoutActor(delivery *backchannelLogoutDelivery) actor.Factory {
+	return func(actorID string, actorService *actor.Service) actor.Actor {
+		return &backchannelLogoutActor{
+			log: slog.With(
+				slog.String("scope", "actor"),
+				slog.String("actorType", backchannelLogoutActorType),
+				slog.String("actorID", actorID),
+			),
+			delivery: delivery,
+			client:   actor.NewActorClient[backchannelLogoutActorState](backchannelLogoutActorType, actorID, actorService),
+		}
+	}
+}
+
+// Invoke implements actor.ActorInvoke
+func (a *backchannelLogoutActor) Invoke(ctx context.Context, method string, data actor.Envelope) (any, error) {
+	if method != backchannelLogoutActorMethodSchedule {
+		return nil, common.ErrUnsupportedActorMethod{Method: method}
+	}
+
+	if data == nil {
+		return nil, errors.New("backchannel logout actor input is missing")
+	}
+	var state backchannelLogoutActorState
+	err := data.Decode(&state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode backchannel logout actor input: %w", err)
+	}
+
+	err = a.client.SetState(ctx, state, &actor.SetStateOpts{TTL: backchannelLogoutStateTTL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to persist backchannel logout actor state: %w", err)
+	}
+
+	// The first attempt runs right away, but outside of the caller's request
+	err = a.client.SetAlarm(ctx, backchannelLogoutAlarmDeliver, actor.AlarmProperties{DueTime: time.Now()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set backchannel logout delivery alarm: %w", err)
+	}
+
+	return nil, nil
+}
+
+// Alarm implements actor.ActorAlarm
+func (a *backchannelLogoutActor) Alarm(ctx context.Context, name string, _ actor.Envelope) error {
+	if name != backchannelLogoutAlarmDeliver {
+		return fmt.Errorf("unsupported alarm '%s' for the %s actor", name, backchannelLogoutActorType)
+	}
+
+	state, err := a.client.GetState(ctx)
+	if errors.Is(err, actor.ErrStateNotFound) {
+		// The state expired before the alarm fired, so there is nothing left to deliver
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load backchannel logout actor state: %w", err)
+	}
+
+	retry, err := a.delivery.deliver(ctx, state)
+	if err == nil || !retry || state.Attempts >= len(backchannelLogoutRetryDelays) {
+		if err != nil {
+			a.log.WarnContext(ctx, "Giving up on backchannel logout delivery", slog.String("client_id", state.ClientID), slog.Int("attempts", state.Attempts+1), slog.Any("error", err))
+		}
+		return a.client.DeleteState(ctx)
+	}
+
+	delay := backchannelLogoutRetryDelays[state.Attempts]
+	a.log.InfoContext(ctx, "Backchannel logout delivery failed, will retry", slog.String("client_id", state.ClientID), slog.Duration("delay", delay), slog.Any("error", err))
+
+	state.Attempts++
+	err = a.client.SetState(ctx, state, &actor.SetStateOpts{TTL: backchannelLogoutStateTTL})
+	if err != nil {
+		return fmt.Errorf("failed to persist backchannel logout actor state: %w", err)
+	}
+
+	// Errors are not returned to Francis for retries, as the backoff here is much longer than the alarm's own retry policy
+	return a.client.SetAlarm(ctx, backchannelLogoutAlarmDeliver, actor.AlarmProperties{DueTime: time.Now().Add(delay)})
+}
+
+// deliver POSTs a freshly signed logout token to the client, and reports whether a failed attempt is worth retrying
+func (d *backchannelLogoutDelivery) deliver(parentCtx context.Context, state backchannelLogoutActorState) (retry bool, err error) {
+	logoutToken, err := d.signLogoutToken(state)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, backchannelLogoutRequestTimeout)
+	defer cancel()
+
+	body := url.Values{"logout_token": {logoutToken}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, state.LogoutURI, strings.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create backchannel logout request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cache-Control", "no-store")
+
+	httpClient := d.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if state.PublicOnly {
+		httpClient = utils.PublicOnlyHTTPClient(httpClient)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		// The logout URI won't stop pointing to a private address by sending the token again
+		retry = !errors.Is(err, utils.ErrPrivateURL)
+		return retry, fmt.Errorf("failed to send backchannel logout request: %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	// A 4xx response means the client rejected the logout token, which won't change by sending it again
+	retry = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("client responded to the backchannel logout request with status %d", res.StatusCode)
+}
+
+// signLogoutToken builds and signs a logout token as defined by OpenID Connect Back-Channel Logout 1.0 section 2.4
+func (d *backchannelLogoutDelivery) signLogoutToken(state backchannelLogoutActorState) (string, error) {
+	now := time.Now()
+	builder := jwt.NewBuilder().
+		Issuer(d.issuer).
+		Audience([]string{state.ClientID}).
+		IssuedAt(now).
+		Expiration(now.Add(backchannelLogoutTokenLifetime)).
+		JwtID(uuid.New().String()).
+		Claim("events", map[string]any{backchannelLogoutEvent: map[string]any{}})
+	if state.Subject != "" {
+		builder = builder.Subject(state.Subject)
+	}
+	if state.SessionID != "" {
+		builder = builder.Claim("sid", state.SessionID)
+	}
+	token, err := builder.Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build logout token: %w", err)
+	}
+
+	alg, err := registeredSigningAlgorithm(d.signer, state.SigningAlg)
+	if err != nil {
+		return "", fmt.Errorf("failed to get signing algorithm: %w", err)
+	}
+
+	headers := jws.NewHeaders()
+	err = headers.Set(jws.TypeKey, backchannelLogoutTokenType)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signJWTWithHeaders(d.signer, alg, token, headers)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign logout token: %w", err)
+	}
+
+	return signed, nil
+}
@@ -0,0 +1,212 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/italypaleale/francis/host/local"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+// logoutTokenReceiver is a relying party backchannel logout endpoint that records the logout tokens it receives
+type logoutTokenReceiver struct {
+	mu     sync.Mutex
+	tokens []string
+	status int
+}
+
+func (r *logoutTokenReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = append(r.tokens, req.PostFormValue("logout_token"))
+	w.WriteHeader(r.status)
+}
+
+func (r *logoutTokenReceiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.tokens...)
+}
+
+func newBackchannelLogoutDeliveryForTest(t *testing.T) (*backchannelLogoutDelivery, *ecdsa.PrivateKey) {
+	t.Helper()
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return &backchannelLogoutDelivery{
+		signer: testTokenSigner{key: signerKey},
+		issuer: "https://issuer.example.com",
+	}, signerKey
+}
+
+func TestBackchannelLogoutTokenClaims(t *testing.T) {
+	delivery, signerKey := newBackchannelLogoutDeliveryForTest(t)
+
+	logoutToken, err := delivery.signLogoutToken(backchannelLogoutActorState{
+		ClientID: "test-client",
+		Subject:  "test-user",
+	})
+	require.NoError(t, err)
+
+	token, err := jwt.ParseString(logoutToken, jwt.WithKey(jwa.ES256(), &signerKey.PublicKey), jwt.WithValidate(true))
+	require.NoError(t, err)
+
+	issuer, _ := token.Issuer()
+	require.Equal(t, "https://issuer.example.com", issuer)
+	audience, _ := token.Audience()
+	require.Equal(t, []string{"test-client"}, audience)
+	subject, _ := token.Subject()
+	require.Equal(t, "test-user", subject)
+	jti, _ := token.JwtID()
+	require.NotEmpty(t, jti)
+
+	var events map[string]any
+	require.NoError(t, token.Get("events", &events))
+	require.Contains(t, events, backchannelLogoutEvent)
+
+	// A logout token must never carry a nonce, so it can't be mistaken for an ID token
+	require.False(t, token.Has("nonce"))
+
+	msg, err := jws.Parse([]byte(logoutToken))
+	require.NoError(t, err)
+	typ, _ := msg.Signatures()[0].ProtectedHeaders().Type()
+	require.Equal(t, backchannelLogoutTokenType, typ)
+}
+
+func TestBackchannelLogoutDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		expectError bool
+		expectRetry bool
+	}{
+		{name: "accepted", status: http.StatusOK},
+		{name: "rejected by the client", status: http.StatusBadRequest, expectError: true},
+		{name: "client unavailable", status: http.StatusServiceUnavailable, expectError: true, expectRetry: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &logoutTokenReceiver{status: tt.status}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			delivery, _ := newBackchannelLogoutDeliveryForTest(t)
+			retry, err := delivery.deliver(t.Context(), backchannelLogoutActorState{
+				ClientID:  "test-client",
+				LogoutURI: server.URL,
+				Subject:   "test-user",
+			})
+			if tt.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.expectRetry, retry)
+			require.Len(t, receiver.received(), 1)
+		})
+	}
+}
+
+func TestBackchannelLogoutDeliveryRefusesPrivateAddressesOfSelfRegisteredClients(t *testing.T) {
+	receiver := &logoutTokenReceiver{status: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	delivery, _ := newBackchannelLogoutDeliveryForTest(t)
+	retry, err := delivery.deliver(t.Context(), backchannelLogoutActorState{
+		ClientID:   "test-client",
+		LogoutURI:  server.URL,
+		Subject:    "test-user",
+		PublicOnly: true,
+	})
+	require.ErrorIs(t, err, utils.ErrPrivateURL)
+	require.False(t, retry)
+	require.Empty(t, receiver.received())
+}
+
+func TestBackchannelLogoutNotifiesAuthorizedClients(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	receiver := &logoutTokenReceiver{status: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	user := model.User{Base: model.Base{ID: "test-user"}}
+	require.NoError(t, db.Create(&user).Error)
+	for _, client := range []model.OidcClient{
+		{Base: model.Base{ID: "with-logout-uri"}, Name: "With logout URI", BackchannelLogoutURI: new(server.URL)},
+		{Base: model.Base{ID: "without-logout-uri"}, Name: "Without logout URI"},
+		{Base: model.Base{ID: "not-authorized"}, Name: "Not authorized", BackchannelLogoutURI: new(server.URL)},
+	} {
+		require.NoError(t, db.Create(&client).Error)
+	}
+	for _, clientID := range []string{"with-logout-uri", "without-logout-uri"} {
+		require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: user.ID, ClientID: clientID}).Error)
+	}
+
+	delivery, _ := newBackchannelLogoutDeliveryForTest(t)
+	delivery.httpClient = server.Client()
+	host := testutils.NewActorHostForTest(t, func(t *testing.T, host *local.Host) {
+		require.NoError(t, host.RegisterActor(backchannelLogoutActorType, newBackchannelLogoutActor(delivery)))
+	})
+	service := newBackchannelLogoutService(db, host.Service(), nil)
+
+	require.NoError(t, service.notifyUserLogout(t.Context(), user.ID, "test-session"))
+
+	require.Eventually(t, func() bool {
+		return len(receiver.received()) == 1
+	}, 10*time.Second, 20*time.Millisecond)
+
+	token, err := jwt.ParseInsecure([]byte(receiver.received()[0]))
+	require.NoError(t, err)
+	audience, _ := token.Audience()
+	require.Equal(t, []string{"with-logout-uri"}, audience)
+	var sid string
+	require.NoError(t, token.Get("sid", &sid))
+	require.Equal(t, "test-session", sid)
+
+	// Revoking a single client notifies it even though the authorization no longer exists
+	require.NoError(t, service.notifyClientLogout(t.Context(), user.ID, "not-authorized"))
+	require.Eventually(t, func() bool {
+		return len(receiver.received()) == 2
+	}, 10*time.Second, 20*time.Millisecond)
+
+	// Revoking a client the user has sessions with sends a logout token for each of them, even though the revocation has already ended them
+	sessionRequired := model.OidcClient{Base: model.Base{ID: "session-required"}, Name: "Session required", BackchannelLogoutURI: new(server.URL), BackchannelLogoutSessionRequired: true}
+	require.NoError(t, db.Create(&sessionRequired).Error)
+	store := NewStore(db, nil)
+	for _, sessionID := range []string{"session-a", "session-b", "session-a"} {
+		requester := newTestRequester("request-"+uuid.NewString(), sessionRequired.ID, user.ID, "")
+		requester.GetSession().(*Session).SessionID = sessionID
+		require.NoError(t, store.CreateRefreshTokenSession(t.Context(), "refresh-"+uuid.NewString(), "", requester))
+	}
+	require.NoError(t, RevokeUserClientSessions(t.Context(), db, user.ID, sessionRequired.ID))
+	require.NoError(t, service.notifyClientLogout(t.Context(), user.ID, sessionRequired.ID))
+
+	require.Eventually(t, func() bool {
+		return len(receiver.received()) == 4
+	}, 10*time.Second, 20*time.Millisecond)
+	sessionIDs := make([]string, 0, 2)
+	for _, received := range receiver.received()[2:] {
+		token, err := jwt.ParseInsecure([]byte(received))
+		require.NoError(t, err)
+		require.NoError(t, token.Get("sid", &sid))
+		sessionIDs = append(sessionIDs, sid)
+	}
+	require.ElementsMatch(t, []string{"session-a", "session-b"}, sessionIDs)
+}
@@ -0,0 +1,499 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// grantTypeCIBA is the grant type a client fetches the tokens of a backchannel authentication request with, as defined by OpenID Connect CIBA Core 1.0 section 10.1
+const grantTypeCIBA fosite.GrantType = "urn:openid:params:grant-type:ciba"
+
+const (
+	// cibaDefaultRequestLifetime is how long the user has to decide when the client doesn't ask for a requested_expiry
+	cibaDefaultRequestLifetime = 5 * time.Minute
+	// cibaMaxRequestLifetime caps the requested_expiry a client can ask for
+	cibaMaxRequestLifetime = 30 * time.Minute
+	// cibaPollingInterval is the minimum time a polling client must wait between two token requests
+	cibaPollingInterval = 5 * time.Second
+	// cibaAuthReqIDLength is the length of the random auth_req_id handed to the client
+	cibaAuthReqIDLength = 40
+	// cibaMaxBindingMessageLength limits the binding message, which must fit on the screen next to the request
+	cibaMaxBindingMessageLength = 100
+	// cibaMaxNotificationTokenLength limits the bearer token a ping mode client is notified with
+	cibaMaxNotificationTokenLength = 1024
+)
+
+var (
+	errCIBAUnknownUserID = &fosite.RFC6749Error{
+		ErrorField:       "unknown_user_id",
+		DescriptionField: "The user identified by the hint is not known.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errCIBAInvalidBindingMessage = &fosite.RFC6749Error{
+		ErrorField:       "invalid_binding_message",
+		DescriptionField: "The binding message is invalid or unacceptable.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errCIBAExpiredToken = &fosite.RFC6749Error{
+		ErrorField:       "expired_token",
+		DescriptionField: "The auth_req_id has expired, the client must start a new backchannel authentication request.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// BackchannelTokenDeliveryModesSupported returns the CIBA token delivery modes, for the backchannel_token_delivery_modes_supported server metadata
+// The push mode is not supported, as it would make Pocket ID deliver the tokens themselves to the client
+func BackchannelTokenDeliveryModesSupported() []string {
+	return []string{string(model.OidcClientBackchannelTokenDeliveryModePoll), string(model.OidcClientBackchannelTokenDeliveryModePing)}
+}
+
+// cibaService implements OpenID Connect Client-Initiated Backchannel Authentication 1.0
+// A client starts the authentication of a user it identifies with a hint, the user approves or denies it in Pocket ID, and the client then fetches the tokens at the token endpoint
+type cibaService struct {
+	authenticateClient   fosite.ClientAuthenticationStrategy
+	store                *Store
+	authorizationService *authorizationService
+	claimsService        *ClaimsService
+	notifications        *cibaNotificationService
+	signer               TokenSigner
+	issuer               string
+	auditLog             AuditLogger
+	db                   *gorm.DB
+}
+
+func newCIBAService(
+	authenticateClient fosite.ClientAuthenticationStrategy,
+	store *Store,
+	authorizationService *authorizationService,
+	claimsService *ClaimsService,
+	notifications *cibaNotificationService,
+	signer TokenSigner,
+	issuer string,
+	auditLog AuditLogger,
+	db *gorm.DB,
+) *cibaService {
+	return &cibaService{
+		authenticateClient:   authenticateClient,
+		store:                store,
+		authorizationService: authorizationService,
+		claimsService:        claimsService,
+		notifications:        notifications,
+		signer:               signer,
+		issuer:               issuer,
+		auditLog:             auditLog,
+		db:                   db,
+	}
+}
+
+// createAuthenticationRequest handles a backchannel authentication request, as defined by CIBA Core section 7
+func (s *cibaService) createAuthenticationRequest(ctx context.Context, r *http.Request) (*dto.OidcBackchannelAuthenticationResponseDto, error) {
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, fosite.ErrInvalidRequest.WithHint("Unable to parse HTTP body, make sure to send a properly formatted form request body.").WithWrap(err)
+	}
+	form := r.PostForm
+
+	authenticatedClient, err := s.authenticateClient(ctx, r, form)
+	if err != nil {
+		return nil, err
+	}
+	client, ok := authenticatedClient.(Client)
+	if !ok || !client.GetGrantTypes().Has(string(grantTypeCIBA)) {
+		return nil, fosite.ErrUnauthorizedClient.WithHint("The OAuth 2.0 Client is not allowed to use backchannel authentication.")
+	}
+
+	scopes := fosite.RemoveEmpty(strings.Split(form.Get("scope"), " "))
+	if !slices.Contains(scopes, "openid") {
+		return nil, fosite.ErrInvalidScope.WithHint("The 'openid' scope is required for backchannel authentication.")
+	}
+
+	bindingMessage := form.Get("binding_message")
+	if utf8.RuneCountInString(bindingMessage) > cibaMaxBindingMessageLength {
+		return nil, errCIBAInvalidBindingMessage.WithHintf("The binding message must not be longer than %d characters.", cibaMaxBindingMessageLength)
+	}
+
+	lifetime, err := cibaRequestLifetime(form.Get("requested_expiry"))
+	if err != nil {
+		return nil, err
+	}
+
+	// In ping mode the client is notified with its own bearer token, which tells it the notification comes from Pocket ID
+	notificationToken := form.Get("client_notification_token")
+	if client.BackchannelTokenDeliveryMode == model.OidcClientBackchannelTokenDeliveryModePing {
+		if notificationToken == "" {
+			return nil, fosite.ErrInvalidRequest.WithHint("The 'client_notification_token' parameter is required in ping mode.")
+		}
+		if len(notificationToken) > cibaMaxNotificationTokenLength {
+			return nil, fosite.ErrInvalidRequest.WithHintf("The 'client_notification_token' parameter must not be longer than %d characters.", cibaMaxNotificationTokenLength)
+		}
+	}
+
+	user, err := s.resolveHintedUser(ctx, client, form)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled || !IsUserGroupAllowedToAuthorize(user, client.OidcClient) {
+		return nil, fosite.ErrAccessDenied.WithHint("The user is not allowed to access this service.")
+	}
+
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Form = form
+	request.RequestedScope = scopes
+	request.Session = NewEmptySession()
+
+	// Validate the requested scopes and resolve the resource indicator to an audience and the subset of requested scopes that may be granted
+	resource, err := request.GetResource()
+	if err != nil {
+		return nil, err
+	}
+	// The requested claims are only validated here, they're released when the user approves the request
+	_, err = parseClaimsRequest(form.Get("claims"))
+	if err != nil {
+		return nil, err
+	}
+	audience, grantedScopes, _, err := s.authorizationService.resolveGrant(ctx, client.GetID(), resource, scopes, nil)
+	if err != nil {
+		if resource != "" && errors.Is(err, fosite.ErrAccessDenied) {
+			return nil, fosite.ErrInvalidTarget.WithHintf("The requested resource '%s' is invalid, missing, unknown, or malformed.", resource)
+		}
+		return nil, err
+	}
+	grantResourceIndicator(request, audience, grantedScopes)
+
+	requestData, err := s.store.encodeRequester(request)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	authReqID, err := utils.GenerateRandomAlphanumericString(cibaAuthReqIDLength)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	cibaRequest := CIBARequest{
+		AuthReqIDSignature: utils.CreateSha256Hash(authReqID),
+		ClientID:           client.GetID(),
+		UserID:             user.ID,
+		Scopes:             scopes,
+		Resource:           resource,
+		Status:             cibaRequestStatusPending,
+		PollingInterval:    int(cibaPollingInterval.Seconds()),
+		ExpiresAt:          datatype.DateTime(time.Now().Add(lifetime)),
+		RequestData:        requestData,
+	}
+	if bindingMessage != "" {
+		cibaRequest.BindingMessage = &bindingMessage
+	}
+	err = s.db.WithContext(ctx).Create(&cibaRequest).Error
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if client.BackchannelTokenDeliveryMode == model.OidcClientBackchannelTokenDeliveryModePing {
+		err = s.notifications.register(ctx, cibaRequest.ID, cibaNotificationActorState{
+			ClientID:          client.GetID(),
+			Endpoint:          *client.BackchannelClientNotificationEndpoint,
+			NotificationToken: notificationToken,
+			AuthReqID:         authReqID,
+			ExpiresAt:         cibaRequest.ExpiresAt.ToTime(),
+			PublicOnly:        client.IsSelfRegistered(),
+		})
+		if err != nil {
+			return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+	}
+
+	response := &dto.OidcBackchannelAuthenticationResponseDto{
+		AuthReqID: authReqID,
+		ExpiresIn: int(lifetime.Seconds()),
+	}
+	// A ping mode client only polls if it missed the notification, so the interval is only a hint for poll mode clients
+	if client.BackchannelTokenDeliveryMode == model.OidcClientBackchannelTokenDeliveryModePoll {
+		response.Interval = cibaRequest.PollingInterval
+	}
+	return response, nil
+}
+
+// cibaRequestLifetime returns how long the user has to decide, which the client may shorten or lengthen with requested_expiry
+func cibaRequestLifetime(requestedExpiry string) (time.Duration, error) {
+	if requestedExpiry == "" {
+		return cibaDefaultRequestLifetime, nil
+	}
+
+	seconds, err := strconv.Atoi(requestedExpiry)
+	if err != nil || seconds <= 0 {
+		return 0, fosite.ErrInvalidRequest.WithHint("The 'requested_expiry' parameter must be a positive number of seconds.")
+	}
+	return min(time.Duration(seconds)*time.Second, cibaMaxRequestLifetime), nil
+}
+
+// resolveHintedUser returns the user the client identified with exactly one of the hints of CIBA Core section 7.1
+func (s *cibaService) resolveHintedUser(ctx context.Context, client Client, form url.Values) (model.User, error) {
+	loginHint := form.Get("login_hint")
+	idTokenHint := form.Get("id_token_hint")
+	loginHintToken := form.Get("login_hint_token")
+
+	hints := 0
+	for _, hint := range []string{loginHint, idTokenHint, loginHintToken} {
+		if hint != "" {
+			hints++
+		}
+	}
+	if hints != 1 {
+		return model.User{}, fosite.ErrInvalidRequest.WithHint("Exactly one of 'login_hint', 'id_token_hint' or 'login_hint_token' is required.")
+	}
+	if loginHintToken != "" {
+		return model.User{}, fosite.ErrInvalidRequest.WithHint("The 'login_hint_token' parameter is not supported, use 'login_hint' or 'id_token_hint' instead.")
+	}
+
+	query := s.db.
+		WithContext(ctx).
+		Preload("UserGroups")
+
+	if idTokenHint != "" {
+		token, err := verifyIDTokenHint(s.signer, s.issuer, idTokenHint)
+		if err != nil {
+			return model.User{}, fosite.ErrInvalidRequest.WithHint("The 'id_token_hint' parameter is not a valid ID token.").WithWrap(err)
+		}
+		// The ID token must have been issued to the client that passes it back
+		audience, _ := token.Audience()
+		subject, _ := token.Subject()
+		if !slices.Contains(audience, client.GetID()) || subject == "" {
+			return model.User{}, fosite.ErrInvalidRequest.WithHint("The 'id_token_hint' parameter was not issued to this client.")
+		}
+		// The subject is pairwise if the client is
+		userID, found, err := s.claimsService.subjects.userIDFor(ctx, s.db, client.OidcClient, subject)
+		if err != nil {
+			return model.User{}, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		if !found {
+			return model.User{}, errCIBAUnknownUserID
+		}
+		query = query.Where("id = ?", userID)
+	} else {
+		// The login hint is the username or the email address of the user
+		query = query.Where("username = ? OR email = ?", loginHint, loginHint)
+	}
+
+	var user model.User
+	err := query.First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.User{}, errCIBAUnknownUserID
+	}
+	if err != nil {
+		return model.User{}, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	return user, nil
+}
+
+// listPendingRequests returns the backchannel authentication requests waiting for the user's decision, newest first
+func (s *cibaService) listPendingRequests(ctx context.Context, userID string) ([]dto.BackchannelAuthenticationRequestDto, error) {
+	var cibaRequests []CIBARequest
+	err := s.db.
+		WithContext(ctx).
+		Preload("Client").
+		Where("user_id = ? AND status = ? AND expires_at > ?", userID, cibaRequestStatusPending, datatype.DateTime(time.Now())).
+		Order("created_at DESC").
+		Find(&cibaRequests).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]dto.BackchannelAuthenticationRequestDto, len(cibaRequests))
+	for i, cibaRequest := range cibaRequests {
+		scopeInfo, err := s.authorizationService.resolveScopeInfoForRequest(ctx, cibaRequest.Resource, cibaRequest.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		// Always serialize a possibly empty array rather than null
+		if scopeInfo == nil {
+			scopeInfo = []dto.ScopeInfoDto{}
+		}
+
+		requests[i] = dto.BackchannelAuthenticationRequestDto{
+			ID: cibaRequest.ID,
+			Client: dto.OidcClientMetaDataDto{
+				ID:                       cibaRequest.Client.ID,
+				Name:                     cibaRequest.Client.Name,
+				HasLogo:                  cibaRequest.Client.HasLogo(),
+				HasDarkLogo:              cibaRequest.Client.HasDarkLogo(),
+				LaunchURL:                cibaRequest.Client.LaunchURL,
+				RequiresReauthentication: true,
+				ClientType:               string(cibaRequest.Client.ClientType),
+			},
+			Scope:          cibaRequest.Scopes,
+			ScopeInfo:      scopeInfo,
+			BindingMessage: cibaRequest.BindingMessage,
+			CreatedAt:      cibaRequest.CreatedAt,
+			ExpiresAt:      cibaRequest.ExpiresAt,
+		}
+	}
+
+	return requests, nil
+}
+
+// approveRequest records the user's approval of a backchannel authentication request, after which the client can fetch its tokens
+// The user wasn't the one who started the request, so the approval always requires a fresh passkey reauthentication
+func (s *cibaService) approveRequest(ctx context.Context, id, userID, authenticationMethod, reauthenticationToken string, meta requestMeta) error {
+	if reauthenticationToken == "" || s.authorizationService.reauth == nil {
+		return apperror.ReauthenticationRequired()
+	}
+
+	var cibaRequest CIBARequest
+	err := withTx(ctx, s.db, func(ctx context.Context) error {
+		var err error
+		cibaRequest, err = s.pendingRequest(ctx, id, userID)
+		if err != nil {
+			return err
+		}
+
+		reauthenticatedAt, contextClass, err := s.authorizationService.reauth.ConsumeReauthenticationToken(ctx, dbFromContext(ctx, s.db), reauthenticationToken, userID)
+		if err != nil {
+			return err
+		}
+
+		request, err := s.store.decodeRequester(ctx, cibaRequest.RequestData)
+		if err != nil {
+			return err
+		}
+		client, ok := request.GetClient().(Client)
+		if !ok {
+			return errors.New("backchannel authentication request has an unexpected client type")
+		}
+
+		// The user's access is checked again, as their groups may have changed since the request was started
+		err = s.claimsService.ValidateUserAccess(ctx, userID, client)
+		if err != nil {
+			return err
+		}
+
+		// Backchannel requests can ask for an authentication context class with acr_values, like authorization requests
+		requiredContextClass, err := requiredAuthenticationContextClass(client.OidcClient, request.GetRequestForm().Get("acr_values"), "")
+		if err != nil {
+			return err
+		}
+		if !common.SatisfiesAuthenticationContext(contextClass, requiredContextClass) {
+			return apperror.InsufficientUserAuthentication()
+		}
+
+		session := NewAuthenticatedSession(userID, authenticationMethod, reauthenticatedAt, request.GetRequestedAt())
+		session.AuthenticationContextClass = contextClass
+		session.ClaimsRequest, err = parseClaimsRequest(request.GetRequestForm().Get("claims"))
+		if err != nil {
+			return err
+		}
+		err = s.claimsService.applyIDTokenClaims(ctx, session, client.OidcClient, request.GetGrantedScopes())
+		if err != nil {
+			return err
+		}
+		request.SetSession(session)
+
+		_, _, consentKeys, err := s.authorizationService.resolveGrant(ctx, client.GetID(), cibaRequest.Resource, request.GetRequestedScopes(), session.ClaimsRequest)
+		if err != nil {
+			return err
+		}
+		hasAlreadyAuthorizedClient, err := s.authorizationService.consent(ctx, userID, client.GetID(), consentKeys)
+		if err != nil {
+			return err
+		}
+
+		event := model.AuditLogEventBackchannelAuthorization
+		if !hasAlreadyAuthorizedClient {
+			event = model.AuditLogEventNewBackchannelAuthorization
+		}
+		if s.auditLog != nil {
+			s.auditLog.Create(ctx, event, meta.IPAddress, meta.UserAgent, userID, model.AuditLogData{"clientName": client.Name}, dbFromContext(ctx, s.db))
+		}
+
+		requestData, err := s.store.encodeRequester(request)
+		if err != nil {
+			return err
+		}
+		return s.decide(ctx, cibaRequest.ID, cibaRequestStatusApproved, requestData)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyClient(ctx, cibaRequest)
+	return nil
+}
+
+// denyRequest records that the user denied a backchannel authentication request
+func (s *cibaService) denyRequest(ctx context.Context, id, userID string) error {
+	var cibaRequest CIBARequest
+	err := withTx(ctx, s.db, func(ctx context.Context) error {
+		var err error
+		cibaRequest, err = s.pendingRequest(ctx, id, userID)
+		if err != nil {
+			return err
+		}
+		return s.decide(ctx, cibaRequest.ID, cibaRequestStatusDenied, cibaRequest.RequestData)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyClient(ctx, cibaRequest)
+	return nil
+}
+
+func (s *cibaService) pendingRequest(ctx context.Context, id, userID string) (CIBARequest, error) {
+	var cibaRequest CIBARequest
+	err := dbFromContext(ctx, s.db).
+		Preload("Client").
+		Where("id = ? AND user_id = ? AND status = ? AND expires_at > ?", id, userID, cibaRequestStatusPending, datatype.DateTime(time.Now())).
+		First(&cibaRequest).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return CIBARequest{}, apperror.NotFound("backchannel authentication request")
+	}
+	return cibaRequest, err
+}
+
+// decide moves a pending request to the user's decision, failing if it was already decided concurrently
+func (s *cibaService) decide(ctx context.Context, id string, status cibaRequestStatus, requestData string) error {
+	result := dbFromContext(ctx, s.db).
+		Model(&CIBARequest{}).
+		Where("id = ? AND status = ?", id, cibaRequestStatusPending).
+		Updates(map[string]any{
+			"status":       status,
+			"request_data": requestData,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperror.NotFound("backchannel authentication request")
+	}
+	return nil
+}
+
+// notifyClient pings a ping mode client once the user decided, so it can fetch the result at the token endpoint
+func (s *cibaService) notifyClient(ctx context.Context, cibaRequest CIBARequest) {
+	if cibaRequest.Client.BackchannelTokenDeliveryMode != model.OidcClientBackchannelTokenDeliveryModePing {
+		return
+	}
+	// A failed notification doesn't undo the decision: the client can still poll for the result
+	s.notifications.notify(ctx, cibaRequest.ID)
+}
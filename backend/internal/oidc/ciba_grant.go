@@ -0,0 +1,200 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ory/fosite"
+	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+	"gorm.io/gorm"
+
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// cibaGrantHandler implements the token request of CIBA Core section 10.1
+// The client presents the auth_req_id it received from the backchannel authentication endpoint; until the user decided, it's told to keep polling
+// Once approved, the request is consumed and the tokens are issued for the session the user approved it with
+type cibaGrantHandler struct {
+	coreStrategy fositeoauth2.CoreStrategy
+	idTokens     *openid.IDTokenHandleHelper
+	store        *Store
+	config       *fosite.Config
+}
+
+func newCIBAGrantHandler(coreStrategy fositeoauth2.CoreStrategy, idTokenStrategy openid.OpenIDConnectTokenStrategy, store *Store, config *fosite.Config) *cibaGrantHandler {
+	return &cibaGrantHandler{
+		coreStrategy: coreStrategy,
+		idTokens:     &openid.IDTokenHandleHelper{IDTokenStrategy: idTokenStrategy},
+		store:        store,
+		config:       config,
+	}
+}
+
+func (h *cibaGrantHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := requester.GetClient()
+	if !client.GetGrantTypes().Has(string(grantTypeCIBA)) {
+		return fosite.ErrUnauthorizedClient.WithHint("The OAuth 2.0 Client is not allowed to use the CIBA grant.")
+	}
+
+	authReqID := requester.GetRequestForm().Get("auth_req_id")
+	if authReqID == "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'auth_req_id' parameter is missing.")
+	}
+
+	db := h.store.dbFor(ctx)
+	var cibaRequest CIBARequest
+	err := db.
+		Where("auth_req_id_signature = ?", utils.CreateSha256Hash(authReqID)).
+		First(&cibaRequest).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && cibaRequest.ClientID != client.GetID()) {
+		return fosite.ErrInvalidGrant.WithHint("The auth_req_id is invalid.")
+	}
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	now := time.Now()
+	if now.After(cibaRequest.ExpiresAt.ToTime()) {
+		return errCIBAExpiredToken
+	}
+
+	switch cibaRequest.Status {
+	case cibaRequestStatusPending:
+		return h.poll(ctx, cibaRequest, now)
+	case cibaRequestStatusDenied:
+		// The client learned the outcome, so the request is no longer needed
+		err = db.Delete(&CIBARequest{}, "id = ?", cibaRequest.ID).Error
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		return fosite.ErrAccessDenied.WithHint("The user denied the backchannel authentication request.")
+	case cibaRequestStatusApproved:
+		return h.grant(ctx, requester, cibaRequest)
+	default:
+		return fosite.ErrServerError.WithDebugf("Unknown backchannel authentication request status '%s'.", cibaRequest.Status)
+	}
+}
+
+// poll tells the client the user hasn't decided yet, or that it polls too often, as defined by CIBA Core section 11
+func (h *cibaGrantHandler) poll(ctx context.Context, cibaRequest CIBARequest, now time.Time) error {
+	tooSoon := cibaRequest.LastPolledAt != nil &&
+		now.Before(cibaRequest.LastPolledAt.ToTime().Add(time.Duration(cibaRequest.PollingInterval)*time.Second))
+
+	err := h.store.dbFor(ctx).
+		Model(&CIBARequest{}).
+		Where("id = ?", cibaRequest.ID).
+		Update("last_polled_at", datatype.DateTime(now)).
+		Error
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if tooSoon {
+		return fosite.ErrSlowDown
+	}
+	return fosite.ErrAuthorizationPending
+}
+
+// grant consumes the approved request and restores what the user approved on the token request
+func (h *cibaGrantHandler) grant(ctx context.Context, requester fosite.AccessRequester, cibaRequest CIBARequest) error {
+	// Deleting the request only once makes the auth_req_id single-use even if the client sends concurrent token requests
+	result := h.store.dbFor(ctx).
+		Where("id = ? AND status = ?", cibaRequest.ID, cibaRequestStatusApproved).
+		Delete(&CIBARequest{})
+	if result.Error != nil {
+		return fosite.ErrServerError.WithWrap(result.Error).WithDebug(result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return fosite.ErrInvalidGrant.WithHint("The auth_req_id has already been used.")
+	}
+
+	approved, err := h.store.decodeRequester(ctx, cibaRequest.RequestData)
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	// The approved request's ID is kept, so all the tokens issued for it can later be revoked together
+	requester.SetID(approved.GetID())
+	requester.SetSession(approved.GetSession())
+	requester.SetRequestedScopes(approved.GetRequestedScopes())
+	requester.SetRequestedAudience(approved.GetRequestedAudience())
+	for _, scope := range approved.GetGrantedScopes() {
+		requester.GrantScope(scope)
+	}
+	for _, audience := range approved.GetGrantedAudience() {
+		requester.GrantAudience(audience)
+	}
+
+	client := requester.GetClient()
+	session := requester.GetSession()
+	now := time.Now().UTC()
+	session.SetExpiresAt(fosite.AccessToken, now.Add(fosite.GetEffectiveLifespan(client, grantTypeCIBA, fosite.AccessToken, h.config.GetAccessTokenLifespan(ctx))))
+	if h.canIssueRefreshToken(ctx, requester) {
+		session.SetExpiresAt(fosite.RefreshToken, now.Add(fosite.GetEffectiveLifespan(client, grantTypeCIBA, fosite.RefreshToken, h.config.GetRefreshTokenLifespan(ctx))))
+	}
+
+	return nil
+}
+
+func (h *cibaGrantHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	err := issueAccessToken(ctx, h.coreStrategy, h.store, requester, responder)
+	if err != nil {
+		return err
+	}
+
+	if h.canIssueRefreshToken(ctx, requester) {
+		refreshToken, refreshSignature, err := h.coreStrategy.GenerateRefreshToken(ctx, requester)
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		accessSignature := h.coreStrategy.AccessTokenSignature(ctx, responder.GetAccessToken())
+		err = h.store.CreateRefreshTokenSession(ctx, refreshSignature, accessSignature, requester.Sanitize([]string{}))
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		responder.SetExtra("refresh_token", refreshToken)
+	}
+
+	// The ID token is issued like for the authorization code grant, including the hash of the access token
+	if requester.GetGrantedScopes().Has("openid") {
+		if session, ok := requester.GetSession().(openid.Session); ok {
+			session.IDTokenClaims().AccessTokenHash = h.idTokens.GetAccessTokenHash(ctx, requester, responder)
+		}
+		lifespan := fosite.GetEffectiveLifespan(requester.GetClient(), grantTypeCIBA, fosite.IDToken, h.config.GetIDTokenLifespan(ctx))
+		err = h.idTokens.IssueExplicitIDToken(ctx, lifespan, requester, responder)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// canIssueRefreshToken applies the same rules as the other grants, so the client can refresh the tokens of an approved request
+func (h *cibaGrantHandler) canIssueRefreshToken(ctx context.Context, requester fosite.Requester) bool {
+	scopes := refreshTokenScopes(ctx, h.config)
+	if len(scopes) > 0 && !requester.GetGrantedScopes().HasOneOf(scopes...) {
+		return false
+	}
+	return requester.GetClient().GetGrantTypes().Has(string(fosite.GrantTypeRefreshToken))
+}
+
+func (h *cibaGrantHandler) CanSkipClientAuthentication(context.Context, fosite.AccessRequester) bool {
+	return false
+}
+
+func (h *cibaGrantHandler) CanHandleTokenEndpointRequest(_ context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(string(grantTypeCIBA))
+}
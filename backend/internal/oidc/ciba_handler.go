@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/cookie"
+)
+
+type cibaHandler struct {
+	provider    fosite.OAuth2Provider
+	cibaService *cibaService
+}
+
+func newCIBAHandler(provider fosite.OAuth2Provider, cibaService *cibaService) *cibaHandler {
+	return &cibaHandler{
+		provider:    provider,
+		cibaService: cibaService,
+	}
+}
+
+func (h *cibaHandler) backchannelAuthorize(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	response, err := h.cibaService.createAuthenticationRequest(ctx, c.Request)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create backchannel authentication request", "error", err)
+		h.provider.WriteAccessError(ctx, c.Writer, nil, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *cibaHandler) listPendingRequests(c *gin.Context) {
+	requests, err := h.cibaService.listPendingRequests(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func (h *cibaHandler) approveRequest(c *gin.Context) {
+	reauthenticationToken, _ := c.Cookie(cookie.ReauthenticationTokenCookieName)
+
+	err := h.cibaService.approveRequest(
+		c.Request.Context(),
+		c.Param("id"),
+		c.GetString("userID"),
+		c.GetString("authenticationMethod"),
+		reauthenticationToken,
+		requestMetaFromGin(c),
+	)
+	if err != nil {
+		if errors.Is(err, fosite.ErrAccessDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You're not allowed to access this service."})
+			return
+		}
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *cibaHandler) denyRequest(c *gin.Context) {
+	err := h.cibaService.denyRequest(c.Request.Context(), c.Param("id"), c.GetString("userID"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,225 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/italypaleale/francis/actor"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// A CIBA notification actor pings a ping mode client once the user decided on its backchannel authentication request, as defined by CIBA Core section 10.2
+// Each request is its own actor: it's registered with the notification details when the request is started, so the auth_req_id never has to be stored in the database, and notified once the user decided
+
+const (
+	cibaNotificationActorType = "oidc-ciba-notification"
+
+	cibaNotificationActorMethodRegister = "register"
+	cibaNotificationActorMethodNotify   = "notify"
+	cibaNotificationAlarmDeliver        = "deliver"
+
+	// cibaNotificationRequestTimeout bounds every delivery attempt
+	cibaNotificationRequestTimeout = 10 * time.Second
+)
+
+// cibaNotificationRetryDelays is the wait before each retry; the delivery is abandoned once they're exhausted or the request expired
+var cibaNotificationRetryDelays = []time.Duration{
+	5 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+}
+
+type cibaNotificationActorState struct {
+	ClientID          string
+	Endpoint          string
+	NotificationToken string
+	AuthReqID         string
+	ExpiresAt         time.Time
+	// PublicOnly restricts the notification to public IP addresses, for endpoints of self-registered clients
+	PublicOnly bool
+	Attempts   int
+}
+
+type cibaNotificationDelivery struct {
+	httpClient *http.Client
+}
+
+type cibaNotificationActor struct {
+	log      *slog.Logger
+	delivery *cibaNotificationDelivery
+	client   actor.Client[cibaNotificationActorState]
+}
+
+func newCIBANotificationActor(delivery *cibaNotificationDelivery) actor.Factory {
+	return func(actorID string, actorService *actor.Service) actor.Actor {
+		return &cibaNotificationActor{
+			log: slog.With(
+				slog.String("scope", "actor"),
+				slog.String("actorType", cibaNotificationActorType),
+				slog.String("actorID", actorID),
+			),
+			delivery: delivery,
+			client:   actor.NewActorClient[cibaNotificationActorState](cibaNotificationActorType, actorID, actorService),
+		}
+	}
+}
+
+// Invoke implements actor.ActorInvoke
+func (a *cibaNotificationActor) Invoke(ctx context.Context, method string, data actor.Envelope) (any, error) {
+	switch method {
+	case cibaNotificationActorMethodRegister:
+		return nil, a.register(ctx, data)
+	case cibaNotificationActorMethodNotify:
+		return nil, a.notify(ctx)
+	default:
+		return nil, common.ErrUnsupportedActorMethod{Method: method}
+	}
+}
+
+func (a *cibaNotificationActor) register(ctx context.Context, data actor.Envelope) error {
+	if data == nil {
+		return errors.New("CIBA notification actor input is missing")
+	}
+	var state cibaNotificationActorState
+	err := data.Decode(&state)
+	if err != nil {
+		return fmt.Errorf("failed to decode CIBA notification actor input: %w", err)
+	}
+
+	// The state expires with the request, as the client can't fetch anything after that
+	err = a.client.SetState(ctx, state, &actor.SetStateOpts{TTL: time.Until(state.ExpiresAt)})
+	if err != nil {
+		return fmt.Errorf("failed to persist CIBA notification actor state: %w", err)
+	}
+	return nil
+}
+
+func (a *cibaNotificationActor) notify(ctx context.Context) error {
+	_, err := a.client.GetState(ctx)
+	if errors.Is(err, actor.ErrStateNotFound) {
+		// The request expired, so there is nothing left to notify
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load CIBA notification actor state: %w", err)
+	}
+
+	// The notification is sent outside of the request of the user who decided
+	err = a.client.SetAlarm(ctx, cibaNotificationAlarmDeliver, actor.AlarmProperties{DueTime: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to set CIBA notification delivery alarm: %w", err)
+	}
+	return nil
+}
+
+// Alarm implements actor.ActorAlarm
+func (a *cibaNotificationActor) Alarm(ctx context.Context, name string, _ actor.Envelope) error {
+	if name != cibaNotificationAlarmDeliver {
+		return fmt.Errorf("unsupported alarm '%s' for the %s actor", name, cibaNotificationActorType)
+	}
+
+	state, err := a.client.GetState(ctx)
+	if errors.Is(err, actor.ErrStateNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load CIBA notification actor state: %w", err)
+	}
+
+	retry, err := a.delivery.deliver(ctx, state)
+	if err == nil || !retry || state.Attempts >= len(cibaNotificationRetryDelays) {
+		if err != nil {
+			a.log.WarnContext(ctx, "Giving up on CIBA notification delivery", slog.String("client_id", state.ClientID), slog.Int("attempts", state.Attempts+1), slog.Any("error", err))
+		}
+		return a.client.DeleteState(ctx)
+	}
+
+	delay := cibaNotificationRetryDelays[state.Attempts]
+	if time.Now().Add(delay).After(state.ExpiresAt) {
+		a.log.WarnContext(ctx, "Giving up on CIBA notification delivery, the request expires before the next attempt", slog.String("client_id", state.ClientID), slog.Any("error", err))
+		return a.client.DeleteState(ctx)
+	}
+	a.log.InfoContext(ctx, "CIBA notification delivery failed, will retry", slog.String("client_id", state.ClientID), slog.Duration("delay", delay), slog.Any("error", err))
+
+	state.Attempts++
+	err = a.client.SetState(ctx, state, &actor.SetStateOpts{TTL: time.Until(state.ExpiresAt)})
+	if err != nil {
+		return fmt.Errorf("failed to persist CIBA notification actor state: %w", err)
+	}
+
+	return a.client.SetAlarm(ctx, cibaNotificationAlarmDeliver, actor.AlarmProperties{DueTime: time.Now().Add(delay)})
+}
+
+// deliver POSTs the auth_req_id to the client's notification endpoint, and reports whether a failed attempt is worth retrying
+func (d *cibaNotificationDelivery) deliver(parentCtx context.Context, state cibaNotificationActorState) (retry bool, err error) {
+	body, err := json.Marshal(map[string]string{"auth_req_id": state.AuthReqID})
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, cibaNotificationRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, state.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create CIBA notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+state.NotificationToken)
+
+	httpClient := d.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if state.PublicOnly {
+		httpClient = utils.PublicOnlyHTTPClient(httpClient)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		retry = !errors.Is(err, utils.ErrPrivateURL)
+		return retry, fmt.Errorf("failed to send CIBA notification request: %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("client responded to the CIBA notification with status %d", res.StatusCode)
+}
+
+// cibaNotificationService registers and triggers the notifications of ping mode clients
+type cibaNotificationService struct {
+	actors *actor.Service
+}
+
+func newCIBANotificationService(actors *actor.Service) *cibaNotificationService {
+	return &cibaNotificationService{actors: actors}
+}
+
+func (s *cibaNotificationService) register(ctx context.Context, requestID string, state cibaNotificationActorState) error {
+	if s == nil || s.actors == nil {
+		return errors.New("the actor host is required to notify ping mode clients")
+	}
+	_, err := s.actors.Invoke(ctx, cibaNotificationActorType, requestID, cibaNotificationActorMethodRegister, state)
+	return err
+}
+
+func (s *cibaNotificationService) notify(ctx context.Context, requestID string) {
+	if s == nil || s.actors == nil {
+		return
+	}
+	_, err := s.actors.Invoke(ctx, cibaNotificationActorType, requestID, cibaNotificationActorMethodNotify, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to schedule CIBA notification", slog.String("request_id", requestID), slog.Any("error", err))
+	}
+}
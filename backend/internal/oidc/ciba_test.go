@@ -0,0 +1,220 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestCIBAPollFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL     = "https://issuer.example.com"
+		clientID    = "ciba-client"
+		clientPlain = "ciba-secret-value"
+		userID      = "ciba-user"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}, Username: "alice", Email: new("alice@example.com")}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:                         model.Base{ID: clientID},
+		Name:                         "CIBA Client",
+		Credentials:                  testClientCredentials(clientPlain),
+		BackchannelTokenDeliveryMode: model.OidcClientBackchannelTokenDeliveryModePoll,
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:        model.Base{ID: "other-client"},
+		Name:        "Other Client",
+		Credentials: testClientCredentials(clientPlain),
+	}).Error)
+
+	reauth := &fakeReauthenticationConsumer{
+		token:             testReauthenticationToken,
+		userID:            userID,
+		reauthenticatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	store := NewStore(db, nil)
+	signer := testTokenSigner{key: signingKey}
+	provider, err := newProvider(store, nil, signer, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+	claimsService := newClaimsService(db, nil, baseURL, nil)
+	auditLogger := &fakeAuditLogger{}
+	authorizationService := newAuthorizationService(db, newInteractionSessionService(db), claimsService, reauth, auditLogger, nil)
+	service := newCIBAService(provider.authenticateClient, store, authorizationService, claimsService, nil, signer, baseURL, auditLogger, db)
+	tokens := newTokenHandler(provider, claimsService, nil, newDPoPVerifier(store, []byte("test-secret"), baseURL), provider.tlsClientAuth, auditLogger, db)
+
+	startRequest := func(t *testing.T, clientID string, form url.Values) (string, error) {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/bc-authorize", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, clientPlain)
+
+		response, err := service.createAuthenticationRequest(t.Context(), req)
+		if err != nil {
+			return "", err
+		}
+		assert.Equal(t, int(cibaDefaultRequestLifetime.Seconds()), response.ExpiresIn)
+		assert.Equal(t, int(cibaPollingInterval.Seconds()), response.Interval)
+		return response.AuthReqID, nil
+	}
+
+	requestToken := func(t *testing.T, clientID, authReqID string) map[string]any {
+		t.Helper()
+		form := url.Values{
+			"grant_type":  {string(grantTypeCIBA)},
+			"auth_req_id": {authReqID},
+		}
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, tokenEndpointPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, clientPlain)
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		tokens.token(c)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	pendingRequestID := func(t *testing.T) string {
+		t.Helper()
+		requests, err := service.listPendingRequests(t.Context(), userID)
+		require.NoError(t, err)
+		require.NotEmpty(t, requests)
+		return requests[0].ID
+	}
+
+	t.Run("approved request issues tokens once", func(t *testing.T) {
+		authReqID, err := startRequest(t, clientID, url.Values{
+			"scope":           {"openid email"},
+			"login_hint":      {"alice@example.com"},
+			"binding_message": {"Order 1234"},
+		})
+		require.NoError(t, err)
+
+		requests, err := service.listPendingRequests(t.Context(), userID)
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		assert.Equal(t, clientID, requests[0].Client.ID)
+		assert.Equal(t, "Order 1234", *requests[0].BindingMessage)
+		assert.Equal(t, []string{"openid", "email"}, []string(requests[0].Scope))
+
+		body := requestToken(t, clientID, authReqID)
+		assert.Equal(t, "authorization_pending", body["error"])
+		body = requestToken(t, clientID, authReqID)
+		assert.Equal(t, "slow_down", body["error"])
+
+		err = service.approveRequest(t.Context(), requests[0].ID, userID, "phr", "", requestMeta{})
+		require.True(t, apperror.IsCode(err, apperror.CodeReauthenticationRequired))
+
+		err = service.approveRequest(t.Context(), requests[0].ID, userID, "phr", reauth.token, requestMeta{})
+		require.NoError(t, err)
+		require.Len(t, auditLogger.events, 1)
+		assert.Equal(t, model.AuditLogEventNewBackchannelAuthorization, auditLogger.events[0])
+
+		body = requestToken(t, clientID, authReqID)
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		require.NotEmpty(t, body["id_token"])
+
+		claims := decodeJWTPart(t, body["id_token"].(string), 1)
+		assert.Equal(t, userID, claims["sub"])
+		assert.Equal(t, []string{clientID}, jwtAudience(claims))
+		assert.NotEmpty(t, claims["at_hash"])
+
+		body = requestToken(t, clientID, authReqID)
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("denied request", func(t *testing.T) {
+		authReqID, err := startRequest(t, clientID, url.Values{"scope": {"openid"}, "login_hint": {"alice"}})
+		require.NoError(t, err)
+
+		require.NoError(t, service.denyRequest(t.Context(), pendingRequestID(t), userID))
+
+		body := requestToken(t, clientID, authReqID)
+		assert.Equal(t, "access_denied", body["error"])
+		body = requestToken(t, clientID, authReqID)
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("request of another user can't be decided", func(t *testing.T) {
+		_, err := startRequest(t, clientID, url.Values{"scope": {"openid"}, "login_hint": {"alice"}})
+		require.NoError(t, err)
+		id := pendingRequestID(t)
+
+		err = service.denyRequest(t.Context(), id, "other-user")
+		require.True(t, apperror.IsCode(err, apperror.CodeNotFound))
+
+		require.NoError(t, service.denyRequest(t.Context(), id, userID))
+	})
+
+	t.Run("auth_req_id of another client", func(t *testing.T) {
+		authReqID, err := startRequest(t, clientID, url.Values{"scope": {"openid"}, "login_hint": {"alice"}})
+		require.NoError(t, err)
+
+		body := requestToken(t, "other-client", authReqID)
+		assert.Equal(t, "unauthorized_client", body["error"])
+
+		require.NoError(t, service.denyRequest(t.Context(), pendingRequestID(t), userID))
+	})
+
+	t.Run("expired request", func(t *testing.T) {
+		authReqID, err := startRequest(t, clientID, url.Values{"scope": {"openid"}, "login_hint": {"alice"}})
+		require.NoError(t, err)
+		require.NoError(t, db.Model(&CIBARequest{}).
+			Where("status = ?", cibaRequestStatusPending).
+			Update("expires_at", datatype.DateTime(time.Now().Add(-time.Minute))).
+			Error)
+
+		body := requestToken(t, clientID, authReqID)
+		assert.Equal(t, "expired_token", body["error"])
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, err := startRequest(t, clientID, url.Values{"scope": {"openid"}, "login_hint": {"nobody"}})
+		require.ErrorIs(t, err, errCIBAUnknownUserID)
+	})
+
+	t.Run("request without a hint", func(t *testing.T) {
+		_, err := startRequest(t, clientID, url.Values{"scope": {"openid"}})
+		require.ErrorIs(t, err, fosite.ErrInvalidRequest)
+	})
+
+	t.Run("request without the openid scope", func(t *testing.T) {
+		_, err := startRequest(t, clientID, url.Values{"scope": {"email"}, "login_hint": {"alice"}})
+		require.ErrorIs(t, err, fosite.ErrInvalidScope)
+	})
+
+	t.Run("client without a token delivery mode", func(t *testing.T) {
+		_, err := startRequest(t, "other-client", url.Values{"scope": {"openid"}, "login_hint": {"alice"}})
+		require.ErrorIs(t, err, fosite.ErrUnauthorizedClient)
+	})
+}
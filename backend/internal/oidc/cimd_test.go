@@ -602,6 +602,14 @@ func TestClient_DeclaredCapabilitiesAreEnforced(t *testing.T) {
 		}}
 		assert.Contains(t, client.GetGrantTypes(), "refresh_token")
 	})
+
+	t.Run("dynamically registered clients are restricted to their declaration", func(t *testing.T) {
+		client := Client{OidcClient: model.OidcClient{
+			ClientType:         model.OidcClientTypeDynamic,
+			MetadataGrantTypes: datatype.StringList{"authorization_code", "client_credentials"},
+		}}
+		assert.Equal(t, fosite.Arguments{"authorization_code", "client_credentials"}, client.GetGrantTypes())
+	})
 }
 
 func TestBuildClientFromMetadata_RecordsDeclaredCapabilities(t *testing.T) {
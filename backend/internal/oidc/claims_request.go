@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+
+	"github.com/ory/fosite"
+)
+
+// ClaimsRequest is the claims parameter of an authorization request, see OpenID Connect Core section 5.5
+// It lets clients request individual claims, separately for the ID token and the userinfo response
+type ClaimsRequest struct {
+	UserInfo RequestedClaims `json:"userinfo,omitempty"`
+	IDToken  RequestedClaims `json:"id_token,omitempty"`
+}
+
+// RequestedClaims maps the names of individually requested claims to their constraints
+// A claim requested in the default manner has no constraints, so its value is nil
+type RequestedClaims map[string]*RequestedClaim
+
+// RequestedClaim is the constraints a client put on a requested claim
+type RequestedClaim struct {
+	Essential bool  `json:"essential,omitempty"`
+	Value     any   `json:"value,omitempty"`
+	Values    []any `json:"values,omitempty"`
+}
+
+// scopeClaims are the claims released with each standard scope
+// Custom claims are released with the profile scope, see claimScope
+var scopeClaims = map[string][]string{
+	"profile": {"given_name", "family_name", "name", "display_name", "preferred_username", "picture"},
+	"email":   {"email", "email_verified"},
+	"groups":  {"groups"},
+}
+
+// authenticationClaims describe the authentication rather than the user, so they're released in every ID token and can't be requested individually
+var authenticationClaims = []string{"sub", "auth_time", "acr", "amr", "sid", "nonce"}
+
+// parseClaimsRequest parses the claims parameter, which is empty if the client didn't send one
+func parseClaimsRequest(claims string) (*ClaimsRequest, error) {
+	if claims == "" {
+		return nil, nil
+	}
+
+	var request ClaimsRequest
+	err := json.Unmarshal([]byte(claims), &request)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'claims' parameter is not a valid JSON object.").WithWrap(err)
+	}
+	return &request, nil
+}
+
+// idTokenClaims returns the claims requested for the ID token, and is safe to call on a nil request
+func (r *ClaimsRequest) idTokenClaims() RequestedClaims {
+	if r == nil {
+		return nil
+	}
+	return r.IDToken
+}
+
+// userInfoClaims returns the claims requested for the userinfo response, and is safe to call on a nil request
+func (r *ClaimsRequest) userInfoClaims() RequestedClaims {
+	if r == nil {
+		return nil
+	}
+	return r.UserInfo
+}
+
+// userClaimNames returns the names of the requested user claims, for both the ID token and the userinfo response
+func (r *ClaimsRequest) userClaimNames() []string {
+	if r == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(r.IDToken)+len(r.UserInfo))
+	for _, requested := range []RequestedClaims{r.IDToken, r.UserInfo} {
+		for name := range requested {
+			if !slices.Contains(authenticationClaims, name) && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// has reports whether the claim was requested individually
+func (r RequestedClaims) has(name string) bool {
+	_, ok := r[name]
+	return ok
+}
+
+// allows reports whether a claim may be released with the given value
+// A claim requested with a value or values is only released if it has one of them
+func (r RequestedClaims) allows(name string, value any) bool {
+	requested := r[name]
+	if requested == nil || (requested.Value == nil && len(requested.Values) == 0) {
+		return true
+	}
+
+	return slices.ContainsFunc(requested.acceptedValues(), func(accepted any) bool {
+		return claimValuesEqual(accepted, value)
+	})
+}
+
+// acceptedValues returns the values the claim was requested with
+func (r *RequestedClaim) acceptedValues() []any {
+	if r.Value != nil {
+		return append([]any{r.Value}, r.Values...)
+	}
+	return r.Values
+}
+
+// acceptedStrings returns the string values the claim was requested with, ignoring values of other types
+func (r *RequestedClaim) acceptedStrings() []string {
+	values := make([]string, 0, len(r.Values)+1)
+	for _, value := range r.acceptedValues() {
+		if value, ok := value.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// claimValuesEqual compares a requested value, as decoded from JSON, with the value of a claim by their JSON encoding
+func claimValuesEqual(requested, actual any) bool {
+	requestedJSON, err := json.Marshal(requested)
+	if err != nil {
+		return false
+	}
+	actualJSON, err := json.Marshal(actual)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(requestedJSON, actualJSON)
+}
+
+// claimScope returns the standard scope a user claim is released with
+// Custom claims don't belong to a standard scope but are released with the profile scope, like the standard profile claims
+func claimScope(name string) string {
+	for scope, claims := range scopeClaims {
+		if slices.Contains(claims, name) {
+			return scope
+		}
+	}
+	return "profile"
+}
+
+// consentClaimKey is the consent key of a claim requested individually, without the scope it's released with
+// The empty audience before the unit separator keeps it apart from the keys of API scopes and audiences, as audiences are never empty
+func consentClaimKey(name string) string {
+	return "\x1f" + name
+}
+
+// claimConsentKeys returns the consent keys of the claims requested individually that the granted scopes don't release already
+func claimConsentKeys(request *ClaimsRequest, grantedScopes []string) []string {
+	if !slices.Contains(grantedScopes, "openid") {
+		return nil
+	}
+
+	var keys []string
+	for _, name := range request.userClaimNames() {
+		if !slices.Contains(grantedScopes, claimScope(name)) {
+			keys = append(keys, consentClaimKey(name))
+		}
+	}
+	return keys
+}
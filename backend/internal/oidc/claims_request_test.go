@@ -0,0 +1,53 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClaimsRequest(t *testing.T) {
+	request, err := parseClaimsRequest("")
+	require.NoError(t, err)
+	require.Nil(t, request)
+
+	request, err = parseClaimsRequest(`{"id_token":{"email":null,"department":{"essential":true}},"userinfo":{"groups":{"values":["admins"]}}}`)
+	require.NoError(t, err)
+	require.True(t, request.idTokenClaims().has("email"))
+	require.True(t, request.IDToken["department"].Essential)
+	require.Equal(t, []any{"admins"}, request.UserInfo["groups"].Values)
+	require.Equal(t, []string{"department", "email", "groups"}, request.userClaimNames())
+
+	_, err = parseClaimsRequest(`["email"]`)
+	require.ErrorIs(t, err, fosite.ErrInvalidRequest)
+}
+
+func TestRequestedClaimsAllows(t *testing.T) {
+	requested := RequestedClaims{
+		"email":          nil,
+		"department":     {Value: "engineering"},
+		"email_verified": {Values: []any{true}},
+	}
+
+	require.True(t, requested.allows("email", "tim@example.com"))
+	require.True(t, requested.allows("name", "Tim Cook"))
+	require.True(t, requested.allows("department", "engineering"))
+	require.False(t, requested.allows("department", "sales"))
+	require.True(t, requested.allows("email_verified", true))
+	require.False(t, requested.allows("email_verified", false))
+}
+
+func TestClaimConsentKeys(t *testing.T) {
+	request := &ClaimsRequest{
+		IDToken:  RequestedClaims{"email": nil, "auth_time": {Essential: true}},
+		UserInfo: RequestedClaims{"given_name": nil, "department": nil},
+	}
+
+	// Claims released by a granted scope don't need their own consent, and the authentication claims never do
+	require.Equal(t, []string{consentClaimKey("department"), consentClaimKey("given_name")}, claimConsentKeys(request, []string{"openid", "email"}))
+	require.Empty(t, claimConsentKeys(request, []string{"openid", "email", "profile"}))
+	// Without the openid scope, no claims are released at all
+	require.Empty(t, claimConsentKeys(request, []string{"email"}))
+	require.Empty(t, claimConsentKeys(nil, []string{"openid"}))
+}
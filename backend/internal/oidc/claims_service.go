@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"maps"
 	"slices"
 
 	"github.com/ory/fosite"
+	fositejwt "github.com/ory/fosite/token/jwt"
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"gorm.io/gorm"
@@ -21,6 +23,7 @@ type ClaimsService struct {
 	customClaims CustomClaimSource
 	baseURL      string
 	signer       TokenSigner
+	subjects     *pairwiseSubjects
 }
 
 func newClaimsService(db *gorm.DB, customClaims CustomClaimSource, baseURL string, signer TokenSigner) *ClaimsService {
@@ -32,6 +35,13 @@ func newClaimsService(db *gorm.DB, customClaims CustomClaimSource, baseURL strin
 	}
 }
 
+// withPairwiseSubjects sets how the subjects of pairwise clients are computed; without it every client gets the ID of the user
+// It returns the service to allow chaining at construction
+func (s *ClaimsService) withPairwiseSubjects(subjects *pairwiseSubjects) *ClaimsService {
+	s.subjects = subjects
+	return s
+}
+
 // ValidateUserAccess re-checks, at token-issuance time, that the user behind a grant is
 // still allowed to obtain tokens for the client.
 func (s *ClaimsService) ValidateUserAccess(ctx context.Context, userID string, client Client) error {
@@ -65,17 +75,27 @@ func (s *ClaimsService) ValidateUserAccess(ctx context.Context, userID string, c
 }
 
 // applyIDTokenClaims applies the claims of a user to the ID token claims in the session based on the requested scopes.
-func (s *ClaimsService) applyIDTokenClaims(ctx context.Context, session *Session, scopes fosite.Arguments) error {
+// The user claims the client's claim policy puts in the access token are applied as well.
+func (s *ClaimsService) applyIDTokenClaims(ctx context.Context, session *Session, client model.OidcClient, scopes fosite.Arguments) error {
 	userID := session.Subject
 	if userID == "" {
 		return nil
 	}
 
-	claims, err := s.GetUserClaims(ctx, userID, scopes)
+	claims, err := s.GetUserClaims(ctx, client, userID, scopes, session.ClaimsRequest.idTokenClaims(), model.ClaimDestinationIDToken)
+	if err != nil {
+		return err
+	}
+	accessTokenClaims, err := s.accessTokenClaims(ctx, client, userID, scopes)
 	if err != nil {
 		return err
 	}
 
+	// A client that asks for a specific subject must not get tokens for another user, see OpenID Connect Core section 5.5.1
+	if !session.ClaimsRequest.idTokenClaims().allows("sub", claims["sub"]) {
+		return fosite.ErrLoginRequired.WithHint("The signed in user isn't the one the client requested.")
+	}
+
 	// Record the signing algorithm on the ID token header so fosite derives the at_hash/
 	// c_hash digest from it (e.g. RS384 -> SHA-384, ES512 -> SHA-512). Without this the
 	// header is empty and fosite defaults to SHA-256, producing wrong hashes whenever the
@@ -83,30 +103,56 @@ func (s *ClaimsService) applyIDTokenClaims(ctx context.Context, session *Session
 	// never overrides the real JWS header. The signer is always wired in production; it is
 	// only nil in unit tests that do not assert hash correctness.
 	if s.signer != nil {
-		alg, err := s.signer.GetKeyAlg()
+		alg, err := registeredSigningAlgorithm(s.signer, client.IDTokenSignedResponseAlg)
 		if err != nil {
 			return err
 		}
 		session.IDTokenHeaders().Add("alg", alg.String())
 	}
 
-	applyUserClaimsToIDToken(session, userID, claims)
+	applyUserClaimsToIDToken(session, claims)
+	session.setAccessTokenUserClaims(accessTokenClaims)
 	return nil
 }
 
-func applyUserClaimsToIDToken(session *Session, userID string, claims map[string]any) {
+// accessTokenClaims returns the user claims the client's claim policy puts in the access token
+// They are only released by the scopes, as the claims parameter is about the ID token and the userinfo response
+func (s *ClaimsService) accessTokenClaims(ctx context.Context, client model.OidcClient, userID string, scopes []string) (map[string]any, error) {
+	claims, err := s.GetUserClaims(ctx, client, userID, scopes, nil, model.ClaimDestinationAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	// The access token has its own subject
+	delete(claims, "sub")
+	return claims, nil
+}
+
+// applyUserClaimsToIDToken sets the claims of the user on the ID token, and its subject on both the ID and the access token
+// The session's own subject stays the ID of the user, as it's what Pocket ID looks the user up by
+func applyUserClaimsToIDToken(session *Session, claims map[string]any) {
+	subject, _ := claims["sub"].(string)
 	idTokenClaims := session.IDTokenClaims()
-	idTokenClaims.Subject = userID
+	idTokenClaims.Subject = subject
 	idTokenClaims.Extra = claims
 	idTokenClaims.Extra[common.TokenTypeClaim] = idTokenType
 	if session.AuthenticationMethod != "" {
 		idTokenClaims.AuthenticationMethodsReferences = []string{session.AuthenticationMethod}
 	}
+	idTokenClaims.AuthenticationContextClassReference = session.AuthenticationContextClass
+	if session.SessionID != "" {
+		idTokenClaims.Extra["sid"] = session.SessionID
+	}
+	if session.JWTClaims == nil {
+		session.JWTClaims = &fositejwt.JWTClaims{}
+	}
+	session.JWTClaims.Subject = subject
 }
 
-// GetUserClaims retrieves the claims for a user based on the requested scopes. It includes standard claims
+// GetUserClaims retrieves the claims for a user based on the requested scopes and the claims requested individually. It includes standard claims
 // like "sub" and "email" as well as any custom claims defined for the user or their groups.
-func (s *ClaimsService) GetUserClaims(ctx context.Context, userID string, scopes []string) (map[string]any, error) {
+// The "sub" claim is the subject the client knows the user by, which is pairwise if the client is configured so.
+// The claim policy of the client then decides which of them go to the destination and under which name, and adds its static claims.
+func (s *ClaimsService) GetUserClaims(ctx context.Context, client model.OidcClient, userID string, scopes []string, requested RequestedClaims, destination model.ClaimDestination) (map[string]any, error) {
 	db := dbFromContext(ctx, s.db)
 
 	var user model.User
@@ -119,48 +165,123 @@ func (s *ClaimsService) GetUserClaims(ctx context.Context, userID string, scopes
 	}
 
 	claims := make(map[string]any, 10)
+	// A claim is released if its scope was granted or the client requested it individually
+	// A requested value restricts the release to users that have it
+	release := func(scope, name string, value any) {
+		if (slices.Contains(scopes, scope) || requested.has(name)) && requested.allows(name, value) {
+			claims[name] = value
+		}
+	}
 
-	if slices.Contains(scopes, "profile") {
+	if slices.Contains(scopes, "profile") || len(requested) > 0 {
 		customClaims, err := s.customClaims.GetCustomClaimsForUserWithUserGroups(ctx, user.ID, db)
 		if err != nil {
 			return nil, err
 		}
 
 		for _, customClaim := range customClaims {
-			// A custom claim value can be a JSON document or a plain string
-			var jsonValue any
-			if err := json.Unmarshal([]byte(customClaim.Value), &jsonValue); err == nil {
-				claims[customClaim.Key] = jsonValue
-			} else {
-				claims[customClaim.Key] = customClaim.Value
+			if client.ClaimPolicy.ReleasesCustomClaim(customClaim.Key) {
+				release("profile", customClaim.Key, parseClaimValue(customClaim.Value))
 			}
 		}
-
-		claims["given_name"] = user.FirstName
-		claims["family_name"] = user.LastName
-		claims["name"] = user.FullName()
-		claims["display_name"] = user.DisplayName
-		claims["preferred_username"] = user.Username
-		claims["picture"] = s.baseURL + "/api/users/" + user.ID + "/profile-picture.png"
 	}
 
-	claims["sub"] = user.ID
+	release("profile", "given_name", user.FirstName)
+	release("profile", "family_name", user.LastName)
+	release("profile", "name", user.FullName())
+	release("profile", "display_name", user.DisplayName)
+	release("profile", "preferred_username", user.Username)
+	release("profile", "picture", s.baseURL+"/api/users/"+user.ID+"/profile-picture.png")
+
+	claims["sub"] = s.subjects.subjectFor(client, user.ID)
 
 	// Only release the email claims when the user actually has an email. Emitting
 	// email_verified alongside a null/absent email (OIDC Core §5.1) is malformed and can
 	// mislead relying parties that key trust decisions on email_verified.
-	if slices.Contains(scopes, "email") && user.Email != nil && *user.Email != "" {
-		claims["email"] = *user.Email
-		claims["email_verified"] = user.EmailVerified
+	if user.Email != nil && *user.Email != "" {
+		release("email", "email", *user.Email)
+		release("email", "email_verified", user.EmailVerified)
+	}
+
+	userGroups := make([]string, len(user.UserGroups))
+	for i, group := range user.UserGroups {
+		userGroups[i] = group.Name
 	}
+	release("groups", "groups", userGroups)
 
-	if slices.Contains(scopes, "groups") {
-		userGroups := make([]string, len(user.UserGroups))
-		for i, group := range user.UserGroups {
-			userGroups[i] = group.Name
+	return applyClaimPolicy(client.ClaimPolicy, claims, destination), nil
+}
+
+// applyClaimPolicy renames the user claims and keeps the ones that go to the destination, then adds the static claims of the client
+// The subject identifies the user to the client, so it's never renamed or left out
+// A renamed claim takes the place of a custom claim that already has its new name, and a static claim the place of both
+func applyClaimPolicy(policy model.OidcClientClaimPolicy, claims map[string]any, destination model.ClaimDestination) map[string]any {
+	released := make(map[string]any, len(claims)+len(policy.StaticClaims))
+	renamed := make(map[string]any)
+	for name, value := range claims {
+		if name == "sub" {
+			released[name] = value
+			continue
+		}
+		mapping := policy.Mapping(name)
+		if !mapping.Destinations.Includes(destination) {
+			continue
+		}
+		if mapping.Name != "" && mapping.Name != name {
+			renamed[mapping.Name] = value
+		} else {
+			released[name] = value
+		}
+	}
+	maps.Copy(released, renamed)
+	for _, staticClaim := range policy.StaticClaims {
+		if staticClaim.Destinations.Includes(destination) {
+			released[staticClaim.Key] = parseClaimValue(staticClaim.Value)
 		}
-		claims["groups"] = userGroups
 	}
+	return released
+}
 
-	return claims, nil
+// parseClaimValue returns the value of a custom or static claim, which can be a JSON document or a plain string
+func parseClaimValue(value string) any {
+	var jsonValue any
+	if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
+		return jsonValue
+	}
+	return value
+}
+
+// releasedClaimNames returns the names of the user claims a client gets in the ID token, the userinfo response and the access token, for the consent screen
+// The subject is left out, as it only identifies the user to the client, and so are the static claims of the client, which aren't about the user
+func (s *ClaimsService) releasedClaimNames(ctx context.Context, client model.OidcClient, userID string, scopes []string, claimsRequest *ClaimsRequest) ([]string, error) {
+	names := []string{}
+	// Without the openid scope, the client gets neither an ID token nor access to the userinfo endpoint
+	if !slices.Contains(scopes, "openid") {
+		return names, nil
+	}
+
+	destinations := []struct {
+		destination model.ClaimDestination
+		requested   RequestedClaims
+	}{
+		{model.ClaimDestinationIDToken, claimsRequest.idTokenClaims()},
+		{model.ClaimDestinationUserInfo, claimsRequest.userInfoClaims()},
+		{model.ClaimDestinationAccessToken, nil},
+	}
+	for _, d := range destinations {
+		claims, err := s.GetUserClaims(ctx, client, userID, scopes, d.requested, d.destination)
+		if err != nil {
+			return nil, err
+		}
+		for name := range claims {
+			isStatic := slices.ContainsFunc(client.ClaimPolicy.StaticClaims, func(staticClaim model.OidcClientStaticClaim) bool {
+				return staticClaim.Key == name
+			})
+			if name != "sub" && !isStatic && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
 }
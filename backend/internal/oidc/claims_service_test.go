@@ -3,6 +3,7 @@ package oidc
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/ory/fosite"
@@ -112,13 +113,13 @@ func TestClaimsServiceGetUserClaims(t *testing.T) {
 	require.NoError(t, db.Model(&user).Association("UserGroups").Append(&group))
 
 	t.Run("openid only releases sub", func(t *testing.T) {
-		claims, err := service.GetUserClaims(t.Context(), userID, []string{"openid"})
+		claims, err := service.GetUserClaims(t.Context(), model.OidcClient{}, userID, []string{"openid"}, nil, model.ClaimDestinationUserInfo)
 		require.NoError(t, err)
 		require.Equal(t, map[string]any{"sub": userID}, claims)
 	})
 
 	t.Run("email scope releases email claims", func(t *testing.T) {
-		claims, err := service.GetUserClaims(t.Context(), userID, []string{"openid", "email"})
+		claims, err := service.GetUserClaims(t.Context(), model.OidcClient{}, userID, []string{"openid", "email"}, nil, model.ClaimDestinationUserInfo)
 		require.NoError(t, err)
 		require.Equal(t, userID, claims["sub"])
 		require.Equal(t, "tim@example.com", claims["email"])
@@ -128,13 +129,13 @@ func TestClaimsServiceGetUserClaims(t *testing.T) {
 	})
 
 	t.Run("groups scope releases group names", func(t *testing.T) {
-		claims, err := service.GetUserClaims(t.Context(), userID, []string{"groups"})
+		claims, err := service.GetUserClaims(t.Context(), model.OidcClient{}, userID, []string{"groups"}, nil, model.ClaimDestinationUserInfo)
 		require.NoError(t, err)
 		require.Equal(t, []string{"developers"}, claims["groups"])
 	})
 
 	t.Run("profile scope releases profile and custom claims", func(t *testing.T) {
-		claims, err := service.GetUserClaims(t.Context(), userID, []string{"profile"})
+		claims, err := service.GetUserClaims(t.Context(), model.OidcClient{}, userID, []string{"profile"}, nil, model.ClaimDestinationUserInfo)
 		require.NoError(t, err)
 		require.Equal(t, "Tim", claims["given_name"])
 		require.Equal(t, "Cook", claims["family_name"])
@@ -150,6 +151,121 @@ func TestClaimsServiceGetUserClaims(t *testing.T) {
 		// Profile must not leak email when the email scope was not requested.
 		require.NotContains(t, claims, "email")
 	})
+
+	t.Run("claims requested individually are released without their scope", func(t *testing.T) {
+		claims, err := service.GetUserClaims(t.Context(), model.OidcClient{}, userID, []string{"openid"}, RequestedClaims{
+			"email":      nil,
+			"department": {Essential: true},
+		}, model.ClaimDestinationUserInfo)
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"sub": userID, "email": "tim@example.com", "department": "engineering"}, claims)
+	})
+
+	t.Run("requested values restrict the release", func(t *testing.T) {
+		claims, err := service.GetUserClaims(t.Context(), model.OidcClient{}, userID, []string{"openid", "profile"}, RequestedClaims{
+			"department":     {Value: "sales"},
+			"email_verified": {Values: []any{true}},
+		}, model.ClaimDestinationUserInfo)
+		require.NoError(t, err)
+		require.NotContains(t, claims, "department")
+		require.Equal(t, true, claims["email_verified"])
+		require.Equal(t, "Tim", claims["given_name"])
+	})
+}
+
+// TestClaimsServiceAppliesClientClaimPolicy verifies that a client only gets the custom claims its policy allows,
+// under the names it maps them to and in the destinations it chooses, along with its static claims
+func TestClaimsServiceAppliesClientClaimPolicy(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	const userID = "user-1"
+
+	customClaims := fakeCustomClaimSource{claims: []model.CustomClaim{
+		{Key: "department", Value: "engineering"},
+		{Key: "roles", Value: `["admin","dev"]`},
+		{Key: "cost_center", Value: "4711"},
+	}}
+	service := newClaimsService(db, customClaims, "", nil)
+	require.NoError(t, db.Create(&model.User{
+		Base:          model.Base{ID: userID},
+		Username:      "tim",
+		Email:         stringPointer("tim@example.com"),
+		EmailVerified: true,
+	}).Error)
+
+	client := model.OidcClient{ClaimPolicy: model.OidcClientClaimPolicy{
+		CustomClaimsFilter: model.OidcClientClaimFilterAllow,
+		CustomClaimKeys:    []string{"department", "roles"},
+		Mappings: []model.OidcClientClaimMapping{
+			{Claim: "department", Name: "ou", Destinations: model.ClaimDestinations{IDToken: true, UserInfo: true}},
+			{Claim: "roles", Destinations: model.ClaimDestinations{AccessToken: true}},
+			{Claim: "email_verified", Destinations: model.ClaimDestinations{}},
+		},
+		StaticClaims: []model.OidcClientStaticClaim{
+			{Key: "tenant", Value: "acme", Destinations: model.ClaimDestinations{IDToken: true, AccessToken: true}},
+		},
+	}}
+	scopes := []string{"openid", "profile", "email"}
+
+	t.Run("ID token", func(t *testing.T) {
+		claims, err := service.GetUserClaims(t.Context(), client, userID, scopes, nil, model.ClaimDestinationIDToken)
+		require.NoError(t, err)
+		require.Equal(t, "engineering", claims["ou"])
+		require.Equal(t, "acme", claims["tenant"])
+		require.Equal(t, "tim@example.com", claims["email"])
+		require.Equal(t, userID, claims["sub"])
+		require.NotContains(t, claims, "department")
+		require.NotContains(t, claims, "roles")
+		require.NotContains(t, claims, "cost_center")
+		require.NotContains(t, claims, "email_verified")
+	})
+
+	t.Run("userinfo", func(t *testing.T) {
+		claims, err := service.GetUserClaims(t.Context(), client, userID, scopes, nil, model.ClaimDestinationUserInfo)
+		require.NoError(t, err)
+		require.Equal(t, "engineering", claims["ou"])
+		require.NotContains(t, claims, "tenant")
+		require.NotContains(t, claims, "roles")
+	})
+
+	t.Run("access token", func(t *testing.T) {
+		claims, err := service.accessTokenClaims(t.Context(), client, userID, scopes)
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"roles": []any{"admin", "dev"}, "tenant": "acme"}, claims)
+	})
+
+	t.Run("deny list", func(t *testing.T) {
+		denyClient := model.OidcClient{ClaimPolicy: model.OidcClientClaimPolicy{
+			CustomClaimsFilter: model.OidcClientClaimFilterDeny,
+			CustomClaimKeys:    []string{"cost_center"},
+		}}
+		claims, err := service.GetUserClaims(t.Context(), denyClient, userID, scopes, nil, model.ClaimDestinationUserInfo)
+		require.NoError(t, err)
+		require.Equal(t, "engineering", claims["department"])
+		require.NotContains(t, claims, "cost_center")
+	})
+
+	t.Run("consent screen lists released user claims only", func(t *testing.T) {
+		names, err := service.releasedClaimNames(t.Context(), client, userID, scopes, nil)
+		require.NoError(t, err)
+		require.Contains(t, names, "ou")
+		require.Contains(t, names, "roles")
+		require.NotContains(t, names, "tenant")
+		require.NotContains(t, names, "cost_center")
+	})
+}
+
+func TestClaimsServiceRejectsIDTokenForOtherRequestedSubject(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: "user-1"}, Username: "tim"}).Error)
+	service := newClaimsService(db, fakeCustomClaimSource{}, "", nil)
+
+	session := NewAuthenticatedSession("user-1", "phr", time.Time{}, time.Time{})
+	session.ClaimsRequest = &ClaimsRequest{IDToken: RequestedClaims{"sub": {Value: "user-2"}}}
+	err := service.applyIDTokenClaims(t.Context(), session, model.OidcClient{}, fosite.Arguments{"openid"})
+	require.ErrorIs(t, err, fosite.ErrLoginRequired)
+
+	session.ClaimsRequest = &ClaimsRequest{IDToken: RequestedClaims{"sub": {Value: "user-1"}}}
+	require.NoError(t, service.applyIDTokenClaims(t.Context(), session, model.OidcClient{}, fosite.Arguments{"openid"}))
 }
 
 // TestClaimsServiceAppliesSigningAlgToIDTokenHeader verifies the ID token header carries the
@@ -166,7 +282,7 @@ func TestClaimsServiceAppliesSigningAlgToIDTokenHeader(t *testing.T) {
 			session := NewEmptySession()
 			session.Subject = "alg-user"
 
-			require.NoError(t, service.applyIDTokenClaims(t.Context(), session, fosite.Arguments{"openid"}))
+			require.NoError(t, service.applyIDTokenClaims(t.Context(), session, model.OidcClient{}, fosite.Arguments{"openid"}))
 			require.Equal(t, alg.String(), session.IDTokenHeaders().Get("alg"))
 		})
 	}
@@ -35,3 +35,11 @@ func cleanupAbandonedInteractionSessions(ctx context.Context, db *gorm.DB) (int6
 		Delete(&InteractionSession{}, "created_at < ?", datatype.DateTime(time.Now().Add(-interactionSessionLifetime)))
 	return st.RowsAffected, st.Error
 }
+
+// cleanupExpiredCIBARequests deletes backchannel authentication requests the client can no longer fetch tokens for.
+func cleanupExpiredCIBARequests(ctx context.Context, db *gorm.DB) (int64, error) {
+	st := db.
+		WithContext(ctx).
+		Delete(&CIBARequest{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	return st.RowsAffected, st.Error
+}
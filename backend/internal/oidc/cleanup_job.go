@@ -43,7 +43,13 @@ func newCleanupJobs(db *gorm.DB) ([]*cronjob.CronJob, error) {
 		return nil, err
 	}
 
-	return []*cronjob.CronJob{clearOAuth2Sessions, clearOAuth2JTIs, clearInteractionSessions}, nil
+	// Create the built-in actor for the ClearCIBARequests job
+	clearCIBARequests, err := newCleanupJob("ClearCIBARequests", jobs.clearCIBARequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*cronjob.CronJob{clearOAuth2Sessions, clearOAuth2JTIs, clearInteractionSessions, clearCIBARequests}, nil
 }
 
 // newCleanupJob creates a cron job actor that runs one of the OIDC cleanups on a daily schedule
@@ -100,3 +106,15 @@ func (j *cleanupJobs) clearInteractionSessions(ctx context.Context) error {
 
 	return nil
 }
+
+// clearCIBARequests deletes expired backchannel authentication requests.
+func (j *cleanupJobs) clearCIBARequests(ctx context.Context) error {
+	count, err := cleanupExpiredCIBARequests(ctx, j.db)
+	if err != nil {
+		return fmt.Errorf("failed to clean CIBA requests: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Cleaned CIBA requests", slog.Int64("count", count))
+
+	return nil
+}
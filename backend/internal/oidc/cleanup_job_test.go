@@ -26,6 +26,7 @@ func TestNewCleanupJobsCreatesOneCronActorPerTable(t *testing.T) {
 		"cronjob.ClearOAuth2Sessions",
 		"cronjob.ClearOAuth2JTIs",
 		"cronjob.ClearInteractionSessions",
+		"cronjob.ClearCIBARequests",
 	}, actorTypes)
 
 	// Every job must be registrable on an actor host
@@ -42,6 +43,8 @@ func TestOIDCCleanupJobsDeleteExpiredRows(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 	err := db.Create(&model.OidcClient{Base: model.Base{ID: "cleanup-job-client"}, Name: "Cleanup Job Client"}).Error
 	require.NoError(t, err)
+	err = db.Create(&model.User{Base: model.Base{ID: "cleanup-job-user"}, Username: "cleanup-job-user"}).Error
+	require.NoError(t, err)
 
 	var (
 		past   = datatype.DateTime(time.Now().Add(-time.Hour))
@@ -79,6 +82,17 @@ func TestOIDCCleanupJobsDeleteExpiredRows(t *testing.T) {
 	err = db.Model(&InteractionSession{}).Where("id = ?", "interaction-abandoned").Update("created_at", abandonedCreatedAt).Error
 	require.NoError(t, err)
 
+	err = db.Create(&CIBARequest{
+		Base: model.Base{ID: "ciba-expired"}, AuthReqIDSignature: "expired", ClientID: "cleanup-job-client", UserID: "cleanup-job-user",
+		Status: cibaRequestStatusPending, PollingInterval: 5, ExpiresAt: past, RequestData: `{"client_id":"cleanup-job-client"}`,
+	}).Error
+	require.NoError(t, err)
+	err = db.Create(&CIBARequest{
+		Base: model.Base{ID: "ciba-pending"}, AuthReqIDSignature: "pending", ClientID: "cleanup-job-client", UserID: "cleanup-job-user",
+		Status: cibaRequestStatusPending, PollingInterval: 5, ExpiresAt: future, RequestData: `{"client_id":"cleanup-job-client"}`,
+	}).Error
+	require.NoError(t, err)
+
 	jobs := &cleanupJobs{db: db}
 	err = jobs.clearOAuth2Sessions(t.Context())
 	require.NoError(t, err)
@@ -86,6 +100,8 @@ func TestOIDCCleanupJobsDeleteExpiredRows(t *testing.T) {
 	require.NoError(t, err)
 	err = jobs.clearInteractionSessions(t.Context())
 	require.NoError(t, err)
+	err = jobs.clearCIBARequests(t.Context())
+	require.NoError(t, err)
 
 	var remaining []string
 	err = db.Model(&OAuth2Session{}).Pluck("id", &remaining).Error
@@ -99,4 +115,8 @@ func TestOIDCCleanupJobsDeleteExpiredRows(t *testing.T) {
 	err = db.Model(&InteractionSession{}).Pluck("id", &remaining).Error
 	require.NoError(t, err)
 	require.Equal(t, []string{"interaction-pending"}, remaining)
+
+	err = db.Model(&CIBARequest{}).Pluck("id", &remaining).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"ciba-pending"}, remaining)
 }
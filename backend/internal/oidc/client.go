@@ -54,17 +54,25 @@ func (c Client) GetGrantTypes() fosite.Arguments {
 		string(fosite.GrantTypeDeviceCode),
 	}
 	if !c.IsPublic() {
-		grantTypes = append(grantTypes, string(fosite.GrantTypeClientCredentials))
+		grantTypes = append(grantTypes, string(fosite.GrantTypeClientCredentials), string(grantTypeTokenExchange))
+	}
+	// The JWT bearer grant is only available to clients that trust an external issuer for it
+	if len(c.Credentials.JWTBearerGrants) > 0 {
+		grantTypes = append(grantTypes, string(fosite.GrantTypeJWTBearer))
+	}
+	// Backchannel authentication is only available to confidential clients that chose a token delivery mode
+	if !c.IsPublic() && c.BackchannelTokenDeliveryMode != "" {
+		grantTypes = append(grantTypes, string(grantTypeCIBA))
 	}
 
-	if !c.IsMetadataDocument() {
+	if !c.IsMetadataDocument() && !c.IsDynamicallyRegistered() {
 		return grantTypes
 	}
 	if len(c.MetadataGrantTypes) == 0 {
 		return fosite.Arguments{string(fosite.GrantTypeAuthorizationCode)}
 	}
 
-	// If the client is a CIMD client, we need to filter the grant types based on the metadata document.
+	// CIMD and dynamically registered clients declared the grant types they use in their metadata, so the available ones are filtered by them
 	allowed := make(fosite.Arguments, 0, len(c.MetadataGrantTypes))
 	for _, value := range c.MetadataGrantTypes {
 		if slices.Contains([]string(grantTypes), value) {
@@ -113,16 +121,16 @@ func (c Client) GetEffectiveLifespan(grantType fosite.GrantType, tokenType fosit
 	switch tokenType {
 	case fosite.AccessToken:
 		switch grantType {
-		case fosite.GrantTypeAuthorizationCode, fosite.GrantTypeRefreshToken, fosite.GrantTypeDeviceCode, fosite.GrantTypeClientCredentials:
+		case fosite.GrantTypeAuthorizationCode, fosite.GrantTypeRefreshToken, fosite.GrantTypeDeviceCode, fosite.GrantTypeClientCredentials, grantTypeTokenExchange, fosite.GrantTypeJWTBearer, grantTypeCIBA:
 			minutes = c.AccessTokenDurationMinutes
-		case fosite.GrantTypeImplicit, fosite.GrantTypePassword, fosite.GrantTypeJWTBearer:
+		case fosite.GrantTypeImplicit, fosite.GrantTypePassword:
 			return fallback
 		default:
 			return fallback
 		}
 	case fosite.RefreshToken:
 		switch grantType {
-		case fosite.GrantTypeAuthorizationCode, fosite.GrantTypeRefreshToken, fosite.GrantTypeDeviceCode:
+		case fosite.GrantTypeAuthorizationCode, fosite.GrantTypeRefreshToken, fosite.GrantTypeDeviceCode, grantTypeCIBA:
 			minutes = c.RefreshTokenDurationMinutes
 		case fosite.GrantTypeImplicit, fosite.GrantTypePassword, fosite.GrantTypeClientCredentials, fosite.GrantTypeJWTBearer:
 			return fallback
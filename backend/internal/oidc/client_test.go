@@ -59,6 +59,10 @@ func TestClientGetEffectiveLifespan(t *testing.T) {
 		{name: "device grant access token", grantType: fosite.GrantTypeDeviceCode, tokenType: fosite.AccessToken, want: 2 * time.Hour},
 		{name: "device grant refresh token", grantType: fosite.GrantTypeDeviceCode, tokenType: fosite.RefreshToken, want: 7 * 24 * time.Hour},
 		{name: "client credentials access token", grantType: fosite.GrantTypeClientCredentials, tokenType: fosite.AccessToken, want: 2 * time.Hour},
+		{name: "token exchange access token", grantType: grantTypeTokenExchange, tokenType: fosite.AccessToken, want: 2 * time.Hour},
+		{name: "JWT bearer access token", grantType: fosite.GrantTypeJWTBearer, tokenType: fosite.AccessToken, want: 2 * time.Hour},
+		{name: "CIBA access token", grantType: grantTypeCIBA, tokenType: fosite.AccessToken, want: 2 * time.Hour},
+		{name: "CIBA refresh token", grantType: grantTypeCIBA, tokenType: fosite.RefreshToken, want: 7 * 24 * time.Hour},
 		{name: "client credentials refresh token falls back", grantType: fosite.GrantTypeClientCredentials, tokenType: fosite.RefreshToken, want: fallback},
 		{name: "ID token falls back", grantType: fosite.GrantTypeAuthorizationCode, tokenType: fosite.IDToken, want: fallback},
 		{name: "unsupported grant falls back", grantType: fosite.GrantTypePassword, tokenType: fosite.AccessToken, want: fallback},
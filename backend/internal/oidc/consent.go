@@ -0,0 +1,203 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// The kinds of consent keys, see consentScopeKey, consentAudienceKey and consentClaimKey
+const (
+	consentKeyTypeScope      = "scope"
+	consentKeyTypePermission = "permission"
+	consentKeyTypeAPI        = "api"
+	consentKeyTypeClaim      = "claim"
+)
+
+// isOptionalScope reports whether the user may untick the requested scope on the consent screen
+// Without openid there's nothing to sign in with, so only the whole authorization can be declined
+func isOptionalScope(scope string) bool {
+	return scope != "openid"
+}
+
+// parseConsentKey splits a consent key into its kind, the audience of the API it's for, and the scope, API or claim it's about
+func parseConsentKey(key string) (keyType string, audience string, name string) {
+	audience, name, qualified := strings.Cut(key, "\x1f")
+	switch {
+	case !qualified:
+		return consentKeyTypeScope, "", key
+	case audience == "":
+		return consentKeyTypeClaim, "", name
+	case name == "":
+		return consentKeyTypeAPI, audience, audience
+	default:
+		return consentKeyTypePermission, audience, name
+	}
+}
+
+// isOptionalConsentKey reports whether the user may decline the consent key on the consent screen and revoke it on its own later
+// The APIs themselves and the claims requested individually are what the authorization is about, like openid
+func isOptionalConsentKey(key string) bool {
+	keyType, _, name := parseConsentKey(key)
+	switch keyType {
+	case consentKeyTypeScope, consentKeyTypePermission:
+		return isOptionalScope(name)
+	default:
+		return false
+	}
+}
+
+// declinedConsentKeys returns the consent keys of the scopes the user unticked on the consent screen, for every resource they were granted for
+func declinedConsentKeys(grants []ResourceGrant, requestedScopes []string, declinedScopes []string) ([]string, error) {
+	var keys []string
+	for _, scope := range declinedScopes {
+		if !slices.Contains(requestedScopes, scope) || !isOptionalScope(scope) {
+			return nil, apperror.ValidationMessage("The scope '" + scope + "' can't be declined")
+		}
+
+		for _, grant := range grants {
+			if !slices.Contains(grant.Scopes, scope) {
+				continue
+			}
+			key := consentScopeKey(grant.Audience, scope)
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// withoutDeclinedScopes removes the scopes the user declined from the grants, so the client only gets the ones the user agreed to
+func withoutDeclinedScopes(grants []ResourceGrant, consents model.ScopeConsents) []ResourceGrant {
+	filtered := make([]ResourceGrant, len(grants))
+	for i, grant := range grants {
+		filtered[i] = ResourceGrant{
+			Audience: grant.Audience,
+			Scopes: slices.DeleteFunc(slices.Clone(grant.Scopes), func(scope string) bool {
+				return consents.IsDeclined(consentScopeKey(grant.Audience, scope))
+			}),
+		}
+	}
+	return filtered
+}
+
+// withoutDeclinedClaims removes the claims requested individually that are released with a scope the user declined, so the claims parameter can't bring them back
+// The claims of scopes that weren't requested at all are consented to on their own, see claimConsentKeys
+func withoutDeclinedClaims(request *ClaimsRequest, requestedScopes []string, grants []ResourceGrant) *ClaimsRequest {
+	if request == nil {
+		return nil
+	}
+
+	grantedScopes := resourceGrantScopes(grants)
+	declined := func(name string) bool {
+		scope := claimScope(name)
+		return slices.Contains(requestedScopes, scope) && !slices.Contains(grantedScopes, scope)
+	}
+	withoutDeclined := func(requested RequestedClaims) RequestedClaims {
+		if requested == nil {
+			return nil
+		}
+		filtered := maps.Clone(requested)
+		maps.DeleteFunc(filtered, func(name string, _ *RequestedClaim) bool {
+			return !slices.Contains(authenticationClaims, name) && declined(name)
+		})
+		return filtered
+	}
+
+	return &ClaimsRequest{
+		UserInfo: withoutDeclined(request.UserInfo),
+		IDToken:  withoutDeclined(request.IDToken),
+	}
+}
+
+// authorizedScopes describes the user's decisions on everything they were asked to consent to for the client, for their account settings
+func (s *authorizationService) authorizedScopes(ctx context.Context, userID, clientID string) ([]dto.AuthorizedOidcClientScopeDto, error) {
+	var authorizedClient model.UserAuthorizedOidcClient
+	err := dbFromContext(ctx, s.db).
+		Preload("Client").
+		First(&authorizedClient, "client_id = ? AND user_id = ?", clientID, userID).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apperror.NotFound("Client authorization")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := make([]dto.AuthorizedOidcClientScopeDto, len(authorizedClient.ScopeConsents))
+	for i, consent := range authorizedClient.ScopeConsents {
+		keyType, audience, name := parseConsentKey(consent.Scope)
+		scopes[i] = dto.AuthorizedOidcClientScopeDto{
+			Key:         consent.Scope,
+			Type:        keyType,
+			Name:        name,
+			Audience:    audience,
+			Granted:     consent.Granted,
+			Revocable:   consent.Granted && isOptionalConsentKey(consent.Scope),
+			ConsentedAt: consent.ConsentedAt,
+			ExpiresAt:   consent.ExpiresAt(authorizedClient.Client.ConsentLifetimeMinutes),
+		}
+
+		// The API registered a display name for its permission, like on the consent screen
+		if keyType == consentKeyTypePermission && s.apiAccess != nil {
+			infos, err := s.apiAccess.DescribePermissions(ctx, audience, []string{name})
+			if err != nil {
+				return nil, err
+			}
+			if len(infos) > 0 {
+				scopes[i].Name = infos[0].Name
+				scopes[i].Description = infos[0].Description
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// revokeAuthorizedScope withdraws the user's consent to a single optional scope, so the client has to ask for it again, and ends the sessions that were granted it
+func (s *authorizationService) revokeAuthorizedScope(ctx context.Context, userID, clientID, key string) error {
+	return withTx(ctx, s.db, func(ctx context.Context) error {
+		db := dbFromContext(ctx, s.db)
+
+		var authorizedClient model.UserAuthorizedOidcClient
+		err := db.
+			First(&authorizedClient, "client_id = ? AND user_id = ?", clientID, userID).
+			Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apperror.NotFound("Client authorization")
+		}
+		if err != nil {
+			return err
+		}
+
+		consent, ok := authorizedClient.ScopeConsents.Find(key)
+		if !ok || !consent.Granted {
+			return apperror.NotFound("Scope consent")
+		}
+		if !isOptionalConsentKey(key) {
+			return apperror.ValidationMessage("Only optional scopes can be revoked on their own, revoke the whole authorization instead")
+		}
+
+		consents := slices.DeleteFunc(slices.Clone(authorizedClient.ScopeConsents), func(consent model.ScopeConsent) bool {
+			return consent.Scope == key
+		})
+		err = db.
+			Model(&model.UserAuthorizedOidcClient{}).
+			Where("user_id = ? AND client_id = ?", userID, clientID).
+			Update("scope_consents", consents).
+			Error
+		if err != nil {
+			return err
+		}
+
+		_, audience, scope := parseConsentKey(key)
+		return RevokeUserClientScopeSessions(ctx, db, userID, clientID, audience, scope)
+	})
+}
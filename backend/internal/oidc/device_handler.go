@@ -54,6 +54,7 @@ func (h *deviceHandler) verifyDeviceCode(c *gin.Context) {
 		userCode,
 		c.GetString("userID"),
 		c.GetString("authenticationMethod"),
+		c.GetString("authenticationContextClass"),
 		typedAuthenticationTime,
 		reauthenticationToken,
 		requestMetaFromGin(c),
@@ -77,7 +78,7 @@ func (h *deviceHandler) deviceCodeInfo(c *gin.Context) {
 		return
 	}
 
-	deviceCodeInfo, err := h.deviceService.getDeviceCodeInfo(c.Request.Context(), userCode, c.GetString("userID"))
+	deviceCodeInfo, err := h.deviceService.getDeviceCodeInfo(c.Request.Context(), userCode, c.GetString("userID"), c.GetString("authenticationContextClass"))
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -10,6 +10,7 @@ import (
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/handler/rfc8628"
 	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
@@ -58,7 +59,12 @@ func (s *deviceService) createDeviceAuthorization(ctx context.Context, req *http
 	if err != nil {
 		return nil, request, err
 	}
-	audience, grantedScopes, _, err := s.authorizationService.resolveGrant(ctx, client.GetID(), resource, request.GetRequestedScopes())
+	// The requested claims are only validated here, they're released when the user accepts the code
+	_, err = parseClaimsRequest(request.GetRequestForm().Get("claims"))
+	if err != nil {
+		return nil, request, err
+	}
+	audience, grantedScopes, _, err := s.authorizationService.resolveGrant(ctx, client.GetID(), resource, request.GetRequestedScopes(), nil)
 	if err != nil {
 		if resource != "" && errors.Is(err, fosite.ErrAccessDenied) {
 			return nil, request, fosite.ErrInvalidTarget.WithHintf("The requested resource '%s' is invalid, missing, unknown, or malformed.", resource)
@@ -83,7 +89,7 @@ func (s *deviceService) createDeviceAuthorization(ctx context.Context, req *http
 	}, request, nil
 }
 
-func (s *deviceService) acceptDeviceCode(ctx context.Context, userCode, userID, authenticationMethod string, authenticationTime time.Time, reauthenticationToken string, meta requestMeta) error {
+func (s *deviceService) acceptDeviceCode(ctx context.Context, userCode, userID, authenticationMethod, authenticationContextClass string, authenticationTime time.Time, reauthenticationToken string, meta requestMeta) error {
 	request, userCodeSignature, err := s.deviceRequestFromUserCode(ctx, userCode)
 	if err != nil {
 		return err
@@ -109,31 +115,42 @@ func (s *deviceService) acceptDeviceCode(ctx context.Context, userCode, userID,
 	if err != nil {
 		return err
 	}
-	audience, grantedScopes, consentKeys, err := s.authorizationService.resolveGrant(ctx, client.GetID(), resource, request.GetRequestedScopes())
+	claimsRequest, err := parseClaimsRequest(request.GetRequestForm().Get("claims"))
+	if err != nil {
+		return err
+	}
+	audience, grantedScopes, consentKeys, err := s.authorizationService.resolveGrant(ctx, client.GetID(), resource, request.GetRequestedScopes(), claimsRequest)
 	if err != nil {
 		return err
 	}
 	grantResourceIndicator(request, audience, grantedScopes)
 
 	return withTx(ctx, s.db, func(ctx context.Context) error {
-		if client.RequiresReauthentication {
+		// A user who signed in with a weaker class than the client's minimum steps up by reauthenticating
+		if client.RequiresReauthentication || !common.SatisfiesAuthenticationContext(authenticationContextClass, client.MinimumACR) {
 			if reauthenticationToken == "" || s.authorizationService == nil || s.authorizationService.reauth == nil {
 				return apperror.ReauthenticationRequired()
 			}
 
-			reauthenticatedAt, err := s.authorizationService.reauth.ConsumeReauthenticationToken(ctx, dbFromContext(ctx, s.db), reauthenticationToken, userID)
+			reauthenticatedAt, contextClass, err := s.authorizationService.reauth.ConsumeReauthenticationToken(ctx, dbFromContext(ctx, s.db), reauthenticationToken, userID)
 			if err != nil {
 				return err
 			}
+			if !common.SatisfiesAuthenticationContext(contextClass, client.MinimumACR) {
+				return apperror.InsufficientUserAuthentication()
+			}
 			authenticationTime = reauthenticatedAt
+			authenticationContextClass = contextClass
 		}
 		if authenticationTime.IsZero() {
 			authenticationTime = time.Now().UTC()
 		}
 
 		session := NewAuthenticatedSession(userID, authenticationMethod, authenticationTime, request.GetRequestedAt())
+		session.AuthenticationContextClass = authenticationContextClass
+		session.ClaimsRequest = claimsRequest
 
-		if err = s.claimsService.applyIDTokenClaims(ctx, session, request.GetGrantedScopes()); err != nil {
+		if err = s.claimsService.applyIDTokenClaims(ctx, session, client.OidcClient, request.GetGrantedScopes()); err != nil {
 			return err
 		}
 		request.SetSession(session)
@@ -183,7 +200,7 @@ func (s *deviceService) loadDeviceAuthorizationUser(ctx context.Context, userID
 	return user, err
 }
 
-func (s *deviceService) getDeviceCodeInfo(ctx context.Context, userCode, userID string) (*dto.DeviceCodeInfoDto, error) {
+func (s *deviceService) getDeviceCodeInfo(ctx context.Context, userCode, userID, authenticationContextClass string) (*dto.DeviceCodeInfoDto, error) {
 	request, _, err := s.deviceRequestFromUserCode(ctx, userCode)
 	if err != nil {
 		return nil, err
@@ -194,9 +211,14 @@ func (s *deviceService) getDeviceCodeInfo(ctx context.Context, userCode, userID
 	if err != nil {
 		return nil, err
 	}
+	claimsRequest, err := parseClaimsRequest(request.GetRequestForm().Get("claims"))
+	if err != nil {
+		return nil, err
+	}
 	authorizationRequired := true
+	claims := []string{}
 	if userID != "" {
-		_, _, consentKeys, err := s.authorizationService.resolveGrant(ctx, client.GetID(), resource, request.GetRequestedScopes())
+		_, _, consentKeys, err := s.authorizationService.resolveGrant(ctx, client.GetID(), resource, request.GetRequestedScopes(), claimsRequest)
 		if err != nil {
 			return nil, err
 		}
@@ -206,6 +228,11 @@ func (s *deviceService) getDeviceCodeInfo(ctx context.Context, userCode, userID
 		}
 		// The device flow has no per-request prompt parameter, so consent depends only on prior authorization and the client's skip-consent setting
 		authorizationRequired = consentRequired(hasAuthorizedClient, client.SkipConsent, nil)
+
+		claims, err = s.claimsService.releasedClaimNames(ctx, client.OidcClient, userID, request.GetRequestedScopes(), claimsRequest)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	scope := request.GetRequestedScopes()
@@ -235,8 +262,9 @@ func (s *deviceService) getDeviceCodeInfo(ctx context.Context, userCode, userID
 		},
 		Scope:                    scope,
 		ScopeInfo:                scopeInfo,
+		Claims:                   claims,
 		AuthorizationRequired:    authorizationRequired,
-		ReauthenticationRequired: client.RequiresReauthentication,
+		ReauthenticationRequired: client.RequiresReauthentication || !common.SatisfiesAuthenticationContext(authenticationContextClass, client.MinimumACR),
 	}, nil
 }
 
@@ -14,6 +14,7 @@ import (
 
 	"github.com/ory/fosite"
 	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
 	"github.com/stretchr/testify/require"
@@ -27,16 +28,17 @@ type fakeReauthenticationConsumer struct {
 	token             string
 	userID            string
 	reauthenticatedAt time.Time
+	contextClass      string
 	calls             int
 }
 
-func (f *fakeReauthenticationConsumer) ConsumeReauthenticationToken(_ context.Context, _ *gorm.DB, token string, userID string) (time.Time, error) {
+func (f *fakeReauthenticationConsumer) ConsumeReauthenticationToken(_ context.Context, _ *gorm.DB, token string, userID string) (time.Time, string, error) {
 	f.calls++
 	if token != f.token || userID != f.userID {
-		return time.Time{}, apperror.ReauthenticationRequired()
+		return time.Time{}, "", apperror.ReauthenticationRequired()
 	}
 
-	return f.reauthenticatedAt, nil
+	return f.reauthenticatedAt, f.contextClass, nil
 }
 
 func TestDeviceServiceAcceptRequiresReauthenticationTokenWhenClientRequiresIt(t *testing.T) {
@@ -51,19 +53,73 @@ func TestDeviceServiceAcceptRequiresReauthenticationTokenWhenClientRequiresIt(t
 	}
 	service, _, _, userCode, _ := newTestDeviceServiceWithCode(t, clientID, userID, true, reauth)
 
-	err := service.acceptDeviceCode(t.Context(), userCode, userID, "phr", time.Now().UTC(), "", requestMeta{})
+	err := service.acceptDeviceCode(t.Context(), userCode, userID, "phr", common.ACRPasskey, time.Now().UTC(), "", requestMeta{})
 	require.True(t, apperror.IsCode(err, apperror.CodeReauthenticationRequired))
 	require.Zero(t, reauth.calls)
 
-	info, err := service.getDeviceCodeInfo(t.Context(), userCode, userID)
+	info, err := service.getDeviceCodeInfo(t.Context(), userCode, userID, common.ACRPasskey)
 	require.NoError(t, err)
 	require.True(t, info.ReauthenticationRequired)
 
-	err = service.acceptDeviceCode(t.Context(), userCode, userID, "phr", time.Now().UTC(), reauth.token, requestMeta{})
+	err = service.acceptDeviceCode(t.Context(), userCode, userID, "phr", common.ACRPasskey, time.Now().UTC(), reauth.token, requestMeta{})
 	require.NoError(t, err)
 	require.Equal(t, 1, reauth.calls)
 }
 
+func TestDeviceServiceAcceptRequiresStepUpBelowClientMinimumACR(t *testing.T) {
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+	reauth := &fakeReauthenticationConsumer{
+		token:             testReauthenticationToken,
+		userID:            userID,
+		reauthenticatedAt: time.Now().UTC().Truncate(time.Second),
+		contextClass:      common.ACRUserVerifiedPasskey,
+	}
+	service, store, provider, userCode, deviceCode := newTestDeviceServiceWithCode(t, clientID, userID, false, reauth)
+	require.NoError(t, service.db.Model(&model.OidcClient{}).Where("id = ?", clientID).Update("minimum_acr", common.ACRUserVerifiedPasskey).Error)
+
+	info, err := service.getDeviceCodeInfo(t.Context(), userCode, userID, common.ACRUserVerifiedPasskey)
+	require.NoError(t, err)
+	require.False(t, info.ReauthenticationRequired)
+
+	info, err = service.getDeviceCodeInfo(t.Context(), userCode, userID, common.ACROneTimeCode)
+	require.NoError(t, err)
+	require.True(t, info.ReauthenticationRequired)
+
+	err = service.acceptDeviceCode(t.Context(), userCode, userID, "otp", common.ACROneTimeCode, time.Now().UTC(), "", requestMeta{})
+	require.True(t, apperror.IsCode(err, apperror.CodeReauthenticationRequired))
+
+	err = service.acceptDeviceCode(t.Context(), userCode, userID, "otp", common.ACROneTimeCode, time.Now().UTC(), reauth.token, requestMeta{})
+	require.NoError(t, err)
+
+	deviceCodeSignature, err := provider.deviceStrategy.DeviceCodeSignature(t.Context(), deviceCode)
+	require.NoError(t, err)
+	acceptedRequest, err := store.GetDeviceCodeSession(t.Context(), deviceCodeSignature, NewEmptySession())
+	require.NoError(t, err)
+	session := acceptedRequest.GetSession().(*Session)
+	require.Equal(t, common.ACRUserVerifiedPasskey, session.AuthenticationContextClass)
+}
+
+func TestDeviceServiceAcceptRejectsStepUpWithWeakerPasskey(t *testing.T) {
+	const (
+		userID   = "test-user"
+		clientID = "test-client"
+	)
+	reauth := &fakeReauthenticationConsumer{
+		token:             testReauthenticationToken,
+		userID:            userID,
+		reauthenticatedAt: time.Now().UTC().Truncate(time.Second),
+		contextClass:      common.ACRUserVerifiedPasskey,
+	}
+	service, _, _, userCode, _ := newTestDeviceServiceWithCode(t, clientID, userID, false, reauth)
+	require.NoError(t, service.db.Model(&model.OidcClient{}).Where("id = ?", clientID).Update("minimum_acr", common.ACRDeviceBoundPasskey).Error)
+
+	err := service.acceptDeviceCode(t.Context(), userCode, userID, "phr", common.ACRPasskey, time.Now().UTC(), reauth.token, requestMeta{})
+	require.True(t, apperror.IsCode(err, apperror.CodeInsufficientUserAuthentication))
+}
+
 func TestDeviceServiceCreatesUserCodeWithOAuthPrefix(t *testing.T) {
 	service, _, _, userCode, _ := newTestDeviceServiceWithCode(t, "test-client", "test-user", false, nil)
 
@@ -85,7 +141,7 @@ func TestDeviceServiceAcceptUsesReauthenticationTimeForDeviceSession(t *testing.
 	}
 	service, store, provider, userCode, deviceCode := newTestDeviceServiceWithCode(t, clientID, userID, true, reauth)
 
-	err := service.acceptDeviceCode(t.Context(), userCode, userID, "phr", time.Now().Add(-time.Hour).UTC(), reauth.token, requestMeta{})
+	err := service.acceptDeviceCode(t.Context(), userCode, userID, "phr", common.ACRPasskey, time.Now().Add(-time.Hour).UTC(), reauth.token, requestMeta{})
 	require.NoError(t, err)
 
 	deviceCodeSignature, err := provider.deviceStrategy.DeviceCodeSignature(t.Context(), deviceCode)
@@ -0,0 +1,346 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/ory/fosite"
+)
+
+// DPoP (RFC 9449) binds access and refresh tokens to a key held by the client, so a leaked token is useless without the private key
+// Every request that issues or uses a bound token carries a proof JWT signed with that key, which is checked here
+
+const (
+	dpopHeader      = "DPoP"
+	dpopNonceHeader = "DPoP-Nonce"
+	// dpopTokenType is both the token_type of DPoP-bound tokens and the authorization scheme they are presented with
+	dpopTokenType = "DPoP"
+	// dpopProofType is the explicit JWT type every DPoP proof must carry, as defined by RFC 9449 section 4.2
+	dpopProofType = "dpop+jwt"
+
+	// dpopProofMaxAge is how old a proof may be, based on its iat claim; the server-issued nonce is what actually limits how long a proof can be used
+	dpopProofMaxAge = 5 * time.Minute
+	// dpopProofClockSkew is how far in the future a proof's iat claim may be
+	dpopProofClockSkew = time.Minute
+	// dpopNonceLifetime is how long a nonce is handed out for; nonces of the previous period are still accepted, so a nonce stays valid for at least this long
+	dpopNonceLifetime = 5 * time.Minute
+	// dpopJTIPrefix namespaces the proof IDs in the table that is shared with client assertions
+	dpopJTIPrefix = "dpop:"
+
+	tokenEndpointPath         = "/api/oidc/token"
+	userInfoEndpointPath      = "/api/oidc/userinfo"
+	introspectionEndpointPath = "/api/oidc/introspect"
+)
+
+var (
+	errInvalidDPoPProof = &fosite.RFC6749Error{
+		ErrorField:       "invalid_dpop_proof",
+		DescriptionField: "The DPoP proof is invalid.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errUseDPoPNonce = &fosite.RFC6749Error{
+		ErrorField:       "use_dpop_nonce",
+		DescriptionField: "The DPoP proof must contain the nonce issued by the server.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// dpopSigningAlgorithms are the algorithms accepted for DPoP proofs; symmetric algorithms are never allowed, as the proof must be verifiable with the public key it carries
+var dpopSigningAlgorithms = []jwa.SignatureAlgorithm{
+	jwa.ES256(), jwa.ES384(), jwa.ES512(),
+	jwa.RS256(), jwa.RS384(), jwa.RS512(),
+	jwa.PS256(), jwa.PS384(), jwa.PS512(),
+	jwa.EdDSA(),
+}
+
+// DPoPSigningAlgValuesSupported returns the algorithms accepted for DPoP proofs, for the dpop_signing_alg_values_supported server metadata
+func DPoPSigningAlgValuesSupported() []string {
+	algs := make([]string, len(dpopSigningAlgorithms))
+	for i, alg := range dpopSigningAlgorithms {
+		algs[i] = alg.String()
+	}
+	return algs
+}
+
+// dpopJTIStore records the IDs of the proofs that were already used
+type dpopJTIStore interface {
+	ClientAssertionJWTValid(ctx context.Context, jti string) error
+	SetClientAssertionJWT(ctx context.Context, jti string, exp time.Time) error
+}
+
+type dpopVerifier struct {
+	jtis     dpopJTIStore
+	nonceKey []byte
+	baseURLs []string
+}
+
+// newDPoPVerifier creates the verifier for DPoP proofs
+// The proof's htu claim is accepted for every base URL the endpoints are reachable at
+func newDPoPVerifier(jtis dpopJTIStore, secret []byte, baseURLs ...string) *dpopVerifier {
+	// Nonces are derived from the instance secret rather than stored, so every instance of an HA deployment accepts the nonces issued by the others
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte("pocketid/dpop_nonce"))
+
+	return &dpopVerifier{
+		jtis:     jtis,
+		nonceKey: mac.Sum(nil),
+		baseURLs: baseURLs,
+	}
+}
+
+// nonce returns the nonce the clients must include in their proofs
+func (v *dpopVerifier) nonce() string {
+	return v.nonceForPeriod(time.Now().Unix() / int64(dpopNonceLifetime.Seconds()))
+}
+
+func (v *dpopVerifier) nonceForPeriod(period int64) string {
+	mac := hmac.New(sha256.New, v.nonceKey)
+	_ = binary.Write(mac, binary.BigEndian, period)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (v *dpopVerifier) isValidNonce(nonce string) bool {
+	period := time.Now().Unix() / int64(dpopNonceLifetime.Seconds())
+	for _, p := range []int64{period, period - 1} {
+		if subtle.ConstantTimeCompare([]byte(nonce), []byte(v.nonceForPeriod(p))) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// setNonceHeader hands out a fresh nonce to a client that uses DPoP
+func (v *dpopVerifier) setNonceHeader(c *gin.Context) {
+	c.Header(dpopNonceHeader, v.nonce())
+}
+
+// verifyProof validates the DPoP proof of the request, if any, and returns the JWK SHA-256 thumbprint of the key it was signed with
+// accessToken is the token the request is authorized with, which the proof must be bound to through its ath claim; it is empty at the token endpoint
+// A request without a proof returns an empty thumbprint and no error
+func (v *dpopVerifier) verifyProof(ctx context.Context, r *http.Request, path string, accessToken string) (string, error) {
+	proofs := r.Header.Values(dpopHeader)
+	if len(proofs) == 0 {
+		return "", nil
+	}
+	if len(proofs) > 1 {
+		return "", errInvalidDPoPProof.WithHint("Only one DPoP proof may be sent.")
+	}
+	proof := []byte(proofs[0])
+
+	msg, err := jws.Parse(proof)
+	if err != nil {
+		return "", errInvalidDPoPProof.WithWrap(err)
+	}
+	if len(msg.Signatures()) != 1 {
+		return "", errInvalidDPoPProof.WithHint("The DPoP proof must have exactly one signature.")
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+
+	typ, _ := headers.Type()
+	if typ != dpopProofType {
+		return "", errInvalidDPoPProof.WithHintf("The DPoP proof must have the '%s' type.", dpopProofType)
+	}
+	alg, ok := headers.Algorithm()
+	if !ok || !slices.Contains(dpopSigningAlgorithms, alg) {
+		return "", errInvalidDPoPProof.WithHint("The DPoP proof is signed with an unsupported algorithm.")
+	}
+	key, ok := headers.JWK()
+	if !ok {
+		return "", errInvalidDPoPProof.WithHint("The DPoP proof must contain the public key it was signed with.")
+	}
+	if key.KeyType() == jwa.OctetSeq() {
+		return "", errInvalidDPoPProof.WithHint("The DPoP proof must be signed with an asymmetric key.")
+	}
+	isPrivate, err := jwk.IsPrivateKey(key)
+	if err != nil || isPrivate {
+		return "", errInvalidDPoPProof.WithHint("The DPoP proof must not contain a private key.")
+	}
+
+	token, err := jwt.Parse(proof, jwt.WithKey(alg, key), jwt.WithValidate(false))
+	if err != nil {
+		return "", errInvalidDPoPProof.WithWrap(err)
+	}
+
+	err = v.validateClaims(r, path, accessToken, token)
+	if err != nil {
+		return "", err
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", errInvalidDPoPProof.WithWrap(err)
+	}
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	// A proof can only be used once; it is recorded until it's too old to be accepted anyway
+	jti, _ := token.JwtID()
+	iat, _ := token.IssuedAt()
+	jtiKey := dpopJTIKey(jkt, jti)
+	err = v.jtis.ClientAssertionJWTValid(ctx, jtiKey)
+	if err != nil {
+		return "", errInvalidDPoPProof.WithHint("The DPoP proof has already been used.").WithWrap(err)
+	}
+	err = v.jtis.SetClientAssertionJWT(ctx, jtiKey, iat.Add(dpopProofMaxAge+dpopProofClockSkew))
+	if err != nil {
+		return "", errInvalidDPoPProof.WithHint("The DPoP proof has already been used.").WithWrap(err)
+	}
+
+	return jkt, nil
+}
+
+// validateClaims checks the claims of a proof whose signature was already verified, as described by RFC 9449 section 4.3
+func (v *dpopVerifier) validateClaims(r *http.Request, path string, accessToken string, token jwt.Token) error {
+	jti, _ := token.JwtID()
+	if jti == "" {
+		return errInvalidDPoPProof.WithHint("The DPoP proof is missing the jti claim.")
+	}
+
+	var htm string
+	if token.Get("htm", &htm) != nil || htm != r.Method {
+		return errInvalidDPoPProof.WithHint("The htm claim of the DPoP proof doesn't match the HTTP method of the request.")
+	}
+
+	var htu string
+	if token.Get("htu", &htu) != nil || !v.matchesEndpoint(htu, path) {
+		return errInvalidDPoPProof.WithHint("The htu claim of the DPoP proof doesn't match the URL of the request.")
+	}
+
+	iat, ok := token.IssuedAt()
+	now := time.Now()
+	if !ok || iat.Before(now.Add(-dpopProofMaxAge)) || iat.After(now.Add(dpopProofClockSkew)) {
+		return errInvalidDPoPProof.WithHint("The DPoP proof is expired or not yet valid.")
+	}
+
+	var nonce string
+	if token.Get("nonce", &nonce) != nil || !v.isValidNonce(nonce) {
+		return errUseDPoPNonce
+	}
+
+	if accessToken != "" {
+		var ath string
+		if token.Get("ath", &ath) != nil || ath != dpopAccessTokenHash(accessToken) {
+			return errInvalidDPoPProof.WithHint("The ath claim of the DPoP proof doesn't match the access token.")
+		}
+	}
+
+	return nil
+}
+
+// matchesEndpoint compares the htu claim with the endpoint's URL, ignoring the query and fragment as required by RFC 9449 section 4.3
+func (v *dpopVerifier) matchesEndpoint(htu string, path string) bool {
+	parsed, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+
+	for _, baseURL := range v.baseURLs {
+		expected, err := url.Parse(baseURL + path)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(parsed.Scheme, expected.Scheme) && strings.EqualFold(parsed.Host, expected.Host) && parsed.EscapedPath() == expected.EscapedPath() {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyTokenBinding checks that an access token presented to one of Pocket ID's protected endpoints is used the way it was issued
+// A DPoP-bound token must come with the DPoP scheme and a proof signed with the bound key, while a bearer token may not be sent with the DPoP scheme
+func (v *dpopVerifier) verifyTokenBinding(ctx context.Context, r *http.Request, path string, accessToken string, scheme string, session *Session) error {
+	jkt := session.dpopKeyThumbprint()
+	if jkt == "" {
+		if scheme == dpopTokenType {
+			return fosite.ErrRequestUnauthorized.WithDescription("The access token is not DPoP-bound and must be presented as a bearer token.")
+		}
+		return nil
+	}
+
+	// Accepting a bound token as a plain bearer token would defeat the binding, see RFC 9449 section 7.2
+	if scheme != dpopTokenType {
+		return fosite.ErrRequestUnauthorized.WithDescription("The access token is DPoP-bound and must be presented with the DPoP authorization scheme.")
+	}
+
+	proofJKT, err := v.verifyProof(ctx, r, path, accessToken)
+	if err != nil {
+		return err
+	}
+	if proofJKT == "" {
+		return errInvalidDPoPProof.WithHint("The DPoP-bound access token must be accompanied by a DPoP proof.")
+	}
+	if proofJKT != jkt {
+		return errInvalidDPoPProof.WithHint("The DPoP proof is signed with a different key than the access token is bound to.")
+	}
+
+	return nil
+}
+
+// bindDPoPKey binds the tokens about to be issued to the key of the request's DPoP proof, enforcing the client's DPoP policy
+func bindDPoPKey(client Client, accessRequest fosite.AccessRequester, session *Session, jkt string) error {
+	if jkt == "" && client.RequiresDpop {
+		return errInvalidDPoPProof.WithHint("The client must use DPoP-bound tokens.")
+	}
+
+	// The refresh tokens of public clients stay bound to the key they were first issued for, as required by RFC 9449 section 5
+	// Confidential clients authenticate on every refresh, so they may switch keys, or go back to bearer tokens
+	boundJKT := session.dpopKeyThumbprint()
+	if accessRequest.GetGrantTypes().Has(string(fosite.GrantTypeRefreshToken)) && client.IsPublic() && boundJKT != "" && boundJKT != jkt {
+		return errInvalidDPoPProof.WithHint("The refresh token is bound to a different DPoP key.")
+	}
+
+	session.setDPoPKeyThumbprint(jkt)
+	return nil
+}
+
+// accessTokenFromRequest returns the access token of the request along with the authorization scheme it was sent with
+// fosite.AccessTokenFromRequest only knows about the Bearer scheme
+func accessTokenFromRequest(r *http.Request) (token string, scheme string) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, dpopTokenType) {
+		return token, dpopTokenType
+	}
+	return fosite.AccessTokenFromRequest(r), fosite.BearerAccessToken
+}
+
+// isDPoPError reports whether the error must be answered with a DPoP challenge
+func isDPoPError(err error) bool {
+	rfcErr := fosite.ErrorToRFC6749Error(err)
+	return rfcErr.ErrorField == errInvalidDPoPProof.ErrorField || rfcErr.ErrorField == errUseDPoPNonce.ErrorField
+}
+
+// writeDPoPChallenge answers a request to a protected resource that failed DPoP validation, as described by RFC 9449 section 7.1
+func writeDPoPChallenge(c *gin.Context, err error) {
+	rfcErr := fosite.ErrorToRFC6749Error(err)
+	c.Header("WWW-Authenticate", fmt.Sprintf(`DPoP error="%s", error_description="%s", algs="%s"`, rfcErr.ErrorField, rfcErr.GetDescription(), strings.Join(DPoPSigningAlgValuesSupported(), " ")))
+	c.JSON(http.StatusUnauthorized, rfcErr)
+}
+
+func dpopAccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func dpopJTIKey(jkt string, jti string) string {
+	// The jti is chosen by the client, so it's hashed together with the key to keep keys of different clients apart and bound the length
+	sum := sha256.Sum256([]byte(jkt + ":" + jti))
+	return dpopJTIPrefix + base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,348 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+type dpopProofOptions struct {
+	typ         string
+	method      string
+	url         string
+	nonce       string
+	accessToken string
+}
+
+// newDPoPProof signs a DPoP proof the way a client would, with the public key embedded in the header
+func newDPoPProof(t *testing.T, key *ecdsa.PrivateKey, opts dpopProofOptions) string {
+	t.Helper()
+
+	builder := jwt.NewBuilder().
+		JwtID(uuid.NewString()).
+		IssuedAt(time.Now()).
+		Claim("htm", opts.method).
+		Claim("htu", opts.url)
+	if opts.nonce != "" {
+		builder = builder.Claim("nonce", opts.nonce)
+	}
+	if opts.accessToken != "" {
+		builder = builder.Claim("ath", dpopAccessTokenHash(opts.accessToken))
+	}
+	token, err := builder.Build()
+	require.NoError(t, err)
+
+	publicKey, err := jwk.Import(&key.PublicKey)
+	require.NoError(t, err)
+
+	typ := opts.typ
+	if typ == "" {
+		typ = dpopProofType
+	}
+	headers := jws.NewHeaders()
+	require.NoError(t, headers.Set(jws.TypeKey, typ))
+	require.NoError(t, headers.Set(jws.JWKKey, publicKey))
+
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), key, jws.WithProtectedHeaders(headers)))
+	require.NoError(t, err)
+	return string(signed)
+}
+
+func dpopThumbprint(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	publicKey, err := jwk.Import(&key.PublicKey)
+	require.NoError(t, err)
+	thumbprint, err := publicKey.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+func TestDPoPVerifierProof(t *testing.T) {
+	const baseURL = "https://issuer.example.com"
+
+	db := testutils.NewDatabaseForTest(t)
+	verifier := newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL, "http://pocket-id.internal:1411")
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	validOptions := dpopProofOptions{
+		method: http.MethodPost,
+		url:    baseURL + tokenEndpointPath,
+		nonce:  verifier.nonce(),
+	}
+
+	verify := func(t *testing.T, proof string, accessToken string) (string, error) {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, tokenEndpointPath, nil)
+		if proof != "" {
+			req.Header.Set(dpopHeader, proof)
+		}
+		return verifier.verifyProof(t.Context(), req, tokenEndpointPath, accessToken)
+	}
+
+	requireDPoPError := func(t *testing.T, expected *fosite.RFC6749Error, err error) {
+		t.Helper()
+		require.Error(t, err)
+		require.Equal(t, expected.ErrorField, fosite.ErrorToRFC6749Error(err).ErrorField)
+	}
+
+	t.Run("request without a proof is not bound", func(t *testing.T) {
+		jkt, err := verify(t, "", "")
+		require.NoError(t, err)
+		require.Empty(t, jkt)
+	})
+
+	t.Run("valid proof returns the key thumbprint", func(t *testing.T) {
+		jkt, err := verify(t, newDPoPProof(t, clientKey, validOptions), "")
+		require.NoError(t, err)
+		require.Equal(t, dpopThumbprint(t, clientKey), jkt)
+	})
+
+	t.Run("proof for another base URL of the server is accepted", func(t *testing.T) {
+		opts := validOptions
+		opts.url = "http://pocket-id.internal:1411" + tokenEndpointPath + "?ignored=true"
+		_, err := verify(t, newDPoPProof(t, clientKey, opts), "")
+		require.NoError(t, err)
+	})
+
+	t.Run("replayed proof is rejected", func(t *testing.T) {
+		proof := newDPoPProof(t, clientKey, validOptions)
+		_, err := verify(t, proof, "")
+		require.NoError(t, err)
+
+		_, err = verify(t, proof, "")
+		requireDPoPError(t, errInvalidDPoPProof, err)
+	})
+
+	t.Run("proof without a nonce asks for one", func(t *testing.T) {
+		opts := validOptions
+		opts.nonce = ""
+		_, err := verify(t, newDPoPProof(t, clientKey, opts), "")
+		requireDPoPError(t, errUseDPoPNonce, err)
+
+		opts.nonce = "made-up-nonce"
+		_, err = verify(t, newDPoPProof(t, clientKey, opts), "")
+		requireDPoPError(t, errUseDPoPNonce, err)
+	})
+
+	t.Run("proof for another request is rejected", func(t *testing.T) {
+		opts := validOptions
+		opts.method = http.MethodGet
+		_, err := verify(t, newDPoPProof(t, clientKey, opts), "")
+		requireDPoPError(t, errInvalidDPoPProof, err)
+
+		opts = validOptions
+		opts.url = baseURL + userInfoEndpointPath
+		_, err = verify(t, newDPoPProof(t, clientKey, opts), "")
+		requireDPoPError(t, errInvalidDPoPProof, err)
+	})
+
+	t.Run("proof must have the DPoP type", func(t *testing.T) {
+		opts := validOptions
+		opts.typ = "JWT"
+		_, err := verify(t, newDPoPProof(t, clientKey, opts), "")
+		requireDPoPError(t, errInvalidDPoPProof, err)
+	})
+
+	t.Run("proof must be bound to the access token it is sent with", func(t *testing.T) {
+		opts := validOptions
+		opts.accessToken = "other-access-token"
+		_, err := verify(t, newDPoPProof(t, clientKey, opts), "the-access-token")
+		requireDPoPError(t, errInvalidDPoPProof, err)
+
+		opts.accessToken = "the-access-token"
+		_, err = verify(t, newDPoPProof(t, clientKey, opts), "the-access-token")
+		require.NoError(t, err)
+	})
+}
+
+func TestTokenHandlerIssuesDPoPBoundTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL     = "https://issuer.example.com"
+		clientPlain = "cc-secret-value"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	for _, client := range []model.OidcClient{
+		{Base: model.Base{ID: "optional-dpop"}, Name: "Optional DPoP", Credentials: testClientCredentials(clientPlain)},
+		{Base: model.Base{ID: "required-dpop"}, Name: "Required DPoP", Credentials: testClientCredentials(clientPlain), RequiresDpop: true},
+	} {
+		require.NoError(t, db.Create(&client).Error)
+	}
+
+	provider, err := newProvider(NewStore(db, nil), nil, testTokenSigner{key: signerKey}, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+	verifier := newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, verifier, provider.tlsClientAuth, nil, nil)
+
+	requestToken := func(t *testing.T, clientID string, proof string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{"grant_type": {"client_credentials"}}
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, tokenEndpointPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, clientPlain)
+		if proof != "" {
+			req.Header.Set(dpopHeader, proof)
+		}
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		handler.token(c)
+		return rec
+	}
+
+	t.Run("proof without a nonce is answered with a nonce", func(t *testing.T) {
+		rec := requestToken(t, "optional-dpop", newDPoPProof(t, clientKey, dpopProofOptions{method: http.MethodPost, url: baseURL + tokenEndpointPath}))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.NotEmpty(t, rec.Header().Get(dpopNonceHeader))
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, "use_dpop_nonce", body["error"])
+	})
+
+	t.Run("access token is bound to the proof's key", func(t *testing.T) {
+		rec := requestToken(t, "optional-dpop", newDPoPProof(t, clientKey, dpopProofOptions{method: http.MethodPost, url: baseURL + tokenEndpointPath, nonce: verifier.nonce()}))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, dpopTokenType, body["token_type"])
+
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		require.Equal(t, map[string]any{"jkt": dpopThumbprint(t, clientKey)}, claims["cnf"])
+	})
+
+	t.Run("bearer token is issued without a proof", func(t *testing.T) {
+		rec := requestToken(t, "optional-dpop", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.True(t, strings.EqualFold(fosite.BearerAccessToken, body["token_type"].(string)))
+		require.NotContains(t, decodeJWTPart(t, body["access_token"].(string), 1), "cnf")
+	})
+
+	t.Run("client that requires DPoP is refused a bearer token", func(t *testing.T) {
+		rec := requestToken(t, "required-dpop", "")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, "invalid_dpop_proof", body["error"])
+	})
+}
+
+func TestUserInfoHandlerEnforcesDPoPBinding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const baseURL = "https://issuer.example.com"
+
+	db := testutils.NewDatabaseForTest(t)
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: "user-1"}, Username: "user-1"}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "client-1"}, Name: "Client"}).Error)
+
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	provider, err := newProvider(NewStore(db, nil).WithIssuer(baseURL), nil, testTokenSigner{key: signerKey}, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+	verifier := newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL)
+	handler := newUserInfoHandler(provider, newClaimsService(db, nil, baseURL, nil), baseURL, verifier, provider.tlsClientAuth, nil, provider.responseEncrypter)
+
+	session := NewEmptySession()
+	session.Subject = "user-1"
+	session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(time.Hour))
+	session.setDPoPKeyThumbprint(dpopThumbprint(t, clientKey))
+
+	request := fosite.NewAccessRequest(session)
+	request.ID = "dpop-request"
+	request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: "client-1"}}}
+	request.GrantTypes = fosite.Arguments{string(fosite.GrantTypeAuthorizationCode)}
+	request.RequestedScope = fosite.Arguments{"openid"}
+	request.GrantedScope = fosite.Arguments{"openid"}
+	request.RequestedAudience = fosite.Arguments{"client-1"}
+	request.GrantedAudience = fosite.Arguments{"client-1"}
+	response, err := provider.NewAccessResponse(t.Context(), request)
+	require.NoError(t, err)
+	accessToken := response.GetAccessToken()
+
+	callUserInfo := func(t *testing.T, scheme string, proofKey *ecdsa.PrivateKey) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, userInfoEndpointPath, nil)
+		req.Header.Set("Authorization", scheme+" "+accessToken)
+		if proofKey != nil {
+			req.Header.Set(dpopHeader, newDPoPProof(t, proofKey, dpopProofOptions{
+				method:      http.MethodGet,
+				url:         baseURL + userInfoEndpointPath,
+				nonce:       verifier.nonce(),
+				accessToken: accessToken,
+			}))
+		}
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		handler.userInfo(c)
+		return rec
+	}
+
+	t.Run("bound token can't be used as a bearer token", func(t *testing.T) {
+		rec := callUserInfo(t, "Bearer", nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("proof must be signed with the bound key", func(t *testing.T) {
+		rec := callUserInfo(t, dpopTokenType, otherKey)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.True(t, strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "DPoP "))
+	})
+
+	t.Run("bound token is accepted with a proof of the bound key", func(t *testing.T) {
+		rec := callUserInfo(t, dpopTokenType, clientKey)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NotEmpty(t, rec.Header().Get(dpopNonceHeader))
+	})
+}
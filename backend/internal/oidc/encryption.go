@@ -0,0 +1,165 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+)
+
+// EncryptionAlgValuesSupported returns the key management algorithms ID tokens and userinfo responses can be encrypted with, for the *_encryption_alg_values_supported server metadata
+func EncryptionAlgValuesSupported() []string {
+	return []string{
+		jwa.RSA_OAEP().String(),
+		jwa.RSA_OAEP_256().String(),
+		jwa.ECDH_ES().String(),
+		jwa.ECDH_ES_A128KW().String(),
+		jwa.ECDH_ES_A192KW().String(),
+		jwa.ECDH_ES_A256KW().String(),
+	}
+}
+
+// EncryptionEncValuesSupported returns the content encryption algorithms ID tokens and userinfo responses can be encrypted with, for the *_encryption_enc_values_supported server metadata
+func EncryptionEncValuesSupported() []string {
+	return []string{
+		jwa.A128CBC_HS256().String(),
+		jwa.A192CBC_HS384().String(),
+		jwa.A256CBC_HS512().String(),
+		jwa.A128GCM().String(),
+		jwa.A192GCM().String(),
+		jwa.A256GCM().String(),
+	}
+}
+
+// responseEncrypter encrypts signed responses with the public keys of the client, producing the nested JWTs of OpenID Connect Core section 16.14
+type responseEncrypter struct {
+	keys *federatedClientAuthenticator
+}
+
+func newResponseEncrypter(keys *federatedClientAuthenticator) *responseEncrypter {
+	return &responseEncrypter{keys: keys}
+}
+
+// encrypt wraps a signed JWT in a JWE for the client, using the client's key that fits the algorithm
+func (e *responseEncrypter) encrypt(ctx context.Context, client Client, signedJWT string, algName string, encName string) (string, error) {
+	alg, ok := jwa.LookupKeyEncryptionAlgorithm(algName)
+	if !ok {
+		return "", fmt.Errorf("unknown key encryption algorithm '%s'", algName)
+	}
+	enc, ok := jwa.LookupContentEncryptionAlgorithm(encName)
+	if !ok {
+		return "", fmt.Errorf("unknown content encryption algorithm '%s'", encName)
+	}
+
+	key, err := e.encryptionKey(ctx, client, alg)
+	if err != nil {
+		return "", err
+	}
+
+	// The content type tells the client that the payload is a signed JWT
+	headers := jwe.NewHeaders()
+	err = headers.Set(jwe.ContentTypeKey, "JWT")
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := jwe.Encrypt([]byte(signedJWT),
+		jwe.WithKey(alg, key),
+		jwe.WithContentEncryption(enc),
+		jwe.WithProtectedHeaders(headers),
+		jwe.WithCompact(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt response: %w", err)
+	}
+	return string(encrypted), nil
+}
+
+// encryptionKey returns the first key of the client's JWKS that can be used with the algorithm
+// Keys meant for signatures, or restricted to another algorithm, are skipped
+func (e *responseEncrypter) encryptionKey(ctx context.Context, client Client, alg jwa.KeyEncryptionAlgorithm) (jwk.Key, error) {
+	keySet, err := e.clientKeySet(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keySet.Len() {
+		key, ok := keySet.Key(i)
+		if !ok {
+			continue
+		}
+		if use, ok := key.KeyUsage(); ok && use != jwk.ForEncryption.String() {
+			continue
+		}
+		if keyAlg, ok := key.Algorithm(); ok && keyAlg.String() != alg.String() {
+			continue
+		}
+		if !keyTypeFitsAlgorithm(key.KeyType(), alg) {
+			continue
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("the client has no key for the encryption algorithm '%s'", alg.String())
+}
+
+// clientKeySet returns the client's registered JWKS, or else the key set published at its JWKS URI
+func (e *responseEncrypter) clientKeySet(ctx context.Context, client Client) (jwk.Set, error) {
+	credentials := client.Credentials
+	if credentials.JWKS != "" {
+		keySet, err := jwk.ParseString(credentials.JWKS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client JWKS: %w", err)
+		}
+		return keySet, nil
+	}
+	if credentials.JWKSURI == "" {
+		return nil, errors.New("the client has no keys registered to encrypt responses")
+	}
+	if e.keys == nil {
+		return nil, errors.New("fetching client keys is not available")
+	}
+	return e.keys.fetchJWKSet(ctx, credentials.JWKSURI, client.IsSelfRegistered())
+}
+
+// keyTypeFitsAlgorithm reports whether a key of the given type can be used with the key management algorithm
+func keyTypeFitsAlgorithm(keyType jwa.KeyType, alg jwa.KeyEncryptionAlgorithm) bool {
+	switch alg {
+	case jwa.RSA_OAEP(), jwa.RSA_OAEP_256():
+		return keyType == jwa.RSA()
+	case jwa.ECDH_ES(), jwa.ECDH_ES_A128KW(), jwa.ECDH_ES_A192KW(), jwa.ECDH_ES_A256KW():
+		return keyType == jwa.EC() || keyType == jwa.OKP()
+	default:
+		return false
+	}
+}
+
+// encryptingIDTokenStrategy encrypts the ID tokens of the clients that registered an ID token encryption algorithm
+// The ID token is signed first, so the client gets a nested JWT
+type encryptingIDTokenStrategy struct {
+	openid.OpenIDConnectTokenStrategy
+	encrypter *responseEncrypter
+}
+
+func (s *encryptingIDTokenStrategy) GenerateIDToken(ctx context.Context, lifespan time.Duration, requester fosite.Requester) (string, error) {
+	idToken, err := s.OpenIDConnectTokenStrategy.GenerateIDToken(ctx, lifespan, requester)
+	if err != nil {
+		return "", err
+	}
+
+	client, ok := requester.GetClient().(Client)
+	if !ok || client.IDTokenEncryptedResponseAlg == "" {
+		return idToken, nil
+	}
+
+	encrypted, err := s.encrypter.encrypt(ctx, client, idToken, client.IDTokenEncryptedResponseAlg, client.IDTokenEncryptedResponseEnc)
+	if err != nil {
+		return "", fosite.ErrServerError.WithHint("The ID token could not be encrypted.").WithWrap(err)
+	}
+	return encrypted, nil
+}
@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// testClientJWKS returns a JWKS with the public parts of the keys, each with the given key ID and usage
+func testClientJWKS(t *testing.T, keys map[string]any, usage map[string]string) string {
+	t.Helper()
+
+	set := jwk.NewSet()
+	for kid, key := range keys {
+		publicKey, err := jwk.PublicKeyOf(key)
+		require.NoError(t, err)
+		require.NoError(t, publicKey.Set(jwk.KeyIDKey, kid))
+		if use, ok := usage[kid]; ok {
+			require.NoError(t, publicKey.Set(jwk.KeyUsageKey, use))
+		}
+		require.NoError(t, set.AddKey(publicKey))
+	}
+
+	raw, err := json.Marshal(set)
+	require.NoError(t, err)
+	return string(raw)
+}
+
+func TestResponseEncrypter(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	client := Client{OidcClient: model.OidcClient{
+		Base: model.Base{ID: "client-1"},
+		Credentials: model.OidcClientCredentials{
+			JWKS: testClientJWKS(t,
+				map[string]any{"rsa-enc": rsaKey, "ec-enc": ecKey, "ec-sig": signingKey},
+				map[string]string{"rsa-enc": "enc", "ec-sig": "sig"},
+			),
+		},
+	}}
+	encrypter := newResponseEncrypter(nil)
+
+	tests := []struct {
+		name       string
+		alg        jwa.KeyEncryptionAlgorithm
+		enc        jwa.ContentEncryptionAlgorithm
+		key        any
+		expectedID string
+	}{
+		{name: "RSA-OAEP-256", alg: jwa.RSA_OAEP_256(), enc: jwa.A128CBC_HS256(), key: rsaKey, expectedID: "rsa-enc"},
+		{name: "ECDH-ES", alg: jwa.ECDH_ES(), enc: jwa.A256GCM(), key: ecKey, expectedID: "ec-enc"},
+		{name: "ECDH-ES+A128KW", alg: jwa.ECDH_ES_A128KW(), enc: jwa.A128GCM(), key: ecKey, expectedID: "ec-enc"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encrypted, err := encrypter.encrypt(t.Context(), client, "signed.jwt.value", test.alg.String(), test.enc.String())
+			require.NoError(t, err)
+
+			msg, err := jwe.Parse([]byte(encrypted))
+			require.NoError(t, err)
+			headers := msg.ProtectedHeaders()
+			kid, _ := headers.KeyID()
+			assert.Equal(t, test.expectedID, kid)
+			cty, _ := headers.ContentType()
+			assert.Equal(t, "JWT", cty)
+
+			decrypted, err := jwe.Decrypt([]byte(encrypted), jwe.WithKey(test.alg, test.key))
+			require.NoError(t, err)
+			assert.Equal(t, "signed.jwt.value", string(decrypted))
+		})
+	}
+
+	t.Run("signing keys are not used for encryption", func(t *testing.T) {
+		client := Client{OidcClient: model.OidcClient{
+			Credentials: model.OidcClientCredentials{
+				JWKS: testClientJWKS(t, map[string]any{"ec-sig": signingKey}, map[string]string{"ec-sig": "sig"}),
+			},
+		}}
+		_, err := encrypter.encrypt(t.Context(), client, "signed.jwt.value", jwa.ECDH_ES().String(), jwa.A128GCM().String())
+		require.ErrorContains(t, err, "no key for the encryption algorithm")
+	})
+
+	t.Run("client without keys", func(t *testing.T) {
+		_, err := encrypter.encrypt(t.Context(), Client{}, "signed.jwt.value", jwa.RSA_OAEP().String(), jwa.A128GCM().String())
+		require.Error(t, err)
+	})
+}
+
+type staticIDTokenStrategy string
+
+func (s staticIDTokenStrategy) GenerateIDToken(context.Context, time.Duration, fosite.Requester) (string, error) {
+	return string(s), nil
+}
+
+func TestEncryptingIDTokenStrategy(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	strategy := &encryptingIDTokenStrategy{
+		OpenIDConnectTokenStrategy: staticIDTokenStrategy("signed.id.token"),
+		encrypter:                  newResponseEncrypter(nil),
+	}
+	requestFor := func(client model.OidcClient) fosite.Requester {
+		request := fosite.NewRequest()
+		request.Client = Client{OidcClient: client}
+		return request
+	}
+
+	t.Run("ID token is only signed by default", func(t *testing.T) {
+		idToken, err := strategy.GenerateIDToken(t.Context(), time.Hour, requestFor(model.OidcClient{}))
+		require.NoError(t, err)
+		assert.Equal(t, "signed.id.token", idToken)
+	})
+
+	t.Run("ID token is encrypted for clients that registered an algorithm", func(t *testing.T) {
+		idToken, err := strategy.GenerateIDToken(t.Context(), time.Hour, requestFor(model.OidcClient{
+			IDTokenEncryptedResponseAlg: jwa.RSA_OAEP().String(),
+			IDTokenEncryptedResponseEnc: jwa.A128CBC_HS256().String(),
+			Credentials: model.OidcClientCredentials{
+				JWKS: testClientJWKS(t, map[string]any{"rsa-enc": rsaKey}, nil),
+			},
+		}))
+		require.NoError(t, err)
+
+		decrypted, err := jwe.Decrypt([]byte(idToken), jwe.WithKey(jwa.RSA_OAEP(), rsaKey))
+		require.NoError(t, err)
+		assert.Equal(t, "signed.id.token", string(decrypted))
+	})
+
+	t.Run("ID token is not issued if it can't be encrypted", func(t *testing.T) {
+		_, err := strategy.GenerateIDToken(t.Context(), time.Hour, requestFor(model.OidcClient{
+			IDTokenEncryptedResponseAlg: jwa.RSA_OAEP().String(),
+			IDTokenEncryptedResponseEnc: jwa.A128CBC_HS256().String(),
+		}))
+		require.ErrorIs(t, err, fosite.ErrServerError)
+	})
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 	"github.com/pocket-id/pocket-id/backend/internal/utils/cookie"
 )
 
@@ -28,7 +29,7 @@ func (h *endSessionHandler) endSession(c *gin.Context) {
 		return
 	}
 
-	callbackURL, err := h.endSessionService.endSession(c.Request.Context(), input, c.GetString("userID"))
+	result, err := h.endSessionService.endSession(c.Request.Context(), input, c.GetString("userID"))
 	if err != nil {
 		slog.WarnContext(c.Request.Context(), "Error getting logout callback URL, the user has to confirm the logout manually", "error", err)
 		c.Redirect(http.StatusFound, h.baseURL+"/logout")
@@ -36,12 +37,33 @@ func (h *endSessionHandler) endSession(c *gin.Context) {
 	}
 
 	cookie.AddAccessTokenCookie(c, 0, "")
-	if callbackURL == "" {
-		c.Redirect(http.StatusFound, h.baseURL+"/logout")
+	redirectURL := h.baseURL + "/logout"
+	if result.CallbackURL != "" {
+		redirectURL = appendStateToURL(result.CallbackURL, input.State)
+	}
+
+	if len(result.FrontchannelLogoutURIs) > 0 {
+		h.renderFrontchannelLogout(c, redirectURL, result.FrontchannelLogoutURIs)
 		return
 	}
 
-	c.Redirect(http.StatusFound, appendStateToURL(callbackURL, input.State))
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// renderFrontchannelLogout renders a page that loads the clients' front-channel logout URIs before it continues to the redirect URL
+func (h *endSessionHandler) renderFrontchannelLogout(c *gin.Context, redirectURL string, logoutURIs []string) {
+	c.Header("Content-Security-Policy", utils.BuildFrontchannelLogoutCSP(utils.GetCSPNonce(c), frontchannelLogoutFrameSources(logoutURIs), frontchannelLogoutScriptCSPHash))
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	err := frontchannelLogoutTemplate.Execute(c.Writer, frontchannelLogoutPage{
+		RedirectURL: redirectURL,
+		LogoutURIs:  logoutURIs,
+	})
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to render the front-channel logout page", slog.Any("error", err))
+	}
 }
 
 func bindEndSessionRequest(c *gin.Context) (dto.OidcLogoutDto, error) {
@@ -3,6 +3,7 @@ package oidc
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"net/url"
 	"time"
 
@@ -16,57 +17,77 @@ import (
 )
 
 type endSessionService struct {
-	db      *gorm.DB
-	store   *Store
-	signer  TokenSigner
-	baseURL string
+	db                *gorm.DB
+	store             *Store
+	signer            TokenSigner
+	baseURL           string
+	backchannelLogout *backchannelLogoutService
+	subjects          *pairwiseSubjects
 }
 
-func newEndSessionService(db *gorm.DB, store *Store, signer TokenSigner, baseURL string) *endSessionService {
+func newEndSessionService(db *gorm.DB, store *Store, signer TokenSigner, baseURL string, backchannelLogout *backchannelLogoutService, subjects *pairwiseSubjects) *endSessionService {
 	return &endSessionService{
-		db:      db,
-		store:   store,
-		signer:  signer,
-		baseURL: baseURL,
+		db:                db,
+		store:             store,
+		signer:            signer,
+		baseURL:           baseURL,
+		backchannelLogout: backchannelLogout,
+		subjects:          subjects,
 	}
 }
 
+// endSessionResult tells the handler where to send the user after the logout.
+type endSessionResult struct {
+	// CallbackURL is the client's post-logout callback URL, empty if none is configured
+	CallbackURL string
+	// FrontchannelLogoutURIs must be loaded by the user agent before it follows the callback URL
+	FrontchannelLogoutURIs []string
+}
+
 // endSession revokes the sessions belonging to the ID token hint and returns the
 // client's post-logout callback URL (empty if none is configured).
-func (s *endSessionService) endSession(ctx context.Context, input dto.OidcLogoutDto, userID string) (string, error) {
+func (s *endSessionService) endSession(ctx context.Context, input dto.OidcLogoutDto, userID string) (endSessionResult, error) {
 	if input.IdTokenHint == "" {
-		return "", apperror.TokenInvalid()
+		return endSessionResult{}, apperror.TokenInvalid()
 	}
 
 	token, err := s.verifyIDTokenHint(input.IdTokenHint)
 	if err != nil {
-		return "", apperror.TokenInvalid()
+		return endSessionResult{}, apperror.TokenInvalid()
 	}
 
 	clientIDs, ok := token.Audience()
 	if !ok || len(clientIDs) == 0 {
-		return "", apperror.TokenInvalid()
+		return endSessionResult{}, apperror.TokenInvalid()
 	}
 	clientID := clientIDs[0]
 	if input.ClientId != "" && clientID != input.ClientId {
-		return "", apperror.OidcClientIDNotMatching()
+		return endSessionResult{}, apperror.OidcClientIDNotMatching()
 	}
 
 	subject, ok := token.Subject()
 	if !ok || subject == "" {
-		return "", apperror.TokenInvalid()
+		return endSessionResult{}, apperror.TokenInvalid()
 	}
-	if userID != "" && subject != userID {
-		return "", apperror.TokenInvalid()
+	subjectUserID, err := s.subjectUserID(ctx, clientID, subject)
+	if err != nil {
+		return endSessionResult{}, err
+	}
+	if userID != "" && subjectUserID != userID {
+		return endSessionResult{}, apperror.TokenInvalid()
 	}
-	userID = subject
+	userID = subjectUserID
 
 	idTokenJTI, ok := token.JwtID()
 	if !ok {
-		return "", apperror.TokenInvalid()
+		return endSessionResult{}, apperror.TokenInvalid()
 	}
 
-	var callbackURL string
+	// The sid claim is only present on ID tokens issued in a browser session
+	var sessionID string
+	_ = token.Get("sid", &sessionID)
+
+	var result endSessionResult
 	err = withTx(ctx, s.db, func(ctx context.Context) error {
 		var authorizedClient model.UserAuthorizedOidcClient
 		err := dbFromContext(ctx, s.db).
@@ -80,7 +101,12 @@ func (s *endSessionService) endSession(ctx context.Context, input dto.OidcLogout
 			return err
 		}
 
-		callbackURL, err = logoutCallbackURL(&authorizedClient.Client, input.PostLogoutRedirectUri)
+		result.CallbackURL, err = logoutCallbackURL(&authorizedClient.Client, input.PostLogoutRedirectUri)
+		if err != nil {
+			return err
+		}
+
+		result.FrontchannelLogoutURIs, err = s.frontchannelLogoutURIs(ctx, userID, sessionID)
 		if err != nil {
 			return err
 		}
@@ -88,14 +114,86 @@ func (s *endSessionService) endSession(ctx context.Context, input dto.OidcLogout
 		return s.store.RevokeSessionsByIDTokenHint(ctx, userID, clientID, idTokenJTI)
 	})
 	if err != nil {
+		return endSessionResult{}, err
+	}
+
+	// The user is signing out of Pocket ID, so every client they use is told about it, not only the one that started the logout
+	err = s.backchannelLogout.notifyUserLogout(ctx, userID, sessionID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to send backchannel logout notifications", slog.Any("error", err))
+	}
+
+	return result, nil
+}
+
+// subjectUserID resolves the subject of the ID token hint to the ID of the user, as the subject is pairwise if the client is
+func (s *endSessionService) subjectUserID(ctx context.Context, clientID string, subject string) (string, error) {
+	var client model.OidcClient
+	err := s.db.
+		WithContext(ctx).
+		First(&client, "id = ?", clientID).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", apperror.TokenInvalid()
+	} else if err != nil {
 		return "", err
 	}
 
-	return callbackURL, nil
+	userID, found, err := s.subjects.userIDFor(ctx, s.db, client, subject)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", apperror.TokenInvalid()
+	}
+	return userID, nil
+}
+
+// frontchannelLogoutURIs returns the front-channel logout URIs of every client the user has authorized, with the iss and sid parameters added for the clients that require them
+func (s *endSessionService) frontchannelLogoutURIs(ctx context.Context, userID string, sessionID string) ([]string, error) {
+	var clients []model.OidcClient
+	err := dbFromContext(ctx, s.db).
+		Model(&model.OidcClient{}).
+		Joins("JOIN user_authorized_oidc_clients uac ON uac.client_id = oidc_clients.id").
+		Where("uac.user_id = ?", userID).
+		Where("oidc_clients.frontchannel_logout_uri IS NOT NULL AND oidc_clients.frontchannel_logout_uri <> ''").
+		Order("oidc_clients.id").
+		Find(&clients).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	uris := make([]string, 0, len(clients))
+	for _, client := range clients {
+		logoutURI, err := url.Parse(*client.FrontchannelLogoutURI)
+		if err != nil {
+			slog.WarnContext(ctx, "Ignoring invalid front-channel logout URI", slog.String("client_id", client.ID), slog.Any("error", err))
+			continue
+		}
+
+		// OpenID Connect Front-Channel Logout 1.0 section 2 requires both parameters together, so a session the sid is unknown for can't be logged out with them
+		if client.FrontchannelLogoutSessionRequired && sessionID != "" {
+			q := logoutURI.Query()
+			q.Set("iss", s.baseURL)
+			q.Set("sid", sessionID)
+			logoutURI.RawQuery = q.Encode()
+		}
+
+		uris = append(uris, logoutURI.String())
+	}
+
+	return uris, nil
 }
 
 func (s *endSessionService) verifyIDTokenHint(tokenString string) (jwt.Token, error) {
-	alg, err := s.signer.GetKeyAlg()
+	return verifyIDTokenHint(s.signer, s.baseURL, tokenString)
+}
+
+// verifyIDTokenHint verifies an ID token Pocket ID issued, which a client passes back as a hint of the user it's about
+func verifyIDTokenHint(signer TokenSigner, issuer string, tokenString string) (jwt.Token, error) {
+	// The ID token may be signed with any of the active keys, depending on the client
+	verificationKey, err := verificationKeyForToken(signer, tokenString)
 	if err != nil {
 		return nil, err
 	}
@@ -103,10 +201,10 @@ func (s *endSessionService) verifyIDTokenHint(tokenString string) (jwt.Token, er
 	token, err := jwt.ParseString(
 		tokenString,
 		jwt.WithValidate(true),
-		jwt.WithKey(alg, s.signer.GetPrivateKey()),
+		verificationKey,
 		jwt.WithAcceptableSkew(time.Minute),
 		jwt.WithResetValidators(true),
-		jwt.WithIssuer(s.baseURL),
+		jwt.WithIssuer(issuer),
 		jwt.WithValidator(jwt.IsIssuedAtValid()),
 		jwt.WithValidator(jwt.IsNbfValid()),
 	)
@@ -95,6 +95,7 @@ func TestEndSessionService(t *testing.T) {
 		subject     string
 		audience    string
 		jti         string
+		sid         string
 		omitSubject bool
 		omitJTI     bool
 		omitAud     bool
@@ -118,6 +119,9 @@ func TestEndSessionService(t *testing.T) {
 		if !opts.omitType {
 			builder = builder.Claim(common.TokenTypeClaim, idTokenType)
 		}
+		if opts.sid != "" {
+			builder = builder.Claim("sid", opts.sid)
+		}
 		token, err := builder.Build()
 		require.NoError(t, err)
 		signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), key))
@@ -136,7 +140,7 @@ func TestEndSessionService(t *testing.T) {
 		require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}, Username: "tim"}).Error)
 		require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: userID, ClientID: clientID}).Error)
 		store := NewStore(db, nil)
-		return newEndSessionService(db, store, signer, baseURL), store
+		return newEndSessionService(db, store, signer, baseURL, nil, nil), store
 	}
 
 	validToken := tokenOptions{issuer: baseURL, subject: userID, audience: clientID, jti: jti}
@@ -216,9 +220,9 @@ func TestEndSessionService(t *testing.T) {
 		require.NoError(t, store.CreateAccessTokenSession(t.Context(), "at-sig", newTestRequester("logout-req", clientID, userID, jti)))
 
 		token := signToken(t, validToken)
-		callback, err := service.endSession(t.Context(), dto.OidcLogoutDto{IdTokenHint: token}, userID)
+		result, err := service.endSession(t.Context(), dto.OidcLogoutDto{IdTokenHint: token}, userID)
 		require.NoError(t, err)
-		require.Equal(t, "https://app.example/logout", callback)
+		require.Equal(t, "https://app.example/logout", result.CallbackURL)
 
 		var refresh OAuth2Session
 		require.NoError(t, service.db.First(&refresh, "kind = ? AND key = ?", sessionKindRefreshToken, "rt-sig").Error)
@@ -240,9 +244,9 @@ func TestEndSessionService(t *testing.T) {
 		require.NoError(t, store.CreateAccessTokenSession(t.Context(), "at-other", newTestRequester("other-req", clientID, userID, "other-id-token-jti")))
 
 		token := signToken(t, validToken)
-		callback, err := service.endSession(t.Context(), dto.OidcLogoutDto{IdTokenHint: token}, userID)
+		result, err := service.endSession(t.Context(), dto.OidcLogoutDto{IdTokenHint: token}, userID)
 		require.NoError(t, err)
-		require.Equal(t, "https://app.example/logout", callback)
+		require.Equal(t, "https://app.example/logout", result.CallbackURL)
 
 		var matchingRefresh OAuth2Session
 		require.NoError(t, service.db.First(&matchingRefresh, "kind = ? AND key = ?", sessionKindRefreshToken, "rt-matching").Error)
@@ -274,9 +278,9 @@ func TestEndSessionService(t *testing.T) {
 		require.NoError(t, store.CreateAccessTokenSession(t.Context(), "at-no-session", newTestRequester("logout-req", clientID, userID, jti)))
 
 		token := signToken(t, validToken)
-		callback, err := service.endSession(t.Context(), dto.OidcLogoutDto{IdTokenHint: token}, "")
+		result, err := service.endSession(t.Context(), dto.OidcLogoutDto{IdTokenHint: token}, "")
 		require.NoError(t, err)
-		require.Equal(t, "https://app.example/logout", callback)
+		require.Equal(t, "https://app.example/logout", result.CallbackURL)
 
 		var refresh OAuth2Session
 		require.NoError(t, service.db.First(&refresh, "kind = ? AND key = ?", sessionKindRefreshToken, "rt-no-session").Error)
@@ -292,11 +296,50 @@ func TestEndSessionService(t *testing.T) {
 	t.Run("valid logout honors a registered post_logout_redirect_uri", func(t *testing.T) {
 		service, _ := newService(t)
 		token := signToken(t, validToken)
-		callback, err := service.endSession(t.Context(), dto.OidcLogoutDto{
+		result, err := service.endSession(t.Context(), dto.OidcLogoutDto{
 			IdTokenHint:           token,
 			PostLogoutRedirectUri: "https://app.example/logout",
 		}, userID)
 		require.NoError(t, err)
-		require.Equal(t, "https://app.example/logout", callback)
+		require.Equal(t, "https://app.example/logout", result.CallbackURL)
+		require.Empty(t, result.FrontchannelLogoutURIs)
+	})
+
+	t.Run("valid logout returns the front-channel logout URIs of every authorized client", func(t *testing.T) {
+		service, _ := newService(t)
+		require.NoError(t, service.db.Create(&model.OidcClient{
+			Base:                              model.Base{ID: "with-session"},
+			Name:                              "With session",
+			FrontchannelLogoutURI:             new("https://session.example/logout?foo=bar"),
+			FrontchannelLogoutSessionRequired: true,
+		}).Error)
+		require.NoError(t, service.db.Create(&model.OidcClient{
+			Base:                  model.Base{ID: "without-session"},
+			Name:                  "Without session",
+			FrontchannelLogoutURI: new("https://plain.example/logout"),
+		}).Error)
+		require.NoError(t, service.db.Create(&model.OidcClient{
+			Base:                  model.Base{ID: "not-authorized"},
+			Name:                  "Not authorized",
+			FrontchannelLogoutURI: new("https://other.example/logout"),
+		}).Error)
+		for _, id := range []string{"with-session", "without-session"} {
+			require.NoError(t, service.db.Create(&model.UserAuthorizedOidcClient{UserID: userID, ClientID: id}).Error)
+		}
+
+		tokenOpts := validToken
+		tokenOpts.sid = "browser-session"
+		result, err := service.endSession(t.Context(), dto.OidcLogoutDto{IdTokenHint: signToken(t, tokenOpts)}, userID)
+		require.NoError(t, err)
+		require.Len(t, result.FrontchannelLogoutURIs, 2)
+
+		withSession, err := url.Parse(result.FrontchannelLogoutURIs[0])
+		require.NoError(t, err)
+		require.Equal(t, "session.example", withSession.Host)
+		require.Equal(t, "bar", withSession.Query().Get("foo"))
+		require.Equal(t, baseURL, withSession.Query().Get("iss"))
+		require.Equal(t, "browser-session", withSession.Query().Get("sid"))
+
+		require.Equal(t, "https://plain.example/logout", result.FrontchannelLogoutURIs[1])
 	})
 }
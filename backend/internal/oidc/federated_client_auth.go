@@ -2,7 +2,9 @@ package oidc
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -17,9 +19,16 @@ import (
 	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/ory/fosite"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 )
 
-const clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" // #nosec G101 -- OAuth assertion type identifier, not a credential
+const (
+	clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" // #nosec G101 -- OAuth assertion type identifier, not a credential
+	// jwtBearerJTIPrefix namespaces the authorization grant IDs in the table that is shared with client assertions
+	jwtBearerJTIPrefix = "jwt-bearer:"
+)
 
 var errNoFederatedClientAssertion = errors.New("no federated client assertion")
 
@@ -33,9 +42,11 @@ type federatedClientStore interface {
 // federatedClientAuthenticator authenticates clients via JWT bearer assertions issued
 // by a federated identity provider configured per client.
 type federatedClientAuthenticator struct {
-	clients         federatedClientStore
-	httpClient      *http.Client
-	jwksCache       *jwk.Cache
+	clients    federatedClientStore
+	httpClient *http.Client
+	jwksCache  *jwk.Cache
+	// publicJWKSCache holds the key sets at URLs that third parties control, which it only ever fetches from public IP addresses
+	publicJWKSCache *jwk.Cache
 	defaultAudience string
 }
 
@@ -46,16 +57,22 @@ func newFederatedClientAuthenticator(ctx context.Context, clients federatedClien
 		defaultAudience: defaultAudience,
 	}
 
-	jwksCache, err := authenticator.getJWKCache(ctx)
+	jwksCache, err := authenticator.getJWKCache(ctx, false)
 	if err != nil {
 		return nil, err
 	}
 	authenticator.jwksCache = jwksCache
 
+	publicJWKSCache, err := authenticator.getJWKCache(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	authenticator.publicJWKSCache = publicJWKSCache
+
 	return authenticator, nil
 }
 
-func (a *federatedClientAuthenticator) getJWKCache(ctx context.Context) (*jwk.Cache, error) {
+func (a *federatedClientAuthenticator) getJWKCache(ctx context.Context, publicOnly bool) (*jwk.Cache, error) {
 	// We need to create a custom HTTP client to set a timeout.
 	client := a.httpClient
 	if client == nil {
@@ -72,6 +89,9 @@ func (a *federatedClientAuthenticator) getJWKCache(ctx context.Context) (*jwk.Ca
 		transport.TLSClientConfig.MinVersion = tls.VersionTLS12
 		client.Transport = transport
 	}
+	if publicOnly {
+		client = utils.PublicOnlyHTTPClient(client)
+	}
 
 	return jwk.NewCache(ctx,
 		httprc.NewClient(
@@ -81,9 +101,9 @@ func (a *federatedClientAuthenticator) getJWKCache(ctx context.Context) (*jwk.Ca
 	)
 }
 
-// newClientAuthenticationStrategy accepts federated client assertions before falling
-// back to fosite's default client authentication.
-func newClientAuthenticationStrategy(authenticator *federatedClientAuthenticator, provider *fosite.Fosite) fosite.ClientAuthenticationStrategy {
+// newClientAuthenticationStrategy accepts federated client assertions and TLS client
+// certificates before falling back to fosite's default client authentication.
+func newClientAuthenticationStrategy(authenticator *federatedClientAuthenticator, tlsAuthenticator *tlsClientAuthenticator, provider *fosite.Fosite) fosite.ClientAuthenticationStrategy {
 	return func(ctx context.Context, r *http.Request, form url.Values) (fosite.Client, error) {
 		client, err := authenticator.authenticateForm(ctx, form)
 		if err == nil {
@@ -93,6 +113,14 @@ func newClientAuthenticationStrategy(authenticator *federatedClientAuthenticator
 			return nil, err
 		}
 
+		client, err = tlsAuthenticator.authenticateForm(ctx, r, form)
+		if err == nil {
+			return client, nil
+		}
+		if !errors.Is(err, errNoTLSClientAuth) {
+			return nil, err
+		}
+
 		return provider.DefaultClientAuthenticationStrategy(ctx, r, form)
 	}
 }
@@ -143,12 +171,7 @@ func (a *federatedClientAuthenticator) authenticateAssertion(ctx context.Context
 		return nil, errNoFederatedClientAssertion
 	}
 
-	jwksURL := federatedIdentity.JWKS
-	if jwksURL == "" {
-		jwksURL = strings.TrimRight(issuer, "/") + "/.well-known/jwks.json"
-	}
-
-	jwks, err := a.fetchJWKSet(ctx, jwksURL)
+	jwks, err := a.fetchJWKSet(ctx, issuerJWKSURL(federatedIdentity.Issuer, federatedIdentity.JWKS), false)
 	if err != nil {
 		return nil, fosite.ErrInvalidClient.WithHint("Unable to fetch client assertion JWKS.").WithWrap(err)
 	}
@@ -196,8 +219,89 @@ func (a *federatedClientAuthenticator) authenticateAssertion(ctx context.Context
 	return client, nil
 }
 
-func (a *federatedClientAuthenticator) fetchJWKSet(ctx context.Context, jwksURL string) (jwk.Set, error) {
-	if !a.jwksCache.IsRegistered(ctx, jwksURL) {
+// verifyAuthorizationGrant validates a JWT presented with the JWT bearer grant against the client's JWT bearer grants, and returns the grant that maps it
+// Every failure is reported as invalid_grant, as required by RFC 7523 section 3.1
+func (a *federatedClientAuthenticator) verifyAuthorizationGrant(ctx context.Context, client Client, assertion string) (model.OidcClientJWTBearerGrant, error) {
+	rawAssertion := []byte(assertion)
+	insecureToken, err := jwt.ParseInsecure(rawAssertion)
+	if err != nil {
+		return model.OidcClientJWTBearerGrant{}, fosite.ErrInvalidGrant.WithHint("Invalid assertion.").WithWrap(err)
+	}
+
+	issuer, _ := insecureToken.Issuer()
+	subject, _ := insecureToken.Subject()
+	grant, ok := client.Credentials.JWTBearerGrantFor(issuer, subject)
+	if !ok {
+		return model.OidcClientJWTBearerGrant{}, fosite.ErrInvalidGrant.WithHint("The issuer and subject of the assertion are not trusted by the OAuth 2.0 Client.")
+	}
+
+	jwks, err := a.fetchJWKSet(ctx, issuerJWKSURL(grant.Issuer, grant.JWKS), false)
+	if err != nil {
+		return model.OidcClientJWTBearerGrant{}, fosite.ErrInvalidGrant.WithHint("Unable to fetch the assertion JWKS.").WithWrap(err)
+	}
+
+	audience := grant.Audience
+	if audience == "" {
+		audience = a.defaultAudience
+	}
+
+	parsed, err := jwt.Parse(rawAssertion,
+		jwt.WithValidate(true),
+		jwt.WithAcceptableSkew(30*time.Second),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+		jwt.WithIssuer(issuer),
+		jwt.WithSubject(subject),
+		jwt.WithAudience(audience),
+		jwt.WithKeySet(jwks, jws.WithInferAlgorithmFromKey(true), jws.WithUseDefault(true)),
+	)
+	if err != nil {
+		return model.OidcClientJWTBearerGrant{}, fosite.ErrInvalidGrant.WithHint("Invalid assertion.").WithWrap(err)
+	}
+
+	if grant.ReplayProtection {
+		jti, ok := parsed.JwtID()
+		if !ok || jti == "" {
+			return model.OidcClientJWTBearerGrant{}, fosite.ErrInvalidGrant.WithHint("Assertion is missing jti claim, which is required for replay protection.")
+		}
+		key := jwtBearerJTIKey(issuer, jti)
+		if err := a.clients.ClientAssertionJWTValid(ctx, key); err != nil {
+			return model.OidcClientJWTBearerGrant{}, fosite.ErrInvalidGrant.WithHint("Assertion has already been used.").WithWrap(err)
+		}
+		exp, _ := parsed.Expiration()
+		if err := a.clients.SetClientAssertionJWT(ctx, key, exp); err != nil {
+			return model.OidcClientJWTBearerGrant{}, fosite.ErrInvalidGrant.WithWrap(err)
+		}
+	}
+
+	return grant, nil
+}
+
+func jwtBearerJTIKey(issuer string, jti string) string {
+	// The jti is only unique per issuer, so it's hashed together with the issuer to keep issuers apart and bound the length
+	sum := sha256.Sum256([]byte(issuer + ":" + jti))
+	return jwtBearerJTIPrefix + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// issuerJWKSURL returns the URL of the keys of a trusted external issuer, which defaults to its well-known JWKS
+func issuerJWKSURL(issuer, jwksURL string) string {
+	if jwksURL != "" {
+		return jwksURL
+	}
+	return strings.TrimRight(issuer, "/") + "/.well-known/jwks.json"
+}
+
+// fetchJWKSet returns the key set published at the URL, which is refreshed in the background from then on
+// URLs a self-registered client chose are only fetched from public IP addresses, on the first fetch as well as on every refresh and redirect
+func (a *federatedClientAuthenticator) fetchJWKSet(ctx context.Context, jwksURL string, publicOnly bool) (jwk.Set, error) {
+	cache, httpClient := a.jwksCache, a.httpClient
+	if publicOnly {
+		cache = a.publicJWKSCache
+		if httpClient != nil {
+			httpClient = utils.PublicOnlyHTTPClient(httpClient)
+		}
+	}
+
+	if !cache.IsRegistered(ctx, jwksURL) {
 		// We set a timeout because otherwise Register will keep trying in case of errors
 		registerCtx, registerCancel := context.WithTimeout(ctx, 15*time.Second)
 		defer registerCancel()
@@ -207,19 +311,19 @@ func (a *federatedClientAuthenticator) fetchJWKSet(ctx context.Context, jwksURL
 			jwk.WithMinInterval(15 * time.Minute),
 			jwk.WithWaitReady(true),
 		}
-		if a.httpClient != nil {
-			registerOptions = append(registerOptions, jwk.WithHTTPClient(a.httpClient))
+		if httpClient != nil {
+			registerOptions = append(registerOptions, jwk.WithHTTPClient(httpClient))
 		}
 
 		// We need to register the URL
-		err := a.jwksCache.Register(registerCtx, jwksURL, registerOptions...)
+		err := cache.Register(registerCtx, jwksURL, registerOptions...)
 		// In case of race conditions (two goroutines calling jwkCache.Register at the same time), it's possible we can get a conflict anyways, so we ignore that error
 		if err != nil && !errors.Is(err, httprc.ErrResourceAlreadyExists()) {
 			return nil, fmt.Errorf("failed to register JWK set: %w", err)
 		}
 	}
 
-	jwks, err := a.jwksCache.CachedSet(jwksURL)
+	jwks, err := cache.CachedSet(jwksURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get cached JWK set: %w", err)
 	}
@@ -0,0 +1,70 @@
+package oidc
+
+import (
+	"html/template"
+	"net/url"
+)
+
+// frontchannelLogoutScript waits until every client's logout page has loaded, or gives up after a few seconds, and then continues to the post-logout redirect
+// Like the form_post page, it is allow-listed in the Content-Security-Policy via its SHA-256 hash (frontchannelLogoutScriptCSPHash), so the script body and the hash must stay byte-for-byte identical, which frontchannel_logout_test.go enforces
+const frontchannelLogoutScript = `(function () {
+var frames = document.querySelectorAll("iframe");
+var pending = frames.length;
+var done = false;
+function next() {
+if (done) return;
+done = true;
+window.location.replace(document.body.getAttribute("data-redirect"));
+}
+frames.forEach(function (frame) {
+frame.addEventListener("load", function () {
+pending--;
+if (pending <= 0) next();
+});
+});
+if (pending === 0) next();
+setTimeout(next, 5000);
+})()`
+
+// frontchannelLogoutScriptCSPHash is the CSP script-src source that allow-lists frontchannelLogoutScript
+var frontchannelLogoutScriptCSPHash = cspHashOf(frontchannelLogoutScript)
+
+// frontchannelLogoutTemplate renders the page that notifies clients through OpenID Connect Front-Channel Logout 1.0, by loading each client's logout URI in a hidden iframe
+// A <noscript> link lets the user continue if scripts are disabled, as the iframes are still loaded without them
+var frontchannelLogoutTemplate = template.Must(template.New("frontchannel_logout").Parse(
+	`<!DOCTYPE html>
+<html>
+<head><title>Signing Out</title></head>
+<body data-redirect="{{ .RedirectURL }}">
+{{- range .LogoutURIs }}
+<iframe src="{{ . }}" style="display:none" width="0" height="0"></iframe>
+{{- end }}
+<noscript><a href="{{ .RedirectURL }}">Continue</a></noscript>
+<script>` + frontchannelLogoutScript + `</script>
+</body>
+</html>`))
+
+type frontchannelLogoutPage struct {
+	RedirectURL string
+	LogoutURIs  []string
+}
+
+// frontchannelLogoutFrameSources returns the origins of the logout URIs, which the page's Content-Security-Policy must allow as frame sources
+func frontchannelLogoutFrameSources(logoutURIs []string) []string {
+	seen := make(map[string]struct{}, len(logoutURIs))
+	sources := make([]string, 0, len(logoutURIs))
+	for _, logoutURI := range logoutURIs {
+		parsed, err := url.Parse(logoutURI)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			continue
+		}
+
+		origin := parsed.Scheme + "://" + parsed.Host
+		if _, ok := seen[origin]; ok {
+			continue
+		}
+		seen[origin] = struct{}{}
+		sources = append(sources, origin)
+	}
+	return sources
+}
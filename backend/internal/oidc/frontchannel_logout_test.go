@@ -0,0 +1,55 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func renderFrontchannelLogout(t *testing.T, page frontchannelLogoutPage) string {
+	t.Helper()
+
+	var buf strings.Builder
+	err := frontchannelLogoutTemplate.Execute(&buf, page)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestFrontchannelLogoutTemplate(t *testing.T) {
+	html := renderFrontchannelLogout(t, frontchannelLogoutPage{
+		RedirectURL: "https://client.example.com/logged-out?state=abc",
+		LogoutURIs:  []string{"https://a.example.com/logout?iss=https%3A%2F%2Fissuer.example.com&sid=s1", "https://b.example.com/logout"},
+	})
+
+	assert.NotContains(t, html, "onload", "front-channel logout page must not rely on inline event handlers")
+	assert.Equal(t, 2, strings.Count(html, "<iframe "))
+	assert.Contains(t, html, `src="https://a.example.com/logout?iss=https%3A%2F%2Fissuer.example.com&amp;sid=s1"`)
+	assert.Contains(t, html, `data-redirect="https://client.example.com/logged-out?state=abc"`)
+}
+
+// frontchannelLogoutScriptCSPHash must match the inline script the template actually renders, otherwise the browser never continues to the redirect
+func TestFrontchannelLogoutScriptCSPHashMatchesRenderedScript(t *testing.T) {
+	html := renderFrontchannelLogout(t, frontchannelLogoutPage{RedirectURL: "https://client.example.com"})
+
+	matches := regexp.MustCompile(`(?s)<script>(.*?)</script>`).FindStringSubmatch(html)
+	require.Len(t, matches, 2, "rendered front-channel logout page must contain exactly one inline <script> block")
+
+	sum := sha256.Sum256([]byte(matches[1]))
+	want := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+	assert.Equal(t, want, frontchannelLogoutScriptCSPHash)
+}
+
+func TestFrontchannelLogoutFrameSources(t *testing.T) {
+	sources := frontchannelLogoutFrameSources([]string{
+		"https://a.example.com/logout?sid=1",
+		"https://a.example.com/other",
+		"http://b.example.com:8080/logout",
+		"not a url",
+	})
+	assert.Equal(t, []string{"https://a.example.com", "http://b.example.com:8080"}, sources)
+}
@@ -14,16 +14,21 @@ const (
 )
 
 type interactionSessionForUser struct {
-	ID            string                    `json:"id"`
-	Scopes        []string                  `json:"scopes"`
-	ScopeInfo     []dto.ScopeInfoDto        `json:"scopeInfo"`
-	Client        dto.OidcClientMetaDataDto `json:"client"`
-	CurrentStep   interactionStep           `json:"currentStep,omitempty"`
-	RequiredSteps []interactionStep         `json:"requiredSteps"`
+	ID                   string                           `json:"id"`
+	Scopes               []string                         `json:"scopes"`
+	OptionalScopes       []string                         `json:"optionalScopes"`
+	ScopeInfo            []dto.ScopeInfoDto               `json:"scopeInfo"`
+	AuthorizationDetails []dto.AuthorizationDetailInfoDto `json:"authorizationDetails"`
+	Claims               []string                         `json:"claims"`
+	Client               dto.OidcClientMetaDataDto        `json:"client"`
+	CurrentStep          interactionStep                  `json:"currentStep,omitempty"`
+	RequiredSteps        []interactionStep                `json:"requiredSteps"`
 }
 
 type completeInteractionRequest struct {
 	Step interactionStep `json:"step"`
+	// DeclinedScopes are the optional scopes the user unticked on the consent screen
+	DeclinedScopes []string `json:"declinedScopes"`
 }
 
 type completeInteractionResponse struct {
@@ -51,12 +56,20 @@ func newInteractionSessionForUser(interactionSession InteractionSession) (intera
 		requiredSteps = []interactionStep{}
 	}
 
+	optionalScopes := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if isOptionalScope(scope) {
+			optionalScopes = append(optionalScopes, scope)
+		}
+	}
+
 	return interactionSessionForUser{
-		ID:            interactionSession.ID,
-		Scopes:        scopes,
-		Client:        client,
-		CurrentStep:   currentStep,
-		RequiredSteps: requiredSteps,
+		ID:             interactionSession.ID,
+		Scopes:         scopes,
+		OptionalScopes: optionalScopes,
+		Client:         client,
+		CurrentStep:    currentStep,
+		RequiredSteps:  requiredSteps,
 	}, nil
 }
 
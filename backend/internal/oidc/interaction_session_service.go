@@ -54,13 +54,14 @@ func (s *interactionSessionService) update(ctx context.Context, interactionSessi
 		Model(&InteractionSession{}).
 		Where("id = ?", interactionSession.ID).
 		Updates(map[string]any{
-			"authentication_required":    interactionSession.AuthenticationRequired,
-			"account_selection_required": interactionSession.AccountSelectionRequired,
-			"reauthentication_required":  interactionSession.ReauthenticationRequired,
-			"consent_required":           interactionSession.ConsentRequired,
-			"user_id":                    interactionSession.UserID,
-			"reauthenticated_at":         interactionSession.ReauthenticatedAt,
-			"parameters":                 interactionSession.Parameters,
+			"authentication_required":        interactionSession.AuthenticationRequired,
+			"account_selection_required":     interactionSession.AccountSelectionRequired,
+			"reauthentication_required":      interactionSession.ReauthenticationRequired,
+			"consent_required":               interactionSession.ConsentRequired,
+			"user_id":                        interactionSession.UserID,
+			"reauthenticated_at":             interactionSession.ReauthenticatedAt,
+			"reauthentication_context_class": interactionSession.ReauthenticationContextClass,
+			"parameters":                     interactionSession.Parameters,
 		}).
 		Error
 }
@@ -2,8 +2,10 @@ package oidc
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -14,13 +16,21 @@ type introspectionHandler struct {
 	provider      fosite.OAuth2Provider
 	authenticator *federatedClientAuthenticator
 	baseURL       string
+	dpop          *dpopVerifier
+	mtls          *tlsClientAuthenticator
+	signer        TokenSigner
+	apiAccess     APIAccessProvider
 }
 
-func newIntrospectionHandler(provider fosite.OAuth2Provider, authenticator *federatedClientAuthenticator, baseURL string) *introspectionHandler {
+func newIntrospectionHandler(provider fosite.OAuth2Provider, authenticator *federatedClientAuthenticator, baseURL string, dpop *dpopVerifier, mtls *tlsClientAuthenticator, signer TokenSigner, apiAccess APIAccessProvider) *introspectionHandler {
 	return &introspectionHandler{
 		provider:      provider,
 		authenticator: authenticator,
 		baseURL:       baseURL,
+		dpop:          dpop,
+		mtls:          mtls,
+		signer:        signer,
+		apiAccess:     apiAccess,
 	}
 }
 
@@ -29,8 +39,9 @@ func newIntrospectionHandler(provider fosite.OAuth2Provider, authenticator *fede
 // @Description Pass a token to verify if it is considered valid.
 // @Tags OIDC
 // @Produce json
+// @Produce application/token-introspection+jwt
 // @Param token formData string true "The token to be introspected."
-// @Success 200 {object} object "Response with the introspection result."
+// @Success 200 {object} object "Response with the introspection result, as a signed JWT if requested with the Accept header or required by the API the token is for"
 // @Router /api/oidc/introspect [post]
 func (h *introspectionHandler) introspectToken(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -38,23 +49,43 @@ func (h *introspectionHandler) introspectToken(c *gin.Context) {
 	if h.tryFederatedClientAssertionIntrospection(c) {
 		return
 	}
+	if h.tryDPoPIntrospection(c) {
+		return
+	}
 
 	response, err := h.provider.NewIntrospectionRequest(ctx, c.Request, NewEmptySession())
-	if err != nil {
+	if errors.Is(err, fosite.ErrInactiveToken) {
+		// fosite authenticated the caller before looking at the token, and a caller asking for a JWT gets inactive tokens reported as one too
+		callerClientID, _ := h.callerClientID(ctx, c)
+		h.writeResponse(c, callerClientID, &fosite.IntrospectionResponse{Active: false})
+		return
+	} else if err != nil {
 		slog.ErrorContext(ctx, "Failed to create introspection request", "error", err)
 		h.provider.WriteIntrospectionError(ctx, c.Writer, err)
 		return
 	}
 
-	// A client may only introspect its own tokens. If it was issued to another client, report it as
-	// inactive instead of leaking its existence or contents.
+	// A token the caller may not introspect is reported as inactive instead of leaking its existence or contents
 	callerClientID, err := h.callerClientID(ctx, c)
-	if err != nil || callerClientID == "" || response.GetAccessRequester().GetClient().GetID() != callerClientID {
-		h.provider.WriteIntrospectionResponse(ctx, c.Writer, &fosite.IntrospectionResponse{Active: false})
+	if err != nil {
+		h.writeResponse(c, callerClientID, &fosite.IntrospectionResponse{Active: false})
+		return
+	}
+	allowed, err := h.mayIntrospect(ctx, callerClientID, response.GetTokenUse(), response.GetAccessRequester())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check whether the client may introspect the token", "error", err)
+		h.provider.WriteIntrospectionError(ctx, c.Writer, fosite.ErrServerError.WithWrap(err))
+		return
+	} else if !allowed {
+		h.writeResponse(c, callerClientID, &fosite.IntrospectionResponse{Active: false})
 		return
 	}
 
-	h.provider.WriteIntrospectionResponse(ctx, c.Writer, response)
+	if r, ok := response.(*fosite.IntrospectionResponse); ok {
+		setIntrospectedTokenType(r)
+	}
+	setIntrospectedSubject(response.GetAccessRequester())
+	h.writeResponse(c, callerClientID, response)
 }
 
 // tryFederatedClientAssertionIntrospection handles introspection requests authenticated
@@ -73,12 +104,66 @@ func (h *introspectionHandler) tryFederatedClientAssertionIntrospection(c *gin.C
 		return true
 	}
 
-	tokenUse, accessRequester, err := h.provider.IntrospectToken(ctx, c.PostForm("token"), fosite.TokenUse(c.PostForm("token_type_hint")), NewEmptySession(), strings.Fields(c.PostForm("scope"))...)
+	h.introspectForClient(c, client.GetID())
+	return true
+}
+
+// tryDPoPIntrospection handles introspection requests authenticated with a DPoP-bound access token, which fosite only accepts with the Bearer scheme
+func (h *introspectionHandler) tryDPoPIntrospection(c *gin.Context) bool {
+	ctx := c.Request.Context()
+	callerToken, scheme := accessTokenFromRequest(c.Request)
+	if scheme != dpopTokenType || callerToken == "" {
+		return false
+	}
+	h.dpop.setNonceHeader(c)
+
+	_, callerRequester, err := h.provider.IntrospectToken(ctx, callerToken, fosite.AccessToken, NewEmptySession())
 	if err != nil {
-		h.provider.WriteIntrospectionError(ctx, c.Writer, fosite.ErrInactiveToken.WithWrap(err))
+		h.provider.WriteIntrospectionError(ctx, c.Writer, fosite.ErrRequestUnauthorized.WithWrap(err))
 		return true
 	}
 
+	session, _ := callerRequester.GetSession().(*Session)
+	err = h.dpop.verifyTokenBinding(ctx, c.Request, introspectionEndpointPath, callerToken, scheme, session)
+	if err != nil {
+		if isDPoPError(err) {
+			writeDPoPChallenge(c, err)
+		} else {
+			h.provider.WriteIntrospectionError(ctx, c.Writer, err)
+		}
+		return true
+	}
+	err = h.mtls.verifyTokenBinding(c.Request, session)
+	if err != nil {
+		h.provider.WriteIntrospectionError(ctx, c.Writer, err)
+		return true
+	}
+
+	h.introspectForClient(c, callerRequester.GetClient().GetID())
+	return true
+}
+
+// introspectForClient writes the introspection response for a caller that was authenticated outside of fosite
+// Tokens the caller may not introspect are reported as inactive
+func (h *introspectionHandler) introspectForClient(c *gin.Context, clientID string) {
+	ctx := c.Request.Context()
+
+	tokenUse, accessRequester, err := h.provider.IntrospectToken(ctx, c.PostForm("token"), fosite.TokenUse(c.PostForm("token_type_hint")), NewEmptySession(), strings.Fields(c.PostForm("scope"))...)
+	if err != nil {
+		h.writeResponse(c, clientID, &fosite.IntrospectionResponse{Active: false})
+		return
+	}
+
+	allowed, err := h.mayIntrospect(ctx, clientID, tokenUse, accessRequester)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check whether the client may introspect the token", "error", err)
+		h.provider.WriteIntrospectionError(ctx, c.Writer, fosite.ErrServerError.WithWrap(err))
+		return
+	} else if !allowed {
+		h.writeResponse(c, clientID, &fosite.IntrospectionResponse{Active: false})
+		return
+	}
+
 	response := &fosite.IntrospectionResponse{
 		Active:          true,
 		AccessRequester: accessRequester,
@@ -87,14 +172,58 @@ func (h *introspectionHandler) tryFederatedClientAssertionIntrospection(c *gin.C
 	if tokenUse == fosite.AccessToken {
 		response.AccessTokenType = fosite.BearerAccessToken
 	}
+	setIntrospectedTokenType(response)
+	setIntrospectedSubject(accessRequester)
 
-	if accessRequester.GetClient().GetID() != client.GetID() {
-		h.provider.WriteIntrospectionResponse(ctx, c.Writer, &fosite.IntrospectionResponse{Active: false})
-		return true
+	h.writeResponse(c, clientID, response)
+}
+
+// mayIntrospect reports whether the caller may see the contents of the token
+// A client may introspect its own tokens, and the resource server of an API the access tokens audienced to that API, which it receives from other clients
+func (h *introspectionHandler) mayIntrospect(ctx context.Context, callerClientID string, tokenUse fosite.TokenUse, requester fosite.Requester) (bool, error) {
+	if callerClientID == "" || requester == nil {
+		return false, nil
+	}
+	if requester.GetClient().GetID() == callerClientID {
+		return true, nil
+	}
+	if h.apiAccess == nil || tokenUse != fosite.AccessToken {
+		return false, nil
 	}
 
-	h.provider.WriteIntrospectionResponse(ctx, c.Writer, response)
-	return true
+	audiences, err := h.apiAccess.ResourceServerAudiences(ctx, nil, callerClientID)
+	if err != nil {
+		return false, err
+	}
+	for _, audience := range requester.GetGrantedAudience() {
+		if slices.Contains(audiences, audience) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setIntrospectedTokenType reports DPoP-bound access tokens with the DPoP token type, next to the cnf claim that carries the binding
+func setIntrospectedTokenType(response *fosite.IntrospectionResponse) {
+	if response.TokenUse != fosite.AccessToken || response.AccessRequester == nil {
+		return
+	}
+	session, ok := response.AccessRequester.GetSession().(*Session)
+	if ok && session.dpopKeyThumbprint() != "" {
+		response.AccessTokenType = dpopTokenType
+	}
+}
+
+// setIntrospectedSubject reports the subject the access token carries, which is pairwise for pairwise clients, instead of the ID of the user
+// The session is discarded after the response, so its subject can be replaced
+func setIntrospectedSubject(requester fosite.Requester) {
+	if requester == nil {
+		return
+	}
+	session, ok := requester.GetSession().(*Session)
+	if ok && session.JWTClaims != nil && session.JWTClaims.Subject != "" {
+		session.Subject = session.JWTClaims.Subject
+	}
 }
 
 // callerClientID resolves the client that authenticated this introspection request.
@@ -104,6 +233,15 @@ func (h *introspectionHandler) callerClientID(ctx context.Context, c *gin.Contex
 		if err != nil {
 			return "", err
 		}
+		// A DPoP-bound token proves nothing without its proof, so it can't authenticate the caller as a bearer token
+		session, ok := accessRequester.GetSession().(*Session)
+		if ok && session.dpopKeyThumbprint() != "" {
+			return "", fosite.ErrRequestUnauthorized.WithDescription("The access token is DPoP-bound and must be presented with the DPoP authorization scheme.")
+		}
+		err = h.mtls.verifyTokenBinding(c.Request, session)
+		if err != nil {
+			return "", err
+		}
 		return accessRequester.GetClient().GetID(), nil
 	}
 
@@ -111,5 +249,6 @@ func (h *introspectionHandler) callerClientID(ctx context.Context, c *gin.Contex
 		return url.QueryUnescape(id)
 	}
 
-	return "", nil
+	// fosite only accepts introspection requests authenticated with an access token or HTTP basic authentication
+	return "", fosite.ErrRequestUnauthorized.WithDescription("The introspection request must be authenticated with an access token or HTTP basic authentication.")
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
@@ -70,7 +71,7 @@ func TestIntrospectionHandlerBindsTokenToCallerClient(t *testing.T) {
 	clientBToken := issueAccessToken(t, "req-b", "client-b", "user-b")
 	clientBOtherToken := issueAccessToken(t, "req-b-2", "client-b", "user-b")
 
-	handler := newIntrospectionHandler(provider, nil, "https://issuer.example.com")
+	handler := newIntrospectionHandler(provider, nil, "https://issuer.example.com", newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), "https://issuer.example.com"), provider.tlsClientAuth, nil, nil)
 
 	introspect := func(t *testing.T, bearer, token string) map[string]any {
 		t.Helper()
@@ -178,7 +179,7 @@ func TestIntrospectionHandlerAllowsReusedFederatedClientAssertion(t *testing.T)
 	signedAssertion, err := jwt.Sign(assertionToken, jwt.WithKey(signingAlg, signingKey))
 	require.NoError(t, err)
 
-	handler := newIntrospectionHandler(provider, authenticator, baseURL)
+	handler := newIntrospectionHandler(provider, authenticator, baseURL, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 	introspect := func(t *testing.T) (int, map[string]any) {
 		t.Helper()
 		body := url.Values{
@@ -207,3 +208,242 @@ func TestIntrospectionHandlerAllowsReusedFederatedClientAssertion(t *testing.T)
 	require.Equal(t, http.StatusOK, status)
 	require.Equal(t, true, body["active"])
 }
+
+func TestIntrospectionHandlerJWTResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL      = "https://issuer.example.com"
+		ordersAPI    = "https://api.orders.example.com"
+		inventoryAPI = "https://api.inventory.example.com"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "client-a"}, Name: "Client A"}).Error)
+
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer := testTokenSigner{key: signerKey}
+
+	// #nosec G101
+	provider, err := newProvider(NewStore(db, nil), nil, signer, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+
+	issueAccessToken := func(t *testing.T, requestID, audience string, scopes ...string) string {
+		t.Helper()
+		session := NewEmptySession()
+		session.Subject = "user-a"
+		session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(time.Hour))
+
+		request := fosite.NewAccessRequest(session)
+		request.ID = requestID
+		request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: "client-a"}}}
+		request.GrantTypes = fosite.Arguments{string(fosite.GrantTypeClientCredentials)}
+		request.RequestedScope = scopes
+		request.GrantedScope = scopes
+		request.RequestedAudience = fosite.Arguments{audience}
+		request.GrantedAudience = fosite.Arguments{audience}
+
+		response, err := provider.NewAccessResponse(t.Context(), request)
+		require.NoError(t, err)
+		return response.GetAccessToken()
+	}
+
+	ordersToken := issueAccessToken(t, "req-orders", ordersAPI, "read:orders")
+	inventoryToken := issueAccessToken(t, "req-inventory", inventoryAPI, "read:inventory")
+
+	apiAccess := fakeAPIAccess{
+		allowed: map[string]map[SubjectType][]string{
+			ordersAPI:    {SubjectTypeClient: {"read:orders", "write:orders"}},
+			inventoryAPI: {SubjectTypeClient: {"read:inventory"}},
+		},
+		jwtIntrospection: map[string]bool{inventoryAPI: true},
+	}
+	handler := newIntrospectionHandler(provider, nil, baseURL, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, signer, apiAccess)
+
+	introspect := func(t *testing.T, token, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := url.Values{"token": {token}}.Encode()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/introspect", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+ordersToken)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+
+		handler.introspectToken(c)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	parseResponse := func(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+		t.Helper()
+		require.Equal(t, contentTypeIntrospectionJWT, rec.Header().Get("Content-Type"))
+
+		msg, err := jws.Parse(rec.Body.Bytes())
+		require.NoError(t, err)
+		typ, _ := msg.Signatures()[0].ProtectedHeaders().Type()
+		require.Equal(t, introspectionJWTType, typ)
+
+		token, err := jwt.Parse(rec.Body.Bytes(), jwt.WithKey(jwa.ES256(), signerKey.Public()), jwt.WithIssuer(baseURL), jwt.WithAudience("client-a"))
+		require.NoError(t, err)
+		_, ok := token.IssuedAt()
+		require.True(t, ok)
+
+		var introspection map[string]any
+		require.NoError(t, token.Get(tokenIntrospectionClaim, &introspection))
+		return introspection
+	}
+
+	t.Run("JSON by default", func(t *testing.T) {
+		rec := introspect(t, ordersToken, "")
+		require.Contains(t, rec.Header().Get("Content-Type"), "application/json")
+
+		var out map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		require.Equal(t, true, out["active"])
+	})
+
+	t.Run("JWT when requested with the Accept header", func(t *testing.T) {
+		introspection := parseResponse(t, introspect(t, ordersToken, contentTypeIntrospectionJWT))
+		require.Equal(t, true, introspection["active"])
+		require.Equal(t, "client-a", introspection["client_id"])
+		require.Equal(t, "read:orders", introspection["scope"])
+		require.Equal(t, []any{"read:orders"}, introspection[permissionsClaim])
+	})
+
+	t.Run("JWT when required by the API", func(t *testing.T) {
+		introspection := parseResponse(t, introspect(t, inventoryToken, "application/json"))
+		require.Equal(t, true, introspection["active"])
+		require.Equal(t, []any{"read:inventory"}, introspection[permissionsClaim])
+	})
+
+	t.Run("inactive tokens are signed too", func(t *testing.T) {
+		introspection := parseResponse(t, introspect(t, "invalid-token", contentTypeIntrospectionJWT))
+		require.Equal(t, map[string]any{"active": false}, introspection)
+	})
+}
+
+// An API's resource server receives the access tokens of other clients, so it may introspect the ones audienced to its API
+func TestIntrospectionHandlerAllowsAPIResourceServer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL      = "https://issuer.example.com"
+		ordersAPI    = "https://api.orders.example.com"
+		inventoryAPI = "https://api.inventory.example.com"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	for _, clientID := range []string{"client-a", "orders-gateway", "inventory-gateway"} {
+		require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: clientID}, Name: clientID}).Error)
+	}
+
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer := testTokenSigner{key: signerKey}
+
+	// #nosec G101
+	provider, err := newProvider(NewStore(db, nil), nil, signer, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+
+	issueAccessToken := func(t *testing.T, requestID, clientID, audience string) string {
+		t.Helper()
+		session := NewEmptySession()
+		session.Subject = "user-a"
+		session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(time.Hour))
+
+		request := fosite.NewAccessRequest(session)
+		request.ID = requestID
+		request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: clientID}}}
+		request.GrantTypes = fosite.Arguments{string(fosite.GrantTypeClientCredentials)}
+		request.RequestedAudience = fosite.Arguments{audience}
+		request.GrantedAudience = fosite.Arguments{audience}
+
+		response, err := provider.NewAccessResponse(t.Context(), request)
+		require.NoError(t, err)
+		return response.GetAccessToken()
+	}
+
+	ordersToken := issueAccessToken(t, "req-orders", "client-a", ordersAPI)
+	loginToken := issueAccessToken(t, "req-login", "client-a", "client-a")
+	ordersGatewayToken := issueAccessToken(t, "req-orders-gateway", "orders-gateway", "orders-gateway")
+	inventoryGatewayToken := issueAccessToken(t, "req-inventory-gateway", "inventory-gateway", "inventory-gateway")
+
+	apiAccess := fakeAPIAccess{
+		allowed: map[string]map[SubjectType][]string{
+			ordersAPI:    {SubjectTypeClient: {}},
+			inventoryAPI: {SubjectTypeClient: {}},
+		},
+		resourceServers: map[string][]string{
+			"orders-gateway":    {ordersAPI},
+			"inventory-gateway": {inventoryAPI},
+		},
+	}
+	handler := newIntrospectionHandler(provider, nil, baseURL, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, signer, apiAccess)
+
+	introspect := func(t *testing.T, bearer, token, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := url.Values{"token": {token}}.Encode()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/introspect", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.Header.Set("Accept", accept)
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+
+		handler.introspectToken(c)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	t.Run("resource server of the token's API", func(t *testing.T) {
+		rec := introspect(t, ordersGatewayToken, ordersToken, "application/json")
+
+		var out map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		require.Equal(t, true, out["active"])
+		require.Equal(t, "client-a", out["client_id"])
+	})
+
+	t.Run("JWT response is audienced to the resource server", func(t *testing.T) {
+		rec := introspect(t, ordersGatewayToken, ordersToken, contentTypeIntrospectionJWT)
+		require.Equal(t, contentTypeIntrospectionJWT, rec.Header().Get("Content-Type"))
+
+		token, err := jwt.Parse(rec.Body.Bytes(), jwt.WithKey(jwa.ES256(), signerKey.Public()), jwt.WithIssuer(baseURL), jwt.WithAudience("orders-gateway"))
+		require.NoError(t, err)
+		var introspection map[string]any
+		require.NoError(t, token.Get(tokenIntrospectionClaim, &introspection))
+		require.Equal(t, true, introspection["active"])
+	})
+
+	t.Run("resource server of another API", func(t *testing.T) {
+		rec := introspect(t, inventoryGatewayToken, ordersToken, "application/json")
+
+		var out map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		require.Equal(t, false, out["active"])
+	})
+
+	t.Run("token that isn't audienced to an API", func(t *testing.T) {
+		rec := introspect(t, ordersGatewayToken, loginToken, "application/json")
+
+		var out map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		require.Equal(t, false, out["active"])
+	})
+}
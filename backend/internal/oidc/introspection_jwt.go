@@ -0,0 +1,199 @@
+package oidc
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/ory/fosite"
+)
+
+const (
+	// contentTypeIntrospectionJWT is the media type of introspection responses returned as a JWT, see RFC 9701 section 4
+	contentTypeIntrospectionJWT = "application/token-introspection+jwt"
+	// introspectionJWTType is the typ header of introspection responses returned as a JWT, see RFC 9701 section 5
+	introspectionJWTType = "token-introspection+jwt"
+
+	// tokenIntrospectionClaim holds the introspection response inside the JWT
+	tokenIntrospectionClaim = "token_introspection"
+	// permissionsClaim lists the permissions of the token's API that it was granted, which the scope member mixes with the identity scopes
+	permissionsClaim = "permissions"
+)
+
+// IntrospectionSigningAlgValuesSupported returns the algorithms introspection responses can be signed with, for the introspection_signing_alg_values_supported server metadata
+// They are always signed with the default signing key
+func IntrospectionSigningAlgValuesSupported(signer TokenSigner) []string {
+	alg, err := serverSigningAlgorithm(signer)
+	if err != nil {
+		return nil
+	}
+	return []string{alg.String()}
+}
+
+// writeResponse writes the introspection response as JSON, or as a JWT signed by Pocket ID when the caller asks for it or the API the token is for requires it
+func (h *introspectionHandler) writeResponse(c *gin.Context, callerClientID string, response fosite.IntrospectionResponder) {
+	ctx := c.Request.Context()
+
+	required, err := h.jwtResponseRequired(ctx, response)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check whether the introspection response must be a JWT", "error", err)
+		h.provider.WriteIntrospectionError(ctx, c.Writer, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	// The response depends on the Accept header, so caches must keep the formats apart
+	c.Header("Vary", "Accept")
+	if introspectionResponseFormat(c, required) != contentTypeIntrospectionJWT {
+		h.provider.WriteIntrospectionResponse(ctx, c.Writer, response)
+		return
+	}
+
+	signed, err := h.jwtResponse(ctx, callerClientID, response)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to sign introspection response", "error", err)
+		h.provider.WriteIntrospectionError(ctx, c.Writer, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentTypeIntrospectionJWT, []byte(signed))
+}
+
+// introspectionResponseFormat negotiates whether the introspection response is returned as JSON or as a JWT
+// A response the API requires as a JWT is never downgraded to JSON, as the resource server relies on the signature
+func introspectionResponseFormat(c *gin.Context, required bool) string {
+	if required {
+		return contentTypeIntrospectionJWT
+	}
+
+	// An Accept header that matches neither format gets JSON, like before negotiation was supported
+	return cmp.Or(c.NegotiateFormat(gin.MIMEJSON, contentTypeIntrospectionJWT), gin.MIMEJSON)
+}
+
+// jwtResponseRequired reports whether one of the APIs the token is audienced to requires introspection responses as a JWT
+func (h *introspectionHandler) jwtResponseRequired(ctx context.Context, response fosite.IntrospectionResponder) (bool, error) {
+	if h.apiAccess == nil || !response.IsActive() || response.GetAccessRequester() == nil {
+		return false, nil
+	}
+
+	for _, audience := range response.GetAccessRequester().GetGrantedAudience() {
+		required, err := h.apiAccess.IntrospectionJWTRequired(ctx, nil, audience)
+		if err != nil {
+			return false, err
+		}
+		if required {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// jwtResponse wraps the introspection response in a JWT for the caller, see RFC 9701 section 5
+func (h *introspectionHandler) jwtResponse(ctx context.Context, callerClientID string, response fosite.IntrospectionResponder) (string, error) {
+	introspection, err := h.introspectionMembers(ctx, response)
+	if err != nil {
+		return "", err
+	}
+
+	if response.IsActive() && response.GetAccessRequester() != nil {
+		permissions, err := h.grantedPermissions(ctx, response.GetAccessRequester())
+		if err != nil {
+			return "", err
+		}
+		if len(permissions) > 0 {
+			introspection[permissionsClaim] = permissions
+		}
+	}
+
+	builder := jwt.NewBuilder().
+		Issuer(h.baseURL).
+		IssuedAt(time.Now()).
+		Claim(tokenIntrospectionClaim, introspection)
+	// A caller that couldn't be identified only ever gets an inactive response, which isn't meant for anyone in particular
+	if callerClientID != "" {
+		builder = builder.Audience([]string{callerClientID})
+	}
+	token, err := builder.Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build introspection response: %w", err)
+	}
+
+	alg, err := serverSigningAlgorithm(h.signer)
+	if err != nil {
+		return "", err
+	}
+	headers := jws.NewHeaders()
+	err = headers.Set(jws.TypeKey, introspectionJWTType)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signJWTWithHeaders(h.signer, alg, token, headers)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign introspection response: %w", err)
+	}
+	return signed, nil
+}
+
+// introspectionMembers returns the members of the JSON introspection response fosite writes, so both formats carry exactly the same ones
+func (h *introspectionHandler) introspectionMembers(ctx context.Context, response fosite.IntrospectionResponder) (map[string]any, error) {
+	buffer := &responseBuffer{header: http.Header{}}
+	h.provider.WriteIntrospectionResponse(ctx, buffer, response)
+
+	decoder := json.NewDecoder(&buffer.body)
+	// Timestamps must stay integers instead of turning into floats
+	decoder.UseNumber()
+
+	var members map[string]any
+	err := decoder.Decode(&members)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+	return members, nil
+}
+
+// grantedPermissions returns the keys of the API permissions the token was granted for the APIs it's audienced to
+func (h *introspectionHandler) grantedPermissions(ctx context.Context, requester fosite.Requester) ([]string, error) {
+	if h.apiAccess == nil {
+		return nil, nil
+	}
+
+	var permissions []string
+	for _, audience := range requester.GetGrantedAudience() {
+		infos, err := h.apiAccess.DescribePermissions(ctx, audience, requester.GetGrantedScopes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the permissions of '%s': %w", audience, err)
+		}
+		for _, info := range infos {
+			if !slices.Contains(permissions, info.Key) {
+				permissions = append(permissions, info.Key)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// responseBuffer is an http.ResponseWriter that keeps the body in memory, to reuse what fosite writes
+type responseBuffer struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+func (b *responseBuffer) WriteHeader(int) {}
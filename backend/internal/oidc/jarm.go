@@ -0,0 +1,188 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/ory/fosite"
+)
+
+// Response modes of the JWT Secured Authorization Response Mode (JARM)
+const (
+	responseModeJWT         fosite.ResponseModeType = "jwt"
+	responseModeQueryJWT    fosite.ResponseModeType = "query.jwt"
+	responseModeFragmentJWT fosite.ResponseModeType = "fragment.jwt"
+	responseModeFormPostJWT fosite.ResponseModeType = "form_post.jwt"
+)
+
+// jarmResponseLifetime is how long a signed authorization response is valid
+// The client processes the response right after the redirect, so it can be short
+const jarmResponseLifetime = 5 * time.Minute
+
+// JARMResponseModesSupported returns the JWT response modes, for the response_modes_supported server metadata
+func JARMResponseModesSupported() []string {
+	return []string{string(responseModeJWT), string(responseModeQueryJWT), string(responseModeFragmentJWT), string(responseModeFormPostJWT)}
+}
+
+// AuthorizationSigningAlgValuesSupported returns the algorithms signed authorization responses can be signed with, for the authorization_signing_alg_values_supported server metadata
+func AuthorizationSigningAlgValuesSupported(signer TokenSigner) []string {
+	return signingAlgValuesSupported(signer)
+}
+
+// jarmResponseModeHandler delivers authorization responses and errors as a JWT signed by Pocket ID, as defined by JARM
+// fosite calls it for every response mode that it doesn't handle itself
+type jarmResponseModeHandler struct {
+	signer TokenSigner
+	issuer string
+}
+
+func newJARMResponseModeHandler(signer TokenSigner, issuer string) *jarmResponseModeHandler {
+	return &jarmResponseModeHandler{
+		signer: signer,
+		issuer: issuer,
+	}
+}
+
+func (h *jarmResponseModeHandler) ResponseModes() fosite.ResponseModeTypes {
+	return fosite.ResponseModeTypes{responseModeJWT, responseModeQueryJWT, responseModeFragmentJWT, responseModeFormPostJWT}
+}
+
+func (h *jarmResponseModeHandler) WriteAuthorizeResponse(ctx context.Context, rw http.ResponseWriter, ar fosite.AuthorizeRequester, resp fosite.AuthorizeResponder) {
+	h.write(ctx, rw, ar, resp.GetParameters())
+}
+
+func (h *jarmResponseModeHandler) WriteAuthorizeError(ctx context.Context, rw http.ResponseWriter, ar fosite.AuthorizeRequester, err error) {
+	params := fosite.ErrorToRFC6749Error(err).ToValues()
+	if state := ar.GetState(); state != "" {
+		params.Set("state", state)
+	}
+	h.write(ctx, rw, ar, params)
+}
+
+func (h *jarmResponseModeHandler) write(ctx context.Context, rw http.ResponseWriter, ar fosite.AuthorizeRequester, params url.Values) {
+	response, err := h.sign(ar, params)
+	if err != nil {
+		// The response can't be sent to the client without a signature, so the error is shown to the user instead
+		slog.ErrorContext(ctx, "Failed to sign authorization response", "error", err)
+		writeJARMServerError(rw)
+		return
+	}
+
+	redirectURI := *ar.GetRedirectURI()
+	switch jarmDeliveryMode(ar) {
+	case responseModeFormPostJWT:
+		rw.Header().Set("Content-Type", "text/html;charset=UTF-8")
+		fosite.WriteAuthorizeFormPostResponse(redirectURI.String(), url.Values{"response": {response}}, formPostTemplate, rw)
+	case responseModeFragmentJWT:
+		redirectURI.Fragment = url.Values{"response": {response}}.Encode()
+		redirectURI.RawFragment = ""
+		writeJARMRedirect(rw, redirectURI)
+	default:
+		query := redirectURI.Query()
+		query.Set("response", response)
+		redirectURI.RawQuery = query.Encode()
+		writeJARMRedirect(rw, redirectURI)
+	}
+}
+
+// sign wraps the authorization response parameters in a JWT for the client
+func (h *jarmResponseModeHandler) sign(ar fosite.AuthorizeRequester, params url.Values) (string, error) {
+	alg, err := h.signingAlgorithm(ar.GetClient())
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	builder := jwt.NewBuilder().
+		Issuer(h.issuer).
+		Audience([]string{ar.GetClient().GetID()}).
+		IssuedAt(now).
+		Expiration(now.Add(jarmResponseLifetime))
+	for key, values := range params {
+		// The issuer is a registered claim already, and RFC 9207 requires the same value
+		if key == "iss" || len(values) == 0 {
+			continue
+		}
+		builder = builder.Claim(key, values[0])
+	}
+	token, err := builder.Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build authorization response: %w", err)
+	}
+
+	signed, err := signJWT(h.signer, alg, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign authorization response: %w", err)
+	}
+	return signed, nil
+}
+
+// signingAlgorithm returns the algorithm the client registered for signed authorization responses, or the algorithm of the signing key
+func (h *jarmResponseModeHandler) signingAlgorithm(client fosite.Client) (jwa.SignatureAlgorithm, error) {
+	var registered string
+	if oidcClient, ok := client.(Client); ok {
+		registered = oidcClient.AuthorizationSignedResponseAlg
+	}
+	return registeredSigningAlgorithm(h.signer, registered)
+}
+
+// jarmDeliveryMode resolves the "jwt" response mode to the default mode of the response type, see JARM section 2.3.4
+func jarmDeliveryMode(ar fosite.AuthorizeRequester) fosite.ResponseModeType {
+	mode := ar.GetResponseMode()
+	if mode != responseModeJWT {
+		return mode
+	}
+	if ar.GetResponseTypes().ExactOne("code") {
+		return responseModeQueryJWT
+	}
+	return responseModeFragmentJWT
+}
+
+// isFormPostResponseMode reports whether the response is delivered by an auto-submitting HTML form
+func isFormPostResponseMode(mode fosite.ResponseModeType) bool {
+	return mode == fosite.ResponseModeFormPost || mode == responseModeFormPostJWT
+}
+
+func writeJARMRedirect(rw http.ResponseWriter, redirectURI url.URL) {
+	rw.Header().Set("Location", redirectURI.String())
+	rw.WriteHeader(http.StatusSeeOther)
+}
+
+func writeJARMServerError(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(rw).Encode(fosite.ErrServerError.WithHint("The authorization response could not be signed."))
+}
+
+// signingAlgorithmsForKey returns the JWS algorithms that can be used with a private key
+func signingAlgorithmsForKey(key any) []jwa.SignatureAlgorithm {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return []jwa.SignatureAlgorithm{jwa.RS256(), jwa.RS384(), jwa.RS512(), jwa.PS256(), jwa.PS384(), jwa.PS512()}
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return []jwa.SignatureAlgorithm{jwa.ES256()}
+		case elliptic.P384():
+			return []jwa.SignatureAlgorithm{jwa.ES384()}
+		case elliptic.P521():
+			return []jwa.SignatureAlgorithm{jwa.ES512()}
+		}
+	case ed25519.PrivateKey:
+		return []jwa.SignatureAlgorithm{jwa.EdDSA()}
+	}
+	return nil
+}
+
+var _ fosite.ResponseModeHandler = (*jarmResponseModeHandler)(nil)
@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+func TestJARMResponseModeHandler(t *testing.T) {
+	const issuer = "https://issuer.example.com"
+
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	handler := newJARMResponseModeHandler(testTokenSigner{key: signerKey}, issuer)
+
+	newRequest := func(mode fosite.ResponseModeType, client model.OidcClient) *fosite.AuthorizeRequest {
+		client.ID = "jarm-client"
+		ar := fosite.NewAuthorizeRequest()
+		ar.Client = Client{OidcClient: client}
+		ar.ResponseTypes = fosite.Arguments{"code"}
+		ar.ResponseMode = mode
+		ar.State = "state-value"
+		ar.RedirectURI = &url.URL{Scheme: "https", Host: "client.example.com", Path: "/callback", RawQuery: "tenant=1"}
+		return ar
+	}
+	newResponse := func() *fosite.AuthorizeResponse {
+		resp := fosite.NewAuthorizeResponse()
+		resp.AddParameter("code", "authorization-code")
+		resp.AddParameter("state", "state-value")
+		resp.AddParameter("iss", issuer)
+		return resp
+	}
+	verify := func(t *testing.T, response string) jwt.Token {
+		t.Helper()
+		token, err := jwt.ParseString(response,
+			jwt.WithKey(jwa.ES256(), &signerKey.PublicKey),
+			jwt.WithValidate(true),
+			jwt.WithIssuer(issuer),
+			jwt.WithAudience("jarm-client"),
+		)
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("jwt defaults to the query for the code response type", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.WriteAuthorizeResponse(t.Context(), rec, newRequest(responseModeJWT, model.OidcClient{}), newResponse())
+		require.Equal(t, http.StatusSeeOther, rec.Code)
+
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/callback", location.Path)
+		assert.Equal(t, "1", location.Query().Get("tenant"))
+		assert.False(t, location.Query().Has("code"), "the code must only be sent inside the JWT")
+
+		token := verify(t, location.Query().Get("response"))
+		var code, state string
+		require.NoError(t, token.Get("code", &code))
+		require.NoError(t, token.Get("state", &state))
+		assert.Equal(t, "authorization-code", code)
+		assert.Equal(t, "state-value", state)
+	})
+
+	t.Run("fragment.jwt", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.WriteAuthorizeResponse(t.Context(), rec, newRequest(responseModeFragmentJWT, model.OidcClient{}), newResponse())
+		require.Equal(t, http.StatusSeeOther, rec.Code)
+
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		fragment, err := url.ParseQuery(location.Fragment)
+		require.NoError(t, err)
+		verify(t, fragment.Get("response"))
+	})
+
+	t.Run("form_post.jwt", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.WriteAuthorizeResponse(t.Context(), rec, newRequest(responseModeFormPostJWT, model.OidcClient{}), newResponse())
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+
+		match := regexp.MustCompile(`name="response" value="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+		require.Len(t, match, 2)
+		verify(t, html.UnescapeString(match[1]))
+	})
+
+	t.Run("errors are signed as well", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.WriteAuthorizeError(t.Context(), rec, newRequest(responseModeQueryJWT, model.OidcClient{}), fosite.ErrAccessDenied)
+		require.Equal(t, http.StatusSeeOther, rec.Code)
+
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		token := verify(t, location.Query().Get("response"))
+		var errorCode, state string
+		require.NoError(t, token.Get("error", &errorCode))
+		require.NoError(t, token.Get("state", &state))
+		assert.Equal(t, "access_denied", errorCode)
+		assert.Equal(t, "state-value", state)
+	})
+
+	t.Run("algorithm the key can't sign with", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ar := newRequest(responseModeQueryJWT, model.OidcClient{AuthorizationSignedResponseAlg: "RS256"})
+		handler.WriteAuthorizeResponse(t.Context(), rec, ar, newResponse())
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, rec.Header().Get("Location"))
+	})
+}
+
+func TestAuthorizationSigningAlgValuesSupported(t *testing.T) {
+	signerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ES384"}, AuthorizationSigningAlgValuesSupported(testTokenSigner{key: signerKey}))
+}
@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/ory/fosite"
+	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
+)
+
+// jwtBearerGrantHandler implements the JWT bearer authorization grant of RFC 7523
+// A workload presents a JWT of an external issuer the client trusts, such as a Kubernetes service account token, and receives an access token for an API
+// The client's JWT bearer grants map the issuer and subject of the JWT either to a user, whose user-delegated API access then applies, or to the client itself, whose client access applies
+// The admin configuring the mapping to a user stands in for the user's consent, so no consent is asked for
+type jwtBearerGrantHandler struct {
+	assertions   *federatedClientAuthenticator
+	accessTokens fositeoauth2.AccessTokenStrategy
+	store        *Store
+	config       *fosite.Config
+}
+
+func newJWTBearerGrantHandler(assertions *federatedClientAuthenticator, accessTokens fositeoauth2.AccessTokenStrategy, store *Store, config *fosite.Config) *jwtBearerGrantHandler {
+	return &jwtBearerGrantHandler{
+		assertions:   assertions,
+		accessTokens: accessTokens,
+		store:        store,
+		config:       config,
+	}
+}
+
+func (h *jwtBearerGrantHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client, ok := requester.GetClient().(Client)
+	if !ok || !client.GetGrantTypes().Has(string(fosite.GrantTypeJWTBearer)) {
+		return fosite.ErrUnauthorizedClient.WithHint("The OAuth 2.0 Client is not allowed to use the JWT bearer grant.")
+	}
+
+	assertion := requester.GetRequestForm().Get("assertion")
+	if assertion == "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'assertion' parameter is missing.")
+	}
+	grant, err := h.assertions.verifyAuthorizationGrant(ctx, client, assertion)
+	if err != nil {
+		return err
+	}
+
+	// The grant only issues tokens for APIs, so the target must always be named
+	resource, err := requester.GetResource()
+	if err != nil {
+		return err
+	}
+	if resource == "" {
+		return fosite.ErrInvalidTarget.WithHint("The 'resource' parameter is missing.")
+	}
+
+	subjectType := SubjectTypeClient
+	if grant.UserID != "" {
+		subjectType = SubjectTypeUser
+	}
+	audience, grantedScopes, err := resolveResource(ctx, nil, h.store.apiAccess, client.GetID(), resource, requester.GetRequestedScopes(), subjectType)
+	if err != nil {
+		return err
+	}
+	// A workload never authenticated a user at Pocket ID, so its tokens must not reach the identity endpoints
+	grantedScopes = slices.DeleteFunc(grantedScopes, isStandardScope)
+	grantResourceIndicator(requester, audience, grantedScopes)
+
+	session, ok := requester.GetSession().(*Session)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The session must be *oidc.Session.")
+	}
+	// A grant mapped to the client itself leaves the subject empty, the token handler then assigns the client's subject as for the client credentials grant
+	if grant.UserID != "" {
+		session.Subject = grant.UserID
+		session.IDTokenClaims().Subject = grant.UserID
+	}
+	session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(fosite.GetEffectiveLifespan(client, fosite.GrantTypeJWTBearer, fosite.AccessToken, h.config.GetAccessTokenLifespan(ctx))))
+
+	return nil
+}
+
+func (h *jwtBearerGrantHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	return issueAccessToken(ctx, h.accessTokens, h.store, requester, responder)
+}
+
+func (h *jwtBearerGrantHandler) CanSkipClientAuthentication(context.Context, fosite.AccessRequester) bool {
+	return false
+}
+
+func (h *jwtBearerGrantHandler) CanHandleTokenEndpointRequest(_ context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(string(fosite.GrantTypeJWTBearer))
+}
+
+var _ fosite.TokenEndpointHandler = (*jwtBearerGrantHandler)(nil)
@@ -0,0 +1,230 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	jwkutils "github.com/pocket-id/pocket-id/backend/internal/utils/jwk"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestTokenHandlerJWTBearerGrant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL       = "https://issuer.example.com"
+		clientID      = "workload-client"
+		clientPlain   = "workload-secret-value"
+		workloadIss   = "https://kubernetes.example.com"
+		workloadJWKS  = "https://kubernetes.example.com/openid/v1/jwks"
+		ordersAPI     = "https://api.orders.example.com"
+		deployUserID  = "user-deploy"
+		deploySubject = "system:serviceaccount:ci:deployer"
+	)
+
+	workloadKey, err := jwkutils.GenerateKey(jwa.RS256().String(), "")
+	require.NoError(t, err)
+	workloadAlg, ok := workloadKey.Algorithm()
+	require.True(t, ok)
+	workloadPublicKey, err := workloadKey.PublicKey()
+	require.NoError(t, err)
+	workloadKeys := jwk.NewSet()
+	require.NoError(t, workloadKeys.AddKey(workloadPublicKey))
+
+	db := testutils.NewDatabaseForTest(t)
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: deployUserID}, Username: "deploy"}).Error)
+	credentials := testClientCredentials(clientPlain)
+	credentials.JWTBearerGrants = []model.OidcClientJWTBearerGrant{
+		{Issuer: workloadIss, JWKS: workloadJWKS, Subject: "system:serviceaccount:ci:*"},
+		{Issuer: workloadIss, JWKS: workloadJWKS, Subject: deploySubject, UserID: deployUserID},
+		{Issuer: workloadIss, JWKS: workloadJWKS, Subject: "system:serviceaccount:prod:*", ReplayProtection: true},
+	}
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:        model.Base{ID: clientID},
+		Name:        "Workload Client",
+		Credentials: credentials,
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:        model.Base{ID: "other-client"},
+		Name:        "Other Client",
+		Credentials: testClientCredentials(clientPlain),
+	}).Error)
+
+	apiAccess := fakeAPIAccess{allowed: map[string]map[SubjectType][]string{
+		ordersAPI: {
+			SubjectTypeClient: {"read:orders"},
+			SubjectTypeUser:   {"read:orders", "write:orders"},
+		},
+	}}
+
+	store := NewStore(db, apiAccess)
+	authenticator, err := newFederatedClientAuthenticator(t.Context(), store, newJWKSetHTTPClient(t, workloadKeys), baseURL)
+	require.NoError(t, err)
+	provider, err := newProvider(store, authenticator, testTokenSigner{key: signingKey}, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess, newDPoPVerifier(store, []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
+
+	signAssertion := func(t *testing.T, subject string, mutate func(b *jwt.Builder) *jwt.Builder) string {
+		t.Helper()
+		builder := jwt.NewBuilder().
+			Issuer(workloadIss).
+			Subject(subject).
+			Audience([]string{baseURL}).
+			IssuedAt(time.Now()).
+			Expiration(time.Now().Add(10 * time.Minute))
+		if mutate != nil {
+			builder = mutate(builder)
+		}
+		token, err := builder.Build()
+		require.NoError(t, err)
+		signed, err := jwt.Sign(token, jwt.WithKey(workloadAlg, workloadKey))
+		require.NoError(t, err)
+		return string(signed)
+	}
+
+	requestToken := func(t *testing.T, clientID string, form url.Values) map[string]any {
+		t.Helper()
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, tokenEndpointPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, clientPlain)
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		handler.token(c)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	t.Run("workload mapped to the client gets the client's API access", func(t *testing.T) {
+		body := requestToken(t, clientID, url.Values{
+			"assertion": {signAssertion(t, "system:serviceaccount:ci:builder", nil)},
+			"resource":  {ordersAPI},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		assert.Empty(t, body["refresh_token"])
+
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		assert.Equal(t, clientCredentialsSubjectPrefix+clientID, claims["sub"])
+		assert.Equal(t, []string{ordersAPI}, jwtAudience(claims))
+		assert.Equal(t, []string{"read:orders"}, jwtScopes(claims))
+	})
+
+	t.Run("workload mapped to a user acts for that user", func(t *testing.T) {
+		body := requestToken(t, clientID, url.Values{
+			"assertion": {signAssertion(t, deploySubject, nil)},
+			"resource":  {ordersAPI},
+			"scope":     {"openid write:orders"},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		assert.Equal(t, deployUserID, claims["sub"])
+		assert.Equal(t, []string{"write:orders"}, jwtScopes(claims), "identity scopes must be dropped from workload tokens")
+	})
+
+	t.Run("subject that no grant matches", func(t *testing.T) {
+		body := requestToken(t, clientID, url.Values{
+			"assertion": {signAssertion(t, "system:serviceaccount:default:builder", nil)},
+			"resource":  {ordersAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("assertion for another audience", func(t *testing.T) {
+		body := requestToken(t, clientID, url.Values{
+			"assertion": {signAssertion(t, "system:serviceaccount:ci:builder", func(b *jwt.Builder) *jwt.Builder {
+				return b.Audience([]string{"https://other.example.com"})
+			})},
+			"resource": {ordersAPI},
+		})
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("assertion signed by an unknown key", func(t *testing.T) {
+		otherKey, err := jwkutils.GenerateKey(jwa.RS256().String(), "")
+		require.NoError(t, err)
+		token, err := jwt.NewBuilder().
+			Issuer(workloadIss).
+			Subject("system:serviceaccount:ci:builder").
+			Audience([]string{baseURL}).
+			Expiration(time.Now().Add(10 * time.Minute)).
+			Build()
+		require.NoError(t, err)
+		signed, err := jwt.Sign(token, jwt.WithKey(workloadAlg, otherKey))
+		require.NoError(t, err)
+
+		body := requestToken(t, clientID, url.Values{
+			"assertion": {string(signed)},
+			"resource":  {ordersAPI},
+		})
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("replay protected assertion can only be used once", func(t *testing.T) {
+		assertion := signAssertion(t, "system:serviceaccount:prod:builder", func(b *jwt.Builder) *jwt.Builder {
+			return b.JwtID("workload-token-1")
+		})
+
+		body := requestToken(t, clientID, url.Values{"assertion": {assertion}, "resource": {ordersAPI}})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+
+		body = requestToken(t, clientID, url.Values{"assertion": {assertion}, "resource": {ordersAPI}})
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("replay protection is kept apart from client assertions", func(t *testing.T) {
+		require.NoError(t, store.SetClientAssertionJWT(t.Context(), "workload-token-2", time.Now().Add(10*time.Minute)))
+
+		body := requestToken(t, clientID, url.Values{
+			"assertion": {signAssertion(t, "system:serviceaccount:prod:builder", func(b *jwt.Builder) *jwt.Builder {
+				return b.JwtID("workload-token-2")
+			})},
+			"resource": {ordersAPI},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+	})
+
+	t.Run("request without a resource", func(t *testing.T) {
+		body := requestToken(t, clientID, url.Values{
+			"assertion": {signAssertion(t, "system:serviceaccount:ci:builder", nil)},
+		})
+		assert.Equal(t, "invalid_target", body["error"])
+	})
+
+	t.Run("client without JWT bearer grants", func(t *testing.T) {
+		body := requestToken(t, "other-client", url.Values{
+			"assertion": {signAssertion(t, "system:serviceaccount:ci:builder", nil)},
+			"resource":  {ordersAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "unauthorized_client", body["error"])
+	})
+}
@@ -22,6 +22,8 @@ type OAuth2Session struct {
 	Active               bool
 	RequestData          string
 	ExpiresAt            *datatype.DateTime
+	// RotatedAt is when a refresh token was exchanged for a new one, which starts its reuse grace window
+	RotatedAt *datatype.DateTime
 }
 
 func (OAuth2Session) TableName() string {
@@ -61,6 +63,8 @@ type InteractionSession struct {
 
 	RequestedAt       datatype.DateTime
 	ReauthenticatedAt *datatype.DateTime
+	// ReauthenticationContextClass is the authentication context class the user reauthenticated with
+	ReauthenticationContextClass string
 
 	Parameters InteractionSessionParameters
 }
@@ -74,3 +78,43 @@ func (p *InteractionSessionParameters) Scan(value any) error {
 func (p InteractionSessionParameters) Value() (driver.Value, error) {
 	return json.Marshal(p)
 }
+
+// cibaRequestStatus is the state of a backchannel authentication request, which only moves on from pending once the user decided
+type cibaRequestStatus string
+
+const (
+	cibaRequestStatusPending  cibaRequestStatus = "pending"
+	cibaRequestStatusApproved cibaRequestStatus = "approved"
+	cibaRequestStatusDenied   cibaRequestStatus = "denied"
+)
+
+// CIBARequest is a client-initiated backchannel authentication request, waiting for the user to approve or deny it in Pocket ID.
+// The request is deleted once the client fetched its tokens or learned it was denied.
+type CIBARequest struct {
+	model.Base
+
+	// AuthReqIDSignature is the hash of the auth_req_id the client fetches the tokens with; the auth_req_id itself is never stored
+	AuthReqIDSignature string
+
+	ClientID string
+	Client   model.OidcClient
+
+	UserID string
+	User   model.User
+
+	Scopes         datatype.StringList
+	Resource       string
+	BindingMessage *string
+	Status         cibaRequestStatus
+
+	PollingInterval int
+	LastPolledAt    *datatype.DateTime
+	ExpiresAt       datatype.DateTime
+
+	// RequestData is the encoded request the tokens are issued for, which carries the user's session once the request is approved
+	RequestData string
+}
+
+func (CIBARequest) TableName() string {
+	return "ciba_requests"
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/italypaleale/francis/host/local"
 	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"gorm.io/gorm"
@@ -20,12 +21,21 @@ type Config struct {
 	TokenBaseURL              string
 	Secret                    []byte
 	AllowInsecureCallbackURLs bool
+	// ClientCertificateHeader is the header a TLS-terminating reverse proxy forwards the client certificate used for mutual-TLS client authentication in, empty to only accept certificates of direct TLS connections
+	// The proxy must overwrite the header on every request, so a client can't pass its own value through
+	ClientCertificateHeader string
+	// ClientCertificateProxies are the reverse proxies allowed to forward the client certificate in ClientCertificateHeader
+	ClientCertificateProxies []string
 }
 
 type TokenSigner interface {
 	GetPrivateKey() any
 	GetKeyAlg() (jwa.KeyAlgorithm, error)
 	GetKeyID() (string, bool)
+	// GetSigningKeys returns all active signing keys, starting with the default key
+	GetSigningKeys() []jwk.Key
+	// GetVerificationKeys returns the keys tokens signed by Pocket ID can be verified with, which include the next and retired keys of a rollover
+	GetVerificationKeys() []jwk.Key
 }
 
 type CustomClaimSource interface {
@@ -33,7 +43,7 @@ type CustomClaimSource interface {
 }
 
 type ReauthenticationTokenConsumer interface {
-	ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, error)
+	ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, string, error)
 }
 
 type AuditLogger interface {
@@ -61,17 +71,21 @@ type Dependencies struct {
 type Module struct {
 	Preview *ClientPreviewBuilder
 
-	config       Config
-	store        *Store
-	cimdResolver *cimdClientResolver
+	config            Config
+	store             *Store
+	cimdResolver      *cimdClientResolver
+	backchannelLogout *backchannelLogoutService
+	authorization     *authorizationService
 
 	authorizationHandler *authorizationHandler
 	tokenHandler         *tokenHandler
 	userInfoHandler      *userInfoHandler
 	parHandler           *parHandler
 	introspectionHandler *introspectionHandler
+	revocationHandler    *revocationHandler
 	endSessionHandler    *endSessionHandler
 	deviceHandler        *deviceHandler
+	cibaHandler          *cibaHandler
 }
 
 func New(ctx context.Context, deps Dependencies) (*Module, error) {
@@ -93,12 +107,46 @@ func New(ctx context.Context, deps Dependencies) (*Module, error) {
 		return nil, fmt.Errorf("failed to create OAuth2 provider: %w", err)
 	}
 
-	claimsService := newClaimsService(deps.DB, deps.CustomClaims, deps.Config.BaseURL, deps.Signer)
+	pairwiseSubjects, err := newPairwiseSubjects(deps.Config.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive pairwise subject secret: %w", err)
+	}
+	claimsService := newClaimsService(deps.DB, deps.CustomClaims, deps.Config.BaseURL, deps.Signer).
+		withPairwiseSubjects(pairwiseSubjects)
 	previewBuilder := newClientPreviewBuilder(claimsService, provider.tokenStrategies)
 	interactionSessionService := newInteractionSessionService(deps.DB)
 	authorizationService := newAuthorizationService(deps.DB, interactionSessionService, claimsService, deps.Reauth, deps.AuditLog, deps.APIAccess)
 	deviceService := newDeviceService(provider, store, provider.deviceStrategy, authorizationService, claimsService, deps.AuditLog, deps.DB)
-	endSessionService := newEndSessionService(deps.DB, store, deps.Signer, deps.Config.BaseURL)
+	// Register the actor that delivers backchannel logout tokens to the clients
+	var backchannelLogout *backchannelLogoutService
+	if deps.Actors != nil {
+		err = deps.Actors.RegisterActor(backchannelLogoutActorType, newBackchannelLogoutActor(&backchannelLogoutDelivery{
+			signer:     deps.Signer,
+			issuer:     deps.Config.BaseURL,
+			httpClient: deps.HTTPClient,
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("error registering OIDC backchannel logout actor: %w", err)
+		}
+		backchannelLogout = newBackchannelLogoutService(deps.DB, deps.Actors.Service(), pairwiseSubjects)
+	}
+
+	// Register the actor that notifies ping mode clients of the user's decision on their backchannel authentication requests
+	var cibaNotifications *cibaNotificationService
+	if deps.Actors != nil {
+		err = deps.Actors.RegisterActor(cibaNotificationActorType, newCIBANotificationActor(&cibaNotificationDelivery{
+			httpClient: deps.HTTPClient,
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("error registering OIDC CIBA notification actor: %w", err)
+		}
+		cibaNotifications = newCIBANotificationService(deps.Actors.Service())
+	}
+	cibaService := newCIBAService(provider.authenticateClient, store, authorizationService, claimsService, cibaNotifications, deps.Signer, deps.Config.BaseURL, deps.AuditLog, deps.DB)
+
+	requestObjects := newRequestObjectVerifier(store, authenticator, deps.Config.BaseURL)
+	dpop := newDPoPVerifier(store, deps.Config.Secret, deps.Config.BaseURL, deps.Config.TokenBaseURL)
+	endSessionService := newEndSessionService(deps.DB, store, deps.Signer, deps.Config.BaseURL, backchannelLogout, pairwiseSubjects)
 
 	// Register the cleanup jobs for expired OIDC rows
 	if !deps.CleanupDisabled {
@@ -122,17 +170,21 @@ func New(ctx context.Context, deps Dependencies) (*Module, error) {
 	return &Module{
 		Preview: previewBuilder,
 
-		config:       deps.Config,
-		store:        store,
-		cimdResolver: cimdResolver,
-
-		authorizationHandler: newAuthorizationHandler(provider, authorizationService),
-		tokenHandler:         newTokenHandler(provider, claimsService, deps.APIAccess),
-		userInfoHandler:      newUserInfoHandler(provider, claimsService, deps.Config.BaseURL),
-		parHandler:           newPARHandler(provider),
-		introspectionHandler: newIntrospectionHandler(provider, authenticator, deps.Config.BaseURL),
+		config:            deps.Config,
+		store:             store,
+		cimdResolver:      cimdResolver,
+		backchannelLogout: backchannelLogout,
+		authorization:     authorizationService,
+
+		authorizationHandler: newAuthorizationHandler(provider, authorizationService, requestObjects),
+		tokenHandler:         newTokenHandler(provider, claimsService, deps.APIAccess, dpop, provider.tlsClientAuth, deps.AuditLog, deps.DB),
+		userInfoHandler:      newUserInfoHandler(provider, claimsService, deps.Config.BaseURL, dpop, provider.tlsClientAuth, deps.Signer, provider.responseEncrypter),
+		parHandler:           newPARHandler(provider, requestObjects, deps.APIAccess),
+		introspectionHandler: newIntrospectionHandler(provider, authenticator, deps.Config.BaseURL, dpop, provider.tlsClientAuth, deps.Signer, deps.APIAccess),
+		revocationHandler:    newRevocationHandler(provider, deps.AuditLog, deps.DB),
 		endSessionHandler:    newEndSessionHandler(endSessionService, deps.Config.BaseURL),
 		deviceHandler:        newDeviceHandler(provider, deviceService),
+		cibaHandler:          newCIBAHandler(provider, cibaService),
 	}, nil
 }
 
@@ -141,6 +193,21 @@ func (m *Module) RefreshClientMetadata(ctx context.Context, clientID string) (mo
 	return m.cimdResolver.RefreshMetadataClient(ctx, clientID)
 }
 
+// NotifyClientLogout sends a backchannel logout token to the client, if it registered a backchannel logout URI, after the user's sessions with it were ended
+func (m *Module) NotifyClientLogout(ctx context.Context, userID string, clientID string) error {
+	return m.backchannelLogout.notifyClientLogout(ctx, userID, clientID)
+}
+
+// AuthorizedScopes describes the user's decisions on everything they were asked to consent to for the client
+func (m *Module) AuthorizedScopes(ctx context.Context, userID, clientID string) ([]dto.AuthorizedOidcClientScopeDto, error) {
+	return m.authorization.authorizedScopes(ctx, userID, clientID)
+}
+
+// RevokeAuthorizedScope withdraws the user's consent to a single optional scope of the client, without revoking the whole authorization
+func (m *Module) RevokeAuthorizedScope(ctx context.Context, userID, clientID, key string) error {
+	return m.authorization.revokeAuthorizedScope(ctx, userID, clientID, key)
+}
+
 func (m *Module) RegisterRoutes(rootGroup *gin.RouterGroup, apiGroup *gin.RouterGroup, optionalBrowserAuth gin.HandlerFunc, browserAuth gin.HandlerFunc) {
 	rootGroup.GET("/authorize", optionalBrowserAuth, m.authorizationHandler.authorize)
 	rootGroup.POST("/authorize", optionalBrowserAuth, m.authorizationHandler.authorize)
@@ -157,10 +224,17 @@ func (m *Module) RegisterRoutes(rootGroup *gin.RouterGroup, apiGroup *gin.Router
 
 	apiGroup.POST("/oidc/introspect", m.introspectionHandler.introspectToken)
 
+	apiGroup.POST("/oidc/revoke", m.revocationHandler.revokeToken)
+
 	apiGroup.GET("/oidc/end-session", optionalBrowserAuth, m.endSessionHandler.endSession)
 	apiGroup.POST("/oidc/end-session", optionalBrowserAuth, m.endSessionHandler.endSession)
 
 	apiGroup.POST("/oidc/device/authorize", m.deviceHandler.authorizeDevice)
 	apiGroup.POST("/oidc/device/verify", browserAuth, m.deviceHandler.verifyDeviceCode)
 	apiGroup.GET("/oidc/device/info", browserAuth, m.deviceHandler.deviceCodeInfo)
+
+	apiGroup.POST("/oidc/bc-authorize", m.cibaHandler.backchannelAuthorize)
+	apiGroup.GET("/oidc/bc-authorize/requests", browserAuth, m.cibaHandler.listPendingRequests)
+	apiGroup.POST("/oidc/bc-authorize/requests/:id/approve", browserAuth, m.cibaHandler.approveRequest)
+	apiGroup.POST("/oidc/bc-authorize/requests/:id/deny", browserAuth, m.cibaHandler.denyRequest)
 }
@@ -0,0 +1,266 @@
+package oidc
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ory/fosite"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/crypto"
+)
+
+var errNoTLSClientAuth = errors.New("no TLS client authentication")
+
+// TLSClientAuthMethodsSupported returns the mutual-TLS client authentication methods of RFC 8705 that the token endpoint accepts
+func TLSClientAuthMethodsSupported() []string {
+	return []string{string(model.OidcClientTLSClientAuthPKI), string(model.OidcClientTLSClientAuthSelfSigned)}
+}
+
+// clientGetter is the subset of the store the TLS client authenticator needs
+type clientGetter interface {
+	GetClient(ctx context.Context, id string) (fosite.Client, error)
+}
+
+// tlsClientAuthenticator authenticates clients with a TLS client certificate, and binds access tokens to that certificate, as defined by RFC 8705
+// The certificate is read from the TLS connection, or from the header a TLS-terminating reverse proxy forwards it in, see Config.ClientCertificateHeader
+type tlsClientAuthenticator struct {
+	clients clientGetter
+	// header is the header the certificate is forwarded in, empty if forwarded certificates aren't accepted
+	// NGINX and Caddy send it as URL-encoded PEM, while Traefik and HAProxy send the base64-encoded DER
+	header         string
+	trustedProxies []netip.Prefix
+}
+
+func newTLSClientAuthenticator(clients clientGetter, header string, trustedProxies []string) *tlsClientAuthenticator {
+	prefixes := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		// Like gin, accept both CIDR ranges and single IPs
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return &tlsClientAuthenticator{
+		clients:        clients,
+		header:         header,
+		trustedProxies: prefixes,
+	}
+}
+
+// clientCertificate returns the certificate the client presented, or nil if there is none
+// Forwarded certificates are ignored unless the request comes directly from one of the proxies allowed to forward them, otherwise anyone could claim to hold any certificate
+func (a *tlsClientAuthenticator) clientCertificate(r *http.Request) *x509.Certificate {
+	if a == nil {
+		return nil
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0]
+	}
+	if a.header == "" || !a.isTrustedProxy(r.RemoteAddr) {
+		return nil
+	}
+
+	value := r.Header.Get(a.header)
+	if value == "" {
+		return nil
+	}
+	cert, err := parseForwardedCertificate(value)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+func (a *tlsClientAuthenticator) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	return slices.ContainsFunc(a.trustedProxies, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+// authenticateForm returns errNoTLSClientAuth when the client did not present a certificate or has no TLS client authentication configured, so the caller can fall back to other authentication methods
+func (a *tlsClientAuthenticator) authenticateForm(ctx context.Context, r *http.Request, form url.Values) (fosite.Client, error) {
+	// RFC 8705 section 2 requires the client_id parameter, since the certificate alone doesn't identify the client
+	clientID := form.Get("client_id")
+	if clientID == "" {
+		return nil, errNoTLSClientAuth
+	}
+	cert := a.clientCertificate(r)
+	if cert == nil {
+		return nil, errNoTLSClientAuth
+	}
+
+	client, err := a.clients.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, fosite.ErrInvalidClient.WithWrap(err)
+	}
+	oidcClient, ok := client.(Client)
+	if !ok || oidcClient.Credentials.TLSClientAuth == nil {
+		return nil, errNoTLSClientAuth
+	}
+
+	err = verifyClientCertificate(oidcClient.Credentials.TLSClientAuth, cert)
+	if err != nil {
+		return nil, fosite.ErrInvalidClient.WithHint("The client certificate does not match the certificate registered for the client.").WithWrap(err)
+	}
+
+	return client, nil
+}
+
+// certificateThumbprint returns the thumbprint of the certificate the client authenticated with, which access tokens are bound to
+// It is empty when the client did not present a certificate that matches its registration
+func (a *tlsClientAuthenticator) certificateThumbprint(r *http.Request, client Client) string {
+	if client.Credentials.TLSClientAuth == nil {
+		return ""
+	}
+	cert := a.clientCertificate(r)
+	if cert == nil || verifyClientCertificate(client.Credentials.TLSClientAuth, cert) != nil {
+		return ""
+	}
+	return crypto.CertificateThumbprint(cert)
+}
+
+// verifyTokenBinding checks that a certificate-bound access token is presented over a connection that uses the same certificate, see RFC 8705 section 3
+func (a *tlsClientAuthenticator) verifyTokenBinding(r *http.Request, session *Session) error {
+	x5t := session.certificateThumbprint()
+	if x5t == "" {
+		return nil
+	}
+
+	cert := a.clientCertificate(r)
+	if cert == nil || crypto.CertificateThumbprint(cert) != x5t {
+		return fosite.ErrRequestUnauthorized.WithDescription("The access token is bound to a client certificate that was not presented with the request.")
+	}
+	return nil
+}
+
+// verifyClientCertificate matches the certificate against the client's registration
+func verifyClientCertificate(config *model.OidcClientTLSClientAuth, cert *x509.Certificate) error {
+	switch config.Method {
+	case model.OidcClientTLSClientAuthSelfSigned:
+		pinned, err := crypto.ParseCertificatesPEM(config.Certificates)
+		if err != nil {
+			return err
+		}
+		thumbprint := crypto.CertificateThumbprint(cert)
+		for _, pinnedCert := range pinned {
+			if crypto.CertificateThumbprint(pinnedCert) == thumbprint {
+				return nil
+			}
+		}
+		return errors.New("certificate is not pinned to the client")
+
+	case model.OidcClientTLSClientAuthPKI:
+		caCerts, err := crypto.ParseCertificatesPEM(config.CACertificates)
+		if err != nil {
+			return err
+		}
+		roots := x509.NewCertPool()
+		for _, caCert := range caCerts {
+			roots.AddCert(caCert)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return err
+		}
+		if !matchesCertificateSubject(config, cert) {
+			return errors.New("certificate subject does not match the client")
+		}
+		return nil
+
+	default:
+		return errors.New("unsupported TLS client authentication method")
+	}
+}
+
+// matchesCertificateSubject checks the subject attribute registered for the client, of which only one is expected
+// A registration without any subject attribute matches no certificate, as any certificate issued by the CA would otherwise be accepted
+func matchesCertificateSubject(config *model.OidcClientTLSClientAuth, cert *x509.Certificate) bool {
+	switch {
+	case config.SubjectDN != "":
+		return strings.EqualFold(normalizeDN(cert.Subject.String()), normalizeDN(config.SubjectDN))
+	case config.SANDNS != "":
+		return slices.ContainsFunc(cert.DNSNames, func(name string) bool {
+			return strings.EqualFold(name, config.SANDNS)
+		})
+	case config.SANURI != "":
+		return slices.ContainsFunc(cert.URIs, func(uri *url.URL) bool {
+			return uri.String() == config.SANURI
+		})
+	case config.SANIP != "":
+		expected := net.ParseIP(config.SANIP)
+		return expected != nil && slices.ContainsFunc(cert.IPAddresses, expected.Equal)
+	case config.SANEmail != "":
+		return slices.ContainsFunc(cert.EmailAddresses, func(email string) bool {
+			return strings.EqualFold(email, config.SANEmail)
+		})
+	default:
+		return false
+	}
+}
+
+// normalizeDN removes the optional whitespace around the separators of a RFC 4514 distinguished name
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, part := range parts {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			parts[i] = strings.TrimSpace(part)
+			continue
+		}
+		parts[i] = strings.TrimSpace(key) + "=" + strings.TrimSpace(value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseForwardedCertificate parses the certificate forwarded by a reverse proxy, either as URL-encoded PEM or as base64-encoded DER
+func parseForwardedCertificate(value string) (*x509.Certificate, error) {
+	// PathUnescape leaves "+" alone, which is part of the base64 alphabet
+	unescaped, err := url.PathUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(unescaped, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(unescaped))
+		if block == nil {
+			return nil, errors.New("invalid PEM certificate")
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	// Proxies that forward the whole chain separate the certificates with commas, and the client's own comes first
+	leaf, _, _ := strings.Cut(unescaped, ",")
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(leaf))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
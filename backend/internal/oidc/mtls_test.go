@@ -0,0 +1,255 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/crypto"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// newTestCertificate issues a client certificate signed by the parent, or a self-signed one when parent is nil
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if !template.IsCA {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCertificate{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func newTestCA(t *testing.T) testCertificate {
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func TestTLSClientAuthenticatorClientCertificate(t *testing.T) {
+	cert := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}, nil)
+	authenticator := newTLSClientAuthenticator(nil, "X-SSL-Client-Cert", []string{"192.0.2.0/24", "10.0.0.1"})
+
+	newRequest := func(remoteAddr string, header string, value string) *http.Request {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/token", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(header, value)
+		return req
+	}
+
+	t.Run("URL-encoded PEM from a trusted proxy", func(t *testing.T) {
+		got := authenticator.clientCertificate(newRequest("192.0.2.10:1234", "X-SSL-Client-Cert", url.QueryEscape(cert.pem)))
+		require.NotNil(t, got)
+		assert.Equal(t, cert.cert.Raw, got.Raw)
+	})
+
+	t.Run("base64 DER chain from a trusted proxy", func(t *testing.T) {
+		value := base64.StdEncoding.EncodeToString(cert.cert.Raw) + ",MIIBfake"
+		got := authenticator.clientCertificate(newRequest("10.0.0.1:1234", "X-SSL-Client-Cert", value))
+		require.NotNil(t, got)
+		assert.Equal(t, cert.cert.Raw, got.Raw)
+	})
+
+	t.Run("other forwarding headers are ignored", func(t *testing.T) {
+		value := base64.StdEncoding.EncodeToString(cert.cert.Raw)
+		got := authenticator.clientCertificate(newRequest("10.0.0.1:1234", "X-Forwarded-Tls-Client-Cert", value))
+		assert.Nil(t, got)
+	})
+
+	t.Run("header from an untrusted peer is ignored", func(t *testing.T) {
+		got := authenticator.clientCertificate(newRequest("203.0.113.5:1234", "X-SSL-Client-Cert", url.QueryEscape(cert.pem)))
+		assert.Nil(t, got)
+	})
+
+	t.Run("header is ignored without trusted proxies", func(t *testing.T) {
+		got := newTLSClientAuthenticator(nil, "X-SSL-Client-Cert", nil).clientCertificate(newRequest("192.0.2.10:1234", "X-SSL-Client-Cert", url.QueryEscape(cert.pem)))
+		assert.Nil(t, got)
+	})
+
+	t.Run("header is ignored without a configured header", func(t *testing.T) {
+		got := newTLSClientAuthenticator(nil, "", []string{"192.0.2.0/24"}).clientCertificate(newRequest("192.0.2.10:1234", "X-SSL-Client-Cert", url.QueryEscape(cert.pem)))
+		assert.Nil(t, got)
+	})
+}
+
+func TestVerifyClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	client := newTestCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "client.example.com", Organization: []string{"Example"}},
+		DNSNames: []string{"client.example.com"},
+	}, &ca)
+	selfSigned := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "self-signed"}}, nil)
+
+	tests := []struct {
+		name    string
+		config  model.OidcClientTLSClientAuth
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{
+			name:   "pinned self-signed certificate",
+			config: model.OidcClientTLSClientAuth{Method: model.OidcClientTLSClientAuthSelfSigned, Certificates: client.pem + selfSigned.pem},
+			cert:   selfSigned.cert,
+		},
+		{
+			name:    "certificate that is not pinned",
+			config:  model.OidcClientTLSClientAuth{Method: model.OidcClientTLSClientAuthSelfSigned, Certificates: client.pem},
+			cert:    selfSigned.cert,
+			wantErr: true,
+		},
+		{
+			name:   "PKI with matching DNS SAN",
+			config: model.OidcClientTLSClientAuth{Method: model.OidcClientTLSClientAuthPKI, CACertificates: ca.pem, SANDNS: "CLIENT.example.com"},
+			cert:   client.cert,
+		},
+		{
+			name:   "PKI with matching subject DN",
+			config: model.OidcClientTLSClientAuth{Method: model.OidcClientTLSClientAuthPKI, CACertificates: ca.pem, SubjectDN: "CN=client.example.com, O=Example"},
+			cert:   client.cert,
+		},
+		{
+			name:    "PKI with another subject",
+			config:  model.OidcClientTLSClientAuth{Method: model.OidcClientTLSClientAuthPKI, CACertificates: ca.pem, SANDNS: "other.example.com"},
+			cert:    client.cert,
+			wantErr: true,
+		},
+		{
+			name:    "PKI without subject attribute",
+			config:  model.OidcClientTLSClientAuth{Method: model.OidcClientTLSClientAuthPKI, CACertificates: ca.pem},
+			cert:    client.cert,
+			wantErr: true,
+		},
+		{
+			name:    "PKI with untrusted CA",
+			config:  model.OidcClientTLSClientAuth{Method: model.OidcClientTLSClientAuthPKI, CACertificates: otherCA.pem, SANDNS: "client.example.com"},
+			cert:    client.cert,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyClientCertificate(&tt.config, tt.cert)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTokenHandlerIssuesCertificateBoundTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL  = "https://issuer.example.com"
+		clientID = "mtls-client"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	cert := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: clientID}}, nil)
+	otherCert := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}}, nil)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base: model.Base{ID: clientID},
+		Name: "mTLS Client",
+		Credentials: model.OidcClientCredentials{
+			TLSClientAuth: &model.OidcClientTLSClientAuth{
+				Method:       model.OidcClientTLSClientAuthSelfSigned,
+				Certificates: cert.pem,
+			},
+		},
+	}).Error)
+
+	provider, err := newProvider(NewStore(db, nil), nil, testTokenSigner{key: signerKey}, Config{
+		BaseURL:                  baseURL,
+		TokenBaseURL:             baseURL,
+		Secret:                   []byte("test-secret"),
+		ClientCertificateHeader:  "X-SSL-Client-Cert",
+		ClientCertificateProxies: []string{"192.0.2.0/24"},
+	}, nil)
+	require.NoError(t, err)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
+
+	requestToken := func(t *testing.T, certPEM string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID}}
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, tokenEndpointPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-SSL-Client-Cert", url.QueryEscape(certPEM))
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		handler.token(c)
+		return rec
+	}
+
+	t.Run("registered certificate", func(t *testing.T) {
+		rec := requestToken(t, cert.pem)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, "bearer", strings.ToLower(body["token_type"].(string)))
+
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		cnf, ok := claims["cnf"].(map[string]any)
+		require.True(t, ok, "access token must carry the cnf claim")
+		assert.Equal(t, crypto.CertificateThumbprint(cert.cert), cnf["x5t#S256"])
+	})
+
+	t.Run("unregistered certificate", func(t *testing.T) {
+		rec := requestToken(t, otherCert.pem)
+		require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "invalid_client")
+	})
+}
@@ -0,0 +1,89 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// SubjectTypesSupported returns the subject identifier types, for the subject_types_supported server metadata
+func SubjectTypesSupported() []string {
+	return []string{string(model.OidcClientSubjectTypePublic), string(model.OidcClientSubjectTypePairwise)}
+}
+
+// pairwiseSubjects computes the subjects clients know the users by, as defined by OpenID Connect Core section 8
+// A pairwise subject is derived from the instance secret, the sector of the client and the user, so it's stable for the clients of a sector without being stored
+type pairwiseSubjects struct {
+	secret []byte
+}
+
+func newPairwiseSubjects(secret []byte) (*pairwiseSubjects, error) {
+	key, err := derivePairwiseSubjectSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &pairwiseSubjects{secret: key}, nil
+}
+
+// derivePairwiseSubjectSecret derives a 32-byte secret for the pairwise subjects from the provided secret.
+// Note: changing this function changes the subject of every user of a pairwise client, and is considered a breaking change.
+func derivePairwiseSubjectSecret(secret []byte) ([]byte, error) {
+	const info = "pocketid/oidc_pairwise_subject"
+	r := hkdf.New(sha256.New, secret, nil, []byte(info))
+
+	key := make([]byte, 32)
+	_, err := io.ReadFull(r, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// subjectFor returns the subject the client knows the user by
+func (p *pairwiseSubjects) subjectFor(client model.OidcClient, userID string) string {
+	if p == nil || userID == "" || client.SubjectType != model.OidcClientSubjectTypePairwise {
+		return userID
+	}
+
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(client.SectorIdentifier()))
+	// The separator keeps a sector and a user ID from being confused with another pair that concatenates to the same value
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// userIDFor resolves a subject the client passed back, for example in an ID token hint, to the ID of the user
+// A pairwise subject can't be reversed, so it's matched against the subjects of the users who authorized the client
+func (p *pairwiseSubjects) userIDFor(ctx context.Context, db *gorm.DB, client model.OidcClient, subject string) (string, bool, error) {
+	if p == nil || client.SubjectType != model.OidcClientSubjectTypePairwise {
+		return subject, true, nil
+	}
+
+	var userIDs []string
+	err := db.
+		WithContext(ctx).
+		Model(&model.UserAuthorizedOidcClient{}).
+		Where("client_id = ?", client.ID).
+		Pluck("user_id", &userIDs).
+		Error
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, userID := range userIDs {
+		if subtle.ConstantTimeCompare([]byte(p.subjectFor(client, userID)), []byte(subject)) == 1 {
+			return userID, true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestPairwiseSubjects(t *testing.T) {
+	subjects, err := newPairwiseSubjects([]byte("test-secret"))
+	require.NoError(t, err)
+
+	pairwiseClient := func(id string, callbackURLs ...string) model.OidcClient {
+		return model.OidcClient{
+			Base:         model.Base{ID: id},
+			CallbackURLs: datatype.StringList(callbackURLs),
+			SubjectType:  model.OidcClientSubjectTypePairwise,
+		}
+	}
+
+	t.Run("public client gets the ID of the user", func(t *testing.T) {
+		client := model.OidcClient{Base: model.Base{ID: "public"}, SubjectType: model.OidcClientSubjectTypePublic}
+		assert.Equal(t, "user-1", subjects.subjectFor(client, "user-1"))
+	})
+
+	t.Run("pairwise subject is stable within a sector", func(t *testing.T) {
+		a := pairwiseClient("client-a", "https://app.example.com/callback")
+		b := pairwiseClient("client-b", "https://app.example.com/other-callback")
+
+		subject := subjects.subjectFor(a, "user-1")
+		assert.NotEqual(t, "user-1", subject)
+		assert.Equal(t, subject, subjects.subjectFor(a, "user-1"))
+		assert.Equal(t, subject, subjects.subjectFor(b, "user-1"))
+		assert.NotEqual(t, subject, subjects.subjectFor(a, "user-2"))
+	})
+
+	t.Run("pairwise subject differs across sectors", func(t *testing.T) {
+		a := pairwiseClient("client-a", "https://app.example.com/callback")
+		b := pairwiseClient("client-b", "https://other.example.com/callback")
+		assert.NotEqual(t, subjects.subjectFor(a, "user-1"), subjects.subjectFor(b, "user-1"))
+
+		// The sector identifier URI takes precedence over the callback URLs
+		b.SectorIdentifierURI = new("https://app.example.com/sector.json")
+		assert.Equal(t, subjects.subjectFor(a, "user-1"), subjects.subjectFor(b, "user-1"))
+	})
+
+	t.Run("pairwise subject depends on the secret", func(t *testing.T) {
+		other, err := newPairwiseSubjects([]byte("other-secret"))
+		require.NoError(t, err)
+		client := pairwiseClient("client-a", "https://app.example.com/callback")
+		assert.NotEqual(t, subjects.subjectFor(client, "user-1"), other.subjectFor(client, "user-1"))
+	})
+
+	t.Run("subject resolves to the user who authorized the client", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		client := pairwiseClient("client-a", "https://app.example.com/callback")
+		client.Name = "Client A"
+		require.NoError(t, db.Create(&client).Error)
+		for _, userID := range []string{"user-1", "user-2"} {
+			require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}, Username: userID}).Error)
+			require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: userID, ClientID: client.ID}).Error)
+		}
+
+		userID, found, err := subjects.userIDFor(t.Context(), db, client, subjects.subjectFor(client, "user-2"))
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "user-2", userID)
+
+		_, found, err = subjects.userIDFor(t.Context(), db, client, "user-2")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+}
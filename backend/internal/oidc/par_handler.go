@@ -8,18 +8,30 @@ import (
 )
 
 type parHandler struct {
-	provider fosite.OAuth2Provider
+	provider       fosite.OAuth2Provider
+	requestObjects *requestObjectVerifier
+	apiAccess      APIAccessProvider
 }
 
-func newPARHandler(provider fosite.OAuth2Provider) *parHandler {
+func newPARHandler(provider fosite.OAuth2Provider, requestObjects *requestObjectVerifier, apiAccess APIAccessProvider) *parHandler {
 	return &parHandler{
-		provider: provider,
+		provider:       provider,
+		requestObjects: requestObjects,
+		apiAccess:      apiAccess,
 	}
 }
 
 func (h *parHandler) pushedAuthorizationRequest(c *gin.Context) {
 	ctx := c.Request.Context()
 
+	// Signed request objects are verified here, so the authorize endpoint can trust the parameters stored with the request_uri
+	err := h.requestObjects.resolve(ctx, c.Request, clientAuthenticationParameters...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to verify request object", "error", err)
+		h.provider.WritePushedAuthorizeError(ctx, c.Writer, fosite.NewAuthorizeRequest(), err)
+		return
+	}
+
 	ar, err := h.provider.NewPushedAuthorizeRequest(ctx, c.Request)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to create pushed authorize request", "error", err)
@@ -27,6 +39,17 @@ func (h *parHandler) pushedAuthorizationRequest(c *gin.Context) {
 		return
 	}
 
+	// The authorization details are rejected when they're pushed rather than when the user is sent to the authorize endpoint
+	resource, err := authorizationDetailsResource(requestedResources(ar.GetRequestForm()), ar.GetRequestForm().Get("authorization_details"))
+	if err == nil {
+		_, err = requestedAuthorizationDetails(ctx, nil, h.apiAccess, ar.GetClient().GetID(), resource, SubjectTypeUser, ar.GetRequestForm().Get("authorization_details"))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to validate pushed authorization details", "error", err)
+		h.provider.WritePushedAuthorizeError(ctx, c.Writer, ar, err)
+		return
+	}
+
 	response, err := h.provider.NewPushedAuthorizeResponse(ctx, ar, NewEmptySession())
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to create pushed authorize response", "error", err)
@@ -37,14 +37,24 @@ func (b *ClientPreviewBuilder) BuildClientPreview(ctx context.Context, client mo
 		return nil, err
 	}
 
-	userInfo, err := b.claimsService.GetUserClaims(ctx, userID, scopeArgs)
+	// The claim policy of the client can release different claims to each destination
+	userInfo, err := b.claimsService.GetUserClaims(ctx, client, userID, scopeArgs, nil, model.ClaimDestinationUserInfo)
+	if err != nil {
+		return nil, err
+	}
+	idTokenClaims, err := b.claimsService.GetUserClaims(ctx, client, userID, scopeArgs, nil, model.ClaimDestinationIDToken)
+	if err != nil {
+		return nil, err
+	}
+	accessTokenClaims, err := b.claimsService.accessTokenClaims(ctx, client, userID, scopeArgs)
 	if err != nil {
 		return nil, err
 	}
 
 	request := b.newPreviewRequest(ctx, client, userID, scopeArgs, authenticationMethod)
 	session := request.GetSession().(*Session)
-	applyUserClaimsToIDToken(session, userID, userInfo)
+	applyUserClaimsToIDToken(session, idTokenClaims)
+	session.setAccessTokenUserClaims(accessTokenClaims)
 
 	idToken, err := b.strategies.idToken.GenerateIDToken(ctx, b.strategies.config.GetIDTokenLifespan(ctx), request)
 	if err != nil {
@@ -19,7 +19,10 @@ import (
 
 type oidcProvider struct {
 	fosite.OAuth2Provider
-	deviceStrategy *deviceStrategy
+	deviceStrategy     *deviceStrategy
+	tlsClientAuth      *tlsClientAuthenticator
+	responseEncrypter  *responseEncrypter
+	authenticateClient fosite.ClientAuthenticationStrategy
 	tokenStrategies
 }
 
@@ -54,6 +57,7 @@ func newProvider(store *Store, authenticator *federatedClientAuthenticator, sign
 		EnablePKCEPlainChallengeMethod:          true,
 		SupportedRequestObjectSigningAlgorithms: []string{"none"},
 		FormPostHTMLTemplate:                    formPostTemplate,
+		ResponseModeHandlerExtension:            newJARMResponseModeHandler(signer, config.BaseURL),
 		RefreshTokenScopes:                      []string{},
 		GlobalSecret:                            secret,
 		JWTScopeClaimKey:                        jwt.JWTScopeFieldBoth,
@@ -64,11 +68,18 @@ func newProvider(store *Store, authenticator *federatedClientAuthenticator, sign
 	keyGetter := func(context.Context) (interface{}, error) {
 		return SigningKeyFromSigner(signer)
 	}
-	sig := newJWTSigner(keyGetter)
+	sig := newJWTSigner(signer, keyGetter)
+	// Access and ID tokens are signed with the algorithms the API and the client of the token request want
+	accessTokenSigner := newJWTSigner(signer, func(ctx context.Context) (any, error) {
+		return signingKeyForAlgorithm(signer, tokenSigningAlgorithmsFromContext(ctx).accessToken)
+	})
+	idTokenSigner := newJWTSigner(signer, func(ctx context.Context) (any, error) {
+		return signingKeyForAlgorithm(signer, tokenSigningAlgorithmsFromContext(ctx).idToken)
+	})
 	coreStrategy := compose.NewOAuth2HMACStrategy(fositeConfig)
 	deviceStrategy := &deviceStrategy{DefaultDeviceStrategy: compose.NewDeviceStrategy(fositeConfig)}
 	defaultAccessTokenStrategy := &fositeoauth2.DefaultJWTStrategy{
-		Signer:          sig,
+		Signer:          accessTokenSigner,
 		HMACSHAStrategy: coreStrategy,
 		Config:          fositeConfig,
 	}
@@ -79,35 +90,57 @@ func newProvider(store *Store, authenticator *federatedClientAuthenticator, sign
 	// Apply Pocket ID's identity-audience policy outside Fosite's reusable RFC 9068 token profile
 	accessTokenStrategy := NewAccessTokenStrategy(rfc9068AccessTokenStrategy, config.BaseURL)
 	idTokenStrategy := &openid.DefaultStrategy{
-		Signer: sig,
+		Signer: idTokenSigner,
 		Config: fositeConfig,
 	}
+	// The issued ID tokens are encrypted for the clients that ask for it, while the preview keeps showing the signed token
+	responseEncrypter := newResponseEncrypter(authenticator)
+	encryptingIDTokens := &encryptingIDTokenStrategy{
+		OpenIDConnectTokenStrategy: idTokenStrategy,
+		encrypter:                  responseEncrypter,
+	}
 	provider := compose.Compose(
 		fositeConfig,
 		store,
 		&compose.CommonStrategy{
 			CoreStrategy:               accessTokenStrategy,
 			RFC8628CodeStrategy:        deviceStrategy,
-			OpenIDConnectTokenStrategy: idTokenStrategy,
+			OpenIDConnectTokenStrategy: encryptingIDTokens,
 			Signer:                     sig,
 		},
-		compose.OAuth2AuthorizeExplicitFactory,
+		// The grants that issue the first refresh token of an authorization only do so if the client's policy allows it
+		withRefreshTokenPolicy(compose.OAuth2AuthorizeExplicitFactory),
 		compose.OAuth2ClientCredentialsGrantFactory,
 		compose.OAuth2RefreshTokenGrantFactory,
 		compose.RFC8628DeviceFactory,
-		compose.RFC8628DeviceAuthorizationTokenFactory,
+		withRefreshTokenPolicy(compose.RFC8628DeviceAuthorizationTokenFactory),
 		compose.OpenIDConnectExplicitFactory,
 		compose.OpenIDConnectRefreshFactory,
 		compose.OpenIDConnectDeviceFactory,
 		compose.OAuth2TokenIntrospectionFactory,
+		compose.OAuth2TokenRevocationFactory,
 		compose.OAuth2PKCEFactory,
 		compose.PushedAuthorizeHandlerFactory,
 	).(*fosite.Fosite)
 
-	fositeConfig.ClientAuthenticationStrategy = newClientAuthenticationStrategy(authenticator, provider)
+	// The token exchange grant validates the subject token through the composed provider, so it is registered last
+	tokenExchange := newTokenExchangeHandler(accessTokenStrategy, store, fositeConfig)
+	tokenExchange.provider = provider
+	fositeConfig.TokenEndpointHandlers.Append(tokenExchange)
+	// The JWT bearer grant verifies the assertions with the keys of the external issuers, which the federated authenticator fetches and caches
+	if authenticator != nil {
+		fositeConfig.TokenEndpointHandlers.Append(newJWTBearerGrantHandler(authenticator, accessTokenStrategy, store, fositeConfig))
+	}
+	fositeConfig.TokenEndpointHandlers.Append(newCIBAGrantHandler(accessTokenStrategy, encryptingIDTokens, store, fositeConfig))
+
+	tlsClientAuth := newTLSClientAuthenticator(store, config.ClientCertificateHeader, config.ClientCertificateProxies)
+	fositeConfig.ClientAuthenticationStrategy = newClientAuthenticationStrategy(authenticator, tlsClientAuth, provider)
 	return &oidcProvider{
-		OAuth2Provider: provider,
-		deviceStrategy: deviceStrategy,
+		OAuth2Provider:     provider,
+		deviceStrategy:     deviceStrategy,
+		tlsClientAuth:      tlsClientAuth,
+		responseEncrypter:  responseEncrypter,
+		authenticateClient: fositeConfig.ClientAuthenticationStrategy,
 		tokenStrategies: tokenStrategies{
 			accessToken: accessTokenStrategy,
 			idToken:     idTokenStrategy,
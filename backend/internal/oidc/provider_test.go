@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/ory/fosite"
 	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
@@ -27,6 +28,10 @@ import (
 
 type testTokenSigner struct {
 	key *ecdsa.PrivateKey
+	// additional are the active keys of other algorithms
+	additional []jwk.Key
+	// retired are keys replaced by a rollover, which only verify tokens
+	retired []jwk.Key
 }
 
 func (s testTokenSigner) GetPrivateKey() any {
@@ -41,6 +46,25 @@ func (s testTokenSigner) GetKeyID() (string, bool) {
 	return "test-key-id", true
 }
 
+func (s testTokenSigner) GetSigningKeys() []jwk.Key {
+	return append([]jwk.Key{testSigningKey(s.key, jwa.ES256(), "test-key-id")}, s.additional...)
+}
+
+func (s testTokenSigner) GetVerificationKeys() []jwk.Key {
+	return append(s.GetSigningKeys(), s.retired...)
+}
+
+// testSigningKey wraps a raw private key as an active signing key
+func testSigningKey(key any, alg jwa.KeyAlgorithm, keyID string) jwk.Key {
+	signingKey, err := jwk.Import(key)
+	if err != nil {
+		panic(err)
+	}
+	_ = signingKey.Set(jwk.KeyIDKey, keyID)
+	_ = signingKey.Set(jwk.AlgorithmKey, alg)
+	return signingKey
+}
+
 func TestDeriveGlobalSecretUsesStableValue(t *testing.T) {
 	masterSecret := []byte("test-secret")
 
@@ -336,8 +360,9 @@ func TestProviderRejectsSignedRequestObject(t *testing.T) {
 	}, nil)
 	require.NoError(t, err)
 
-	// The signature is never verified: the request object must already be rejected because only
-	// "none" is a supported request object signing algorithm.
+	// The signature is never verified: signed request objects are verified and unwrapped by the
+	// requestObjectVerifier, so any that reaches fosite must be rejected because only "none" is a
+	// supported request object signing algorithm.
 	requestObject := encodeRequestObject(t,
 		map[string]any{"alg": "RS256"},
 		map[string]any{"redirect_uri": "https://client.example.com/callback"},
@@ -378,6 +403,10 @@ type algTestSigner struct {
 func (s algTestSigner) GetPrivateKey() any                   { return s.key }
 func (s algTestSigner) GetKeyAlg() (jwa.KeyAlgorithm, error) { return s.alg, nil }
 func (s algTestSigner) GetKeyID() (string, bool)             { return "test-key-id", true }
+func (s algTestSigner) GetSigningKeys() []jwk.Key {
+	return []jwk.Key{testSigningKey(s.key, s.alg, "test-key-id")}
+}
+func (s algTestSigner) GetVerificationKeys() []jwk.Key { return s.GetSigningKeys() }
 
 // TestProviderIssuesAndValidatesTokensForSupportedAlgorithms guards against the
 // regression where fosite's DefaultSigner derived the JWT algorithm from the Go key type
@@ -445,6 +474,49 @@ func TestProviderIssuesAndValidatesTokensForSupportedAlgorithms(t *testing.T) {
 	}
 }
 
+func TestProviderSignsTokensWithAlgorithmFromContext(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "test-client"}, Name: "Test Client"}).Error)
+
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer := testTokenSigner{
+		key:        signerKey,
+		additional: []jwk.Key{testSigningKey(generateEd25519TestKey(t), jwa.EdDSA(), "eddsa-key-id")},
+	}
+
+	// #nosec G101
+	provider, err := newProvider(NewStore(db, nil), nil, signer, Config{
+		BaseURL:      "https://issuer.example.com",
+		TokenBaseURL: "https://issuer.example.com",
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+
+	session := NewEmptySession()
+	session.Subject = "test-user"
+	session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(time.Hour))
+
+	request := fosite.NewAccessRequest(session)
+	request.ID = "test-request"
+	request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: "test-client"}}}
+	request.GrantTypes = fosite.Arguments{string(fosite.GrantTypeClientCredentials)}
+	request.GrantedAudience = fosite.Arguments{"https://api.orders.example.com"}
+
+	ctx := contextWithTokenSigningAlgorithms(t.Context(), tokenSigningAlgorithms{accessToken: "EdDSA"})
+	response, err := provider.NewAccessResponse(ctx, request)
+	require.NoError(t, err)
+
+	header := decodeJWTPart(t, response.GetAccessToken(), 0)
+	require.Equal(t, "EdDSA", header["alg"])
+	require.Equal(t, "eddsa-key-id", header["kid"])
+
+	// The token is verified with the key its header names, whichever algorithm the request in the context wants
+	_, introspected, err := provider.IntrospectToken(t.Context(), response.GetAccessToken(), fosite.AccessToken, NewEmptySession())
+	require.NoError(t, err)
+	require.Equal(t, "test-client", introspected.GetClient().GetID())
+}
+
 func generateRSATestKey(t *testing.T) any {
 	t.Helper()
 	key, err := rsa.GenerateKey(rand.Reader, 2048)
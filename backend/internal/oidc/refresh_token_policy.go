@@ -0,0 +1,114 @@
+package oidc
+
+import (
+	"context"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// offlineAccessScope is the scope clients request a refresh token with, see OpenID Connect Core section 11
+const offlineAccessScope = "offline_access"
+
+// The refresh token policy of a client is carried in the context, like transactions in tx.go, because fosite's grant
+// handlers read the refresh token scopes from the shared config and report stolen refresh tokens only as an error
+
+type offlineAccessRequiredContextKey struct{}
+
+type refreshTokenReuseContextKey struct{}
+
+// contextWithOfflineAccessRequired makes the grant handlers only issue a refresh token if the offline_access scope was granted
+func contextWithOfflineAccessRequired(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineAccessRequiredContextKey{}, true)
+}
+
+// refreshTokenScopes returns the scopes of which one must be granted for a refresh token to be issued
+func refreshTokenScopes(ctx context.Context, config fosite.RefreshTokenScopesProvider) []string {
+	if required, _ := ctx.Value(offlineAccessRequiredContextKey{}).(bool); required {
+		return []string{offlineAccessScope}
+	}
+	return config.GetRefreshTokenScopes(ctx)
+}
+
+// refreshTokenPolicyConfig is the fosite config as seen by the grant handlers that issue refresh tokens
+type refreshTokenPolicyConfig struct {
+	fosite.Configurator
+}
+
+func (c refreshTokenPolicyConfig) GetRefreshTokenScopes(ctx context.Context) []string {
+	return refreshTokenScopes(ctx, c.Configurator)
+}
+
+// withRefreshTokenPolicy composes a grant handler that applies the refresh token policy of the client in the context
+func withRefreshTokenPolicy(factory compose.Factory) compose.Factory {
+	return func(config fosite.Configurator, storage any, strategy any) any {
+		return factory(refreshTokenPolicyConfig{Configurator: config}, storage, strategy)
+	}
+}
+
+// validateOfflineAccessGranted rejects refreshing an authorization without the offline_access scope for clients that require it
+// Refresh tokens issued before the client required it are rejected too, so the policy applies to every active authorization
+func validateOfflineAccessGranted(client Client, accessRequest fosite.AccessRequester) error {
+	if !client.RefreshTokenRequiresOfflineAccess || !accessRequest.GetGrantTypes().ExactOne(string(fosite.GrantTypeRefreshToken)) {
+		return nil
+	}
+	if !accessRequest.GetGrantedScopes().Has(offlineAccessScope) {
+		return fosite.ErrInvalidGrant.WithHintf("The OAuth 2.0 Client was not granted scope %s and may thus not perform the 'refresh_token' authorization grant.", offlineAccessScope)
+	}
+	return nil
+}
+
+// applyRefreshTokenFamilyLifetime limits the refresh token about to be issued to the client's absolute lifetime
+// Each rotation extends the idle lifetime of the refresh token, but the absolute lifetime counts from the first refresh token of the authorization
+func applyRefreshTokenFamilyLifetime(client model.OidcClient, session *Session, now time.Time) error {
+	expiresAt := session.GetExpiresAt(fosite.RefreshToken)
+	if expiresAt.IsZero() {
+		return nil
+	}
+	if session.RefreshTokenFamilyIssuedAt == nil {
+		session.RefreshTokenFamilyIssuedAt = &now
+	}
+	if !model.IsValidTokenDurationMinutes(client.RefreshTokenMaxLifetimeMinutes) {
+		return nil
+	}
+
+	maxExpiresAt := session.RefreshTokenFamilyIssuedAt.Add(time.Duration(client.RefreshTokenMaxLifetimeMinutes) * time.Minute)
+	if !maxExpiresAt.After(now) {
+		return fosite.ErrInvalidGrant.WithHint("The refresh token has exceeded the maximum lifetime of the authorization.")
+	}
+	if expiresAt.After(maxExpiresAt) {
+		session.SetExpiresAt(fosite.RefreshToken, maxExpiresAt)
+	}
+	return nil
+}
+
+// withinRefreshTokenReuseGrace reports whether a rotated refresh token may still be used, because the client may send concurrent refresh requests with the same token
+func withinRefreshTokenReuseGrace(client fosite.Client, rotatedAt *datatype.DateTime, now time.Time) bool {
+	c, ok := client.(Client)
+	if !ok || rotatedAt == nil || c.RefreshTokenReuseGraceSeconds <= 0 {
+		return false
+	}
+	return now.Before(rotatedAt.ToTime().Add(time.Duration(c.RefreshTokenReuseGraceSeconds) * time.Second))
+}
+
+// refreshTokenReuse collects a rotated refresh token that was presented again during a token request
+type refreshTokenReuse struct {
+	requester fosite.Requester
+}
+
+// contextWithRefreshTokenReuse lets the store report reused refresh tokens to the token handler, which writes the audit log
+func contextWithRefreshTokenReuse(ctx context.Context) (context.Context, *refreshTokenReuse) {
+	reuse := &refreshTokenReuse{}
+	return context.WithValue(ctx, refreshTokenReuseContextKey{}, reuse), reuse
+}
+
+func reportRefreshTokenReuse(ctx context.Context, requester fosite.Requester) {
+	reuse, ok := ctx.Value(refreshTokenReuseContextKey{}).(*refreshTokenReuse)
+	if ok {
+		reuse.requester = requester
+	}
+}
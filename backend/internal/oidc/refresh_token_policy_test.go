@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	fositejwt "github.com/ory/fosite/token/jwt"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestApplyRefreshTokenFamilyLifetime(t *testing.T) {
+	now := time.Now().UTC()
+	client := model.OidcClient{RefreshTokenMaxLifetimeMinutes: 60}
+
+	t.Run("first refresh token starts the family", func(t *testing.T) {
+		session := NewEmptySession()
+		session.SetExpiresAt(fosite.RefreshToken, now.Add(24*time.Hour))
+
+		require.NoError(t, applyRefreshTokenFamilyLifetime(client, session, now))
+		require.Equal(t, now, *session.RefreshTokenFamilyIssuedAt)
+		require.Equal(t, now.Add(time.Hour), session.GetExpiresAt(fosite.RefreshToken))
+	})
+
+	t.Run("rotated refresh token keeps the family start", func(t *testing.T) {
+		issuedAt := now.Add(-45 * time.Minute)
+		session := NewEmptySession()
+		session.RefreshTokenFamilyIssuedAt = &issuedAt
+		session.SetExpiresAt(fosite.RefreshToken, now.Add(24*time.Hour))
+
+		require.NoError(t, applyRefreshTokenFamilyLifetime(client, session, now))
+		require.Equal(t, issuedAt.Add(time.Hour), session.GetExpiresAt(fosite.RefreshToken))
+	})
+
+	t.Run("idle lifetime within the family lifetime is kept", func(t *testing.T) {
+		session := NewEmptySession()
+		session.SetExpiresAt(fosite.RefreshToken, now.Add(30*time.Minute))
+
+		require.NoError(t, applyRefreshTokenFamilyLifetime(client, session, now))
+		require.Equal(t, now.Add(30*time.Minute), session.GetExpiresAt(fosite.RefreshToken))
+	})
+
+	t.Run("family past its lifetime is rejected", func(t *testing.T) {
+		issuedAt := now.Add(-2 * time.Hour)
+		session := NewEmptySession()
+		session.RefreshTokenFamilyIssuedAt = &issuedAt
+		session.SetExpiresAt(fosite.RefreshToken, now.Add(24*time.Hour))
+
+		require.ErrorIs(t, applyRefreshTokenFamilyLifetime(client, session, now), fosite.ErrInvalidGrant)
+	})
+
+	t.Run("without an absolute lifetime only the idle lifetime applies", func(t *testing.T) {
+		session := NewEmptySession()
+		session.SetExpiresAt(fosite.RefreshToken, now.Add(24*time.Hour))
+
+		require.NoError(t, applyRefreshTokenFamilyLifetime(model.OidcClient{}, session, now))
+		require.Equal(t, now.Add(24*time.Hour), session.GetExpiresAt(fosite.RefreshToken))
+	})
+}
+
+func TestTokenHandlerRefreshTokenPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL = "https://issuer.example.com"
+		secret  = "test-secret"
+	)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer := testTokenSigner{key: key}
+
+	globalSecret, err := DeriveGlobalSecret([]byte(secret))
+	require.NoError(t, err)
+	strategy := compose.NewOAuth2HMACStrategy(&fosite.Config{
+		GlobalSecret:         globalSecret,
+		RefreshTokenLifespan: 30 * 24 * time.Hour,
+	})
+
+	// mintRefreshToken stores an active refresh-token session with the given granted scope and returns the opaque token
+	mintRefreshToken := func(t *testing.T, db *gorm.DB, clientID, userID string, grantedScope fosite.Arguments) string {
+		t.Helper()
+		token, signature, err := strategy.GenerateRefreshToken(t.Context(), nil)
+		require.NoError(t, err)
+
+		now := time.Now().UTC()
+		session := NewEmptySession()
+		session.Subject = userID
+		session.Claims = &fositejwt.IDTokenClaims{
+			Subject:     userID,
+			RequestedAt: now,
+			AuthTime:    now,
+			Extra:       map[string]any{},
+		}
+		session.SetExpiresAt(fosite.RefreshToken, now.Add(30*24*time.Hour))
+		session.SetExpiresAt(fosite.AccessToken, now.Add(time.Hour))
+
+		request := fosite.NewRequest()
+		request.ID = "refresh-req-" + userID
+		request.RequestedAt = now
+		request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: clientID}, IsPublic: true}}
+		request.RequestedScope = grantedScope
+		request.GrantedScope = grantedScope
+		request.RequestedAudience = fosite.Arguments{clientID}
+		request.GrantedAudience = fosite.Arguments{clientID}
+		request.Session = session
+
+		require.NoError(t, NewStore(db, nil).CreateRefreshTokenSession(t.Context(), signature, "", request))
+		return token
+	}
+
+	doRefresh := func(t *testing.T, db *gorm.DB, auditLogger AuditLogger, clientID, refreshToken string) map[string]any {
+		t.Helper()
+		provider, err := newProvider(NewStore(db, nil), nil, signer, Config{
+			BaseURL:      baseURL,
+			TokenBaseURL: baseURL,
+			Secret:       []byte(secret),
+		}, nil)
+		require.NoError(t, err)
+		handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, auditLogger, db)
+
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+			"client_id":     {clientID},
+		}
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		handler.token(c)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	seedUserAndClient := func(t *testing.T, db *gorm.DB, client model.OidcClient, userID string) {
+		t.Helper()
+		require.NoError(t, db.Create(&client).Error)
+		require.NoError(t, db.Create(&model.User{Base: model.Base{ID: userID}, Username: "tim"}).Error)
+	}
+
+	// rotateAt backdates the rotation of a refresh token that was already exchanged
+	rotateAt := func(t *testing.T, db *gorm.DB, refreshToken string, rotatedAt time.Time) {
+		t.Helper()
+		signature := strategy.RefreshTokenSignature(t.Context(), refreshToken)
+		require.NoError(t, db.Model(&OAuth2Session{}).
+			Where("kind = ? AND key = ?", sessionKindRefreshToken, signature).
+			Update("rotated_at", new(datatype.DateTime(rotatedAt))).Error)
+	}
+
+	t.Run("refresh without offline_access is rejected when the client requires it", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		const clientID, userID = "client-offline", "user-offline"
+		seedUserAndClient(t, db, model.OidcClient{Base: model.Base{ID: clientID}, Name: "Client", IsPublic: true, RefreshTokenRequiresOfflineAccess: true}, userID)
+
+		body := doRefresh(t, db, nil, clientID, mintRefreshToken(t, db, clientID, userID, fosite.Arguments{"openid"}))
+		require.Empty(t, body["access_token"])
+		require.Equal(t, "invalid_grant", body["error"])
+
+		body = doRefresh(t, db, nil, clientID, mintRefreshToken(t, db, clientID, userID+"-offline", fosite.Arguments{"openid", offlineAccessScope}))
+		require.NotEmpty(t, body["access_token"], "expected a new access token, got error: %v", body["error"])
+		require.NotEmpty(t, body["refresh_token"])
+	})
+
+	t.Run("rotated token is accepted again within the grace window", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		const clientID, userID = "client-grace", "user-grace"
+		seedUserAndClient(t, db, model.OidcClient{Base: model.Base{ID: clientID}, Name: "Client", IsPublic: true, RefreshTokenReuseGraceSeconds: 30}, userID)
+		auditLogger := &fakeAuditLogger{}
+
+		token := mintRefreshToken(t, db, clientID, userID, fosite.Arguments{"openid"})
+		first := doRefresh(t, db, auditLogger, clientID, token)
+		require.NotEmpty(t, first["refresh_token"], "expected a rotated refresh token, got error: %v", first["error"])
+
+		second := doRefresh(t, db, auditLogger, clientID, token)
+		require.NotEmpty(t, second["access_token"], "expected a new access token, got error: %v", second["error"])
+		require.Empty(t, auditLogger.events)
+
+		// The token the first refresh issued must still work
+		third := doRefresh(t, db, auditLogger, clientID, first["refresh_token"].(string))
+		require.NotEmpty(t, third["access_token"], "expected a new access token, got error: %v", third["error"])
+	})
+
+	t.Run("rotated token presented after the grace window revokes the family and is audited", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		const clientID, userID = "client-reuse", "user-reuse"
+		seedUserAndClient(t, db, model.OidcClient{Base: model.Base{ID: clientID}, Name: "Reuse Client", IsPublic: true, RefreshTokenReuseGraceSeconds: 30}, userID)
+		auditLogger := &fakeAuditLogger{}
+
+		token := mintRefreshToken(t, db, clientID, userID, fosite.Arguments{"openid"})
+		first := doRefresh(t, db, auditLogger, clientID, token)
+		require.NotEmpty(t, first["refresh_token"], "expected a rotated refresh token, got error: %v", first["error"])
+		rotateAt(t, db, token, time.Now().Add(-time.Minute))
+
+		reused := doRefresh(t, db, auditLogger, clientID, token)
+		require.Empty(t, reused["access_token"])
+		require.Equal(t, []model.AuditLogEvent{model.AuditLogEventRefreshTokenReused}, auditLogger.events)
+		require.Equal(t, model.AuditLogData{"clientName": "Reuse Client"}, auditLogger.data[0])
+
+		// The family is revoked, so the token the first refresh issued no longer works either
+		revoked := doRefresh(t, db, auditLogger, clientID, first["refresh_token"].(string))
+		require.Empty(t, revoked["access_token"])
+	})
+}
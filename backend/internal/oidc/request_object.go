@@ -0,0 +1,291 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/ory/fosite"
+)
+
+// requestObjectSigningAlgorithms are the algorithms accepted for signed request objects
+// Symmetric algorithms are not supported, as Pocket ID only stores hashes of client secrets
+var requestObjectSigningAlgorithms = []jwa.SignatureAlgorithm{
+	jwa.ES256(), jwa.ES384(), jwa.ES512(),
+	jwa.RS256(), jwa.RS384(), jwa.RS512(),
+	jwa.PS256(), jwa.PS384(), jwa.PS512(),
+	jwa.EdDSA(),
+}
+
+// requestObjectRegisteredClaims are the JWT claims of a request object that are not authorization request parameters
+var requestObjectRegisteredClaims = []string{"iss", "aud", "exp", "iat", "nbf", "jti", "sub"}
+
+// clientAuthenticationParameters are kept from the outer request when a pushed authorization request is replaced by its request object, as the client authenticates outside of it
+var clientAuthenticationParameters = []string{"client_secret", "client_assertion", "client_assertion_type"}
+
+// RequestObjectSigningAlgValuesSupported returns the algorithms accepted for request objects, for the request_object_signing_alg_values_supported server metadata
+// Unsigned request objects are still accepted, unless the client requires signed ones
+func RequestObjectSigningAlgValuesSupported() []string {
+	algs := make([]string, 0, len(requestObjectSigningAlgorithms)+1)
+	for _, alg := range requestObjectSigningAlgorithms {
+		algs = append(algs, alg.String())
+	}
+	return append(algs, jwa.NoSignature().String())
+}
+
+// requestObjectVerifier verifies signed request objects (JAR, RFC 9101) and replaces the authorization request parameters with the ones they carry
+// fosite only accepts unsigned request objects, so signed ones are unwrapped before the request reaches it
+type requestObjectVerifier struct {
+	clients clientGetter
+	keys    *federatedClientAuthenticator
+	issuer  string
+}
+
+func newRequestObjectVerifier(clients clientGetter, keys *federatedClientAuthenticator, issuer string) *requestObjectVerifier {
+	return &requestObjectVerifier{
+		clients: clients,
+		keys:    keys,
+		issuer:  issuer,
+	}
+}
+
+// resolve verifies the request object of an authorization request, and enforces it for clients that require signed request objects
+// The form of r is replaced with the parameters of the request object, plus the outer parameters listed in keep
+func (v *requestObjectVerifier) resolve(ctx context.Context, r *http.Request, keep ...string) error {
+	err := r.ParseForm()
+	if err != nil {
+		return fosite.ErrInvalidRequest.WithHint("Unable to parse the request.").WithWrap(err)
+	}
+	// Request objects are only passed by reference through pushed authorization requests, whose request URIs never reach this point
+	// Fetching request objects from URLs the client chooses isn't supported, as request_uri_parameter_supported tells clients
+	if r.Form.Get("request_uri") != "" {
+		return fosite.ErrRequestURINotSupported.WithHint("Request objects can only be passed by reference through a pushed authorization request.")
+	}
+
+	clientID := r.Form.Get("client_id")
+	if clientID == "" {
+		// Clients that authenticate a pushed authorization request with client_secret_basic may only send their ID in the Authorization header
+		username, _, ok := r.BasicAuth()
+		if ok {
+			clientID, _ = url.QueryUnescape(username)
+		}
+	}
+	if clientID == "" {
+		// fosite reports the missing client
+		return nil
+	}
+	client, err := v.clients.GetClient(ctx, clientID)
+	if err != nil {
+		// fosite resolves the client again and reports the error in the same way as for any other request
+		return nil //nolint:nilerr
+	}
+	oidcClient, ok := client.(Client)
+	if !ok {
+		return nil
+	}
+
+	requestObject := r.Form.Get("request")
+	if requestObject == "" {
+		if oidcClient.RequiresSignedRequestObject {
+			return fosite.ErrInvalidRequest.WithHint("The client must send its authorization request parameters in a signed request object.")
+		}
+		return nil
+	}
+
+	msg, err := jws.Parse([]byte(requestObject))
+	if err != nil || len(msg.Signatures()) != 1 {
+		// Unsigned request objects are not JWS, and are left to fosite
+		if !oidcClient.RequiresSignedRequestObject {
+			return nil
+		}
+		return fosite.ErrInvalidRequestObject.WithHint("The request object must be signed.")
+	}
+	alg, ok := msg.Signatures()[0].ProtectedHeaders().Algorithm()
+	if !ok || alg == jwa.NoSignature() {
+		if !oidcClient.RequiresSignedRequestObject {
+			return nil
+		}
+		return fosite.ErrInvalidRequestObject.WithHint("The request object must be signed.")
+	}
+	if !slices.Contains(requestObjectSigningAlgorithms, alg) {
+		return fosite.ErrInvalidRequestObject.WithHintf("The request object is signed with the unsupported algorithm '%s'.", alg.String())
+	}
+
+	params, err := v.verify(ctx, oidcClient, []byte(requestObject))
+	if err != nil {
+		return err
+	}
+
+	// RFC 9101 section 6.3: only the parameters in the request object are used, the outer ones are ignored
+	for _, key := range slices.Concat(keep, []string{"client_id"}) {
+		if values, ok := r.Form[key]; ok {
+			params[key] = values
+		}
+	}
+	r.Form = params
+	r.PostForm = url.Values{}
+	if r.Method == http.MethodPost {
+		r.PostForm = params
+	}
+	r.URL.RawQuery = ""
+	if r.Method != http.MethodPost {
+		r.URL.RawQuery = params.Encode()
+	}
+	return nil
+}
+
+// verify checks the signature and claims of a request object and returns the authorization request parameters it carries
+func (v *requestObjectVerifier) verify(ctx context.Context, client Client, requestObject []byte) (url.Values, error) {
+	keySets, err := v.clientKeySets(ctx, client)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("Unable to load the keys of the client.").WithWrap(err)
+	}
+	if len(keySets) == 0 {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("The client has no keys registered to verify request objects.")
+	}
+
+	var verifyErr error
+	for _, keySet := range keySets {
+		_, err = jwt.Parse(requestObject,
+			jwt.WithKeySet(keySet, jws.WithInferAlgorithmFromKey(true), jws.WithUseDefault(true)),
+			jwt.WithValidate(true),
+			jwt.WithAcceptableSkew(30*time.Second),
+			jwt.WithRequiredClaim(jwt.ExpirationKey),
+			jwt.WithIssuer(client.GetID()),
+			jwt.WithAudience(v.issuer),
+		)
+		if err == nil {
+			verifyErr = nil
+			break
+		}
+		verifyErr = err
+	}
+	if verifyErr != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("The request object could not be verified.").WithWrap(verifyErr)
+	}
+
+	msg, err := jws.Parse(requestObject)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithWrap(err)
+	}
+	params, err := requestObjectParameters(msg.Payload())
+	if err != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithWrap(err)
+	}
+
+	if claimedClientID := params.Get("client_id"); claimedClientID != "" && claimedClientID != client.GetID() {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("The client_id of the request object does not match the client_id of the request.")
+	}
+	if params.Has("request") || params.Has("request_uri") {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("A request object must not contain the 'request' or 'request_uri' parameters.")
+	}
+
+	return params, nil
+}
+
+// clientKeySets returns the key sets the client may sign request objects with: its registered JWKS, or else the keys of its federated identities
+func (v *requestObjectVerifier) clientKeySets(ctx context.Context, client Client) ([]jwk.Set, error) {
+	credentials := client.Credentials
+	if credentials.JWKS != "" {
+		keySet, err := jwk.ParseString(credentials.JWKS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client JWKS: %w", err)
+		}
+		return []jwk.Set{keySet}, nil
+	}
+
+	var jwksURLs []string
+	// The JWKS URI of a self-registered client is chosen by a third party, while federated identities are always configured by an admin
+	publicOnly := credentials.JWKSURI != "" && client.IsSelfRegistered()
+	if credentials.JWKSURI != "" {
+		jwksURLs = append(jwksURLs, credentials.JWKSURI)
+	} else {
+		for _, federatedIdentity := range credentials.FederatedIdentities {
+			jwksURLs = append(jwksURLs, issuerJWKSURL(federatedIdentity.Issuer, federatedIdentity.JWKS))
+		}
+	}
+	if len(jwksURLs) == 0 {
+		return nil, nil
+	}
+	if v.keys == nil {
+		return nil, errors.New("fetching remote key sets is not available")
+	}
+
+	keySets := make([]jwk.Set, 0, len(jwksURLs))
+	for _, jwksURL := range jwksURLs {
+		keySet, err := v.keys.fetchJWKSet(ctx, jwksURL, publicOnly)
+		if err != nil {
+			return nil, err
+		}
+		keySets = append(keySets, keySet)
+	}
+	return keySets, nil
+}
+
+// requestObjectParameters converts the claims of a request object to authorization request parameters
+// Arrays of strings, such as resource, become repeated parameters, and other structured values, such as claims, keep their JSON encoding
+func requestObjectParameters(payload []byte) (url.Values, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var claims map[string]any
+	err := decoder.Decode(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request object claims: %w", err)
+	}
+
+	params := url.Values{}
+	for key, value := range claims {
+		if slices.Contains(requestObjectRegisteredClaims, key) {
+			continue
+		}
+
+		switch value := value.(type) {
+		case string:
+			params.Set(key, value)
+		case json.Number:
+			params.Set(key, value.String())
+		case bool:
+			params.Set(key, fmt.Sprint(value))
+		case nil:
+			continue
+		default:
+			if values, ok := stringValues(value); ok {
+				params[key] = values
+				continue
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode request object claim %q: %w", key, err)
+			}
+			params.Set(key, string(encoded))
+		}
+	}
+	return params, nil
+}
+
+func stringValues(value any) ([]string, bool) {
+	items, ok := value.([]any)
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i], ok = item.(string)
+		if !ok {
+			return nil, false
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,285 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func newTestRequestObjectKey(t *testing.T) (jwk.Key, string) {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key, err := jwk.Import(privateKey)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, "request-object-key"))
+
+	publicKey, err := key.PublicKey()
+	require.NoError(t, err)
+	set := jwk.NewSet()
+	require.NoError(t, set.AddKey(publicKey))
+	jwks, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	return key, string(jwks)
+}
+
+func signRequestObject(t *testing.T, key jwk.Key, claims map[string]any) string {
+	t.Helper()
+
+	token := jwt.New()
+	for name, value := range claims {
+		require.NoError(t, token.Set(name, value))
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), key))
+	require.NoError(t, err)
+	return string(signed)
+}
+
+func TestRequestObjectVerifierResolve(t *testing.T) {
+	const (
+		issuer   = "https://issuer.example.com"
+		clientID = "jar-client"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	key, jwks := newTestRequestObjectKey(t)
+	otherKey, _ := newTestRequestObjectKey(t)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:                        model.Base{ID: clientID},
+		Name:                        "JAR Client",
+		RequiresSignedRequestObject: true,
+		Credentials:                 model.OidcClientCredentials{JWKS: jwks},
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base: model.Base{ID: "plain-client"},
+		Name: "Plain Client",
+	}).Error)
+
+	verifier := newRequestObjectVerifier(NewStore(db, nil), nil, issuer)
+
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"iss":           clientID,
+			"aud":           issuer,
+			"exp":           time.Now().Add(time.Minute).Unix(),
+			"client_id":     clientID,
+			"response_type": "code",
+			"redirect_uri":  "https://client.example.com/callback",
+			"scope":         "openid profile",
+			"resource":      []string{"https://api.example.com", "https://other.example.com"},
+			"claims":        map[string]any{"userinfo": map[string]any{"email": nil}},
+		}
+	}
+
+	newRequest := func(t *testing.T, query url.Values) *http.Request {
+		return httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/authorize?"+query.Encode(), nil)
+	}
+
+	t.Run("valid signed request object replaces the parameters", func(t *testing.T) {
+		req := newRequest(t, url.Values{
+			"client_id": {clientID},
+			"scope":     {"openid email"},
+			"request":   {signRequestObject(t, key, validClaims())},
+		})
+
+		require.NoError(t, verifier.resolve(t.Context(), req))
+		assert.Equal(t, "openid profile", req.Form.Get("scope"))
+		assert.Equal(t, clientID, req.Form.Get("client_id"))
+		assert.Equal(t, []string{"https://api.example.com", "https://other.example.com"}, req.Form["resource"])
+		assert.JSONEq(t, `{"userinfo":{"email":null}}`, req.Form.Get("claims"))
+		assert.False(t, req.Form.Has("request"))
+		assert.False(t, req.Form.Has("iss"))
+		assert.Equal(t, req.Form.Encode(), req.URL.RawQuery)
+	})
+
+	t.Run("request object signed with another key", func(t *testing.T) {
+		req := newRequest(t, url.Values{
+			"client_id": {clientID},
+			"request":   {signRequestObject(t, otherKey, validClaims())},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequestObject)
+	})
+
+	t.Run("request object for another audience", func(t *testing.T) {
+		claims := validClaims()
+		claims["aud"] = "https://other-issuer.example.com"
+		req := newRequest(t, url.Values{
+			"client_id": {clientID},
+			"request":   {signRequestObject(t, key, claims)},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequestObject)
+	})
+
+	t.Run("request object issued by another client", func(t *testing.T) {
+		claims := validClaims()
+		claims["iss"] = "plain-client"
+		req := newRequest(t, url.Values{
+			"client_id": {clientID},
+			"request":   {signRequestObject(t, key, claims)},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequestObject)
+	})
+
+	t.Run("mismatching client_id", func(t *testing.T) {
+		claims := validClaims()
+		claims["client_id"] = "plain-client"
+		req := newRequest(t, url.Values{
+			"client_id": {clientID},
+			"request":   {signRequestObject(t, key, claims)},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequestObject)
+	})
+
+	t.Run("expired request object", func(t *testing.T) {
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		req := newRequest(t, url.Values{
+			"client_id": {clientID},
+			"request":   {signRequestObject(t, key, claims)},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequestObject)
+	})
+
+	t.Run("client requiring signed request objects sends plain parameters", func(t *testing.T) {
+		req := newRequest(t, url.Values{
+			"client_id":     {clientID},
+			"response_type": {"code"},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequest)
+	})
+
+	t.Run("client requiring signed request objects sends an unsigned one", func(t *testing.T) {
+		req := newRequest(t, url.Values{
+			"client_id": {clientID},
+			"request":   {encodeRequestObject(t, map[string]any{"alg": "none"}, validClaims())},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequestObject)
+	})
+
+	t.Run("unsigned request objects of other clients are left to fosite", func(t *testing.T) {
+		requestObject := encodeRequestObject(t, map[string]any{"alg": "none"}, map[string]any{"scope": "openid"})
+		req := newRequest(t, url.Values{
+			"client_id": {"plain-client"},
+			"request":   {requestObject},
+		})
+
+		require.NoError(t, verifier.resolve(t.Context(), req))
+		assert.Equal(t, requestObject, req.Form.Get("request"))
+	})
+
+	t.Run("request object passed by a reference that is not a pushed authorization request", func(t *testing.T) {
+		req := newRequest(t, url.Values{
+			"client_id":   {"plain-client"},
+			"request_uri": {"https://client.example.com/request.jwt"},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrRequestURINotSupported)
+	})
+
+	t.Run("signed request object of a client without keys", func(t *testing.T) {
+		claims := validClaims()
+		claims["iss"] = "plain-client"
+		claims["client_id"] = "plain-client"
+		req := newRequest(t, url.Values{
+			"client_id": {"plain-client"},
+			"request":   {signRequestObject(t, key, claims)},
+		})
+
+		err := verifier.resolve(t.Context(), req)
+		require.ErrorIs(t, err, fosite.ErrInvalidRequestObject)
+	})
+}
+
+func TestRequestObjectVerifierResolvePushedAuthorizationRequest(t *testing.T) {
+	const (
+		issuer   = "https://issuer.example.com"
+		clientID = "jar-client"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	key, jwks := newTestRequestObjectKey(t)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:        model.Base{ID: clientID},
+		Name:        "JAR Client",
+		Credentials: model.OidcClientCredentials{JWKS: jwks},
+	}).Error)
+
+	verifier := newRequestObjectVerifier(NewStore(db, nil), nil, issuer)
+
+	requestObject := signRequestObject(t, key, map[string]any{
+		"iss":           clientID,
+		"aud":           issuer,
+		"exp":           time.Now().Add(time.Minute).Unix(),
+		"response_type": "code",
+		"scope":         "openid",
+	})
+	form := url.Values{
+		"client_id":     {clientID},
+		"client_secret": {"client-secret"},
+		"state":         {"ignored"},
+		"request":       {requestObject},
+	}
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/par", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	require.NoError(t, verifier.resolve(t.Context(), req, clientAuthenticationParameters...))
+	assert.Equal(t, "openid", req.PostForm.Get("scope"))
+	assert.Equal(t, clientID, req.PostForm.Get("client_id"))
+	assert.Equal(t, "client-secret", req.PostForm.Get("client_secret"))
+	assert.False(t, req.PostForm.Has("state"))
+}
+
+func TestRequestObjectParameters(t *testing.T) {
+	params, err := requestObjectParameters([]byte(`{
+		"iss": "client",
+		"aud": "https://issuer.example.com",
+		"exp": 1893456000,
+		"scope": "openid",
+		"max_age": 300,
+		"resource": ["https://api.example.com"],
+		"ui_locales": null,
+		"authorization_details": [{"type": "payment"}]
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, url.Values{
+		"scope":                 {"openid"},
+		"max_age":               {"300"},
+		"resource":              {"https://api.example.com"},
+		"authorization_details": {`[{"type":"payment"}]`},
+	}, params)
+}
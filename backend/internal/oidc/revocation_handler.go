@@ -0,0 +1,94 @@
+package oidc
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+type revocationHandler struct {
+	provider fosite.OAuth2Provider
+	auditLog AuditLogger
+	db       *gorm.DB
+}
+
+func newRevocationHandler(provider fosite.OAuth2Provider, auditLog AuditLogger, db *gorm.DB) *revocationHandler {
+	return &revocationHandler{
+		provider: provider,
+		auditLog: auditLog,
+		db:       db,
+	}
+}
+
+// revokeToken godoc
+// @Summary Revoke OIDC tokens
+// @Description Revoke an access token or refresh token as defined by RFC 7009. Revoking a refresh token also revokes the access tokens issued with it.
+// @Tags OIDC
+// @Accept application/x-www-form-urlencoded
+// @Param token formData string true "The token to be revoked."
+// @Param token_type_hint formData string false "A hint about the type of the token: access_token or refresh_token."
+// @Success 200 "The token was revoked, or it was already invalid."
+// @Router /api/oidc/revoke [post]
+func (h *revocationHandler) revokeToken(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Look the token up before revoking it, as it can't be resolved anymore afterwards and the audit log needs to know whom it belonged to
+	tokenUse, requester := h.lookupToken(ctx, c.PostForm("token"), c.PostForm("token_type_hint"))
+
+	// Fosite authenticates the client through the same strategy as the token endpoint, so every client authentication method (including federated client assertions) is accepted here too
+	// Unknown or already invalid tokens are not an error, as required by RFC 7009 section 2.2
+	err := h.provider.NewRevocationRequest(ctx, c.Request)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to revoke token", "error", err)
+		h.provider.WriteRevocationResponse(ctx, c.Writer, err)
+		return
+	}
+
+	if requester != nil {
+		h.createAuditLog(ctx, c, tokenUse, requester)
+	}
+
+	h.provider.WriteRevocationResponse(ctx, c.Writer, nil)
+}
+
+// lookupToken resolves the token that is about to be revoked, returning a nil requester if it is unknown or already inactive
+func (h *revocationHandler) lookupToken(ctx context.Context, token string, tokenTypeHint string) (fosite.TokenUse, fosite.AccessRequester) {
+	if token == "" {
+		return "", nil
+	}
+
+	tokenUse, requester, err := h.provider.IntrospectToken(ctx, token, fosite.TokenUse(tokenTypeHint), NewEmptySession())
+	if err != nil {
+		return "", nil
+	}
+
+	return tokenUse, requester
+}
+
+func (h *revocationHandler) createAuditLog(ctx context.Context, c *gin.Context, tokenUse fosite.TokenUse, requester fosite.AccessRequester) {
+	if h.auditLog == nil {
+		return
+	}
+
+	// Audit log entries belong to a user, so tokens issued with the client credentials grant are not recorded
+	subject := requester.GetSession().GetSubject()
+	if subject == "" || strings.HasPrefix(subject, clientCredentialsSubjectPrefix) {
+		return
+	}
+
+	data := model.AuditLogData{
+		"tokenType": string(tokenUse),
+	}
+	if client, ok := requester.GetClient().(Client); ok {
+		data["clientName"] = client.Name
+	}
+
+	meta := requestMetaFromGin(c)
+	h.auditLog.Create(ctx, model.AuditLogEventTokenRevoked, meta.IPAddress, meta.UserAgent, subject, data, h.db.WithContext(ctx))
+}
@@ -0,0 +1,116 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestRevocationHandlerRevokesAccessToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := testutils.NewDatabaseForTest(t)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "client-a"}, Name: "Client A", IsPublic: true}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{Base: model.Base{ID: "client-b"}, Name: "Client B", IsPublic: true}).Error)
+
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// #nosec G101
+	provider, err := newProvider(NewStore(db, nil), nil, testTokenSigner{key: signerKey}, Config{
+		BaseURL:      "https://issuer.example.com",
+		TokenBaseURL: "https://issuer.example.com",
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+
+	issueAccessToken := func(t *testing.T, requestID, clientID, subject string) string {
+		t.Helper()
+		session := NewEmptySession()
+		session.Subject = subject
+		session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(time.Hour))
+
+		request := fosite.NewAccessRequest(session)
+		request.ID = requestID
+		request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: clientID}, Name: "Client"}}
+		request.GrantTypes = fosite.Arguments{string(fosite.GrantTypeClientCredentials)}
+		request.RequestedAudience = fosite.Arguments{clientID}
+		request.GrantedAudience = fosite.Arguments{clientID}
+
+		response, err := provider.NewAccessResponse(t.Context(), request)
+		require.NoError(t, err)
+		return response.GetAccessToken()
+	}
+
+	isActive := func(t *testing.T, token string) bool {
+		t.Helper()
+		_, _, err := provider.IntrospectToken(t.Context(), token, fosite.AccessToken, NewEmptySession())
+		return err == nil
+	}
+
+	auditLogger := &fakeAuditLogger{}
+	handler := newRevocationHandler(provider, auditLogger, db)
+
+	revoke := func(t *testing.T, clientID, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := url.Values{"token": {token}, "client_id": {clientID}}.Encode()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/revoke", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+
+		handler.revokeToken(c)
+		return rec
+	}
+
+	t.Run("rejects revoking a token issued to another client", func(t *testing.T) {
+		token := issueAccessToken(t, "req-foreign", "client-a", "user-a")
+
+		rec := revoke(t, "client-b", token)
+		require.NotEqual(t, http.StatusOK, rec.Code)
+		require.True(t, isActive(t, token))
+	})
+
+	t.Run("revokes the token and records an audit log entry", func(t *testing.T) {
+		auditLogger.events = nil
+		token := issueAccessToken(t, "req-own", "client-a", "user-a")
+
+		rec := revoke(t, "client-a", token)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.False(t, isActive(t, token))
+		require.Equal(t, []model.AuditLogEvent{model.AuditLogEventTokenRevoked}, auditLogger.events)
+	})
+
+	t.Run("answers unknown tokens with success", func(t *testing.T) {
+		auditLogger.events = nil
+
+		rec := revoke(t, "client-a", "not-a-token")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, auditLogger.events)
+	})
+
+	t.Run("does not record client credentials tokens in the audit log", func(t *testing.T) {
+		auditLogger.events = nil
+		token := issueAccessToken(t, "req-client", "client-a", clientCredentialsSubjectPrefix+"client-a")
+
+		rec := revoke(t, "client-a", token)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.False(t, isActive(t, token))
+		require.Empty(t, auditLogger.events)
+	})
+}
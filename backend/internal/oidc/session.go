@@ -22,6 +22,80 @@ type Session struct {
 	ExpiresAt            map[fosite.TokenType]time.Time `json:"expires_at,omitempty"`
 	Subject              string                         `json:"subject"`
 	AuthenticationMethod string                         `json:"authentication_method,omitempty"`
+	// AuthenticationContextClass is the authentication context class the user authenticated with, and is released to clients as the "acr" claim
+	AuthenticationContextClass string `json:"authentication_context_class,omitempty"`
+	// ClaimsRequest is the claims parameter of the authorization request, which the ID token and userinfo claims are released by along with the scopes
+	ClaimsRequest *ClaimsRequest `json:"claims_request,omitempty"`
+	// AuthorizationDetails are the RFC 9396 authorization details granted to the client, and are released to resource servers as the "authorization_details" claim
+	AuthorizationDetails []AuthorizationDetail `json:"authorization_details,omitempty"`
+	// NarrowedAuthorizationDetails are the authorization details the client narrowed the access token down to at the token endpoint, nil if it carries all of AuthorizationDetails
+	// They're kept apart, so the refresh token stored from the same session keeps the whole grant, and can't be omitted when empty, as an empty list narrows down to none
+	NarrowedAuthorizationDetails []AuthorizationDetail `json:"narrowed_authorization_details"`
+	// ResourceGrants splits the grant of an authorization for several RFC 8707 resources by resource, as each access token is only issued for one of them
+	ResourceGrants []ResourceGrant `json:"resource_grants,omitempty"`
+	// RefreshTokenFamilyIssuedAt is when the first refresh token of the authorization was issued, which the client's absolute refresh token lifetime counts from
+	RefreshTokenFamilyIssuedAt *time.Time `json:"refresh_token_family_issued_at,omitempty"`
+	// SessionID identifies the Pocket ID browser session the authorization was granted in, and is released to clients as the "sid" claim
+	SessionID string `json:"session_id,omitempty"`
+	// Confirmation holds the key or certificate the tokens are bound to, and is released to resource servers as the "cnf" claim
+	Confirmation *TokenConfirmation `json:"cnf,omitempty"`
+	// Actor identifies the client acting on behalf of the subject of an exchanged token, and is released to resource servers as the "act" claim
+	Actor *TokenActor `json:"act,omitempty"`
+	// AccessTokenUserClaims are the user claims the client's claim policy puts in the access token
+	AccessTokenUserClaims map[string]any `json:"access_token_user_claims,omitempty"`
+}
+
+// TokenConfirmation is the confirmation claim of sender-constrained tokens, as defined by RFC 7800
+type TokenConfirmation struct {
+	// JKT is the JWK SHA-256 thumbprint of the DPoP key, as defined by RFC 9449 section 6
+	JKT string `json:"jkt,omitempty"`
+	// X509Thumbprint is the SHA-256 thumbprint of the client certificate, as defined by RFC 8705 section 3.1
+	X509Thumbprint string `json:"x5t#S256,omitempty"`
+}
+
+// TokenActor is the actor claim of tokens issued with the token exchange grant, as defined by RFC 8693 section 4.1
+// A token that was exchanged again nests the previous actors, the outermost being the current one
+type TokenActor struct {
+	Subject string      `json:"sub"`
+	Actor   *TokenActor `json:"act,omitempty"`
+}
+
+func (c *TokenConfirmation) isEmpty() bool {
+	return c == nil || (c.JKT == "" && c.X509Thumbprint == "")
+}
+
+func (s *Session) dpopKeyThumbprint() string {
+	if s == nil || s.Confirmation == nil {
+		return ""
+	}
+	return s.Confirmation.JKT
+}
+
+func (s *Session) setDPoPKeyThumbprint(jkt string) {
+	if s.Confirmation == nil {
+		s.Confirmation = &TokenConfirmation{}
+	}
+	s.Confirmation.JKT = jkt
+	if s.Confirmation.isEmpty() {
+		s.Confirmation = nil
+	}
+}
+
+func (s *Session) certificateThumbprint() string {
+	if s == nil || s.Confirmation == nil {
+		return ""
+	}
+	return s.Confirmation.X509Thumbprint
+}
+
+func (s *Session) setCertificateThumbprint(x5t string) {
+	if s.Confirmation == nil {
+		s.Confirmation = &TokenConfirmation{}
+	}
+	s.Confirmation.X509Thumbprint = x5t
+	if s.Confirmation.isEmpty() {
+		s.Confirmation = nil
+	}
 }
 
 func NewEmptySession() *Session {
@@ -72,13 +146,35 @@ func (s *Session) GetUsername() string {
 }
 
 func (s *Session) GetExtraClaims() map[string]interface{} {
-	if s == nil || s.Claims == nil || s.Claims.Issuer == "" {
-		return map[string]interface{}{}
+	extra := map[string]interface{}{}
+	if s == nil {
+		return extra
+	}
+	if s.Claims != nil && s.Claims.Issuer != "" {
+		extra["iss"] = s.Claims.Issuer
+	}
+	// Introspection reports the binding, so resource servers can check the proof of possession themselves
+	if !s.Confirmation.isEmpty() {
+		extra["cnf"] = s.Confirmation
+	}
+	if s.Actor != nil {
+		extra["act"] = s.Actor
+	}
+	if s.AuthenticationContextClass != "" {
+		extra["acr"] = s.AuthenticationContextClass
+	}
+	if details := s.accessTokenAuthorizationDetails(); len(details) > 0 {
+		extra["authorization_details"] = details
 	}
+	return extra
+}
 
-	return map[string]interface{}{
-		"iss": s.Claims.Issuer,
+// accessTokenAuthorizationDetails returns the authorization details the access token carries
+func (s *Session) accessTokenAuthorizationDetails() []AuthorizationDetail {
+	if s.NarrowedAuthorizationDetails != nil {
+		return s.NarrowedAuthorizationDetails
 	}
+	return s.AuthorizationDetails
 }
 
 func (s *Session) GetSubject() string {
@@ -134,9 +230,42 @@ func (s *Session) GetJWTClaims() fositejwt.JWTClaimsContainer {
 	if s.JWTClaims.Extra == nil {
 		s.JWTClaims.Extra = map[string]interface{}{}
 	}
+	for name, value := range s.AccessTokenUserClaims {
+		s.JWTClaims.Extra[name] = value
+	}
+	// The binding is kept in sync with the session, as the claims of a refreshed session are reused for the new access token
+	if s.Confirmation.isEmpty() {
+		delete(s.JWTClaims.Extra, "cnf")
+	} else {
+		s.JWTClaims.Extra["cnf"] = s.Confirmation
+	}
+	if s.Actor != nil {
+		s.JWTClaims.Extra["act"] = s.Actor
+	}
+	// Resource servers can check the authentication context class themselves, and ask for a step-up as described by RFC 9470
+	if s.AuthenticationContextClass != "" {
+		s.JWTClaims.Extra["acr"] = s.AuthenticationContextClass
+	}
+	// The authorization details can be narrowed at the token endpoint, so they're kept in sync like the binding
+	if details := s.accessTokenAuthorizationDetails(); len(details) == 0 {
+		delete(s.JWTClaims.Extra, "authorization_details")
+	} else {
+		s.JWTClaims.Extra["authorization_details"] = details
+	}
 	return s.JWTClaims
 }
 
+// setAccessTokenUserClaims replaces the user claims of the access token
+// The claims of a refreshed session are reused for the new access token, so the ones the user no longer has are removed from them
+func (s *Session) setAccessTokenUserClaims(claims map[string]any) {
+	if s.JWTClaims != nil {
+		for name := range s.AccessTokenUserClaims {
+			delete(s.JWTClaims.Extra, name)
+		}
+	}
+	s.AccessTokenUserClaims = claims
+}
+
 func (s *Session) GetJWTHeader() *fositejwt.Headers {
 	if s.JWTHeader == nil {
 		s.JWTHeader = &fositejwt.Headers{}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 )
 
 func TestNewAuthenticatedSession(t *testing.T) {
@@ -32,3 +34,46 @@ func TestNewAuthenticatedSessionDefaultsTimes(t *testing.T) {
 	require.False(t, session.Claims.AuthTime.After(after))
 	require.Equal(t, session.Claims.AuthTime, session.Claims.RequestedAt)
 }
+
+func TestSessionReleasesAuthenticationContextClass(t *testing.T) {
+	session := NewAuthenticatedSession("user-id", "phr", time.Time{}, time.Time{})
+	require.NotContains(t, session.GetJWTClaims().ToMapClaims(), "acr")
+
+	session.AuthenticationContextClass = common.ACRUserVerifiedPasskey
+
+	require.Equal(t, common.ACRUserVerifiedPasskey, session.GetJWTClaims().ToMapClaims()["acr"])
+	require.Equal(t, common.ACRUserVerifiedPasskey, session.GetExtraClaims()["acr"])
+}
+
+func TestSessionReleasesAuthorizationDetails(t *testing.T) {
+	session := NewAuthenticatedSession("user-id", "phr", time.Time{}, time.Time{})
+	session.AuthorizationDetails = []AuthorizationDetail{{"type": "payment_initiation"}}
+
+	require.Equal(t, session.AuthorizationDetails, session.GetJWTClaims().ToMapClaims()["authorization_details"])
+	require.Equal(t, session.AuthorizationDetails, session.GetExtraClaims()["authorization_details"])
+
+	// Narrowed authorization details replace the ones of the refreshed session in the access token, while the session keeps the whole grant
+	narrowed := []AuthorizationDetail{{"type": "payment_initiation", "identifier": "invoice-1"}}
+	session.NarrowedAuthorizationDetails = narrowed
+	require.Equal(t, narrowed, session.GetJWTClaims().ToMapClaims()["authorization_details"])
+	require.Equal(t, narrowed, session.GetExtraClaims()["authorization_details"])
+	require.Equal(t, []AuthorizationDetail{{"type": "payment_initiation"}}, session.AuthorizationDetails)
+
+	// Narrowing down to none survives storing the session
+	session.NarrowedAuthorizationDetails = []AuthorizationDetail{}
+	stored := session.Clone().(*Session)
+	require.NotContains(t, stored.GetJWTClaims().ToMapClaims(), "authorization_details")
+	require.NotContains(t, stored.GetExtraClaims(), "authorization_details")
+}
+
+// The user claims of the access token are recomputed on refresh, so the ones the user no longer has must not linger in the reused session
+func TestSessionReplacesAccessTokenUserClaims(t *testing.T) {
+	session := NewAuthenticatedSession("user-1", "phr", time.Time{}, time.Time{})
+	session.setAccessTokenUserClaims(map[string]any{"roles": []string{"admin"}})
+	require.Equal(t, []string{"admin"}, session.GetJWTClaims().ToMapClaims()["roles"])
+
+	session.setAccessTokenUserClaims(map[string]any{"tenant": "acme"})
+	claims := session.GetJWTClaims().ToMapClaims()
+	require.NotContains(t, claims, "roles")
+	require.Equal(t, "acme", claims["tenant"])
+}
@@ -3,8 +3,14 @@ package oidc
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 
 	jose "github.com/go-jose/go-jose/v4"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
 	fositejwt "github.com/ory/fosite/token/jwt"
 )
 
@@ -15,35 +21,252 @@ import (
 // *rsa.PrivateKey is signed with RS256 and every *ecdsa.PrivateKey with ES256, while an
 // ed25519.PrivateKey is not supported at all.
 func SigningKeyFromSigner(signer TokenSigner) (*jose.JSONWebKey, error) {
-	rawKey := signer.GetPrivateKey()
-	if rawKey == nil {
+	// The key, its ID and its algorithm come from one snapshot of the signing keys, so they can't belong to different keys while a rollover is being loaded
+	keys := signer.GetSigningKeys()
+	if len(keys) == 0 {
 		return nil, errors.New("signing key is not available")
 	}
-
-	alg, err := signer.GetKeyAlg()
+	key, rawKey, err := exportSigningKey(keys[0])
 	if err != nil {
 		return nil, err
 	}
 
+	alg, ok := key.Algorithm()
+	if !ok || alg == nil {
+		return nil, errors.New("failed to retrieve algorithm for key")
+	}
+
 	signingKey := &jose.JSONWebKey{
 		Key:       rawKey,
 		Algorithm: alg.String(),
 	}
 
-	if keyID, ok := signer.GetKeyID(); ok {
+	if keyID, ok := key.KeyID(); ok {
 		signingKey.KeyID = keyID
 	}
 
 	return signingKey, nil
 }
 
+// signingKeyForAlgorithm wraps the active signing key for a JWS algorithm like SigningKeyFromSigner, or the default key if no algorithm is given
+func signingKeyForAlgorithm(signer TokenSigner, alg string) (*jose.JSONWebKey, error) {
+	if alg == "" {
+		return SigningKeyFromSigner(signer)
+	}
+	signatureAlg, ok := jwa.LookupSignatureAlgorithm(alg)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing algorithm '%s'", alg)
+	}
+
+	key, rawKey, err := activeSigningKey(signer, signatureAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey := &jose.JSONWebKey{
+		Key:       rawKey,
+		Algorithm: signatureAlg.String(),
+	}
+	if keyID, ok := key.KeyID(); ok {
+		signingKey.KeyID = keyID
+	}
+	return signingKey, nil
+}
+
+// activeSigningKey returns the active signing key to sign with an algorithm, along with its raw private key
+// A key that was created for the algorithm is preferred, otherwise the first key that can be used with it is picked, starting with the default key
+func activeSigningKey(signer TokenSigner, alg jwa.SignatureAlgorithm) (jwk.Key, any, error) {
+	keys := signer.GetSigningKeys()
+	for _, key := range keys {
+		keyAlg, ok := key.Algorithm()
+		if ok && keyAlg.String() == alg.String() {
+			return exportSigningKey(key)
+		}
+	}
+	for _, key := range keys {
+		signingKey, rawKey, err := exportSigningKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if slices.Contains(signingAlgorithmsForKey(rawKey), alg) {
+			return signingKey, rawKey, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no active signing key for algorithm '%s'", alg.String())
+}
+
+func exportSigningKey(key jwk.Key) (jwk.Key, any, error) {
+	var rawKey any
+	err := jwk.Export(key, &rawKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to export signing key: %w", err)
+	}
+	return key, rawKey, nil
+}
+
+// verificationKeyByID returns the public key of the verification key with the given key ID
+func verificationKeyByID(signer TokenSigner, keyID string) (any, bool) {
+	for _, key := range signer.GetVerificationKeys() {
+		kid, ok := key.KeyID()
+		if !ok || kid != keyID {
+			continue
+		}
+		_, rawKey, err := exportSigningKey(key)
+		if err != nil {
+			return nil, false
+		}
+		return new((&jose.JSONWebKey{Key: rawKey}).Public()), true
+	}
+	return nil, false
+}
+
+// verificationKeyForToken returns the option to verify a token Pocket ID signed with one of its verification keys
+// The key is picked by the key ID in the header, and the algorithm in the header must be one the key can be used with
+func verificationKeyForToken(signer TokenSigner, tokenString string) (jwt.ParseOption, error) {
+	msg, err := jws.Parse([]byte(tokenString))
+	if err != nil {
+		return nil, err
+	}
+	signatures := msg.Signatures()
+	if len(signatures) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+	headers := signatures[0].ProtectedHeaders()
+	alg, ok := headers.Algorithm()
+	if !ok {
+		return nil, errors.New("token has no signing algorithm")
+	}
+
+	keys := signer.GetVerificationKeys()
+	if len(keys) == 0 {
+		return nil, errors.New("signing key is not available")
+	}
+	key := keys[0]
+	if keyID, ok := headers.KeyID(); ok {
+		key = nil
+		for _, k := range keys {
+			kid, ok := k.KeyID()
+			if ok && kid == keyID {
+				key = k
+				break
+			}
+		}
+		if key == nil {
+			return nil, fmt.Errorf("token is signed with unknown key '%s'", keyID)
+		}
+	}
+
+	_, rawKey, err := exportSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(signingAlgorithmsForKey(rawKey), alg) {
+		return nil, fmt.Errorf("token is signed with algorithm '%s', which its key can't be used with", alg.String())
+	}
+	return jwt.WithKey(alg, rawKey), nil
+}
+
+// signingAlgValuesSupported returns the algorithms the active signing keys can sign with, for the *_signing_alg_values_supported server metadata
+func signingAlgValuesSupported(signer TokenSigner) []string {
+	var values []string
+	for _, key := range signer.GetSigningKeys() {
+		_, rawKey, err := exportSigningKey(key)
+		if err != nil {
+			continue
+		}
+		for _, alg := range signingAlgorithmsForKey(rawKey) {
+			if !slices.Contains(values, alg.String()) {
+				values = append(values, alg.String())
+			}
+		}
+	}
+	return values
+}
+
+// IDTokenSigningAlgValuesSupported returns the algorithms ID tokens can be signed with, for the id_token_signing_alg_values_supported server metadata
+// Every active key can sign ID tokens, for the clients that registered one of its algorithms
+func IDTokenSigningAlgValuesSupported(signer TokenSigner) []string {
+	return signingAlgValuesSupported(signer)
+}
+
+// registeredSigningAlgorithm returns the signature algorithm a client registered for a response, or the algorithm of Pocket ID's signing key if it didn't register one
+func registeredSigningAlgorithm(signer TokenSigner, registered string) (jwa.SignatureAlgorithm, error) {
+	if registered == "" {
+		return serverSigningAlgorithm(signer)
+	}
+	alg, ok := jwa.LookupSignatureAlgorithm(registered)
+	if !ok {
+		return jwa.EmptySignatureAlgorithm(), fmt.Errorf("unknown signing algorithm '%s'", registered)
+	}
+	return alg, nil
+}
+
+// serverSigningAlgorithm returns the signature algorithm of Pocket ID's signing key
+func serverSigningAlgorithm(signer TokenSigner) (jwa.SignatureAlgorithm, error) {
+	keyAlg, err := signer.GetKeyAlg()
+	if err != nil {
+		return jwa.EmptySignatureAlgorithm(), fmt.Errorf("failed to get signing key algorithm: %w", err)
+	}
+	alg, ok := jwa.LookupSignatureAlgorithm(keyAlg.String())
+	if !ok {
+		return jwa.EmptySignatureAlgorithm(), fmt.Errorf("signing key algorithm '%s' is not a signature algorithm", keyAlg.String())
+	}
+	return alg, nil
+}
+
+// signJWT signs a token with the active signing key for the algorithm, adding the key ID to the header
+func signJWT(signer TokenSigner, alg jwa.SignatureAlgorithm, token jwt.Token) (string, error) {
+	return signJWTWithHeaders(signer, alg, token, jws.NewHeaders())
+}
+
+// signJWTWithHeaders signs a token like signJWT, with additional protected headers
+func signJWTWithHeaders(signer TokenSigner, alg jwa.SignatureAlgorithm, token jwt.Token, headers jws.Headers) (string, error) {
+	key, rawKey, err := activeSigningKey(signer, alg)
+	if err != nil {
+		return "", err
+	}
+
+	if kid, ok := key.KeyID(); ok {
+		err := headers.Set(jws.KeyIDKey, kid)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	signed, err := jwt.Sign(token, jwt.WithKey(alg, rawKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+type tokenSigningAlgorithmsContextKey struct{}
+
+// tokenSigningAlgorithms are the algorithms the tokens of a token response are signed with, an empty one means the algorithm of the default key
+// They're carried in the context, like transactions in tx.go, because fosite's token strategies pick the signing key with nothing but the context
+type tokenSigningAlgorithms struct {
+	idToken     string
+	accessToken string
+}
+
+func contextWithTokenSigningAlgorithms(ctx context.Context, algs tokenSigningAlgorithms) context.Context {
+	return context.WithValue(ctx, tokenSigningAlgorithmsContextKey{}, algs)
+}
+
+func tokenSigningAlgorithmsFromContext(ctx context.Context) tokenSigningAlgorithms {
+	algs, _ := ctx.Value(tokenSigningAlgorithmsContextKey{}).(tokenSigningAlgorithms)
+	return algs
+}
+
 type jwtSigner struct {
 	*fositejwt.DefaultSigner
+	signer TokenSigner
 }
 
-func newJWTSigner(keyGetter fositejwt.GetPrivateKeyFunc) *jwtSigner {
+func newJWTSigner(signer TokenSigner, keyGetter fositejwt.GetPrivateKeyFunc) *jwtSigner {
 	return &jwtSigner{
 		DefaultSigner: &fositejwt.DefaultSigner{GetPrivateKey: keyGetter},
+		signer:        signer,
 	}
 }
 
@@ -65,7 +288,13 @@ func (s *jwtSigner) Decode(ctx context.Context, token string) (*fositejwt.Token,
 		verificationKey = new(jsonWebKey.Public())
 	}
 
-	return fositejwt.Parse(token, func(*fositejwt.Token) (any, error) {
+	// Tokens may be signed with any of the active keys, which the key ID in the header identifies
+	return fositejwt.Parse(token, func(t *fositejwt.Token) (any, error) {
+		if keyID, ok := t.Header["kid"].(string); ok {
+			if key, ok := verificationKeyByID(s.signer, keyID); ok {
+				return key, nil
+			}
+		}
 		return verificationKey, nil
 	})
 }
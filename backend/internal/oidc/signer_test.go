@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKeyForAlgorithm(t *testing.T) {
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer := testTokenSigner{
+		key: signerKey,
+		additional: []jwk.Key{
+			testSigningKey(generateRSATestKey(t), jwa.RS256(), "rsa-key-id"),
+			testSigningKey(generateEd25519TestKey(t), jwa.EdDSA(), "eddsa-key-id"),
+		},
+	}
+
+	tests := []struct {
+		name      string
+		alg       string
+		wantKeyID string
+		wantErr   bool
+	}{
+		{name: "default key without an algorithm", alg: "", wantKeyID: "test-key-id"},
+		{name: "default key for its algorithm", alg: "ES256", wantKeyID: "test-key-id"},
+		{name: "additional key for its algorithm", alg: "EdDSA", wantKeyID: "eddsa-key-id"},
+		{name: "compatible key for another algorithm", alg: "PS384", wantKeyID: "rsa-key-id"},
+		{name: "no key for the algorithm", alg: "ES512", wantErr: true},
+		{name: "unknown algorithm", alg: "HS256", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := signingKeyForAlgorithm(signer, tt.alg)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKeyID, key.KeyID)
+		})
+	}
+}
+
+func TestVerificationKeyForToken(t *testing.T) {
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer := testTokenSigner{
+		key:        signerKey,
+		additional: []jwk.Key{testSigningKey(generateRSATestKey(t), jwa.RS256(), "rsa-key-id")},
+	}
+
+	token, err := jwt.NewBuilder().Issuer("https://issuer.example.com").IssuedAt(time.Now()).Build()
+	require.NoError(t, err)
+
+	for _, alg := range []jwa.SignatureAlgorithm{jwa.ES256(), jwa.RS256(), jwa.PS256()} {
+		t.Run(alg.String(), func(t *testing.T) {
+			signed, err := signJWT(signer, alg, token)
+			require.NoError(t, err)
+
+			verificationKey, err := verificationKeyForToken(signer, signed)
+			require.NoError(t, err)
+			_, err = jwt.ParseString(signed, verificationKey)
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		signed, err := signJWT(testTokenSigner{key: otherKey}, jwa.ES256(), token)
+		require.NoError(t, err)
+
+		// The other signer uses the same key ID, so its token is only rejected by the signature
+		verificationKey, err := verificationKeyForToken(signer, signed)
+		require.NoError(t, err)
+		_, err = jwt.ParseString(signed, verificationKey)
+		require.Error(t, err)
+
+		rotatedSigner := testTokenSigner{key: signerKey, additional: []jwk.Key{testSigningKey(generateRSATestKey(t), jwa.RS256(), "rotated-key-id")}}
+		signed, err = signJWT(signer, jwa.RS256(), token)
+		require.NoError(t, err)
+		_, err = verificationKeyForToken(rotatedSigner, signed)
+		require.ErrorContains(t, err, "unknown key 'rsa-key-id'")
+	})
+
+	t.Run("retired key", func(t *testing.T) {
+		retiredKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		retiredSigningKey := testSigningKey(retiredKey, jwa.ES256(), "retired-key-id")
+		headers := jws.NewHeaders()
+		require.NoError(t, headers.Set(jws.KeyIDKey, "retired-key-id"))
+		signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), retiredKey, jws.WithProtectedHeaders(headers)))
+		require.NoError(t, err)
+
+		// After a rollover the former key still verifies the tokens it signed, but no longer signs new ones
+		rolledOverSigner := testTokenSigner{key: signerKey, retired: []jwk.Key{retiredSigningKey}}
+		verificationKey, err := verificationKeyForToken(rolledOverSigner, string(signed))
+		require.NoError(t, err)
+		_, err = jwt.ParseString(string(signed), verificationKey)
+		require.NoError(t, err)
+
+		key, _, err := activeSigningKey(rolledOverSigner, jwa.ES256())
+		require.NoError(t, err)
+		kid, _ := key.KeyID()
+		assert.Equal(t, "test-key-id", kid)
+	})
+}
+
+func TestIDTokenSigningAlgValuesSupported(t *testing.T) {
+	signerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	signer := testTokenSigner{
+		key:        signerKey,
+		additional: []jwk.Key{testSigningKey(generateEd25519TestKey(t), jwa.EdDSA(), "eddsa-key-id")},
+	}
+
+	assert.Equal(t, []string{"ES384", "EdDSA"}, IDTokenSigningAlgValuesSupported(signer))
+}
@@ -358,18 +358,32 @@ func (s *Store) DeleteAccessTokenSession(ctx context.Context, signature string)
 }
 
 func (s *Store) CreateRefreshTokenSession(ctx context.Context, signature string, accessSignature string, request fosite.Requester) error {
-	return s.upsertSession(ctx, sessionKindRefreshToken, signature, request, accessSignature, true, fosite.RefreshToken)
+	// The access token of a grant for several resources is limited to one of them, while the refresh token is stored with the whole grant
+	return s.upsertSession(ctx, sessionKindRefreshToken, signature, withResourceGrants(request), accessSignature, true, fosite.RefreshToken)
 }
 
 func (s *Store) GetRefreshTokenSession(ctx context.Context, signature string, _ fosite.Session) (fosite.Requester, error) {
-	request, active, err := s.getRequesterSession(ctx, sessionKindRefreshToken, signature)
+	session, err := s.getSession(ctx, sessionKindRefreshToken, signature)
 	if err != nil {
 		return nil, err
 	}
-	if !active {
-		return request, fosite.ErrInactiveToken
+	request, err := s.decodeRequester(ctx, session.RequestData)
+	if err != nil {
+		return nil, err
 	}
-	return request, nil
+	if session.Active {
+		return request, nil
+	}
+
+	// A client refreshing concurrently presents a token another request has just rotated, which is accepted within the client's grace window
+	if withinRefreshTokenReuseGrace(request.GetClient(), session.RotatedAt, time.Now()) {
+		return request, nil
+	}
+	// Any later use of a rotated token means it was copied, so fosite revokes the whole family when it gets this error
+	if session.RotatedAt != nil {
+		reportRefreshTokenReuse(ctx, request)
+	}
+	return request, fosite.ErrInactiveToken
 }
 
 func (s *Store) DeleteRefreshTokenSession(ctx context.Context, signature string) error {
@@ -377,10 +391,31 @@ func (s *Store) DeleteRefreshTokenSession(ctx context.Context, signature string)
 }
 
 func (s *Store) RotateRefreshToken(ctx context.Context, requestID string, refreshTokenSignature string) error {
-	if err := s.deactivateSession(ctx, sessionKindRefreshToken, refreshTokenSignature); err != nil {
+	now := time.Now()
+	result := s.dbFor(ctx).
+		Model(&OAuth2Session{}).
+		Where("kind = ? AND key = ? AND active = ?", sessionKindRefreshToken, refreshTokenSignature, true).
+		Updates(map[string]any{"active": false, "rotated_at": new(datatype.DateTime(now))})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return s.RevokeAccessToken(ctx, requestID)
+	}
+
+	// The token was already rotated by a concurrent refresh within the grace window, whose access token must stay valid
+	session, err := s.getSession(ctx, sessionKindRefreshToken, refreshTokenSignature)
+	if err != nil {
 		return err
 	}
-	return s.RevokeAccessToken(ctx, requestID)
+	client, err := s.resolvePersistedClient(ctx, session.ClientID)
+	if err != nil {
+		return err
+	}
+	if !withinRefreshTokenReuseGrace(client, session.RotatedAt, now) {
+		return fosite.ErrNotFound
+	}
+	return nil
 }
 
 // Satisfies fositeoauth2.TokenRevocationStorage
@@ -418,25 +453,31 @@ func RevokeUserClientSessions(ctx context.Context, db *gorm.DB, userID, clientID
 	return s.revokeRequestIDs(ctx, requestIDs)
 }
 
-// findActiveRefreshTokenRequestIDsForUserClient returns request IDs for active refresh-token sessions belonging to the user and client, plus the subset matching the optional ID token JTI
-func (s *Store) findActiveRefreshTokenRequestIDsForUserClient(ctx context.Context, userID, clientID, idTokenJTI string) (candidates []string, jtiMatches []string, err error) {
-	var sessions []OAuth2Session
-	query := s.dbFor(ctx).
-		Select("request_id", "request_data").
-		Where("kind = ? AND active = ? AND client_id = ?", sessionKindRefreshToken, true, clientID)
+// RevokeUserClientScopeSessions revokes the user's refresh-token sessions with the client that were granted the scope, for the API identified by audience if it isn't empty
+// The client has to ask the user again to get a token with the scope
+func RevokeUserClientScopeSessions(ctx context.Context, db *gorm.DB, userID, clientID, audience, scope string) error {
+	s := NewStore(db, nil)
+	sessions, err := s.findActiveRefreshTokenSessionsForUserClient(ctx, userID, clientID)
+	if err != nil {
+		return err
+	}
 
-	// Filter by the user ID stored in the JSON request data
-	switch query.Name() {
-	case "sqlite":
-		query = query.Where("json_extract(CAST(request_data AS TEXT), '$.session.subject') = ?", userID)
-	case "postgres":
-		query = query.Where("request_data #>> '{session,subject}' = ?", userID)
-	default:
-		return nil, nil, fmt.Errorf("unsupported database dialect: %s", query.Name())
+	var requestIDs []string
+	for _, session := range sessions {
+		var stored storedRequester
+		if err := json.Unmarshal([]byte(session.RequestData), &stored); err != nil {
+			return err
+		}
+		if stored.GrantedScope.Has(scope) && (audience == "" || stored.GrantedAudience.Has(audience)) {
+			requestIDs = append(requestIDs, session.RequestID)
+		}
 	}
-	err = query.
-		Find(&sessions).
-		Error
+	return s.revokeRequestIDs(ctx, requestIDs)
+}
+
+// findActiveRefreshTokenRequestIDsForUserClient returns request IDs for active refresh-token sessions belonging to the user and client, plus the subset matching the optional ID token JTI
+func (s *Store) findActiveRefreshTokenRequestIDsForUserClient(ctx context.Context, userID, clientID, idTokenJTI string) (candidates []string, jtiMatches []string, err error) {
+	sessions, err := s.findActiveRefreshTokenSessionsForUserClient(ctx, userID, clientID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -462,6 +503,67 @@ func (s *Store) findActiveRefreshTokenRequestIDsForUserClient(ctx context.Contex
 	return mapKeys(candidateRequestIDs), mapKeys(matchingRequestIDs), nil
 }
 
+// findActiveRefreshTokenSessionsForUserClient returns the active refresh-token sessions belonging to the user and client
+func (s *Store) findActiveRefreshTokenSessionsForUserClient(ctx context.Context, userID, clientID string) ([]OAuth2Session, error) {
+	var sessions []OAuth2Session
+	query, err := whereSessionSubject(s.dbFor(ctx).
+		Select("request_id", "request_data").
+		Where("kind = ? AND active = ? AND client_id = ?", sessionKindRefreshToken, true, clientID), userID)
+	if err != nil {
+		return nil, err
+	}
+	err = query.
+		Find(&sessions).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// findSessionIDsForUserClient returns the IDs of the browser sessions the user's unexpired access and refresh tokens with the client were issued in
+// Revoked refresh tokens count as well, so the sessions a revocation has just ended are still known
+func (s *Store) findSessionIDsForUserClient(ctx context.Context, userID, clientID string) ([]string, error) {
+	var sessions []OAuth2Session
+	query, err := whereSessionSubject(s.dbFor(ctx).
+		Select("request_data").
+		Where("kind IN ? AND client_id = ?", []string{sessionKindAccessToken, sessionKindRefreshToken}, clientID).
+		Where("expires_at IS NULL OR expires_at > ?", datatype.DateTime(time.Now())), userID)
+	if err != nil {
+		return nil, err
+	}
+	err = query.
+		Find(&sessions).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionIDs []string
+	for _, session := range sessions {
+		var stored storedRequester
+		if err := json.Unmarshal([]byte(session.RequestData), &stored); err != nil {
+			return nil, err
+		}
+		if stored.Session != nil && stored.Session.SessionID != "" && !slices.Contains(sessionIDs, stored.Session.SessionID) {
+			sessionIDs = append(sessionIDs, stored.Session.SessionID)
+		}
+	}
+	return sessionIDs, nil
+}
+
+// whereSessionSubject filters the query by the user ID stored in the JSON request data
+func whereSessionSubject(query *gorm.DB, userID string) (*gorm.DB, error) {
+	switch query.Name() {
+	case "sqlite":
+		return query.Where("json_extract(CAST(request_data AS TEXT), '$.session.subject') = ?", userID), nil
+	case "postgres":
+		return query.Where("request_data #>> '{session,subject}' = ?", userID), nil
+	default:
+		return nil, fmt.Errorf("unsupported database dialect: %s", query.Name())
+	}
+}
+
 func (s *Store) revokeRequestIDs(ctx context.Context, requestIDs []string) error {
 	if len(requestIDs) == 0 {
 		return nil
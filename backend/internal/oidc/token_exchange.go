@@ -0,0 +1,283 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// Grant and token types of the OAuth 2.0 token exchange, as defined by RFC 8693
+const (
+	grantTypeTokenExchange fosite.GrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken                    = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchangeHandler implements the token exchange grant of RFC 8693
+// A confidential client presents an access token Pocket ID issued on behalf of a user, and receives a token for another API that keeps acting on behalf of that user
+// The client may only exchange into the APIs it was granted token exchange access to, and only for the permissions granted for the exchange that the user agreed to as well, see agreedScopes
+type tokenExchangeHandler struct {
+	// provider validates the subject token, it is set once the provider is composed
+	provider     fosite.OAuth2Provider
+	accessTokens fositeoauth2.AccessTokenStrategy
+	store        *Store
+	config       *fosite.Config
+}
+
+func newTokenExchangeHandler(accessTokens fositeoauth2.AccessTokenStrategy, store *Store, config *fosite.Config) *tokenExchangeHandler {
+	return &tokenExchangeHandler{
+		accessTokens: accessTokens,
+		store:        store,
+		config:       config,
+	}
+}
+
+func (h *tokenExchangeHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := requester.GetClient()
+	if client.IsPublic() || !client.GetGrantTypes().Has(string(grantTypeTokenExchange)) {
+		return fosite.ErrUnauthorizedClient.WithHint("The OAuth 2.0 Client is not allowed to use the token exchange grant.")
+	}
+
+	form := requester.GetRequestForm()
+	// The authenticated client is always the actor, so a separate actor token would have nothing to add
+	if form.Get("actor_token") != "" {
+		return fosite.ErrInvalidRequest.WithHint("Actor tokens are not supported, the authenticated client acts on behalf of the subject.")
+	}
+	if tokenType := form.Get("requested_token_type"); tokenType != "" && tokenType != tokenTypeAccessToken {
+		return fosite.ErrInvalidRequest.WithHintf("The requested token type '%s' is not supported.", tokenType)
+	}
+	if form.Get("subject_token") == "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'subject_token' parameter is missing.")
+	}
+	if tokenType := form.Get("subject_token_type"); tokenType != tokenTypeAccessToken {
+		return fosite.ErrInvalidRequest.WithHintf("The subject token type '%s' is not supported.", tokenType)
+	}
+
+	subjectRequester, err := h.subjectRequester(ctx, client.GetID(), form.Get("subject_token"))
+	if err != nil {
+		return err
+	}
+	subjectSession, ok := subjectRequester.GetSession().(*Session)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The session must be *oidc.Session.")
+	}
+
+	target, err := tokenExchangeTarget(requester)
+	if err != nil {
+		return err
+	}
+	audience, grantedScopes, err := resolveResource(ctx, nil, h.store.apiAccess, client.GetID(), target, requester.GetRequestedScopes(), SubjectTypeTokenExchange)
+	if err != nil {
+		return err
+	}
+	// The exchanged token is only meant for the API, so it must never reach Pocket ID's identity endpoints
+	grantedScopes = slices.DeleteFunc(grantedScopes, isStandardScope)
+	grantedScopes, err = h.agreedScopes(ctx, audience, grantedScopes, requester.GetRequestedScopes(), subjectRequester)
+	if err != nil {
+		return err
+	}
+	grantResourceIndicator(requester, audience, grantedScopes)
+
+	session, ok := requester.GetSession().(*Session)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The session must be *oidc.Session.")
+	}
+	session.Subject = subjectSession.Subject
+	session.AuthenticationMethod = subjectSession.AuthenticationMethod
+	session.IDTokenClaims().Subject = subjectSession.Subject
+	session.IDTokenClaims().AuthTime = subjectSession.IDTokenClaims().AuthTime
+	// The client becomes the current actor, and whoever acted in the subject token before is kept as the previous link of the delegation chain
+	session.Actor = &TokenActor{
+		Subject: clientCredentialsSubjectPrefix + client.GetID(),
+		Actor:   subjectSession.Actor,
+	}
+
+	// The exchanged token never outlives the token it was exchanged for
+	expiresAt := time.Now().UTC().Add(fosite.GetEffectiveLifespan(client, grantTypeTokenExchange, fosite.AccessToken, h.config.GetAccessTokenLifespan(ctx)))
+	if subjectExpiresAt := subjectSession.GetExpiresAt(fosite.AccessToken); !subjectExpiresAt.IsZero() && subjectExpiresAt.Before(expiresAt) {
+		expiresAt = subjectExpiresAt
+	}
+	session.SetExpiresAt(fosite.AccessToken, expiresAt)
+
+	return nil
+}
+
+func (h *tokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	err := issueAccessToken(ctx, h.accessTokens, h.store, requester, responder)
+	if err != nil {
+		return err
+	}
+	responder.SetExtra("issued_token_type", tokenTypeAccessToken)
+	return nil
+}
+
+func (h *tokenExchangeHandler) CanSkipClientAuthentication(context.Context, fosite.AccessRequester) bool {
+	return false
+}
+
+func (h *tokenExchangeHandler) CanHandleTokenEndpointRequest(_ context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(string(grantTypeTokenExchange))
+}
+
+// issueAccessToken generates and stores the access token of a grant that Pocket ID handles itself, and adds it to the token response
+func issueAccessToken(ctx context.Context, accessTokens fositeoauth2.AccessTokenStrategy, store *Store, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	token, signature, err := accessTokens.GenerateAccessToken(ctx, requester)
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	err = store.CreateAccessTokenSession(ctx, signature, requester.Sanitize([]string{}))
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	responder.SetAccessToken(token)
+	responder.SetTokenType(fosite.BearerAccessToken)
+	responder.SetExpiresIn(time.Until(requester.GetSession().GetExpiresAt(fosite.AccessToken)).Round(time.Second))
+	responder.SetScopes(requester.GetGrantedScopes())
+	return nil
+}
+
+// subjectRequester validates the subject token and returns the request it was issued for
+// Only active access tokens issued on behalf of a user and audienced to the exchanging client, or to an API the client may exchange tokens of, can be exchanged
+// Sender-constrained tokens are rejected, because the exchange would otherwise turn them into bearer tokens without their holder ever proving possession of the key
+func (h *tokenExchangeHandler) subjectRequester(ctx context.Context, clientID, token string) (fosite.AccessRequester, error) {
+	tokenUse, subjectRequester, err := h.provider.IntrospectToken(ctx, token, fosite.AccessToken, NewEmptySession())
+	if err != nil || tokenUse != fosite.AccessToken {
+		return nil, fosite.ErrInvalidGrant.WithHint("The subject token is invalid, expired or revoked.")
+	}
+
+	subjectSession, ok := subjectRequester.GetSession().(*Session)
+	if !ok {
+		return nil, fosite.ErrServerError.WithDebug("The session must be *oidc.Session.")
+	}
+	if subjectSession.Subject == "" || strings.HasPrefix(subjectSession.Subject, clientCredentialsSubjectPrefix) {
+		return nil, fosite.ErrInvalidGrant.WithHint("Only tokens issued on behalf of a user can be exchanged.")
+	}
+	if !subjectSession.Confirmation.isEmpty() {
+		return nil, fosite.ErrInvalidGrant.WithHint("Sender-constrained tokens can't be exchanged.")
+	}
+
+	audienced, err := h.subjectTokenAudienced(ctx, clientID, subjectRequester.GetGrantedAudience())
+	if err != nil {
+		return nil, err
+	}
+	if !audienced {
+		return nil, fosite.ErrInvalidGrant.WithHint("The subject token was not issued for the OAuth 2.0 Client or for an API it may exchange tokens of.")
+	}
+
+	return subjectRequester, nil
+}
+
+// subjectTokenAudienced reports whether one of the audiences of a subject token is the exchanging client itself, or an API the client was granted token exchange access to
+func (h *tokenExchangeHandler) subjectTokenAudienced(ctx context.Context, clientID string, audiences []string) (bool, error) {
+	if slices.Contains(audiences, clientID) {
+		return true, nil
+	}
+	if h.store.apiAccess == nil {
+		return false, nil
+	}
+
+	for _, audience := range audiences {
+		_, _, hasAccess, err := h.store.apiAccess.AllowedScopesForAudience(ctx, nil, clientID, audience, SubjectTypeTokenExchange)
+		if err != nil {
+			return false, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		if hasAccess {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// agreedScopes limits the scopes of an exchanged token to those the user agreed to, so an exchange never adds permissions on the user's behalf
+// Exchanging into the API the subject token was issued for keeps to the scopes of the subject token, while exchanging into another API keeps to the permissions of that API the user consented to when authorizing the client the subject token was issued to
+// Explicitly requested scopes the user didn't agree to are an error, while the default of every exchange permission is silently narrowed, and nothing left to grant is an error as well
+func (h *tokenExchangeHandler) agreedScopes(ctx context.Context, audience string, grantedScopes, requestedScopes []string, subjectRequester fosite.AccessRequester) ([]string, error) {
+	agreed := subjectRequester.GetGrantedScopes()
+	if !subjectRequester.GetGrantedAudience().Has(audience) {
+		consented, err := h.consentedScopes(ctx, subjectRequester.GetSession().GetSubject(), subjectRequester.GetClient().GetID(), audience)
+		if err != nil {
+			return nil, err
+		}
+		agreed = consented
+	}
+
+	scopes := make([]string, 0, len(grantedScopes))
+	for _, scope := range grantedScopes {
+		if agreed.Has(scope) {
+			scopes = append(scopes, scope)
+			continue
+		}
+		if slices.Contains(requestedScopes, scope) {
+			return nil, fosite.ErrInvalidScope.WithHintf("The user didn't agree to the scope '%s' for the subject token.", scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fosite.ErrInvalidScope.WithHint("The user didn't agree to any of the scopes the OAuth 2.0 Client may exchange the subject token for.")
+	}
+	return scopes, nil
+}
+
+// consentedScopes returns the permissions of the API the user currently consents to for the client
+func (h *tokenExchangeHandler) consentedScopes(ctx context.Context, userID, clientID, audience string) ([]string, error) {
+	var authorizedClient model.UserAuthorizedOidcClient
+	err := h.store.dbFor(ctx).
+		Preload("Client").
+		First(&authorizedClient, "client_id = ? AND user_id = ?", clientID, userID).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	now := time.Now()
+	scopes := make([]string, 0, len(authorizedClient.ScopeConsents))
+	for _, consent := range authorizedClient.ScopeConsents {
+		keyType, consentAudience, scope := parseConsentKey(consent.Scope)
+		if keyType != consentKeyTypePermission || consentAudience != audience || !consent.Granted || consent.IsExpiredAt(authorizedClient.Client.ConsentLifetimeMinutes, now) {
+			continue
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// tokenExchangeTarget returns the API the token is requested for, which RFC 8693 lets the client name by its resource, by its audience, or both
+func tokenExchangeTarget(requester fosite.AccessRequester) (string, error) {
+	resource, err := requester.GetResource()
+	if err != nil {
+		return "", err
+	}
+
+	audiences := requester.GetRequestedAudience()
+	if len(audiences) > 1 || (resource != "" && len(audiences) == 1 && strings.TrimRight(audiences[0], "/") != strings.TrimRight(resource, "/")) {
+		return "", fosite.ErrInvalidTarget.WithHint("A token exchange request may only target one API.")
+	}
+	if resource == "" && len(audiences) == 1 {
+		resource = audiences[0]
+	}
+	if resource == "" {
+		return "", fosite.ErrInvalidTarget.WithHint("The 'resource' or 'audience' parameter is missing.")
+	}
+
+	return resource, nil
+}
+
+var _ fosite.TokenEndpointHandler = (*tokenExchangeHandler)(nil)
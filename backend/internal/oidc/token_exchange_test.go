@@ -0,0 +1,350 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestTokenHandlerTokenExchange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL       = "https://issuer.example.com"
+		clientID      = "exchange-client"
+		clientPlain   = "exchange-secret-value"
+		ordersAPI     = "https://api.orders.example.com"
+		inventoryAPI  = "https://api.inventory.example.com"
+		billingAPI    = "https://api.billing.example.com"
+		subjectUserID = "user-1"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: subjectUserID}, Username: "user-1"}).Error)
+	require.NoError(t, db.Create(&model.User{Base: model.Base{ID: "user-2"}, Username: "user-2"}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:        model.Base{ID: clientID},
+		Name:        "Exchange Client",
+		Credentials: testClientCredentials(clientPlain),
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:     model.Base{ID: "public-client"},
+		Name:     "Public Client",
+		IsPublic: true,
+	}).Error)
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base: model.Base{ID: "frontend-client"},
+		Name: "Frontend",
+	}).Error)
+	// The user let the frontend read, but not change, the inventory on their behalf
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{
+		UserID:   subjectUserID,
+		ClientID: "frontend-client",
+		ScopeConsents: model.ScopeConsents{
+			{Scope: consentScopeKey(inventoryAPI, "read:inventory"), Granted: true, ConsentedAt: datatype.DateTime(time.Now())},
+			{Scope: consentScopeKey(inventoryAPI, "write:inventory"), Granted: false, ConsentedAt: datatype.DateTime(time.Now())},
+			{Scope: consentAudienceKey(inventoryAPI), Granted: true, ConsentedAt: datatype.DateTime(time.Now())},
+		},
+	}).Error)
+
+	apiAccess := fakeAPIAccess{allowed: map[string]map[SubjectType][]string{
+		ordersAPI: {
+			SubjectTypeUser:          {"admin:orders"},
+			SubjectTypeTokenExchange: {"read:orders", "write:orders"},
+		},
+		inventoryAPI: {
+			SubjectTypeTokenExchange: {"read:inventory", "write:inventory"},
+		},
+		billingAPI: {
+			SubjectTypeUser: {"read:billing"},
+		},
+	}}
+
+	provider, err := newProvider(NewStore(db, apiAccess), nil, testTokenSigner{key: key}, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte("test-secret"),
+	}, nil)
+	require.NoError(t, err)
+	auditLogger := &fakeAuditLogger{}
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, auditLogger, db)
+
+	// issueToken issues a user's access token that the frontend client obtained for the orders API, which configure may change
+	issueToken := func(t *testing.T, configure func(request *fosite.AccessRequest, session *Session)) string {
+		t.Helper()
+		session := NewEmptySession()
+		session.Subject = subjectUserID
+		session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(time.Hour))
+
+		request := fosite.NewAccessRequest(session)
+		request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: "frontend-client"}, Name: "Frontend"}}
+		request.GrantTypes = fosite.Arguments{string(fosite.GrantTypeClientCredentials)}
+		request.GrantedAudience = fosite.Arguments{ordersAPI}
+		request.GrantedScope = fosite.Arguments{"read:orders", "write:orders"}
+		if configure != nil {
+			configure(request, session)
+		}
+
+		response, err := provider.NewAccessResponse(t.Context(), request)
+		require.NoError(t, err)
+		return response.GetAccessToken()
+	}
+
+	issueSubjectToken := func(t *testing.T, subject string, actor *TokenActor, expiresIn time.Duration) string {
+		t.Helper()
+		return issueToken(t, func(_ *fosite.AccessRequest, session *Session) {
+			session.Subject = subject
+			session.Actor = actor
+			session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(expiresIn))
+		})
+	}
+
+	exchange := func(t *testing.T, clientID string, form url.Values) map[string]any {
+		t.Helper()
+		form.Set("grant_type", string(grantTypeTokenExchange))
+		if !form.Has("subject_token_type") {
+			form.Set("subject_token_type", tokenTypeAccessToken)
+		}
+		// Public clients only identify themselves, confidential clients authenticate with their secret
+		public := clientID == "public-client"
+		if public {
+			form.Set("client_id", clientID)
+		}
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, tokenEndpointPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if !public {
+			req.SetBasicAuth(clientID, clientPlain)
+		}
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		handler.token(c)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	t.Run("exchanges the token for a down-scoped token of another API", func(t *testing.T) {
+		auditLogger.events = nil
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+			"resource":      {inventoryAPI},
+			"scope":         {"openid read:inventory"},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		assert.Equal(t, tokenTypeAccessToken, body["issued_token_type"])
+		assert.True(t, strings.EqualFold(fosite.BearerAccessToken, body["token_type"].(string)))
+		assert.Empty(t, body["refresh_token"])
+
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		assert.Equal(t, subjectUserID, claims["sub"])
+		assert.Equal(t, []string{inventoryAPI}, jwtAudience(claims))
+		assert.Equal(t, []string{"read:inventory"}, jwtScopes(claims), "identity scopes must be dropped from exchanged tokens")
+		assert.Equal(t, map[string]any{"sub": clientCredentialsSubjectPrefix + clientID}, claims["act"])
+
+		require.Equal(t, []model.AuditLogEvent{model.AuditLogEventTokenExchanged}, auditLogger.events)
+		assert.Equal(t, inventoryAPI, auditLogger.data[0]["resource"])
+		assert.Equal(t, "read:inventory", auditLogger.data[0]["scope"])
+		assert.Equal(t, "Exchange Client", auditLogger.data[0]["clientName"])
+	})
+
+	t.Run("grants the exchange permissions the user consented to when no scope is requested", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+			"audience":      {inventoryAPI},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		assert.Equal(t, []string{"read:inventory"}, jwtScopes(claims))
+	})
+
+	t.Run("permission of another API the user did not consent to", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+			"resource":      {inventoryAPI},
+			"scope":         {"write:inventory"},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "invalid_scope", body["error"])
+	})
+
+	t.Run("user who did not consent to any permission of another API", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, "user-2", nil, time.Hour)},
+			"resource":      {inventoryAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "invalid_scope", body["error"])
+	})
+
+	t.Run("exchanges a token audienced to the exchanging client", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueToken(t, func(request *fosite.AccessRequest, _ *Session) {
+				request.GrantedAudience = fosite.Arguments{clientID}
+			})},
+			"resource": {inventoryAPI},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		assert.Equal(t, []string{"read:inventory"}, jwtScopes(claims))
+	})
+
+	t.Run("token of a foreign client", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueToken(t, func(request *fosite.AccessRequest, _ *Session) {
+				request.GrantedAudience = fosite.Arguments{"frontend-client"}
+			})},
+			"resource": {ordersAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("token of a foreign API", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueToken(t, func(request *fosite.AccessRequest, _ *Session) {
+				request.GrantedAudience = fosite.Arguments{billingAPI}
+			})},
+			"resource": {ordersAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("permission of the subject token's API the subject token was not granted", func(t *testing.T) {
+		readOnlyToken := issueToken(t, func(request *fosite.AccessRequest, _ *Session) {
+			request.GrantedScope = fosite.Arguments{"read:orders"}
+		})
+
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {readOnlyToken},
+			"resource":      {ordersAPI},
+			"scope":         {"write:orders"},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "invalid_scope", body["error"])
+
+		body = exchange(t, clientID, url.Values{
+			"subject_token": {readOnlyToken},
+			"resource":      {ordersAPI},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		assert.Equal(t, []string{"read:orders"}, jwtScopes(claims))
+	})
+
+	t.Run("sender-constrained token", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueToken(t, func(_ *fosite.AccessRequest, session *Session) {
+				session.setDPoPKeyThumbprint("dpop-key-thumbprint")
+			})},
+			"resource": {ordersAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("keeps the previous actors of the delegation chain", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, &TokenActor{Subject: "client-upstream"}, time.Hour)},
+			"resource":      {ordersAPI},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		assert.Equal(t, map[string]any{
+			"sub": clientCredentialsSubjectPrefix + clientID,
+			"act": map[string]any{"sub": "client-upstream"},
+		}, claims["act"])
+	})
+
+	t.Run("exchanged token does not outlive the subject token", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, 5*time.Minute)},
+			"resource":      {ordersAPI},
+		})
+		require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+		assert.LessOrEqual(t, body["expires_in"].(float64), (5 * time.Minute).Seconds())
+	})
+
+	t.Run("permission that is not granted for the exchange", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+			"resource":      {ordersAPI},
+			"scope":         {"admin:orders"},
+		})
+		assert.Equal(t, "invalid_scope", body["error"])
+	})
+
+	t.Run("API the client may not exchange into", func(t *testing.T) {
+		auditLogger.events = nil
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+			"resource":      {billingAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "access_denied", body["error"])
+		assert.Empty(t, auditLogger.events)
+	})
+
+	t.Run("request without a target", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+		})
+		assert.Equal(t, "invalid_target", body["error"])
+	})
+
+	t.Run("invalid subject token", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {"not-a-token"},
+			"resource":      {ordersAPI},
+		})
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("client credentials token as the subject token", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token": {issueSubjectToken(t, clientCredentialsSubjectPrefix+"frontend-client", nil, time.Hour)},
+			"resource":      {ordersAPI},
+		})
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("unsupported subject token type", func(t *testing.T) {
+		body := exchange(t, clientID, url.Values{
+			"subject_token":      {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+			"subject_token_type": {"urn:ietf:params:oauth:token-type:id_token"},
+			"resource":           {ordersAPI},
+		})
+		assert.Equal(t, "invalid_request", body["error"])
+	})
+
+	t.Run("public clients can't exchange tokens", func(t *testing.T) {
+		body := exchange(t, "public-client", url.Values{
+			"subject_token": {issueSubjectToken(t, subjectUserID, nil, time.Hour)},
+			"resource":      {ordersAPI},
+		})
+		assert.Empty(t, body["access_token"])
+		assert.Equal(t, "unauthorized_client", body["error"])
+	})
+}
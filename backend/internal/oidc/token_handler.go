@@ -4,22 +4,38 @@ import (
 	"context"
 	"log/slog"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ory/fosite"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
 )
 
+// clientCredentialsSubjectPrefix prefixes the subject of tokens issued with the client credentials grant, so they can't be confused with a user ID
+const clientCredentialsSubjectPrefix = "client-"
+
 type tokenHandler struct {
 	provider      fosite.OAuth2Provider
 	claimsService *ClaimsService
 	apiAccess     APIAccessProvider
+	dpop          *dpopVerifier
+	mtls          *tlsClientAuthenticator
+	auditLog      AuditLogger
+	db            *gorm.DB
 }
 
-func newTokenHandler(provider fosite.OAuth2Provider, claimsService *ClaimsService, apiAccess APIAccessProvider) *tokenHandler {
+func newTokenHandler(provider fosite.OAuth2Provider, claimsService *ClaimsService, apiAccess APIAccessProvider, dpop *dpopVerifier, mtls *tlsClientAuthenticator, auditLog AuditLogger, db *gorm.DB) *tokenHandler {
 	return &tokenHandler{
 		provider:      provider,
 		claimsService: claimsService,
 		apiAccess:     apiAccess,
+		dpop:          dpop,
+		mtls:          mtls,
+		auditLog:      auditLog,
+		db:            db,
 	}
 }
 
@@ -30,8 +46,24 @@ func (h *tokenHandler) token(c *gin.Context) {
 	// fosite restores the stored session over this empty one.
 	session := NewEmptySession()
 
+	// The DPoP proof is checked before the grant, so a client that has to retry with a nonce doesn't spend its authorization code or refresh token
+	if c.GetHeader(dpopHeader) != "" {
+		h.dpop.setNonceHeader(c)
+	}
+	dpopJKT, err := h.dpop.verifyProof(ctx, c.Request, tokenEndpointPath, "")
+	if err != nil {
+		slog.WarnContext(ctx, "Rejected token request: invalid DPoP proof", "error", err)
+		h.provider.WriteAccessError(ctx, c.Writer, nil, err)
+		return
+	}
+
+	// fosite revokes a refresh token family when a rotated token is presented again, which the store reports here to record it in the audit log
+	ctx, refreshTokenReuse := contextWithRefreshTokenReuse(ctx)
 	accessRequest, err := h.provider.NewAccessRequest(ctx, c.Request, session)
 	if err != nil {
+		if refreshTokenReuse.requester != nil {
+			h.createRefreshTokenReuseAuditLog(ctx, c, refreshTokenReuse.requester)
+		}
 		slog.ErrorContext(ctx, "Failed to create access request", "error", err)
 		h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
 		return
@@ -53,6 +85,27 @@ func (h *tokenHandler) token(c *gin.Context) {
 			return
 		}
 
+		err = validateOfflineAccessGranted(client, accessRequest)
+		if err != nil {
+			slog.WarnContext(ctx, "Rejected refresh token request: offline_access was not granted", "error", err.Error())
+			h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+			return
+		}
+
+		err = applyRefreshTokenFamilyLifetime(client.OidcClient, requestSession, time.Now().UTC())
+		if err != nil {
+			slog.WarnContext(ctx, "Rejected token request: refresh token family exceeded its maximum lifetime", "error", err.Error())
+			h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+			return
+		}
+
+		// A grant for several resources issues each access token for the one the client names, which the refresh check below then validates
+		err = selectResourceGrant(accessRequest, requestSession)
+		if err != nil {
+			h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+			return
+		}
+
 		err = h.validateRefreshAPIGrant(ctx, client, accessRequest)
 		if err != nil {
 			slog.WarnContext(ctx, "Rejected refresh token request: API grant is no longer allowed for the user subject", "error", err.Error())
@@ -60,6 +113,20 @@ func (h *tokenHandler) token(c *gin.Context) {
 			return
 		}
 
+		err = bindDPoPKey(client, accessRequest, requestSession, dpopJKT)
+		if err != nil {
+			if isDPoPError(err) {
+				h.dpop.setNonceHeader(c)
+			}
+			slog.WarnContext(ctx, "Rejected token request: DPoP policy not met", "error", err.Error())
+			h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+			return
+		}
+
+		// Tokens of a client that authenticated with its certificate are bound to it, see RFC 8705 section 3
+		// The client authenticates again on every refresh, so the binding follows the certificate it currently uses
+		requestSession.setCertificateThumbprint(h.mtls.certificateThumbprint(c.Request, client))
+
 		// The client credentials grant has no authorize step so the RFC 8707 resource is resolved here to stamp the API audience and limit the granted scope to what the client is allowed for that API
 		// It resolves against the client-subject grants: a permission delegated by users does not let the client act as itself
 		// The other grants had their audience and scope resolved at authorize or device time and restored from storage, so they must be left untouched
@@ -83,35 +150,107 @@ func (h *tokenHandler) token(c *gin.Context) {
 				accessReq.GrantedAudience = nil
 			}
 			grantResourceIndicator(accessRequest, audience, grantedScopes)
+
+			// The client acts as itself, so the authorization details it requests here are checked against its client grants
+			requestSession.AuthorizationDetails, err = requestedAuthorizationDetails(ctx, nil, h.apiAccess, client.GetID(), resource, SubjectTypeClient, accessRequest.GetRequestForm().Get("authorization_details"))
+			if err != nil {
+				h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+				return
+			}
+		} else {
+			// The other grants restored the authorization details the user granted, which the client may narrow down for this access token only
+			requestSession.NarrowedAuthorizationDetails, err = narrowAuthorizationDetails(requestSession.AuthorizationDetails, accessRequest.GetRequestForm().Get("authorization_details"))
+			if err != nil {
+				h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+				return
+			}
 		}
 	}
 
-	err = h.claimsService.applyIDTokenClaims(ctx, requestSession, accessRequest.GetGrantedScopes())
+	client, ok := accessRequest.GetClient().(Client)
+	err = h.claimsService.applyIDTokenClaims(ctx, requestSession, client.OidcClient, accessRequest.GetGrantedScopes())
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to apply ID token claims", "error", err)
 		h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
 		return
 	}
 
-	// The client credentials grant has no resource owner, so no subject is ever set. Assign a
+	// The client credentials grant, and the JWT bearer grant when mapped to the client itself, have no resource owner, so no subject is ever set. Assign a
 	// stable synthetic subject so the issued JWT access token still carries a subclaim.
 	if requestSession.Subject == "" {
-		client, ok := accessRequest.GetClient().(Client)
-		if ok && accessRequest.GetGrantTypes().Has(string(fosite.GrantTypeClientCredentials)) {
-			requestSession.Subject = "client-" + client.GetID()
+		if ok && (accessRequest.GetGrantTypes().Has(string(fosite.GrantTypeClientCredentials)) || accessRequest.GetGrantTypes().ExactOne(string(fosite.GrantTypeJWTBearer))) {
+			requestSession.Subject = clientCredentialsSubjectPrefix + client.GetID()
 		}
 	}
 
+	if ok && client.RefreshTokenRequiresOfflineAccess {
+		ctx = contextWithOfflineAccessRequired(ctx)
+	}
+	// The ID token is signed with the algorithm the client registered, and the access token with the one of the API it's audienced to
+	accessTokenAlg, err := accessTokenSigningAlgorithm(ctx, nil, h.apiAccess, accessRequest.GetClient().GetID(), accessRequest.GetGrantedAudience())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get access token signing algorithm", "error", err)
+		h.provider.WriteAccessError(ctx, c.Writer, accessRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	ctx = contextWithTokenSigningAlgorithms(ctx, tokenSigningAlgorithms{
+		idToken:     client.IDTokenSignedResponseAlg,
+		accessToken: accessTokenAlg,
+	})
 	response, err := h.provider.NewAccessResponse(ctx, accessRequest)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to create access response", "error", err)
 		h.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
 		return
 	}
+	if requestSession.dpopKeyThumbprint() != "" {
+		response.SetTokenType(dpopTokenType)
+	}
+	// The client learns which authorization details the token carries, see RFC 9396 section 7
+	if details := requestSession.accessTokenAuthorizationDetails(); len(details) > 0 {
+		response.SetExtra("authorization_details", details)
+	}
+
+	// Every exchange lets a client act for a user at another API, so it is recorded in the user's audit log
+	if accessRequest.GetGrantTypes().ExactOne(string(grantTypeTokenExchange)) {
+		h.createTokenExchangeAuditLog(ctx, c, accessRequest)
+	}
 
 	h.provider.WriteAccessResponse(ctx, c.Writer, accessRequest, response)
 }
 
+func (h *tokenHandler) createTokenExchangeAuditLog(ctx context.Context, c *gin.Context, accessRequest fosite.AccessRequester) {
+	if h.auditLog == nil {
+		return
+	}
+
+	data := model.AuditLogData{
+		"resource": strings.Join(accessRequest.GetGrantedAudience(), " "),
+		"scope":    strings.Join(accessRequest.GetGrantedScopes(), " "),
+	}
+	if client, ok := accessRequest.GetClient().(Client); ok {
+		data["clientName"] = client.Name
+	}
+
+	meta := requestMetaFromGin(c)
+	h.auditLog.Create(ctx, model.AuditLogEventTokenExchanged, meta.IPAddress, meta.UserAgent, accessRequest.GetSession().GetSubject(), data, h.db.WithContext(ctx))
+}
+
+func (h *tokenHandler) createRefreshTokenReuseAuditLog(ctx context.Context, c *gin.Context, requester fosite.Requester) {
+	slog.WarnContext(ctx, "Detected reuse of a rotated refresh token, revoking its family", "request_id", requester.GetID())
+	if h.auditLog == nil {
+		return
+	}
+
+	data := model.AuditLogData{}
+	if client, ok := requester.GetClient().(Client); ok {
+		data["clientName"] = client.Name
+	}
+
+	meta := requestMetaFromGin(c)
+	h.auditLog.Create(ctx, model.AuditLogEventRefreshTokenReused, meta.IPAddress, meta.UserAgent, requester.GetSession().GetSubject(), data, h.db.WithContext(ctx))
+}
+
 func (h *tokenHandler) validateRefreshAPIGrant(ctx context.Context, client Client, accessRequest fosite.AccessRequester) error {
 	if !accessRequest.GetGrantTypes().Has(string(fosite.GrantTypeRefreshToken)) {
 		return nil
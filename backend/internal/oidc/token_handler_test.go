@@ -63,7 +63,7 @@ func TestTokenHandlerClientCredentialsGrant(t *testing.T) {
 		Secret:       []byte(secret),
 	}, nil)
 	require.NoError(t, err)
-	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 	form := url.Values{"grant_type": {"client_credentials"}}
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/token", strings.NewReader(form.Encode()))
@@ -122,7 +122,7 @@ func TestTokenHandlerClientCredentialsDropsIdentityScopes(t *testing.T) {
 		Secret:       []byte(secret),
 	}, nil)
 	require.NoError(t, err)
-	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"openid"}}
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/token", strings.NewReader(form.Encode()))
@@ -182,7 +182,7 @@ func TestTokenHandlerClientCredentialsUsesClientSubjectGrants(t *testing.T) {
 		Secret:       []byte(secret),
 	}, nil)
 	require.NoError(t, err)
-	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 	requestToken := func(t *testing.T, scope string) map[string]any {
 		t.Helper()
@@ -218,6 +218,76 @@ func TestTokenHandlerClientCredentialsUsesClientSubjectGrants(t *testing.T) {
 	require.Equal(t, "invalid_scope", body["error"])
 }
 
+func TestTokenHandlerClientCredentialsGrantsAuthorizationDetails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		baseURL     = "https://issuer.example.com"
+		secret      = "test-secret"
+		clientID    = "cc-client"
+		clientPlain = "cc-secret-value"
+		apiAudience = "https://api.payments.example.com"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&model.OidcClient{
+		Base:        model.Base{ID: clientID},
+		Name:        "Client Credentials Client",
+		Credentials: testClientCredentials(clientPlain),
+		IsPublic:    false,
+	}).Error)
+
+	apiAccess := fakeAPIAccess{
+		allowed:     map[string]map[SubjectType][]string{apiAudience: {SubjectTypeClient: {}}},
+		detailTypes: map[string][]string{apiAudience: {"payment_initiation"}},
+	}
+
+	provider, err := newProvider(NewStore(db, apiAccess), nil, testTokenSigner{key: key}, Config{
+		BaseURL:      baseURL,
+		TokenBaseURL: baseURL,
+		Secret:       []byte(secret),
+	}, nil)
+	require.NoError(t, err)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
+
+	requestToken := func(t *testing.T, authorizationDetails string) map[string]any {
+		t.Helper()
+		form := url.Values{
+			"grant_type":            {"client_credentials"},
+			"resource":              {apiAudience},
+			"authorization_details": {authorizationDetails},
+		}
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, clientPlain)
+
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = req
+		handler.token(c)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	// The granted authorization details are returned with the token and carried in it
+	body := requestToken(t, `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"100.00"}}]`)
+	require.NotEmpty(t, body["access_token"], "got error: %v (%v)", body["error"], body["error_description"])
+	expected := []any{map[string]any{"type": "payment_initiation", "instructedAmount": map[string]any{"currency": "EUR", "amount": "100.00"}}}
+	require.Equal(t, expected, body["authorization_details"])
+	claims := decodeJWTPart(t, body["access_token"].(string), 1)
+	require.Equal(t, expected, claims["authorization_details"])
+
+	// A type the API doesn't accept is rejected
+	body = requestToken(t, `[{"type":"account_information"}]`)
+	require.Empty(t, body["access_token"])
+	require.Equal(t, "invalid_authorization_details", body["error"])
+}
+
 func TestTokenHandlerClientCredentialsDefaultsResourceScopes(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -253,7 +323,7 @@ func TestTokenHandlerClientCredentialsDefaultsResourceScopes(t *testing.T) {
 		Secret:       []byte(secret),
 	}, nil)
 	require.NoError(t, err)
-	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 	requestToken := func(t *testing.T, target string, form url.Values) map[string]any {
 		t.Helper()
@@ -385,6 +455,7 @@ func TestTokenHandlerRefreshGrantRevalidatesUser(t *testing.T) {
 		}
 		session.SetExpiresAt(fosite.RefreshToken, now.Add(30*24*time.Hour))
 		session.SetExpiresAt(fosite.AccessToken, now.Add(time.Hour))
+		session.ResourceGrants = resourceGrants
 
 		request := fosite.NewRequest()
 		request.ID = "refresh-req-" + userID
@@ -408,7 +479,7 @@ func TestTokenHandlerRefreshGrantRevalidatesUser(t *testing.T) {
 			Secret:       []byte(secret),
 		}, nil)
 		require.NoError(t, err)
-		handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil)
+		handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 		form := url.Values{
 			"grant_type":    {"refresh_token"},
@@ -542,7 +613,8 @@ func TestTokenHandlerRefreshGrantPreservesAudienceAndScope(t *testing.T) {
 	revokedAPI := userAccess(map[string][]string{})
 
 	// mintRefreshToken stores an active refresh-token session with the given granted scope and audience, standing in for a token issued by an earlier authorize, and returns the opaque token
-	mintRefreshToken := func(t *testing.T, db *gorm.DB, clientID, userID string, grantedScope, grantedAudience fosite.Arguments) string {
+	// resourceGrants splits the grant by resource, as for an authorization that named several resources
+	mintRefreshToken := func(t *testing.T, db *gorm.DB, clientID, userID string, grantedScope, grantedAudience fosite.Arguments, resourceGrants ...ResourceGrant) string {
 		t.Helper()
 		globalSecret, err := DeriveGlobalSecret([]byte(secret))
 		require.NoError(t, err)
@@ -592,7 +664,7 @@ func TestTokenHandlerRefreshGrantPreservesAudienceAndScope(t *testing.T) {
 			Secret:       []byte(secret),
 		}, nil)
 		require.NoError(t, err)
-		handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess)
+		handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), apiAccess, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 		form := url.Values{
 			"grant_type":    {"refresh_token"},
@@ -722,6 +794,47 @@ func TestTokenHandlerRefreshGrantPreservesAudienceAndScope(t *testing.T) {
 		require.Empty(t, body["access_token"])
 		require.Equal(t, "invalid_scope", body["error"])
 	})
+
+	t.Run("refresh of a grant for several resources issues the access token for the requested one", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		const clientID, userID = "client-multi-resource", "user-multi-resource"
+		const billingResource = "https://api.billing.example.com"
+		seedUserAndClient(t, db, clientID, userID)
+
+		multiAPI := userAccess(map[string][]string{
+			apiResource:     {"read:orders"},
+			billingResource: {"read:billing"},
+		})
+
+		token := mintRefreshToken(t, db, clientID, userID,
+			fosite.Arguments{"openid", "read:orders", "read:billing"},
+			fosite.Arguments{apiResource, billingResource},
+			ResourceGrant{Audience: apiResource, Scopes: []string{"openid", "read:orders"}},
+			ResourceGrant{Audience: billingResource, Scopes: []string{"openid", "read:billing"}},
+		)
+
+		// Without a resource the server can't tell which of the APIs the access token is for
+		body := doRefresh(t, db, multiAPI, clientID, token, nil)
+		require.Empty(t, body["access_token"])
+		require.Equal(t, "invalid_target", body["error"])
+
+		body = doRefresh(t, db, multiAPI, clientID, token, url.Values{"resource": {billingResource + "/"}})
+		require.NotEmpty(t, body["access_token"], "expected a new access token, got error: %v", body["error"])
+		claims := decodeJWTPart(t, body["access_token"].(string), 1)
+		require.ElementsMatch(t, []string{billingResource, baseURL}, jwtAudience(claims))
+		require.Equal(t, []string{"openid", "read:billing"}, jwtScopes(claims))
+
+		// The rotated refresh token still carries the whole grant, so the next access token can be for the other API
+		body = doRefresh(t, db, multiAPI, clientID, body["refresh_token"].(string), url.Values{"resource": {apiResource}})
+		require.NotEmpty(t, body["access_token"], "expected a new access token, got error: %v", body["error"])
+		claims = decodeJWTPart(t, body["access_token"].(string), 1)
+		require.ElementsMatch(t, []string{apiResource, baseURL}, jwtAudience(claims))
+		require.Equal(t, []string{"openid", "read:orders"}, jwtScopes(claims))
+
+		body = doRefresh(t, db, multiAPI, clientID, body["refresh_token"].(string), url.Values{"resource": {"https://api.inventory.example.com"}})
+		require.Empty(t, body["access_token"])
+		require.Equal(t, "invalid_target", body["error"])
+	})
 }
 
 // TestTokenHandlerClientSecretRotation drives the token endpoint with each of a client's secrets,
@@ -759,7 +872,7 @@ func TestTokenHandlerClientSecretRotation(t *testing.T) {
 		Secret:       []byte(secret),
 	}, nil)
 	require.NoError(t, err)
-	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 	requestToken := func(t *testing.T, clientSecret string) map[string]any {
 		t.Helper()
@@ -827,7 +940,7 @@ func TestTokenHandlerClientWithoutSecrets(t *testing.T) {
 		Secret:       []byte(secret),
 	}, nil)
 	require.NoError(t, err)
-	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil)
+	handler := newTokenHandler(provider, newClaimsService(db, nil, baseURL, nil), nil, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, nil, nil)
 
 	form := url.Values{"grant_type": {"client_credentials"}}
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/token", strings.NewReader(form.Encode()))
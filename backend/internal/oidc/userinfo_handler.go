@@ -1,26 +1,43 @@
 package oidc
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/ory/fosite"
 	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
 )
 
+// contentTypeJWT is the media type of userinfo responses returned as a JWT
+const contentTypeJWT = "application/jwt"
+
 type userInfoHandler struct {
 	provider      fosite.OAuth2Provider
 	claimsService *ClaimsService
 	issuer        string
+	dpop          *dpopVerifier
+	mtls          *tlsClientAuthenticator
+	signer        TokenSigner
+	encrypter     *responseEncrypter
 }
 
-func newUserInfoHandler(provider fosite.OAuth2Provider, claimsService *ClaimsService, issuer string) *userInfoHandler {
+func newUserInfoHandler(provider fosite.OAuth2Provider, claimsService *ClaimsService, issuer string, dpop *dpopVerifier, mtls *tlsClientAuthenticator, signer TokenSigner, encrypter *responseEncrypter) *userInfoHandler {
 	return &userInfoHandler{
 		provider:      provider,
 		claimsService: claimsService,
 		issuer:        issuer,
+		dpop:          dpop,
+		mtls:          mtls,
+		signer:        signer,
+		encrypter:     encrypter,
 	}
 }
 
@@ -30,12 +47,18 @@ func newUserInfoHandler(provider fosite.OAuth2Provider, claimsService *ClaimsSer
 // @Tags OIDC
 // @Accept json
 // @Produce json
-// @Success 200 {object} object "User claims based on requested scopes"
+// @Produce application/jwt
+// @Success 200 {object} object "User claims based on requested scopes, as a signed JWT if the client registered a userinfo signing or encryption algorithm or accepts only application/jwt"
 // @Security OAuth2AccessToken
 // @Router /api/oidc/userinfo [get]
 func (h *userInfoHandler) userInfo(c *gin.Context) {
 	ctx := c.Request.Context()
-	tokenType, accessRequest, err := h.provider.IntrospectToken(ctx, fosite.AccessTokenFromRequest(c.Request), fosite.AccessToken, NewEmptySession())
+	accessToken, scheme := accessTokenFromRequest(c.Request)
+	if scheme == dpopTokenType {
+		h.dpop.setNonceHeader(c)
+	}
+
+	tokenType, accessRequest, err := h.provider.IntrospectToken(ctx, accessToken, fosite.AccessToken, NewEmptySession())
 	if err != nil {
 		writeUserInfoError(c, err)
 		return
@@ -51,6 +74,17 @@ func (h *userInfoHandler) userInfo(c *gin.Context) {
 		return
 	}
 
+	err = h.dpop.verifyTokenBinding(ctx, c.Request, userInfoEndpointPath, accessToken, scheme, session)
+	if err != nil {
+		writeUserInfoError(c, err)
+		return
+	}
+	err = h.mtls.verifyTokenBinding(c.Request, session)
+	if err != nil {
+		writeUserInfoError(c, err)
+		return
+	}
+
 	// userinfo is one of Pocket ID's own identity endpoints, so the presented token must be audienced to Pocket ID itself (the issuer)
 	// A token granted an identity scope carries the issuer audience and is accepted here even when it also targets a custom API, while a token audienced only to a custom API belongs to that third-party resource server and cannot be replayed here to read the user's profile
 	if !accessRequest.GetGrantedAudience().Has(h.issuer) {
@@ -65,7 +99,13 @@ func (h *userInfoHandler) userInfo(c *gin.Context) {
 		return
 	}
 
-	claims, err := h.claimsService.GetUserClaims(ctx, session.GetSubject(), accessRequest.GetGrantedScopes())
+	client, _ := accessRequest.GetClient().(Client)
+	// The client's minimum may have been raised after the token was issued, so it's checked against the current setting
+	if !common.SatisfiesAuthenticationContext(session.AuthenticationContextClass, client.MinimumACR) {
+		writeStepUpChallenge(c, client.MinimumACR)
+		return
+	}
+	claims, err := h.claimsService.GetUserClaims(ctx, client.OidcClient, session.GetSubject(), accessRequest.GetGrantedScopes(), session.ClaimsRequest.userInfoClaims(), model.ClaimDestinationUserInfo)
 	if err != nil {
 		// A token whose subject no longer resolves to a user is an authentication failure, not a missing resource
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -76,10 +116,83 @@ func (h *userInfoHandler) userInfo(c *gin.Context) {
 		return
 	}
 
+	// The response depends on the Accept header, so caches must keep the formats apart
+	c.Header("Vary", "Accept")
+	if userInfoResponseFormat(c, client) == contentTypeJWT {
+		response, err := h.jwtResponse(ctx, client, claims)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		c.Data(http.StatusOK, contentTypeJWT, []byte(response))
+		return
+	}
+
 	c.JSON(http.StatusOK, claims)
 }
 
+// userInfoResponseFormat negotiates whether the claims are returned as JSON or as a JWT, see OpenID Connect Core section 5.3.2
+// Clients that registered a signed response get a JWT unless they only accept JSON, and other clients can ask for a JWT with the Accept header
+// Encrypted responses are never downgraded to JSON, as that would expose the claims the client wants protected
+func userInfoResponseFormat(c *gin.Context, client Client) string {
+	if client.UserinfoEncryptedResponseAlg != "" {
+		return contentTypeJWT
+	}
+
+	offered := []string{gin.MIMEJSON, contentTypeJWT}
+	if client.UserinfoSignedResponseAlg != "" {
+		offered = []string{contentTypeJWT, gin.MIMEJSON}
+	}
+	// An Accept header that matches neither format keeps the client's default, like before negotiation was supported
+	return cmp.Or(c.NegotiateFormat(offered...), offered[0])
+}
+
+// jwtResponse signs the claims as a JWT for the client, and encrypts it if the client registered an encryption algorithm
+func (h *userInfoHandler) jwtResponse(ctx context.Context, client Client, claims map[string]any) (string, error) {
+	token := jwt.New()
+	for key, value := range claims {
+		err := token.Set(key, value)
+		if err != nil {
+			return "", fmt.Errorf("failed to set claim '%s': %w", key, err)
+		}
+	}
+	// The issuer and audience let the client, and the systems it forwards the response to, check where it came from and who it was meant for
+	err := token.Set(jwt.IssuerKey, h.issuer)
+	if err != nil {
+		return "", err
+	}
+	err = token.Set(jwt.AudienceKey, []string{client.GetID()})
+	if err != nil {
+		return "", err
+	}
+
+	alg, err := registeredSigningAlgorithm(h.signer, client.UserinfoSignedResponseAlg)
+	if err != nil {
+		return "", err
+	}
+	signed, err := signJWT(h.signer, alg, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign userinfo response: %w", err)
+	}
+
+	if client.UserinfoEncryptedResponseAlg == "" {
+		return signed, nil
+	}
+	return h.encrypter.encrypt(ctx, client, signed, client.UserinfoEncryptedResponseAlg, client.UserinfoEncryptedResponseEnc)
+}
+
+// UserinfoSigningAlgValuesSupported returns the algorithms userinfo responses can be signed with, for the userinfo_signing_alg_values_supported server metadata
+// They are signed with the same key as the authorization responses
+func UserinfoSigningAlgValuesSupported(signer TokenSigner) []string {
+	return AuthorizationSigningAlgValuesSupported(signer)
+}
+
 func writeUserInfoError(c *gin.Context, err error) {
+	if isDPoPError(err) {
+		writeDPoPChallenge(c, err)
+		return
+	}
+
 	rfcErr := fosite.ErrorToRFC6749Error(err)
 	if rfcErr.StatusCode() == http.StatusUnauthorized {
 		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", error_description="%s"`, rfcErr.ErrorField, rfcErr.GetDescription()))
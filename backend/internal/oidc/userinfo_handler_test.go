@@ -4,16 +4,23 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
 
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
 )
@@ -54,9 +61,9 @@ func TestUserInfoHandler(t *testing.T) {
 	}, nil)
 	require.NoError(t, err)
 
-	handler := newUserInfoHandler(provider, newClaimsService(db, nil, baseURL, nil), baseURL)
+	handler := newUserInfoHandler(provider, newClaimsService(db, nil, baseURL, nil), baseURL, newDPoPVerifier(NewStore(db, nil), []byte("test-secret"), baseURL), provider.tlsClientAuth, testTokenSigner{key: key}, provider.responseEncrypter)
 
-	issueAccessToken := func(t *testing.T, requestID, subject string, scopes ...string) string {
+	issueAccessTokenFor := func(t *testing.T, requestID string, client model.OidcClient, subject string, scopes ...string) string {
 		t.Helper()
 		session := NewEmptySession()
 		session.Subject = subject
@@ -64,32 +71,66 @@ func TestUserInfoHandler(t *testing.T) {
 
 		request := fosite.NewAccessRequest(session)
 		request.ID = requestID
-		request.Client = Client{OidcClient: model.OidcClient{Base: model.Base{ID: clientID}}}
+		request.Client = Client{OidcClient: client}
 		request.GrantTypes = fosite.Arguments{string(fosite.GrantTypeClientCredentials)}
 		request.RequestedScope = fosite.Arguments(scopes)
 		request.GrantedScope = fosite.Arguments(scopes)
 		// The grant is bound to the requesting client
 		// The issuer store adds the issuer audience when the token carries an identity scope, which is what userinfo gates on
-		request.RequestedAudience = fosite.Arguments{clientID}
-		request.GrantedAudience = fosite.Arguments{clientID}
+		request.RequestedAudience = fosite.Arguments{client.ID}
+		request.GrantedAudience = fosite.Arguments{client.ID}
 
 		response, err := provider.NewAccessResponse(t.Context(), request)
 		require.NoError(t, err)
 		return response.GetAccessToken()
 	}
+	issueAccessToken := func(t *testing.T, requestID, subject string, scopes ...string) string {
+		t.Helper()
+		return issueAccessTokenFor(t, requestID, model.OidcClient{Base: model.Base{ID: clientID}}, subject, scopes...)
+	}
 
-	call := func(t *testing.T, token string) (*httptest.ResponseRecorder, *gin.Context) {
+	serve := func(t *testing.T, req *http.Request) (*httptest.ResponseRecorder, *gin.Context) {
 		t.Helper()
-		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/api/oidc/userinfo", nil)
-		if token != "" {
-			req.Header.Set("Authorization", "Bearer "+token)
-		}
 		rec := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(rec)
 		c.Request = req
 		handler.userInfo(c)
 		return rec, c
 	}
+	callAccepting := func(t *testing.T, token string, accept string) (*httptest.ResponseRecorder, *gin.Context) {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/api/oidc/userinfo", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return serve(t, req)
+	}
+	call := func(t *testing.T, token string) (*httptest.ResponseRecorder, *gin.Context) {
+		t.Helper()
+		return callAccepting(t, token, "")
+	}
+	// parseSignedResponse verifies a userinfo JWT with the instance key and checks that it was issued for the client
+	parseSignedResponse := func(t *testing.T, signed []byte, audience string) jwt.Token {
+		t.Helper()
+		token, err := jwt.Parse(signed, jwt.WithKey(jwa.ES256(), key.Public()), jwt.WithIssuer(baseURL), jwt.WithAudience(audience))
+		require.NoError(t, err)
+		subject, _ := token.Subject()
+		require.Equal(t, userID, subject)
+		var email string
+		require.NoError(t, token.Get("email", &email))
+		require.Equal(t, "tim@example.com", email)
+		return token
+	}
+
+	signingClient := model.OidcClient{
+		Base:                      model.Base{ID: "signing-client"},
+		Name:                      "Signing Client",
+		UserinfoSignedResponseAlg: "ES256",
+	}
+	require.NoError(t, db.Create(&signingClient).Error)
 
 	t.Run("valid user access token returns the granted claims", func(t *testing.T) {
 		token := issueAccessToken(t, "req-valid", userID, "openid", "email")
@@ -106,6 +147,85 @@ func TestUserInfoHandler(t *testing.T) {
 		require.NotContains(t, claims, "given_name")
 	})
 
+	t.Run("client with a userinfo signing algorithm gets a signed JWT", func(t *testing.T) {
+		token := issueAccessTokenFor(t, "req-signed", signingClient, userID, "openid", "email")
+		rec, c := call(t, token)
+
+		require.Empty(t, c.Errors)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/jwt", rec.Header().Get("Content-Type"))
+		require.Equal(t, "Accept", rec.Header().Get("Vary"))
+		parseSignedResponse(t, rec.Body.Bytes(), signingClient.ID)
+	})
+
+	t.Run("signed userinfo is returned for POST requests", func(t *testing.T) {
+		token := issueAccessTokenFor(t, "req-signed-post", signingClient, userID, "openid", "email")
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/oidc/userinfo", strings.NewReader(url.Values{"access_token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec, c := serve(t, req)
+
+		require.Empty(t, c.Errors)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/jwt", rec.Header().Get("Content-Type"))
+		parseSignedResponse(t, rec.Body.Bytes(), signingClient.ID)
+	})
+
+	t.Run("client with a userinfo signing algorithm can ask for JSON", func(t *testing.T) {
+		token := issueAccessTokenFor(t, "req-signed-json", signingClient, userID, "openid", "email")
+		rec, c := callAccepting(t, token, "application/json")
+
+		require.Empty(t, c.Errors)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var claims map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &claims))
+		require.Equal(t, userID, claims["sub"])
+	})
+
+	t.Run("client can ask for a signed JWT with the Accept header", func(t *testing.T) {
+		token := issueAccessToken(t, "req-accept-jwt", userID, "openid", "email")
+		rec, c := callAccepting(t, token, "application/jwt")
+
+		require.Empty(t, c.Errors)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/jwt", rec.Header().Get("Content-Type"))
+		parseSignedResponse(t, rec.Body.Bytes(), clientID)
+	})
+
+	t.Run("Accept header that matches no format keeps JSON", func(t *testing.T) {
+		token := issueAccessToken(t, "req-accept-html", userID, "openid", "email")
+		rec, c := callAccepting(t, token, "text/html")
+
+		require.Empty(t, c.Errors)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Header().Get("Content-Type"), "application/json")
+	})
+
+	t.Run("client with userinfo encryption gets a signed and encrypted JWT", func(t *testing.T) {
+		encryptionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		encryptingClient := model.OidcClient{
+			Base:                         model.Base{ID: "encrypting-client"},
+			Name:                         "Encrypting Client",
+			UserinfoEncryptedResponseAlg: "RSA-OAEP-256",
+			UserinfoEncryptedResponseEnc: "A256GCM",
+			Credentials: model.OidcClientCredentials{
+				JWKS: testClientJWKS(t, map[string]any{"enc-key": encryptionKey}, nil),
+			},
+		}
+		require.NoError(t, db.Create(&encryptingClient).Error)
+
+		token := issueAccessTokenFor(t, "req-encrypted", encryptingClient, userID, "openid", "email")
+		// An encrypted response is never downgraded to JSON
+		rec, c := callAccepting(t, token, "application/json")
+		require.Empty(t, c.Errors)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/jwt", rec.Header().Get("Content-Type"))
+
+		signed, err := jwe.Decrypt(rec.Body.Bytes(), jwe.WithKey(jwa.RSA_OAEP_256(), encryptionKey))
+		require.NoError(t, err)
+		parseSignedResponse(t, signed, encryptingClient.ID)
+	})
+
 	t.Run("token without the openid scope is rejected", func(t *testing.T) {
 		// A token issued purely for a custom API carries no openid scope and must not read profile claims
 		session := NewEmptySession()
@@ -181,6 +301,21 @@ func TestUserInfoHandler(t *testing.T) {
 		require.Contains(t, rec.Body.String(), "not audienced to this server")
 	})
 
+	t.Run("token below the client's minimum authentication context class gets a step-up challenge", func(t *testing.T) {
+		stepUpClient := model.OidcClient{
+			Base:       model.Base{ID: "step-up-client"},
+			Name:       "Step-up Client",
+			MinimumACR: common.ACRDeviceBoundPasskey,
+		}
+		require.NoError(t, db.Create(&stepUpClient).Error)
+
+		token := issueAccessTokenFor(t, "req-step-up", stepUpClient, userID, "openid", "email")
+		rec, _ := call(t, token)
+
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, `Bearer error="insufficient_user_authentication", error_description="A stronger authentication is required.", acr_values="urn:pocket-id:acr:passkey:device-bound"`, rec.Header().Get("WWW-Authenticate"))
+	})
+
 	t.Run("token whose user no longer exists is rejected with 401", func(t *testing.T) {
 		// A valid token whose subject was deleted is an auth failure, not a 404
 		token := issueAccessToken(t, "req-ghost", "ghost-user", "openid")
@@ -354,31 +354,35 @@ func (s *TestService) SeedDatabase(baseURL string) error {
 
 		userAuthorizedClients := []model.UserAuthorizedOidcClient{
 			{
-				Scope:      datatype.StringList{"openid", "profile", "email"},
 				UserID:     users[0].ID,
 				ClientID:   oidcClients[0].ID,
 				LastUsedAt: datatype.DateTime(time.Date(2025, 8, 1, 13, 0, 0, 0, time.UTC)),
 			},
 			{
-				Scope:      datatype.StringList{"openid", "profile", "email"},
 				UserID:     users[0].ID,
 				ClientID:   oidcClients[2].ID,
 				LastUsedAt: datatype.DateTime(time.Date(2025, 8, 10, 14, 0, 0, 0, time.UTC)),
 			},
 			{
-				Scope:      datatype.StringList{"openid", "profile", "email"},
 				UserID:     users[1].ID,
 				ClientID:   oidcClients[3].ID,
 				LastUsedAt: datatype.DateTime(time.Date(2025, 8, 12, 12, 0, 0, 0, time.UTC)),
 			},
 			{
-				Scope:      datatype.StringList{"openid", "profile", "email"},
 				UserID:     users[0].ID,
 				ClientID:   oidcClients[5].ID,
 				LastUsedAt: datatype.DateTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
 			},
 		}
 		for _, userAuthorizedClient := range userAuthorizedClients {
+			// The seeded users granted the same scopes to every client when they last used it
+			for _, scope := range []string{"openid", "profile", "email"} {
+				userAuthorizedClient.ScopeConsents = append(userAuthorizedClient.ScopeConsents, model.ScopeConsent{
+					Scope:       scope,
+					Granted:     true,
+					ConsentedAt: userAuthorizedClient.LastUsedAt,
+				})
+			}
 			if err := tx.Create(&userAuthorizedClient).Error; err != nil {
 				return err
 			}
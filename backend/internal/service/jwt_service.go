@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	"gorm.io/gorm"
 
@@ -43,12 +46,21 @@ const (
 )
 
 type JwtService struct {
-	db          *gorm.DB
-	envConfig   *common.EnvConfigSchema
+	db         *gorm.DB
+	envConfig  *common.EnvConfigSchema
+	instanceID string
+
+	// mu guards the keys, which are reloaded while requests are being served after a signing key rollover
+	mu          sync.RWMutex
 	privateKey  jwk.Key
 	keyId       string
-	instanceID  string
 	jwksEncoded []byte
+	// additionalKeys are the signing keys of other algorithms that are active alongside privateKey
+	additionalKeys []jwk.Key
+	// nextKeys are published ahead of replacing privateKey and the additional keys during a rollover, so relying parties already know them once they sign tokens
+	nextKeys []jwk.Key
+	// retiredKeys were replaced by a rollover, and remain published until the tokens they signed have expired
+	retiredKeys []jwk.Key
 }
 
 func NewJwtService(ctx context.Context, db *gorm.DB, instanceID string) (*JwtService, error) {
@@ -84,28 +96,74 @@ func (s *JwtService) LoadOrGenerateKey(ctx context.Context) error {
 		return fmt.Errorf("failed to load key: %w", err)
 	}
 
-	// If we have a key, store it in the object and we're done
+	// If we have a key, store it in the object, otherwise we need to generate a new one
 	if key != nil {
 		err = s.SetKey(key)
 		if err != nil {
 			return fmt.Errorf("failed to set private key: %w", err)
 		}
-		return nil
+	} else {
+		err = s.generateKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+
+		// Save the newly-generated key
+		err = keyProvider.SaveKey(ctx, s.privateKey)
+		if err != nil {
+			return fmt.Errorf("failed to save private key: %w", err)
+		}
 	}
 
-	// If we are here, we need to generate a new key
-	err = s.generateKey()
+	return s.loadKeys(ctx, keyProvider, s.privateKey)
+}
+
+// ReloadKeys loads all keys from the key provider again, picking up a rollover that was performed by any replica
+func (s *JwtService) ReloadKeys(ctx context.Context) error {
+	keyProvider, err := jwkutils.GetKeyProvider(s.db, s.envConfig, s.instanceID)
 	if err != nil {
-		return fmt.Errorf("failed to generate key: %w", err)
+		return fmt.Errorf("failed to get key provider: %w", err)
 	}
 
-	// Save the newly-generated key
-	err = keyProvider.SaveKey(ctx, s.privateKey)
+	key, err := keyProvider.LoadKey(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to save private key: %w", err)
+		return fmt.Errorf("failed to load key: %w", err)
+	}
+	if key == nil {
+		return errors.New("key not found")
 	}
 
-	return nil
+	return s.loadKeys(ctx, keyProvider, key)
+}
+
+// loadKeys loads the keys that accompany the private key, then sets all of them at once
+func (s *JwtService) loadKeys(ctx context.Context, keyProvider jwkutils.KeyProvider, privateKey jwk.Key) error {
+	// Load the keys of other algorithms that are active alongside the key
+	additionalKeys, err := keyProvider.LoadAdditionalKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load additional keys: %w", err)
+	}
+
+	// Load the keys of a rollover, which are published but never used for signing
+	nextKey, err := keyProvider.LoadNextKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load next key: %w", err)
+	}
+	nextKeys, err := keyProvider.LoadNextAdditionalKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load next additional keys: %w", err)
+	}
+	if nextKey != nil {
+		nextKeys = append([]jwk.Key{nextKey}, nextKeys...)
+	}
+	retiredKeys, err := keyProvider.LoadRetiredKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load retired keys: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceKeys(privateKey, additionalKeys, nextKeys, retiredKeys)
 }
 
 // generateKey generates a new key and stores it in the object
@@ -148,40 +206,130 @@ func ValidateKey(privateKey jwk.Key) error {
 }
 
 func (s *JwtService) SetKey(privateKey jwk.Key) error {
-	// Validate the loaded key
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceKeys(privateKey, s.additionalKeys, s.nextKeys, s.retiredKeys)
+}
+
+// SetAdditionalKeys sets the signing keys of other algorithms that are active alongside the private key
+// Each of them is published in the JWKS, and is used for clients and APIs that need its algorithm
+func (s *JwtService) SetAdditionalKeys(keys []jwk.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceKeys(s.privateKey, keys, s.nextKeys, s.retiredKeys)
+}
+
+// SetRolloverKeys sets the next and retired keys of a signing key rollover
+// They're published in the JWKS and accepted when verifying tokens, but never used for signing
+func (s *JwtService) SetRolloverKeys(nextKeys []jwk.Key, retiredKeys []jwk.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceKeys(s.privateKey, s.additionalKeys, nextKeys, retiredKeys)
+}
+
+// replaceKeys validates the keys, then swaps them in together with the JWKS that publishes them
+// The caller must hold the write lock
+func (s *JwtService) replaceKeys(privateKey jwk.Key, additionalKeys []jwk.Key, nextKeys []jwk.Key, retiredKeys []jwk.Key) error {
+	if privateKey == nil {
+		return errors.New("key is not initialized")
+	}
 	err := ValidateKey(privateKey)
 	if err != nil {
 		return fmt.Errorf("private key is not valid: %w", err)
 	}
-
-	// Set the private key and key id in the object
-	s.privateKey = privateKey
-
 	keyId, ok := privateKey.KeyID()
 	if !ok {
 		return errors.New("key object does not contain a key ID")
 	}
-	s.keyId = keyId
 
-	// Create and encode a JWKS containing the public key
-	publicKey, err := s.GetPublicJWK()
+	for _, key := range additionalKeys {
+		err = ValidateKey(key)
+		if err != nil {
+			return fmt.Errorf("additional key is not valid: %w", err)
+		}
+		alg, ok := key.Algorithm()
+		if !ok || alg == nil {
+			return errors.New("additional key does not contain an algorithm")
+		}
+	}
+
+	for _, key := range nextKeys {
+		err = ValidateKey(key)
+		if err != nil {
+			return fmt.Errorf("next key is not valid: %w", err)
+		}
+	}
+	for _, key := range retiredKeys {
+		err = ValidateKey(key)
+		if err != nil {
+			return fmt.Errorf("retired key is not valid: %w", err)
+		}
+	}
+
+	keys := publishedKeys(privateKey, additionalKeys, nextKeys, retiredKeys)
+	jwksEncoded, err := encodeJWKS(keys)
 	if err != nil {
-		return fmt.Errorf("failed to get public JWK: %w", err)
+		return err
 	}
+
+	s.privateKey = privateKey
+	s.keyId = keyId
+	s.additionalKeys = additionalKeys
+	s.nextKeys = nextKeys
+	s.retiredKeys = retiredKeys
+	s.jwksEncoded = jwksEncoded
+
+	return nil
+}
+
+// publishedKeys returns every key that is published in the JWKS, starting with the active signing keys
+// While a rollover is being promoted, a key can briefly be stored both as an active key and as a next or retired key, so each key ID is listed once
+func publishedKeys(privateKey jwk.Key, additionalKeys []jwk.Key, nextKeys []jwk.Key, retiredKeys []jwk.Key) []jwk.Key {
+	keys := make([]jwk.Key, 0, len(additionalKeys)+len(nextKeys)+len(retiredKeys)+1)
+	keys = append(keys, privateKey)
+	keys = append(keys, additionalKeys...)
+	keys = append(keys, nextKeys...)
+	keys = append(keys, retiredKeys...)
+
+	seen := make(map[string]struct{}, len(keys))
+	return slices.DeleteFunc(keys, func(key jwk.Key) bool {
+		kid, _ := key.KeyID()
+		_, ok := seen[kid]
+		seen[kid] = struct{}{}
+		return ok
+	})
+}
+
+// encodeJWKS creates and encodes a JWKS containing the public keys of the keys
+func encodeJWKS(keys []jwk.Key) ([]byte, error) {
 	jwks := jwk.NewSet()
-	err = jwks.AddKey(publicKey)
-	if err != nil {
-		return fmt.Errorf("failed to add public key to JWKS: %w", err)
+	for _, key := range keys {
+		publicKey, err := key.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get public key: %w", err)
+		}
+		jwkutils.EnsureAlgInKey(publicKey, "", "")
+		err = jwks.AddKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add public key to JWKS: %w", err)
+		}
 	}
-	s.jwksEncoded, err = json.Marshal(jwks)
+
+	jwksEncoded, err := json.Marshal(jwks)
 	if err != nil {
-		return fmt.Errorf("failed to encode JWKS to JSON: %w", err)
+		return nil, fmt.Errorf("failed to encode JWKS to JSON: %w", err)
 	}
 
-	return nil
+	return jwksEncoded, nil
 }
 
 func (s *JwtService) GenerateAccessToken(user model.User, authenticationMethod string, sessionDuration time.Duration) (string, error) {
+	return s.GenerateAccessTokenWithContextClass(user, authenticationMethod, defaultAuthenticationContextClass(authenticationMethod), sessionDuration)
+}
+
+// GenerateAccessTokenWithContextClass generates an access token that also records the authentication context class of the sign in
+// It's used by sign in methods whose strength isn't implied by the authentication method alone, such as passkeys
+func (s *JwtService) GenerateAccessTokenWithContextClass(user model.User, authenticationMethod string, authenticationContextClass string, sessionDuration time.Duration) (string, error) {
 	now := time.Now()
 	token, err := jwt.NewBuilder().
 		Subject(user.ID).
@@ -214,8 +362,17 @@ func (s *JwtService) GenerateAccessToken(user model.User, authenticationMethod s
 		return "", fmt.Errorf("failed to set '%s' claim in token: %w", common.AuthenticationMethodsClaim, err)
 	}
 
-	alg, _ := s.privateKey.Algorithm()
-	signed, err := jwt.Sign(token, jwt.WithKey(alg, s.privateKey))
+	err = SetAuthenticationContextClass(token, authenticationContextClass)
+	if err != nil {
+		return "", fmt.Errorf("failed to set '%s' claim in token: %w", common.AuthenticationContextClassClaim, err)
+	}
+
+	s.mu.RLock()
+	privateKey := s.privateKey
+	s.mu.RUnlock()
+
+	alg, _ := privateKey.Algorithm()
+	signed, err := jwt.Sign(token, jwt.WithKey(alg, privateKey))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -224,11 +381,16 @@ func (s *JwtService) GenerateAccessToken(user model.User, authenticationMethod s
 }
 
 func (s *JwtService) VerifyAccessToken(tokenString string) (jwt.Token, error) {
-	alg, _ := s.privateKey.Algorithm()
+	key, err := s.verificationKeyForToken(tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	alg, _ := key.Algorithm()
 	token, err := jwt.ParseString(
 		tokenString,
 		jwt.WithValidate(true),
-		jwt.WithKey(alg, s.privateKey),
+		jwt.WithKey(alg, key),
 		jwt.WithAcceptableSkew(clockSkew),
 		jwt.WithAudience(s.envConfig.AppURL),
 		jwt.WithIssuer(s.envConfig.AppURL),
@@ -241,8 +403,44 @@ func (s *JwtService) VerifyAccessToken(tokenString string) (jwt.Token, error) {
 	return token, nil
 }
 
+// verificationKeyForToken returns the key a token was signed with, which the key ID in the header identifies
+// Tokens without a key ID are verified with the private key
+func (s *JwtService) verificationKeyForToken(tokenString string) (jwk.Key, error) {
+	msg, err := jws.Parse([]byte(tokenString))
+	if err != nil {
+		return nil, err
+	}
+	signatures := msg.Signatures()
+	if len(signatures) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+
+	s.mu.RLock()
+	privateKey := s.privateKey
+	s.mu.RUnlock()
+	if privateKey == nil {
+		return nil, errors.New("key is not initialized")
+	}
+
+	keyID, ok := signatures[0].ProtectedHeaders().KeyID()
+	if !ok {
+		return privateKey, nil
+	}
+	for _, key := range s.GetVerificationKeys() {
+		kid, ok := key.KeyID()
+		if ok && kid == keyID {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("token is signed with unknown key '%s'", keyID)
+}
+
 // GetPublicJWK returns the JSON Web Key (JWK) for the public key.
 func (s *JwtService) GetPublicJWK() (jwk.Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if s.privateKey == nil {
 		return nil, errors.New("key is not initialized")
 	}
@@ -257,9 +455,12 @@ func (s *JwtService) GetPublicJWK() (jwk.Key, error) {
 	return pubKey, nil
 }
 
-// GetPublicJWKSAsJSON returns the JSON Web Key Set (JWKS) for the public key, encoded as JSON.
-// The value is cached since the key is static.
+// GetPublicJWKSAsJSON returns the JSON Web Key Set (JWKS) for the public keys of all active signing keys, encoded as JSON.
+// The value is cached, and only changes when the keys are reloaded.
 func (s *JwtService) GetPublicJWKSAsJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if len(s.jwksEncoded) == 0 {
 		return nil, errors.New("key is not initialized")
 	}
@@ -269,6 +470,9 @@ func (s *JwtService) GetPublicJWKSAsJSON() ([]byte, error) {
 
 // GetKeyAlg returns the algorithm of the key
 func (s *JwtService) GetKeyAlg() (jwa.KeyAlgorithm, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if len(s.jwksEncoded) == 0 {
 		return nil, errors.New("key is not initialized")
 	}
@@ -283,12 +487,38 @@ func (s *JwtService) GetKeyAlg() (jwa.KeyAlgorithm, error) {
 
 // GetKeyID returns the key ID (kid) of the signing key, if one is set.
 func (s *JwtService) GetKeyID() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if s.privateKey == nil {
 		return "", false
 	}
 	return s.privateKey.KeyID()
 }
 
+// GetSigningKeys returns all active signing keys, starting with the default key
+func (s *JwtService) GetSigningKeys() []jwk.Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.privateKey == nil {
+		return nil
+	}
+	return append([]jwk.Key{s.privateKey}, s.additionalKeys...)
+}
+
+// GetVerificationKeys returns all keys tokens signed by Pocket ID can be verified with, starting with the active signing keys
+// Besides those, it includes the next and retired keys of a rollover, since another replica may already have promoted the next key, and tokens signed with a retired key are still valid
+func (s *JwtService) GetVerificationKeys() []jwk.Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.privateKey == nil {
+		return nil
+	}
+	return publishedKeys(s.privateKey, s.additionalKeys, s.nextKeys, s.retiredKeys)
+}
+
 // GetAuthenticationMethod returns the first authentication method in the "amr" claim in the token
 func (s *JwtService) GetAuthenticationMethod(token jwt.Token) (string, error) {
 	if !token.Has(common.AuthenticationMethodsClaim) {
@@ -310,6 +540,37 @@ func (s *JwtService) GetAuthenticationMethod(token jwt.Token) (string, error) {
 	return authenticationMethod, nil
 }
 
+// GetAuthenticationContextClass returns the authentication context class in the "acr" claim in the token
+// Tokens issued before the claim was introduced get the class implied by their authentication method
+func (s *JwtService) GetAuthenticationContextClass(token jwt.Token) (string, error) {
+	if !token.Has(common.AuthenticationContextClassClaim) {
+		authenticationMethod, err := s.GetAuthenticationMethod(token)
+		if err != nil {
+			return "", err
+		}
+		return defaultAuthenticationContextClass(authenticationMethod), nil
+	}
+
+	var authenticationContextClass string
+	err := token.Get(common.AuthenticationContextClassClaim, &authenticationContextClass)
+	if err != nil {
+		return "", fmt.Errorf("failed to get '%s' claim from token: %w", common.AuthenticationContextClassClaim, err)
+	}
+	return authenticationContextClass, nil
+}
+
+// defaultAuthenticationContextClass returns the weakest authentication context class that the authentication method achieves
+func defaultAuthenticationContextClass(authenticationMethod string) string {
+	switch authenticationMethod {
+	case AuthenticationMethodOneTimePassword:
+		return common.ACROneTimeCode
+	case AuthenticationMethodPhishingResistant:
+		return common.ACRPasskey
+	default:
+		return ""
+	}
+}
+
 // SetTokenType sets the "type" claim in the token
 func SetTokenType(token jwt.Token, tokenType string) error {
 	if tokenType == "" {
@@ -335,6 +596,14 @@ func SetAuthenticationMethods(token jwt.Token, authenticationMethod string) erro
 	return token.Set(common.AuthenticationMethodsClaim, []string{authenticationMethod})
 }
 
+// SetAuthenticationContextClass sets the authentication context class claim in the token
+func SetAuthenticationContextClass(token jwt.Token, authenticationContextClass string) error {
+	if authenticationContextClass == "" {
+		return nil
+	}
+	return token.Set(common.AuthenticationContextClassClaim, authenticationContextClass)
+}
+
 // SetAudienceString sets the "aud" claim with a value that is a string, and not an array
 // This is permitted by RFC 7519, and it's done here for backwards-compatibility
 func SetAudienceString(token jwt.Token, audience string) error {
@@ -357,6 +626,9 @@ func TokenTypeValidator(expectedTokenType string) jwt.ValidatorFunc {
 }
 
 func (s *JwtService) GetPrivateKey() any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	var privateKey any
 	_ = jwk.Export(s.privateKey, &privateKey)
 	return privateKey
@@ -190,6 +190,143 @@ func TestJwtService_Init(t *testing.T) {
 
 }
 
+func TestJwtService_AdditionalKeys(t *testing.T) {
+	mockConfig := appconfig.NewTestAppConfigService(nil)
+	db := testutils.NewDatabaseForTest(t)
+	mockEnvConfig := newTestEnvConfig()
+	instanceID := newInstanceID(t, db)
+
+	// Save a default RS256 key and an additional ES256 key
+	defaultKey, err := jwkutils.GenerateKey("RS256", "")
+	require.NoError(t, err)
+	defaultKID := saveKeyToDatabase(t, db, instanceID, mockEnvConfig, mockConfig, defaultKey)
+
+	additionalKey, err := jwkutils.GenerateKey("ES256", "")
+	require.NoError(t, err)
+	keyProvider, err := jwkutils.GetKeyProvider(db, mockEnvConfig, instanceID)
+	require.NoError(t, err)
+	require.NoError(t, keyProvider.SaveAdditionalKeys(t.Context(), []jwk.Key{additionalKey}))
+	additionalKID, _ := additionalKey.KeyID()
+
+	service := initJwtService(t, db, instanceID, mockConfig, mockEnvConfig)
+
+	t.Run("signing keys start with the default key", func(t *testing.T) {
+		keys := service.GetSigningKeys()
+		require.Len(t, keys, 2)
+		kid, _ := keys[0].KeyID()
+		assert.Equal(t, defaultKID, kid)
+		kid, _ = keys[1].KeyID()
+		assert.Equal(t, additionalKID, kid)
+
+		alg, err := service.GetKeyAlg()
+		require.NoError(t, err)
+		assert.Equal(t, "RS256", alg.String())
+	})
+
+	t.Run("JWKS publishes all public keys", func(t *testing.T) {
+		jwksJSON, err := service.GetPublicJWKSAsJSON()
+		require.NoError(t, err)
+
+		set, err := jwk.Parse(jwksJSON)
+		require.NoError(t, err)
+		require.Equal(t, 2, set.Len())
+
+		for _, kid := range []string{defaultKID, additionalKID} {
+			key, ok := set.LookupKeyID(kid)
+			require.True(t, ok, "JWKS should contain key %s", kid)
+			isPrivate, err := jwk.IsPrivateKey(key)
+			require.NoError(t, err)
+			assert.False(t, isPrivate)
+		}
+	})
+
+	t.Run("rejects additional keys without an algorithm", func(t *testing.T) {
+		key, err := jwkutils.GenerateKey("ES384", "")
+		require.NoError(t, err)
+		require.NoError(t, key.Remove(jwk.AlgorithmKey))
+
+		err = service.SetAdditionalKeys([]jwk.Key{key})
+		require.Error(t, err)
+	})
+}
+
+func TestJwtService_RolloverKeys(t *testing.T) {
+	mockConfig := appconfig.NewTestAppConfigService(nil)
+	db := testutils.NewDatabaseForTest(t)
+	mockEnvConfig := newTestEnvConfig()
+	instanceID := newInstanceID(t, db)
+
+	currentKey, err := jwkutils.GenerateKey("ES256", "")
+	require.NoError(t, err)
+	currentKID := saveKeyToDatabase(t, db, instanceID, mockEnvConfig, mockConfig, currentKey)
+
+	nextKey, err := jwkutils.GenerateKey("ES256", "")
+	require.NoError(t, err)
+	nextKID, _ := nextKey.KeyID()
+	keyProvider, err := jwkutils.GetKeyProvider(db, mockEnvConfig, instanceID)
+	require.NoError(t, err)
+	require.NoError(t, keyProvider.SaveNextKey(t.Context(), nextKey))
+
+	service := initJwtService(t, db, instanceID, mockConfig, mockEnvConfig)
+	user := model.User{Base: model.Base{ID: "user123"}}
+
+	t.Run("the next key is published but not used for signing", func(t *testing.T) {
+		keys := service.GetSigningKeys()
+		require.Len(t, keys, 1)
+		kid, _ := keys[0].KeyID()
+		assert.Equal(t, currentKID, kid)
+
+		jwksJSON, err := service.GetPublicJWKSAsJSON()
+		require.NoError(t, err)
+		set, err := jwk.Parse(jwksJSON)
+		require.NoError(t, err)
+		require.Equal(t, 2, set.Len())
+		_, ok := set.LookupKeyID(nextKID)
+		assert.True(t, ok, "JWKS should contain the next key")
+	})
+
+	tokenString, err := service.GenerateAccessToken(user, "", time.Hour)
+	require.NoError(t, err)
+
+	// Promote the next key as the rollover does, retiring the current key
+	require.NoError(t, keyProvider.SaveRetiredKeys(t.Context(), []jwk.Key{currentKey}))
+	require.NoError(t, keyProvider.SaveKey(t.Context(), nextKey))
+	require.NoError(t, keyProvider.DeleteNextKey(t.Context()))
+	require.NoError(t, service.ReloadKeys(t.Context()))
+
+	t.Run("the promoted key signs new tokens", func(t *testing.T) {
+		kid, ok := service.GetKeyID()
+		require.True(t, ok)
+		assert.Equal(t, nextKID, kid)
+
+		newTokenString, err := service.GenerateAccessToken(user, "", time.Hour)
+		require.NoError(t, err)
+		_, err = service.VerifyAccessToken(newTokenString)
+		require.NoError(t, err)
+	})
+
+	t.Run("tokens signed with the retired key remain valid", func(t *testing.T) {
+		_, err := service.VerifyAccessToken(tokenString)
+		require.NoError(t, err)
+
+		jwksJSON, err := service.GetPublicJWKSAsJSON()
+		require.NoError(t, err)
+		set, err := jwk.Parse(jwksJSON)
+		require.NoError(t, err)
+		require.Equal(t, 2, set.Len())
+		_, ok := set.LookupKeyID(currentKID)
+		assert.True(t, ok, "JWKS should contain the retired key")
+	})
+
+	t.Run("tokens signed with a removed key are rejected", func(t *testing.T) {
+		require.NoError(t, keyProvider.SaveRetiredKeys(t.Context(), nil))
+		require.NoError(t, service.ReloadKeys(t.Context()))
+
+		_, err := service.VerifyAccessToken(tokenString)
+		require.Error(t, err)
+	})
+}
+
 func TestJwtService_GetPublicJWK(t *testing.T) {
 	mockConfig := appconfig.NewTestAppConfigService(nil)
 	db := testutils.NewDatabaseForTest(t)
@@ -396,6 +533,31 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 			assert.Equal(t, AuthenticationMethodPhishingResistant, authenticationMethod, "amr should match")
 	})
 
+	t.Run("sets authentication context class claim", func(t *testing.T) {
+		service, _, _ := setupJwtService(t, instanceID, mockConfig)
+
+		user := model.User{
+			Base: model.Base{ID: "user-with-acr"},
+		}
+
+		tokenString, err := service.GenerateAccessTokenWithContextClass(user, AuthenticationMethodPhishingResistant, common.ACRDeviceBoundPasskey, sessionDuration)
+		require.NoError(t, err, "Failed to generate access token")
+		claims, err := service.VerifyAccessToken(tokenString)
+		require.NoError(t, err, "Failed to verify generated token")
+		acr, err := service.GetAuthenticationContextClass(claims)
+		_ = assert.NoError(t, err, "Failed to get acr claim") &&
+			assert.Equal(t, common.ACRDeviceBoundPasskey, acr, "acr should match")
+
+		// The class is derived from the authentication method when it isn't set explicitly
+		tokenString, err = service.GenerateAccessToken(user, AuthenticationMethodOneTimePassword, sessionDuration)
+		require.NoError(t, err, "Failed to generate access token")
+		claims, err = service.VerifyAccessToken(tokenString)
+		require.NoError(t, err, "Failed to verify generated token")
+		acr, err = service.GetAuthenticationContextClass(claims)
+		_ = assert.NoError(t, err, "Failed to get acr claim") &&
+			assert.Equal(t, common.ACROneTimeCode, acr, "acr should match")
+	})
+
 	t.Run("works with Ed25519 keys", func(t *testing.T) {
 		origKeyID := createEdDSAKeyJWK(t, db, instanceID, envConfig, mockConfig)
 		service := initJwtService(t, db, instanceID, mockConfig, envConfig)
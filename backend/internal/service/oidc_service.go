@@ -3,9 +3,11 @@ package service
 import (
 	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"net/url"
@@ -33,6 +35,9 @@ const (
 	GrantTypeRefreshToken      = "refresh_token"
 	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
 	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	GrantTypeJWTBearer         = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	GrantTypeCIBA              = "urn:openid:params:grant-type:ciba"
 
 	AccessTokenDuration  = time.Duration(model.DefaultAccessTokenDurationMinutes) * time.Minute
 	RefreshTokenDuration = time.Duration(model.DefaultRefreshTokenDurationMinutes) * time.Minute
@@ -43,6 +48,8 @@ type OidcService struct {
 	jwtService        *JwtService
 	previewBuilder    oidcClientPreviewBuilder
 	metadataRefresher metadataRefresher
+	logoutNotifier    logoutNotifier
+	scopeConsents     scopeConsentManager
 	scimSyncScheduler ScimSyncScheduler
 
 	httpClient  *http.Client
@@ -57,11 +64,22 @@ type metadataRefresher interface {
 	RefreshClientMetadata(ctx context.Context, clientID string) (model.OidcClient, error)
 }
 
+type logoutNotifier interface {
+	NotifyClientLogout(ctx context.Context, userID string, clientID string) error
+}
+
+type scopeConsentManager interface {
+	AuthorizedScopes(ctx context.Context, userID, clientID string) ([]dto.AuthorizedOidcClientScopeDto, error)
+	RevokeAuthorizedScope(ctx context.Context, userID, clientID, key string) error
+}
+
 func NewOidcService(
 	db *gorm.DB,
 	jwtService *JwtService,
 	previewBuilder oidcClientPreviewBuilder,
 	metadataRefresher metadataRefresher,
+	logoutNotifier logoutNotifier,
+	scopeConsents scopeConsentManager,
 	scimSyncScheduler ScimSyncScheduler,
 	httpClient *http.Client,
 	fileStorage storage.FileStorage,
@@ -71,6 +89,8 @@ func NewOidcService(
 		jwtService:        jwtService,
 		previewBuilder:    previewBuilder,
 		metadataRefresher: metadataRefresher,
+		logoutNotifier:    logoutNotifier,
+		scopeConsents:     scopeConsents,
 		scimSyncScheduler: scimSyncScheduler,
 		httpClient:        httpClient,
 		fileStorage:       fileStorage,
@@ -152,9 +172,61 @@ func (s *OidcService) CreateClient(ctx context.Context, input dto.OidcClientCrea
 		},
 		CreatedByID: new(userID),
 	}
+	return s.createClientInternal(ctx, client, input)
+}
+
+// CreateDynamicClient creates a client that registered itself with dynamic client registration
+// The client is limited to the grant types it declared, and can only be used by the members of the given user groups
+// If only downloading its logo fails, the stored client is returned along with the error, so the registration can delete it again
+func (s *OidcService) CreateDynamicClient(ctx context.Context, input dto.OidcClientCreateDto, grantTypes []string, userGroupIDs []string, createdByID *string) (model.OidcClient, error) {
+	var userGroups []model.UserGroup
+	if len(userGroupIDs) > 0 {
+		err := s.db.
+			WithContext(ctx).
+			Where("id IN ?", userGroupIDs).
+			Find(&userGroups).
+			Error
+		if err != nil {
+			return model.OidcClient{}, err
+		}
+	}
+
+	client := model.OidcClient{
+		Base: model.Base{
+			ID: input.ID,
+		},
+		ClientType:         model.OidcClientTypeDynamic,
+		MetadataGrantTypes: grantTypes,
+		AllowedUserGroups:  userGroups,
+		CreatedByID:        createdByID,
+	}
+	return s.createClientInternal(ctx, client, input)
+}
+
+func (s *OidcService) createClientInternal(ctx context.Context, client model.OidcClient, input dto.OidcClientCreateDto) (model.OidcClient, error) {
+	err := validateResponseEncryption(&input.OidcClientUpdateDto)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = validateClaimPolicy(&input.ClaimPolicy)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = s.validateSigningAlgs(&input.OidcClientUpdateDto)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = s.validateSectorIdentifier(ctx, &input.OidcClientUpdateDto)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = s.validateJWTBearerGrantUsers(ctx, &input.OidcClientUpdateDto)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
 	updateOIDCClientModelFromDto(&client, &input.OidcClientUpdateDto)
 
-	err := s.db.
+	err = s.db.
 		WithContext(ctx).
 		Create(&client).
 		Error
@@ -169,14 +241,14 @@ func (s *OidcService) CreateClient(ctx context.Context, input dto.OidcClientCrea
 	if input.LogoURL != nil {
 		err = s.downloadAndSaveLogoFromURL(ctx, client.ID, *input.LogoURL, true)
 		if err != nil {
-			return model.OidcClient{}, fmt.Errorf("failed to download logo: %w", err)
+			return client, fmt.Errorf("failed to download logo: %w", err)
 		}
 	}
 
 	if input.DarkLogoURL != nil {
 		err = s.downloadAndSaveLogoFromURL(ctx, client.ID, *input.DarkLogoURL, false)
 		if err != nil {
-			return model.OidcClient{}, fmt.Errorf("failed to download dark logo: %w", err)
+			return client, fmt.Errorf("failed to download dark logo: %w", err)
 		}
 	}
 
@@ -184,6 +256,44 @@ func (s *OidcService) CreateClient(ctx context.Context, input dto.OidcClientCrea
 }
 
 func (s *OidcService) UpdateClient(ctx context.Context, clientID string, input dto.OidcClientUpdateDto) (model.OidcClient, error) {
+	return s.updateClientInternal(ctx, clientID, input, nil)
+}
+
+// UpdateDynamicClient updates the registration of a dynamically registered client, including the grant types it declared
+func (s *OidcService) UpdateDynamicClient(ctx context.Context, clientID string, input dto.OidcClientUpdateDto, grantTypes []string) (model.OidcClient, error) {
+	return s.updateClientInternal(ctx, clientID, input, func(client *model.OidcClient) error {
+		if !client.IsDynamicallyRegistered() {
+			return apperror.NotFound("OIDC client")
+		}
+		client.MetadataGrantTypes = grantTypes
+		return nil
+	})
+}
+
+// updateClientInternal applies the update to the client; the optional modify function can change it further, or reject the update, in the same transaction
+func (s *OidcService) updateClientInternal(ctx context.Context, clientID string, input dto.OidcClientUpdateDto, modify func(client *model.OidcClient) error) (model.OidcClient, error) {
+	err := validateResponseEncryption(&input)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = validateClaimPolicy(&input.ClaimPolicy)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = s.validateSigningAlgs(&input)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	// The sector identifier document is fetched before the transaction is started
+	err = s.validateSectorIdentifier(ctx, &input)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = s.validateJWTBearerGrantUsers(ctx, &input)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
@@ -195,6 +305,12 @@ func (s *OidcService) UpdateClient(ctx context.Context, clientID string, input d
 	}
 
 	updateOIDCClientModelFromDto(&client, &input)
+	if modify != nil {
+		err = modify(&client)
+		if err != nil {
+			return model.OidcClient{}, err
+		}
+	}
 
 	if !input.IsGroupRestricted {
 		// Clear allowed user groups if the restriction is removed
@@ -211,12 +327,20 @@ func (s *OidcService) UpdateClient(ctx context.Context, clientID string, input d
 			Select(
 				"Description",
 				"RequiresReauthentication",
+				"MinimumACR",
 				"RequiresPushedAuthorizationRequests",
+				"RequiresDpop",
+				"RequiresSignedRequestObject",
 				"SkipConsent",
 				"LaunchURL",
 				"IsGroupRestricted",
 				"AccessTokenDurationMinutes",
 				"RefreshTokenDurationMinutes",
+				"RefreshTokenRequiresOfflineAccess",
+				"RefreshTokenMaxLifetimeMinutes",
+				"RefreshTokenReuseGraceSeconds",
+				"ConsentLifetimeMinutes",
+				"ClaimPolicy",
 			).
 			Updates(&client).Error
 	} else {
@@ -253,7 +377,10 @@ func updateOIDCClientModelFromDto(client *model.OidcClient, input *dto.OidcClien
 	// Update fields that remain locally managed for every client type
 	client.Description = input.Description
 	client.RequiresReauthentication = input.RequiresReauthentication
+	client.MinimumACR = input.MinimumACR
 	client.RequiresPushedAuthorizationRequests = input.RequiresPushedAuthorizationRequests
+	client.RequiresDpop = input.RequiresDpop
+	client.RequiresSignedRequestObject = input.RequiresSignedRequestObject
 	client.SkipConsent = input.SkipConsent
 	client.LaunchURL = input.LaunchURL
 	client.IsGroupRestricted = input.IsGroupRestricted
@@ -262,6 +389,15 @@ func updateOIDCClientModelFromDto(client *model.OidcClient, input *dto.OidcClien
 	client.AccessTokenDurationMinutes = cmp.Or(input.AccessTokenDurationMinutes, model.DefaultAccessTokenDurationMinutes)
 	client.RefreshTokenDurationMinutes = cmp.Or(input.RefreshTokenDurationMinutes, model.DefaultRefreshTokenDurationMinutes)
 
+	// The refresh token policy is locally managed as well, and zero means the family has no absolute lifetime and rotated tokens no grace window
+	client.RefreshTokenRequiresOfflineAccess = input.RefreshTokenRequiresOfflineAccess
+	client.RefreshTokenMaxLifetimeMinutes = input.RefreshTokenMaxLifetimeMinutes
+	client.RefreshTokenReuseGraceSeconds = input.RefreshTokenReuseGraceSeconds
+	// The consent lifetime is locally managed too, and zero means the user is only asked again when the client requests more
+	client.ConsentLifetimeMinutes = input.ConsentLifetimeMinutes
+	// So is the claim policy, as the admin decides which user claims a client gets wherever its registration comes from
+	client.ClaimPolicy = claimPolicyFromDto(input.ClaimPolicy)
+
 	// Preserve fields that are sourced from the client metadata document
 	if client.IsMetadataDocument() {
 		return
@@ -291,6 +427,304 @@ func updateOIDCClientModelFromDto(client *model.OidcClient, input *dto.OidcClien
 		}
 	}
 
+	client.Credentials.TLSClientAuth = nil
+	if tlsAuth := input.Credentials.TLSClientAuth; tlsAuth != nil {
+		client.Credentials.TLSClientAuth = &model.OidcClientTLSClientAuth{
+			Method:         model.OidcClientTLSClientAuthMethod(tlsAuth.Method),
+			CACertificates: tlsAuth.CACertificates,
+			SubjectDN:      tlsAuth.SubjectDN,
+			SANDNS:         tlsAuth.SANDNS,
+			SANURI:         tlsAuth.SANURI,
+			SANIP:          tlsAuth.SANIP,
+			SANEmail:       tlsAuth.SANEmail,
+			Certificates:   tlsAuth.Certificates,
+		}
+	}
+
+	client.Credentials.JWKS = input.Credentials.JWKS
+	client.Credentials.JWKSURI = input.Credentials.JWKSURI
+
+	client.Credentials.JWTBearerGrants = make([]model.OidcClientJWTBearerGrant, len(input.Credentials.JWTBearerGrants))
+	for i, grant := range input.Credentials.JWTBearerGrants {
+		client.Credentials.JWTBearerGrants[i] = model.OidcClientJWTBearerGrant{
+			Issuer:           grant.Issuer,
+			Subject:          grant.Subject,
+			Audience:         grant.Audience,
+			JWKS:             grant.JWKS,
+			UserID:           grant.UserID,
+			ReplayProtection: grant.ReplayProtection,
+		}
+	}
+
+	client.BackchannelLogoutURI = input.BackchannelLogoutURI
+	client.BackchannelLogoutSessionRequired = input.BackchannelLogoutSessionRequired
+	client.FrontchannelLogoutURI = input.FrontchannelLogoutURI
+	client.FrontchannelLogoutSessionRequired = input.FrontchannelLogoutSessionRequired
+	client.AuthorizationSignedResponseAlg = input.AuthorizationSignedResponseAlg
+	client.UserinfoSignedResponseAlg = input.UserinfoSignedResponseAlg
+	client.IDTokenSignedResponseAlg = input.IDTokenSignedResponseAlg
+	client.BackchannelTokenDeliveryMode = model.OidcClientBackchannelTokenDeliveryMode(input.BackchannelTokenDeliveryMode)
+	client.BackchannelClientNotificationEndpoint = nil
+	// The notification endpoint is only called in ping mode
+	if client.BackchannelTokenDeliveryMode == model.OidcClientBackchannelTokenDeliveryModePing {
+		client.BackchannelClientNotificationEndpoint = input.BackchannelClientNotificationEndpoint
+	}
+	client.SubjectType = model.OidcClientSubjectType(cmp.Or(input.SubjectType, string(model.OidcClientSubjectTypePublic)))
+	client.SectorIdentifierURI = nil
+	// The sector identifier URI only matters for pairwise subjects
+	if client.SubjectType == model.OidcClientSubjectTypePairwise {
+		client.SectorIdentifierURI = input.SectorIdentifierURI
+	}
+
+	client.IDTokenEncryptedResponseAlg, client.IDTokenEncryptedResponseEnc = encryptedResponseSettings(input.IDTokenEncryptedResponseAlg, input.IDTokenEncryptedResponseEnc)
+	client.UserinfoEncryptedResponseAlg, client.UserinfoEncryptedResponseEnc = encryptedResponseSettings(input.UserinfoEncryptedResponseAlg, input.UserinfoEncryptedResponseEnc)
+}
+
+// claimPolicyFromDto returns the claim policy to store, dropping the custom claim keys if they don't filter anything
+func claimPolicyFromDto(input dto.OidcClientClaimPolicyDto) model.OidcClientClaimPolicy {
+	policy := model.OidcClientClaimPolicy{
+		CustomClaimsFilter: model.OidcClientClaimFilter(input.CustomClaimsFilter),
+		Mappings:           make([]model.OidcClientClaimMapping, len(input.Mappings)),
+		StaticClaims:       make([]model.OidcClientStaticClaim, len(input.StaticClaims)),
+	}
+	if policy.CustomClaimsFilter != "" {
+		policy.CustomClaimKeys = input.CustomClaimKeys
+	}
+	for i, mapping := range input.Mappings {
+		policy.Mappings[i] = model.OidcClientClaimMapping{
+			Claim:        mapping.Claim,
+			Name:         mapping.Name,
+			Destinations: model.ClaimDestinations(mapping.Destinations),
+		}
+	}
+	for i, staticClaim := range input.StaticClaims {
+		policy.StaticClaims[i] = model.OidcClientStaticClaim{
+			Key:          staticClaim.Key,
+			Value:        staticClaim.Value,
+			Destinations: model.ClaimDestinations(staticClaim.Destinations),
+		}
+	}
+	return policy
+}
+
+// validateClaimPolicy checks that every claim a client's claim policy releases has a name of its own, which isn't one Pocket ID sets on the tokens
+func validateClaimPolicy(input *dto.OidcClientClaimPolicyDto) error {
+	released := make(map[string]struct{}, len(input.Mappings)+len(input.StaticClaims))
+	release := func(name string) error {
+		if isProtocolClaim(name) {
+			return apperror.ValidationMessage(fmt.Sprintf("The claim %s is set by Pocket ID and can't be released by the claim policy", name))
+		}
+		if _, ok := released[name]; ok {
+			return apperror.ValidationMessage(fmt.Sprintf("The claim policy releases more than one claim as %s", name))
+		}
+		released[name] = struct{}{}
+		return nil
+	}
+
+	mapped := make(map[string]struct{}, len(input.Mappings))
+	for _, mapping := range input.Mappings {
+		if _, ok := mapped[mapping.Claim]; ok {
+			return apperror.ValidationMessage(fmt.Sprintf("The claim %s is mapped more than once", mapping.Claim))
+		}
+		mapped[mapping.Claim] = struct{}{}
+
+		if mapping.Claim == "sub" {
+			return apperror.ValidationMessage("The sub claim identifies the user to the client and can't be mapped")
+		}
+		// A claim renamed to the name of a standard user claim would hide it
+		if mapping.Name != "" && mapping.Name != mapping.Claim && isReservedClaim(mapping.Name) {
+			return apperror.ValidationMessage(fmt.Sprintf("The claim %s can't be renamed to %s, as that is the name of another claim", mapping.Claim, mapping.Name))
+		}
+		if err := release(cmp.Or(mapping.Name, mapping.Claim)); err != nil {
+			return err
+		}
+	}
+	for _, staticClaim := range input.StaticClaims {
+		if isReservedClaim(staticClaim.Key) {
+			return apperror.ValidationMessage(fmt.Sprintf("The static claim %s would hide the claim of the same name", staticClaim.Key))
+		}
+		if err := release(staticClaim.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isProtocolClaim reports whether Pocket ID sets the claim on the tokens themselves, so no user or static claim can be released under its name
+func isProtocolClaim(key string) bool {
+	switch key {
+	case TokenTypeClaim,
+		"sub",
+		"iss",
+		"aud",
+		"exp",
+		"iat",
+		"nbf",
+		"jti",
+		"auth_time",
+		"nonce",
+		"acr",
+		"amr",
+		"azp",
+		"sid",
+		"at_hash",
+		"c_hash",
+		"client_id",
+		"scope",
+		"cnf",
+		"act",
+		"authorization_details":
+		return true
+	default:
+		return false
+	}
+}
+
+// encryptedResponseSettings returns the algorithm and content encryption to store; the content encryption is only kept if the algorithm is set
+func encryptedResponseSettings(alg, enc string) (string, string) {
+	if alg == "" {
+		return "", ""
+	}
+	return alg, cmp.Or(enc, model.DefaultEncryptedResponseEnc)
+}
+
+// validateResponseEncryption checks that a client that wants encrypted responses has keys to encrypt them with
+func validateResponseEncryption(input *dto.OidcClientUpdateDto) error {
+	if input.IDTokenEncryptedResponseAlg == "" && input.UserinfoEncryptedResponseAlg == "" {
+		return nil
+	}
+	if input.Credentials.JWKS == "" && input.Credentials.JWKSURI == "" {
+		return apperror.ValidationMessage("A JWKS or JWKS URI is required to encrypt the ID tokens or userinfo responses of the client")
+	}
+	return nil
+}
+
+// validateSigningAlgs checks that the algorithms a client wants its responses signed with can be used with one of the active signing keys
+func (s *OidcService) validateSigningAlgs(input *dto.OidcClientUpdateDto) error {
+	algs := []struct {
+		name      string
+		value     string
+		supported func(oidc.TokenSigner) []string
+	}{
+		{name: "ID tokens", value: input.IDTokenSignedResponseAlg, supported: oidc.IDTokenSigningAlgValuesSupported},
+		{name: "authorization responses", value: input.AuthorizationSignedResponseAlg, supported: oidc.AuthorizationSigningAlgValuesSupported},
+		{name: "userinfo responses", value: input.UserinfoSignedResponseAlg, supported: oidc.UserinfoSigningAlgValuesSupported},
+	}
+	for _, alg := range algs {
+		if alg.value == "" {
+			continue
+		}
+		if s.jwtService == nil || !slices.Contains(alg.supported(s.jwtService), alg.value) {
+			return apperror.ValidationMessage(fmt.Sprintf("None of the signing keys can sign %s with %s", alg.name, alg.value))
+		}
+	}
+	return nil
+}
+
+// validateJWTBearerGrantUsers checks that the users the JWT bearer grants of a client act for exist
+func (s *OidcService) validateJWTBearerGrantUsers(ctx context.Context, input *dto.OidcClientUpdateDto) error {
+	userIDs := make([]string, 0, len(input.Credentials.JWTBearerGrants))
+	for _, grant := range input.Credentials.JWTBearerGrants {
+		if grant.UserID != "" && !slices.Contains(userIDs, grant.UserID) {
+			userIDs = append(userIDs, grant.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	var count int64
+	err := s.db.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where("id IN ?", userIDs).
+		Count(&count).
+		Error
+	if err != nil {
+		return err
+	}
+	if count != int64(len(userIDs)) {
+		return apperror.ValidationMessage("The user of a JWT bearer grant doesn't exist")
+	}
+	return nil
+}
+
+// validateSectorIdentifier checks that the pairwise subjects of a client can be computed, as defined by OpenID Connect Core section 8.1
+// The callback URLs must either all be on the same host, or all be listed in the document at the sector identifier URI
+func (s *OidcService) validateSectorIdentifier(ctx context.Context, input *dto.OidcClientUpdateDto) error {
+	if input.SubjectType != string(model.OidcClientSubjectTypePairwise) {
+		return nil
+	}
+
+	if input.SectorIdentifierURI == nil || *input.SectorIdentifierURI == "" {
+		hosts := make(map[string]struct{}, len(input.CallbackURLs))
+		for _, callbackURL := range input.CallbackURLs {
+			u, err := url.Parse(callbackURL)
+			if err == nil {
+				hosts[u.Hostname()] = struct{}{}
+			}
+		}
+		if len(hosts) > 1 {
+			return apperror.ValidationMessage("A sector identifier URI is required for pairwise subjects when the callback URLs are on more than one host")
+		}
+		return nil
+	}
+
+	redirectURIs, err := s.fetchSectorIdentifierDocument(ctx, *input.SectorIdentifierURI)
+	if err != nil {
+		return err
+	}
+	for _, callbackURL := range input.CallbackURLs {
+		if !slices.Contains(redirectURIs, callbackURL) {
+			return apperror.ValidationMessage(fmt.Sprintf("The callback URL %s is not listed in the sector identifier document", callbackURL))
+		}
+	}
+	return nil
+}
+
+// fetchSectorIdentifierDocument downloads the JSON array of redirect URIs published at a sector identifier URI
+func (s *OidcService) fetchSectorIdentifierDocument(parentCtx context.Context, raw string) ([]string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, apperror.ValidationMessage("The sector identifier URI must be an HTTPS URL")
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, 15*time.Second)
+	defer cancel()
+
+	// Prevents SSRF by allowing only public IPs, which is checked when connecting so the address can't change after the check
+	// Following redirects isn't allowed either, so the document must be served at the URI itself
+	client := httpClientWithCheckRedirect(utils.PublicOnlyHTTPClient(s.httpClient), func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, apperror.ValidationMessage("The sector identifier URI is invalid")
+	}
+	req.Header.Set("User-Agent", "pocket-id/oidc-sector-identifier-fetcher")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if errors.Is(err, utils.ErrPrivateURL) {
+		return nil, apperror.ValidationMessage("The sector identifier URI must not point to a private IP address")
+	} else if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch the sector identifier document", slog.String("url", raw), slog.Any("error", err))
+		return nil, apperror.ValidationMessage("The sector identifier document could not be fetched")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apperror.ValidationMessage(fmt.Sprintf("The sector identifier document could not be fetched: the server returned %s", resp.Status))
+	}
+
+	const maxSectorIdentifierDocumentSize = 64 << 10
+	var redirectURIs []string
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxSectorIdentifierDocumentSize)).Decode(&redirectURIs)
+	if err != nil {
+		return nil, apperror.ValidationMessage("The sector identifier document must be a JSON array of redirect URIs")
+	}
+	return redirectURIs, nil
 }
 
 func (s *OidcService) DeleteClient(ctx context.Context, clientID string) error {
@@ -718,9 +1152,30 @@ func (s *OidcService) RevokeAuthorizedClient(ctx context.Context, userID string,
 		return err
 	}
 
+	// Let the client know the user's sessions have ended, so it can sign them out too
+	if s.logoutNotifier != nil {
+		err = s.logoutNotifier.NotifyClientLogout(ctx, userID, clientID)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to send backchannel logout notification", slog.String("client_id", clientID), slog.Any("error", err))
+		}
+	}
+
 	return nil
 }
 
+// ListAuthorizedClientScopes returns the user's decisions on every scope, API and claim they were asked to consent to for the client
+func (s *OidcService) ListAuthorizedClientScopes(ctx context.Context, userID string, clientID string) ([]dto.AuthorizedOidcClientScopeDto, error) {
+	return s.scopeConsents.AuthorizedScopes(ctx, userID, clientID)
+}
+
+// RevokeAuthorizedClientScope revokes the user's consent to a single optional scope of the client, without revoking the whole authorization
+func (s *OidcService) RevokeAuthorizedClientScope(ctx context.Context, userID string, clientID string, scope string) error {
+	if scope == "" {
+		return apperror.MissingField("scope")
+	}
+	return s.scopeConsents.RevokeAuthorizedScope(ctx, userID, clientID, scope)
+}
+
 func (s *OidcService) ListAccessibleOidcClients(ctx context.Context, userID string, listRequestOptions utils.ListRequestOptions) ([]dto.AccessibleOidcClientDto, utils.PaginationResponse, error) {
 	tx := s.db.Begin()
 	defer func() {
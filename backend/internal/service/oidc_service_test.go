@@ -521,7 +521,7 @@ func TestOidcService_downloadAndSaveLogoFromURL(t *testing.T) {
 func TestOidcService_CreateClient_withDescription(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	description := "A test client description"
@@ -546,7 +546,7 @@ func TestOidcService_CreateClient_withDescription(t *testing.T) {
 func TestOidcService_CreateClient_withoutDescription(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	input := dto.OidcClientCreateDto{
@@ -595,7 +595,7 @@ func TestOidcService_CreateClient_tokenLifetimes(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			db := testutils.NewDatabaseForTest(t)
 
-			s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+			s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 			require.NoError(t, err)
 
 			input := dto.OidcClientCreateDto{
@@ -622,7 +622,7 @@ func TestOidcService_CreateClient_tokenLifetimes(t *testing.T) {
 func TestOidcService_UpdateClient_tokenLifetimes(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{
@@ -656,10 +656,173 @@ func TestOidcService_UpdateClient_tokenLifetimes(t *testing.T) {
 	assert.Equal(t, model.DefaultRefreshTokenDurationMinutes, fetched.RefreshTokenDurationMinutes)
 }
 
+func TestOidcService_CreateClient_claimPolicy(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	input := dto.OidcClientCreateDto{
+		OidcClientUpdateDto: dto.OidcClientUpdateDto{
+			Name:         "Test Client",
+			CallbackURLs: []string{"https://example.com/callback"},
+			ClaimPolicy: dto.OidcClientClaimPolicyDto{
+				CustomClaimsFilter: "allow",
+				CustomClaimKeys:    []string{"department"},
+				Mappings: []dto.OidcClientClaimMappingDto{
+					{Claim: "department", Name: "ou", Destinations: dto.OidcClientClaimDestinationsDto{IDToken: true, AccessToken: true}},
+				},
+				StaticClaims: []dto.OidcClientStaticClaimDto{
+					{Key: "tenant", Value: "acme", Destinations: dto.OidcClientClaimDestinationsDto{UserInfo: true}},
+				},
+			},
+		},
+	}
+
+	client, err := s.CreateClient(t.Context(), input, "user-id")
+	require.NoError(t, err)
+
+	var fetched model.OidcClient
+	require.NoError(t, db.First(&fetched, "id = ?", client.ID).Error)
+	assert.Equal(t, model.OidcClientClaimPolicy{
+		CustomClaimsFilter: model.OidcClientClaimFilterAllow,
+		CustomClaimKeys:    []string{"department"},
+		Mappings: []model.OidcClientClaimMapping{
+			{Claim: "department", Name: "ou", Destinations: model.ClaimDestinations{IDToken: true, AccessToken: true}},
+		},
+		StaticClaims: []model.OidcClientStaticClaim{
+			{Key: "tenant", Value: "acme", Destinations: model.ClaimDestinations{UserInfo: true}},
+		},
+	}, fetched.ClaimPolicy)
+}
+
+func TestOidcService_CreateClient_jwtBearerGrantUsers(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	user := model.User{Username: "jwt-bearer-user"}
+	require.NoError(t, db.Create(&user).Error)
+
+	newInput := func(userID string) dto.OidcClientUpdateDto {
+		return dto.OidcClientUpdateDto{
+			Name:         "Test Client",
+			CallbackURLs: []string{"https://example.com/callback"},
+			Credentials: dto.OidcClientCredentialsDto{
+				JWTBearerGrants: []dto.OidcClientJWTBearerGrantDto{
+					{Issuer: "https://issuer.example.com", Subject: "repo:pocket-id/*", UserID: userID},
+				},
+			},
+		}
+	}
+
+	_, err = s.CreateClient(t.Context(), dto.OidcClientCreateDto{OidcClientUpdateDto: newInput("missing-user")}, "user-id")
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{OidcClientUpdateDto: newInput(user.ID)}, "user-id")
+	require.NoError(t, err)
+
+	_, err = s.UpdateClient(t.Context(), client.ID, newInput("missing-user"))
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+}
+
+func TestOidcService_CreateClient_signingAlgs(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	jwtService := initJwtService(t, db, newInstanceID(t, db), nil, newTestEnvConfig())
+
+	s, err := NewOidcService(db, jwtService, nil, nil, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	newInput := func(alg string) dto.OidcClientCreateDto {
+		return dto.OidcClientCreateDto{
+			OidcClientUpdateDto: dto.OidcClientUpdateDto{
+				Name:                      "Test Client",
+				CallbackURLs:              []string{"https://example.com/callback"},
+				IDTokenSignedResponseAlg:  alg,
+				UserinfoSignedResponseAlg: alg,
+			},
+		}
+	}
+
+	// The generated signing key is an RSA key, which can't sign with an ECDSA algorithm
+	_, err = s.CreateClient(t.Context(), newInput("ES256"), "user-id")
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+
+	client, err := s.CreateClient(t.Context(), newInput("PS256"), "user-id")
+	require.NoError(t, err)
+
+	_, err = s.UpdateClient(t.Context(), client.ID, newInput("EdDSA").OidcClientUpdateDto)
+	require.True(t, apperror.IsCode(err, apperror.CodeValidationFailed))
+}
+
+func TestValidateClaimPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		policy  dto.OidcClientClaimPolicyDto
+		wantErr bool
+	}{
+		{
+			name: "renames and static claims with names of their own",
+			policy: dto.OidcClientClaimPolicyDto{
+				Mappings:     []dto.OidcClientClaimMappingDto{{Claim: "department", Name: "ou"}, {Claim: "groups"}},
+				StaticClaims: []dto.OidcClientStaticClaimDto{{Key: "tenant", Value: "acme"}},
+			},
+		},
+		{
+			name:    "mapping the subject",
+			policy:  dto.OidcClientClaimPolicyDto{Mappings: []dto.OidcClientClaimMappingDto{{Claim: "sub", Name: "user_id"}}},
+			wantErr: true,
+		},
+		{
+			name:    "renaming to a protocol claim",
+			policy:  dto.OidcClientClaimPolicyDto{Mappings: []dto.OidcClientClaimMappingDto{{Claim: "department", Name: "aud"}}},
+			wantErr: true,
+		},
+		{
+			name:    "renaming to a standard user claim",
+			policy:  dto.OidcClientClaimPolicyDto{Mappings: []dto.OidcClientClaimMappingDto{{Claim: "upn", Name: "email"}}},
+			wantErr: true,
+		},
+		{
+			name:    "mapping a claim twice",
+			policy:  dto.OidcClientClaimPolicyDto{Mappings: []dto.OidcClientClaimMappingDto{{Claim: "department"}, {Claim: "department", Name: "ou"}}},
+			wantErr: true,
+		},
+		{
+			name:    "renaming two claims to the same name",
+			policy:  dto.OidcClientClaimPolicyDto{Mappings: []dto.OidcClientClaimMappingDto{{Claim: "department", Name: "ou"}, {Claim: "team", Name: "ou"}}},
+			wantErr: true,
+		},
+		{
+			name:    "static claim hiding a user claim",
+			policy:  dto.OidcClientClaimPolicyDto{StaticClaims: []dto.OidcClientStaticClaimDto{{Key: "groups", Value: "[]"}}},
+			wantErr: true,
+		},
+		{
+			name: "static claim with the name of a renamed claim",
+			policy: dto.OidcClientClaimPolicyDto{
+				Mappings:     []dto.OidcClientClaimMappingDto{{Claim: "department", Name: "ou"}},
+				StaticClaims: []dto.OidcClientStaticClaimDto{{Key: "ou", Value: "sales"}},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateClaimPolicy(&test.policy)
+			if test.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestOidcService_CreateClientSecret_withCustomSecret(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{Name: "Test Client"}
@@ -688,7 +851,7 @@ func TestOidcService_CreateClientSecret_withCustomSecret(t *testing.T) {
 func TestOidcService_CreateClientSecret_multipleSecrets(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{Name: "Test Client"}
@@ -726,7 +889,7 @@ func TestOidcService_CreateClientSecret_multipleSecrets(t *testing.T) {
 func TestOidcService_CreateClientSecret_expirationInThePast(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{Name: "Test Client"}
@@ -741,7 +904,7 @@ func TestOidcService_CreateClientSecret_expirationInThePast(t *testing.T) {
 func TestOidcService_CreateClientSecret_limit(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{Name: "Test Client"}
@@ -760,7 +923,7 @@ func TestOidcService_CreateClientSecret_limit(t *testing.T) {
 func TestOidcService_CreateClientSecret_preservesFederatedIdentities(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{
@@ -796,7 +959,7 @@ func TestOidcService_CreateClientSecret_preservesFederatedIdentities(t *testing.
 func TestOidcService_UpdateClient_description(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	// Create a client without a description
@@ -837,7 +1000,7 @@ func TestOidcService_UpdateClient_description(t *testing.T) {
 func TestOidcService_UpdateClient_CIMDPreservesMetadataFields(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{
@@ -906,7 +1069,7 @@ func TestOidcService_UpdateClient_CIMDPreservesMetadataFields(t *testing.T) {
 func TestOidcService_UpdateClient_CIMDDoesNotOverwriteConcurrentMetadataRefresh(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	client := model.OidcClient{
@@ -939,7 +1102,7 @@ func TestOidcService_UpdateClient_CIMDDoesNotOverwriteConcurrentMetadataRefresh(
 
 func TestOidcService_ListAccessibleOidcClients_requiresExplicitGroupPermission(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	allowedGroup := model.UserGroup{Name: "allowed", FriendlyName: "Allowed"}
@@ -973,7 +1136,7 @@ func TestOidcService_ListAccessibleOidcClients_requiresExplicitGroupPermission(t
 
 func TestOidcService_ListClientViewsFilterByLaunchURLPresence(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
-	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil)
+	s, err := NewOidcService(db, nil, nil, nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	user := model.User{Username: "launch-url-filter"}
@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCertificatePEM(t *testing.T, commonName string) (string, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), der
+}
+
+func TestParseCertificatesPEM(t *testing.T) {
+	first, _ := newTestCertificatePEM(t, "first")
+	second, _ := newTestCertificatePEM(t, "second")
+
+	certs, err := ParseCertificatesPEM(first + "\n" + second)
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+	assert.Equal(t, "first", certs[0].Subject.CommonName)
+	assert.Equal(t, "second", certs[1].Subject.CommonName)
+
+	_, err = ParseCertificatesPEM("")
+	require.Error(t, err)
+
+	_, err = ParseCertificatesPEM("not a certificate")
+	require.Error(t, err)
+
+	_, err = ParseCertificatesPEM(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")})))
+	require.Error(t, err)
+}
+
+func TestCertificateThumbprint(t *testing.T) {
+	certPEM, der := newTestCertificatePEM(t, "client")
+	certs, err := ParseCertificatesPEM(certPEM)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(der)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), CertificateThumbprint(certs[0]))
+}
@@ -27,15 +27,20 @@ func SetCSPNonce(c *gin.Context, nonce string) {
 }
 
 func BuildCSP(nonce string) string {
-	return buildCSP(nonce, nil, nil)
+	return buildCSP(nonce, nil, nil, nil)
 }
 
 // BuildFormPostCSP builds the Content-Security-Policy for an OIDC response_mode=form_post page
 func BuildFormPostCSP(nonce, redirectURI, scriptHash string) string {
-	return buildCSP(nonce, []string{redirectURI}, []string{scriptHash})
+	return buildCSP(nonce, []string{redirectURI}, []string{scriptHash}, nil)
 }
 
-func buildCSP(nonce string, formActionExtra, scriptSrcExtra []string) string {
+// BuildFrontchannelLogoutCSP builds the Content-Security-Policy for the OIDC front-channel logout page, which embeds the clients' logout URIs in iframes
+func BuildFrontchannelLogoutCSP(nonce string, frameSources []string, scriptHash string) string {
+	return buildCSP(nonce, nil, []string{scriptHash}, frameSources)
+}
+
+func buildCSP(nonce string, formActionExtra, scriptSrcExtra, frameSrcExtra []string) string {
 	formAction := "'self'"
 	scriptSrc := "script-src 'self'"
 	if nonce != "" {
@@ -61,11 +66,23 @@ func buildCSP(nonce string, formActionExtra, scriptSrcExtra []string) string {
 		formAction += b.String()
 	}
 
+	frameSrc := ""
+	if len(frameSrcExtra) > 0 {
+		frameSrc = "frame-src 'self'"
+		for _, extra := range frameSrcExtra {
+			if extra != "" {
+				frameSrc += " " + extra
+			}
+		}
+		frameSrc += "; "
+	}
+
 	return "default-src 'self'; " +
 		"base-uri 'self'; " +
 		"object-src 'none'; " +
 		"frame-ancestors 'none'; " +
 		"form-action " + formAction + "; " +
+		frameSrc +
 		"img-src * blob:;" +
 		"font-src 'self'; " +
 		"style-src 'self' 'unsafe-inline'; " +
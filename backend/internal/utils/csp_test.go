@@ -28,3 +28,14 @@ func TestBuildFormPostCSP(t *testing.T) {
 	assert.True(t, found, "csp must contain a script-src directive")
 	assert.NotContains(t, scriptSrc, "unsafe-inline")
 }
+
+func TestBuildFrontchannelLogoutCSP(t *testing.T) {
+	csp := BuildFrontchannelLogoutCSP("test-nonce", []string{"https://a.example.com", "https://b.example.com"}, "'sha256-abc123'")
+
+	// The clients' logout URIs must be allowed as frame sources
+	assert.Contains(t, csp, "frame-src 'self' https://a.example.com https://b.example.com;")
+	assert.Contains(t, csp, "script-src 'self' 'nonce-test-nonce' 'sha256-abc123'")
+
+	// The regular policy must not allow any foreign frames
+	assert.NotContains(t, BuildCSP("test-nonce"), "frame-src")
+}
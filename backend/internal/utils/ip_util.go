@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/http"
 	"net/netip"
 	"net/url"
 	"strings"
@@ -94,6 +95,41 @@ func IsURLPrivate(ctx context.Context, u *url.URL) (bool, error) {
 	return false, nil
 }
 
+// ErrPrivateURL is returned for requests to URLs that resolve to a private IP address
+var ErrPrivateURL = errors.New("private IP addresses are not allowed")
+
+// publicOnlyTransport refuses requests to private IP addresses before handing them to the next transport
+// As every redirect and every refresh is a request of its own, each of them is checked
+type publicOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func (t publicOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	private, err := IsURLPrivate(req.Context(), req.URL)
+	if err != nil {
+		return nil, err
+	} else if private {
+		return nil, ErrPrivateURL
+	}
+	return t.next.RoundTrip(req)
+}
+
+// PublicOnlyHTTPClient returns a copy of the client that only sends requests to public IP addresses
+// It prevents SSRF through URLs that third parties control, such as those of self-registered clients
+func PublicOnlyHTTPClient(source *http.Client) *http.Client {
+	if source == nil {
+		source = http.DefaultClient
+	}
+
+	client := *source
+	next := source.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = publicOnlyTransport{next: next}
+	return &client
+}
+
 func listContainsIP(ipNets []*net.IPNet, ip net.IP) bool {
 	for _, ipNet := range ipNets {
 		if ipNet.Contains(ip) {
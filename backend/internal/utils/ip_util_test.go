@@ -3,6 +3,8 @@ package utils
 import (
 	"context"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -378,3 +380,22 @@ func TestIsURLPrivate_ContextCancellation(t *testing.T) {
 	_, err = IsURLPrivate(ctx, u)
 	assert.Error(t, err, "IsURLPrivate with cancelled context expected error but got none")
 }
+
+func TestPublicOnlyHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	res, err := server.Client().Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	// The test server listens on a loopback address
+	_, err = PublicOnlyHTTPClient(server.Client()).Do(req)
+	require.ErrorIs(t, err, ErrPrivateURL)
+}
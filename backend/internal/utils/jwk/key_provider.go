@@ -20,6 +20,19 @@ type KeyProvider interface {
 	Init(opts KeyProviderOpts) error
 	LoadKey(ctx context.Context) (jwk.Key, error)
 	SaveKey(ctx context.Context, key jwk.Key) error
+	// LoadAdditionalKeys returns the signing keys of other algorithms that are active alongside the key, for clients that need a different algorithm
+	LoadAdditionalKeys(ctx context.Context) ([]jwk.Key, error)
+	SaveAdditionalKeys(ctx context.Context, keys []jwk.Key) error
+	// LoadNextKey returns the key that is published ahead of replacing the key during a rollover, or nil if no rollover is in progress
+	LoadNextKey(ctx context.Context) (jwk.Key, error)
+	SaveNextKey(ctx context.Context, key jwk.Key) error
+	DeleteNextKey(ctx context.Context) error
+	// LoadNextAdditionalKeys returns the keys that replace the additional keys during a rollover, one for each of their algorithms
+	LoadNextAdditionalKeys(ctx context.Context) ([]jwk.Key, error)
+	SaveNextAdditionalKeys(ctx context.Context, keys []jwk.Key) error
+	// LoadRetiredKeys returns the keys replaced by a rollover, which remain published until the tokens they signed have expired
+	LoadRetiredKeys(ctx context.Context) ([]jwk.Key, error)
+	SaveRetiredKeys(ctx context.Context, keys []jwk.Key) error
 }
 
 func GetKeyProvider(db *gorm.DB, envConfig *common.EnvConfigSchema, instanceID string) (keyProvider KeyProvider, err error) {
@@ -3,6 +3,7 @@ package jwk
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -17,6 +18,18 @@ import (
 
 const PrivateKeyDBKey = "jwt_private_key.json"
 
+// AdditionalPrivateKeysDBKey stores the signing keys of other algorithms that are active alongside the private key
+const AdditionalPrivateKeysDBKey = "jwt_additional_private_keys.json"
+
+// NextPrivateKeyDBKey stores the key that replaces the private key at the end of a rollover
+const NextPrivateKeyDBKey = "jwt_next_private_key.json"
+
+// NextAdditionalPrivateKeysDBKey stores the keys that replace the additional private keys at the end of a rollover
+const NextAdditionalPrivateKeysDBKey = "jwt_next_additional_private_keys.json"
+
+// RetiredPrivateKeysDBKey stores the keys replaced by a rollover, until the tokens they signed have expired
+const RetiredPrivateKeysDBKey = "jwt_retired_private_keys.json"
+
 type KeyProviderDatabase struct {
 	db  *gorm.DB
 	kek []byte
@@ -34,13 +47,136 @@ func (f *KeyProviderDatabase) Init(opts KeyProviderOpts) error {
 }
 
 func (f *KeyProviderDatabase) LoadKey(ctx context.Context) (key jwk.Key, err error) {
+	data, err := f.loadEncrypted(ctx, PrivateKeyDBKey)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	// Parse the key
+	key, err = jwk.ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (f *KeyProviderDatabase) SaveKey(ctx context.Context, key jwk.Key) error {
+	// Encode the key to JSON
+	data, err := EncodeJWKBytes(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key to JSON: %w", err)
+	}
+
+	return f.saveEncrypted(ctx, PrivateKeyDBKey, data)
+}
+
+func (f *KeyProviderDatabase) LoadAdditionalKeys(ctx context.Context) ([]jwk.Key, error) {
+	return f.loadKeySet(ctx, AdditionalPrivateKeysDBKey)
+}
+
+func (f *KeyProviderDatabase) SaveAdditionalKeys(ctx context.Context, keys []jwk.Key) error {
+	return f.saveKeySet(ctx, AdditionalPrivateKeysDBKey, keys)
+}
+
+func (f *KeyProviderDatabase) LoadNextKey(ctx context.Context) (jwk.Key, error) {
+	data, err := f.loadEncrypted(ctx, NextPrivateKeyDBKey)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	key, err := jwk.ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (f *KeyProviderDatabase) SaveNextKey(ctx context.Context, key jwk.Key) error {
+	data, err := EncodeJWKBytes(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key to JSON: %w", err)
+	}
+
+	return f.saveEncrypted(ctx, NextPrivateKeyDBKey, data)
+}
+
+func (f *KeyProviderDatabase) DeleteNextKey(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err := f.db.WithContext(ctx).Delete(&model.KV{Key: NextPrivateKeyDBKey}).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete private key from the database: %w", err)
+	}
+
+	return nil
+}
+
+func (f *KeyProviderDatabase) LoadNextAdditionalKeys(ctx context.Context) ([]jwk.Key, error) {
+	return f.loadKeySet(ctx, NextAdditionalPrivateKeysDBKey)
+}
+
+func (f *KeyProviderDatabase) SaveNextAdditionalKeys(ctx context.Context, keys []jwk.Key) error {
+	return f.saveKeySet(ctx, NextAdditionalPrivateKeysDBKey, keys)
+}
+
+func (f *KeyProviderDatabase) LoadRetiredKeys(ctx context.Context) ([]jwk.Key, error) {
+	return f.loadKeySet(ctx, RetiredPrivateKeysDBKey)
+}
+
+func (f *KeyProviderDatabase) SaveRetiredKeys(ctx context.Context, keys []jwk.Key) error {
+	return f.saveKeySet(ctx, RetiredPrivateKeysDBKey, keys)
+}
+
+// loadKeySet loads the keys stored as a JWK set under the key
+func (f *KeyProviderDatabase) loadKeySet(ctx context.Context, dbKey string) ([]jwk.Key, error) {
+	data, err := f.loadEncrypted(ctx, dbKey)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private keys: %w", err)
+	}
+
+	keys := make([]jwk.Key, 0, set.Len())
+	for i := range set.Len() {
+		key, _ := set.Key(i)
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// saveKeySet stores the keys as a JWK set under the key
+func (f *KeyProviderDatabase) saveKeySet(ctx context.Context, dbKey string, keys []jwk.Key) error {
+	set := jwk.NewSet()
+	for _, key := range keys {
+		err := set.AddKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to add key to the set: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("failed to encode keys to JSON: %w", err)
+	}
+
+	return f.saveEncrypted(ctx, dbKey, data)
+}
+
+// loadEncrypted loads and decrypts the value stored under the key, returning nil if there's none
+func (f *KeyProviderDatabase) loadEncrypted(ctx context.Context, dbKey string) ([]byte, error) {
 	row := model.KV{
-		Key: PrivateKeyDBKey,
+		Key: dbKey,
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	err = f.db.WithContext(ctx).First(&row).Error
+	err := f.db.WithContext(ctx).First(&row).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		// Key not present in the database - return nil so a new one can be generated
 		return nil, nil
@@ -65,22 +201,11 @@ func (f *KeyProviderDatabase) LoadKey(ctx context.Context) (key jwk.Key, err err
 		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
 	}
 
-	// Parse the key
-	key, err = jwk.ParseKey(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
-	}
-
-	return key, nil
+	return data, nil
 }
 
-func (f *KeyProviderDatabase) SaveKey(ctx context.Context, key jwk.Key) error {
-	// Encode the key to JSON
-	data, err := EncodeJWKBytes(key)
-	if err != nil {
-		return fmt.Errorf("failed to encode key to JSON: %w", err)
-	}
-
+// saveEncrypted encrypts the data and stores it under the key
+func (f *KeyProviderDatabase) saveEncrypted(ctx context.Context, dbKey string, data []byte) error {
 	// Encrypt the key then encode to Base64
 	enc, err := cryptoutils.Encrypt(f.kek, data, nil)
 	if err != nil {
@@ -88,7 +213,7 @@ func (f *KeyProviderDatabase) SaveKey(ctx context.Context, key jwk.Key) error {
 	}
 	// Save to database
 	row := model.KV{
-		Key:   PrivateKeyDBKey,
+		Key:   dbKey,
 		Value: new(base64.StdEncoding.EncodeToString(enc)),
 	}
 
@@ -259,6 +259,99 @@ func TestKeyProviderDatabase_SaveKey(t *testing.T) {
 	})
 }
 
+func TestKeyProviderDatabase_AdditionalKeys(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	provider := &KeyProviderDatabase{}
+	err := provider.Init(KeyProviderOpts{
+		DB:  db,
+		Kek: generateTestKEK(t),
+	})
+	require.NoError(t, err)
+
+	// Without any stored keys there are no additional keys
+	keys, err := provider.LoadAdditionalKeys(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	ecKey, err := GenerateKey("ES256", "")
+	require.NoError(t, err)
+	edKey, err := GenerateKey("EdDSA", "Ed25519")
+	require.NoError(t, err)
+
+	err = provider.SaveAdditionalKeys(t.Context(), []jwk.Key{ecKey, edKey})
+	require.NoError(t, err)
+
+	keys, err = provider.LoadAdditionalKeys(t.Context())
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	for i, expected := range []jwk.Key{ecKey, edKey} {
+		expectedBytes, err := EncodeJWKBytes(expected)
+		require.NoError(t, err)
+		loadedBytes, err := EncodeJWKBytes(keys[i])
+		require.NoError(t, err)
+		assert.Equal(t, expectedBytes, loadedBytes)
+	}
+
+	// The additional keys are stored apart from the private key
+	key, err := provider.LoadKey(t.Context())
+	require.NoError(t, err)
+	assert.Nil(t, key)
+}
+
+func TestKeyProviderDatabase_RolloverKeys(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	provider := &KeyProviderDatabase{}
+	err := provider.Init(KeyProviderOpts{
+		DB:  db,
+		Kek: generateTestKEK(t),
+	})
+	require.NoError(t, err)
+
+	// Without a rollover in progress there's neither a next key nor retired keys
+	next, err := provider.LoadNextKey(t.Context())
+	require.NoError(t, err)
+	assert.Nil(t, next)
+	retired, err := provider.LoadRetiredKeys(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, retired)
+
+	nextKey, err := GenerateKey("RS256", "")
+	require.NoError(t, err)
+	err = provider.SaveNextKey(t.Context(), nextKey)
+	require.NoError(t, err)
+
+	next, err = provider.LoadNextKey(t.Context())
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	expectedBytes, err := EncodeJWKBytes(nextKey)
+	require.NoError(t, err)
+	loadedBytes, err := EncodeJWKBytes(next)
+	require.NoError(t, err)
+	assert.Equal(t, expectedBytes, loadedBytes)
+
+	retiredKey, err := GenerateKey("ES256", "")
+	require.NoError(t, err)
+	err = provider.SaveRetiredKeys(t.Context(), []jwk.Key{retiredKey})
+	require.NoError(t, err)
+
+	retired, err = provider.LoadRetiredKeys(t.Context())
+	require.NoError(t, err)
+	require.Len(t, retired, 1)
+	retiredKeyID, _ := retiredKey.KeyID()
+	loadedKeyID, _ := retired[0].KeyID()
+	assert.Equal(t, retiredKeyID, loadedKeyID)
+
+	// Deleting the next key leaves the retired keys in place, and deleting it again is a no-op
+	require.NoError(t, provider.DeleteNextKey(t.Context()))
+	require.NoError(t, provider.DeleteNextKey(t.Context()))
+	next, err = provider.LoadNextKey(t.Context())
+	require.NoError(t, err)
+	assert.Nil(t, next)
+	retired, err = provider.LoadRetiredKeys(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, retired, 1)
+}
+
 func generateTestKEK(t *testing.T) []byte {
 	t.Helper()
 
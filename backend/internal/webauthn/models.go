@@ -27,6 +27,8 @@ type ReauthenticationToken struct {
 	model.Base
 	Token     string
 	ExpiresAt datatype.DateTime
+	// AuthenticationContextClass is the authentication context class the user re-verified themselves with
+	AuthenticationContextClass string
 
 	UserID string
 	User   model.User
@@ -17,9 +17,10 @@ import (
 )
 
 type TokenService interface {
-	GenerateAccessToken(user model.User, authenticationMethod string, sessionDuration time.Duration) (string, error)
+	GenerateAccessTokenWithContextClass(user model.User, authenticationMethod string, authenticationContextClass string, sessionDuration time.Duration) (string, error)
 	VerifyAccessToken(tokenString string) (jwt.Token, error)
 	GetAuthenticationMethod(token jwt.Token) (string, error)
+	GetAuthenticationContextClass(token jwt.Token) (string, error)
 }
 
 type AuditLogger interface {
@@ -94,7 +95,7 @@ func (m *Module) RegisterRoutes(apiGroup *gin.RouterGroup, userAuth, browserAuth
 }
 
 // ConsumeReauthenticationToken implements the OIDC module's ReauthenticationTokenConsumer interface
-func (m *Module) ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, error) {
+func (m *Module) ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, string, error) {
 	return m.service.ConsumeReauthenticationToken(ctx, tx, token, userID)
 }
 
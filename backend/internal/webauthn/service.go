@@ -15,6 +15,7 @@ import (
 
 	"github.com/pocket-id/pocket-id/backend/internal/appconfig"
 	"github.com/pocket-id/pocket-id/backend/internal/apperror"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
@@ -277,7 +278,7 @@ func (s *Service) VerifyLogin(ctx context.Context, dbConfig *appconfig.AppConfig
 	}
 
 	var user *model.User
-	_, err := s.webAuthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (gowebauthn.User, error) {
+	credential, err := s.webAuthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (gowebauthn.User, error) {
 		innerErr := tx.
 			WithContext(ctx).
 			Preload("Credentials").
@@ -304,7 +305,7 @@ func (s *Service) VerifyLogin(ctx context.Context, dbConfig *appconfig.AppConfig
 		return model.User{}, "", apperror.UserDisabled()
 	}
 
-	token, err := s.signer.GenerateAccessToken(*user, authenticationMethodPhishingResistant, dbConfig.SessionDuration.AsDurationMinutes())
+	token, err := s.signer.GenerateAccessTokenWithContextClass(*user, authenticationMethodPhishingResistant, authenticationContextClass(credential), dbConfig.SessionDuration.AsDurationMinutes())
 	if err != nil {
 		return model.User{}, "", err
 	}
@@ -448,6 +449,19 @@ func validateCredentialPolicy(dbConfig *appconfig.AppConfigModel, credential *go
 	return nil
 }
 
+// authenticationContextClass returns the authentication context class achieved with the passkey, from the flags of the assertion
+// Only passkeys that verified the user and can't be backed up to other devices count as device-bound
+func authenticationContextClass(credential *gowebauthn.Credential) string {
+	switch {
+	case !credential.Flags.UserVerified:
+		return common.ACRPasskey
+	case credential.Flags.BackupEligible:
+		return common.ACRUserVerifiedPasskey
+	default:
+		return common.ACRDeviceBoundPasskey
+	}
+}
+
 func (s *Service) CreateReauthenticationTokenWithAccessToken(ctx context.Context, accessToken string) (string, error) {
 	tx := s.db.Begin()
 	defer func() {
@@ -471,6 +485,10 @@ func (s *Service) CreateReauthenticationTokenWithAccessToken(ctx context.Context
 	if authenticationMethod != authenticationMethodPhishingResistant {
 		return "", apperror.ReauthenticationRequired()
 	}
+	acr, err := s.signer.GetAuthenticationContextClass(token)
+	if err != nil {
+		return "", apperror.ReauthenticationRequiredWithCause(err)
+	}
 
 	// Check if token is issued less than a minute ago
 	tokenExpiration, ok := token.IssuedAt()
@@ -490,7 +508,7 @@ func (s *Service) CreateReauthenticationTokenWithAccessToken(ctx context.Context
 		return "", fmt.Errorf("failed to load user: %w", err)
 	}
 
-	reauthToken, err := s.createReauthenticationToken(ctx, tx, user.ID)
+	reauthToken, err := s.createReauthenticationToken(ctx, tx, user.ID, acr)
 	if err != nil {
 		return "", err
 	}
@@ -531,7 +549,7 @@ func (s *Service) CreateReauthenticationTokenWithWebauthn(ctx context.Context, s
 
 	// Validate the credential assertion
 	var user *model.User
-	_, err := s.webAuthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (gowebauthn.User, error) {
+	credential, err := s.webAuthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (gowebauthn.User, error) {
 		innerErr := tx.
 			WithContext(ctx).
 			Preload("Credentials").
@@ -555,7 +573,7 @@ func (s *Service) CreateReauthenticationTokenWithWebauthn(ctx context.Context, s
 	}
 
 	// Create reauthentication token
-	token, err := s.createReauthenticationToken(ctx, tx, user.ID)
+	token, err := s.createReauthenticationToken(ctx, tx, user.ID, authenticationContextClass(credential))
 	if err != nil {
 		return "", err
 	}
@@ -582,7 +600,8 @@ func classifyPasskeyError(err error, fallback func(error) *apperror.Error) *appe
 	return fallback(err)
 }
 
-func (s *Service) ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, error) {
+// ConsumeReauthenticationToken redeems a reauthentication token of the user, returning when and with which authentication context class they re-verified themselves
+func (s *Service) ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB, token string, userID string) (time.Time, string, error) {
 	hashedToken := utils.CreateSha256Hash(token)
 	var reauthToken ReauthenticationToken
 	result := tx.WithContext(ctx).
@@ -590,24 +609,25 @@ func (s *Service) ConsumeReauthenticationToken(ctx context.Context, tx *gorm.DB,
 		Delete(&reauthToken, "token = ? AND user_id = ? AND expires_at > ?", hashedToken, userID, datatype.DateTime(time.Now()))
 
 	if result.Error != nil {
-		return time.Time{}, result.Error
+		return time.Time{}, "", result.Error
 	}
 	if result.RowsAffected == 0 {
-		return time.Time{}, apperror.ReauthenticationRequired()
+		return time.Time{}, "", apperror.ReauthenticationRequired()
 	}
-	return reauthToken.CreatedAt.UTC(), nil
+	return reauthToken.CreatedAt.UTC(), reauthToken.AuthenticationContextClass, nil
 }
 
-func (s *Service) createReauthenticationToken(ctx context.Context, tx *gorm.DB, userID string) (string, error) {
+func (s *Service) createReauthenticationToken(ctx context.Context, tx *gorm.DB, userID string, acr string) (string, error) {
 	token, err := utils.GenerateRandomAlphanumericString(32)
 	if err != nil {
 		return "", err
 	}
 
 	reauthToken := ReauthenticationToken{
-		Token:     utils.CreateSha256Hash(token),
-		ExpiresAt: datatype.DateTime(time.Now().Add(3 * time.Minute)),
-		UserID:    userID,
+		Token:                      utils.CreateSha256Hash(token),
+		ExpiresAt:                  datatype.DateTime(time.Now().Add(3 * time.Minute)),
+		AuthenticationContextClass: acr,
+		UserID:                     userID,
 	}
 
 	err = tx.WithContext(ctx).Create(&reauthToken).Error
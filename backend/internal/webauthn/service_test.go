@@ -21,8 +21,8 @@ import (
 	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
 )
 
-// fakeSigner is an in-memory TokenService that mints opaque tokens carrying a subject,
-// an issued-at time and an optional authentication method, without any real signing
+// fakeSigner is an in-memory TokenService that mints opaque tokens carrying a subject, an issued-at
+// time and an optional authentication method and context class, without any real signing
 type fakeSigner struct {
 	tokens  map[string]jwt.Token
 	counter int
@@ -32,13 +32,16 @@ func newFakeSigner() *fakeSigner {
 	return &fakeSigner{tokens: map[string]jwt.Token{}}
 }
 
-func (s *fakeSigner) GenerateAccessToken(user model.User, authenticationMethod string, _ time.Duration) (string, error) {
+func (s *fakeSigner) GenerateAccessTokenWithContextClass(user model.User, authenticationMethod string, authenticationContextClass string, _ time.Duration) (string, error) {
 	builder := jwt.NewBuilder().
 		Subject(user.ID).
 		IssuedAt(time.Now())
 	if authenticationMethod != "" {
 		builder = builder.Claim(common.AuthenticationMethodsClaim, []string{authenticationMethod})
 	}
+	if authenticationContextClass != "" {
+		builder = builder.Claim(common.AuthenticationContextClassClaim, authenticationContextClass)
+	}
 	token, err := builder.Build()
 	if err != nil {
 		return "", err
@@ -72,6 +75,15 @@ func (s *fakeSigner) GetAuthenticationMethod(token jwt.Token) (string, error) {
 	return methods[0], nil
 }
 
+func (s *fakeSigner) GetAuthenticationContextClass(token jwt.Token) (string, error) {
+	if !token.Has(common.AuthenticationContextClassClaim) {
+		return "", nil
+	}
+	var acr string
+	err := token.Get(common.AuthenticationContextClassClaim, &acr)
+	return acr, err
+}
+
 func TestCreateReauthenticationTokenWithAccessToken(t *testing.T) {
 	setupService := func(t *testing.T) (*Service, *fakeSigner, model.User) {
 		t.Helper()
@@ -89,18 +101,23 @@ func TestCreateReauthenticationTokenWithAccessToken(t *testing.T) {
 
 	t.Run("accepts a fresh access token from WebAuthn login", func(t *testing.T) {
 		service, signer, user := setupService(t)
-		accessToken, err := signer.GenerateAccessToken(user, authenticationMethodPhishingResistant, time.Hour)
+		accessToken, err := signer.GenerateAccessTokenWithContextClass(user, authenticationMethodPhishingResistant, common.ACRDeviceBoundPasskey, time.Hour)
 		require.NoError(t, err)
 
 		reauthenticationToken, err := service.CreateReauthenticationTokenWithAccessToken(t.Context(), accessToken)
 
 		require.NoError(t, err)
 		assert.NotEmpty(t, reauthenticationToken)
+
+		// The reauthentication keeps the authentication context class of the sign in
+		_, acr, err := service.ConsumeReauthenticationToken(t.Context(), service.db, reauthenticationToken, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, common.ACRDeviceBoundPasskey, acr)
 	})
 
 	t.Run("rejects a fresh access token from one-time access login", func(t *testing.T) {
 		service, signer, user := setupService(t)
-		accessToken, err := signer.GenerateAccessToken(user, "otp", time.Hour)
+		accessToken, err := signer.GenerateAccessTokenWithContextClass(user, "otp", common.ACROneTimeCode, time.Hour)
 		require.NoError(t, err)
 
 		reauthenticationToken, err := service.CreateReauthenticationTokenWithAccessToken(t.Context(), accessToken)
@@ -112,7 +129,7 @@ func TestCreateReauthenticationTokenWithAccessToken(t *testing.T) {
 
 	t.Run("rejects a fresh access token without an authentication method", func(t *testing.T) {
 		service, signer, user := setupService(t)
-		accessToken, err := signer.GenerateAccessToken(user, "", time.Hour)
+		accessToken, err := signer.GenerateAccessTokenWithContextClass(user, "", "", time.Hour)
 		require.NoError(t, err)
 
 		reauthenticationToken, err := service.CreateReauthenticationTokenWithAccessToken(t.Context(), accessToken)
@@ -223,6 +240,23 @@ func TestSyncedPasskeyPolicy(t *testing.T) {
 	require.NoError(t, validateCredentialPolicy(&appconfig.AppConfigModel{WebauthnAllowSyncedPasskeys: "false"}, credential))
 }
 
+func TestPasskeyAuthenticationContextClass(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags    gowebauthn.CredentialFlags
+		expected string
+	}{
+		{name: "without user verification", flags: gowebauthn.CredentialFlags{BackupEligible: false}, expected: common.ACRPasskey},
+		{name: "synced passkey", flags: gowebauthn.CredentialFlags{UserVerified: true, BackupEligible: true}, expected: common.ACRUserVerifiedPasskey},
+		{name: "device-bound passkey", flags: gowebauthn.CredentialFlags{UserVerified: true}, expected: common.ACRDeviceBoundPasskey},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, authenticationContextClass(&gowebauthn.Credential{Flags: test.flags}))
+		})
+	}
+}
+
 func TestClassifyPasskeyErrorRecognizesMissingUserVerification(t *testing.T) {
 	rpIDHash := make([]byte, 32)
 	authenticatorData := protocol.AuthenticatorData{
@@ -339,7 +373,7 @@ func TestCeremoniesRejectSessionThatDoesNotExist(t *testing.T) {
 	})
 }
 
-func TestConsumeReauthenticationTokenReturnsTokenCreationTime(t *testing.T) {
+func TestConsumeReauthenticationTokenReturnsTokenCreationTimeAndContextClass(t *testing.T) {
 	db := testutils.NewDatabaseForTest(t)
 	service := &Service{db: db}
 
@@ -351,18 +385,20 @@ func TestConsumeReauthenticationTokenReturnsTokenCreationTime(t *testing.T) {
 		Base: model.Base{ID: userID},
 	}).Error)
 	require.NoError(t, db.Create(&ReauthenticationToken{
-		Token:     utils.CreateSha256Hash(token),
-		ExpiresAt: datatype.DateTime(time.Now().Add(time.Minute)),
-		UserID:    userID,
+		Token:                      utils.CreateSha256Hash(token),
+		ExpiresAt:                  datatype.DateTime(time.Now().Add(time.Minute)),
+		AuthenticationContextClass: common.ACRUserVerifiedPasskey,
+		UserID:                     userID,
 	}).Error)
 
 	var storedToken ReauthenticationToken
 	require.NoError(t, db.First(&storedToken, "user_id = ?", userID).Error)
 
 	tx := db.Begin()
-	reauthenticatedAt, err := service.ConsumeReauthenticationToken(t.Context(), tx, token, userID)
+	reauthenticatedAt, acr, err := service.ConsumeReauthenticationToken(t.Context(), tx, token, userID)
 	require.NoError(t, err)
 	require.NoError(t, tx.Commit().Error)
 
 	require.Equal(t, storedToken.CreatedAt.UTC(), reauthenticatedAt)
+	require.Equal(t, common.ACRUserVerifiedPasskey, acr)
 }
@@ -0,0 +1,2 @@
+ALTER TABLE oidc_clients ADD COLUMN backchannel_logout_uri TEXT;
+ALTER TABLE oidc_clients ADD COLUMN backchannel_logout_session_required BOOLEAN NOT NULL DEFAULT FALSE;
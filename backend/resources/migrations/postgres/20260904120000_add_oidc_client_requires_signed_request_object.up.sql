@@ -0,0 +1 @@
+ALTER TABLE oidc_clients ADD COLUMN requires_signed_request_object BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE oidc_clients DROP COLUMN authorization_signed_response_alg;
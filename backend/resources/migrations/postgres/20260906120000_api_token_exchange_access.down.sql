@@ -0,0 +1,8 @@
+DELETE FROM oidc_clients_allowed_apis WHERE subject_type = 'token_exchange';
+DELETE FROM oidc_clients_allowed_api_permissions WHERE subject_type = 'token_exchange';
+
+ALTER TABLE oidc_clients_allowed_apis DROP CONSTRAINT oidc_clients_allowed_apis_subject_type_check;
+ALTER TABLE oidc_clients_allowed_apis ADD CONSTRAINT oidc_clients_allowed_apis_subject_type_check CHECK (subject_type IN ('user', 'client'));
+
+ALTER TABLE oidc_clients_allowed_api_permissions DROP CONSTRAINT oidc_clients_allowed_api_permissions_subject_type_check;
+ALTER TABLE oidc_clients_allowed_api_permissions ADD CONSTRAINT oidc_clients_allowed_api_permissions_subject_type_check CHECK (subject_type IN ('user', 'client'));
@@ -0,0 +1,6 @@
+-- Clients can be allowed to exchange a user's token for a token of an API, with their own set of permissions
+ALTER TABLE oidc_clients_allowed_apis DROP CONSTRAINT oidc_clients_allowed_apis_subject_type_check;
+ALTER TABLE oidc_clients_allowed_apis ADD CONSTRAINT oidc_clients_allowed_apis_subject_type_check CHECK (subject_type IN ('user', 'client', 'token_exchange'));
+
+ALTER TABLE oidc_clients_allowed_api_permissions DROP CONSTRAINT oidc_clients_allowed_api_permissions_subject_type_check;
+ALTER TABLE oidc_clients_allowed_api_permissions ADD CONSTRAINT oidc_clients_allowed_api_permissions_subject_type_check CHECK (subject_type IN ('user', 'client', 'token_exchange'));
@@ -0,0 +1,3 @@
+DROP TABLE ciba_requests;
+ALTER TABLE oidc_clients DROP COLUMN backchannel_client_notification_endpoint;
+ALTER TABLE oidc_clients DROP COLUMN backchannel_token_delivery_mode;
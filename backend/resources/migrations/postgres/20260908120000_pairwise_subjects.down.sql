@@ -0,0 +1,2 @@
+ALTER TABLE oidc_clients DROP COLUMN sector_identifier_uri;
+ALTER TABLE oidc_clients DROP COLUMN subject_type;
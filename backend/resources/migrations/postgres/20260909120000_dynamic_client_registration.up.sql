@@ -0,0 +1,30 @@
+CREATE TABLE oidc_initial_access_tokens
+(
+    id            UUID        NOT NULL PRIMARY KEY,
+    created_at    TIMESTAMPTZ NOT NULL,
+    name          TEXT        NOT NULL,
+    description   TEXT,
+    token         TEXT        NOT NULL UNIQUE,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    last_used_at  TIMESTAMPTZ,
+    usage_limit   INTEGER     NOT NULL DEFAULT 0,
+    usage_count   INTEGER     NOT NULL DEFAULT 0,
+    created_by_id UUID REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE TABLE oidc_initial_access_tokens_user_groups
+(
+    initial_access_token_id UUID NOT NULL,
+    user_group_id           UUID NOT NULL,
+    PRIMARY KEY (initial_access_token_id, user_group_id),
+    FOREIGN KEY (initial_access_token_id) REFERENCES oidc_initial_access_tokens (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_group_id) REFERENCES user_groups (id) ON DELETE CASCADE
+);
+
+CREATE TABLE oidc_client_registrations
+(
+    client_id                 TEXT        NOT NULL PRIMARY KEY REFERENCES oidc_clients (id) ON DELETE CASCADE,
+    created_at                TIMESTAMPTZ NOT NULL,
+    registration_access_token TEXT        NOT NULL UNIQUE,
+    initial_access_token_id   UUID REFERENCES oidc_initial_access_tokens (id) ON DELETE SET NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE oidc_clients DROP COLUMN userinfo_encrypted_response_enc;
+ALTER TABLE oidc_clients DROP COLUMN userinfo_encrypted_response_alg;
+ALTER TABLE oidc_clients DROP COLUMN id_token_encrypted_response_enc;
+ALTER TABLE oidc_clients DROP COLUMN id_token_encrypted_response_alg;
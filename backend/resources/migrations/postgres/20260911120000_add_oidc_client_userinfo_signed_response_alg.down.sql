@@ -0,0 +1 @@
+ALTER TABLE oidc_clients DROP COLUMN userinfo_signed_response_alg;
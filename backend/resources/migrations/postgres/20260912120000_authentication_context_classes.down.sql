@@ -0,0 +1,3 @@
+ALTER TABLE reauthentication_tokens DROP COLUMN authentication_context_class;
+ALTER TABLE interaction_sessions DROP COLUMN reauthentication_context_class;
+ALTER TABLE oidc_clients DROP COLUMN minimum_acr;
@@ -0,0 +1,11 @@
+CREATE TABLE api_authorization_detail_types (
+    id UUID NOT NULL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    api_id UUID NOT NULL REFERENCES apis(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    UNIQUE (api_id, type)
+);
+
+CREATE INDEX idx_api_authorization_detail_types_api_id ON api_authorization_detail_types(api_id);
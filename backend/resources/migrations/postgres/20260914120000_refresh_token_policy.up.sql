@@ -0,0 +1,4 @@
+ALTER TABLE oidc_clients ADD COLUMN refresh_token_requires_offline_access BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE oidc_clients ADD COLUMN refresh_token_max_lifetime_minutes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE oidc_clients ADD COLUMN refresh_token_reuse_grace_seconds BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE oauth2_sessions ADD COLUMN rotated_at TIMESTAMPTZ;
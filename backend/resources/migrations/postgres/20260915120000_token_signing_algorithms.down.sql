@@ -0,0 +1,2 @@
+ALTER TABLE apis DROP COLUMN access_token_signing_alg;
+ALTER TABLE oidc_clients DROP COLUMN id_token_signed_response_alg;
@@ -0,0 +1,2 @@
+ALTER TABLE oidc_clients ADD COLUMN id_token_signed_response_alg TEXT NOT NULL DEFAULT '';
+ALTER TABLE apis ADD COLUMN access_token_signing_alg TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE apis DROP COLUMN introspection_jwt_required;
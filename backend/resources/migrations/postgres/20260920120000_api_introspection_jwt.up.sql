@@ -0,0 +1 @@
+ALTER TABLE apis ADD COLUMN introspection_jwt_required BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,10 @@
+ALTER TABLE user_authorized_oidc_clients ADD COLUMN scope JSONB NOT NULL DEFAULT '[]'::jsonb;
+UPDATE user_authorized_oidc_clients
+SET scope = COALESCE((
+    SELECT jsonb_agg(scope_consent ->> 'scope')
+    FROM jsonb_array_elements(scope_consents) AS scope_consent
+    WHERE (scope_consent ->> 'granted')::boolean
+), '[]'::jsonb);
+ALTER TABLE user_authorized_oidc_clients DROP COLUMN scope_consents;
+
+ALTER TABLE oidc_clients DROP COLUMN consent_lifetime_minutes;
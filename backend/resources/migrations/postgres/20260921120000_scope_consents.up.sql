@@ -0,0 +1,10 @@
+ALTER TABLE oidc_clients ADD COLUMN consent_lifetime_minutes BIGINT NOT NULL DEFAULT 0;
+
+-- Every scope consented to so far was granted when the client was last used
+ALTER TABLE user_authorized_oidc_clients ADD COLUMN scope_consents JSONB NOT NULL DEFAULT '[]'::jsonb;
+UPDATE user_authorized_oidc_clients
+SET scope_consents = COALESCE((
+    SELECT jsonb_agg(jsonb_build_object('scope', scope_value.value, 'granted', true, 'consentedAt', last_used_at))
+    FROM jsonb_array_elements_text(scope) AS scope_value(value)
+), '[]'::jsonb);
+ALTER TABLE user_authorized_oidc_clients DROP COLUMN scope;
@@ -0,0 +1 @@
+ALTER TABLE oidc_clients ADD COLUMN claim_policy JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1 @@
+ALTER TABLE apis DROP COLUMN resource_server_client_id;
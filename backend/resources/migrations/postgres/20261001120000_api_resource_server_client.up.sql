@@ -0,0 +1 @@
+ALTER TABLE apis ADD COLUMN resource_server_client_id TEXT REFERENCES oidc_clients(id) ON DELETE SET NULL;
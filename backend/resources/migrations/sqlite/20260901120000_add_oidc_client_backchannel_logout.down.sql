@@ -0,0 +1,2 @@
+ALTER TABLE oidc_clients DROP COLUMN backchannel_logout_uri;
+ALTER TABLE oidc_clients DROP COLUMN backchannel_logout_session_required;
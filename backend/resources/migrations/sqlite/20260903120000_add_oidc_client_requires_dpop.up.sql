@@ -0,0 +1 @@
+ALTER TABLE oidc_clients ADD COLUMN requires_dpop BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,28 @@
+PRAGMA foreign_keys=OFF;
+BEGIN;
+
+CREATE TABLE oidc_clients_allowed_apis_new (
+    oidc_client_id TEXT NOT NULL REFERENCES oidc_clients(id) ON DELETE CASCADE,
+    api_id TEXT NOT NULL REFERENCES apis(id) ON DELETE CASCADE,
+    subject_type TEXT NOT NULL CHECK (subject_type IN ('user', 'client')),
+    PRIMARY KEY (oidc_client_id, api_id, subject_type)
+);
+INSERT INTO oidc_clients_allowed_apis_new (oidc_client_id, api_id, subject_type)
+SELECT oidc_client_id, api_id, subject_type FROM oidc_clients_allowed_apis WHERE subject_type <> 'token_exchange';
+DROP TABLE oidc_clients_allowed_apis;
+ALTER TABLE oidc_clients_allowed_apis_new RENAME TO oidc_clients_allowed_apis;
+CREATE INDEX idx_oidc_clients_allowed_apis_api_id ON oidc_clients_allowed_apis(api_id);
+
+CREATE TABLE oidc_clients_allowed_api_permissions_new (
+    oidc_client_id TEXT NOT NULL REFERENCES oidc_clients(id) ON DELETE CASCADE,
+    api_permission_id TEXT NOT NULL REFERENCES api_permissions(id) ON DELETE CASCADE,
+    subject_type TEXT NOT NULL CHECK (subject_type IN ('user', 'client')),
+    PRIMARY KEY (oidc_client_id, api_permission_id, subject_type)
+);
+INSERT INTO oidc_clients_allowed_api_permissions_new (oidc_client_id, api_permission_id, subject_type)
+SELECT oidc_client_id, api_permission_id, subject_type FROM oidc_clients_allowed_api_permissions WHERE subject_type <> 'token_exchange';
+DROP TABLE oidc_clients_allowed_api_permissions;
+ALTER TABLE oidc_clients_allowed_api_permissions_new RENAME TO oidc_clients_allowed_api_permissions;
+
+COMMIT;
+PRAGMA foreign_keys=ON;
@@ -0,0 +1,27 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE oidc_clients ADD COLUMN backchannel_token_delivery_mode TEXT NOT NULL DEFAULT '';
+ALTER TABLE oidc_clients ADD COLUMN backchannel_client_notification_endpoint TEXT;
+
+CREATE TABLE ciba_requests (
+    id TEXT NOT NULL PRIMARY KEY,
+    created_at INTEGER NOT NULL,
+    auth_req_id_signature TEXT NOT NULL UNIQUE,
+    client_id TEXT NOT NULL REFERENCES oidc_clients(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT NOT NULL DEFAULT '[]',
+    resource TEXT NOT NULL DEFAULT '',
+    binding_message TEXT,
+    status TEXT NOT NULL DEFAULT 'pending',
+    polling_interval INTEGER NOT NULL,
+    last_polled_at INTEGER,
+    expires_at INTEGER NOT NULL,
+    request_data TEXT NOT NULL
+);
+
+CREATE INDEX idx_ciba_requests_user_id ON ciba_requests (user_id);
+CREATE INDEX idx_ciba_requests_expires_at ON ciba_requests (expires_at);
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -0,0 +1,3 @@
+DROP TABLE oidc_client_registrations;
+DROP TABLE oidc_initial_access_tokens_user_groups;
+DROP TABLE oidc_initial_access_tokens;
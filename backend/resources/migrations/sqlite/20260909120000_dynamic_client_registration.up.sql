@@ -0,0 +1,31 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+CREATE TABLE oidc_initial_access_tokens (
+    id TEXT NOT NULL PRIMARY KEY,
+    created_at INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    token TEXT NOT NULL UNIQUE,
+    expires_at INTEGER NOT NULL,
+    last_used_at INTEGER,
+    usage_limit INTEGER NOT NULL DEFAULT 0,
+    usage_count INTEGER NOT NULL DEFAULT 0,
+    created_by_id TEXT REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE oidc_initial_access_tokens_user_groups (
+    initial_access_token_id TEXT NOT NULL REFERENCES oidc_initial_access_tokens(id) ON DELETE CASCADE,
+    user_group_id TEXT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    PRIMARY KEY (initial_access_token_id, user_group_id)
+);
+
+CREATE TABLE oidc_client_registrations (
+    client_id TEXT NOT NULL PRIMARY KEY REFERENCES oidc_clients(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    registration_access_token TEXT NOT NULL UNIQUE,
+    initial_access_token_id TEXT REFERENCES oidc_initial_access_tokens(id) ON DELETE SET NULL
+);
+
+COMMIT;
+PRAGMA foreign_keys= ON;
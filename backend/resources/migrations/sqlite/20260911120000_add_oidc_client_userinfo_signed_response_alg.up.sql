@@ -0,0 +1 @@
+ALTER TABLE oidc_clients ADD COLUMN userinfo_signed_response_alg TEXT NOT NULL DEFAULT '';
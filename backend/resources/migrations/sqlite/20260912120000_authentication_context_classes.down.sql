@@ -0,0 +1,9 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE reauthentication_tokens DROP COLUMN authentication_context_class;
+ALTER TABLE interaction_sessions DROP COLUMN reauthentication_context_class;
+ALTER TABLE oidc_clients DROP COLUMN minimum_acr;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -0,0 +1,9 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE oidc_clients ADD COLUMN minimum_acr TEXT NOT NULL DEFAULT '';
+ALTER TABLE interaction_sessions ADD COLUMN reauthentication_context_class TEXT NOT NULL DEFAULT '';
+ALTER TABLE reauthentication_tokens ADD COLUMN authentication_context_class TEXT NOT NULL DEFAULT '';
+
+COMMIT;
+PRAGMA foreign_keys= ON;
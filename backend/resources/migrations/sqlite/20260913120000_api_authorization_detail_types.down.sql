@@ -0,0 +1,7 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+DROP TABLE IF EXISTS api_authorization_detail_types;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
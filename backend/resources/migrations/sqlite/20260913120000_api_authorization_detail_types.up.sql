@@ -0,0 +1,17 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+CREATE TABLE api_authorization_detail_types (
+    id TEXT NOT NULL PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    api_id TEXT NOT NULL REFERENCES apis(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    UNIQUE (api_id, type)
+);
+
+CREATE INDEX idx_api_authorization_detail_types_api_id ON api_authorization_detail_types(api_id);
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -0,0 +1,10 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE oauth2_sessions DROP COLUMN rotated_at;
+ALTER TABLE oidc_clients DROP COLUMN refresh_token_reuse_grace_seconds;
+ALTER TABLE oidc_clients DROP COLUMN refresh_token_max_lifetime_minutes;
+ALTER TABLE oidc_clients DROP COLUMN refresh_token_requires_offline_access;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -0,0 +1,10 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE oidc_clients ADD COLUMN refresh_token_requires_offline_access BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE oidc_clients ADD COLUMN refresh_token_max_lifetime_minutes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE oidc_clients ADD COLUMN refresh_token_reuse_grace_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE oauth2_sessions ADD COLUMN rotated_at INTEGER;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -0,0 +1,8 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE apis DROP COLUMN access_token_signing_alg;
+ALTER TABLE oidc_clients DROP COLUMN id_token_signed_response_alg;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -0,0 +1,7 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE apis DROP COLUMN introspection_jwt_required;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
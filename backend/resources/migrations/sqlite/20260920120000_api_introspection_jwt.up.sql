@@ -0,0 +1,7 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE apis ADD COLUMN introspection_jwt_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
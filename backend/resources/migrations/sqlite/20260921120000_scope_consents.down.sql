@@ -0,0 +1,16 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE user_authorized_oidc_clients ADD COLUMN scope BLOB NOT NULL DEFAULT X'5B5D';
+UPDATE user_authorized_oidc_clients
+SET scope = CAST(COALESCE((
+    SELECT json_group_array(json_extract(scope_consent.value, '$.scope'))
+    FROM json_each(CAST(user_authorized_oidc_clients.scope_consents AS TEXT)) AS scope_consent
+    WHERE json_extract(scope_consent.value, '$.granted')
+), '[]') AS BLOB);
+ALTER TABLE user_authorized_oidc_clients DROP COLUMN scope_consents;
+
+ALTER TABLE oidc_clients DROP COLUMN consent_lifetime_minutes;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
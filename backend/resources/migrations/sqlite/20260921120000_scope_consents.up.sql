@@ -0,0 +1,20 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE oidc_clients ADD COLUMN consent_lifetime_minutes INTEGER NOT NULL DEFAULT 0;
+
+-- Every scope consented to so far was granted when the client was last used
+ALTER TABLE user_authorized_oidc_clients ADD COLUMN scope_consents BLOB NOT NULL DEFAULT X'5B5D';
+UPDATE user_authorized_oidc_clients
+SET scope_consents = CAST(COALESCE((
+    SELECT json_group_array(json_object(
+        'scope', scope_value.value,
+        'granted', json('true'),
+        'consentedAt', strftime('%Y-%m-%dT%H:%M:%SZ', user_authorized_oidc_clients.last_used_at, 'unixepoch')
+    ))
+    FROM json_each(CAST(user_authorized_oidc_clients.scope AS TEXT)) AS scope_value
+), '[]') AS BLOB);
+ALTER TABLE user_authorized_oidc_clients DROP COLUMN scope;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
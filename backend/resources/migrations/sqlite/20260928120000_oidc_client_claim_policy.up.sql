@@ -0,0 +1,7 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE oidc_clients ADD COLUMN claim_policy BLOB NOT NULL DEFAULT X'7B7D';
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -0,0 +1,7 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE apis DROP COLUMN resource_server_client_id;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
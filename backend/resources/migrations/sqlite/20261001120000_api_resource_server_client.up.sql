@@ -0,0 +1,7 @@
+PRAGMA foreign_keys= OFF;
+BEGIN;
+
+ALTER TABLE apis ADD COLUMN resource_server_client_id TEXT REFERENCES oidc_clients(id) ON DELETE SET NULL;
+
+COMMIT;
+PRAGMA foreign_keys= ON;
@@ -1,5 +1,6 @@
 import type {
 	Api,
+	ApiAuthorizationDetailTypeInput,
 	ApiCimdAccessUpdate,
 	ApiClient,
 	ApiClientAccess,
@@ -48,6 +49,11 @@ export default class ApisService extends APIService {
 		return res.data as Api;
 	};
 
+	updateAuthorizationDetailTypes = async (id: string, types: ApiAuthorizationDetailTypeInput[]) => {
+		const res = await this.api.put(`/apis/${id}/authorization-detail-types`, { types });
+		return res.data as Api;
+	};
+
 	updateCimdAccess = async (id: string, access: ApiCimdAccessUpdate) => {
 		const res = await this.api.put(`/apis/${id}/cimd-access`, access);
 		return res.data as Api;
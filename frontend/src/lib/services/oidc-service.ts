@@ -1,7 +1,14 @@
+import type {
+	InitialAccessToken,
+	InitialAccessTokenCreate,
+	InitialAccessTokenResponse
+} from '$lib/types/initial-access-token.type';
 import type { ListRequestOptions, Paginated } from '$lib/types/list-request.type';
 import type {
 	AccessibleOidcClient,
 	AuthorizedOidcClient,
+	AuthorizedOidcClientScope,
+	BackchannelAuthenticationRequest,
 	CompleteInteractionResponse,
 	InteractionSession,
 	InteractionStep,
@@ -26,10 +33,14 @@ class OidcService extends APIService {
 		return data;
 	};
 
-	completeAuthorizeInteractionStep = async (id: string, step: InteractionStep) => {
+	completeAuthorizeInteractionStep = async (
+		id: string,
+		step: InteractionStep,
+		declinedScopes?: string[]
+	) => {
 		const { data } = await this.api.post<CompleteInteractionResponse>(
 			`/oidc/interactions/${id}/complete`,
-			{ step }
+			{ step, declinedScopes }
 		);
 		return data;
 	};
@@ -121,6 +132,19 @@ class OidcService extends APIService {
 		return response.data;
 	};
 
+	listBackchannelAuthenticationRequests = async () => {
+		const res = await this.api.get('/oidc/bc-authorize/requests');
+		return res.data as BackchannelAuthenticationRequest[];
+	};
+
+	approveBackchannelAuthenticationRequest = async (id: string) => {
+		await this.api.post(`/oidc/bc-authorize/requests/${id}/approve`);
+	};
+
+	denyBackchannelAuthenticationRequest = async (id: string) => {
+		await this.api.post(`/oidc/bc-authorize/requests/${id}/deny`);
+	};
+
 	getClientPreview = async (id: string, userId: string, scopes: string) => {
 		const response = await this.api.get(
 			`/oidc/clients/${encodeClientIdParam(id)}/preview/${userId}`,
@@ -145,12 +169,40 @@ class OidcService extends APIService {
 		await this.api.delete(`/oidc/users/me/authorized-clients/${encodeClientIdParam(clientId)}`);
 	};
 
+	listOwnAuthorizedClientScopes = async (clientId: string) => {
+		const res = await this.api.get(
+			`/oidc/users/me/authorized-clients/${encodeClientIdParam(clientId)}/scopes`
+		);
+		return res.data as AuthorizedOidcClientScope[];
+	};
+
+	revokeOwnAuthorizedClientScope = async (clientId: string, scope: string) => {
+		await this.api.delete(
+			`/oidc/users/me/authorized-clients/${encodeClientIdParam(clientId)}/scopes`,
+			{ params: { scope } }
+		);
+	};
+
 	getScimResourceProvider = async (clientId: string) => {
 		const res = await this.api.get(
 			`/oidc/clients/${encodeClientIdParam(clientId)}/scim-service-provider`
 		);
 		return res.data as ScimServiceProvider;
 	};
+
+	listInitialAccessTokens = async (options?: ListRequestOptions) => {
+		const res = await this.api.get('/oidc/initial-access-tokens', { params: options });
+		return res.data as Paginated<InitialAccessToken>;
+	};
+
+	createInitialAccessToken = async (data: InitialAccessTokenCreate) => {
+		const res = await this.api.post('/oidc/initial-access-tokens', data);
+		return res.data as InitialAccessTokenResponse;
+	};
+
+	deleteInitialAccessToken = async (id: string) => {
+		await this.api.delete(`/oidc/initial-access-tokens/${id}`);
+	};
 }
 
 export default OidcService;
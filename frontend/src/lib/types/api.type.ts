@@ -6,6 +6,14 @@ export type ApiPermission = {
 	allowedForCimdClients: boolean;
 };
 
+// A type of RFC 9396 authorization details the API accepts
+export type ApiAuthorizationDetailType = {
+	id: string;
+	type: string;
+	name: string;
+	description?: string;
+};
+
 export type Api = {
 	id: string;
 	name: string;
@@ -13,15 +21,28 @@ export type Api = {
 	createdAt: string;
 	permissions: ApiPermission[];
 	allowCimdClients: boolean;
+	// Algorithm of the access tokens for the API; empty uses the algorithm of the default signing key
+	accessTokenSigningAlg: string;
+	// Whether introspection responses for the API's tokens are always signed JWTs (RFC 9701)
+	introspectionJwtRequired: boolean;
+	// Client the API introspects the access tokens audienced to it with
+	resourceServerClientId: string | null;
+	authorizationDetailTypes: ApiAuthorizationDetailType[];
 };
 
 export type ApiCreate = {
 	name: string;
 	resource: string;
+	accessTokenSigningAlg: string;
+	introspectionJwtRequired: boolean;
+	resourceServerClientId: string;
 };
 
 export type ApiUpdate = {
 	name: string;
+	accessTokenSigningAlg: string;
+	introspectionJwtRequired: boolean;
+	resourceServerClientId: string;
 };
 
 export type ApiPermissionInput = {
@@ -30,11 +51,20 @@ export type ApiPermissionInput = {
 	description: string;
 };
 
+export type ApiAuthorizationDetailTypeInput = {
+	type: string;
+	name: string;
+	description: string;
+};
+
 export type ApiClientGrant = {
 	userDelegatedAccess: boolean;
 	clientAccess: boolean;
+	// The client may exchange a user's token for a token of this API (RFC 8693)
+	tokenExchangeAccess: boolean;
 	userDelegatedPermissionIds: string[];
 	clientPermissionIds: string[];
+	tokenExchangePermissionIds: string[];
 };
 
 export type ApiCimdAccessUpdate = {
@@ -0,0 +1,26 @@
+import type { UserGroup } from './user-group.type';
+
+export type InitialAccessToken = {
+	id: string;
+	name: string;
+	description?: string;
+	expiresAt: string;
+	lastUsedAt?: string;
+	usageLimit: number;
+	usageCount: number;
+	userGroups: UserGroup[];
+	createdAt: string;
+};
+
+export type InitialAccessTokenCreate = {
+	name: string;
+	description?: string;
+	expiresAt: Date;
+	usageLimit: number;
+	userGroupIds: string[];
+};
+
+export type InitialAccessTokenResponse = {
+	initialAccessToken: InitialAccessToken;
+	token: string;
+};
@@ -1,6 +1,6 @@
 import type { UserGroup } from './user-group.type';
 
-export type OidcClientType = 'standard' | 'cimd';
+export type OidcClientType = 'standard' | 'cimd' | 'dynamic';
 
 export type OidcClientMetaData = {
 	id: string;
@@ -35,11 +35,81 @@ export type OidcClientSecretCreated = OidcClientSecret & {
 	secret: string;
 };
 
+export type OidcClientTLSClientAuthMethod = 'tls_client_auth' | 'self_signed_tls_client_auth';
+
+// Mutual-TLS client authentication (RFC 8705): either a CA-issued certificate matched on one subject attribute, or pinned self-signed certificates
+export type OidcClientTLSClientAuth = {
+	method: OidcClientTLSClientAuthMethod;
+	caCertificates?: string;
+	subjectDn?: string;
+	sanDns?: string;
+	sanUri?: string;
+	sanIp?: string;
+	sanEmail?: string;
+	certificates?: string;
+};
+
 export type OidcClientCredentials = {
 	federatedIdentities: OidcClientFederatedIdentity[];
 	secrets: OidcClientSecret[];
+	tlsClientAuth?: OidcClientTLSClientAuth;
+	// Public keys the client signs request objects with, either inline or by URL
+	jwks?: string;
+	jwksUri?: string;
+	jwtBearerGrants?: OidcClientJWTBearerGrant[];
+};
+
+// Maps the JWTs of a trusted external issuer to the subject of the tokens issued with the JWT bearer grant (RFC 7523)
+export type OidcClientJWTBearerGrant = {
+	issuer: string;
+	// A trailing "*" matches every subject starting with the preceding text
+	subject: string;
+	audience?: string;
+	jwks?: string;
+	// The user the issued tokens act for, the client itself if empty
+	userId?: string;
+	replayProtection: boolean;
+};
+
+export type OidcClientClaimDestinations = {
+	idToken: boolean;
+	userInfo: boolean;
+	accessToken: boolean;
 };
 
+export type OidcClientClaimMapping = {
+	claim: string;
+	// What the client gets the claim as, empty to keep its name
+	name?: string;
+	destinations: OidcClientClaimDestinations;
+};
+
+export type OidcClientStaticClaim = {
+	key: string;
+	// Parsed as JSON if possible, released as text otherwise
+	value: string;
+	destinations: OidcClientClaimDestinations;
+};
+
+// Limits, renames and routes the user claims released to a client, and adds static claims
+export type OidcClientClaimPolicy = {
+	// Whether the custom claim keys are the only custom claims released or are withheld; empty releases all custom claims
+	customClaimsFilter: '' | 'allow' | 'deny';
+	customClaimKeys?: string[];
+	mappings?: OidcClientClaimMapping[];
+	staticClaims?: OidcClientStaticClaim[];
+};
+
+export type OidcClientBackchannelTokenDeliveryMode = '' | 'poll' | 'ping';
+
+export type OidcClientSubjectType = 'public' | 'pairwise';
+
+export type AuthenticationContextClass =
+	| 'urn:pocket-id:acr:one-time-code'
+	| 'urn:pocket-id:acr:passkey'
+	| 'urn:pocket-id:acr:passkey:user-verified'
+	| 'urn:pocket-id:acr:passkey:device-bound';
+
 export type OidcDiscoveryConfiguration = {
 	issuer: string;
 	authorization_endpoint: string;
@@ -56,6 +126,8 @@ export type OidcClient = OidcClientMetaData & {
 	pkceEnabled: boolean;
 	requiresReauthentication: boolean;
 	requiresPushedAuthorizationRequests: boolean;
+	requiresDpop: boolean;
+	requiresSignedRequestObject: boolean;
 	skipConsent: boolean;
 	credentials?: OidcClientCredentials;
 	launchURL?: string;
@@ -63,11 +135,48 @@ export type OidcClient = OidcClientMetaData & {
 	pkceSupported: boolean;
 	accessTokenDurationMinutes: number;
 	refreshTokenDurationMinutes: number;
+	// Whether refresh tokens are only issued to authorizations that were granted offline_access
+	refreshTokenRequiresOfflineAccess: boolean;
+	// Lifetime of a refresh token family from its first refresh token, regardless of rotations; 0 for none
+	refreshTokenMaxLifetimeMinutes: number;
+	// How long a rotated refresh token is still accepted, for clients refreshing concurrently
+	refreshTokenReuseGraceSeconds: number;
+	// How long the user's consent lasts before they are asked again; 0 if it never expires
+	consentLifetimeMinutes: number;
+	claimPolicy: OidcClientClaimPolicy;
+	backchannelLogoutURI?: string;
+	backchannelLogoutSessionRequired: boolean;
+	frontchannelLogoutURI?: string;
+	frontchannelLogoutSessionRequired: boolean;
+	// Algorithm of JWT authorization responses (JARM); empty uses the algorithm of the signing key
+	authorizationSignedResponseAlg: string;
+	// Algorithm of userinfo responses returned as a JWT; empty returns JSON unless the client asks for a JWT
+	userinfoSignedResponseAlg: string;
+	// Algorithm of the ID tokens; empty uses the algorithm of the default signing key
+	idTokenSignedResponseAlg: string;
+	// How the client learns the outcome of backchannel authentication requests (CIBA); empty disables it
+	backchannelTokenDeliveryMode: OidcClientBackchannelTokenDeliveryMode;
+	backchannelClientNotificationEndpoint?: string;
+	// Whether the client gets the ID of the user as subject, or one derived for its sector
+	subjectType: OidcClientSubjectType;
+	sectorIdentifierUri?: string;
+	// Algorithms the ID tokens and userinfo responses are encrypted with; empty keeps them signed only
+	idTokenEncryptedResponseAlg: string;
+	idTokenEncryptedResponseEnc: string;
+	userinfoEncryptedResponseAlg: string;
+	userinfoEncryptedResponseEnc: string;
+	// Weakest authentication context class users must sign in with; empty accepts any sign in
+	minimumAcr: AuthenticationContextClass | '';
 };
 
 export type OidcClientTokenLifetimes = Pick<
 	OidcClient,
-	'accessTokenDurationMinutes' | 'refreshTokenDurationMinutes'
+	| 'accessTokenDurationMinutes'
+	| 'refreshTokenDurationMinutes'
+	| 'refreshTokenRequiresOfflineAccess'
+	| 'refreshTokenMaxLifetimeMinutes'
+	| 'refreshTokenReuseGraceSeconds'
+	| 'consentLifetimeMinutes'
 >;
 
 export type OidcClientWithAllowedUserGroups = OidcClient & {
@@ -100,21 +209,48 @@ export type OidcClientCreateWithLogo = OidcClientCreate & {
 export type OidcDeviceCodeInfo = {
 	scope: string[];
 	scopeInfo: InteractionScopeInfo[];
+	// Names of the user claims the client gets, empty until the user is signed in
+	claims: string[];
 	authorizationRequired: boolean;
 	reauthenticationRequired: boolean;
 	client: OidcClientMetaData;
 };
 
+// A backchannel authentication request a client started for the signed-in user, waiting for their decision
+export type BackchannelAuthenticationRequest = {
+	id: string;
+	client: OidcClientMetaData;
+	scope: string[];
+	scopeInfo: InteractionScopeInfo[];
+	bindingMessage?: string;
+	createdAt: string;
+	expiresAt: string;
+};
+
 export type AccessibleOidcClient = OidcClientMetaData & {
 	lastUsedAt: Date | null;
 };
 
 export type AuthorizedOidcClient = {
-	scope: string;
 	client: OidcClientMetaData;
 	lastUsedAt: Date;
 };
 
+// The user's decision on a scope, API or claim they were asked to consent to for a client
+export type AuthorizedOidcClientScope = {
+	key: string;
+	type: 'scope' | 'permission' | 'api' | 'claim';
+	name: string;
+	description?: string;
+	// The API the permission is for
+	audience?: string;
+	granted: boolean;
+	// Whether it can be revoked without revoking the whole authorization
+	revocable: boolean;
+	consentedAt: string;
+	expiresAt?: string;
+};
+
 export type InteractionStep = 'authenticate' | 'select_account' | 'reauthenticate' | 'consent';
 
 export type InteractionScopeInfo = {
@@ -123,10 +259,23 @@ export type InteractionScopeInfo = {
 	description?: string;
 };
 
+// An RFC 9396 authorization detail the client requested, with the name the API registered for its type
+export type InteractionAuthorizationDetail = {
+	type: string;
+	name: string;
+	description?: string;
+	detail: Record<string, unknown>;
+};
+
 export type InteractionSession = {
 	id: string;
 	scopes: string[];
 	scopeInfo: InteractionScopeInfo[];
+	authorizationDetails: InteractionAuthorizationDetail[];
+	// Names of the user claims the client gets, empty until the user is signed in
+	claims: string[];
+	// Scopes the user may untick on the consent screen, the others are required for the authorization
+	optionalScopes: string[];
 	client: OidcClientMetaData;
 	currentStep?: InteractionStep;
 	requiredSteps: InteractionStep[];
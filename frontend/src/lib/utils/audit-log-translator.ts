@@ -9,8 +9,13 @@ export const eventTypes: Record<string, string> = {
 	ACCOUNT_CREATED: m.account_created(),
 	DEVICE_CODE_AUTHORIZATION: m.device_code_authorization(),
 	NEW_DEVICE_CODE_AUTHORIZATION: m.new_device_code_authorization(),
+	BACKCHANNEL_AUTHORIZATION: m.backchannel_authorization(),
+	NEW_BACKCHANNEL_AUTHORIZATION: m.new_backchannel_authorization(),
 	PASSKEY_ADDED: m.passkey_added(),
-	PASSKEY_REMOVED: m.passkey_removed()
+	PASSKEY_REMOVED: m.passkey_removed(),
+	TOKEN_REVOKED: m.token_revoked(),
+	TOKEN_EXCHANGED: m.token_exchanged(),
+	REFRESH_TOKEN_REUSED: m.refresh_token_reused()
 };
 
 /**
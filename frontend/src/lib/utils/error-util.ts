@@ -20,7 +20,8 @@ const codeMessages: Record<string, () => string> = {
 	webauthn_authentication_failed: () => m.passkey_verification_failed(),
 	passkey_user_verification_required: () => m.passkey_user_verification_required(),
 	synced_passkey_not_allowed: () => m.synced_passkeys_not_allowed(),
-	device_login_expired: () => m.device_login_request_expired()
+	device_login_expired: () => m.device_login_request_expired(),
+	insufficient_user_authentication: () => m.stronger_passkey_required()
 };
 
 function getApiErrorResponse(e: unknown): ApiErrorResponse | undefined {
@@ -36,6 +37,10 @@ function getApiErrorResponse(e: unknown): ApiErrorResponse | undefined {
 	return data as ApiErrorResponse;
 }
 
+export function getApiErrorCode(e: unknown): string | undefined {
+	return getApiErrorResponse(e)?.code;
+}
+
 export function getAxiosErrorMessage(
 	e: unknown,
 	defaultMessage: string = m.an_unknown_error_occurred()
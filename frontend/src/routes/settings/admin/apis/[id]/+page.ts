@@ -1,7 +1,13 @@
 import ApisService from '$lib/services/apis-service';
+import type { OidcDiscoveryConfiguration } from '$lib/types/oidc.type';
 import type { PageLoad } from './$types';
 
-export const load: PageLoad = async ({ params }) => {
-	const api = await new ApisService().get(params.id);
-	return { api };
+export const load: PageLoad = async ({ fetch, params }) => {
+	const apiPromise = new ApisService().get(params.id);
+	const oidcConfigurationPromise = fetch('/.well-known/openid-configuration').then(
+		(response) => response.json() as Promise<OidcDiscoveryConfiguration>
+	);
+
+	const [api, oidcConfiguration] = await Promise.all([apiPromise, oidcConfigurationPromise]);
+	return { api, oidcConfiguration };
 };
@@ -33,15 +33,18 @@ export const load: PageLoad = async () => {
 		}
 	};
 
-	const [clients, authorizedClientsWithoutLaunchURL] = await Promise.all([
-		oidcService.listOwnAccessibleClients(appRequestOptions),
-		oidcService.listOwnAuthorizedClients(authorizedClientRequestOptions)
-	]);
+	const [clients, authorizedClientsWithoutLaunchURL, backchannelAuthenticationRequests] =
+		await Promise.all([
+			oidcService.listOwnAccessibleClients(appRequestOptions),
+			oidcService.listOwnAuthorizedClients(authorizedClientRequestOptions),
+			oidcService.listBackchannelAuthenticationRequests()
+		]);
 
 	return {
 		clients,
 		appRequestOptions,
 		authorizedClientsWithoutLaunchURL,
-		authorizedClientRequestOptions
+		authorizedClientRequestOptions,
+		backchannelAuthenticationRequests
 	};
 };
@@ -109,8 +109,8 @@ test('Authorize client requesting offline_access scope', async ({ page }) => {
 	await page.goto(`/authorize?${urlParams.toString()}`);
 
 	// offline_access is a valid OIDC scope: the flow must reach the consent screen rather than
-	// being rejected with invalid_scope (offline_access itself has no displayable scope item)
-	await expectScopes(page, ['Email', 'Profile']);
+	// being rejected with invalid_scope, and the user is told the client keeps access
+	await expectScopes(page, ['Email', 'Profile', 'Offline access']);
 
 	const callbackUrl = await expectCallbackRedirect(page, oidcClient.callbackUrl, () =>
 		page.getByRole('button', { name: 'Sign in' }).click()